@@ -0,0 +1,302 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+//go:build !vnc_minimal
+
+package vnc
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Defaults of AdaptiveEncodingConfig.
+const (
+	defaultAdaptiveMinThroughput = 256 << 10
+	defaultAdaptiveMaxRoundTrip  = 200 * time.Millisecond
+	defaultAdaptiveInterval      = 2 * time.Second
+
+	// adaptiveMinSample is the fewest bytes received in an interval from
+	// which throughput is estimated; smaller updates are dominated by
+	// latency rather than bandwidth.
+	adaptiveMinSample = 32 << 10
+)
+
+// EncodingProfile identifies the encodings selected by an
+// AdaptiveEncodings controller.
+type EncodingProfile int
+
+const (
+	// ProfileHighQuality is used while the network keeps up.
+	ProfileHighQuality EncodingProfile = iota
+
+	// ProfileLowBandwidth is used while throughput is low or round trips
+	// are slow.
+	ProfileLowBandwidth
+)
+
+// String returns the name of the profile.
+func (p EncodingProfile) String() string {
+	switch p {
+	case ProfileHighQuality:
+		return "high-quality"
+	case ProfileLowBandwidth:
+		return "low-bandwidth"
+	default:
+		return "unknown"
+	}
+}
+
+// AdaptiveEncodingConfig configures StartAdaptiveEncodings. Zero fields use
+// the defaults.
+type AdaptiveEncodingConfig struct {
+	// HighQuality and LowBandwidth are the encodings sent with SetEncodings
+	// for each profile. By default both are the current encodings, with JPEG
+	// quality 8 and compression level 2 for HighQuality, and JPEG quality 2
+	// and compression level 9 for LowBandwidth.
+	HighQuality  []Encoding
+	LowBandwidth []Encoding
+
+	// MinThroughput is the rate, in bytes per second, at which updates must
+	// arrive to keep the high-quality profile. The default is 256 KiB/s.
+	MinThroughput float64
+
+	// MaxRoundTrip is the longest round-trip time that keeps the
+	// high-quality profile. The default is 200ms.
+	MaxRoundTrip time.Duration
+
+	// Interval is how often conditions are measured. The default is 2s.
+	Interval time.Duration
+
+	// OnSwitch, if set, is called from the controller goroutine after each
+	// change of profile.
+	OnSwitch func(EncodingProfile)
+}
+
+// AdaptiveEncodings switches the encodings of a connection between a
+// high-quality and a low-bandwidth profile as network conditions change. It
+// is created by StartAdaptiveEncodings.
+type AdaptiveEncodings struct {
+	c      *ClientConn
+	cfg    AdaptiveEncodingConfig
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	mu         sync.Mutex
+	profile    EncodingProfile
+	throughput float64
+	roundTrip  time.Duration
+}
+
+// StartAdaptiveEncodings sends the high-quality encodings and starts a
+// controller that measures the connection every interval and re-sends
+// SetEncodings when it should switch profiles:
+//
+//	adaptive, err := client.StartAdaptiveEncodings(vnc.AdaptiveEncodingConfig{})
+//	if err != nil {
+//		return err
+//	}
+//	defer adaptive.Stop()
+//
+// Throughput is the rate at which rectangle data arrived while updates were
+// being decoded, measured from EncodingStats over intervals that received
+// enough data; round trips are measured with Ping. The controller switches to
+// the low-bandwidth profile when either crosses its threshold, and back once
+// round trips take less than half of MaxRoundTrip and throughput, where
+// measured, is at least twice MinThroughput, so it does not flap near the
+// thresholds.
+//
+// The controller owns the encodings while it runs: a profile switch replaces
+// encodings set with SetEncodings or SetCompressionLevel. In manual pump
+// mode another goroutine must call ProcessNextMessage, as for Ping. Closing
+// the connection stops the controller.
+func (c *ClientConn) StartAdaptiveEncodings(cfg AdaptiveEncodingConfig) (*AdaptiveEncodings, error) {
+	if cfg.MinThroughput <= 0 {
+		cfg.MinThroughput = defaultAdaptiveMinThroughput
+	}
+	if cfg.MaxRoundTrip <= 0 {
+		cfg.MaxRoundTrip = defaultAdaptiveMaxRoundTrip
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = defaultAdaptiveInterval
+	}
+	base := withoutQualityEncodings(c.GetEncodings())
+	if len(cfg.HighQuality) == 0 {
+		cfg.HighQuality = withEncodingQuality(base, 8, 2)
+	}
+	if len(cfg.LowBandwidth) == 0 {
+		cfg.LowBandwidth = withEncodingQuality(base, 2, 9)
+	}
+
+	if err := c.SetEncodings(cfg.HighQuality); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(c.ctx)
+	a := &AdaptiveEncodings{
+		c:      c,
+		cfg:    cfg,
+		ctx:    ctx,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	if !c.goTracked(a.run) {
+		cancel()
+		return nil, c.enrichError(networkError("StartAdaptiveEncodings", "connection is closed", nil))
+	}
+	return a, nil
+}
+
+// Profile returns the current profile.
+func (a *AdaptiveEncodings) Profile() EncodingProfile {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.profile
+}
+
+// Measurements returns the throughput, in bytes per second, and round-trip
+// time last measured, or zero for either not yet measured.
+func (a *AdaptiveEncodings) Measurements() (throughput float64, roundTrip time.Duration) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.throughput, a.roundTrip
+}
+
+// Stop stops the controller and waits for it to exit, leaving the encodings
+// of the current profile in place.
+func (a *AdaptiveEncodings) Stop() {
+	a.cancel()
+	<-a.done
+}
+
+// run measures the connection every interval until the controller stops.
+func (a *AdaptiveEncodings) run() {
+	defer close(a.done)
+
+	ticker := time.NewTicker(a.cfg.Interval)
+	defer ticker.Stop()
+
+	bytes, decodeTime := a.received()
+	for {
+		select {
+		case <-ticker.C:
+		case <-a.ctx.Done():
+			return
+		}
+
+		roundTrip, ok := a.ping()
+		if !ok {
+			return
+		}
+		totalBytes, totalTime := a.received()
+		var throughput float64
+		if totalBytes-bytes >= adaptiveMinSample && totalTime > decodeTime {
+			throughput = float64(totalBytes-bytes) / (totalTime - decodeTime).Seconds()
+		}
+		bytes, decodeTime = totalBytes, totalTime
+
+		a.mu.Lock()
+		current := a.profile
+		if throughput > 0 {
+			a.throughput = throughput
+		}
+		a.roundTrip = roundTrip
+		a.mu.Unlock()
+
+		next := a.cfg.choose(current, throughput, roundTrip)
+		if next == current {
+			continue
+		}
+		encodings := a.cfg.HighQuality
+		if next == ProfileLowBandwidth {
+			encodings = a.cfg.LowBandwidth
+		}
+		if err := a.c.SetEncodings(encodings); err != nil {
+			a.c.logger.Warn("Failed to switch encoding profile",
+				Field{Key: "profile", Value: next.String()},
+				Field{Key: "error", Value: err})
+			continue
+		}
+		a.c.logger.Info("Switched encoding profile",
+			Field{Key: "profile", Value: next.String()},
+			Field{Key: "throughput", Value: throughput},
+			Field{Key: "round_trip", Value: roundTrip})
+
+		a.mu.Lock()
+		a.profile = next
+		a.mu.Unlock()
+		if a.cfg.OnSwitch != nil {
+			a.cfg.OnSwitch(next)
+		}
+	}
+}
+
+// ping measures a round trip, counting one that takes longer than the
+// interval as taking the interval. It reports false once the controller has
+// stopped.
+func (a *AdaptiveEncodings) ping() (time.Duration, bool) {
+	ctx, cancel := context.WithTimeout(a.ctx, a.cfg.Interval)
+	defer cancel()
+
+	roundTrip, err := a.c.Ping(ctx)
+	switch {
+	case a.ctx.Err() != nil:
+		return 0, false
+	case errors.Is(err, context.DeadlineExceeded):
+		return a.cfg.Interval, true
+	case err != nil:
+		a.c.logger.Warn("Failed to measure round trip", Field{Key: "error", Value: err})
+		return 0, true
+	}
+	return roundTrip, true
+}
+
+// received returns the rectangle bytes received so far and the time spent
+// receiving them.
+func (a *AdaptiveEncodings) received() (uint64, time.Duration) {
+	var bytes uint64
+	var decodeTime time.Duration
+	for _, s := range a.c.EncodingStats() {
+		bytes += s.WireBytes
+		decodeTime += s.DecodeTime
+	}
+	return bytes, decodeTime
+}
+
+// choose returns the profile for the measured throughput, zero if not
+// measured, and round-trip time, zero if not measured.
+func (cfg *AdaptiveEncodingConfig) choose(current EncodingProfile, throughput float64, roundTrip time.Duration) EncodingProfile {
+	slow := roundTrip > cfg.MaxRoundTrip || (throughput > 0 && throughput < cfg.MinThroughput)
+	if slow {
+		return ProfileLowBandwidth
+	}
+	fast := roundTrip < cfg.MaxRoundTrip/2 && (throughput == 0 || throughput >= 2*cfg.MinThroughput)
+	if current == ProfileLowBandwidth && !fast {
+		return ProfileLowBandwidth
+	}
+	return ProfileHighQuality
+}
+
+// withoutQualityEncodings returns a copy of encodings without JPEG quality
+// and compression level pseudo-encodings.
+func withoutQualityEncodings(encodings []Encoding) []Encoding {
+	return slices.DeleteFunc(slices.Clone(encodings), func(enc Encoding) bool {
+		switch enc.(type) {
+		case *JPEGQualityPseudoEncoding, *CompressionLevelPseudoEncoding:
+			return true
+		}
+		return false
+	})
+}
+
+// withEncodingQuality returns a copy of encodings followed by the JPEG
+// quality and compression level pseudo-encodings for quality and level.
+func withEncodingQuality(encodings []Encoding, quality, level uint8) []Encoding {
+	return append(slices.Clone(encodings),
+		&JPEGQualityPseudoEncoding{Level: quality},
+		&CompressionLevelPseudoEncoding{Level: level})
+}
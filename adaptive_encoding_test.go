@@ -0,0 +1,85 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+//go:build !vnc_minimal
+
+package vnc
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/tenthirtyam/go-vnc/rfb"
+)
+
+func TestAdaptiveEncodings_Choose(t *testing.T) {
+	cfg := AdaptiveEncodingConfig{MinThroughput: 1000, MaxRoundTrip: 100 * time.Millisecond}
+	tests := []struct {
+		name       string
+		current    EncodingProfile
+		throughput float64
+		roundTrip  time.Duration
+		want       EncodingProfile
+	}{
+		{"fast", ProfileHighQuality, 5000, 10 * time.Millisecond, ProfileHighQuality},
+		{"slow round trip", ProfileHighQuality, 5000, 150 * time.Millisecond, ProfileLowBandwidth},
+		{"low throughput", ProfileHighQuality, 500, 10 * time.Millisecond, ProfileLowBandwidth},
+		{"idle", ProfileHighQuality, 0, 10 * time.Millisecond, ProfileHighQuality},
+		{"recovering round trip", ProfileLowBandwidth, 5000, 80 * time.Millisecond, ProfileLowBandwidth},
+		{"recovering throughput", ProfileLowBandwidth, 1500, 10 * time.Millisecond, ProfileLowBandwidth},
+		{"recovered", ProfileLowBandwidth, 2000, 40 * time.Millisecond, ProfileHighQuality},
+		{"recovered idle", ProfileLowBandwidth, 0, 40 * time.Millisecond, ProfileHighQuality},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := cfg.choose(tt.current, tt.throughput, tt.roundTrip); got != tt.want {
+				t.Errorf("choose(%v, %v, %v) = %v, want %v", tt.current, tt.throughput, tt.roundTrip, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAdaptiveEncodings_Switch(t *testing.T) {
+	_, conn := newUpdateServer(t, 8, 8,
+		WithInitialEncodings(&TightEncoding{}, &RawEncoding{}, &CompressionLevelPseudoEncoding{Level: 6}))
+
+	switched := make(chan EncodingProfile, 4)
+	adaptive, err := conn.StartAdaptiveEncodings(AdaptiveEncodingConfig{
+		// Every round trip is slower than a nanosecond.
+		MaxRoundTrip: time.Nanosecond,
+		Interval:     10 * time.Millisecond,
+		OnSwitch:     func(p EncodingProfile) { switched <- p },
+	})
+	if err != nil {
+		t.Fatalf("StartAdaptiveEncodings() error = %v", err)
+	}
+	defer adaptive.Stop()
+
+	want := []int32{rfb.EncodingTight, rfb.EncodingRaw,
+		rfb.PseudoEncodingJPEGQualityLevel0 + 8, rfb.PseudoEncodingCompressionLevel0 + 2}
+	if got := encodingTypes(conn.GetEncodings()); !slices.Equal(got, want) {
+		t.Errorf("encodings after start = %v, want the high-quality profile %v", got, want)
+	}
+
+	select {
+	case p := <-switched:
+		if p != ProfileLowBandwidth {
+			t.Fatalf("switched to %v, want %v", p, ProfileLowBandwidth)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("controller did not switch profiles")
+	}
+
+	want = []int32{rfb.EncodingTight, rfb.EncodingRaw,
+		rfb.PseudoEncodingJPEGQualityLevel0 + 2, rfb.PseudoEncodingCompressionLevel0 + 9}
+	if got := encodingTypes(conn.GetEncodings()); !slices.Equal(got, want) {
+		t.Errorf("encodings after switch = %v, want the low-bandwidth profile %v", got, want)
+	}
+	if adaptive.Profile() != ProfileLowBandwidth {
+		t.Errorf("Profile() = %v, want %v", adaptive.Profile(), ProfileLowBandwidth)
+	}
+	if _, roundTrip := adaptive.Measurements(); roundTrip <= 0 {
+		t.Errorf("Measurements() round trip = %v, want a positive duration", roundTrip)
+	}
+}
@@ -0,0 +1,47 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package vnc
+
+import (
+	"strings"
+	"time"
+)
+
+// maxAnnotations bounds the annotations kept in the statistics timeline; the
+// oldest are dropped first.
+const maxAnnotations = 256
+
+// Annotation is a caller-provided marker in the session timeline.
+type Annotation struct {
+	// Time is when the annotation was added.
+	Time time.Time
+
+	// Text describes the moment, such as "login complete".
+	Text string
+}
+
+// Annotate marks the current moment of the session with text. The annotation
+// is added to Stats.Annotations and, while a recording is running, to the
+// recording, whose sink can store it as a chapter marker or subtitle for
+// playback. Annotating long automation runs makes the interesting parts of
+// their recordings easy to find.
+//
+// Example usage:
+//
+//	client.Annotate("login complete")
+func (c *ClientConn) Annotate(text string) {
+	text = strings.Join(strings.Fields(text), " ")
+	annotation := Annotation{Time: time.Now(), Text: text}
+
+	c.stats.mu.Lock()
+	if len(c.stats.annotations) == maxAnnotations {
+		c.stats.annotations = append(c.stats.annotations[:0], c.stats.annotations[1:]...)
+	}
+	c.stats.annotations = append(c.stats.annotations, annotation)
+	c.stats.mu.Unlock()
+
+	if h := c.recorder.Load(); h != nil {
+		h.annotate(annotation)
+	}
+}
@@ -0,0 +1,33 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package vnc
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestAnnotate_Timeline(t *testing.T) {
+	c := &ClientConn{logger: &NoOpLogger{}}
+
+	c.Annotate("  login\n complete ")
+	annotations := c.Stats().Annotations
+	if len(annotations) != 1 || annotations[0].Text != "login complete" {
+		t.Fatalf("Annotations = %+v, want one \"login complete\"", annotations)
+	}
+	if annotations[0].Time.IsZero() {
+		t.Error("annotation has no time")
+	}
+
+	for i := range maxAnnotations + 10 {
+		c.Annotate(fmt.Sprintf("step %d", i))
+	}
+	annotations = c.Stats().Annotations
+	if len(annotations) != maxAnnotations {
+		t.Fatalf("kept %d annotations, want %d", len(annotations), maxAnnotations)
+	}
+	if first, last := annotations[0].Text, annotations[len(annotations)-1].Text; first != "step 10" || last != fmt.Sprintf("step %d", maxAnnotations+9) {
+		t.Errorf("annotations span %q to %q", first, last)
+	}
+}
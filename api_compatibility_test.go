@@ -5,12 +5,26 @@ package vnc
 
 import (
 	"context"
+	"go/ast"
+	"go/build"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
 	"net"
+	"os"
 	"reflect"
+	"slices"
+	"strings"
 	"testing"
 	"time"
 )
 
+// apiManifestPath is the golden file listing the exported API of the package.
+// Run the tests with VNC_UPDATE_API=1 to regenerate it after an intended
+// change.
+const apiManifestPath = "testdata/api.txt"
+
 // TestBackwardCompatibility_PublicAPISignatures verifies that all existing public APIs
 // maintain identical function signatures and behavior for backward compatibility.
 func TestAPICompatibility_PublicAPISignatures(t *testing.T) {
@@ -594,3 +608,116 @@ func TestAPICompatibility_Integration(t *testing.T) {
 		}
 	})
 }
+
+// TestAPICompatibility_Manifest compares the exported API of the package, as
+// built without tags on Linux, with the golden manifest, so that a change
+// removing or altering an exported symbol, and with it the drop-in
+// compatibility with mitchellh/go-vnc, fails review instead of a downstream
+// build. Additions fail as well until the manifest is regenerated.
+func TestAPICompatibility_Manifest(t *testing.T) {
+	got := apiManifest(t)
+	if os.Getenv("VNC_UPDATE_API") == "1" {
+		if err := os.WriteFile(apiManifestPath, []byte(strings.Join(got, "\n")+"\n"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	data, err := os.ReadFile(apiManifestPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+
+	for _, line := range want {
+		if _, found := slices.BinarySearch(got, line); !found {
+			t.Errorf("removed or changed: %s", line)
+		}
+	}
+	for _, line := range got {
+		if _, found := slices.BinarySearch(want, line); !found {
+			t.Errorf("added: %s", line)
+		}
+	}
+	if t.Failed() {
+		t.Log("run the tests with VNC_UPDATE_API=1 to accept an intended API change")
+	}
+}
+
+// apiManifest type-checks the package and returns one sorted line per exported
+// constant, variable, function, type, method, and struct field.
+func apiManifest(t *testing.T) []string {
+	t.Helper()
+
+	ctxt := build.Default
+	ctxt.GOOS, ctxt.GOARCH = "linux", "amd64"
+	ctxt.BuildTags = nil
+	pkg, err := ctxt.ImportDir(".", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fset := token.NewFileSet()
+	files := make([]*ast.File, 0, len(pkg.GoFiles))
+	for _, name := range pkg.GoFiles {
+		f, err := parser.ParseFile(fset, name, nil, parser.SkipObjectResolution)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, f)
+	}
+
+	conf := types.Config{Importer: importer.ForCompiler(fset, "source", nil)}
+	checked, err := conf.Check(pkg.ImportPath, fset, files, nil)
+	if err != nil {
+		t.Fatalf("type-checking the package: %v", err)
+	}
+
+	qualifier := func(p *types.Package) string {
+		if p == checked {
+			return ""
+		}
+		return p.Name()
+	}
+
+	var lines []string
+	scope := checked.Scope()
+	for _, name := range scope.Names() {
+		obj := scope.Lookup(name)
+		if !obj.Exported() {
+			continue
+		}
+
+		if c, ok := obj.(*types.Const); ok {
+			// Constant values are part of the wire protocol.
+			lines = append(lines, types.ObjectString(c, qualifier)+" = "+c.Val().ExactString())
+			continue
+		}
+		typeName, ok := obj.(*types.TypeName)
+		if !ok || typeName.IsAlias() {
+			lines = append(lines, types.ObjectString(obj, qualifier))
+			continue
+		}
+
+		named := typeName.Type().(*types.Named)
+		switch underlying := named.Underlying().(type) {
+		case *types.Struct:
+			lines = append(lines, "type "+name+" struct")
+			for field := range underlying.Fields() {
+				if field.Exported() {
+					lines = append(lines, "field "+name+"."+field.Name()+" "+types.TypeString(field.Type(), qualifier))
+				}
+			}
+		default:
+			lines = append(lines, "type "+name+" "+types.TypeString(underlying, qualifier))
+		}
+		for method := range named.Methods() {
+			if method.Exported() {
+				lines = append(lines, types.ObjectString(method, qualifier))
+			}
+		}
+	}
+
+	slices.Sort(lines)
+	return lines
+}
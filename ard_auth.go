@@ -0,0 +1,156 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package vnc
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/md5" // #nosec G501 - Required by the ARD protocol
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"net"
+
+	"github.com/tenthirtyam/go-vnc/rfb"
+)
+
+// Sizes of the Apple Remote Desktop credentials block, which holds the
+// username and the password in fixed-size, NUL-terminated fields.
+const (
+	ardCredentialFieldSize = 64
+	ardCredentialsSize     = 2 * ardCredentialFieldSize
+)
+
+// ardMaxKeyLength bounds the Diffie-Hellman key length announced by the
+// server, which macOS sets to 128 bytes.
+const ardMaxKeyLength = 1024
+
+// ARDAuth implements Apple Remote Desktop authentication (security type 30),
+// which macOS Screen Sharing requires. The client agrees on a key with the
+// server by Diffie-Hellman and sends the credentials of a macOS account
+// encrypted with AES-128 under the MD5 digest of the shared secret.
+//
+// Username and Password are truncated to 63 bytes each.
+type ARDAuth struct {
+	Username string
+	Password string
+
+	// Credentials, if set, supplies the username and password at handshake
+	// time in place of Username and Password.
+	Credentials CredentialProvider
+
+	logger Logger
+}
+
+// SecurityType returns the security type identifier for Apple Remote Desktop.
+func (a *ARDAuth) SecurityType() uint8 {
+	return rfb.SecurityARD
+}
+
+// String returns a human-readable description of the authentication method.
+func (a *ARDAuth) String() string {
+	return "Apple Remote Desktop"
+}
+
+// SetLogger sets the logger for the authentication method.
+func (a *ARDAuth) SetLogger(logger Logger) {
+	a.logger = logger
+}
+
+// ClearPassword securely clears the password from memory.
+func (a *ARDAuth) ClearPassword() {
+	if a.Password != "" {
+		a.Password = (&SecureMemory{}).ClearString(a.Password)
+	}
+}
+
+// Handshake reads the Diffie-Hellman parameters of the server and sends the
+// encrypted credentials with the public key of the client.
+func (a *ARDAuth) Handshake(ctx context.Context, conn net.Conn) error {
+	select {
+	case <-ctx.Done():
+		return timeoutError("ARDAuth.Handshake", "authentication cancelled", ctx.Err())
+	default:
+	}
+
+	logger := a.logger
+	if logger == nil {
+		logger = &NoOpLogger{}
+	}
+
+	fields, err := readFields(conn, "ARD parameters", make([]byte, 4))
+	if err != nil {
+		return networkError("ARDAuth.Handshake", "failed to read Diffie-Hellman parameters", err)
+	}
+	generator, keyLength := fields.uint16(), int(fields.uint16())
+	if keyLength == 0 || keyLength > ardMaxKeyLength {
+		return protocolError("ARDAuth.Handshake",
+			fmt.Sprintf("invalid Diffie-Hellman key length %d", keyLength), nil)
+	}
+	keys, err := readBytes(conn, "ARD keys", 2*keyLength)
+	if err != nil {
+		return networkError("ARDAuth.Handshake", "failed to read Diffie-Hellman keys", err)
+	}
+	prime := new(big.Int).SetBytes(keys[:keyLength])
+	serverPublic := new(big.Int).SetBytes(keys[keyLength:])
+	if prime.Cmp(big.NewInt(3)) < 0 || serverPublic.Sign() <= 0 || serverPublic.Cmp(prime) >= 0 {
+		return protocolError("ARDAuth.Handshake", "invalid Diffie-Hellman parameters", nil)
+	}
+	logger.Debug("Received Diffie-Hellman parameters", Field{Key: "key_length", Value: keyLength})
+
+	private, err := rand.Int(rand.Reader, new(big.Int).Sub(prime, big.NewInt(2)))
+	if err != nil {
+		return authenticationError("ARDAuth.Handshake", "failed to generate private key", err)
+	}
+	private.Add(private, big.NewInt(1))
+	public := new(big.Int).Exp(big.NewInt(int64(generator)), private, prime)
+	shared := new(big.Int).Exp(serverPublic, private, prime).FillBytes(make([]byte, keyLength))
+	key := md5.Sum(shared) // #nosec G401 - Required by the ARD protocol
+	sm := &SecureMemory{}
+	sm.ClearBytes(shared)
+	defer sm.ClearBytes(key[:])
+
+	username, password, err := resolveCredentials(ctx, "ARDAuth.Handshake", a.Credentials, a.Username, a.Password, true)
+	if err != nil {
+		return err
+	}
+	credentials, err := ardCredentials(username, password)
+	sm.ClearBytes(password)
+	if err != nil {
+		return authenticationError("ARDAuth.Handshake", "failed to prepare credentials", err)
+	}
+	defer sm.ClearBytes(credentials)
+
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return authenticationError("ARDAuth.Handshake", "failed to create cipher", err)
+	}
+	response := make([]byte, ardCredentialsSize+keyLength)
+	// The protocol encrypts each block independently, as in ECB mode.
+	for i := 0; i < ardCredentialsSize; i += aes.BlockSize {
+		block.Encrypt(response[i:i+aes.BlockSize], credentials[i:i+aes.BlockSize])
+	}
+	public.FillBytes(response[ardCredentialsSize:])
+
+	if _, err := conn.Write(response); err != nil {
+		return networkError("ARDAuth.Handshake", "failed to send credentials", err)
+	}
+	logger.Debug("Sent encrypted credentials")
+	return nil
+}
+
+// ardCredentials returns the credentials block: the username and the
+// password, each NUL-terminated in a 64-byte field padded with random bytes.
+func ardCredentials(username string, password []byte) ([]byte, error) {
+	credentials := make([]byte, ardCredentialsSize)
+	if _, err := rand.Read(credentials); err != nil {
+		return nil, err
+	}
+	for i, value := range [][]byte{[]byte(username), password} {
+		field := credentials[i*ardCredentialFieldSize : (i+1)*ardCredentialFieldSize]
+		n := copy(field[:ardCredentialFieldSize-1], value)
+		field[n] = 0
+	}
+	return credentials, nil
+}
@@ -0,0 +1,129 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package vnc
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/md5" // #nosec G501 - Required by the ARD protocol
+	"encoding/binary"
+	"io"
+	"math/big"
+	"net"
+	"testing"
+)
+
+// ardTestPrime is the 1024-bit MODP prime of RFC 2409, as sent by macOS.
+var ardTestPrime, _ = new(big.Int).SetString(
+	"FFFFFFFFFFFFFFFFC90FDAA22168C234C4C6628B80DC1CD1"+
+		"29024E088A67CC74020BBEA63B139B22514A08798E3404DD"+
+		"EF9519B3CD3A431B302B0A6DF25F14374FE1356D6D51C245"+
+		"E485B576625E7EC6F44C42E9A637ED6B0BFF5CB6F406B7ED"+
+		"EE386BFB5A899FA5AE9F24117C4B1FE649286651ECE65381"+
+		"FFFFFFFFFFFFFFFF", 16)
+
+// serveARD plays the server side of ARD authentication and returns the
+// username and password it decrypted.
+func serveARD(conn net.Conn) (string, string, error) {
+	const keyLength = 128
+	private := big.NewInt(0x1234567)
+	public := new(big.Int).Exp(big.NewInt(2), private, ardTestPrime)
+
+	msg := binary.BigEndian.AppendUint16(nil, 2)
+	msg = binary.BigEndian.AppendUint16(msg, keyLength)
+	msg = append(msg, ardTestPrime.FillBytes(make([]byte, keyLength))...)
+	msg = append(msg, public.FillBytes(make([]byte, keyLength))...)
+	if _, err := conn.Write(msg); err != nil {
+		return "", "", err
+	}
+
+	response := make([]byte, ardCredentialsSize+keyLength)
+	if _, err := io.ReadFull(conn, response); err != nil {
+		return "", "", err
+	}
+	clientPublic := new(big.Int).SetBytes(response[ardCredentialsSize:])
+	shared := new(big.Int).Exp(clientPublic, private, ardTestPrime).FillBytes(make([]byte, keyLength))
+	key := md5.Sum(shared) // #nosec G401 - Required by the ARD protocol
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return "", "", err
+	}
+	credentials := make([]byte, ardCredentialsSize)
+	for i := 0; i < ardCredentialsSize; i += aes.BlockSize {
+		block.Decrypt(credentials[i:i+aes.BlockSize], response[i:i+aes.BlockSize])
+	}
+	field := func(b []byte) string {
+		if n := bytes.IndexByte(b, 0); n >= 0 {
+			return string(b[:n])
+		}
+		return string(b)
+	}
+	return field(credentials[:ardCredentialFieldSize]), field(credentials[ardCredentialFieldSize:]), nil
+}
+
+func TestARDAuth_Handshake(t *testing.T) {
+	server, client := net.Pipe()
+	defer func() { _ = client.Close() }()
+
+	type result struct {
+		username, password string
+		err                error
+	}
+	done := make(chan result, 1)
+	go func() {
+		defer func() { _ = server.Close() }()
+		var r result
+		r.username, r.password, r.err = serveARD(server)
+		done <- r
+	}()
+
+	auth := &ARDAuth{Username: "admin", Password: "correct horse battery staple"}
+	if err := auth.Handshake(context.Background(), client); err != nil {
+		t.Fatalf("Handshake() error = %v", err)
+	}
+	r := <-done
+	if r.err != nil {
+		t.Fatalf("server error = %v", r.err)
+	}
+	if r.username != auth.Username || r.password != auth.Password {
+		t.Errorf("credentials = %q/%q, want %q/%q", r.username, r.password, auth.Username, auth.Password)
+	}
+}
+
+func TestARDAuth_InvalidParameters(t *testing.T) {
+	tests := map[string][]byte{
+		"zero key length":    {0, 2, 0, 0},
+		"public key too big": {0, 2, 0, 1, 5, 7},
+	}
+	for name, msg := range tests {
+		t.Run(name, func(t *testing.T) {
+			server, client := net.Pipe()
+			defer func() { _ = client.Close() }()
+			go func() {
+				defer func() { _ = server.Close() }()
+				_, _ = server.Write(msg)
+			}()
+
+			err := (&ARDAuth{}).Handshake(context.Background(), client)
+			if !IsVNCError(err, ErrProtocol) {
+				t.Errorf("Handshake() error = %v, want protocol error", err)
+			}
+		})
+	}
+}
+
+func TestARDCredentials_Truncation(t *testing.T) {
+	long := string(bytes.Repeat([]byte{'a'}, 100))
+	credentials, err := ardCredentials(long, []byte("pw"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if credentials[ardCredentialFieldSize-1] != 0 {
+		t.Error("username field is not NUL-terminated")
+	}
+	if !bytes.Equal(credentials[ardCredentialFieldSize:ardCredentialFieldSize+3], []byte("pw\x00")) {
+		t.Errorf("password field = %q", credentials[ardCredentialFieldSize:ardCredentialFieldSize+3])
+	}
+}
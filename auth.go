@@ -19,6 +19,25 @@ type ClientAuth interface {
 	String() string
 }
 
+// AuthHandshake runs the handshake of auth on conn and returns the connection
+// the session continues on. Methods that encrypt the session, such as
+// VeNCryptAuth, TLSAuth, and RSAAESAuth, wrap conn during the handshake, and
+// the security result and all later messages must use the wrapped connection
+// they return; other methods return conn. Callers that perform the RFB
+// handshake themselves, such as proxies, use AuthHandshake rather than
+// calling Handshake directly.
+//
+// The returned connection is non-nil even when the handshake fails, so that
+// a connection wrapped before the failure can be closed.
+func AuthHandshake(ctx context.Context, auth ClientAuth, conn net.Conn) (net.Conn, error) {
+	var secured net.Conn
+	err := auth.Handshake(context.WithValue(ctx, securedConnKey{}, &secured), conn)
+	if secured == nil {
+		secured = conn
+	}
+	return secured, err
+}
+
 // ClientAuthNone implements the "None" authentication method (security type 1).
 type ClientAuthNone struct {
 	logger Logger
@@ -60,7 +79,12 @@ func (c *ClientAuthNone) SetLogger(logger Logger) {
 
 // PasswordAuth implements VNC Authentication (security type 2).
 type PasswordAuth struct {
-	Password     string
+	Password string
+
+	// Credentials, if set, supplies the password at handshake time in place
+	// of Password.
+	Credentials CredentialProvider
+
 	logger       Logger
 	secureMemory *SecureMemory
 }
@@ -89,28 +113,34 @@ func (p *PasswordAuth) Handshake(ctx context.Context, c net.Conn) error {
 	default:
 	}
 
+	if p.secureMemory == nil {
+		p.secureMemory = &SecureMemory{}
+	}
+
+	_, password, err := resolveCredentials(ctx, "PasswordAuth.Handshake", p.Credentials, "", p.Password, false)
+	if err != nil {
+		return err
+	}
+	defer p.secureMemory.ClearBytes(password)
+
 	if p.logger != nil {
 		p.logger.Debug("Starting VNC password authentication handshake")
 
-		if len(p.Password) > VNCMaxPasswordLength {
+		if len(password) > VNCMaxPasswordLength {
 			p.logger.Warn("Password exceeds VNC maximum length, will be truncated for DES encryption",
-				Field{Key: "password_length", Value: len(p.Password)})
+				Field{Key: "password_length", Value: len(password)})
 		}
 
-		if len(p.Password) == 0 {
+		if len(password) == 0 {
 			p.logger.Warn("Empty password provided for VNC authentication")
 		}
 	}
 
-	if p.secureMemory == nil {
-		p.secureMemory = &SecureMemory{}
-	}
-
 	memProtection := newMemoryProtection()
 	challengeBuffer := memProtection.NewProtectedBytes(VNCChallengeSize)
 	defer challengeBuffer.Clear()
 
-	if err := binary.Read(c, binary.BigEndian, challengeBuffer.Data()); err != nil {
+	if _, err := readFields(c, "authentication challenge", challengeBuffer.Data()); err != nil {
 		if p.logger != nil {
 			p.logger.Error("Failed to read authentication challenge from server",
 				Field{Key: "error", Value: err})
@@ -132,7 +162,7 @@ func (p *PasswordAuth) Handshake(ctx context.Context, c net.Conn) error {
 	default:
 	}
 
-	crypted, err := p.encrypt(p.Password, challengeBuffer.Data())
+	crypted, err := p.encrypt(string(password), challengeBuffer.Data())
 	if err != nil {
 		if p.logger != nil {
 			p.logger.Error("Failed to encrypt password challenge",
@@ -194,7 +224,7 @@ func (p *PasswordAuth) ClearPassword() {
 
 // encrypt performs DES encryption of the challenge using the provided password.
 func (p *PasswordAuth) encrypt(key string, bytes []byte) ([]byte, error) {
-	secureCipher := newSecureDESCipher()
+	secureCipher := NewSecureDESCipher()
 	timingProtection := newTimingProtection()
 
 	var result []byte
@@ -392,6 +422,12 @@ func (r *AuthRegistry) NegotiateAuth(ctx context.Context, serverTypes []uint8, p
 
 // ValidateAuthMethod performs validation on an authentication method instance.
 func (r *AuthRegistry) ValidateAuthMethod(auth ClientAuth) error {
+	return r.validateAuthMethod(auth, false)
+}
+
+// validateAuthMethod validates auth, accepting a PasswordAuth without a
+// password when prompted, as the handshake then asks the AuthPrompt for it.
+func (r *AuthRegistry) validateAuthMethod(auth ClientAuth, prompted bool) error {
 	if auth == nil {
 		return validationError("AuthRegistry.ValidateAuthMethod", "authentication method is nil", nil)
 	}
@@ -403,7 +439,7 @@ func (r *AuthRegistry) ValidateAuthMethod(auth ClientAuth) error {
 
 	switch a := auth.(type) {
 	case *PasswordAuth:
-		if a.Password == "" {
+		if a.Password == "" && a.Credentials == nil && !prompted {
 			if r.logger != nil {
 				r.logger.Warn("Password authentication method has empty password")
 			}
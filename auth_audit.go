@@ -0,0 +1,158 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package vnc
+
+import (
+	"fmt"
+	"time"
+)
+
+// AuthEventKind identifies what an AuthEvent reports.
+type AuthEventKind int
+
+// Kinds of AuthEvent, in the order a handshake reports them.
+const (
+	// AuthOffered reports the security types the server offered, or the one
+	// an RFB 3.3 server chose.
+	AuthOffered AuthEventKind = iota + 1
+
+	// AuthSelected reports the authentication method the client selected.
+	AuthSelected
+
+	// AuthSucceeded reports that the server accepted the authentication.
+	AuthSucceeded
+
+	// AuthFailed reports that the security handshake failed, whether the
+	// server rejected the credentials, no method matched, or the exchange
+	// broke off.
+	AuthFailed
+)
+
+// String returns the name of the kind.
+func (k AuthEventKind) String() string {
+	switch k {
+	case AuthOffered:
+		return "offered"
+	case AuthSelected:
+		return "selected"
+	case AuthSucceeded:
+		return "succeeded"
+	case AuthFailed:
+		return "failed"
+	default:
+		return fmt.Sprintf("AuthEventKind(%d)", int(k))
+	}
+}
+
+// AuthEvent reports a step of the security handshake for auditing. Each
+// event carries what the handshake has established so far.
+type AuthEvent struct {
+	// Kind tells what happened.
+	Kind AuthEventKind
+
+	// ConnID and RemoteAddr identify the connection.
+	ConnID     string
+	RemoteAddr string
+
+	// Offered lists the security types the server offered.
+	Offered []uint8
+
+	// SecurityType and Method identify the selected authentication method,
+	// and are zero before it is selected.
+	SecurityType uint8
+	Method       string
+
+	// Reason is the reason the server gave for a failure, if any, and Err
+	// the error the handshake fails with. Both are only set for AuthFailed.
+	Reason string
+	Err    error
+
+	// Duration is the time since the server's security types arrived, so
+	// for AuthSucceeded and AuthFailed it is the duration of authentication.
+	Duration time.Duration
+
+	// Time is when the client observed the event.
+	Time time.Time
+}
+
+// WithAuthAudit calls audit with an AuthEvent at each step of the security
+// handshake, so security teams can record which methods servers offer, how
+// clients authenticate, and why and how slowly authentication fails:
+//
+//	client, err := vnc.ClientWithOptions(ctx, conn, vnc.WithAuth(auth),
+//		vnc.WithAuthAudit(func(ev vnc.AuthEvent) {
+//			slog.Info("vnc auth", "event", ev.Kind, "server", ev.RemoteAddr,
+//				"method", ev.Method, "reason", ev.Reason, "duration", ev.Duration)
+//		}))
+//
+// audit runs on the goroutine performing the handshake, which waits for it.
+// The events are also logged at debug level.
+func WithAuthAudit(audit func(AuthEvent)) ClientOption {
+	return func(cfg *ClientConfig) {
+		cfg.AuthAudit = audit
+	}
+}
+
+// authAudit accumulates the security handshake of a connection and reports
+// its steps.
+type authAudit struct {
+	c     *ClientConn
+	start time.Time
+	event AuthEvent
+}
+
+// newAuthAudit returns the audit of the security handshake of c.
+func (c *ClientConn) newAuthAudit() *authAudit {
+	a := &authAudit{c: c, start: time.Now()}
+	a.event.ConnID = c.connID
+	if addr := c.c.RemoteAddr(); addr != nil {
+		a.event.RemoteAddr = addr.String()
+	}
+	return a
+}
+
+// offered reports the security types of the server.
+func (a *authAudit) offered(securityTypes []uint8) {
+	a.start = time.Now()
+	a.event.Offered = securityTypes
+	a.emit(AuthOffered)
+}
+
+// selected reports the selected authentication method.
+func (a *authAudit) selected(securityType uint8, auth ClientAuth) {
+	a.event.SecurityType = securityType
+	a.event.Method = auth.String()
+	a.emit(AuthSelected)
+}
+
+// succeeded reports a successful authentication.
+func (a *authAudit) succeeded() {
+	a.emit(AuthSucceeded)
+}
+
+// fail reports a failed security handshake with the reason of the server,
+// if any, and returns err.
+func (a *authAudit) fail(err error, reason string) error {
+	a.event.Reason = reason
+	a.event.Err = err
+	a.emit(AuthFailed)
+	return err
+}
+
+// emit logs the event of kind and passes it to the hook.
+func (a *authAudit) emit(kind AuthEventKind) {
+	ev := a.event
+	ev.Kind = kind
+	ev.Time = time.Now()
+	ev.Duration = ev.Time.Sub(a.start)
+
+	a.c.logger.Debug("Authentication event",
+		Field{Key: "kind", Value: kind.String()},
+		Field{Key: "type", Value: ev.SecurityType},
+		Field{Key: "duration", Value: ev.Duration})
+
+	if a.c.config != nil && a.c.config.AuthAudit != nil {
+		a.c.config.AuthAudit(ev)
+	}
+}
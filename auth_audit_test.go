@@ -0,0 +1,96 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package vnc
+
+import (
+	"context"
+	"errors"
+	"net"
+	"slices"
+	"testing"
+
+	"github.com/tenthirtyam/go-vnc/rfb"
+)
+
+func TestAuthAudit_Events(t *testing.T) {
+	tests := []struct {
+		name       string
+		auth       ClientAuth
+		wantKinds  []AuthEventKind
+		wantMethod string
+		wantReason string
+	}{
+		{
+			name:       "success",
+			auth:       NewPasswordAuth("letmein"),
+			wantKinds:  []AuthEventKind{AuthOffered, AuthSelected, AuthSucceeded},
+			wantMethod: "VNC Password",
+		},
+		{
+			name:       "rejected credentials",
+			auth:       NewPasswordAuth("wrong"),
+			wantKinds:  []AuthEventKind{AuthOffered, AuthSelected, AuthFailed},
+			wantMethod: "VNC Password",
+			wantReason: "bad password",
+		},
+		{
+			name:      "no suitable method",
+			auth:      &ClientAuthNone{},
+			wantKinds: []AuthEventKind{AuthOffered, AuthFailed},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			serverConn, clientConn := net.Pipe()
+			go func() {
+				defer func() { _ = serverConn.Close() }()
+				_ = servePasswordLogin(serverConn, "letmein", "bad password")
+			}()
+
+			var events []AuthEvent
+			conn, err := ClientWithOptions(context.Background(), clientConn, WithAuth(tt.auth),
+				WithAuthAudit(func(ev AuthEvent) { events = append(events, ev) }))
+			if err == nil {
+				_ = conn.Close()
+			}
+
+			var kinds []AuthEventKind
+			for _, ev := range events {
+				kinds = append(kinds, ev.Kind)
+			}
+			if !slices.Equal(kinds, tt.wantKinds) {
+				t.Fatalf("event kinds = %v, want %v", kinds, tt.wantKinds)
+			}
+
+			last := events[len(events)-1]
+			if !slices.Equal(last.Offered, []uint8{rfb.SecurityVNCAuth}) {
+				t.Errorf("Offered = %v, want [%d]", last.Offered, rfb.SecurityVNCAuth)
+			}
+			if last.Method != tt.wantMethod || last.Reason != tt.wantReason {
+				t.Errorf("Method, Reason = %q, %q; want %q, %q", last.Method, last.Reason, tt.wantMethod, tt.wantReason)
+			}
+			if last.ConnID == "" || last.RemoteAddr == "" || last.Time.IsZero() || last.Duration < 0 {
+				t.Errorf("event lacks connection details or timing: %+v", last)
+			}
+			if (last.Kind == AuthFailed) != (last.Err != nil) || !errors.Is(err, last.Err) {
+				t.Errorf("Err = %v, want the handshake error %v", last.Err, err)
+			}
+		})
+	}
+}
+
+func TestAuthEventKind_String(t *testing.T) {
+	for kind, want := range map[AuthEventKind]string{
+		AuthOffered:       "offered",
+		AuthSelected:      "selected",
+		AuthSucceeded:     "succeeded",
+		AuthFailed:        "failed",
+		AuthEventKind(42): "AuthEventKind(42)",
+	} {
+		if got := kind.String(); got != want {
+			t.Errorf("%d.String() = %q, want %q", int(kind), got, want)
+		}
+	}
+}
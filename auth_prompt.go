@@ -0,0 +1,93 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package vnc
+
+import "context"
+
+// maxAuthPromptAttempts bounds the connections a Session makes for one call
+// to Session.Client while the server rejects the credentials entered at the
+// prompt.
+const maxAuthPromptAttempts = 3
+
+// AuthPrompt describes the credentials an AuthPromptFunc is asked for.
+type AuthPrompt struct {
+	// SecurityType and Method identify the negotiated authentication
+	// method, such as 2 and "VNC Password".
+	SecurityType uint8
+	Method       string
+
+	// NeedUsername reports whether the method sends a username. When it is
+	// false, the returned username is ignored.
+	NeedUsername bool
+
+	// Attempt counts the prompts for one call to Session.Client, starting at
+	// 1. FailureReason holds the reason the server gave for rejecting the
+	// credentials of the previous attempt, empty on the first.
+	Attempt       int
+	FailureReason string
+}
+
+// AuthPromptFunc returns the credentials for prompt, such as by asking the
+// user in a dialog or a terminal, or by requesting a one-time code. An error
+// aborts the handshake. The authentication methods clear the returned
+// password once sent, so implementations return a fresh slice for each call.
+type AuthPromptFunc func(ctx context.Context, prompt AuthPrompt) (username string, password []byte, err error)
+
+// WithAuthPrompt calls prompt during the security handshake whenever the
+// negotiated authentication method has no password and no
+// CredentialProvider, so credentials can be entered interactively. The
+// username it returns replaces that of the method unless empty.
+//
+// When the server rejects the credentials, the handshake fails with an
+// error wrapping an AuthFailureError. A Session then reconnects and prompts
+// again with the reason of the server, up to three attempts per call to
+// Session.Client, which suits retyped passwords and MFA flows:
+//
+//	session := vnc.NewSession(dial,
+//		vnc.WithAuth(&vnc.PasswordAuth{}),
+//		vnc.WithAuthPrompt(func(ctx context.Context, p vnc.AuthPrompt) (string, []byte, error) {
+//			if p.FailureReason != "" {
+//				fmt.Println("Login failed:", p.FailureReason)
+//			}
+//			return "", readPassword(), nil
+//		}))
+func WithAuthPrompt(prompt AuthPromptFunc) ClientOption {
+	return func(cfg *ClientConfig) {
+		cfg.AuthPrompt = prompt
+	}
+}
+
+// AuthFailureError is the cause of a handshake error when the server rejects
+// the credentials, carrying the reason it gave.
+type AuthFailureError struct {
+	Reason string
+
+	// auth is the rejected authentication method.
+	auth ClientAuth
+}
+
+// Error returns the reason of the server.
+func (e *AuthFailureError) Error() string {
+	return e.Reason
+}
+
+// authPromptKey is the context key under which the handshake passes the
+// prompt to the authentication methods.
+type authPromptKey struct{}
+
+// authPromptState is the prompt of the handshake and the prompt it is called
+// with, less NeedUsername, which the authentication method supplies.
+type authPromptState struct {
+	fn     AuthPromptFunc
+	prompt AuthPrompt
+}
+
+// withAuthPrompt returns ctx carrying the prompt of the handshake, or ctx
+// itself without one.
+func withAuthPrompt(ctx context.Context, fn AuthPromptFunc, prompt AuthPrompt) context.Context {
+	if fn == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, authPromptKey{}, &authPromptState{fn: fn, prompt: prompt})
+}
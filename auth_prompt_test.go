@@ -0,0 +1,231 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package vnc
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/tenthirtyam/go-vnc/rfb"
+)
+
+// servePasswordLogin runs the handshake of a server requiring VNC
+// authentication with password, rejecting other passwords with reason.
+func servePasswordLogin(conn net.Conn, password, reason string) error {
+	if err := rfb.WriteProtocolVersion(conn, 3, 8); err != nil {
+		return err
+	}
+	if _, _, err := rfb.ReadProtocolVersion(conn); err != nil {
+		return err
+	}
+	if err := rfb.WriteSecurityTypes(conn, []uint8{rfb.SecurityVNCAuth}); err != nil {
+		return err
+	}
+	if _, err := rfb.ReadSecurityType(conn); err != nil {
+		return err
+	}
+
+	challenge := bytes.Repeat([]byte{0x3c}, VNCChallengeSize)
+	if _, err := conn.Write(challenge); err != nil {
+		return err
+	}
+	response := make([]byte, VNCChallengeSize)
+	if _, err := io.ReadFull(conn, response); err != nil {
+		return err
+	}
+	want, err := NewSecureDESCipher().EncryptVNCChallenge(password, challenge)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(response, want) {
+		return rfb.WriteSecurityResult(conn, errors.New(reason))
+	}
+
+	if err := rfb.WriteSecurityResult(conn, nil); err != nil {
+		return err
+	}
+	if _, err := rfb.ReadClientInit(conn); err != nil {
+		return err
+	}
+	if err := rfb.WriteServerInit(conn, rfb.ServerInit{
+		Width:  4,
+		Height: 4,
+		PixelFormat: rfb.PixelFormat{
+			BPP: 32, Depth: 24, TrueColor: true,
+			RedMax: 255, GreenMax: 255, BlueMax: 255,
+			RedShift: 16, GreenShift: 8,
+		},
+		Name: "prompt",
+	}); err != nil {
+		return err
+	}
+	_, err = io.Copy(io.Discard, conn)
+	return err
+}
+
+// passwordLoginDialer returns a dial function connecting to servePasswordLogin.
+func passwordLoginDialer(password, reason string, dials *int) func(context.Context) (net.Conn, error) {
+	return func(context.Context) (net.Conn, error) {
+		*dials++
+		serverConn, clientConn := net.Pipe()
+		go func() {
+			defer func() { _ = serverConn.Close() }()
+			_ = servePasswordLogin(serverConn, password, reason)
+		}()
+		return clientConn, nil
+	}
+}
+
+func TestAuthPrompt_SessionRetries(t *testing.T) {
+	var prompts []AuthPrompt
+	entered := []string{"wrong", "letmein"}
+	prompt := func(ctx context.Context, p AuthPrompt) (string, []byte, error) {
+		prompts = append(prompts, p)
+		return "ignored", []byte(entered[len(prompts)-1]), nil
+	}
+
+	dials := 0
+	session := NewSession(passwordLoginDialer("letmein", "bad password", &dials),
+		WithAuth(&PasswordAuth{}), WithAuthPrompt(prompt))
+	defer session.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := session.Client(ctx); err != nil {
+		t.Fatalf("Client() error = %v", err)
+	}
+
+	if dials != 2 || len(prompts) != 2 {
+		t.Fatalf("got %d dials and %d prompts, want 2 each", dials, len(prompts))
+	}
+	first := AuthPrompt{SecurityType: rfb.SecurityVNCAuth, Method: "VNC Password", Attempt: 1}
+	if prompts[0] != first {
+		t.Errorf("first prompt = %+v, want %+v", prompts[0], first)
+	}
+	second := first
+	second.Attempt, second.FailureReason = 2, "bad password"
+	if prompts[1] != second {
+		t.Errorf("second prompt = %+v, want %+v", prompts[1], second)
+	}
+}
+
+func TestAuthPrompt_AttemptsExhausted(t *testing.T) {
+	prompts := 0
+	prompt := func(ctx context.Context, p AuthPrompt) (string, []byte, error) {
+		prompts++
+		return "", []byte("wrong"), nil
+	}
+
+	dials := 0
+	session := NewSession(passwordLoginDialer("letmein", "bad password", &dials),
+		WithAuth(&PasswordAuth{}), WithAuthPrompt(prompt))
+	defer session.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err := session.Client(ctx)
+	var failure *AuthFailureError
+	if !IsVNCError(err, ErrAuthentication) || !errors.As(err, &failure) || failure.Reason != "bad password" {
+		t.Fatalf("Client() error = %v, want authentication error with reason", err)
+	}
+	if dials != maxAuthPromptAttempts || prompts != maxAuthPromptAttempts {
+		t.Errorf("got %d dials and %d prompts, want %d each", dials, prompts, maxAuthPromptAttempts)
+	}
+}
+
+func TestAuthPrompt_ConfiguredPasswordNotRetried(t *testing.T) {
+	prompts := 0
+	prompt := func(ctx context.Context, p AuthPrompt) (string, []byte, error) {
+		prompts++
+		return "", nil, nil
+	}
+
+	dials := 0
+	session := NewSession(passwordLoginDialer("letmein", "bad password", &dials),
+		WithAuth(NewPasswordAuth("wrong")), WithAuthPrompt(prompt))
+	defer session.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := session.Client(ctx); !IsVNCError(err, ErrAuthentication) {
+		t.Fatalf("Client() error = %v, want authentication error", err)
+	}
+	if dials != 1 || prompts != 0 {
+		t.Errorf("got %d dials and %d prompts, want 1 dial and no prompt", dials, prompts)
+	}
+}
+
+func TestAuthPrompt_Username(t *testing.T) {
+	server, client := net.Pipe()
+	defer func() { _ = client.Close() }()
+
+	done := make(chan string, 1)
+	go func() {
+		defer func() { _ = server.Close() }()
+		defer close(done)
+		username, password, err := readPlainCredentials(server)
+		if err == nil {
+			done <- username + ":" + password
+		}
+	}()
+
+	var got AuthPrompt
+	ctx := withAuthPrompt(context.Background(), func(ctx context.Context, p AuthPrompt) (string, []byte, error) {
+		got = p
+		return "entered", []byte("secret"), nil
+	}, AuthPrompt{Method: "Plain", Attempt: 1})
+
+	if err := (&PlainAuth{Username: "static"}).Handshake(ctx, client); err != nil {
+		t.Fatalf("Handshake() error = %v", err)
+	}
+	if creds := <-done; creds != "entered:secret" {
+		t.Errorf("credentials = %q, want entered:secret", creds)
+	}
+	if !got.NeedUsername {
+		t.Error("prompt did not ask for a username")
+	}
+}
+
+func TestAuthPrompt_Error(t *testing.T) {
+	cause := errors.New("dialog dismissed")
+	ctx := withAuthPrompt(context.Background(), func(ctx context.Context, p AuthPrompt) (string, []byte, error) {
+		return "", nil, cause
+	}, AuthPrompt{Attempt: 1})
+
+	err := (&PasswordAuth{}).Handshake(ctx, nil)
+	if !IsVNCError(err, ErrAuthentication) || !errors.Is(err, cause) {
+		t.Errorf("Handshake() error = %v, want authentication error wrapping %v", err, cause)
+	}
+}
+
+func TestAuthPrompt_RegistryValidation(t *testing.T) {
+	registry := NewAuthRegistry()
+	if err := registry.validateAuthMethod(&PasswordAuth{}, true); err != nil {
+		t.Errorf("validateAuthMethod() with a prompt error = %v", err)
+	}
+	if err := registry.validateAuthMethod(&PasswordAuth{}, false); !IsVNCError(err, ErrValidation) {
+		t.Errorf("validateAuthMethod() without a prompt error = %v, want validation error", err)
+	}
+
+	serverConn, clientConn := net.Pipe()
+	go func() {
+		defer func() { _ = serverConn.Close() }()
+		_ = servePasswordLogin(serverConn, "letmein", "bad password")
+	}()
+
+	conn, err := ClientWithOptions(context.Background(), clientConn,
+		WithAuth(&PasswordAuth{}), WithAuthRegistry(registry),
+		WithAuthPrompt(func(ctx context.Context, p AuthPrompt) (string, []byte, error) {
+			return "", []byte("letmein"), nil
+		}))
+	if err != nil {
+		t.Fatalf("ClientWithOptions() error = %v", err)
+	}
+	_ = conn.Close()
+}
@@ -0,0 +1,27 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package vnc
+
+import "time"
+
+// WithAuthRetry lets a Session walk several credentials for one server. The
+// handshake only uses the first Auth method whose security type the server
+// offers; when the server rejects its credentials, the Session reconnects
+// and tries the next method of that type, until one is accepted or none is
+// left. It waits backoff before the first retry and doubles the wait before
+// each further one, since servers such as TigerVNC blacklist clients that
+// fail authentication in quick succession:
+//
+//	session := vnc.NewSession(dial,
+//		vnc.WithAuth(vnc.NewPasswordAuth(current), vnc.NewPasswordAuth(previous)),
+//		vnc.WithAuthRetry(time.Second))
+//
+// Clients created directly with ClientWithOptions are not retried, since
+// they are handed a single connection.
+func WithAuthRetry(backoff time.Duration) ClientOption {
+	return func(cfg *ClientConfig) {
+		cfg.AuthRetry = true
+		cfg.AuthRetryBackoff = backoff
+	}
+}
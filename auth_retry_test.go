@@ -0,0 +1,94 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package vnc
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestAuthRetry_WalksPasswords(t *testing.T) {
+	tests := []struct {
+		name      string
+		options   []ClientOption
+		wantDials int
+		wantErr   bool
+	}{
+		{
+			name: "third password accepted",
+			options: []ClientOption{
+				WithAuth(NewPasswordAuth("old"), NewPasswordAuth("older"), NewPasswordAuth("letmein")),
+				WithAuthRetry(time.Millisecond),
+			},
+			wantDials: 3,
+		},
+		{
+			name: "all passwords rejected",
+			options: []ClientOption{
+				WithAuth(NewPasswordAuth("old"), NewPasswordAuth("older")),
+				WithAuthRetry(0),
+			},
+			wantDials: 2,
+			wantErr:   true,
+		},
+		{
+			name: "retry not enabled",
+			options: []ClientOption{
+				WithAuth(NewPasswordAuth("old"), NewPasswordAuth("letmein")),
+			},
+			wantDials: 1,
+			wantErr:   true,
+		},
+		{
+			name: "no other method of the type",
+			options: []ClientOption{
+				WithAuth(NewPasswordAuth("old"), &ClientAuthNone{}),
+				WithAuthRetry(0),
+			},
+			wantDials: 1,
+			wantErr:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dials := 0
+			session := NewSession(passwordLoginDialer("letmein", "bad password", &dials), tt.options...)
+			defer session.Close()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			_, err := session.Client(ctx)
+			var failure *AuthFailureError
+			if tt.wantErr != errors.As(err, &failure) {
+				t.Fatalf("Client() error = %v, want AuthFailureError %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && err != nil {
+				t.Fatalf("Client() error = %v", err)
+			}
+			if dials != tt.wantDials {
+				t.Errorf("dials = %d, want %d", dials, tt.wantDials)
+			}
+		})
+	}
+}
+
+func TestAuthRetry_BackoffCancelled(t *testing.T) {
+	dials := 0
+	session := NewSession(passwordLoginDialer("letmein", "bad password", &dials),
+		WithAuth(NewPasswordAuth("old"), NewPasswordAuth("letmein")),
+		WithAuthRetry(time.Hour))
+	defer session.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := session.Client(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Client() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if dials != 1 {
+		t.Errorf("dials = %d, want 1", dials)
+	}
+}
@@ -0,0 +1,51 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package vnc
+
+import (
+	"sync"
+	"time"
+)
+
+// bellThrottle suppresses bells that arrive within the configured interval of
+// the last delivered bell.
+type bellThrottle struct {
+	mu   sync.Mutex
+	last time.Time
+}
+
+// suppressMessage reports whether msg must not be delivered: a bell within the
+// configured BellInterval of the last one, an empty ServerCutText sent as a
+// keep-alive under QuirkCutTextKeepAlive, or the answer to a fence sent by
+// WithKeepalive. Suppressed bells are counted in Stats.SuppressedBells.
+func (c *ClientConn) suppressMessage(msg ServerMessage) bool {
+	if isKeepaliveAnswer(msg) {
+		return true
+	}
+	if cut, ok := msg.(*ServerCutTextMessage); ok {
+		if cut.Text != "" || !c.hasQuirk(QuirkCutTextKeepAlive) {
+			return false
+		}
+		c.logger.Debug("Dropping empty cut text sent as keep-alive")
+		return true
+	}
+	if _, ok := msg.(*BellMessage); !ok || c.config == nil || c.config.BellInterval <= 0 {
+		return false
+	}
+
+	now := time.Now()
+	c.bells.mu.Lock()
+	suppress := !c.bells.last.IsZero() && now.Sub(c.bells.last) < c.config.BellInterval
+	if !suppress {
+		c.bells.last = now
+	}
+	c.bells.mu.Unlock()
+
+	if suppress {
+		c.stats.mu.Lock()
+		c.stats.suppressedBells++
+		c.stats.mu.Unlock()
+	}
+	return suppress
+}
@@ -0,0 +1,40 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+//go:build !vnc_minimal
+
+package vnc
+
+// MinimalBuild reports whether the package was compiled with the vnc_minimal
+// build tag. Full builds include every encoding and optional subsystem.
+const MinimalBuild = false
+
+// compressedEncodings returns the compressed encodings compiled into the
+// package, most efficient first, for presets to offer ahead of the simple
+// encodings.
+func compressedEncodings() []Encoding {
+	return []Encoding{&TightEncoding{}, &TightPNGEncoding{}, &ZRLEEncoding{}, &ZlibEncoding{}, &TRLEEncoding{}}
+}
+
+// streamingDecoder returns the type of an encoding whose decoder holds state
+// spanning rectangles, such as a zlib stream that has started, which Detach
+// cannot hand over. It is called with the pump held.
+func (c *ClientConn) streamingDecoder() (int32, bool) {
+	for encodingType, state := range c.decoders {
+		live := false
+		switch s := state.(type) {
+		case *zlibStream:
+			live = s.reader != nil
+		case *tightState:
+			for i := range s.streams {
+				live = live || s.streams[i].reader != nil
+			}
+		case *h264State:
+			live = len(s.contexts) > 0
+		}
+		if live {
+			return encodingType, true
+		}
+	}
+	return 0, false
+}
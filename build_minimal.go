@@ -0,0 +1,23 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+//go:build vnc_minimal
+
+package vnc
+
+// MinimalBuild reports whether the package was compiled with the vnc_minimal
+// build tag. Minimal builds keep the handshake, input events, and Raw decoding
+// while excluding heavyweight optional subsystems to reduce binary size.
+const MinimalBuild = true
+
+// compressedEncodings returns the compressed encodings compiled into the
+// package; minimal builds have none.
+func compressedEncodings() []Encoding {
+	return nil
+}
+
+// streamingDecoder reports no decoder holding state spanning rectangles, as
+// minimal builds have no streaming decoders.
+func (c *ClientConn) streamingDecoder() (int32, bool) {
+	return 0, false
+}
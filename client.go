@@ -6,16 +6,24 @@ package vnc
 import (
 	"bytes"
 	"context"
+	"crypto/rand"
 	"encoding/binary"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"image"
 	"io"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/tenthirtyam/go-vnc/rfb"
 )
 
 // ButtonMask represents the state of pointer buttons in a VNC pointer event.
-type ButtonMask uint8
+// Buttons above Button8 require the ExtendedMouseButtons pseudo-encoding.
+type ButtonMask uint16
 
 // Button mask constants for standard mouse buttons and scroll wheel events.
 const (
@@ -27,6 +35,20 @@ const (
 	Button6
 	Button7
 	Button8
+	Button9
+)
+
+// Named aliases for the scroll wheel and side buttons, following X11 numbering.
+// Button4 through Button7 scroll up, down, left, and right; ButtonBack and
+// ButtonForward are the side buttons and are sent in the extended format when
+// the server supports it.
+const (
+	ButtonScrollUp    = Button4
+	ButtonScrollDown  = Button5
+	ButtonScrollLeft  = Button6
+	ButtonScrollRight = Button7
+	ButtonBack        = Button8
+	ButtonForward     = Button9
 )
 
 // VNC protocol constants.
@@ -68,25 +90,132 @@ type ClientConn struct {
 	ctx    context.Context
 	cancel context.CancelFunc
 
-	// Mutex for protecting concurrent access to connection state
-	mu sync.RWMutex
+	// Snapshot of the negotiated connection state, read without locking and
+	// replaced under stateMu
+	state   atomic.Pointer[connState]
+	stateMu contentionMutex
+	// deprecatedWarned records, under stateMu, that changes to the
+	// deprecated exported fields have been logged
+	deprecatedWarned bool
+
+	// Connection context attached to errors returned by this connection,
+	// with the phase guarded by mu
+	mu     sync.RWMutex
+	connID string
+	phase  Phase
+
+	// Server message processing state shared by mainLoop and ProcessNextMessage
+	pumpMu       contentionMutex
+	messageTypes map[uint8]ServerMessage
+	pendingType  chan messageTypeResult
+
+	// Closed by Detach to stop the pump at a message boundary, and replaced
+	// under pumpMu when Detach fails; detachMu is held for the whole of Detach
+	detaching chan struct{}
+	detachMu  sync.Mutex
+
+	// Statistics returned by Stats
+	stats connStats
+
+	// Client-side framebuffer used by Screenshot and CaptureRegion
+	capture capture
+
+	// Recent frames returned by RecentFrames
+	history frameHistory
+
+	// Minor version of RFB 3 negotiated during the handshake
+	protocolMinor uint
+
+	// Messages read before the initial settings were sent, delivered first
+	early []ServerMessage
+
+	// Serializes writes, which share the connection's write deadline
+	writeMu sync.Mutex
+
+	// Set once the server confirms the ExtendedMouseButtons pseudo-encoding
+	extendedMouseButtons atomic.Bool
+
+	// Set once the server confirms the QEMU Extended Key Event pseudo-encoding
+	qemuExtendedKeyEvents atomic.Bool
+
+	// Set once the server sends its first Fence message
+	fence atomic.Bool
+
+	// Set once the server confirms the ContinuousUpdates pseudo-encoding
+	continuousUpdates atomic.Bool
+
+	// Set while continuous updates are enabled, until the server ends them
+	continuousUpdatesActive atomic.Bool
+
+	// Set once the connection has reported another client sharing the
+	// desktop, and once it has reported the server closing the connection.
+	otherClient        atomic.Bool
+	serverDisconnected atomic.Bool
+
+	// Bell rate limiting configured by BellInterval
+	bells bellThrottle
+
+	// Frame pacing configured by MaxFrameRate
+	pacer framePacer
+
+	// Time of the last message sent other than a keepalive, in Unix
+	// nanoseconds, and whether IdleDisconnect closed the connection
+	lastActivity atomic.Int64
+	idleClosed   atomic.Bool
+
+	// Byte order mismatch detection, used by the decoding goroutine
+	endianness endiannessProbe
+
+	// State of stateful decoders keyed by encoding type, used by the
+	// decoding goroutine
+	decoders map[int32]any
+
+	// Sanitized record of the connection returned by DebugBundle
+	transcript transcript
+
+	// Active session recording, if any
+	recorder atomic.Pointer[recorderHook]
+
+	// Goroutines owned by the connection, awaited by CloseAndWait
+	wg      sync.WaitGroup
+	closeMu sync.Mutex
+	closing bool
+
+	// The exported fields below mirror the connection state for compatibility
+	// with code written against earlier versions. They are updated while the
+	// connection processes server messages, so reading them concurrently is a
+	// data race, and the library ignores changes made to them. The first
+	// change found when the library next updates the state is logged as a
+	// warning.
 
 	// ColorMap contains the color map for indexed color modes.
+	//
+	// Deprecated: Use GetColorMap, which is safe for concurrent use.
 	ColorMap [ColorMapSize]Color
 
 	// Encs contains the list of encodings supported by this client.
+	//
+	// Deprecated: Use GetEncodings, which is safe for concurrent use.
 	Encs []Encoding
 
 	// FrameBufferWidth is the width of the remote framebuffer in pixels.
+	//
+	// Deprecated: Use GetFrameBufferSize, which is safe for concurrent use.
 	FrameBufferWidth uint16
 
 	// FrameBufferHeight is the height of the remote framebuffer in pixels.
+	//
+	// Deprecated: Use GetFrameBufferSize, which is safe for concurrent use.
 	FrameBufferHeight uint16
 
 	// DesktopName is the human-readable name of the desktop.
+	//
+	// Deprecated: Use GetDesktopName, which is safe for concurrent use.
 	DesktopName string
 
 	// PixelFormat describes the format of pixel data used in this connection.
+	//
+	// Deprecated: Use GetPixelFormat, which is safe for concurrent use.
 	PixelFormat PixelFormat
 }
 
@@ -98,6 +227,10 @@ type ClientConfig struct {
 	// Exclusive determines whether this client requests exclusive access.
 	Exclusive bool
 
+	// SharingEvents, if set, receives the observable outcomes of the shared
+	// or exclusive access requested. See WithSharingEvents.
+	SharingEvents chan<- SharingEvent
+
 	// ServerMessageCh is the channel where server messages will be delivered.
 	ServerMessageCh chan<- ServerMessage
 
@@ -110,17 +243,150 @@ type ClientConfig struct {
 	// AuthRegistry specifies the authentication registry to use.
 	AuthRegistry *AuthRegistry
 
+	// AuthPrompt, if set, supplies the credentials of authentication methods
+	// configured without them. See WithAuthPrompt.
+	AuthPrompt AuthPromptFunc
+
+	// AuthAudit, if set, is called with each step of the security
+	// handshake. See WithAuthAudit.
+	AuthAudit func(AuthEvent)
+
+	// KeepCredentials keeps the passwords of the Auth methods after the
+	// handshake, which otherwise clears them. See WithKeepCredentials.
+	KeepCredentials bool
+
+	// MinSecurity is the lowest security level the handshake accepts. See
+	// WithMinSecurity. The zero value, AllowNone, accepts every level.
+	MinSecurity SecurityLevel
+
+	// AuthRetry makes a Session reconnect with the next Auth method of the
+	// same security type when the server rejects the credentials of one,
+	// waiting AuthRetryBackoff before the first retry and twice as long
+	// before each further one. See WithAuthRetry.
+	AuthRetry        bool
+	AuthRetryBackoff time.Duration
+
+	// EncodingRegistry specifies custom encodings to advertise and decode.
+	EncodingRegistry *EncodingRegistry
+
 	// ConnectTimeout specifies the timeout for the initial connection handshake.
+	// It bounds the handshake only; the established connection is not subject
+	// to it.
 	ConnectTimeout time.Duration
 
-	// ReadTimeout specifies the timeout for individual read operations.
+	// AuthTimeout specifies the timeout for the security handshake, from the
+	// start of the authentication method to the SecurityResult. It applies
+	// within ConnectTimeout, which still bounds the whole handshake.
+	AuthTimeout time.Duration
+
+	// ReadTimeout specifies the timeout for individual read operations during
+	// the handshake and within a server message once its first byte has
+	// arrived. It does not apply while waiting for the next server message, so
+	// sessions on an unchanging desktop stay open; detect dead peers with
+	// WithKeepalive instead.
 	ReadTimeout time.Duration
 
-	// WriteTimeout specifies the timeout for individual write operations.
+	// WriteTimeout specifies the timeout for individual write operations. A
+	// write that times out closes the connection, since the server may have
+	// received a partial message.
 	WriteTimeout time.Duration
 
 	// Metrics specifies the metrics collector to use for connection monitoring.
 	Metrics MetricsCollector
+
+	// ManualPump disables the background message processing goroutine. Server
+	// messages are then read only when the application calls ProcessNextMessage.
+	ManualPump bool
+
+	// GestureTiming controls the pacing of Click, DoubleClick, and Drag.
+	// Zero fields use the defaults.
+	GestureTiming GestureTiming
+
+	// BellInterval is the minimum time between delivered BellMessages. Bells
+	// arriving sooner after the last delivered one are dropped and counted in
+	// Stats.SuppressedBells. Zero delivers every bell.
+	BellInterval time.Duration
+
+	// MaxFrameRate caps the framebuffer updates requested and delivered per
+	// second. See WithMaxFrameRate. Zero disables pacing.
+	MaxFrameRate float64
+
+	// IdleDisconnect closes the connection once no message has been sent
+	// to the server for this long. See WithIdleDisconnect. Zero keeps idle
+	// connections open.
+	IdleDisconnect time.Duration
+
+	// KeepaliveInterval and KeepaliveStrategy send a keepalive whenever no
+	// message has been sent to the server for the interval. See
+	// WithKeepalive. A zero interval disables keepalives.
+	KeepaliveInterval time.Duration
+	KeepaliveStrategy KeepaliveStrategy
+
+	// InitialEncodings, if set, are sent with SetEncodings as soon as the
+	// handshake completes.
+	InitialEncodings []Encoding
+
+	// PixelFormat, if set, is requested with SetPixelFormat as soon as the
+	// handshake completes, before any framebuffer update is requested.
+	PixelFormat *PixelFormat
+
+	// AutoFullUpdate requests a non-incremental update of the whole
+	// framebuffer once the pixel format and encodings have been sent, so the
+	// first frame arrives without further calls.
+	AutoFullUpdate bool
+
+	// SecurityPreference ranks the Auth methods by security type during
+	// negotiation. Methods whose type is not listed follow the listed ones in
+	// their original order.
+	SecurityPreference []uint8
+
+	// Quirks enables workarounds for known server deviations from RFC 6143.
+	Quirks Quirks
+
+	// LowPower makes the client framebuffer convert pixels with shifts
+	// instead of divisions, trading a small loss of color accuracy for speed
+	// on processors without hardware division. See WithLowPowerProfile.
+	LowPower bool
+
+	// PixelEndianness forces the byte order used to decode pixels, for
+	// servers whose pixel format declares the wrong one. The default,
+	// PixelEndiannessAuto, follows the pixel format and logs a warning when
+	// the decoded pixels suggest that it is wrong.
+	PixelEndianness PixelEndianness
+
+	// VerifyCopyRect hashes the source of every CopyRect rectangle before the
+	// copy and the destination after it, and logs a warning and counts
+	// Stats.CopyRectMismatches when they disagree. It maintains the client
+	// framebuffer from the start of the session, at the cost of one extra
+	// pass over each copied area. It is meant for diagnosing corruption such
+	// as smeared window drags.
+	VerifyCopyRect bool
+
+	// FrameHistory and FrameHistoryWindow keep recent frames for
+	// RecentFrames: at most FrameHistory frames, none older than
+	// FrameHistoryWindow. Either enables the history, which maintains the
+	// client framebuffer from the start of the session. See
+	// WithFrameHistory.
+	FrameHistory       int
+	FrameHistoryWindow time.Duration
+
+	// Rotation presents the desktop rotated clockwise, for servers whose
+	// framebuffer is stored sideways relative to the physical screen. Frames,
+	// capture regions, and pointer coordinates are in the rotated display
+	// coordinates reported by DisplaySize; server messages and their
+	// rectangles stay in framebuffer coordinates.
+	Rotation Rotation
+
+	// Elements names screen regions for LocateElement and ClickElement.
+	Elements *ElementMap
+
+	// MessageCatalog localizes the text returned by VNCError.UserMessage for
+	// errors returned by the connection.
+	MessageCatalog MessageCatalog
+
+	// ProtocolVersion, if set, is the protocol version the client negotiates
+	// instead of RFB 3.8, such as "RFB 003.003". See WithProtocolVersion.
+	ProtocolVersion string
 }
 
 // ClientOption represents a functional option for configuring a VNC client connection.
@@ -142,6 +408,15 @@ func WithAuthRegistry(registry *AuthRegistry) ClientOption {
 	}
 }
 
+// WithEncodingRegistry sets a registry of custom encodings for the client.
+// SetEncodings advertises the registered encodings and updates decode
+// rectangles of their types with them.
+func WithEncodingRegistry(registry *EncodingRegistry) ClientOption {
+	return func(cfg *ClientConfig) {
+		cfg.EncodingRegistry = registry
+	}
+}
+
 // WithExclusive sets whether the client should request exclusive access to the server.
 // When true, other clients will be disconnected when this client connects.
 func WithExclusive(exclusive bool) ClientOption {
@@ -160,6 +435,9 @@ func WithLogger(logger Logger) ClientOption {
 
 // WithServerMessageChannel sets the channel where server messages will be delivered.
 // The channel should be buffered to prevent blocking the message processing loop.
+// Messages are delivered in the order the server sent them, after the
+// connection has applied them; see the package documentation for the
+// ordering of resizes, color map changes, and updates.
 func WithServerMessageChannel(ch chan<- ServerMessage) ClientOption {
 	return func(cfg *ClientConfig) {
 		cfg.ServerMessageCh = ch
@@ -176,14 +454,29 @@ func WithServerMessages(messages ...ServerMessage) ClientOption {
 
 // WithConnectTimeout sets the timeout for the initial connection handshake.
 // This includes protocol negotiation, security handshake, and initialization.
+// The connection is not closed once the timeout elapses after a successful
+// handshake.
 func WithConnectTimeout(timeout time.Duration) ClientOption {
 	return func(cfg *ClientConfig) {
 		cfg.ConnectTimeout = timeout
 	}
 }
 
+// WithAuthTimeout sets the timeout for the security handshake alone, so that
+// authentication methods backed by slow services, such as SASL with Kerberos
+// or MS-Logon against a domain controller, can be given more or less time
+// than the rest of the handshake. A handshake that exceeds it fails with an
+// ErrTimeout error.
+func WithAuthTimeout(timeout time.Duration) ClientOption {
+	return func(cfg *ClientConfig) {
+		cfg.AuthTimeout = timeout
+	}
+}
+
 // WithReadTimeout sets the timeout for individual read operations.
-// This applies to reading server messages and framebuffer data.
+// This applies to handshake reads and to reading the body of server messages
+// and framebuffer data. Waiting for the next server message is never timed
+// out, as servers legitimately stay silent while the desktop is unchanged.
 func WithReadTimeout(timeout time.Duration) ClientOption {
 	return func(cfg *ClientConfig) {
 		cfg.ReadTimeout = timeout
@@ -215,6 +508,95 @@ func WithMetrics(metrics MetricsCollector) ClientOption {
 	}
 }
 
+// WithManualPump disables the background message processing goroutine so that
+// the application drives message processing by calling ProcessNextMessage from
+// its own event loop.
+func WithManualPump(enabled bool) ClientOption {
+	return func(cfg *ClientConfig) {
+		cfg.ManualPump = enabled
+	}
+}
+
+// WithGestureTiming sets the pacing of composite gestures such as DoubleClick.
+func WithGestureTiming(timing GestureTiming) ClientOption {
+	return func(cfg *ClientConfig) {
+		cfg.GestureTiming = timing
+	}
+}
+
+// WithBellThrottle limits delivered BellMessages to one per interval, for
+// servers that ring the bell many times per second.
+func WithBellThrottle(interval time.Duration) ClientOption {
+	return func(cfg *ClientConfig) {
+		cfg.BellInterval = interval
+	}
+}
+
+// WithInitialEncodings sets the encodings sent to the server once the
+// handshake completes, in order of preference.
+func WithInitialEncodings(encodings ...Encoding) ClientOption {
+	return func(cfg *ClientConfig) {
+		cfg.InitialEncodings = encodings
+	}
+}
+
+// WithPixelFormat sets the pixel format requested once the handshake completes.
+func WithPixelFormat(format *PixelFormat) ClientOption {
+	return func(cfg *ClientConfig) {
+		cfg.PixelFormat = format
+	}
+}
+
+// WithAutoFullUpdate requests the whole framebuffer once the handshake and the
+// initial settings are complete, replacing the FramebufferUpdateRequest every
+// viewer otherwise sends before its first frame.
+func WithAutoFullUpdate(enabled bool) ClientOption {
+	return func(cfg *ClientConfig) {
+		cfg.AutoFullUpdate = enabled
+	}
+}
+
+// WithForcePixelEndianness decodes pixels in the given byte order regardless
+// of the byte order declared by the pixel format. The pixel format sent to and
+// reported by the server is unchanged.
+func WithForcePixelEndianness(order PixelEndianness) ClientOption {
+	return func(cfg *ClientConfig) {
+		cfg.PixelEndianness = order
+	}
+}
+
+// WithCopyRectVerification enables the CopyRect consistency checks described
+// by ClientConfig.VerifyCopyRect.
+func WithCopyRectVerification(enabled bool) ClientOption {
+	return func(cfg *ClientConfig) {
+		cfg.VerifyCopyRect = enabled
+	}
+}
+
+// WithSecurityPreference ranks the configured authentication methods by
+// security type, most preferred first, independently of the order in which
+// they were passed to WithAuth.
+func WithSecurityPreference(securityTypes ...uint8) ClientOption {
+	return func(cfg *ClientConfig) {
+		cfg.SecurityPreference = securityTypes
+	}
+}
+
+// WithQuirks enables workarounds for known server deviations from RFC 6143.
+func WithQuirks(quirks Quirks) ClientOption {
+	return func(cfg *ClientConfig) {
+		cfg.Quirks |= quirks
+	}
+}
+
+// WithMessageCatalog sets the catalog used by VNCError.UserMessage for errors
+// returned by the connection.
+func WithMessageCatalog(catalog MessageCatalog) ClientOption {
+	return func(cfg *ClientConfig) {
+		cfg.MessageCatalog = catalog
+	}
+}
+
 // Client establishes a VNC client connection with the provided configuration.
 // Performs complete handshake and starts background message processing.
 //
@@ -225,6 +607,8 @@ func Client(c net.Conn, cfg *ClientConfig) (*ClientConn, error) {
 
 // ClientWithContext establishes a VNC client connection with context support.
 // Performs complete handshake including protocol negotiation, security, and initialization.
+// Cancelling ctx closes the connection; cfg.ConnectTimeout, if set, limits the
+// handshake alone.
 func ClientWithContext(ctx context.Context, c net.Conn, cfg *ClientConfig) (*ClientConn, error) {
 	// Initialize logger from config or use NoOpLogger as default
 	var logger Logger = &NoOpLogger{}
@@ -235,20 +619,52 @@ func ClientWithContext(ctx context.Context, c net.Conn, cfg *ClientConfig) (*Cli
 	// Create a cancellable context for this connection
 	connCtx, cancel := context.WithCancel(ctx)
 
+	// ConnectTimeout bounds the handshake only, not the connection lifetime.
+	handshakeCtx := connCtx
+	if cfg != nil && cfg.ConnectTimeout > 0 {
+		var cancelHandshake context.CancelFunc
+		handshakeCtx, cancelHandshake = context.WithTimeout(connCtx, cfg.ConnectTimeout)
+		defer cancelHandshake()
+	}
+
 	conn := &ClientConn{
 		c:      c,
 		config: cfg,
-		logger: logger,
 		ctx:    connCtx,
 		cancel: cancel,
+		connID: newConnID(),
+
+		detaching: make(chan struct{}),
+	}
+	conn.logger = &transcriptLogger{Logger: logger, transcript: &conn.transcript}
+
+	if err := conn.handshakeWithContext(handshakeCtx); err != nil {
+		err = conn.enrichError(err)
+		conn.Close()
+		return nil, err
+	}
+
+	conn.setPhase(PhaseSession)
+	conn.messageTypes = newServerMessageTypes(cfg)
+	conn.sendSharingEvent(SharingGranted)
+
+	if conn.hasQuirk(QuirkEarlyServerData) {
+		if err := conn.readEarlyMessages(); err != nil {
+			conn.Close()
+			return nil, err
+		}
 	}
 
-	if err := conn.handshakeWithContext(connCtx); err != nil {
+	if err := conn.applyInitialSettings(); err != nil {
 		conn.Close()
 		return nil, err
 	}
 
-	go conn.mainLoop()
+	if cfg == nil || !cfg.ManualPump {
+		conn.goTracked(conn.mainLoop)
+	}
+	conn.startIdleWatch()
+	conn.startKeepalive()
 
 	return conn, nil
 }
@@ -318,17 +734,46 @@ func ClientWithOptions(ctx context.Context, c net.Conn, options ...ClientOption)
 		option(cfg)
 	}
 
-	// Apply connect timeout to context if specified
-	if cfg.ConnectTimeout > 0 {
-		var cancel context.CancelFunc
-		ctx, cancel = context.WithTimeout(ctx, cfg.ConnectTimeout)
-		defer cancel()
-	}
-
 	// Use the existing ClientWithContext function with the configured options
 	return ClientWithContext(ctx, c, cfg)
 }
 
+// applyInitialSettings sends the configured pixel format and encodings, and
+// requests the first full update, once the handshake has completed.
+func (c *ClientConn) applyInitialSettings() error {
+	if c.config == nil {
+		return nil
+	}
+
+	if c.config.PixelFormat != nil {
+		if err := c.SetPixelFormat(c.config.PixelFormat); err != nil {
+			return err
+		}
+		// No update has been requested yet, so every update that follows
+		// uses the new format.
+		c.setPixelFormat(*c.config.PixelFormat)
+	}
+
+	if len(c.config.InitialEncodings) > 0 {
+		if err := c.SetEncodings(c.config.InitialEncodings); err != nil {
+			return err
+		}
+	}
+
+	if c.config.VerifyCopyRect || c.frameHistoryEnabled() {
+		c.enableFramebuffer()
+	}
+
+	if c.config.AutoFullUpdate {
+		width, height := c.GetFrameBufferSize()
+		if err := c.FramebufferUpdateRequest(false, 0, 0, width, height); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 // Close terminates the VNC connection and releases associated resources.
 // This method closes the underlying network connection, cancels the connection context,
 // and will cause the message processing goroutine to exit and close the server message channel.
@@ -354,15 +799,62 @@ func ClientWithOptions(ctx context.Context, c net.Conn, options ...ClientOption)
 //		log.Printf("Error closing VNC connection: %v", err)
 //	}
 func (c *ClientConn) Close() error {
+	// Release the decoders once the message being decoded is done; this only
+	// starts on the first call
+	c.goTracked(c.releaseDecoders)
+
+	// Stop accepting new goroutines so CloseAndWait can wait for the rest
+	c.closeMu.Lock()
+	c.closing = true
+	c.closeMu.Unlock()
+
 	// Cancel the context to signal all operations to stop
 	if c.cancel != nil {
 		c.cancel()
 	}
 
+	if hook := c.recorder.Load(); hook != nil {
+		_ = hook.stop()
+	}
+
 	// Close the network connection
 	return c.c.Close()
 }
 
+// CloseAndWait closes the connection like Close and then blocks until every
+// goroutine owned by the connection, including the message processing loop and
+// in-flight network operations, has exited. This gives deterministic teardown
+// for tests and short-lived programs.
+//
+// CloseAndWait must not be called from code running on the message processing
+// loop, such as a custom ServerMessage implementation, as it would wait for
+// itself.
+//
+// Returns:
+//   - error: Any error that occurred while closing the network connection
+func (c *ClientConn) CloseAndWait() error {
+	err := c.Close()
+	c.wg.Wait()
+	return err
+}
+
+// goTracked runs fn in a goroutine awaited by CloseAndWait. It reports false
+// without starting fn once the connection has been closed.
+func (c *ClientConn) goTracked(fn func()) bool {
+	c.closeMu.Lock()
+	defer c.closeMu.Unlock()
+	if c.closing {
+		return false
+	}
+
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		fn()
+	}()
+	return true
+}
+
 // CutText sends clipboard text from the client to the VNC server.
 // This method implements the ClientCutText message as defined in RFC 6143 Section 7.5.6,
 // allowing the client to share clipboard content with the remote desktop.
@@ -414,7 +906,7 @@ func (c *ClientConn) CutText(text string) error {
 		c.logger.Error("Invalid clipboard text",
 			Field{Key: "text_length", Value: len(text)},
 			Field{Key: "error", Value: err})
-		return validationError("CutText", "invalid clipboard text", err)
+		return c.enrichError(validationError("CutText", "invalid clipboard text", err))
 	}
 
 	// Sanitize the text to remove potentially dangerous characters
@@ -426,36 +918,21 @@ func (c *ClientConn) CutText(text string) error {
 		text = sanitizedText
 	}
 
-	var buf bytes.Buffer
-
-	// This is the fixed size data we'll send
-	fixedData := []interface{}{
-		uint8(6),
-		uint8(0),
-		uint8(0),
-		uint8(0),
-		uint32(len(text)), // #nosec G115 - len(text) was already validated by ValidateTextData
-	}
-
-	for _, val := range fixedData {
-		if err := binary.Write(&buf, binary.BigEndian, val); err != nil {
-			return networkError("CutText", "failed to write fixed data to buffer", err)
-		}
-	}
-
+	latin1 := make([]byte, 0, len(text))
 	for _, char := range text {
 		if char > Latin1MaxCodePoint {
-			return validationError("CutText", fmt.Sprintf("character '%c' is not valid Latin-1", char), nil)
+			return c.enrichError(validationError("CutText", fmt.Sprintf("character '%c' is not valid Latin-1", char), nil))
 		}
+		latin1 = append(latin1, byte(char)) //nolint:gosec // char is validated against Latin1MaxCodePoint above
+	}
 
-		if err := binary.Write(&buf, binary.BigEndian, uint8(char)); err != nil { //nolint:gosec // char is validated against Latin1MaxCodePoint above
-			return networkError("CutText", "failed to write character to buffer", err)
-		}
+	var buf bytes.Buffer
+	if err := rfb.WriteClientCutText(&buf, latin1); err != nil {
+		return c.enrichError(encodingError("CutText", "failed to encode cut text message", err))
 	}
 
-	dataLength := 8 + len(text)
-	if err := c.writeWithContext(c.ctx, buf.Bytes()[0:dataLength]); err != nil {
-		return networkError("CutText", "failed to send cut text message", err)
+	if err := c.writeWithContext(c.ctx, buf.Bytes()); err != nil {
+		return c.enrichError(networkError("CutText", "failed to send cut text message", err))
 	}
 
 	return nil
@@ -481,8 +958,8 @@ func (c *ClientConn) CutText(text string) error {
 // Example usage:
 //
 //	// Request full screen update (non-incremental)
-//	err := client.FramebufferUpdateRequest(false, 0, 0,
-//		client.FrameBufferWidth, client.FrameBufferHeight)
+//	width, height := client.GetFrameBufferSize()
+//	err := client.FramebufferUpdateRequest(false, 0, 0, width, height)
 //	if err != nil {
 //		log.Printf("Failed to request framebuffer update: %v", err)
 //	}
@@ -519,29 +996,24 @@ func (c *ClientConn) FramebufferUpdateRequest(incremental bool, x, y, width, hei
 		Field{Key: "width", Value: width},
 		Field{Key: "height", Value: height})
 
-	var buf bytes.Buffer
-	var incrementalByte uint8 = 0
-
-	if incremental {
-		incrementalByte = 1
-	}
-
-	data := []interface{}{
-		uint8(3),
-		incrementalByte,
-		x, y, width, height,
+	req := rfb.FramebufferUpdateRequest{Incremental: incremental, X: x, Y: y, Width: width, Height: height}
+	if c.holdUpdateRequest(req) {
+		c.logger.Debug("Holding framebuffer update request until the next frame")
+		return nil
 	}
+	return c.writeUpdateRequest(req)
+}
 
-	for _, val := range data {
-		if err := binary.Write(&buf, binary.BigEndian, val); err != nil {
-			c.logger.Error("Failed to write framebuffer request data to buffer", Field{Key: "error", Value: err})
-			return networkError("FramebufferUpdateRequest", "failed to write request data to buffer", err)
-		}
+// writeUpdateRequest sends a FramebufferUpdateRequest message.
+func (c *ClientConn) writeUpdateRequest(req rfb.FramebufferUpdateRequest) error {
+	var buf bytes.Buffer
+	if err := rfb.WriteFramebufferUpdateRequest(&buf, req); err != nil {
+		return c.enrichError(encodingError("FramebufferUpdateRequest", "failed to encode framebuffer update request", err))
 	}
 
-	if err := c.writeWithContext(c.ctx, buf.Bytes()[0:10]); err != nil {
+	if err := c.writeWithContext(c.ctx, buf.Bytes()); err != nil {
 		c.logger.Error("Failed to send framebuffer update request", Field{Key: "error", Value: err})
-		return networkError("FramebufferUpdateRequest", "failed to send framebuffer update request", err)
+		return c.enrichError(networkError("FramebufferUpdateRequest", "failed to send framebuffer update request", err))
 	}
 
 	return nil
@@ -623,37 +1095,22 @@ func (c *ClientConn) KeyEvent(keysym uint32, down bool) error {
 		c.logger.Error("Invalid keysym value",
 			Field{Key: "keysym", Value: keysym},
 			Field{Key: "error", Value: err})
-		return validationError("KeyEvent", "invalid keysym value", err)
+		return c.enrichError(validationError("KeyEvent", "invalid keysym value", err))
 	}
 
 	c.logger.Debug("Sending key event",
 		Field{Key: "keysym", Value: keysym},
 		Field{Key: "down", Value: down})
 
-	var downFlag uint8 = 0
-	if down {
-		downFlag = 1
-	}
-
-	data := []interface{}{
-		uint8(4),
-		downFlag,
-		uint8(0),
-		uint8(0),
-		keysym,
-	}
-
 	var buf bytes.Buffer
-	for _, val := range data {
-		if err := binary.Write(&buf, binary.BigEndian, val); err != nil {
-			c.logger.Error("Failed to write key event data to buffer", Field{Key: "error", Value: err})
-			return networkError("KeyEvent", "failed to write key event data to buffer", err)
-		}
+	if err := rfb.WriteKeyEvent(&buf, rfb.KeyEvent{Down: down, Key: keysym}); err != nil {
+		c.logger.Error("Failed to encode key event", Field{Key: "error", Value: err})
+		return c.enrichError(encodingError("KeyEvent", "failed to encode key event", err))
 	}
 
 	if err := c.writeWithContext(c.ctx, buf.Bytes()); err != nil {
 		c.logger.Error("Failed to send key event", Field{Key: "error", Value: err})
-		return networkError("KeyEvent", "failed to send key event", err)
+		return c.enrichError(networkError("KeyEvent", "failed to send key event", err))
 	}
 
 	return nil
@@ -732,22 +1189,41 @@ func (c *ClientConn) KeyEvent(keysym uint32, down bool) error {
 //		return c.PointerEvent(0, x, y)
 //	}
 //
+// Extended buttons:
+// ButtonBack (Button8) and ButtonForward (Button9) are sent in the extended
+// format once the server has confirmed ExtendedMouseButtonsPseudoEncoding. Until
+// then, Button8 is sent in the standard mask and Button9 returns an
+// UnsupportedError.
+//
+//	client.SetEncodings([]Encoding{&RawEncoding{}, &ExtendedMouseButtonsPseudoEncoding{}})
+//	// ... after the server's confirmation has been processed:
+//	client.PointerEvent(ButtonBack, x, y)
+//	client.PointerEvent(0, x, y)
+//
 // Coordinate system:
 // Mouse coordinates are relative to the framebuffer origin (0,0) at the top-left corner.
 // Valid coordinates range from (0,0) to (FrameBufferWidth-1, FrameBufferHeight-1).
 // Coordinates outside this range may be clamped or ignored by the server.
+// With a Rotation configured, coordinates are in display coordinates (see
+// DisplaySize) and are rotated back before they are sent.
 func (c *ClientConn) PointerEvent(mask ButtonMask, x, y uint16) error {
 	// Validate pointer coordinates for security
 	validator := newInputValidator()
-	width, height := c.GetFrameBufferSize()
+	width, height := c.DisplaySize()
 	if err := validator.ValidatePointerPosition(x, y, width, height); err != nil {
 		c.logger.Error("Invalid pointer coordinates",
 			Field{Key: "x", Value: x},
 			Field{Key: "y", Value: y},
-			Field{Key: "framebuffer_width", Value: c.FrameBufferWidth},
-			Field{Key: "framebuffer_height", Value: c.FrameBufferHeight},
+			Field{Key: "framebuffer_width", Value: width},
+			Field{Key: "framebuffer_height", Value: height},
 			Field{Key: "error", Value: err})
-		return validationError("PointerEvent", "invalid pointer coordinates", err)
+		return c.enrichError(validationError("PointerEvent", "invalid pointer coordinates", err))
+	}
+
+	if r := c.displayRotation(); r != Rotate0 {
+		fbWidth, fbHeight := c.GetFrameBufferSize()
+		p := rotatedPoint(image.Pt(int(x), int(y)), image.Pt(int(fbWidth), int(fbHeight)), r)
+		x, y = uint16(p.X), uint16(p.Y) // #nosec G115 - p is within the framebuffer, whose dimensions are uint16
 	}
 
 	c.logger.Debug("Sending pointer event",
@@ -756,24 +1232,24 @@ func (c *ClientConn) PointerEvent(mask ButtonMask, x, y uint16) error {
 		Field{Key: "y", Value: y})
 
 	var buf bytes.Buffer
-
-	data := []interface{}{
-		uint8(5),
-		uint8(mask),
-		x,
-		y,
+	var err error
+	switch {
+	case c.extendedMouseButtons.Load():
+		err = rfb.WriteExtendedPointerEvent(&buf, rfb.ExtendedPointerEvent{Mask: uint16(mask), X: x, Y: y})
+	case mask > 0xff:
+		return c.enrichError(unsupportedError("PointerEvent",
+			fmt.Sprintf("button mask 0x%x requires the ExtendedMouseButtons pseudo-encoding", uint16(mask)), nil))
+	default:
+		err = rfb.WritePointerEvent(&buf, rfb.PointerEvent{Mask: uint8(mask), X: x, Y: y}) // #nosec G115 - mask checked above
 	}
-
-	for _, val := range data {
-		if err := binary.Write(&buf, binary.BigEndian, val); err != nil {
-			c.logger.Error("Failed to write pointer event data to buffer", Field{Key: "error", Value: err})
-			return networkError("PointerEvent", "failed to write pointer event data to buffer", err)
-		}
+	if err != nil {
+		c.logger.Error("Failed to encode pointer event", Field{Key: "error", Value: err})
+		return c.enrichError(encodingError("PointerEvent", "failed to encode pointer event", err))
 	}
 
-	if err := c.writeWithContext(c.ctx, buf.Bytes()[0:6]); err != nil {
+	if err := c.writeWithContext(c.ctx, buf.Bytes()); err != nil {
 		c.logger.Error("Failed to send pointer event", Field{Key: "error", Value: err})
-		return networkError("PointerEvent", "failed to send pointer event", err)
+		return c.enrichError(networkError("PointerEvent", "failed to send pointer event", err))
 	}
 
 	return nil
@@ -859,7 +1335,18 @@ func (c *ClientConn) SetEncodings(encs []Encoding) error {
 		c.logger.Error("Too many encodings specified",
 			Field{Key: "count", Value: len(encs)},
 			Field{Key: "max", Value: maxEncodings})
-		return validationError("SetEncodings", fmt.Sprintf("too many encodings: %d (max %d)", len(encs), maxEncodings), nil)
+		return c.enrichError(validationError("SetEncodings", fmt.Sprintf("too many encodings: %d (max %d)", len(encs), maxEncodings), nil))
+	}
+
+	if c.config != nil && c.config.EncodingRegistry != nil {
+		merged, err := c.config.EncodingRegistry.withRegisteredEncodings(encs)
+		if err != nil {
+			return c.enrichError(err)
+		}
+		encs = merged
+	}
+	if c.hasQuirk(QuirkNoPseudoEncodings) {
+		encs = withoutPseudoEncodings(encs)
 	}
 
 	encodingTypes := make([]int32, len(encs))
@@ -872,7 +1359,7 @@ func (c *ClientConn) SetEncodings(encs []Encoding) error {
 				Field{Key: "index", Value: i},
 				Field{Key: "type", Value: encodingType},
 				Field{Key: "error", Value: err})
-			return validationError("SetEncodings", fmt.Sprintf("invalid encoding type at index %d", i), err)
+			return c.enrichError(validationError("SetEncodings", fmt.Sprintf("invalid encoding type at index %d", i), err))
 		}
 
 		encodingTypes[i] = encodingType
@@ -882,30 +1369,18 @@ func (c *ClientConn) SetEncodings(encs []Encoding) error {
 		Field{Key: "count", Value: len(encs)},
 		Field{Key: "types", Value: encodingTypes})
 
-	data := make([]interface{}, 3+len(encs))
-	data[0] = uint8(2)
-	data[1] = uint8(0)
-	data[2] = uint16(len(encs)) // #nosec G115 - len(encs) was already validated to be <= maxEncodings (100)
-
-	for i, enc := range encs {
-		data[3+i] = enc.Type()
-	}
-
 	var buf bytes.Buffer
-	for _, val := range data {
-		if err := binary.Write(&buf, binary.BigEndian, val); err != nil {
-			c.logger.Error("Failed to write encoding data to buffer", Field{Key: "error", Value: err})
-			return networkError("SetEncodings", "failed to write encoding data to buffer", err)
-		}
+	if err := rfb.WriteSetEncodings(&buf, encodingTypes); err != nil {
+		c.logger.Error("Failed to encode set encodings message", Field{Key: "error", Value: err})
+		return c.enrichError(encodingError("SetEncodings", "failed to encode set encodings message", err))
 	}
 
-	dataLength := 4 + (4 * len(encs))
-	if err := c.writeWithContext(c.ctx, buf.Bytes()[0:dataLength]); err != nil {
+	if err := c.writeWithContext(c.ctx, buf.Bytes()); err != nil {
 		c.logger.Error("Failed to send set encodings message", Field{Key: "error", Value: err})
-		return networkError("SetEncodings", "failed to send set encodings message", err)
+		return c.enrichError(networkError("SetEncodings", "failed to send set encodings message", err))
 	}
 
-	c.Encs = encs
+	c.setEncodings(encs)
 
 	return nil
 }
@@ -991,7 +1466,7 @@ func (c *ClientConn) SetPixelFormat(format *PixelFormat) error {
 		c.logger.Error("Invalid pixel format specified",
 			Field{Key: "pixel_format", Value: format},
 			Field{Key: "error", Value: err})
-		return validationError("SetPixelFormat", "invalid pixel format", err)
+		return c.enrichError(validationError("SetPixelFormat", "invalid pixel format", err))
 	}
 
 	c.logger.Info("Setting pixel format",
@@ -999,46 +1474,34 @@ func (c *ClientConn) SetPixelFormat(format *PixelFormat) error {
 		Field{Key: "depth", Value: format.Depth},
 		Field{Key: "true_color", Value: format.TrueColor})
 
-	var keyEvent [20]byte
-	keyEvent[0] = 0
-
-	pfBytes, err := writePixelFormat(format)
-	if err != nil {
-		return encodingError("SetPixelFormat", "failed to encode pixel format", err)
+	var buf bytes.Buffer
+	if err := rfb.WriteSetPixelFormat(&buf, wirePixelFormat(format)); err != nil {
+		return c.enrichError(encodingError("SetPixelFormat", "failed to encode pixel format", err))
 	}
 
-	// Copy the pixel format bytes into the proper slice location
-	copy(keyEvent[4:], pfBytes)
-
 	// Send the data down the connection
-	if err := c.writeWithContext(c.ctx, keyEvent[:]); err != nil {
-		return networkError("SetPixelFormat", "failed to send pixel format message", err)
+	if err := c.writeWithContext(c.ctx, buf.Bytes()); err != nil {
+		return c.enrichError(networkError("SetPixelFormat", "failed to send pixel format message", err))
 	}
 
 	// Reset the color map as according to RFC.
-	var newColorMap [256]Color
-	c.ColorMap = newColorMap
+	c.resetColorMap()
 
 	return nil
 }
 
-const pvLen = 12
+const pvLen = rfb.ProtocolVersionLength
 
 // parseProtocolVersion parses a VNC protocol version string.
 func parseProtocolVersion(pv []byte) (uint, uint, error) {
-	var major, minor uint
-
 	if len(pv) < pvLen {
 		return 0, 0, protocolError("parseProtocolVersion",
 			fmt.Sprintf("protocol version message too short (%v < %v)", len(pv), pvLen), nil)
 	}
 
-	l, err := fmt.Sscanf(string(pv), "RFB %d.%d\n", &major, &minor)
-	if l != 2 {
-		return 0, 0, protocolError("parseProtocolVersion", "invalid protocol version format", nil)
-	}
+	major, minor, err := rfb.ParseProtocolVersion(pv[:pvLen])
 	if err != nil {
-		return 0, 0, protocolError("parseProtocolVersion", "failed to parse protocol version", err)
+		return 0, 0, protocolError("parseProtocolVersion", "invalid protocol version format", err)
 	}
 
 	return major, minor, nil
@@ -1049,6 +1512,12 @@ func parseProtocolVersion(pv []byte) (uint, uint, error) {
 // and initialization while respecting context cancellation and timeouts.
 func (c *ClientConn) handshakeWithContext(ctx context.Context) error {
 	c.logger.Info("Starting VNC handshake")
+	c.setPhase(PhaseProtocolVersion)
+
+	// The credentials are cleared once the handshake has run, whether it
+	// succeeded or failed.
+	var auth ClientAuth
+	defer func() { c.clearCredentials(auth) }()
 
 	// Initialize input validator for security enhancements
 	validator := newInputValidator()
@@ -1079,42 +1548,54 @@ func (c *ClientConn) handshakeWithContext(ctx context.Context) error {
 		Field{Key: "major", Value: maxMajor},
 		Field{Key: "minor", Value: maxMinor})
 
-	if maxMajor < 3 {
-		c.logger.Error("Unsupported major version", Field{Key: "version", Value: maxMajor})
-		return unsupportedError("handshake", fmt.Sprintf("unsupported major version, less than 3: %d", maxMajor), nil)
-	}
-	if maxMinor < 8 {
-		c.logger.Error("Unsupported minor version", Field{Key: "version", Value: maxMinor})
-		return unsupportedError("handshake", fmt.Sprintf("unsupported minor version, less than 8: %d", maxMinor), nil)
+	minor, err := c.negotiateProtocolMinor(maxMajor, maxMinor)
+	if err != nil {
+		return err
 	}
+	c.protocolMinor = minor
 
 	// Respond with the version we will support
-	c.logger.Debug("Sending protocol version response: RFB 003.008")
-	if err = c.writeWithContext(ctx, []byte("RFB 003.008\n")); err != nil {
+	clientVersion := rfb.FormatProtocolVersion(3, minor)
+	c.logger.Debug("Sending protocol version response",
+		Field{Key: "version", Value: string(clientVersion[:pvLen-1])})
+	c.transcript.recordVersions(string(protocolVersion[:]), string(clientVersion))
+	if err = c.writeWithContext(ctx, clientVersion); err != nil {
 		c.logger.Error("Failed to send protocol version response", Field{Key: "error", Value: err})
 		return networkError("handshake", "failed to send protocol version response", err)
 	}
 
 	// 7.1.2 Security Handshake from server
+	c.setPhase(PhaseSecurity)
 	c.logger.Debug("Reading security types from server")
-	var numSecurityTypes uint8
-	if err = c.readBinaryWithContext(ctx, &numSecurityTypes); err != nil {
-		c.logger.Error("Failed to read number of security types", Field{Key: "error", Value: err})
-		return networkError("handshake", "failed to read number of security types", err)
-	}
+	audit := c.newAuthAudit()
+	var securityTypes []uint8
+	if minor < 7 {
+		// RFB 3.3 servers select the security type themselves.
+		securityType, err := c.readRFB33SecurityType(ctx)
+		if err != nil {
+			return audit.fail(err, "")
+		}
+		securityTypes = []uint8{securityType}
+	} else {
+		var numSecurityTypes uint8
+		if err = c.readBinaryWithContext(ctx, &numSecurityTypes); err != nil {
+			c.logger.Error("Failed to read number of security types", Field{Key: "error", Value: err})
+			return audit.fail(networkError("handshake", "failed to read number of security types", err), "")
+		}
 
-	if numSecurityTypes == 0 {
-		reason := c.readErrorReason()
-		c.logger.Error("No security types available", Field{Key: "reason", Value: reason})
-		return authenticationError("handshake", fmt.Sprintf("no security types available: %s", reason), nil)
-	}
+		if numSecurityTypes == 0 {
+			reason := c.readErrorReason()
+			c.logger.Error("No security types available", Field{Key: "reason", Value: reason})
+			return audit.fail(authenticationError("handshake", fmt.Sprintf("no security types available: %s", reason), nil), reason)
+		}
 
-	// numSecurityTypes is uint8, so it's already bounded to 0-255
+		// numSecurityTypes is uint8, so it's already bounded to 0-255
 
-	securityTypes := make([]uint8, numSecurityTypes)
-	if err = c.readBinaryWithContext(ctx, &securityTypes); err != nil {
-		c.logger.Error("Failed to read security types", Field{Key: "error", Value: err})
-		return networkError("handshake", "failed to read security types", err)
+		securityTypes = make([]uint8, numSecurityTypes)
+		if err = c.readBinaryWithContext(ctx, &securityTypes); err != nil {
+			c.logger.Error("Failed to read security types", Field{Key: "error", Value: err})
+			return audit.fail(networkError("handshake", "failed to read security types", err), "")
+		}
 	}
 
 	// Validate security types for security
@@ -1122,15 +1603,15 @@ func (c *ClientConn) handshakeWithContext(ctx context.Context) error {
 		c.logger.Error("Invalid security types received from server",
 			Field{Key: "types", Value: securityTypes},
 			Field{Key: "error", Value: err})
-		return protocolError("handshake", "server sent invalid security types", err)
+		return audit.fail(protocolError("handshake", "server sent invalid security types", err), "")
 	}
 
 	c.logger.Info("Received security types from server",
-		Field{Key: "count", Value: numSecurityTypes},
+		Field{Key: "count", Value: len(securityTypes)},
 		Field{Key: "types", Value: securityTypes})
+	audit.offered(securityTypes)
 
 	// Use AuthRegistry for authentication negotiation if available
-	var auth ClientAuth
 	var selectedSecurityType uint8
 
 	if c.config.AuthRegistry != nil {
@@ -1140,25 +1621,32 @@ func (c *ClientConn) handshakeWithContext(ctx context.Context) error {
 		// Create preferred order from Auth slice if provided
 		var preferredOrder []uint8
 		if c.config.Auth != nil {
-			preferredOrder = make([]uint8, len(c.config.Auth))
-			for i, authMethod := range c.config.Auth {
+			orderedAuth := c.orderedAuth()
+			preferredOrder = make([]uint8, len(orderedAuth))
+			for i, authMethod := range orderedAuth {
 				preferredOrder[i] = authMethod.SecurityType()
 			}
 		}
 
+		// Only the security types meeting MinSecurity are negotiated.
+		offered := c.config.AuthRegistry.securityTypesMeeting(securityTypes, c.config.MinSecurity)
+		if len(offered) == 0 {
+			return audit.fail(c.minSecurityError(securityTypes), "")
+		}
+
 		var err error
-		auth, selectedSecurityType, err = c.config.AuthRegistry.NegotiateAuth(ctx, securityTypes, preferredOrder)
+		auth, selectedSecurityType, err = c.config.AuthRegistry.NegotiateAuth(ctx, offered, preferredOrder)
 		if err != nil {
 			c.logger.Error("Authentication registry negotiation failed",
 				Field{Key: "server_types", Value: securityTypes},
 				Field{Key: "error", Value: err})
-			return authenticationError("handshake", "authentication negotiation failed", err)
+			return audit.fail(authenticationError("handshake", "authentication negotiation failed", err), "")
 		}
 	} else {
 		// Fall back to legacy authentication method selection
 		c.logger.Debug("Using legacy authentication method selection")
 
-		clientSecurityTypes := c.config.Auth
+		clientSecurityTypes := c.orderedAuth()
 		if clientSecurityTypes == nil {
 			clientSecurityTypes = []ClientAuth{new(ClientAuthNone)}
 		}
@@ -1166,7 +1654,7 @@ func (c *ClientConn) handshakeWithContext(ctx context.Context) error {
 	FindAuth:
 		for _, curAuth := range clientSecurityTypes {
 			for _, securityType := range securityTypes {
-				if curAuth.SecurityType() == securityType {
+				if curAuth.SecurityType() == securityType && authSecurityLevel(curAuth, securityType) >= c.config.MinSecurity {
 					// We use the first matching supported authentication
 					auth = curAuth
 					selectedSecurityType = securityType
@@ -1175,66 +1663,118 @@ func (c *ClientConn) handshakeWithContext(ctx context.Context) error {
 			}
 		}
 
+		if auth == nil && c.config.MinSecurity > AllowNone {
+			return audit.fail(c.minSecurityError(securityTypes), "")
+		}
 		if auth == nil {
 			c.logger.Error("No suitable authentication method found",
 				Field{Key: "server_types", Value: securityTypes})
-			return authenticationError("handshake", fmt.Sprintf("no suitable auth schemes found. server supported: %#v", securityTypes), nil)
+			return audit.fail(authenticationError("handshake", fmt.Sprintf("no suitable auth schemes found. server supported: %#v", securityTypes), nil), "")
 		}
 	}
 
+	c.transcript.recordSecurity(securityTypes, selectedSecurityType)
+
 	c.logger.Info("Selected authentication method",
 		Field{Key: "type", Value: selectedSecurityType},
 		Field{Key: "method", Value: auth.String()})
-
-	// Respond back with the security type we'll use
-	if err = c.writeBinaryWithContext(ctx, selectedSecurityType); err != nil {
-		c.logger.Error("Failed to send selected security type", Field{Key: "error", Value: err})
-		return networkError("handshake", "failed to send selected security type", err)
+	audit.selected(selectedSecurityType, auth)
+
+	// Respond back with the security type we'll use, which RFB 3.3 servers
+	// chose themselves
+	if minor >= 7 {
+		if err = c.writeBinaryWithContext(ctx, selectedSecurityType); err != nil {
+			c.logger.Error("Failed to send selected security type", Field{Key: "error", Value: err})
+			return audit.fail(networkError("handshake", "failed to send selected security type", err), "")
+		}
 	}
 
 	// Validate the authentication method before using it
 	if c.config.AuthRegistry != nil {
-		if err = c.config.AuthRegistry.ValidateAuthMethod(auth); err != nil {
+		if err = c.config.AuthRegistry.validateAuthMethod(auth, c.config.AuthPrompt != nil); err != nil {
 			c.logger.Error("Authentication method validation failed",
 				Field{Key: "type", Value: selectedSecurityType},
 				Field{Key: "method", Value: auth.String()},
 				Field{Key: "error", Value: err})
-			return authenticationError("handshake", "authentication method validation failed", err)
+			return audit.fail(authenticationError("handshake", "authentication method validation failed", err), "")
 		}
 	}
 
 	c.logger.Debug("Starting authentication handshake")
+	c.setPhase(PhaseAuthentication)
 
 	// Set logger for authentication method if it supports it
 	if authWithLogger, ok := auth.(interface{ SetLogger(Logger) }); ok {
 		authWithLogger.SetLogger(c.logger)
 	}
 
-	if err = auth.Handshake(ctx, c.c); err != nil {
+	// AuthTimeout bounds the security handshake, whose I/O the methods
+	// perform on the connection directly.
+	authCtx := ctx
+	if c.config.AuthTimeout > 0 {
+		var cancelAuth context.CancelFunc
+		authCtx, cancelAuth = context.WithTimeout(ctx, c.config.AuthTimeout)
+		defer cancelAuth()
+	}
+	authTimedOut := func() bool {
+		return authCtx.Err() != nil && ctx.Err() == nil
+	}
+
+	methodCtx := authCtx
+	if c.config.MinSecurity > AllowNone {
+		methodCtx = context.WithValue(methodCtx, minSecurityKey{}, c.config.MinSecurity)
+	}
+	methodCtx = withAuthPrompt(methodCtx, c.config.AuthPrompt,
+		AuthPrompt{SecurityType: selectedSecurityType, Method: auth.String(), Attempt: 1})
+	// Methods that wrap the connection, such as in TLS, continue the session
+	// on the wrapped connection.
+	secured := c.c
+	err = c.withDeadline(authCtx, 0, c.c.SetDeadline, func() error {
+		var err error
+		secured, err = AuthHandshake(methodCtx, auth, c.c)
+		return err
+	})
+	c.c = secured
+	if err != nil {
 		c.logger.Error("Authentication handshake failed",
 			Field{Key: "type", Value: selectedSecurityType},
 			Field{Key: "method", Value: auth.String()},
 			Field{Key: "error", Value: err})
-		return authenticationError("handshake", "authentication handshake failed", err)
-	}
-
-	// 7.1.3 SecurityResult Handshake
-	c.logger.Debug("Reading security result")
-	var securityResult uint32
-	if err = c.readBinaryWithContext(ctx, &securityResult); err != nil {
-		c.logger.Error("Failed to read security result", Field{Key: "error", Value: err})
-		return networkError("handshake", "failed to read security result", err)
-	}
+		if authTimedOut() {
+			return audit.fail(timeoutError("handshake", "authentication timed out", err), "")
+		}
+		return audit.fail(authenticationError("handshake", "authentication handshake failed", err), "")
+	}
+
+	// 7.1.3 SecurityResult Handshake. Before RFB 3.8 there is none for the
+	// None security type, and failures carry no reason.
+	securityResultExpected := minor >= 8 || selectedSecurityType != rfb.SecurityNone
+	if securityResultExpected {
+		c.logger.Debug("Reading security result")
+		var securityResult uint32
+		if err = c.readBinaryWithContext(authCtx, &securityResult); err != nil {
+			c.logger.Error("Failed to read security result", Field{Key: "error", Value: err})
+			if authTimedOut() {
+				return audit.fail(timeoutError("handshake", "authentication timed out", err), "")
+			}
+			return audit.fail(networkError("handshake", "failed to read security result", err), "")
+		}
 
-	if securityResult == 1 {
-		reason := c.readErrorReason()
-		c.logger.Error("Authentication failed", Field{Key: "reason", Value: reason})
-		return authenticationError("handshake", fmt.Sprintf("security handshake failed: %s", reason), nil)
+		if securityResult == 1 {
+			reason := "authentication failed"
+			if minor >= 8 {
+				reason = c.readErrorReason()
+			}
+			c.logger.Error("Authentication failed", Field{Key: "reason", Value: reason})
+			return audit.fail(authenticationError("handshake", "security handshake failed", &AuthFailureError{Reason: reason, auth: auth}), reason)
+		}
 	}
 
 	c.logger.Info("Authentication successful")
+	audit.succeeded()
 
 	// 7.3.1 ClientInit
+	c.setPhase(PhaseInitialization)
 	var sharedFlag uint8 = 1
 	if c.config.Exclusive {
 		sharedFlag = 0
@@ -1257,6 +1797,18 @@ func (c *ClientConn) handshakeWithContext(ctx context.Context) error {
 		return networkError("handshake", "failed to read framebuffer height", err)
 	}
 
+	// A zero size is a successful SecurityResult sent by a server that was
+	// not supposed to send one.
+	if width == 0 && height == 0 && !securityResultExpected && c.hasQuirk(QuirkEarlyServerData) {
+		c.logger.Warn("Skipping unexpected security result")
+		if err = c.readBinaryWithContext(ctx, &width); err != nil {
+			return networkError("handshake", "failed to read framebuffer width", err)
+		}
+		if err = c.readBinaryWithContext(ctx, &height); err != nil {
+			return networkError("handshake", "failed to read framebuffer height", err)
+		}
+	}
+
 	// Validate framebuffer dimensions for security
 	if err := validator.ValidateFramebufferDimensions(width, height); err != nil {
 		c.logger.Error("Invalid framebuffer dimensions received from server",
@@ -1272,17 +1824,13 @@ func (c *ClientConn) handshakeWithContext(ctx context.Context) error {
 		return protocolError("handshake", "failed to read pixel format", err)
 	}
 
-	// Update connection state with mutex protection
-	c.mu.Lock()
-	c.FrameBufferWidth = width
-	c.FrameBufferHeight = height
-	c.PixelFormat = pixelFormat
-	c.mu.Unlock()
+	c.setFrameBufferSize(width, height)
+	c.setPixelFormat(pixelFormat)
 
 	// Validate pixel format for security
-	if err := validator.ValidatePixelFormat(&c.PixelFormat); err != nil {
+	if err := validator.ValidatePixelFormat(&pixelFormat); err != nil {
 		c.logger.Error("Invalid pixel format received from server",
-			Field{Key: "pixel_format", Value: c.PixelFormat},
+			Field{Key: "pixel_format", Value: pixelFormat},
 			Field{Key: "error", Value: err})
 		return protocolError("handshake", "server sent invalid pixel format", err)
 	}
@@ -1315,10 +1863,13 @@ func (c *ClientConn) handshakeWithContext(ctx context.Context) error {
 		desktopNameStr = validator.SanitizeText(desktopNameStr)
 	}
 
-	// Update desktop name with mutex protection
-	c.mu.Lock()
-	c.DesktopName = desktopNameStr
-	c.mu.Unlock()
+	c.setDesktopName(desktopNameStr)
+	c.transcript.recordServerInit(DebugServerInit{
+		Width:             width,
+		Height:            height,
+		PixelFormat:       pixelFormat,
+		DesktopNameLength: len(desktopNameStr),
+	})
 
 	// Get current values for logging (thread-safe)
 	logWidth, logHeight := c.GetFrameBufferSize()
@@ -1341,7 +1892,122 @@ func (c *ClientConn) mainLoop() {
 
 	c.logger.Info("Starting message processing loop")
 
-	// Build the map of available server messages
+	for {
+		// Check if context is cancelled before reading
+		select {
+		case <-c.ctx.Done():
+			c.logger.Info("Message processing loop cancelled by context")
+			return
+		default:
+		}
+
+		parsedMsg, err := c.readServerMessage(c.ctx)
+		if err != nil {
+			if errors.Is(err, errDetached) {
+				// Wait for Detach, which either takes over the connection and
+				// closes it or resumes processing
+				c.detachMu.Lock()
+				detached := c.ctx.Err() != nil
+				c.detachMu.Unlock()
+				if detached {
+					c.logger.Info("Message processing loop stopped for detach")
+					return
+				}
+				continue
+			}
+			if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+				c.logger.Info("Message processing loop cancelled", Field{Key: "error", Value: err})
+				return
+			}
+			fields := []Field{{Key: "error", Value: err}}
+			var vncErr *VNCError
+			if errors.As(err, &vncErr) {
+				if vncErr.Op == "readServerMessage" && vncErr.Code == ErrNetwork {
+					c.logger.Debug("Connection closed or error reading message type", Field{Key: "error", Value: err})
+					break
+				}
+				fields = append(fields, vncErr.Fields()...)
+			}
+			c.logger.Error("Failed to process server message", fields...)
+			break
+		}
+
+		if c.suppressMessage(parsedMsg) {
+			continue
+		}
+
+		if !c.deliverPaced(parsedMsg) {
+			c.logger.Info("Message processing loop cancelled while sending message")
+			return
+		}
+	}
+
+	c.logger.Info("Message processing loop ended")
+}
+
+// ProcessNextMessage reads and processes exactly one server message and
+// returns it. It is only available when the connection was created with
+// WithManualPump, letting single-threaded applications such as game loops or
+// WebAssembly hosts integrate the client into their own event loop instead of
+// relying on the background goroutine.
+//
+// The returned message has already been applied to the connection state
+// (framebuffer size, color map, and so on); it is not sent to the configured
+// ServerMessageCh. Bells dropped by BellInterval are skipped. If ctx ends
+// before a message starts arriving, ProcessNextMessage returns ctx.Err() and
+// the connection remains usable, so a short deadline can be used to poll
+// without blocking the caller's loop. Once a message has started arriving it
+// is read in full.
+//
+// Example usage:
+//
+//	client, err := vnc.ClientWithOptions(ctx, conn, vnc.WithManualPump(true))
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//
+//	for running {
+//		pollCtx, cancel := context.WithTimeout(ctx, time.Millisecond)
+//		msg, err := client.ProcessNextMessage(pollCtx)
+//		cancel()
+//		switch {
+//		case errors.Is(err, context.DeadlineExceeded):
+//			// No message pending; render the next frame.
+//		case err != nil:
+//			log.Fatal(err)
+//		default:
+//			handle(msg)
+//		}
+//	}
+func (c *ClientConn) ProcessNextMessage(ctx context.Context) (ServerMessage, error) {
+	if c.config == nil || !c.config.ManualPump {
+		return nil, c.enrichError(configurationError("ProcessNextMessage",
+			"manual message processing requires WithManualPump", nil))
+	}
+
+	select {
+	case <-c.ctx.Done():
+		return nil, c.enrichError(networkError("ProcessNextMessage", "connection closed", c.ctx.Err()))
+	default:
+	}
+
+	for {
+		msg, err := c.readServerMessage(ctx)
+		if err != nil || !c.suppressMessage(msg) {
+			return msg, err
+		}
+	}
+}
+
+// messageTypeResult is the outcome of reading a server message type byte.
+type messageTypeResult struct {
+	messageType uint8
+	err         error
+}
+
+// newServerMessageTypes builds the map of server messages the client can
+// parse, with custom messages from the configuration overriding the defaults.
+func newServerMessageTypes(cfg *ClientConfig) map[uint8]ServerMessage {
 	typeMap := make(map[uint8]ServerMessage)
 
 	defaultMessages := []ServerMessage{
@@ -1349,73 +2015,120 @@ func (c *ClientConn) mainLoop() {
 		new(SetColorMapEntriesMessage),
 		new(BellMessage),
 		new(ServerCutTextMessage),
+		new(FenceMessage),
+		new(EndOfContinuousUpdatesMessage),
 	}
 
 	for _, msg := range defaultMessages {
 		typeMap[msg.Type()] = msg
 	}
 
-	if c.config.ServerMessages != nil {
-		for _, msg := range c.config.ServerMessages {
+	if cfg != nil {
+		for _, msg := range cfg.ServerMessages {
 			typeMap[msg.Type()] = msg
 		}
 	}
 
-	for {
-		// Check if context is cancelled before reading
-		select {
-		case <-c.ctx.Done():
-			c.logger.Info("Message processing loop cancelled by context")
-			return
-		default:
-		}
+	return typeMap
+}
 
-		var messageType uint8
-		if err := c.readBinaryWithContext(c.ctx, &messageType); err != nil {
-			if err == context.Canceled || err == context.DeadlineExceeded {
-				c.logger.Info("Message processing loop cancelled", Field{Key: "error", Value: err})
-			} else {
-				c.logger.Debug("Connection closed or error reading message type", Field{Key: "error", Value: err})
+// readServerMessage reads and parses a single server message. Waiting for the
+// message type respects ctx; if ctx ends first the pending read is kept so the
+// next call resumes it without losing data. The message body is read in full
+// once the type has arrived.
+func (c *ClientConn) readServerMessage(ctx context.Context) (ServerMessage, error) {
+	c.pumpMu.Lock()
+	defer c.pumpMu.Unlock()
+
+	if len(c.early) > 0 {
+		msg := c.early[0]
+		c.early = c.early[1:]
+		return msg, nil
+	}
+
+	if c.pendingType == nil {
+		pending := make(chan messageTypeResult, 1)
+		if !c.goTracked(func() {
+			var result messageTypeResult
+			var buf [1]byte
+			fields, err := readFields(c.c, "message type", buf[:])
+			if result.err = err; err == nil {
+				result.messageType = fields.uint8()
 			}
-			break
+			pending <- result
+		}) {
+			return nil, c.enrichError(networkError("readServerMessage", "connection closed", net.ErrClosed))
 		}
+		c.pendingType = pending
+	}
 
-		c.logger.Debug("Received server message", Field{Key: "type", Value: messageType})
-
-		msg, ok := typeMap[messageType]
-		if !ok {
-			c.logger.Error("Unsupported message type received", Field{Key: "type", Value: messageType})
-			break
-		}
+	var result messageTypeResult
+	select {
+	case result = <-c.pendingType:
+		c.pendingType = nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-c.detaching:
+		return nil, errDetached
+	}
 
-		parsedMsg, err := msg.Read(c, c.c)
-		if err != nil {
-			c.logger.Error("Failed to parse server message",
-				Field{Key: "type", Value: messageType},
-				Field{Key: "error", Value: err})
-			break
+	if result.err != nil {
+		if errors.Is(result.err, io.EOF) && !c.serverDisconnected.Swap(true) {
+			c.sendSharingEvent(SharingDisconnected)
 		}
+		return nil, c.enrichError(networkError("readServerMessage", "failed to read message type", result.err))
+	}
 
-		c.logger.Debug("Successfully parsed server message",
-			Field{Key: "type", Value: messageType},
-			Field{Key: "message_type", Value: fmt.Sprintf("%T", parsedMsg)})
+	messageType := result.messageType
+	c.logger.Debug("Received server message", Field{Key: "type", Value: messageType})
+	c.transcript.recordMessage(messageTypeName(messageType))
 
-		if c.config.ServerMessageCh == nil {
-			c.logger.Debug("No server message channel configured, discarding message")
-			continue
-		}
+	msg, ok := c.messageTypes[messageType]
+	if !ok {
+		return nil, c.enrichMessageError(unsupportedError("readServerMessage",
+			fmt.Sprintf("unsupported message type: %d", messageType), nil), messageTypeName(messageType))
+	}
 
-		// Try to send message to channel with context cancellation support
-		select {
-		case c.config.ServerMessageCh <- parsedMsg:
-			// Message sent successfully
-		case <-c.ctx.Done():
-			c.logger.Info("Message processing loop cancelled while sending message")
-			return
-		}
+	r, clearDeadline := c.messageReader()
+	hook := c.recorder.Load()
+	if hook != nil {
+		r = hook.tee(messageType, r)
+	}
+	parsedMsg, err := msg.Read(c, r)
+	clearDeadline()
+	if hook != nil {
+		hook.commit(err == nil)
+	}
+	if err != nil {
+		return nil, c.enrichMessageError(err, messageTypeName(messageType))
 	}
 
-	c.logger.Info("Message processing loop ended")
+	c.logger.Debug("Successfully parsed server message",
+		Field{Key: "type", Value: messageType},
+		Field{Key: "message_type", Value: fmt.Sprintf("%T", parsedMsg)})
+
+	return parsedMsg, nil
+}
+
+// messageRecorder receives a copy of every server message while a recording
+// started with StartRecording is running.
+type messageRecorder interface {
+	// tee returns body wrapped to copy the message of the given type.
+	tee(messageType uint8, body io.Reader) io.Reader
+
+	// commit ends the message; ok is false if it failed to parse.
+	commit(ok bool)
+
+	// annotate adds an annotation at the current position.
+	annotate(annotation Annotation)
+
+	// stop ends the recording.
+	stop() error
+}
+
+// recorderHook holds the active messageRecorder of a connection.
+type recorderHook struct {
+	messageRecorder
 }
 
 // readErrorReason reads an error reason string from the server.
@@ -1423,10 +2136,12 @@ func (c *ClientConn) readErrorReason() string {
 	// Initialize input validator for security
 	validator := newInputValidator()
 
-	var reasonLen uint32
-	if err := binary.Read(c.c, binary.BigEndian, &reasonLen); err != nil {
+	var header [4]byte
+	fields, err := readFields(c.c, "error reason length", header[:])
+	if err != nil {
 		return "<failed to read error reason length>"
 	}
+	reasonLen := fields.uint32()
 
 	// Validate error reason length to prevent buffer overflow
 	const maxErrorReasonLength = 64 * 1024
@@ -1437,8 +2152,8 @@ func (c *ClientConn) readErrorReason() string {
 		return "<invalid error reason length>"
 	}
 
-	reason := make([]uint8, reasonLen)
-	if err := binary.Read(c.c, binary.BigEndian, &reason); err != nil {
+	reason, err := readBytes(c.c, "error reason", int(reasonLen))
+	if err != nil {
 		return "<failed to read error reason>"
 	}
 
@@ -1454,107 +2169,125 @@ func (c *ClientConn) readErrorReason() string {
 	return reasonText
 }
 
-// Context-aware network operation helpers
+// Context-aware network operation helpers. They run on the calling goroutine
+// and honor ctx through connection deadlines (see withReadDeadline), so
+// sending input does not start a goroutine per call.
 
 // readWithContext reads data from the connection with context cancellation support.
 func (c *ClientConn) readWithContext(ctx context.Context, buf []byte) error {
-	done := make(chan error, 1)
-
-	go func() {
+	return c.withReadDeadline(ctx, func() error {
 		_, err := io.ReadFull(c.c, buf)
-		done <- err
-	}()
-
-	select {
-	case err := <-done:
 		return err
-	case <-ctx.Done():
-		return ctx.Err()
-	}
+	})
 }
 
 // writeWithContext writes data to the connection with context cancellation support.
 func (c *ClientConn) writeWithContext(ctx context.Context, data []byte) error {
-	done := make(chan error, 1)
-
-	go func() {
+	return c.withWriteDeadline(ctx, func() error {
 		_, err := c.c.Write(data)
-		done <- err
-	}()
-
-	select {
-	case err := <-done:
 		return err
-	case <-ctx.Done():
-		return ctx.Err()
-	}
+	})
 }
 
 // readBinaryWithContext reads binary data with context cancellation support.
 func (c *ClientConn) readBinaryWithContext(ctx context.Context, data interface{}) error {
-	done := make(chan error, 1)
-
-	go func() {
-		done <- binary.Read(c.c, binary.BigEndian, data)
-	}()
-
-	select {
-	case err := <-done:
-		return err
-	case <-ctx.Done():
-		return ctx.Err()
-	}
+	return c.withReadDeadline(ctx, func() error {
+		return binary.Read(c.c, binary.BigEndian, data)
+	})
 }
 
 // writeBinaryWithContext writes binary data with context cancellation support.
 func (c *ClientConn) writeBinaryWithContext(ctx context.Context, data interface{}) error {
-	done := make(chan error, 1)
-
-	go func() {
-		done <- binary.Write(c.c, binary.BigEndian, data)
-	}()
-
-	select {
-	case err := <-done:
-		return err
-	case <-ctx.Done():
-		return ctx.Err()
-	}
+	return c.withWriteDeadline(ctx, func() error {
+		return binary.Write(c.c, binary.BigEndian, data)
+	})
 }
 
 // readPixelFormatWithContext reads pixel format data with context cancellation support.
 func (c *ClientConn) readPixelFormatWithContext(ctx context.Context, pf *PixelFormat) error {
-	done := make(chan error, 1)
+	return c.withReadDeadline(ctx, func() error {
+		return readPixelFormat(c.c, pf)
+	})
+}
 
-	go func() {
-		done <- readPixelFormat(c.c, pf)
-	}()
+// ConnID returns the identifier attached to errors produced by this connection.
+func (c *ClientConn) ConnID() string {
+	return c.connID
+}
 
-	select {
-	case err := <-done:
-		return err
-	case <-ctx.Done():
-		return ctx.Err()
+// newConnID generates a random identifier used to correlate errors and logs
+// from a single connection.
+func newConnID() string {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return ""
 	}
+	return hex.EncodeToString(b[:])
 }
 
-// GetFrameBufferSize returns the current framebuffer dimensions in a thread-safe manner.
-func (c *ClientConn) GetFrameBufferSize() (width, height uint16) {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
-	return c.FrameBufferWidth, c.FrameBufferHeight
+// setPhase records the protocol phase the connection has entered.
+func (c *ClientConn) setPhase(phase Phase) {
+	c.mu.Lock()
+	c.phase = phase
+	c.mu.Unlock()
 }
 
-// GetDesktopName returns the desktop name in a thread-safe manner.
-func (c *ClientConn) GetDesktopName() string {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
-	return c.DesktopName
+// enrichError attaches the connection context to the outermost VNCError in err.
+// Fields that are already set are left untouched and non-VNC errors are
+// returned unchanged.
+func (c *ClientConn) enrichError(err error) error {
+	return c.enrichMessageError(err, "")
 }
 
-// GetPixelFormat returns a copy of the current pixel format in a thread-safe manner.
-func (c *ClientConn) GetPixelFormat() PixelFormat {
+// enrichMessageError is enrichError with the name of the server message that
+// was being processed when err occurred.
+func (c *ClientConn) enrichMessageError(err error, messageType string) error {
+	var vncErr *VNCError
+	if err == nil || !errors.As(err, &vncErr) {
+		return err
+	}
+
 	c.mu.RLock()
-	defer c.mu.RUnlock()
-	return c.PixelFormat
+	phase := c.phase
+	c.mu.RUnlock()
+
+	if vncErr.ConnID == "" {
+		vncErr.ConnID = c.connID
+	}
+	if vncErr.RemoteAddr == "" && c.c != nil {
+		if addr := c.c.RemoteAddr(); addr != nil {
+			vncErr.RemoteAddr = addr.String()
+		}
+	}
+	if vncErr.Phase == "" {
+		vncErr.Phase = phase
+	}
+	if vncErr.MessageType == "" {
+		vncErr.MessageType = messageType
+	}
+	if vncErr.catalog == nil && c.config != nil {
+		vncErr.catalog = c.config.MessageCatalog
+	}
+	return err
+}
+
+// messageTypeName returns the RFC 6143 name of a server message type, or the
+// name of the extension message.
+func messageTypeName(messageType uint8) string {
+	switch messageType {
+	case 0:
+		return "FramebufferUpdate"
+	case 1:
+		return "SetColorMapEntries"
+	case 2:
+		return "Bell"
+	case 3:
+		return "ServerCutText"
+	case rfb.FenceMsg:
+		return "Fence"
+	case rfb.EndOfContinuousUpdatesMsg:
+		return "EndOfContinuousUpdates"
+	default:
+		return fmt.Sprintf("%d", messageType)
+	}
 }
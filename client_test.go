@@ -4,11 +4,19 @@
 package vnc
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"log"
 	"net"
+	"os"
+	"strings"
 	"testing"
 	"time"
+
+	"github.com/tenthirtyam/go-vnc/rfb"
 )
 
 // newMockServer creates a mock VNC server for testing with the specified protocol version.
@@ -72,6 +80,55 @@ func TestClient_LowMinorVersion(t *testing.T) {
 	}
 }
 
+func TestClient_ErrorContext(t *testing.T) {
+	nc, err := net.Dial("tcp", newMockServer(t, "003.007"))
+	if err != nil {
+		t.Fatalf("error connecting to mock server: %s", err)
+	}
+
+	_, err = Client(nc, &ClientConfig{})
+	if err == nil {
+		t.Fatal("error expected")
+	}
+
+	var vncErr *VNCError
+	if !errors.As(err, &vncErr) {
+		t.Fatalf("expected VNCError, got %T", err)
+	}
+	if vncErr.Phase != PhaseProtocolVersion {
+		t.Errorf("Phase = %q, want %q", vncErr.Phase, PhaseProtocolVersion)
+	}
+	if vncErr.RemoteAddr != nc.RemoteAddr().String() {
+		t.Errorf("RemoteAddr = %q, want %q", vncErr.RemoteAddr, nc.RemoteAddr().String())
+	}
+	if len(vncErr.ConnID) != 16 {
+		t.Errorf("ConnID = %q, want 16 hex characters", vncErr.ConnID)
+	}
+}
+
+func TestClient_CloseAndWait(t *testing.T) {
+	conn, _ := runReplay(t, replayCase{handshake: replayHandshake(4, 4, "close")})
+
+	done := make(chan struct{})
+	go func() {
+		_ = conn.CloseAndWait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("CloseAndWait did not return")
+	}
+
+	if conn.goTracked(func() {}) {
+		t.Error("goroutine started after CloseAndWait")
+	}
+	if err := conn.KeyEvent(0x61, true); err == nil {
+		t.Error("expected error sending on closed connection")
+	}
+}
+
 func TestClient_WithContextCancellation(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	server, client := net.Pipe()
@@ -284,3 +341,459 @@ func TestClient_FunctionalOptionsComposition(t *testing.T) {
 		t.Error("Expected Exclusive to be true")
 	}
 }
+
+func TestClient_ManualPump(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+
+	go func() {
+		_, _ = io.Copy(io.Discard, serverConn)
+	}()
+	go func() {
+		_, _ = serverConn.Write(replayHandshake(4, 4, "pump"))
+	}()
+
+	conn, err := ClientWithOptions(context.Background(), clientConn,
+		WithAuth(&ClientAuthNone{}), WithManualPump(true))
+	if err != nil {
+		t.Fatalf("handshake failed: %v", err)
+	}
+	defer func() { _ = conn.CloseAndWait() }()
+
+	// No message pending: the poll times out and the connection stays usable.
+	pollCtx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	_, err = conn.ProcessNextMessage(pollCtx)
+	cancel()
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+
+	var s replayStream
+	s.write(uint8(2))
+	s.write(uint8(3), []byte{0, 0, 0}, uint32(5), []byte("hello"))
+	go func() {
+		_, _ = serverConn.Write(s.bytes())
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	msg, err := conn.ProcessNextMessage(ctx)
+	if err != nil {
+		t.Fatalf("ProcessNextMessage failed: %v", err)
+	}
+	if _, ok := msg.(*BellMessage); !ok {
+		t.Fatalf("expected *BellMessage, got %T", msg)
+	}
+
+	msg, err = conn.ProcessNextMessage(ctx)
+	if err != nil {
+		t.Fatalf("ProcessNextMessage failed: %v", err)
+	}
+	cut, ok := msg.(*ServerCutTextMessage)
+	if !ok || cut.Text != "hello" {
+		t.Fatalf("expected cut text \"hello\", got %#v", msg)
+	}
+}
+
+func TestClient_ProcessNextMessageRequiresManualPump(t *testing.T) {
+	conn, _ := runReplay(t, replayCase{handshake: replayHandshake(4, 4, "loop")})
+
+	_, err := conn.ProcessNextMessage(context.Background())
+	if !IsVNCError(err, ErrConfiguration) {
+		t.Fatalf("expected configuration error, got %v", err)
+	}
+}
+
+func TestClient_ExtendedMouseButtons(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+
+	events := make(chan rfb.ExtendedPointerEvent, 4)
+	go func() {
+		_, _ = serverConn.Write(replayHandshake(4, 4, "buttons"))
+	}()
+	go func() {
+		defer close(events)
+		if _, _, err := rfb.ReadProtocolVersion(serverConn); err != nil {
+			return
+		}
+		if _, err := rfb.ReadSecurityType(serverConn); err != nil {
+			return
+		}
+		if _, err := rfb.ReadClientInit(serverConn); err != nil {
+			return
+		}
+		for {
+			msgType, err := rfb.ReadMessageType(serverConn)
+			if err != nil {
+				return
+			}
+			switch msgType {
+			case rfb.SetEncodingsMsg:
+				_, err = rfb.ReadSetEncodings(serverConn)
+			case rfb.PointerEventMsg:
+				var ev rfb.ExtendedPointerEvent
+				if ev, err = rfb.ReadExtendedPointerEvent(serverConn); err == nil {
+					events <- ev
+				}
+			default:
+				err = fmt.Errorf("unexpected message type %d", msgType)
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	conn, err := ClientWithOptions(context.Background(), clientConn,
+		WithAuth(&ClientAuthNone{}), WithManualPump(true))
+	if err != nil {
+		t.Fatalf("handshake failed: %v", err)
+	}
+	defer func() { _ = conn.CloseAndWait() }()
+
+	if err := conn.SetEncodings([]Encoding{&RawEncoding{}, &ExtendedMouseButtonsPseudoEncoding{}}); err != nil {
+		t.Fatalf("SetEncodings failed: %v", err)
+	}
+
+	// Before the server confirms the extension only the standard mask is usable.
+	if err := conn.PointerEvent(ButtonForward, 1, 1); !IsVNCError(err, ErrUnsupported) {
+		t.Fatalf("expected unsupported error before negotiation, got %v", err)
+	}
+	if err := conn.PointerEvent(ButtonScrollLeft, 1, 1); err != nil {
+		t.Fatalf("PointerEvent failed: %v", err)
+	}
+	if ev := <-events; ev.Mask != uint16(ButtonScrollLeft) {
+		t.Fatalf("standard event mask = 0x%x, want 0x%x", ev.Mask, uint16(ButtonScrollLeft))
+	}
+
+	var s replayStream
+	s.write(uint8(0), uint8(0), uint16(1)).rect(0, 0, 0, 0, rfb.PseudoEncodingExtendedMouseButtons)
+	go func() {
+		_, _ = serverConn.Write(s.bytes())
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := conn.ProcessNextMessage(ctx); err != nil {
+		t.Fatalf("ProcessNextMessage failed: %v", err)
+	}
+	if !conn.ExtendedMouseButtons() {
+		t.Fatal("ExtendedMouseButtons() = false after server confirmation")
+	}
+
+	if err := conn.PointerEvent(ButtonForward|ButtonLeft, 2, 3); err != nil {
+		t.Fatalf("PointerEvent failed: %v", err)
+	}
+	want := rfb.ExtendedPointerEvent{Mask: uint16(ButtonForward | ButtonLeft), X: 2, Y: 3}
+	if ev := <-events; ev != want {
+		t.Errorf("extended event = %+v, want %+v", ev, want)
+	}
+}
+
+func TestClient_BellThrottle(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+
+	go func() {
+		_, _ = io.Copy(io.Discard, serverConn)
+	}()
+	go func() {
+		_, _ = serverConn.Write(replayHandshake(4, 4, "bells"))
+	}()
+
+	conn, err := ClientWithOptions(context.Background(), clientConn,
+		WithAuth(&ClientAuthNone{}), WithManualPump(true), WithBellThrottle(time.Hour))
+	if err != nil {
+		t.Fatalf("handshake failed: %v", err)
+	}
+	defer func() { _ = conn.CloseAndWait() }()
+
+	var s replayStream
+	for i := 0; i < 5; i++ {
+		s.write(uint8(2))
+	}
+	s.write(uint8(3), []byte{0, 0, 0}, uint32(2), []byte("ok"))
+	go func() {
+		_, _ = serverConn.Write(s.bytes())
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	msg, err := conn.ProcessNextMessage(ctx)
+	if _, ok := msg.(*BellMessage); !ok || err != nil {
+		t.Fatalf("first message = %T, %v; want *BellMessage", msg, err)
+	}
+	msg, err = conn.ProcessNextMessage(ctx)
+	if _, ok := msg.(*ServerCutTextMessage); !ok || err != nil {
+		t.Fatalf("second message = %T, %v; want *ServerCutTextMessage", msg, err)
+	}
+
+	if got := conn.Stats().SuppressedBells; got != 4 {
+		t.Errorf("SuppressedBells = %d, want 4", got)
+	}
+}
+
+func TestClient_StateAccessors(t *testing.T) {
+	_, conn := newUpdateServer(t, 8, 8)
+
+	encs := []Encoding{&RawEncoding{}, &DesktopSizePseudoEncoding{}}
+	if err := conn.SetEncodings(encs); err != nil {
+		t.Fatal(err)
+	}
+
+	got := conn.GetEncodings()
+	if len(got) != 2 || got[0] != encs[0] || len(conn.Encs) != 2 {
+		t.Fatalf("GetEncodings() = %v, Encs = %v", got, conn.Encs)
+	}
+	got[0] = nil
+	if conn.GetEncodings()[0] == nil {
+		t.Error("GetEncodings() shares its slice with the connection")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Reading state while updates are decoded must not race (run with -race).
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			_ = conn.GetColorMap()
+			_ = conn.GetEncodings()
+			_, _ = conn.GetFrameBufferSize()
+			_ = conn.GetPixelFormat()
+			_ = conn.GetDesktopName()
+		}
+	}()
+	for i := 0; i < 5; i++ {
+		if _, err := conn.Screenshot(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	<-done
+
+	if name := conn.GetDesktopName(); name != "updates" || conn.DesktopName != name {
+		t.Errorf("GetDesktopName() = %q, DesktopName = %q", name, conn.DesktopName)
+	}
+}
+
+func TestClient_StateSnapshot(t *testing.T) {
+	c := &ClientConn{logger: &NoOpLogger{}}
+	c.setPixelFormat(PixelFormat{BPP: 8, Depth: 8})
+	c.setColorMapEntries(1, []Color{{R: 1}})
+
+	// A reader keeps the snapshot it was created from, so a color map update
+	// arriving mid-rectangle cannot change the colors it decodes.
+	reader := c.pixelReader()
+	c.setColorMapEntries(1, []Color{{R: 2}})
+
+	if got := reader.colorMap[1].R; got != 1 {
+		t.Errorf("reader color = %d, want 1", got)
+	}
+	if got := c.GetColorMap()[1].R; got != 2 || c.ColorMap[1].R != 2 {
+		t.Errorf("GetColorMap()[1].R = %d, ColorMap[1].R = %d, want 2", got, c.ColorMap[1].R)
+	}
+
+	c.resetColorMap()
+	if got := c.GetColorMap()[1].R; got != 0 {
+		t.Errorf("color after reset = %d, want 0", got)
+	}
+	if got := c.GetPixelFormat().BPP; got != 8 {
+		t.Errorf("BPP = %d, want 8", got)
+	}
+}
+
+func BenchmarkClient_StateRead(b *testing.B) {
+	c := &ClientConn{logger: &NoOpLogger{}}
+	c.setPixelFormat(PixelFormat{BPP: 32, Depth: 24, TrueColor: true})
+	c.setFrameBufferSize(1920, 1080)
+
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			_ = c.pixelReader()
+			_, _ = c.GetFrameBufferSize()
+		}
+	})
+}
+
+func TestClient_IdleBeyondTimeouts(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+
+	go func() {
+		_, _ = io.Copy(io.Discard, serverConn)
+	}()
+	go func() {
+		_, _ = serverConn.Write(replayHandshake(4, 4, "idle"))
+	}()
+
+	msgs := make(chan ServerMessage, 1)
+	conn, err := ClientWithOptions(context.Background(), clientConn,
+		WithAuth(&ClientAuthNone{}),
+		WithServerMessageChannel(msgs),
+		WithConnectTimeout(50*time.Millisecond),
+		WithTimeout(50*time.Millisecond),
+	)
+	if err != nil {
+		t.Fatalf("handshake failed: %v", err)
+	}
+	defer func() { _ = conn.CloseAndWait() }()
+
+	// The server stays silent for longer than every configured timeout.
+	time.Sleep(200 * time.Millisecond)
+
+	if err := conn.PointerEvent(0, 1, 1); err != nil {
+		t.Fatalf("PointerEvent after idle period failed: %v", err)
+	}
+
+	go func() {
+		_, _ = serverConn.Write([]byte{2})
+	}()
+	select {
+	case msg, ok := <-msgs:
+		if _, isBell := msg.(*BellMessage); !ok || !isBell {
+			t.Fatalf("expected *BellMessage, got %T", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message received after idle period")
+	}
+}
+
+func TestClient_ReadTimeoutWithinMessage(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+
+	go func() {
+		_, _ = io.Copy(io.Discard, serverConn)
+	}()
+	go func() {
+		_, _ = serverConn.Write(replayHandshake(4, 4, "stall"))
+		// A ServerCutText message that stops after its type byte.
+		_, _ = serverConn.Write([]byte{3})
+	}()
+
+	conn, err := ClientWithOptions(context.Background(), clientConn,
+		WithAuth(&ClientAuthNone{}), WithManualPump(true), WithReadTimeout(50*time.Millisecond))
+	if err != nil {
+		t.Fatalf("handshake failed: %v", err)
+	}
+	defer func() { _ = conn.CloseAndWait() }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err = conn.ProcessNextMessage(ctx)
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("expected read deadline error, got %v", err)
+	}
+	if ctx.Err() != nil {
+		t.Fatal("ReadTimeout did not bound the stalled message")
+	}
+}
+
+func TestClient_ConnectTimeout(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+
+	// The server never sends its protocol version.
+	start := time.Now()
+	_, err := ClientWithContext(context.Background(), clientConn, &ClientConfig{
+		Auth:           []ClientAuth{&ClientAuthNone{}},
+		ConnectTimeout: 50 * time.Millisecond,
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("handshake took %v despite ConnectTimeout", elapsed)
+	}
+}
+
+func TestClient_AutoFullUpdate(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+
+	type initial struct {
+		encodings []int32
+		request   rfb.FramebufferUpdateRequest
+		err       error
+	}
+	received := make(chan initial, 1)
+
+	go func() {
+		_, _ = serverConn.Write(replayHandshake(64, 48, "auto"))
+	}()
+	go func() {
+		var got initial
+		defer func() { received <- got }()
+
+		if _, _, got.err = rfb.ReadProtocolVersion(serverConn); got.err != nil {
+			return
+		}
+		if _, got.err = rfb.ReadSecurityType(serverConn); got.err != nil {
+			return
+		}
+		if _, got.err = rfb.ReadClientInit(serverConn); got.err != nil {
+			return
+		}
+		if _, got.err = rfb.ReadMessageType(serverConn); got.err != nil {
+			return
+		}
+		if got.encodings, got.err = rfb.ReadSetEncodings(serverConn); got.err != nil {
+			return
+		}
+		if _, got.err = rfb.ReadMessageType(serverConn); got.err != nil {
+			return
+		}
+		got.request, got.err = rfb.ReadFramebufferUpdateRequest(serverConn)
+	}()
+
+	conn, err := ClientWithOptions(context.Background(), clientConn,
+		WithAuth(&ClientAuthNone{}),
+		WithManualPump(true),
+		WithInitialEncodings(&HextileEncoding{}, &RawEncoding{}),
+		WithAutoFullUpdate(true),
+	)
+	if err != nil {
+		t.Fatalf("handshake failed: %v", err)
+	}
+	defer func() { _ = conn.CloseAndWait() }()
+
+	got := <-received
+	if got.err != nil {
+		t.Fatalf("server failed to read initial messages: %v", got.err)
+	}
+	if want := []int32{5, 0}; fmt.Sprint(got.encodings) != fmt.Sprint(want) {
+		t.Errorf("encodings = %v, want %v", got.encodings, want)
+	}
+	if want := (rfb.FramebufferUpdateRequest{Width: 64, Height: 48}); got.request != want {
+		t.Errorf("update request = %+v, want %+v", got.request, want)
+	}
+	if len(conn.GetEncodings()) != 2 {
+		t.Errorf("GetEncodings() = %v", conn.GetEncodings())
+	}
+}
+
+func TestClient_DeprecatedFieldChangesLogged(t *testing.T) {
+	var buf bytes.Buffer
+	c := &ClientConn{logger: &StandardLogger{Logger: log.New(&buf, "", 0)}}
+	c.setDesktopName("desktop")
+	c.setEncodings([]Encoding{&RawEncoding{}})
+	if buf.Len() != 0 {
+		t.Fatalf("logged %q without changes to the deprecated fields", buf.String())
+	}
+
+	c.Encs[0] = &HextileEncoding{}
+	c.setDesktopName("renamed")
+	c.DesktopName = "changed"
+	c.setDesktopName("renamed again")
+	if got := strings.Count(buf.String(), "Deprecated ClientConn fields"); got != 1 {
+		t.Errorf("logged %d warnings, want 1: %q", got, buf.String())
+	}
+	if got := c.GetEncodings()[0]; got.Type() != rfb.EncodingRaw {
+		t.Errorf("GetEncodings()[0] = %T, want the change to Encs ignored", got)
+	}
+}
@@ -0,0 +1,96 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package vnc
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// ShortReadError reports a message that ended before one of its fields was
+// complete, as when the server closes the connection mid-message or a
+// recording is truncated. It is wrapped in the VNCError returned by the
+// operation, so errors.As finds it, and unwraps to the error that ended the
+// read, usually io.ErrUnexpectedEOF or io.EOF.
+type ShortReadError struct {
+	// Field names the field or group of fields being read.
+	Field string
+
+	// Expected is the size of the field in bytes.
+	Expected int
+
+	// Got is the number of bytes read before the read failed.
+	Got int
+
+	// Err is the error that ended the read.
+	Err error
+}
+
+// Error returns the sizes and the field that was cut short.
+func (e *ShortReadError) Error() string {
+	return fmt.Sprintf("expected %d bytes, got %d at field %s: %v", e.Expected, e.Got, e.Field, e.Err)
+}
+
+// Unwrap returns the error that ended the read.
+func (e *ShortReadError) Unwrap() error {
+	return e.Err
+}
+
+// readFields reads the fixed-size fields named field, len(buf) bytes in all,
+// into buf with a single read and returns them for decoding from memory.
+// Callers pass a buffer sized for the fields, usually an array on the stack.
+func readFields(r io.Reader, field string, buf []byte) (wireFields, error) {
+	if n, err := io.ReadFull(r, buf); err != nil {
+		return wireFields{}, &ShortReadError{Field: field, Expected: len(buf), Got: n, Err: err}
+	}
+	return wireFields{buf: buf}, nil
+}
+
+// readBytes reads the n bytes of the variable-length field named field into a
+// new slice. Callers validate n before allocating.
+func readBytes(r io.Reader, field string, n int) ([]byte, error) {
+	buf := make([]byte, n)
+	if got, err := io.ReadFull(r, buf); err != nil {
+		return nil, &ShortReadError{Field: field, Expected: n, Got: got, Err: err}
+	}
+	return buf, nil
+}
+
+// wireFields decodes big-endian fields in order from a buffer filled by
+// readFields.
+type wireFields struct {
+	buf []byte
+}
+
+// uint8 decodes the next byte.
+func (f *wireFields) uint8() uint8 {
+	v := f.buf[0]
+	f.buf = f.buf[1:]
+	return v
+}
+
+// uint16 decodes the next big-endian 16-bit integer.
+func (f *wireFields) uint16() uint16 {
+	v := binary.BigEndian.Uint16(f.buf)
+	f.buf = f.buf[2:]
+	return v
+}
+
+// uint32 decodes the next big-endian 32-bit integer.
+func (f *wireFields) uint32() uint32 {
+	v := binary.BigEndian.Uint32(f.buf)
+	f.buf = f.buf[4:]
+	return v
+}
+
+// int32 decodes the next big-endian two's complement 32-bit integer.
+func (f *wireFields) int32() int32 {
+	return int32(f.uint32()) // #nosec G115 - two's complement on the wire
+}
+
+// skip discards the next n bytes, such as padding.
+func (f *wireFields) skip(n int) {
+	f.buf = f.buf[n:]
+}
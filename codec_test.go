@@ -0,0 +1,112 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package vnc
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestCodec_ShortReads(t *testing.T) {
+	update := func(s *replayStream) []byte { return s.bytes()[1:] }
+
+	tests := []struct {
+		name     string
+		msg      ServerMessage
+		data     []byte
+		field    string
+		expected int
+		got      int
+	}{
+		{
+			name:     "Update header",
+			msg:      &FramebufferUpdateMessage{},
+			data:     []byte{0, 0},
+			field:    "update header",
+			expected: 3,
+			got:      2,
+		},
+		{
+			name:     "Rectangle header",
+			msg:      &FramebufferUpdateMessage{},
+			data:     update((&replayStream{}).write(uint8(0), uint8(0), uint16(1)).rect(0, 0, 1, 1, 0))[:8],
+			field:    "rectangle header",
+			expected: 12,
+			got:      5,
+		},
+		{
+			name:     "CopyRect source",
+			msg:      &FramebufferUpdateMessage{},
+			data:     update((&replayStream{}).write(uint8(0), uint8(0), uint16(1)).rect(0, 0, 1, 1, (&CopyRectEncoding{}).Type()).write(uint16(0))),
+			field:    "source position",
+			expected: 4,
+			got:      2,
+		},
+		{
+			name:     "Colors",
+			msg:      &SetColorMapEntriesMessage{},
+			data:     []byte{0, 0, 0, 0, 2, 0, 1, 0, 2, 0, 3, 0, 4},
+			field:    "colors",
+			expected: 12,
+			got:      8,
+		},
+		{
+			name:     "Cut text",
+			msg:      &ServerCutTextMessage{},
+			data:     []byte{0, 0, 0, 0, 0, 0, 5, 'h', 'i'},
+			field:    "cut text",
+			expected: 5,
+			got:      2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &ClientConn{logger: &NoOpLogger{}}
+			c.setPixelFormat(replayPixelFormat)
+			c.setFrameBufferSize(1, 1)
+			c.setEncodings([]Encoding{&CopyRectEncoding{}})
+
+			_, err := tt.msg.Read(c, bytes.NewReader(tt.data))
+			var short *ShortReadError
+			if !errors.As(err, &short) {
+				t.Fatalf("Read() error = %v, want a *ShortReadError", err)
+			}
+			if short.Field != tt.field || short.Expected != tt.expected || short.Got != tt.got {
+				t.Errorf("ShortReadError = %+v, want field %q, expected %d, got %d",
+					short, tt.field, tt.expected, tt.got)
+			}
+			if !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+				t.Errorf("Read() error = %v, want it to wrap the end of the stream", err)
+			}
+			if !strings.Contains(err.Error(), "at field "+tt.field) {
+				t.Errorf("Read() error = %q, want it to name the field", err)
+			}
+		})
+	}
+}
+
+func BenchmarkCodec_FramebufferUpdate(b *testing.B) {
+	s := &replayStream{}
+	s.write(uint8(0), uint8(0), uint16(256))
+	for i := range uint16(256) {
+		s.rect(i, 0, 1, 1, (&CopyRectEncoding{}).Type()).write(uint16(0), uint16(0))
+	}
+	data := s.bytes()[1:]
+
+	c := &ClientConn{logger: &NoOpLogger{}}
+	c.setPixelFormat(replayPixelFormat)
+	c.setFrameBufferSize(256, 1)
+	c.setEncodings([]Encoding{&CopyRectEncoding{}})
+
+	b.ReportAllocs()
+	for b.Loop() {
+		if _, err := (&FramebufferUpdateMessage{}).Read(c, bytes.NewReader(data)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
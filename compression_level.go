@@ -0,0 +1,50 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+//go:build !vnc_minimal
+
+package vnc
+
+import "fmt"
+
+// WithCompressionLevel adds a CompressionLevelPseudoEncoding for level, from
+// 0 (fastest) to 9 (smallest), to the initial encodings, replacing any level
+// already there. It must follow the preset or WithInitialEncodings option
+// that sets the encodings, which would otherwise replace it.
+//
+// Example usage:
+//
+//	client, err := vnc.ClientWithOptions(ctx, conn,
+//		vnc.ForTigerVNC(),
+//		vnc.WithCompressionLevel(9),
+//		vnc.WithAuth(auth),
+//	)
+func WithCompressionLevel(level uint8) ClientOption {
+	return func(cfg *ClientConfig) {
+		cfg.InitialEncodings = withCompressionLevel(cfg.InitialEncodings, level)
+	}
+}
+
+// SetCompressionLevel asks the server to compress with zlib at level, from 0
+// (fastest) to 9 (smallest), by sending the current encodings again with the
+// matching CompressionLevelPseudoEncoding. Servers such as TightVNC, TigerVNC,
+// and QEMU apply it to Tight, ZRLE, and Zlib rectangles; others ignore it.
+func (c *ClientConn) SetCompressionLevel(level uint8) error {
+	if level > 9 {
+		return c.enrichError(validationError("SetCompressionLevel",
+			fmt.Sprintf("compression level %d is outside 0 to 9", level), nil))
+	}
+	return c.SetEncodings(withCompressionLevel(c.GetEncodings(), level))
+}
+
+// withCompressionLevel returns a copy of encodings with any compression level
+// pseudo-encoding replaced by one for level, which is placed last.
+func withCompressionLevel(encodings []Encoding, level uint8) []Encoding {
+	result := make([]Encoding, 0, len(encodings)+1)
+	for _, enc := range encodings {
+		if _, ok := enc.(*CompressionLevelPseudoEncoding); !ok {
+			result = append(result, enc)
+		}
+	}
+	return append(result, &CompressionLevelPseudoEncoding{Level: level})
+}
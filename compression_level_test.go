@@ -0,0 +1,58 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+//go:build !vnc_minimal
+
+package vnc
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/tenthirtyam/go-vnc/rfb"
+)
+
+// encodingTypes returns the type of each encoding.
+func encodingTypes(encodings []Encoding) []int32 {
+	types := make([]int32, len(encodings))
+	for i, enc := range encodings {
+		types[i] = enc.Type()
+	}
+	return types
+}
+
+func TestCompressionLevel_Option(t *testing.T) {
+	var cfg ClientConfig
+	for _, option := range []ClientOption{
+		WithInitialEncodings(&ZRLEEncoding{}, &RawEncoding{}),
+		WithCompressionLevel(3),
+		WithCompressionLevel(9),
+	} {
+		option(&cfg)
+	}
+
+	want := []int32{rfb.EncodingZRLE, 0, rfb.PseudoEncodingCompressionLevel0 + 9}
+	if got := encodingTypes(cfg.InitialEncodings); !slices.Equal(got, want) {
+		t.Errorf("initial encodings = %v, want %v", got, want)
+	}
+}
+
+func TestCompressionLevel_Set(t *testing.T) {
+	_, conn := newUpdateServer(t, 4, 4)
+
+	if err := conn.SetEncodings([]Encoding{&TightEncoding{}, &CompressionLevelPseudoEncoding{Level: 1}, &RawEncoding{}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.SetCompressionLevel(6); err != nil {
+		t.Fatalf("SetCompressionLevel failed: %v", err)
+	}
+
+	want := []int32{rfb.EncodingTight, 0, rfb.PseudoEncodingCompressionLevel0 + 6}
+	if got := encodingTypes(conn.GetEncodings()); !slices.Equal(got, want) {
+		t.Errorf("encodings = %v, want %v", got, want)
+	}
+
+	if err := conn.SetCompressionLevel(10); !IsVNCError(err, ErrValidation) {
+		t.Errorf("SetCompressionLevel(10) error = %v, want a validation error", err)
+	}
+}
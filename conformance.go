@@ -0,0 +1,547 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+//go:build !vnc_minimal
+
+package vnc
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"net"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/tenthirtyam/go-vnc/rfb"
+)
+
+// ConformanceStatus is the outcome of a single conformance check.
+type ConformanceStatus int
+
+const (
+	// ConformancePass means the server behaved as RFC 6143 requires.
+	ConformancePass ConformanceStatus = iota
+
+	// ConformanceWarn means the server deviated from a recommendation of
+	// RFC 6143, or the check could not be evaluated reliably.
+	ConformanceWarn
+
+	// ConformanceFail means the server violated a requirement of RFC 6143.
+	ConformanceFail
+
+	// ConformanceSkip means the check did not apply to the server, for
+	// example because it does not support the encoding under test.
+	ConformanceSkip
+)
+
+// String returns the upper-case name of the status used in reports.
+func (s ConformanceStatus) String() string {
+	switch s {
+	case ConformancePass:
+		return "PASS"
+	case ConformanceWarn:
+		return "WARN"
+	case ConformanceFail:
+		return "FAIL"
+	case ConformanceSkip:
+		return "SKIP"
+	default:
+		return fmt.Sprintf("ConformanceStatus(%d)", int(s))
+	}
+}
+
+// ConformanceResult is the result of one conformance check.
+type ConformanceResult struct {
+	// Name identifies the check, such as "version/greeting".
+	Name string
+
+	// Reference is the section of RFC 6143 the check is based on.
+	Reference string
+
+	// Status is the outcome of the check.
+	Status ConformanceStatus
+
+	// Detail explains the outcome.
+	Detail string
+}
+
+// ConformanceReport holds the results of RunConformance in the order the
+// checks ran.
+type ConformanceReport struct {
+	// Address is the server that was checked.
+	Address string
+
+	// Results holds one entry per check.
+	Results []ConformanceResult
+}
+
+// Passed reports whether no check failed. Warnings and skipped checks do not
+// count as failures.
+func (r *ConformanceReport) Passed() bool {
+	return r.Count(ConformanceFail) == 0
+}
+
+// Count returns the number of checks with the given status.
+func (r *ConformanceReport) Count(status ConformanceStatus) int {
+	n := 0
+	for _, result := range r.Results {
+		if result.Status == status {
+			n++
+		}
+	}
+	return n
+}
+
+// String formats the report as one line per check followed by a summary.
+func (r *ConformanceReport) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "RFC 6143 conformance of %s\n", r.Address)
+	for _, result := range r.Results {
+		fmt.Fprintf(&b, "%s  %-26s %-16s %s\n", result.Status, result.Name, result.Reference, result.Detail)
+	}
+	fmt.Fprintf(&b, "%d passed, %d warnings, %d failed, %d skipped\n",
+		r.Count(ConformancePass), r.Count(ConformanceWarn), r.Count(ConformanceFail), r.Count(ConformanceSkip))
+	return b.String()
+}
+
+// ConformanceOption configures RunConformance.
+type ConformanceOption func(*conformanceConfig)
+
+// conformanceConfig holds the settings applied by ConformanceOptions.
+type conformanceConfig struct {
+	dial             func(ctx context.Context) (net.Conn, error)
+	client           []ClientOption
+	probeAuthFailure bool
+	checkTimeout     time.Duration
+}
+
+// defaultConformanceCheckTimeout bounds each check that opens a connection.
+const defaultConformanceCheckTimeout = 10 * time.Second
+
+// WithConformanceDialer replaces the TCP dialer used to open the connections
+// of each check, for example to tunnel them through SSH.
+func WithConformanceDialer(dial func(ctx context.Context) (net.Conn, error)) ConformanceOption {
+	return func(cfg *conformanceConfig) {
+		cfg.dial = dial
+	}
+}
+
+// WithConformanceClientOptions sets the client options, typically WithAuth,
+// used for the checks that need a complete handshake.
+func WithConformanceClientOptions(options ...ClientOption) ConformanceOption {
+	return func(cfg *conformanceConfig) {
+		cfg.client = options
+	}
+}
+
+// WithAuthFailureProbe enables a check that answers a VNC authentication
+// challenge wrongly on purpose to verify the failure reason. It is disabled by
+// default because servers may log the attempt or block the client address
+// after repeated failures.
+func WithAuthFailureProbe(enabled bool) ConformanceOption {
+	return func(cfg *conformanceConfig) {
+		cfg.probeAuthFailure = enabled
+	}
+}
+
+// WithConformanceCheckTimeout bounds each check that opens a connection and
+// each screen capture. Zero or less uses the default of 10 seconds.
+func WithConformanceCheckTimeout(timeout time.Duration) ConformanceOption {
+	return func(cfg *conformanceConfig) {
+		cfg.checkTimeout = timeout
+	}
+}
+
+// RunConformance runs a battery of RFC 6143 checks against the server at
+// address and reports the outcome of each:
+//
+//   - the ProtocolVersion greeting and the handling of RFB 3.3 and of an
+//     unknown minor version;
+//   - the security type list of RFB 3.8 and, with WithAuthFailureProbe, the
+//     reason sent with a failed SecurityResult;
+//   - the ServerInit message of a complete handshake;
+//   - encoding correctness, comparing the desktop decoded with RRE, Hextile,
+//     Zlib, TRLE, ZRLE, Tight, and TightPNG against a Raw reference.
+//
+// Every check opens its own connection. The encoding checks need a static
+// screen; if the desktop changes while they run they report warnings instead
+// of failures.
+//
+// RunConformance returns an error only if the server cannot be reached at all
+// or ctx ends; protocol violations are reported in the ConformanceReport.
+//
+// Example usage:
+//
+//	report, err := vnc.RunConformance(ctx, "localhost:5900",
+//		vnc.WithConformanceClientOptions(vnc.WithAuth(vnc.NewPasswordAuth("secret"))))
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	fmt.Print(report)
+func RunConformance(ctx context.Context, address string, options ...ConformanceOption) (*ConformanceReport, error) {
+	var cfg conformanceConfig
+	for _, option := range options {
+		option(&cfg)
+	}
+	if cfg.checkTimeout <= 0 {
+		cfg.checkTimeout = defaultConformanceCheckTimeout
+	}
+	if cfg.dial == nil {
+		cfg.dial = func(ctx context.Context) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "tcp", address)
+		}
+	}
+
+	run := &conformanceRun{cfg: cfg, report: &ConformanceReport{Address: address}}
+
+	// The greeting doubles as a reachability test.
+	conn, err := run.open(ctx)
+	if err != nil {
+		return nil, networkError("RunConformance", "failed to connect to "+address, err)
+	}
+	run.checkGreeting(conn)
+	_ = conn.Close()
+
+	steps := []func(context.Context){
+		func(ctx context.Context) { run.checkVersion(ctx, 3, ConformanceFail) },
+		func(ctx context.Context) { run.checkVersion(ctx, 5, ConformanceWarn) },
+		run.checkSecurityTypes,
+		run.checkAuthFailure,
+		run.checkSession,
+	}
+	for _, step := range steps {
+		if err := ctx.Err(); err != nil {
+			return run.report, err
+		}
+		step(ctx)
+	}
+	return run.report, ctx.Err()
+}
+
+// conformanceRun carries the state of one RunConformance call.
+type conformanceRun struct {
+	cfg    conformanceConfig
+	report *ConformanceReport
+
+	// securityTypes is the list offered to the RFB 3.8 client.
+	securityTypes []uint8
+}
+
+// add appends a result to the report.
+func (run *conformanceRun) add(name, reference string, status ConformanceStatus, format string, args ...any) {
+	run.report.Results = append(run.report.Results, ConformanceResult{
+		Name:      name,
+		Reference: reference,
+		Status:    status,
+		Detail:    fmt.Sprintf(format, args...),
+	})
+}
+
+// open dials a connection for one check. The connection is closed when ctx
+// ends and times out after the check timeout.
+func (run *conformanceRun) open(ctx context.Context) (net.Conn, error) {
+	conn, err := run.cfg.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	_ = conn.SetDeadline(time.Now().Add(run.cfg.checkTimeout))
+	stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+	return &conformanceConn{Conn: conn, stop: stop}, nil
+}
+
+// conformanceConn stops the close-on-cancel hook of open when closed.
+type conformanceConn struct {
+	net.Conn
+	stop func() bool
+}
+
+// Close implements net.Conn.
+func (c *conformanceConn) Close() error {
+	c.stop()
+	return c.Conn.Close()
+}
+
+// handshake dials a connection and negotiates the given protocol version,
+// recording a failure under name if that is not possible.
+func (run *conformanceRun) handshake(ctx context.Context, name, reference string, minor uint) (net.Conn, bool) {
+	conn, err := run.open(ctx)
+	if err != nil {
+		run.add(name, reference, ConformanceFail, "connect: %v", err)
+		return nil, false
+	}
+	if _, _, err := rfb.ReadProtocolVersion(conn); err != nil {
+		_ = conn.Close()
+		run.add(name, reference, ConformanceFail, "read ProtocolVersion: %v", err)
+		return nil, false
+	}
+	if err := rfb.WriteProtocolVersion(conn, 3, minor); err != nil {
+		_ = conn.Close()
+		run.add(name, reference, ConformanceFail, "write ProtocolVersion: %v", err)
+		return nil, false
+	}
+	return conn, true
+}
+
+// checkGreeting verifies the ProtocolVersion message sent by the server.
+func (run *conformanceRun) checkGreeting(conn net.Conn) {
+	const name, reference = "version/greeting", "RFC 6143 §7.1.1"
+
+	major, minor, err := rfb.ReadProtocolVersion(conn)
+	switch {
+	case err != nil:
+		run.add(name, reference, ConformanceFail, "invalid ProtocolVersion: %v", err)
+	case major == 3 && (minor == 3 || minor == 7 || minor == 8):
+		run.add(name, reference, ConformancePass, "RFB %d.%d", major, minor)
+	default:
+		run.add(name, reference, ConformanceWarn, "RFB %d.%d is not a version published in RFC 6143", major, minor)
+	}
+}
+
+// checkVersion verifies that a client announcing RFB 3.minor receives the
+// single security type of RFB 3.3. RFC 6143 requires this for 3.3 itself and
+// recommends it for unknown minor versions, so violations are reported with
+// the given status.
+func (run *conformanceRun) checkVersion(ctx context.Context, minor uint, violation ConformanceStatus) {
+	name := fmt.Sprintf("version/rfb3%d", minor)
+	const reference = "RFC 6143 §7.1.1"
+
+	conn, ok := run.handshake(ctx, name, reference, minor)
+	if !ok {
+		return
+	}
+	defer func() { _ = conn.Close() }()
+
+	var buf [4]byte
+	if _, err := io.ReadFull(conn, buf[:]); err != nil {
+		run.add(name, reference, violation, "read security type: %v", err)
+		return
+	}
+
+	switch securityType := binary.BigEndian.Uint32(buf[:]); securityType {
+	case 0:
+		reason, err := rfb.ReadReason(conn)
+		if err != nil {
+			run.add(name, reference, violation, "connection refused without a readable reason: %v", err)
+			return
+		}
+		run.add(name, reference, ConformancePass, "connection refused: %s", reason)
+	case uint32(rfb.SecurityNone), uint32(rfb.SecurityVNCAuth):
+		run.add(name, reference, ConformancePass, "security type %d", securityType)
+	default:
+		run.add(name, reference, violation, "security type %d is not allowed in RFB 3.3", securityType)
+	}
+}
+
+// checkSecurityTypes verifies the security type list sent to an RFB 3.8
+// client.
+func (run *conformanceRun) checkSecurityTypes(ctx context.Context) {
+	const name, reference = "security/types", "RFC 6143 §7.1.2"
+
+	conn, ok := run.handshake(ctx, name, reference, 8)
+	if !ok {
+		return
+	}
+	defer func() { _ = conn.Close() }()
+
+	types, err := rfb.ReadSecurityTypes(conn)
+	var failure *rfb.FailureError
+	switch {
+	case errors.As(err, &failure):
+		run.add(name, reference, ConformancePass, "connection refused: %s", failure.Reason)
+	case err != nil:
+		run.add(name, reference, ConformanceFail, "read security types: %v", err)
+	case slices.Contains(types, 0):
+		run.add(name, reference, ConformanceFail, "security type 0 (Invalid) offered in %v", types)
+	default:
+		run.securityTypes = types
+		run.add(name, reference, ConformancePass, "offered %v", types)
+	}
+}
+
+// checkAuthFailure answers a VNC authentication challenge wrongly and
+// verifies that the server reports the failure with a reason.
+func (run *conformanceRun) checkAuthFailure(ctx context.Context) {
+	const name, reference = "security/failure-reason", "RFC 6143 §7.1.3"
+
+	switch {
+	case !run.cfg.probeAuthFailure:
+		run.add(name, reference, ConformanceSkip, "disabled; enable with WithAuthFailureProbe")
+		return
+	case !slices.Contains(run.securityTypes, rfb.SecurityVNCAuth):
+		run.add(name, reference, ConformanceSkip, "VNC authentication not offered")
+		return
+	}
+
+	conn, ok := run.handshake(ctx, name, reference, 8)
+	if !ok {
+		return
+	}
+	defer func() { _ = conn.Close() }()
+
+	if _, err := rfb.ReadSecurityTypes(conn); err != nil {
+		run.add(name, reference, ConformanceFail, "read security types: %v", err)
+		return
+	}
+	if err := rfb.WriteSecurityType(conn, rfb.SecurityVNCAuth); err != nil {
+		run.add(name, reference, ConformanceFail, "select VNC authentication: %v", err)
+		return
+	}
+	var challenge [16]byte
+	if _, err := io.ReadFull(conn, challenge[:]); err != nil {
+		run.add(name, reference, ConformanceFail, "read challenge: %v", err)
+		return
+	}
+	// The challenge itself is a wrong response for any password but one that
+	// encrypts it to itself.
+	if _, err := conn.Write(challenge[:]); err != nil {
+		run.add(name, reference, ConformanceFail, "write response: %v", err)
+		return
+	}
+
+	err := rfb.ReadSecurityResult(conn)
+	var failure *rfb.FailureError
+	switch {
+	case err == nil:
+		run.add(name, reference, ConformanceFail, "server accepted an invalid response")
+	case errors.As(err, &failure):
+		run.add(name, reference, ConformancePass, "failure reason: %s", failure.Reason)
+	default:
+		run.add(name, reference, ConformanceFail, "failed SecurityResult without a readable reason: %v", err)
+	}
+}
+
+// conformanceEncodings are the encodings verified against Raw, in order.
+var conformanceEncodings = []struct {
+	name     string
+	encoding Encoding
+}{
+	{"rre", &RREEncoding{}},
+	{"hextile", &HextileEncoding{}},
+	{"zlib", &ZlibEncoding{}},
+	{"trle", &TRLEEncoding{}},
+	{"zrle", &ZRLEEncoding{}},
+	{"tight", &TightEncoding{}},
+	{"tightpng", &TightPNGEncoding{}},
+}
+
+// checkSession completes a handshake with the client options, verifies the
+// ServerInit message, and runs the encoding checks.
+func (run *conformanceRun) checkSession(ctx context.Context) {
+	const name, reference = "init/server-init", "RFC 6143 §7.3.2"
+
+	conn, err := run.cfg.dial(ctx)
+	if err != nil {
+		run.add(name, reference, ConformanceFail, "connect: %v", err)
+		return
+	}
+	client, err := ClientWithOptions(ctx, conn, run.cfg.client...)
+	if err != nil {
+		_ = conn.Close()
+		run.add(name, reference, ConformanceFail, "handshake: %v", err)
+		run.skipEncodings("handshake failed")
+		return
+	}
+	defer func() { _ = client.Close() }()
+
+	width, height := client.GetFrameBufferSize()
+	format := client.GetPixelFormat()
+	switch {
+	case width == 0 || height == 0:
+		run.add(name, reference, ConformanceFail, "empty framebuffer %dx%d", width, height)
+		run.skipEncodings("empty framebuffer")
+		return
+	case format.Validate() != nil:
+		run.add(name, reference, ConformanceFail, "invalid pixel format: %v", format.Validate())
+		run.skipEncodings("invalid pixel format")
+		return
+	}
+	run.add(name, reference, ConformancePass, "%dx%d, %d bpp, desktop %q", width, height, format.BPP, client.GetDesktopName())
+
+	run.checkEncodings(ctx, client)
+}
+
+// skipEncodings records all encoding checks as skipped.
+func (run *conformanceRun) skipEncodings(reason string) {
+	for _, enc := range conformanceEncodings {
+		run.add("encoding/"+enc.name, "RFC 6143 §7.7", ConformanceSkip, "%s", reason)
+	}
+}
+
+// checkEncodings requests the desktop with each encoding and compares it with
+// a Raw reference.
+func (run *conformanceRun) checkEncodings(ctx context.Context, client *ClientConn) {
+	const reference = "RFC 6143 §7.7"
+
+	capture := func(enc Encoding) (*image.RGBA, error) {
+		if err := client.SetEncodings([]Encoding{enc}); err != nil {
+			return nil, err
+		}
+		captureCtx, cancel := context.WithTimeout(ctx, run.cfg.checkTimeout)
+		defer cancel()
+		return client.Screenshot(captureCtx)
+	}
+
+	want, err := capture(&RawEncoding{})
+	if err != nil {
+		run.skipEncodings(fmt.Sprintf("raw reference: %v", err))
+		return
+	}
+
+	for i, enc := range conformanceEncodings {
+		name := "encoding/" + enc.name
+		before := client.Stats().Encodings[enc.encoding.Type()].Rectangles
+
+		got, err := capture(enc.encoding)
+		if err != nil {
+			run.add(name, reference, ConformanceFail, "decode: %v", err)
+			// A decoding error ends the session.
+			for _, rest := range conformanceEncodings[i+1:] {
+				run.add("encoding/"+rest.name, reference, ConformanceSkip, "session ended by an earlier failure")
+			}
+			return
+		}
+		if client.Stats().Encodings[enc.encoding.Type()].Rectangles == before {
+			run.add(name, reference, ConformanceSkip, "not used by the server")
+			continue
+		}
+
+		diff := countDifferentPixels(want, got)
+		if diff == 0 {
+			run.add(name, reference, ConformancePass, "matches Raw")
+			continue
+		}
+
+		// Tell a decoding difference from a change of the desktop.
+		current, err := capture(&RawEncoding{})
+		if err == nil && countDifferentPixels(want, current) > 0 {
+			run.add(name, reference, ConformanceWarn, "screen changed during the check")
+			want = current
+			continue
+		}
+		run.add(name, reference, ConformanceFail, "%d pixels differ from Raw", diff)
+	}
+}
+
+// countDifferentPixels returns the number of pixels that differ between two
+// images of the same bounds, or the area of the larger one if the bounds
+// differ.
+func countDifferentPixels(a, b *image.RGBA) int {
+	if a.Rect != b.Rect {
+		return max(a.Rect.Dx()*a.Rect.Dy(), b.Rect.Dx()*b.Rect.Dy())
+	}
+	diff := 0
+	for y := a.Rect.Min.Y; y < a.Rect.Max.Y; y++ {
+		for x := a.Rect.Min.X; x < a.Rect.Max.X; x++ {
+			if a.RGBAAt(x, y) != b.RGBAAt(x, y) {
+				diff++
+			}
+		}
+	}
+	return diff
+}
@@ -0,0 +1,219 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+//go:build !vnc_minimal
+
+package vnc
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tenthirtyam/go-vnc/rfb"
+)
+
+// conformanceServer is a minimal RFB 3.8 server with a uniform 4x4 desktop
+// that answers update requests with RRE when offered and Raw otherwise.
+type conformanceServer struct {
+	// rreColor is the background pixel sent in RRE rectangles; a value other
+	// than the desktop color simulates a broken encoder.
+	rreColor uint32
+}
+
+// conformanceDesktop is the pixel value of every pixel of the desktop.
+const conformanceDesktop = 0x00336699
+
+// dial returns a client connection served by a new goroutine.
+func (s *conformanceServer) dial(t *testing.T) func(context.Context) (net.Conn, error) {
+	return func(context.Context) (net.Conn, error) {
+		serverConn, clientConn := net.Pipe()
+		t.Cleanup(func() { serverConn.Close() })
+		go func() {
+			defer serverConn.Close()
+			_ = s.serve(serverConn)
+		}()
+		return clientConn, nil
+	}
+}
+
+// serve runs one connection.
+func (s *conformanceServer) serve(conn net.Conn) error {
+	if err := rfb.WriteProtocolVersion(conn, 3, 8); err != nil {
+		return err
+	}
+	_, minor, err := rfb.ReadProtocolVersion(conn)
+	if err != nil {
+		return err
+	}
+	if minor < 7 {
+		return binary.Write(conn, binary.BigEndian, uint32(rfb.SecurityVNCAuth))
+	}
+
+	if err := rfb.WriteSecurityTypes(conn, []uint8{rfb.SecurityNone, rfb.SecurityVNCAuth}); err != nil {
+		return err
+	}
+	securityType, err := rfb.ReadSecurityType(conn)
+	if err != nil {
+		return err
+	}
+	if securityType == rfb.SecurityVNCAuth {
+		var challenge [16]byte
+		if _, err := conn.Write(challenge[:]); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(conn, challenge[:]); err != nil {
+			return err
+		}
+		return rfb.WriteSecurityResult(conn, errors.New("authentication failed"))
+	}
+	if err := rfb.WriteSecurityResult(conn, nil); err != nil {
+		return err
+	}
+	if _, err := rfb.ReadClientInit(conn); err != nil {
+		return err
+	}
+	if err := rfb.WriteServerInit(conn, rfb.ServerInit{
+		Width:  4,
+		Height: 4,
+		PixelFormat: rfb.PixelFormat{
+			BPP: 32, Depth: 24, TrueColor: true,
+			RedMax: 255, GreenMax: 255, BlueMax: 255,
+			RedShift: 16, GreenShift: 8,
+		},
+		Name: "conformance",
+	}); err != nil {
+		return err
+	}
+
+	var encodings []int32
+	for {
+		msgType, err := rfb.ReadMessageType(conn)
+		if err != nil {
+			return err
+		}
+		switch msgType {
+		case rfb.SetPixelFormatMsg:
+			_, err = rfb.ReadSetPixelFormat(conn)
+		case rfb.SetEncodingsMsg:
+			encodings, err = rfb.ReadSetEncodings(conn)
+		case rfb.FramebufferUpdateRequestMsg:
+			var req rfb.FramebufferUpdateRequest
+			if req, err = rfb.ReadFramebufferUpdateRequest(conn); err == nil {
+				err = s.respond(conn, req, slices.Contains(encodings, (&RREEncoding{}).Type()))
+			}
+		default:
+			return errors.New("unexpected message")
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// respond sends the requested area as one RRE or Raw rectangle.
+func (s *conformanceServer) respond(w io.Writer, req rfb.FramebufferUpdateRequest, rre bool) error {
+	var buf bytes.Buffer
+	_ = rfb.WriteFramebufferUpdate(&buf, 1)
+	rect := rfb.Rectangle{X: req.X, Y: req.Y, Width: req.Width, Height: req.Height}
+	if rre {
+		rect.Encoding = (&RREEncoding{}).Type()
+		_ = rfb.WriteRectangle(&buf, rect)
+		buf.Write(binary.BigEndian.AppendUint32(nil, 0))
+		buf.Write(binary.LittleEndian.AppendUint32(nil, s.rreColor))
+	} else {
+		_ = rfb.WriteRectangle(&buf, rect)
+		for range int(req.Width) * int(req.Height) {
+			buf.Write(binary.LittleEndian.AppendUint32(nil, conformanceDesktop))
+		}
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// conformanceStatuses maps check names to their status.
+func conformanceStatuses(report *ConformanceReport) map[string]ConformanceStatus {
+	statuses := make(map[string]ConformanceStatus)
+	for _, result := range report.Results {
+		statuses[result.Name] = result.Status
+	}
+	return statuses
+}
+
+func TestConformance_Report(t *testing.T) {
+	srv := &conformanceServer{rreColor: conformanceDesktop}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	report, err := RunConformance(ctx, "test:5900",
+		WithConformanceDialer(srv.dial(t)),
+		WithConformanceClientOptions(WithAuth(&ClientAuthNone{})),
+		WithAuthFailureProbe(true))
+	if err != nil {
+		t.Fatalf("RunConformance: %v", err)
+	}
+	if !report.Passed() {
+		t.Errorf("report failed:\n%s", report)
+	}
+
+	want := map[string]ConformanceStatus{
+		"version/greeting":        ConformancePass,
+		"version/rfb33":           ConformancePass,
+		"version/rfb35":           ConformancePass,
+		"security/types":          ConformancePass,
+		"security/failure-reason": ConformancePass,
+		"init/server-init":        ConformancePass,
+		"encoding/rre":            ConformancePass,
+		"encoding/hextile":        ConformanceSkip,
+		"encoding/tight":          ConformanceSkip,
+	}
+	got := conformanceStatuses(report)
+	for name, status := range want {
+		if got[name] != status {
+			t.Errorf("%s = %v, want %v\n%s", name, got[name], status, report)
+		}
+	}
+	if !strings.Contains(report.String(), "PASS  version/greeting") {
+		t.Errorf("String() = %q, missing greeting line", report)
+	}
+}
+
+func TestConformance_EncodingMismatch(t *testing.T) {
+	srv := &conformanceServer{rreColor: 0x00ff0000}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	report, err := RunConformance(ctx, "test:5900",
+		WithConformanceDialer(srv.dial(t)),
+		WithConformanceClientOptions(WithAuth(&ClientAuthNone{})))
+	if err != nil {
+		t.Fatalf("RunConformance: %v", err)
+	}
+	if report.Passed() {
+		t.Errorf("report passed despite a broken RRE encoder:\n%s", report)
+	}
+
+	got := conformanceStatuses(report)
+	if got["encoding/rre"] != ConformanceFail {
+		t.Errorf("encoding/rre = %v, want FAIL\n%s", got["encoding/rre"], report)
+	}
+	if got["security/failure-reason"] != ConformanceSkip {
+		t.Errorf("security/failure-reason = %v, want SKIP without the probe", got["security/failure-reason"])
+	}
+}
+
+func TestConformance_Unreachable(t *testing.T) {
+	dialErr := errors.New("connection refused")
+	_, err := RunConformance(context.Background(), "test:5900",
+		WithConformanceDialer(func(context.Context) (net.Conn, error) { return nil, dialErr }))
+	if !errors.Is(err, dialErr) || !IsVNCError(err, ErrNetwork) {
+		t.Errorf("RunConformance error = %v, want a network error wrapping the dial error", err)
+	}
+}
@@ -0,0 +1,134 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package vnc
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/tenthirtyam/go-vnc/rfb"
+)
+
+// ContinuousUpdatesPseudoEncoding represents the ContinuousUpdates
+// pseudo-encoding. Including it in SetEncodings announces support for
+// continuous updates, in which the server sends the changes to an area as they
+// happen instead of answering one FramebufferUpdateRequest per frame. The
+// server confirms support by sending an EndOfContinuousUpdatesMessage rather
+// than a rectangle.
+type ContinuousUpdatesPseudoEncoding struct{}
+
+// Type returns the encoding type identifier for ContinuousUpdates pseudo-encoding.
+func (*ContinuousUpdatesPseudoEncoding) Type() int32 {
+	return rfb.PseudoEncodingContinuousUpdates
+}
+
+// IsPseudo returns true indicating this is a pseudo-encoding.
+func (*ContinuousUpdatesPseudoEncoding) IsPseudo() bool {
+	return true
+}
+
+// Read decodes a ContinuousUpdates rectangle, which carries no payload.
+// Servers confirm the pseudo-encoding with an EndOfContinuousUpdatesMessage
+// instead.
+func (e *ContinuousUpdatesPseudoEncoding) Read(*ClientConn, *Rectangle, io.Reader) (Encoding, error) {
+	return e, nil
+}
+
+// EndOfContinuousUpdatesMessage represents an EndOfContinuousUpdates message
+// from the server (message type 150). The first one confirms the
+// ContinuousUpdates pseudo-encoding; later ones follow the last update sent
+// after the client disables continuous updates.
+type EndOfContinuousUpdatesMessage struct{}
+
+// Type returns the message type identifier for end of continuous updates messages.
+func (*EndOfContinuousUpdatesMessage) Type() uint8 {
+	return rfb.EndOfContinuousUpdatesMsg
+}
+
+// Read parses an EndOfContinuousUpdates message, which has no body, and
+// records that the server supports continuous updates and is no longer
+// sending them.
+func (*EndOfContinuousUpdatesMessage) Read(c *ClientConn, _ io.Reader) (ServerMessage, error) {
+	if !c.continuousUpdates.Swap(true) {
+		c.logger.Info("Server supports continuous updates")
+	}
+	if c.continuousUpdatesActive.Swap(false) {
+		c.logger.Debug("Continuous updates ended")
+	}
+	return &EndOfContinuousUpdatesMessage{}, nil
+}
+
+// Write encodes the message for a client, as servers built with the server
+// package send it.
+func (*EndOfContinuousUpdatesMessage) Write(w io.Writer) error {
+	if err := rfb.WriteEndOfContinuousUpdates(w); err != nil {
+		return networkError("EndOfContinuousUpdatesMessage.Write", "failed to write end of continuous updates", err)
+	}
+	return nil
+}
+
+// ContinuousUpdatesSupported reports whether the server has confirmed the
+// ContinuousUpdates pseudo-encoding for this connection.
+func (c *ClientConn) ContinuousUpdatesSupported() bool {
+	return c.continuousUpdates.Load()
+}
+
+// ContinuousUpdatesActive reports whether continuous updates are enabled:
+// EnableContinuousUpdates enabled them and the server has not yet sent the
+// EndOfContinuousUpdatesMessage that follows disabling them.
+func (c *ClientConn) ContinuousUpdatesActive() bool {
+	return c.continuousUpdatesActive.Load()
+}
+
+// EnableContinuousUpdates starts or stops continuous updates of the given
+// area. While enabled, the server sends FramebufferUpdate messages for changes
+// within the area as they happen, paced by its own flow control, so
+// high-frame-rate streaming needs no FramebufferUpdateRequest per frame:
+//
+//	client.SetEncodings([]Encoding{&RawEncoding{}, &ContinuousUpdatesPseudoEncoding{}})
+//	...
+//	if client.ContinuousUpdatesSupported() {
+//		width, height := client.GetFrameBufferSize()
+//		_ = client.EnableContinuousUpdates(true, 0, 0, width, height)
+//	}
+//
+// Enabling again replaces the area. After disabling, the server finishes the
+// update in progress and sends an EndOfContinuousUpdatesMessage; until it
+// arrives ContinuousUpdatesActive still reports true. The area is not changed
+// when the desktop is resized, so clients enable continuous updates again for
+// the new size.
+//
+// The server must have confirmed ContinuousUpdatesPseudoEncoding, which
+// ContinuousUpdatesSupported reports; until then EnableContinuousUpdates
+// returns an unsupported error, as servers without the extension close the
+// connection on receiving the message.
+func (c *ClientConn) EnableContinuousUpdates(enable bool, x, y, width, height uint16) error {
+	if !c.continuousUpdates.Load() {
+		return c.enrichError(unsupportedError("EnableContinuousUpdates",
+			"the server has not confirmed the ContinuousUpdates pseudo-encoding", nil))
+	}
+
+	c.logger.Debug("Sending enable continuous updates",
+		Field{Key: "enable", Value: enable},
+		Field{Key: "x", Value: x},
+		Field{Key: "y", Value: y},
+		Field{Key: "width", Value: width},
+		Field{Key: "height", Value: height})
+
+	var buf bytes.Buffer
+	msg := rfb.EnableContinuousUpdates{Enable: enable, X: x, Y: y, Width: width, Height: height}
+	if err := rfb.WriteEnableContinuousUpdates(&buf, msg); err != nil {
+		return c.enrichError(encodingError("EnableContinuousUpdates", "failed to encode enable continuous updates", err))
+	}
+
+	if err := c.writeWithContext(c.ctx, buf.Bytes()); err != nil {
+		c.logger.Error("Failed to send enable continuous updates", Field{Key: "error", Value: err})
+		return c.enrichError(networkError("EnableContinuousUpdates", "failed to send enable continuous updates", err))
+	}
+	if enable {
+		c.continuousUpdatesActive.Store(true)
+	}
+
+	return nil
+}
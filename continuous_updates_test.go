@@ -0,0 +1,70 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package vnc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/tenthirtyam/go-vnc/rfb"
+)
+
+func TestContinuousUpdates_EnableAndEnd(t *testing.T) {
+	msgs := make(chan ServerMessage, 4)
+	srv, conn := newUpdateServer(t, 16, 16, WithServerMessageChannel(msgs))
+
+	if err := conn.EnableContinuousUpdates(true, 0, 0, 16, 16); !IsVNCError(err, ErrUnsupported) {
+		t.Fatalf("EnableContinuousUpdates before confirmation = %v, want an unsupported error", err)
+	}
+
+	endOfContinuousUpdates := func() {
+		t.Helper()
+		go func() { _, _ = srv.conn.Write([]byte{rfb.EndOfContinuousUpdatesMsg}) }()
+		select {
+		case msg := <-msgs:
+			if _, ok := msg.(*EndOfContinuousUpdatesMessage); !ok {
+				t.Fatalf("delivered message = %#v, want EndOfContinuousUpdates", msg)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for EndOfContinuousUpdates")
+		}
+	}
+
+	endOfContinuousUpdates()
+	if !conn.ContinuousUpdatesSupported() {
+		t.Fatal("ContinuousUpdatesSupported() = false after EndOfContinuousUpdates")
+	}
+	if conn.ContinuousUpdatesActive() {
+		t.Fatal("ContinuousUpdatesActive() = true before EnableContinuousUpdates")
+	}
+
+	if err := conn.EnableContinuousUpdates(true, 2, 4, 8, 12); err != nil {
+		t.Fatal(err)
+	}
+	want := rfb.EnableContinuousUpdates{Enable: true, X: 2, Y: 4, Width: 8, Height: 12}
+	select {
+	case got := <-srv.enables:
+		if got != want {
+			t.Errorf("EnableContinuousUpdates = %+v, want %+v", got, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for EnableContinuousUpdates")
+	}
+	if !conn.ContinuousUpdatesActive() {
+		t.Fatal("ContinuousUpdatesActive() = false after enabling")
+	}
+
+	// Disabling leaves the updates active until the server ends them.
+	if err := conn.EnableContinuousUpdates(false, 0, 0, 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	<-srv.enables
+	if !conn.ContinuousUpdatesActive() {
+		t.Fatal("ContinuousUpdatesActive() = false before EndOfContinuousUpdates")
+	}
+	endOfContinuousUpdates()
+	if conn.ContinuousUpdatesActive() {
+		t.Error("ContinuousUpdatesActive() = true after EndOfContinuousUpdates")
+	}
+}
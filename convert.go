@@ -0,0 +1,110 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package vnc
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+)
+
+// The conversions below use the pixel format and color map of the connection
+// when they are called, as the framebuffer does, so there is no converter to
+// keep in sync with SetPixelFormat and SetColorMapEntries. Rectangles and
+// colors must be converted before the pixel format they were decoded in
+// changes, for example as updates are received from the message channel.
+
+// ConvertRectToRGBA renders a decoded rectangle of a FramebufferUpdateMessage
+// as an opaque RGBA image whose bounds are the rectangle's position on the
+// desktop:
+//
+//	for _, rect := range update.Rectangles {
+//		img, err := client.ConvertRectToRGBA(&rect)
+//		if err != nil {
+//			continue // pseudo-encodings and CopyRect carry no pixels
+//		}
+//		draw.Draw(canvas, img.Bounds(), img, img.Bounds().Min, draw.Src)
+//	}
+//
+// Components are scaled as in Screenshot, including the division-free scaling
+// of WithLowPowerProfile. It returns an unsupported error for rectangles
+// whose encoding carries no pixels, such as pseudo-encodings, and for
+// CopyRect rectangles, whose pixels come from the desktop rather than the
+// rectangle; Screenshot and CurrentFrame render those.
+func (c *ClientConn) ConvertRectToRGBA(rect *Rectangle) (*image.RGBA, error) {
+	if rect == nil {
+		return nil, c.enrichError(validationError("ConvertRectToRGBA", "rectangle is required", nil))
+	}
+	painter, ok := rect.Enc.(rectPainter)
+	switch rect.Enc.(type) {
+	case *CopyRectEncoding, *DesktopSizePseudoEncoding, *ExtendedDesktopSizePseudoEncoding:
+		ok = false
+	}
+	if !ok {
+		return nil, c.enrichError(unsupportedError("ConvertRectToRGBA",
+			fmt.Sprintf("rectangles encoded with %T carry no pixels", rect.Enc), nil))
+	}
+
+	fb := c.conversionFramebuffer(rect.Width, rect.Height)
+	local := *rect
+	local.X, local.Y = 0, 0
+	painter.paint(fb, &local)
+
+	img := image.NewRGBA(fb.grid.bounds())
+	fb.grid.copyTo(img, img.Rect)
+	img.Rect = img.Rect.Add(image.Pt(int(rect.X), int(rect.Y)))
+	return img, nil
+}
+
+// ColorToRGBA converts a decoded color, such as the background of a Hextile
+// tile or an entry of the color map, to opaque RGBA.
+func (c *ClientConn) ColorToRGBA(col Color) color.RGBA {
+	return c.conversionFramebuffer(0, 0).rgba(col)
+}
+
+// AppendRGBA appends decoded colors to dst as 8-bit RGBA, four bytes per
+// color, and returns the extended slice. It suits uploading the Colors of Raw
+// rectangles or raw Hextile tiles as textures.
+func (c *ClientConn) AppendRGBA(dst []byte, colors []Color) []byte {
+	fb := c.conversionFramebuffer(0, 0)
+	dst = append(dst, make([]byte, 4*len(colors))...)
+	out := dst[len(dst)-4*len(colors):]
+	for i, col := range colors {
+		px := fb.rgba(col)
+		out[4*i], out[4*i+1], out[4*i+2], out[4*i+3] = px.R, px.G, px.B, px.A
+	}
+	return dst
+}
+
+// DecodePixels converts pixel data as sent on the wire, such as the pixels of
+// a custom encoding, to colors, with the byte order set by
+// WithForcePixelEndianness and the color map for color map formats.
+func (c *ClientConn) DecodePixels(data []byte) ([]Color, error) {
+	s := c.loadState()
+	switch s.pixelFormat.BPP {
+	case 8, 16, 32:
+	default:
+		return nil, c.enrichError(unsupportedError("DecodePixels",
+			fmt.Sprintf("unsupported bits per pixel: %d", s.pixelFormat.BPP), nil))
+	}
+	pr := newPixelReader(s.pixelFormat, s.colorMap)
+	pr.byteOrder = c.pixelByteOrder(s.pixelFormat)
+	if len(data)%pr.BytesPerPixel() != 0 {
+		return nil, c.enrichError(validationError("DecodePixels",
+			fmt.Sprintf("%d bytes is not a whole number of %d-byte pixels", len(data), pr.BytesPerPixel()), nil))
+	}
+
+	colors := make([]Color, len(data)/pr.BytesPerPixel())
+	pr.decodePixels(colors, data)
+	return colors, nil
+}
+
+// conversionFramebuffer returns a framebuffer of the given size that converts
+// colors like the client framebuffer.
+func (c *ClientConn) conversionFramebuffer(width, height uint16) *framebuffer {
+	fb := newFramebuffer(width, height)
+	fb.pf = c.GetPixelFormat()
+	fb.lowPower = c.config != nil && c.config.LowPower
+	return fb
+}
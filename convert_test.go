@@ -0,0 +1,88 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package vnc
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestConvert_RectToRGBA(t *testing.T) {
+	pf := *PixelFormat16BitRGB565
+	const w, h = 20, 18
+	pixels := encodeTestImage(pf, w, h)
+	c := newEncodeConn(pf, 64, 64)
+
+	for _, enc := range []Encoder{&RawEncoding{}, &HextileEncoding{}, &RREEncoding{}} {
+		rect := &Rectangle{X: 5, Y: 7, Width: w, Height: h}
+		data, err := enc.Encode(&pf, rect, pixels)
+		if err != nil {
+			t.Fatalf("Encode() error = %v", err)
+		}
+		if rect.Enc, err = enc.Read(c, rect, bytes.NewReader(data)); err != nil {
+			t.Fatalf("Read() error = %v", err)
+		}
+
+		img, err := c.ConvertRectToRGBA(rect)
+		if err != nil {
+			t.Fatalf("ConvertRectToRGBA(%T) error = %v", enc, err)
+		}
+		if want := image.Rect(5, 7, 5+w, 7+h); img.Bounds() != want {
+			t.Fatalf("ConvertRectToRGBA(%T) bounds = %v, want %v", enc, img.Bounds(), want)
+		}
+		for y := range h {
+			for x := range w {
+				if got, want := img.RGBAAt(5+x, 7+y), pixelRGBA(&pf, pixels[y*w+x]); got != want {
+					t.Fatalf("ConvertRectToRGBA(%T) pixel (%d,%d) = %v, want %v", enc, x, y, got, want)
+				}
+			}
+		}
+	}
+
+	if _, err := c.ConvertRectToRGBA(&Rectangle{Width: 1, Height: 1, Enc: &CopyRectEncoding{}}); !IsVNCError(err, ErrUnsupported) {
+		t.Errorf("ConvertRectToRGBA(CopyRect) error = %v, want an unsupported error", err)
+	}
+	if _, err := c.ConvertRectToRGBA(&Rectangle{Enc: &CursorPseudoEncoding{}}); !IsVNCError(err, ErrUnsupported) {
+		t.Errorf("ConvertRectToRGBA(Cursor) error = %v, want an unsupported error", err)
+	}
+	if _, err := c.ConvertRectToRGBA(nil); !IsVNCError(err, ErrValidation) {
+		t.Errorf("ConvertRectToRGBA(nil) error = %v, want a validation error", err)
+	}
+}
+
+func TestConvert_Colors(t *testing.T) {
+	c := newEncodeConn(*PixelFormat16BitRGB565, 8, 8)
+	white := Color{R: 31, G: 63, B: 31}
+
+	if got, want := c.ColorToRGBA(white), (color.RGBA{R: 255, G: 255, B: 255, A: 255}); got != want {
+		t.Errorf("ColorToRGBA() = %v, want %v", got, want)
+	}
+	got := c.AppendRGBA([]byte{9}, []Color{white, {R: 31}})
+	if want := []byte{9, 255, 255, 255, 255, 255, 0, 0, 255}; !bytes.Equal(got, want) {
+		t.Errorf("AppendRGBA() = %v, want %v", got, want)
+	}
+
+	// Conversions follow pixel format changes.
+	c.setPixelFormat(*PixelFormat32BitRGBA)
+	if got, want := c.ColorToRGBA(white), (color.RGBA{R: 31, G: 63, B: 31, A: 255}); got != want {
+		t.Errorf("ColorToRGBA() after a pixel format change = %v, want %v", got, want)
+	}
+}
+
+func TestConvert_DecodePixels(t *testing.T) {
+	c := newEncodeConn(*PixelFormat16BitRGB565, 8, 8)
+
+	colors, err := c.DecodePixels([]byte{0x1f, 0x00, 0x00, 0xf8})
+	if err != nil {
+		t.Fatalf("DecodePixels() error = %v", err)
+	}
+	if want := []Color{{B: 31}, {R: 31}}; len(colors) != 2 || colors[0] != want[0] || colors[1] != want[1] {
+		t.Errorf("DecodePixels() = %v, want %v", colors, want)
+	}
+	if _, err := c.DecodePixels([]byte{1, 2, 3}); !IsVNCError(err, ErrValidation) {
+		t.Errorf("DecodePixels() of a partial pixel error = %v, want a validation error", err)
+	}
+}
@@ -0,0 +1,66 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package vnc
+
+import (
+	"hash/fnv"
+	"image"
+)
+
+// paintCopyRect paints a CopyRect rectangle into the client framebuffer and,
+// with VerifyCopyRect set, checks the copy. The source is hashed before the
+// copy and the destination after it; the two must match, and a source that
+// does not overlap the destination must be unchanged. A source outside the
+// framebuffer means the client missed an update, usually a desktop resize,
+// that the server has already applied.
+func (c *ClientConn) paintCopyRect(fb *framebuffer, e *CopyRectEncoding, rect *Rectangle) {
+	if c.config == nil || !c.config.VerifyCopyRect {
+		e.paint(fb, rect)
+		return
+	}
+
+	w, h := int(rect.Width), int(rect.Height)
+	src := image.Rect(int(e.SrcX), int(e.SrcY), int(e.SrcX)+w, int(e.SrcY)+h)
+	dst := image.Rect(int(rect.X), int(rect.Y), int(rect.X)+w, int(rect.Y)+h)
+
+	bounds := fb.grid.bounds()
+	if !src.In(bounds) || !dst.In(bounds) {
+		e.paint(fb, rect)
+		c.copyRectMismatch(src, dst, "rectangle extends beyond the framebuffer")
+		return
+	}
+
+	before := fb.grid.checksum(src)
+	e.paint(fb, rect)
+
+	switch {
+	case fb.grid.checksum(dst) != before:
+		c.copyRectMismatch(src, dst, "destination differs from source")
+	case !src.Overlaps(dst) && fb.grid.checksum(src) != before:
+		c.copyRectMismatch(src, dst, "source changed during copy")
+	}
+}
+
+// copyRectMismatch counts and logs a failed CopyRect verification.
+func (c *ClientConn) copyRectMismatch(src, dst image.Rectangle, reason string) {
+	c.stats.mu.Lock()
+	c.stats.copyRectMismatches++
+	c.stats.mu.Unlock()
+
+	c.logger.Warn("CopyRect verification failed",
+		Field{Key: "reason", Value: reason},
+		Field{Key: "source", Value: src.String()},
+		Field{Key: "destination", Value: dst.String()})
+}
+
+// checksum returns an FNV-1a hash of the pixels in r, which must be within the
+// grid.
+func (g *tileGrid) checksum(r image.Rectangle) uint64 {
+	block := image.NewRGBA(r)
+	g.copyTo(block, r)
+
+	h := fnv.New64a()
+	_, _ = h.Write(block.Pix)
+	return h.Sum64()
+}
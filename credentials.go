@@ -0,0 +1,84 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package vnc
+
+import "context"
+
+// CredentialProvider supplies the credentials of an authentication method
+// when the security handshake needs them, so that they need not be stored in
+// the client configuration. Implementations can fetch them from a vault or
+// an agent, or prompt the user.
+//
+// The authentication methods clear the returned password once sent, so
+// implementations return a fresh slice for each call.
+type CredentialProvider interface {
+	// Username returns the username to authenticate as.
+	Username(ctx context.Context) (string, error)
+
+	// Password returns the password.
+	Password(ctx context.Context) ([]byte, error)
+}
+
+// resolveCredentials returns the credentials from provider, or username and
+// password without one. The username is only requested from provider if
+// wantUsername is set. Without a provider or a password, the prompt of
+// WithAuthPrompt is asked, if any. Callers clear the returned password after
+// use.
+func resolveCredentials(ctx context.Context, op string, provider CredentialProvider, username, password string, wantUsername bool) (string, []byte, error) {
+	if provider == nil {
+		state, _ := ctx.Value(authPromptKey{}).(*authPromptState)
+		if password != "" || state == nil {
+			return username, []byte(password), nil
+		}
+
+		prompt := state.prompt
+		prompt.NeedUsername = wantUsername
+		entered, secret, err := state.fn(ctx, prompt)
+		if err != nil {
+			return "", nil, authenticationError(op, "credential prompt failed", err)
+		}
+		if wantUsername && entered != "" {
+			username = entered
+		}
+		return username, secret, nil
+	}
+
+	if wantUsername {
+		var err error
+		if username, err = provider.Username(ctx); err != nil {
+			return "", nil, authenticationError(op, "failed to retrieve username", err)
+		}
+	}
+	secret, err := provider.Password(ctx)
+	if err != nil {
+		return "", nil, authenticationError(op, "failed to retrieve password", err)
+	}
+	return username, secret, nil
+}
+
+// WithKeepCredentials controls whether the passwords of the Auth methods
+// survive the handshake. By default, once the handshake has run, whether it
+// succeeded or failed, the client calls ClearPassword on every Auth method
+// that has it, so secrets do not linger in long-lived configurations. The
+// methods then cannot be reused for another connection, which would send an
+// empty password. Keep them to reuse the methods for further connections or
+// retries; a Session always keeps them to reconnect.
+func WithKeepCredentials(keep bool) ClientOption {
+	return func(cfg *ClientConfig) {
+		cfg.KeepCredentials = keep
+	}
+}
+
+// clearCredentials clears the passwords of the Auth methods and of auth, the
+// method selected for the handshake, if any, unless KeepCredentials is set.
+func (c *ClientConn) clearCredentials(auth ClientAuth) {
+	if c.config == nil || c.config.KeepCredentials {
+		return
+	}
+	for _, method := range append([]ClientAuth{auth}, c.config.Auth...) {
+		if clearer, ok := method.(interface{ ClearPassword() }); ok {
+			clearer.ClearPassword()
+		}
+	}
+}
@@ -0,0 +1,139 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package vnc
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+)
+
+// testCredentials is a CredentialProvider that counts its calls.
+type testCredentials struct {
+	username, password string
+	err                error
+	calls              int
+}
+
+func (c *testCredentials) Username(ctx context.Context) (string, error) {
+	c.calls++
+	return c.username, c.err
+}
+
+func (c *testCredentials) Password(ctx context.Context) ([]byte, error) {
+	c.calls++
+	return []byte(c.password), c.err
+}
+
+func TestPasswordAuth_CredentialProvider(t *testing.T) {
+	server, client := net.Pipe()
+	defer func() { _ = client.Close() }()
+
+	challenge := bytes.Repeat([]byte{0x5a}, VNCChallengeSize)
+	done := make(chan []byte, 1)
+	go func() {
+		defer func() { _ = server.Close() }()
+		defer close(done)
+		if _, err := server.Write(challenge); err != nil {
+			return
+		}
+		response := make([]byte, VNCChallengeSize)
+		if _, err := io.ReadFull(server, response); err == nil {
+			done <- response
+		}
+	}()
+
+	provider := &testCredentials{password: "vaulted"}
+	auth := &PasswordAuth{Credentials: provider}
+	if err := NewAuthRegistry().ValidateAuthMethod(auth); err != nil {
+		t.Fatalf("ValidateAuthMethod() error = %v", err)
+	}
+	if provider.calls != 0 {
+		t.Errorf("provider consulted %d times before the handshake", provider.calls)
+	}
+	if err := auth.Handshake(context.Background(), client); err != nil {
+		t.Fatalf("Handshake() error = %v", err)
+	}
+
+	want, err := NewSecureDESCipher().EncryptVNCChallenge("vaulted", challenge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := <-done; !bytes.Equal(got, want) {
+		t.Errorf("response = %x, want %x", got, want)
+	}
+	if provider.calls != 1 {
+		t.Errorf("provider consulted %d times, want 1", provider.calls)
+	}
+}
+
+func TestPlainAuth_CredentialProvider(t *testing.T) {
+	server, client := net.Pipe()
+	defer func() { _ = client.Close() }()
+
+	done := make(chan string, 1)
+	go func() {
+		defer func() { _ = server.Close() }()
+		defer close(done)
+		username, password, err := readPlainCredentials(server)
+		if err == nil {
+			done <- username + ":" + password
+		}
+	}()
+
+	auth := &PlainAuth{Username: "static", Credentials: &testCredentials{username: "agent", password: "secret"}}
+	if err := auth.Handshake(context.Background(), client); err != nil {
+		t.Fatalf("Handshake() error = %v", err)
+	}
+	if got := <-done; got != "agent:secret" {
+		t.Errorf("credentials = %q, want agent:secret", got)
+	}
+}
+
+func TestCredentialProvider_Error(t *testing.T) {
+	provider := &testCredentials{err: errors.New("vault sealed")}
+
+	// The password is retrieved before any exchange with the server.
+	err := (&PasswordAuth{Credentials: provider}).Handshake(context.Background(), nil)
+	if !IsVNCError(err, ErrAuthentication) || !errors.Is(err, provider.err) {
+		t.Errorf("Handshake() error = %v, want authentication error wrapping %v", err, provider.err)
+	}
+}
+
+func TestClearCredentials_AfterHandshake(t *testing.T) {
+	tests := []struct {
+		name     string
+		password string
+		keep     bool
+		wantKept bool
+	}{
+		{"cleared after success", "letmein", false, false},
+		{"kept on request", "letmein", true, true},
+		{"cleared after failure", "wrong", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			serverConn, clientConn := net.Pipe()
+			go func() {
+				defer func() { _ = serverConn.Close() }()
+				_ = servePasswordLogin(serverConn, "letmein", "bad password")
+			}()
+
+			used, spare := NewPasswordAuth(tt.password), &SASLAuth{Mechanisms: []SASLMechanism{&SASLPlain{Password: "spare"}}}
+			conn, err := ClientWithOptions(context.Background(), clientConn,
+				WithAuth(used, spare), WithKeepCredentials(tt.keep))
+			if err == nil {
+				_ = conn.Close()
+			}
+
+			kept := used.Password != "" && spare.Mechanisms[0].(*SASLPlain).Password != ""
+			if kept != tt.wantKept {
+				t.Errorf("credentials kept = %v, want %v (handshake error %v)", kept, tt.wantKept, err)
+			}
+		})
+	}
+}
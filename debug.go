@@ -0,0 +1,170 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package vnc
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+)
+
+// Limits on the events kept for DebugBundle.
+const (
+	debugMessageLimit = 32
+	debugWarningLimit = 32
+)
+
+// DebugTranscript is the sanitized connection record produced by
+// ClientConn.DebugBundle. It never contains credentials, clipboard contents,
+// pixel data, addresses, or the desktop name, which often includes user and
+// host names.
+type DebugTranscript struct {
+	// ServerVersion and ClientVersion are the ProtocolVersion strings exchanged.
+	ServerVersion string `json:"server_version"`
+	ClientVersion string `json:"client_version"`
+
+	// SecurityTypesOffered lists the security types offered by the server and
+	// SecurityTypeSelected the one chosen by the client.
+	SecurityTypesOffered []int `json:"security_types_offered"`
+	SecurityTypeSelected int   `json:"security_type_selected"`
+
+	// ServerInit holds the fields of the ServerInit message.
+	ServerInit *DebugServerInit `json:"server_init,omitempty"`
+
+	// Encodings lists the encoding types last requested with SetEncodings.
+	Encodings []int32 `json:"encodings"`
+
+	// MessageTypes names the first server messages received after the handshake.
+	MessageTypes []string `json:"message_types"`
+
+	// Stats is a snapshot of the connection statistics. Annotations keep
+	// only their times, since their text is chosen by the application.
+	Stats Stats `json:"stats"`
+
+	// Warnings holds the first warning and error log messages, without their
+	// fields.
+	Warnings []string `json:"warnings"`
+}
+
+// DebugServerInit holds the ServerInit fields recorded by DebugTranscript.
+type DebugServerInit struct {
+	Width             uint16      `json:"width"`
+	Height            uint16      `json:"height"`
+	PixelFormat       PixelFormat `json:"pixel_format"`
+	DesktopNameLength int         `json:"desktop_name_length"`
+}
+
+// transcript records the negotiation and early traffic of a connection.
+type transcript struct {
+	mu            sync.Mutex
+	serverVersion string
+	clientVersion string
+	offered       []uint8
+	selected      uint8
+	serverInit    *DebugServerInit
+	messageTypes  []string
+	warnings      []string
+}
+
+func (t *transcript) recordVersions(server, client string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.serverVersion = strings.TrimSpace(server)
+	t.clientVersion = strings.TrimSpace(client)
+}
+
+func (t *transcript) recordSecurity(offered []uint8, selected uint8) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.offered = append([]uint8(nil), offered...)
+	t.selected = selected
+}
+
+func (t *transcript) recordServerInit(init DebugServerInit) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.serverInit = &init
+}
+
+func (t *transcript) recordMessage(name string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.messageTypes) < debugMessageLimit {
+		t.messageTypes = append(t.messageTypes, name)
+	}
+}
+
+func (t *transcript) recordWarning(level, msg string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.warnings) < debugWarningLimit {
+		t.warnings = append(t.warnings, level+": "+msg)
+	}
+}
+
+// DebugBundle returns a sanitized transcript of the connection as indented
+// JSON, suitable for attaching to bug reports about server interoperability.
+// See DebugTranscript for its contents.
+//
+// Example usage:
+//
+//	bundle, err := client.DebugBundle()
+//	if err == nil {
+//		_ = os.WriteFile("vnc-debug.json", bundle, 0o600)
+//	}
+func (c *ClientConn) DebugBundle() ([]byte, error) {
+	c.transcript.mu.Lock()
+	dt := DebugTranscript{
+		ServerVersion:        c.transcript.serverVersion,
+		ClientVersion:        c.transcript.clientVersion,
+		SecurityTypesOffered: make([]int, len(c.transcript.offered)),
+		SecurityTypeSelected: int(c.transcript.selected),
+		ServerInit:           c.transcript.serverInit,
+		MessageTypes:         append([]string{}, c.transcript.messageTypes...),
+		Warnings:             append([]string{}, c.transcript.warnings...),
+	}
+	for i, securityType := range c.transcript.offered {
+		dt.SecurityTypesOffered[i] = int(securityType)
+	}
+	c.transcript.mu.Unlock()
+
+	dt.Encodings = []int32{}
+	for _, enc := range c.GetEncodings() {
+		dt.Encodings = append(dt.Encodings, enc.Type())
+	}
+	dt.Stats = c.Stats()
+	for i := range dt.Stats.Annotations {
+		dt.Stats.Annotations[i].Text = ""
+	}
+
+	data, err := json.MarshalIndent(dt, "", "  ")
+	if err != nil {
+		return nil, c.enrichError(encodingError("DebugBundle", "failed to encode debug transcript", err))
+	}
+	return data, nil
+}
+
+// transcriptLogger forwards to a Logger and records warning and error messages
+// in a transcript.
+type transcriptLogger struct {
+	Logger
+	transcript *transcript
+}
+
+// Warn records msg and forwards it.
+func (l *transcriptLogger) Warn(msg string, fields ...Field) {
+	l.transcript.recordWarning("warn", msg)
+	l.Logger.Warn(msg, fields...)
+}
+
+// Error records msg and forwards it.
+func (l *transcriptLogger) Error(msg string, fields ...Field) {
+	l.transcript.recordWarning("error", msg)
+	l.Logger.Error(msg, fields...)
+}
+
+// With returns a transcriptLogger that records into the same transcript.
+func (l *transcriptLogger) With(fields ...Field) Logger {
+	return &transcriptLogger{Logger: l.Logger.With(fields...), transcript: l.transcript}
+}
@@ -0,0 +1,109 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package vnc
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDebug_Bundle(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+
+	go func() {
+		_, _ = io.Copy(io.Discard, serverConn)
+	}()
+	go func() {
+		_, _ = serverConn.Write(replayHandshake(4, 3, "secret-host:1"))
+	}()
+
+	conn, err := ClientWithOptions(context.Background(), clientConn,
+		WithAuth(&ClientAuthNone{}), WithManualPump(true))
+	if err != nil {
+		t.Fatalf("handshake failed: %v", err)
+	}
+	defer func() { _ = conn.CloseAndWait() }()
+
+	if err := conn.SetEncodings([]Encoding{&RawEncoding{}, &DesktopSizePseudoEncoding{}}); err != nil {
+		t.Fatal(err)
+	}
+
+	var s replayStream
+	s.write(uint8(2))
+	s.write(uint8(3), []byte{0, 0, 0}, uint32(8), []byte("password"))
+	go func() {
+		_, _ = serverConn.Write(s.bytes())
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for i := 0; i < 2; i++ {
+		if _, err := conn.ProcessNextMessage(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	bundle, err := conn.DebugBundle()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"secret-host", "password"} {
+		if strings.Contains(string(bundle), secret) {
+			t.Errorf("bundle contains %q:\n%s", secret, bundle)
+		}
+	}
+
+	var dt DebugTranscript
+	if err := json.Unmarshal(bundle, &dt); err != nil {
+		t.Fatal(err)
+	}
+
+	if dt.ServerVersion != "RFB 003.008" || dt.ClientVersion != "RFB 003.008" {
+		t.Errorf("versions = %q/%q", dt.ServerVersion, dt.ClientVersion)
+	}
+	if !reflect.DeepEqual(dt.SecurityTypesOffered, []int{1}) || dt.SecurityTypeSelected != 1 {
+		t.Errorf("security types = %v selected %d", dt.SecurityTypesOffered, dt.SecurityTypeSelected)
+	}
+	if dt.ServerInit == nil || dt.ServerInit.Width != 4 || dt.ServerInit.Height != 3 ||
+		dt.ServerInit.DesktopNameLength != len("secret-host:1") {
+		t.Errorf("server init = %+v", dt.ServerInit)
+	}
+	if !reflect.DeepEqual(dt.Encodings, []int32{0, -223}) {
+		t.Errorf("encodings = %v", dt.Encodings)
+	}
+	if !reflect.DeepEqual(dt.MessageTypes, []string{"Bell", "ServerCutText"}) {
+		t.Errorf("message types = %v", dt.MessageTypes)
+	}
+}
+
+func TestDebug_BundleOmitsAnnotationText(t *testing.T) {
+	conn := &ClientConn{logger: &NoOpLogger{}}
+	conn.Annotate("logged in as admin with hunter2")
+
+	bundle, err := conn.DebugBundle()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(bundle), "hunter2") || strings.Contains(string(bundle), "admin") {
+		t.Errorf("bundle contains annotation text:\n%s", bundle)
+	}
+
+	var dt DebugTranscript
+	if err := json.Unmarshal(bundle, &dt); err != nil {
+		t.Fatal(err)
+	}
+	if len(dt.Stats.Annotations) != 1 || dt.Stats.Annotations[0].Time.IsZero() {
+		t.Errorf("annotations = %+v, want one with its time", dt.Stats.Annotations)
+	}
+	if got := conn.Stats().Annotations[0].Text; got != "logged in as admin with hunter2" {
+		t.Errorf("Stats annotation text = %q, want it unchanged", got)
+	}
+}
@@ -0,0 +1,69 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package vnc
+
+import (
+	"runtime"
+	"sync"
+)
+
+// pixelJobBatch is the number of pixels batched into one job before it is
+// handed to a worker, so that small rectangles are expanded inline and the
+// cost of a goroutine is spread over enough work.
+const pixelJobBatch = 4096
+
+// pixelWorkers bounds the goroutines that expand pixels for all connections.
+var pixelWorkers = make(chan struct{}, runtime.GOMAXPROCS(0))
+
+// pixelJobs expands pixels in parallel with the goroutine reading a
+// rectangle, which stays the only one reading the wire. Jobs are batched and
+// run on a worker when one of the pixelWorkers is free, or inline otherwise,
+// so a busy process degrades to sequential decoding instead of queueing.
+type pixelJobs struct {
+	wg     sync.WaitGroup
+	batch  []func()
+	pixels int
+}
+
+// add queues fn, which expands the given number of pixels.
+func (j *pixelJobs) add(pixels int, fn func()) {
+	j.batch = append(j.batch, fn)
+	j.pixels += pixels
+	if j.pixels >= pixelJobBatch {
+		j.dispatch()
+	}
+}
+
+// dispatch runs the batched jobs on a free worker, or inline if none is.
+func (j *pixelJobs) dispatch() {
+	batch := j.batch
+	j.batch, j.pixels = nil, 0
+
+	select {
+	case pixelWorkers <- struct{}{}:
+		j.wg.Add(1)
+		go func() {
+			defer func() {
+				<-pixelWorkers
+				j.wg.Done()
+			}()
+			for _, fn := range batch {
+				fn()
+			}
+		}()
+	default:
+		for _, fn := range batch {
+			fn()
+		}
+	}
+}
+
+// finish runs the jobs still batched inline and waits for the workers.
+func (j *pixelJobs) finish() {
+	for _, fn := range j.batch {
+		fn()
+	}
+	j.batch, j.pixels = nil, 0
+	j.wg.Wait()
+}
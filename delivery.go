@@ -0,0 +1,111 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package vnc
+
+import (
+	"time"
+)
+
+// DeliveryStats reports how server messages reach the channel configured with
+// WithServerMessageChannel, so applications can size its buffer from data: a
+// channel whose queue regularly fills, or whose deliveries block, is too small
+// for the rate at which the application drains it.
+type DeliveryStats struct {
+	// Delivered is the number of messages sent to the channel.
+	Delivered uint64
+
+	// Dropped is the number of messages read from the server but never
+	// delivered, because no channel is configured or the connection closed
+	// while the message waited for space in the channel.
+	Dropped uint64
+
+	// QueueDepth and QueueCapacity are the number of messages buffered in
+	// the channel when the snapshot was taken and the size of its buffer.
+	QueueDepth    int
+	QueueCapacity int
+
+	// MaxQueueDepth is the largest number of messages buffered in the
+	// channel right after a delivery.
+	MaxQueueDepth int
+
+	// Blocked is the number of deliveries that waited because the channel
+	// was full, which also stops the connection from reading the server.
+	Blocked uint64
+
+	// BlockedTime is the total time spent waiting in blocked deliveries.
+	BlockedTime time.Duration
+
+	// MaxLatency is the longest time a single delivery waited.
+	MaxLatency time.Duration
+}
+
+// BlockRate returns the fraction of deliveries that had to wait for space in
+// the channel, or 0 if no message has been delivered.
+func (s DeliveryStats) BlockRate() float64 {
+	if s.Delivered == 0 {
+		return 0
+	}
+	return float64(s.Blocked) / float64(s.Delivered)
+}
+
+// deliverMessage sends a server message to the configured channel, waiting
+// for space until the connection closes, and records the delivery. It
+// reports false if the connection closed first.
+func (c *ClientConn) deliverMessage(msg ServerMessage) bool {
+	ch := c.config.ServerMessageCh
+	if ch == nil {
+		c.logger.Debug("No server message channel configured, discarding message")
+		c.recordDelivery(false, 0, 0)
+		return true
+	}
+
+	select {
+	case ch <- msg:
+		c.recordDelivery(true, 0, len(ch))
+		return true
+	default:
+	}
+
+	start := time.Now()
+	select {
+	case ch <- msg:
+		c.recordDelivery(true, time.Since(start), len(ch))
+		return true
+	case <-c.ctx.Done():
+		c.recordDelivery(false, 0, 0)
+		return false
+	}
+}
+
+// recordDelivery adds a delivered or dropped message to the statistics. A
+// nonzero wait marks a delivery that blocked on a full channel; depth is the
+// number of messages buffered after it.
+func (c *ClientConn) recordDelivery(delivered bool, wait time.Duration, depth int) {
+	c.stats.mu.Lock()
+	defer c.stats.mu.Unlock()
+
+	d := &c.stats.delivery
+	if !delivered {
+		d.Dropped++
+		return
+	}
+	d.Delivered++
+	d.MaxQueueDepth = max(d.MaxQueueDepth, depth)
+	if wait > 0 {
+		d.Blocked++
+		d.BlockedTime += wait
+		d.MaxLatency = max(d.MaxLatency, wait)
+	}
+}
+
+// deliveryStats returns the delivery statistics with the current queue depth
+// of the channel. The caller holds c.stats.mu.
+func (c *ClientConn) deliveryStats() DeliveryStats {
+	d := c.stats.delivery
+	if c.config != nil && c.config.ServerMessageCh != nil {
+		d.QueueDepth = len(c.config.ServerMessageCh)
+		d.QueueCapacity = cap(c.config.ServerMessageCh)
+	}
+	return d
+}
@@ -0,0 +1,423 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+//go:build !vnc_minimal
+
+package vnc
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultDiscoveryTimeout bounds Discover when ctx has no deadline, since
+// multicast discovery has no natural end.
+const DefaultDiscoveryTimeout = 2 * time.Second
+
+// Discovery sources reported in Target.Source.
+const (
+	SourceDNSSRV = "dns-srv"
+	SourceMDNS   = "mdns"
+)
+
+// Target is a VNC server found by discovery.
+type Target struct {
+	// Name is the advertised instance name, such as "Office iMac", or the host
+	// name for unicast DNS records.
+	Name string
+
+	// Host is the server host name without a trailing dot.
+	Host string
+
+	// Port is the server TCP port.
+	Port uint16
+
+	// Addrs holds the addresses advertised for Host, if any. mDNS responders
+	// usually include them, which avoids a second lookup of a .local name.
+	Addrs []net.IP
+
+	// Source identifies the discoverer that found the target.
+	Source string
+}
+
+// Address returns a dialable host:port, preferring an advertised address.
+func (t Target) Address() string {
+	host := t.Host
+	if len(t.Addrs) > 0 {
+		host = t.Addrs[0].String()
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(t.Port)))
+}
+
+// Discoverer finds VNC servers. Implement it to plug other directories, such
+// as an inventory service, into Discover.
+type Discoverer interface {
+	Discover(ctx context.Context) ([]Target, error)
+}
+
+// Discover runs the discoverers concurrently and returns their targets with
+// duplicates removed, in discoverer order. With no discoverers it browses the
+// local network with MDNSDiscoverer. Targets found before an error are still
+// returned along with the error.
+//
+// Example usage:
+//
+//	targets, err := vnc.Discover(ctx,
+//		&vnc.MDNSDiscoverer{},
+//		&vnc.SRVDiscoverer{Domain: "example.com"},
+//	)
+//	for _, target := range targets {
+//		fmt.Printf("%s at %s\n", target.Name, target.Address())
+//	}
+func Discover(ctx context.Context, discoverers ...Discoverer) ([]Target, error) {
+	if len(discoverers) == 0 {
+		discoverers = []Discoverer{&MDNSDiscoverer{}}
+	}
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, DefaultDiscoveryTimeout)
+		defer cancel()
+	}
+
+	type result struct {
+		targets []Target
+		err     error
+	}
+	results := make([]chan result, len(discoverers))
+	for i, discoverer := range discoverers {
+		results[i] = make(chan result, 1)
+		go func() {
+			targets, err := discoverer.Discover(ctx)
+			results[i] <- result{targets, err}
+		}()
+	}
+
+	var targets []Target
+	var errs []error
+	seen := make(map[string]bool)
+	for _, ch := range results {
+		r := <-ch
+		if r.err != nil {
+			errs = append(errs, r.err)
+		}
+		for _, target := range r.targets {
+			key := net.JoinHostPort(strings.ToLower(target.Host), strconv.Itoa(int(target.Port)))
+			if !seen[key] {
+				seen[key] = true
+				targets = append(targets, target)
+			}
+		}
+	}
+
+	return targets, errors.Join(errs...)
+}
+
+// SRVDiscoverer finds servers published as _rfb._tcp SRV records in unicast
+// DNS, ordered by priority and weight.
+type SRVDiscoverer struct {
+	// Domain is the DNS domain to query, such as "example.com".
+	Domain string
+
+	// Resolver performs the lookup. Nil uses net.DefaultResolver.
+	Resolver *net.Resolver
+}
+
+// Discover implements Discoverer.
+func (d *SRVDiscoverer) Discover(ctx context.Context) ([]Target, error) {
+	resolver := d.Resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+
+	_, records, err := resolver.LookupSRV(ctx, "rfb", "tcp", d.Domain)
+	if err != nil {
+		return nil, networkError("SRVDiscoverer.Discover", fmt.Sprintf("failed to look up _rfb._tcp.%s", d.Domain), err)
+	}
+
+	targets := make([]Target, 0, len(records))
+	for _, record := range records {
+		host := strings.TrimSuffix(record.Target, ".")
+		targets = append(targets, Target{Name: host, Host: host, Port: record.Port, Source: SourceDNSSRV})
+	}
+	return targets, nil
+}
+
+// Multicast DNS parameters from RFC 6762.
+const (
+	mdnsAddress        = "224.0.0.251:5353"
+	mdnsDefaultService = "_rfb._tcp.local."
+	mdnsMaxMessage     = 9000
+)
+
+// DNS record types and classes used by MDNSDiscoverer.
+const (
+	dnsTypeA         = 1
+	dnsTypePTR       = 12
+	dnsTypeAAAA      = 28
+	dnsTypeSRV       = 33
+	dnsClassIN       = 1
+	dnsUnicastAnswer = 0x8000
+	dnsMaxPointers   = 16
+)
+
+// MDNSDiscoverer browses the local network for servers advertised with
+// multicast DNS service discovery (Bonjour), such as macOS Screen Sharing and
+// Linux servers announced through Avahi. It sends a one-shot query from an
+// ephemeral port, so it works alongside a system mDNS responder, and collects
+// answers until ctx ends.
+type MDNSDiscoverer struct {
+	// Service is the DNS-SD service type to browse. Empty means
+	// "_rfb._tcp.local.".
+	Service string
+
+	// Addr overrides the multicast group and port queried. Empty means
+	// 224.0.0.251:5353.
+	Addr string
+}
+
+// Discover implements Discoverer.
+func (d *MDNSDiscoverer) Discover(ctx context.Context) ([]Target, error) {
+	service := d.Service
+	if service == "" {
+		service = mdnsDefaultService
+	}
+	if !strings.HasSuffix(service, ".") {
+		service += "."
+	}
+	address := d.Addr
+	if address == "" {
+		address = mdnsAddress
+	}
+
+	groupAddr, err := net.ResolveUDPAddr("udp4", address)
+	if err != nil {
+		return nil, configurationError("MDNSDiscoverer.Discover", "invalid mDNS address", err)
+	}
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{})
+	if err != nil {
+		return nil, networkError("MDNSDiscoverer.Discover", "failed to open mDNS socket", err)
+	}
+	defer conn.Close()
+
+	query, err := mdnsQuery(service)
+	if err != nil {
+		return nil, validationError("MDNSDiscoverer.Discover", "invalid service name", err)
+	}
+	if _, err := conn.WriteToUDP(query, groupAddr); err != nil {
+		return nil, networkError("MDNSDiscoverer.Discover", "failed to send mDNS query", err)
+	}
+
+	stop := context.AfterFunc(ctx, func() {
+		_ = conn.SetReadDeadline(time.Now())
+	})
+	defer stop()
+
+	records := &mdnsRecords{
+		instances: make(map[string]bool),
+		services:  make(map[string]net.SRV),
+		addrs:     make(map[string][]net.IP),
+	}
+	buf := make([]byte, mdnsMaxMessage)
+	for {
+		n, _, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			return records.targets(service), networkError("MDNSDiscoverer.Discover", "failed to read mDNS response", err)
+		}
+		// Malformed packets from other responders are ignored.
+		_ = records.parse(buf[:n], service)
+	}
+
+	return records.targets(service), nil
+}
+
+// mdnsQuery builds a PTR query for service that asks for unicast responses.
+func mdnsQuery(service string) ([]byte, error) {
+	var id [2]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return nil, err
+	}
+
+	msg := make([]byte, 12, 12+len(service)+6)
+	copy(msg, id[:])
+	binary.BigEndian.PutUint16(msg[4:], 1) // QDCOUNT
+
+	for _, label := range strings.Split(strings.TrimSuffix(service, "."), ".") {
+		if label == "" || len(label) > 63 {
+			return nil, fmt.Errorf("invalid label %q", label)
+		}
+		msg = append(msg, byte(len(label)))
+		msg = append(msg, label...)
+	}
+	msg = append(msg, 0)
+	msg = binary.BigEndian.AppendUint16(msg, dnsTypePTR)
+	msg = binary.BigEndian.AppendUint16(msg, dnsClassIN|dnsUnicastAnswer)
+	return msg, nil
+}
+
+// mdnsRecords accumulates the records of mDNS responses, keyed by lowercase
+// domain name.
+type mdnsRecords struct {
+	order     []string
+	instances map[string]bool
+	services  map[string]net.SRV
+	addrs     map[string][]net.IP
+}
+
+// parse adds the records of a DNS response message relevant to service.
+func (r *mdnsRecords) parse(msg []byte, service string) error {
+	if len(msg) < 12 {
+		return errors.New("short DNS message")
+	}
+	questions := int(binary.BigEndian.Uint16(msg[4:]))
+	records := int(binary.BigEndian.Uint16(msg[6:])) +
+		int(binary.BigEndian.Uint16(msg[8:])) +
+		int(binary.BigEndian.Uint16(msg[10:]))
+
+	off := 12
+	for i := 0; i < questions; i++ {
+		var err error
+		if _, off, err = readDNSName(msg, off); err != nil {
+			return err
+		}
+		off += 4
+	}
+
+	for i := 0; i < records; i++ {
+		name, next, err := readDNSName(msg, off)
+		if err != nil {
+			return err
+		}
+		if next+10 > len(msg) {
+			return errors.New("truncated DNS record")
+		}
+		rrType := binary.BigEndian.Uint16(msg[next:])
+		length := int(binary.BigEndian.Uint16(msg[next+8:]))
+		start := next + 10
+		if start+length > len(msg) {
+			return errors.New("truncated DNS record data")
+		}
+		data := msg[start : start+length]
+		name = strings.ToLower(name)
+
+		switch rrType {
+		case dnsTypePTR:
+			if name == strings.ToLower(service) {
+				instance, _, err := readDNSName(msg, start)
+				if err != nil {
+					return err
+				}
+				r.addInstance(instance)
+			}
+		case dnsTypeSRV:
+			if length < 7 {
+				return errors.New("short SRV record")
+			}
+			host, _, err := readDNSName(msg, start+6)
+			if err != nil {
+				return err
+			}
+			r.services[name] = net.SRV{
+				Target:   host,
+				Port:     binary.BigEndian.Uint16(data[4:]),
+				Priority: binary.BigEndian.Uint16(data[0:]),
+				Weight:   binary.BigEndian.Uint16(data[2:]),
+			}
+			// Responders may send the SRV record without the PTR record
+			// when answering a query for a known instance.
+			if strings.HasSuffix(name, "."+strings.ToLower(service)) {
+				r.addInstance(name)
+			}
+		case dnsTypeA, dnsTypeAAAA:
+			if length == net.IPv4len || length == net.IPv6len {
+				r.addrs[name] = append(r.addrs[name], net.IP(append([]byte(nil), data...)))
+			}
+		}
+		off = start + length
+	}
+	return nil
+}
+
+// addInstance records a service instance in the order first seen.
+func (r *mdnsRecords) addInstance(instance string) {
+	key := strings.ToLower(instance)
+	if !r.instances[key] {
+		r.instances[key] = true
+		r.order = append(r.order, instance)
+	}
+}
+
+// targets returns the instances that have an SRV record.
+func (r *mdnsRecords) targets(service string) []Target {
+	var targets []Target
+	for _, instance := range r.order {
+		srv, ok := r.services[strings.ToLower(instance)]
+		if !ok {
+			continue
+		}
+		name := instance
+		if len(instance) > len(service) {
+			name = instance[:len(instance)-len(service)-1]
+		}
+		targets = append(targets, Target{
+			Name:   name,
+			Host:   strings.TrimSuffix(srv.Target, "."),
+			Port:   srv.Port,
+			Addrs:  r.addrs[strings.ToLower(srv.Target)],
+			Source: SourceMDNS,
+		})
+	}
+	return targets
+}
+
+// readDNSName reads a possibly compressed domain name at off and returns it
+// with a trailing dot, along with the offset following it in msg.
+func readDNSName(msg []byte, off int) (string, int, error) {
+	var name strings.Builder
+	next := -1
+	for pointers := 0; ; {
+		if off >= len(msg) {
+			return "", 0, errors.New("truncated DNS name")
+		}
+		length := int(msg[off])
+		switch {
+		case length == 0:
+			if next < 0 {
+				next = off + 1
+			}
+			if name.Len() == 0 {
+				name.WriteByte('.')
+			}
+			return name.String(), next, nil
+		case length&0xc0 == 0xc0:
+			if off+1 >= len(msg) {
+				return "", 0, errors.New("truncated DNS name pointer")
+			}
+			if pointers++; pointers > dnsMaxPointers {
+				return "", 0, errors.New("too many DNS name pointers")
+			}
+			if next < 0 {
+				next = off + 2
+			}
+			off = int(binary.BigEndian.Uint16(msg[off:]) & 0x3fff)
+		case length > 63:
+			return "", 0, errors.New("invalid DNS label length")
+		default:
+			if off+1+length > len(msg) {
+				return "", 0, errors.New("truncated DNS label")
+			}
+			name.Write(msg[off+1 : off+1+length])
+			name.WriteByte('.')
+			off += 1 + length
+		}
+	}
+}
@@ -0,0 +1,207 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+//go:build !vnc_minimal
+
+package vnc
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// dnsMessage builds DNS response messages for discovery tests.
+type dnsMessage struct {
+	records int
+	body    []byte
+}
+
+func (m *dnsMessage) name(name string) {
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		m.body = append(m.body, byte(len(label)))
+		m.body = append(m.body, label...)
+	}
+	m.body = append(m.body, 0)
+}
+
+func (m *dnsMessage) record(name string, rrType uint16, data func()) {
+	m.records++
+	m.name(name)
+	m.body = binary.BigEndian.AppendUint16(m.body, rrType)
+	m.body = binary.BigEndian.AppendUint16(m.body, dnsClassIN)
+	m.body = binary.BigEndian.AppendUint32(m.body, 120)
+	lengthAt := len(m.body)
+	m.body = append(m.body, 0, 0)
+	data()
+	binary.BigEndian.PutUint16(m.body[lengthAt:], uint16(len(m.body)-lengthAt-2))
+}
+
+func (m *dnsMessage) ptr(name, target string) {
+	m.record(name, dnsTypePTR, func() { m.name(target) })
+}
+
+func (m *dnsMessage) srv(name, host string, port uint16) {
+	m.record(name, dnsTypeSRV, func() {
+		m.body = append(m.body, 0, 0, 0, 0)
+		m.body = binary.BigEndian.AppendUint16(m.body, port)
+		m.name(host)
+	})
+}
+
+func (m *dnsMessage) a(name string, ip net.IP) {
+	m.record(name, dnsTypeA, func() { m.body = append(m.body, ip.To4()...) })
+}
+
+// bytes returns the message as a response with id and the question copied
+// from query, if any.
+func (m *dnsMessage) bytes(id uint16, question []byte) []byte {
+	msg := make([]byte, 12)
+	binary.BigEndian.PutUint16(msg, id)
+	binary.BigEndian.PutUint16(msg[2:], 0x8400)
+	if question != nil {
+		binary.BigEndian.PutUint16(msg[4:], 1)
+	}
+	binary.BigEndian.PutUint16(msg[6:], uint16(m.records))
+	msg = append(msg, question...)
+	return append(msg, m.body...)
+}
+
+// serveDNS answers every query received on a local UDP socket with respond.
+func serveDNS(t *testing.T, respond func(query []byte) []byte) string {
+	t.Helper()
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			if reply := respond(append([]byte(nil), buf[:n]...)); reply != nil {
+				_, _ = conn.WriteToUDP(reply, addr)
+			}
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func TestDiscovery_MDNS(t *testing.T) {
+	queries := make(chan []byte, 1)
+	addr := serveDNS(t, func(query []byte) []byte {
+		queries <- query
+
+		var m dnsMessage
+		m.ptr("_rfb._tcp.local.", "Office iMac._rfb._tcp.local.")
+		m.srv("Office iMac._rfb._tcp.local.", "office-imac.local.", 5900)
+		m.a("office-imac.local.", net.IPv4(192, 168, 1, 20))
+		// Records for other services are ignored.
+		m.ptr("_ssh._tcp.local.", "Office iMac._ssh._tcp.local.")
+		m.srv("Office iMac._ssh._tcp.local.", "office-imac.local.", 22)
+		return m.bytes(binary.BigEndian.Uint16(query), nil)
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	targets, err := (&MDNSDiscoverer{Addr: addr}).Discover(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Target{{
+		Name:   "Office iMac",
+		Host:   "office-imac.local",
+		Port:   5900,
+		Addrs:  []net.IP{net.IPv4(192, 168, 1, 20).To4()},
+		Source: SourceMDNS,
+	}}
+	if !reflect.DeepEqual(targets, want) {
+		t.Fatalf("targets = %+v, want %+v", targets, want)
+	}
+	if got := targets[0].Address(); got != "192.168.1.20:5900" {
+		t.Errorf("Address() = %q", got)
+	}
+
+	query := <-queries
+	name, next, err := readDNSName(query, 12)
+	if err != nil || name != "_rfb._tcp.local." {
+		t.Fatalf("query name = %q, %v", name, err)
+	}
+	if qtype, qclass := binary.BigEndian.Uint16(query[next:]), binary.BigEndian.Uint16(query[next+2:]); qtype != dnsTypePTR || qclass != dnsClassIN|dnsUnicastAnswer {
+		t.Errorf("query type %d class %#x, want PTR with unicast response", qtype, qclass)
+	}
+}
+
+func TestDiscovery_SRV(t *testing.T) {
+	addr := serveDNS(t, func(query []byte) []byte {
+		_, next, err := readDNSName(query, 12)
+		if err != nil {
+			return nil
+		}
+		var m dnsMessage
+		m.srv("_rfb._tcp.example.com.", "vnc1.example.com.", 5901)
+		return m.bytes(binary.BigEndian.Uint16(query), query[12:next+4])
+	})
+
+	resolver := &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "udp", addr)
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	targets, err := Discover(ctx, &SRVDiscoverer{Domain: "example.com", Resolver: resolver})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Target{{Name: "vnc1.example.com", Host: "vnc1.example.com", Port: 5901, Source: SourceDNSSRV}}
+	if !reflect.DeepEqual(targets, want) {
+		t.Fatalf("targets = %+v, want %+v", targets, want)
+	}
+}
+
+func TestDiscovery_Merge(t *testing.T) {
+	a := discovererFunc(func(context.Context) ([]Target, error) {
+		return []Target{{Host: "Host.local", Port: 5900}, {Host: "other", Port: 5900}}, nil
+	})
+	b := discovererFunc(func(context.Context) ([]Target, error) {
+		return []Target{{Host: "host.local", Port: 5900}}, net.UnknownNetworkError("test")
+	})
+
+	targets, err := Discover(context.Background(), a, b)
+	if err == nil {
+		t.Error("expected the discoverer error to be returned")
+	}
+	if len(targets) != 2 {
+		t.Errorf("targets = %+v, want duplicates removed", targets)
+	}
+}
+
+func TestDiscovery_ReadDNSNameLoop(t *testing.T) {
+	// A name pointer that refers to itself.
+	msg := []byte{0xc0, 0x00}
+	if _, _, err := readDNSName(msg, 0); err == nil {
+		t.Fatal("expected an error for a pointer loop")
+	}
+}
+
+// discovererFunc adapts a function to the Discoverer interface.
+type discovererFunc func(context.Context) ([]Target, error)
+
+func (f discovererFunc) Discover(ctx context.Context) ([]Target, error) {
+	return f(ctx)
+}
@@ -27,6 +27,71 @@
 //	}
 //	defer client.Close()
 //
+// Discover finds servers to connect to, browsing the local network with
+// MDNSDiscoverer (Bonjour) by default and unicast _rfb._tcp SRV records with
+// SRVDiscoverer; other directories plug in through the Discoverer interface.
+//
+// ConnectTimeout limits the handshake only. ReadTimeout applies to handshake
+// reads and to the body of each server message, never to the wait for the
+// next message, so long-idle monitoring sessions stay open; use WithKeepalive
+// to detect peers that have gone away. Conversely, WithIdleDisconnect closes
+// connections the application has stopped using, and a Session dials again,
+// with the settings the last connection used, the next time its Client is
+// called.
+//
+// Presets such as ForQEMU, ForTigerVNC, ForMacScreenSharing, ForBMCKVM, and
+// ForVino bundle the encodings, pixel format, security preference, and quirks
+// known to work with a family of servers. They only rank the authentication
+// methods configured with WithAuth, and options given after a preset override
+// it:
+//
+//	client, err := vnc.ClientWithOptions(ctx, conn,
+//		vnc.ForQEMU(),
+//		vnc.WithAuth(vnc.NewPasswordAuth("secret")),
+//	)
+//
+// The client speaks RFB 3.8 and refuses older servers. WithProtocolVersion
+// negotiates RFB 3.3 or 3.7 instead, to test those handshakes against servers
+// that support several versions or to work around servers that misbehave on
+// 3.8; ProtocolVersion reports the version in use.
+//
+// WithQuirks enables workarounds for individual deviations. Servers that
+// pipeline data before the session is set up, such as a SecurityResult the
+// protocol version does not define or updates in their own pixel format right
+// after ServerInit, need QuirkEarlyServerData, which buffers those messages and
+// decodes them before the client's settings take effect.
+//
+// WithLowPowerProfile suits kiosk and signage viewers on Raspberry Pi-class
+// hardware: it negotiates 8 or 16 bits per pixel, offers only cheap encodings,
+// and converts pixels without division at slightly reduced color fidelity.
+//
+// Connection state negotiated with the server is read through accessors that
+// are safe for concurrent use: GetFrameBufferSize, GetDesktopName,
+// GetPixelFormat, GetColorMap, and GetEncodings. They read an immutable
+// snapshot without locking, so decoders can consult them for every rectangle;
+// Stats reports contention on the locks that remain. The matching exported
+// fields are deprecated mirrors kept for compatibility; changes made to them
+// are ignored and logged as a warning.
+//
+// Servers that support ExtendedDesktopSizePseudoEncoding, such as TigerVNC and
+// QEMU, report resizes together with the layout of each monitor, which Screens
+// returns. DesktopNamePseudoEncoding lets them announce that the desktop was
+// renamed; GetDesktopName then returns the new name, and the rectangle in the
+// delivered FramebufferUpdateMessage notifies the application.
+//
+// When the server sends the cursor shape, which CursorPseudoEncoding,
+// XCursorPseudoEncoding, and AlphaCursorPseudoEncoding request, Cursor returns
+// it as an RGBA image with its hotspot for local rendering. TigerVNC sends the
+// alpha variant, whose shadows and anti-aliased edges the classic bitmask
+// cannot represent. The VNC consoles of ESXi and VMware Workstation send
+// their own cursor shapes when VMwareCursorPseudoEncoding is requested, along
+// with the cursor state and position the guest sets.
+//
+// QEMU and TigerVNC report the Caps Lock, Num Lock, and Scroll Lock LEDs of
+// the remote keyboard when LEDStatePseudoEncoding is requested. LEDState
+// returns them, and SetLockKeys taps the lock keys that differ from the
+// wanted state before automation types text.
+//
 // # Message Handling
 //
 //	msgCh := make(chan vnc.ServerMessage, 100)
@@ -43,6 +108,118 @@
 //		}
 //	}()
 //
+// The connection stops reading the server while the channel is full.
+// Stats.Delivery reports the queue depth of the channel and how often and how
+// long deliveries blocked, as data for choosing its buffer size.
+//
+// Messages arrive in the order the server sent them, each once the connection
+// has applied it, and WithMaxFrameRate only merges consecutive updates. The
+// rectangles of an update keep their order, so a DesktopSize or
+// ExtendedDesktopSize rectangle precedes every rectangle in the new geometry,
+// and the connection rejects pixel rectangles outside the geometry in effect
+// where they appear. Colors are resolved with the color map in effect when
+// their rectangle was decoded, so a SetColorMapEntriesMessage affects only
+// the updates after it. Connection state such as GetFrameBufferSize may
+// already reflect messages still queued in the channel; renderers size their
+// canvas from FramebufferUpdateMessage.Width and Height instead.
+//
+// TightEncoding decodes the Tight encoding of TightVNC, TigerVNC, and QEMU,
+// including JPEG rectangles when a JPEGQualityPseudoEncoding is requested; it
+// is the most bandwidth-efficient choice over WAN links.
+// ZRLEEncoding decodes ZRLE, the compressed encoding supported by nearly every
+// current server, including RealVNC. TRLEEncoding decodes TRLE, its
+// uncompressed variant, which many embedded servers offer as a fallback.
+// ZlibEncoding decodes the Zlib encoding preferred by x11vnc and UltraVNC.
+// TightPNGEncoding decodes the PNG variant of Tight offered to noVNC clients.
+// Updates terminated by a LastRect rectangle are always accepted; offer
+// LastRectPseudoEncoding to let servers stream rectangles as they encode them.
+// H264Encoding decodes the H.264 video of TigerVNC and KasmVNC with a
+// VideoDecoder the application supplies, as the package includes no codec.
+// EncodingStats reports, for each encoding the server has used, the
+// rectangles and bytes received and the time spent decoding them. A
+// RawEncoding offered with Packed set keeps Raw pixels as sent rather than
+// as Colors, for screenshots and recordings of large desktops.
+//
+// Encodings the package does not implement, such as vendor extensions, are
+// registered in an EncodingRegistry and passed with WithEncodingRegistry;
+// SetEncodings advertises them and updates decode their rectangles without a
+// fork of the package. Pseudo-encodings can instead be registered with
+// RegisterPseudoEncoding, whose handler reads the data of each rectangle.
+//
+// RawEncoding, CopyRectEncoding, RREEncoding, HextileEncoding, and
+// ZRLEEncoding also implement Encoder, whose Encode produces the data of a
+// rectangle from its pixels, for servers, proxies, and recorders that
+// generate framebuffer updates. A ZRLEEncoding keeps the zlib stream of the
+// rectangles it encodes, so use one per viewer.
+//
+// Servers that support FencePseudoEncoding, such as TigerVNC, confirm it with
+// a fence request that the client answers automatically; FenceSupported then
+// reports true. Fence sends a fence of the client's own, which the server
+// returns as a FenceMessage once it has processed the preceding messages, for
+// flow control or round-trip measurement.
+//
+// Servers that support ContinuousUpdatesPseudoEncoding, such as TigerVNC,
+// confirm it with an EndOfContinuousUpdatesMessage. EnableContinuousUpdates
+// then asks the server to send the changes to an area as they happen, so
+// high-frame-rate streaming needs no FramebufferUpdateRequest per frame.
+// Viewers that render at a fixed rate cap the frames they request and receive
+// with WithMaxFrameRate instead of decoding every update the server can send.
+//
+// WithCompressionLevel and SetCompressionLevel ask servers to trade CPU time for
+// bandwidth in their zlib-based encodings. StartAdaptiveEncodings makes the
+// choice as the session runs, switching between a high-quality and a
+// low-bandwidth profile by measured throughput and round-trip time.
+//
+// RFB does not confirm whether the server honored an exclusive request.
+// WithSharingEvents reports what the client can observe instead: the start of
+// the session, resizes by another client that show the desktop is shared, and
+// the server closing the connection, as it does to clients displaced by an
+// exclusive one.
+//
+// WithInitialEncodings and WithAutoFullUpdate make the connection send
+// SetEncodings and request the whole framebuffer right after the handshake, so
+// the first FramebufferUpdateMessage arrives without further calls.
+//
+// Applications with their own event loop can disable the background goroutine
+// with WithManualPump and read one message at a time with ProcessNextMessage.
+//
+// # Screenshots
+//
+// Screenshot and CaptureRegion request an update and return a copy of the
+// result as an *image.RGBA. They are safe for concurrent use; overlapping
+// callers share a single update request:
+//
+//	img, err := client.Screenshot(ctx)
+//
+// CaptureFrame and CurrentFrame return an immutable Frame instead. Frames share
+// tiled pixel storage with the client framebuffer, so handing one to another
+// goroutine does not copy the desktop, and later updates never modify it.
+//
+// WithFrameHistory keeps the frames of the most recent updates, which
+// RecentFrames returns so that failure handlers can show what the screen
+// looked like just before an error.
+//
+// Viewers that draw the rectangles of each update themselves convert them
+// with ConvertRectToRGBA, ColorToRGBA, AppendRGBA, and DecodePixels, which use
+// the current pixel format and color map of the connection.
+//
+// EncodeImage and Frame.Encode export frames as PNG or JPEG. WebP and AVIF are
+// much smaller for desktop images, but the standard library cannot encode
+// them, so an encoder must be plugged in with RegisterImageEncoder.
+//
+// StartRecording records the session in the FBS format of vncrec and rfbproxy.
+// A RecordingSink stores the recording: FileSink writes one file,
+// RollingFileSink rotates segment files limited with WithSegmentMaxSize or
+// WithSegmentMaxDuration, and RecordingSinkFunc hands segments to any writer,
+// such as an upload to object storage. Annotate marks moments of the session,
+// such as the end of a login, in Stats and in the recording, where the file
+// sinks store them as WebVTT subtitles next to each segment.
+// WithRecordingEncryption seals segments with AES-GCM under keys from a
+// RecordingKeyProvider, such as a key management service, and
+// DecryptRecording reads them back. WithObservers lets Recording.Observe
+// attach watch-only observers to the live session, which
+// proxy.Proxy.ServeObserver serves to a viewer such as a supervisor.
+//
 // # Input Events
 //
 //	// Send keyboard input
@@ -53,13 +230,132 @@
 //	client.PointerEvent(vnc.ButtonLeft, 100, 100) // Click
 //	client.PointerEvent(0, 100, 100)              // Release
 //
+//	// Scroll two notches down and one to the left
+//	client.Scroll(100, 100, -1, 2)
+//
+// Click, DoubleClick, and Drag pace their events from the round-trip time
+// measured by Ping and by screen captures, so double-clicks register on both
+// fast and slow links (see GestureTiming).
+//
+// SendKeys types keyboard shortcuts written as chords, such as
+// "ctrl+shift+esc" or "cmd+space", releasing modifiers in reverse order.
+//
+// Paste delivers text of any length to the focused remote application, either
+// through the clipboard in chunks of at most MaxClipboardLength bytes or, with
+// WithPasteTyping, as key events for servers that ignore client cut text.
+// TypeClipboardFallback types at a fixed rate and holds Shift where needed, as
+// BMC KVM consoles require.
+//
+// An ElementMap names screen elements by position (Region) or by picture
+// (Template), so scripts can call ClickElement(ctx, "login_button") instead of
+// hard-coding coordinates.
+//
+// Viewers that scale or rotate the desktop can use Viewport (or FitViewport)
+// to convert widget positions to framebuffer coordinates and back.
+// WithRotation instead rotates the desktop for the whole API, so frames and
+// pointer coordinates of portrait kiosk screens agree with each other.
+//
+// The back and forward side buttons (ButtonBack, ButtonForward) need the
+// ExtendedMouseButtonsPseudoEncoding in SetEncodings; ExtendedMouseButtons
+// reports whether the server has confirmed it.
+//
+// QEMU and KVM consoles interpret keysyms through a fixed keyboard layout, so
+// keys typed into guests with other layouts arrive wrong. Once the server has
+// confirmed QEMUExtendedKeyEventPseudoEncoding, ExtendedKeyEvent sends the XT
+// scancode of a key along with its keysym, and the guest applies its own
+// layout.
+//
+// # Session Handoff
+//
+// Detach stops a session at a message boundary and returns its socket and
+// SessionState; Resume continues it without a new handshake. On Unix, Handoff
+// and ReceiveSession pass both to another process over a Unix domain socket,
+// so a supervisor can restart long-running recorders without dropping their
+// sessions.
+//
 // # Error Handling
 //
 //	if vnc.IsVNCError(err, vnc.ErrAuthentication) {
 //		log.Printf("Authentication failed: %v", err)
 //	}
 //
+// Errors returned by a client connection carry the remote address, connection
+// ID, protocol phase, and, where relevant, the server message type:
+//
+//	var vncErr *vnc.VNCError
+//	if errors.As(err, &vncErr) {
+//		log.Printf("%s failed in phase %s: %v", vncErr.RemoteAddr, vncErr.Phase, err)
+//	}
+//
+// When a message ends early, as when the server closes the connection
+// mid-update, the error wraps a ShortReadError naming the field that was cut
+// short and how many of its bytes arrived.
+//
+// For end users, VNCError.UserMessage returns a short message selected by a
+// stable MessageKey; WithMessageCatalog localizes it without parsing the
+// English text of Error.
+//
+// Some servers declare the wrong byte order in their pixel format. The client
+// logs a warning when the padding bits of decoded pixels and cursor images
+// suggest this, and WithForcePixelEndianness overrides the declared order.
+//
+// WithCopyRectVerification checks every CopyRect against checksums of the
+// client framebuffer and reports inconsistencies, such as those behind smeared
+// window drags, in the log and in Stats.CopyRectMismatches.
+//
+// DebugBundle returns a sanitized JSON transcript of the negotiation, the first
+// server messages, statistics, and logged warnings for attaching to bug reports.
+//
+// RunConformance checks a server against RFC 6143, covering version and
+// security negotiation and the correctness of each encoding it offers, and
+// returns a ConformanceReport; examples/conformance wraps it as a command.
+//
+// # Protocol Layer
+//
+// The rfb subpackage exposes the wire-level primitives ClientConn is built on:
+// protocol version and security negotiation, ClientInit and ServerInit, and
+// encoders and decoders for every RFC 6143 message. Use it to build custom
+// clients, servers, or proxies that need control over the protocol flow.
+//
+// The proxy subpackage builds on it to relay viewers to an upstream server,
+// enforcing per-viewer quotas on message sizes, bytes, and input events so a
+// misbehaving viewer cannot degrade the shared desktop.
+//
+// The server subpackage performs the server side of the handshake. Its
+// Authenticators verify viewers, and an optional Authorizer decides on each
+// connection from the authenticated identity, source address, and requested
+// access, granting interactive or view-only access or denying it, which lets
+// the server and proxy packages integrate with role-based access control.
+// Servers send Bell, ServerCutText, and SetColorMapEntries with the same
+// message types the client parses, whose Write methods mirror Read. Its
+// Display serves a framebuffer drawn by the application, scheduling updates
+// from coalesced damage as each viewer requests them. Whole frames, a
+// damage-tracking draw.Image canvas, and screen capturers feeding a real
+// desktop can all drive a Display, and an InputSink delivers the input of
+// interactive viewers to callbacks or, on Linux and Windows, to the local
+// desktop.
+//
+// The vnctest subpackage runs a server in the test process and connects
+// clients to it over net.Pipe, in the manner of net/http/httptest, for fast
+// and deterministic end-to-end tests of handshakes, authentication,
+// encodings, clipboard, and desktop resizes.
+//
+// # Build Tags
+//
+// Building with the vnc_minimal tag excludes heavyweight optional subsystems
+// (compressed and video encodings, image export, and similar features) for
+// embedded consumers that only need the handshake, input events, and Raw
+// decoding:
+//
+//	go build -tags vnc_minimal ./...
+//
+// The MinimalBuild constant reports which profile was compiled. Optional
+// subsystems live in files constrained by "//go:build !vnc_minimal" and must
+// not be referenced from files that are part of the minimal profile.
+//
 // This library maintains API compatibility with github.com/mitchellh/go-vnc
-// and can be used as a drop-in replacement.
+// and can be used as a drop-in replacement. The tests compare the exported API
+// with the manifest in testdata/api.txt, so no release removes or changes a
+// symbol by accident.
 
 package vnc
@@ -0,0 +1,224 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package vnc
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"slices"
+	"sync"
+)
+
+// eaxTagSize is the size of the authentication tag of each EAX message.
+const eaxTagSize = aes.BlockSize
+
+// eaxMaxMessageSize is the largest plaintext of a single message on an RSA-AES
+// encrypted connection.
+const eaxMaxMessageSize = 8192
+
+// errEAXAuthentication reports a message whose tag does not match.
+var errEAXAuthentication = errors.New("message authentication failed")
+
+// eax implements the EAX authenticated encryption mode over AES, which the
+// RSA-AES security types use and crypto/cipher does not provide.
+type eax struct {
+	block  cipher.Block
+	k1, k2 [aes.BlockSize]byte
+}
+
+// newEAX returns EAX over AES with key, which is 16 or 32 bytes long.
+func newEAX(key []byte) (*eax, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	e := &eax{block: block}
+	var l [aes.BlockSize]byte
+	block.Encrypt(l[:], l[:])
+	e.k1 = cmacDouble(l)
+	e.k2 = cmacDouble(e.k1)
+	return e, nil
+}
+
+// cmacDouble multiplies b by x in GF(2^128), deriving the CMAC subkeys.
+func cmacDouble(b [aes.BlockSize]byte) [aes.BlockSize]byte {
+	var d [aes.BlockSize]byte
+	carry := b[0] >> 7
+	for i := 0; i < aes.BlockSize-1; i++ {
+		d[i] = b[i]<<1 | b[i+1]>>7
+	}
+	d[aes.BlockSize-1] = b[aes.BlockSize-1]<<1 ^ carry*0x87
+	return d
+}
+
+// omac returns the CMAC of data prefixed with the block holding tweak.
+func (e *eax) omac(tweak byte, data []byte) [aes.BlockSize]byte {
+	var x [aes.BlockSize]byte
+	x[aes.BlockSize-1] = tweak
+	if len(data) == 0 {
+		// The tweak block is then the complete last block, masked with the
+		// first subkey.
+		subtle.XORBytes(x[:], x[:], e.k1[:])
+		e.block.Encrypt(x[:], x[:])
+		return x
+	}
+	e.block.Encrypt(x[:], x[:])
+
+	for len(data) > aes.BlockSize {
+		subtle.XORBytes(x[:], x[:], data[:aes.BlockSize])
+		e.block.Encrypt(x[:], x[:])
+		data = data[aes.BlockSize:]
+	}
+
+	var last [aes.BlockSize]byte
+	copy(last[:], data)
+	mask := e.k1
+	if len(data) < aes.BlockSize {
+		last[len(data)] = 0x80
+		mask = e.k2
+	}
+	subtle.XORBytes(x[:], x[:], last[:])
+	subtle.XORBytes(x[:], x[:], mask[:])
+	e.block.Encrypt(x[:], x[:])
+	return x
+}
+
+// seal encrypts plaintext and appends the ciphertext and tag to dst.
+func (e *eax) seal(dst, nonce, plaintext, header []byte) []byte {
+	n := e.omac(0, nonce)
+	h := e.omac(1, header)
+
+	start := len(dst)
+	dst = append(dst, make([]byte, len(plaintext)+eaxTagSize)...)
+	ciphertext := dst[start : start+len(plaintext)]
+	cipher.NewCTR(e.block, n[:]).XORKeyStream(ciphertext, plaintext)
+
+	c := e.omac(2, ciphertext)
+	tag := dst[start+len(plaintext):]
+	for i := range tag {
+		tag[i] = n[i] ^ h[i] ^ c[i]
+	}
+	return dst
+}
+
+// open authenticates and decrypts ciphertext, which ends with the tag, and
+// appends the plaintext to dst.
+func (e *eax) open(dst, nonce, ciphertext, header []byte) ([]byte, error) {
+	if len(ciphertext) < eaxTagSize {
+		return nil, errEAXAuthentication
+	}
+	tag := ciphertext[len(ciphertext)-eaxTagSize:]
+	ciphertext = ciphertext[:len(ciphertext)-eaxTagSize]
+
+	n := e.omac(0, nonce)
+	h := e.omac(1, header)
+	c := e.omac(2, ciphertext)
+	var expected [eaxTagSize]byte
+	for i := range expected {
+		expected[i] = n[i] ^ h[i] ^ c[i]
+	}
+	if subtle.ConstantTimeCompare(expected[:], tag) != 1 {
+		return nil, errEAXAuthentication
+	}
+
+	// dst may share memory with ciphertext, as when decrypting in place, so
+	// it is extended without overwriting.
+	start := len(dst)
+	dst = slices.Grow(dst, len(ciphertext))[:start+len(ciphertext)]
+	cipher.NewCTR(e.block, n[:]).XORKeyStream(dst[start:], ciphertext)
+	return dst, nil
+}
+
+// eaxConn is a connection encrypted by the RSA-AES security types. Each
+// message carries its plaintext length as a 16-bit big-endian header, which
+// is authenticated, followed by the EAX ciphertext and tag. The nonce is a
+// little-endian message counter kept for each direction.
+type eaxConn struct {
+	net.Conn
+
+	readMu    sync.Mutex
+	reader    *eax
+	readNonce [aes.BlockSize]byte
+	pending   []byte
+
+	writeMu    sync.Mutex
+	writer     *eax
+	writeNonce [aes.BlockSize]byte
+}
+
+// newEAXConn returns conn encrypted with readKey for the messages of the
+// server and writeKey for the messages of the client.
+func newEAXConn(conn net.Conn, readKey, writeKey []byte) (*eaxConn, error) {
+	reader, err := newEAX(readKey)
+	if err != nil {
+		return nil, err
+	}
+	writer, err := newEAX(writeKey)
+	if err != nil {
+		return nil, err
+	}
+	return &eaxConn{Conn: conn, reader: reader, writer: writer}, nil
+}
+
+// Read decrypts data from the next messages of the server.
+func (c *eaxConn) Read(p []byte) (int, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+
+	for len(c.pending) == 0 {
+		var header [2]byte
+		if _, err := io.ReadFull(c.Conn, header[:]); err != nil {
+			return 0, err
+		}
+		message := make([]byte, int(binary.BigEndian.Uint16(header[:]))+eaxTagSize)
+		if _, err := io.ReadFull(c.Conn, message); err != nil {
+			return 0, err
+		}
+		plaintext, err := c.reader.open(message[:0], c.readNonce[:], message, header[:])
+		if err != nil {
+			return 0, err
+		}
+		incrementNonce(&c.readNonce)
+		c.pending = plaintext
+	}
+
+	n := copy(p, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+// Write encrypts p into messages to the server.
+func (c *eaxConn) Write(p []byte) (int, error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	written := 0
+	for len(p) > 0 {
+		chunk := p[:min(len(p), eaxMaxMessageSize)]
+		message := binary.BigEndian.AppendUint16(make([]byte, 0, 2+len(chunk)+eaxTagSize), uint16(len(chunk))) // #nosec G115 - chunk is at most eaxMaxMessageSize
+		message = c.writer.seal(message, c.writeNonce[:], chunk, message[:2])
+		if _, err := c.Conn.Write(message); err != nil {
+			return written, err
+		}
+		incrementNonce(&c.writeNonce)
+		written += len(chunk)
+		p = p[len(chunk):]
+	}
+	return written, nil
+}
+
+// incrementNonce advances a little-endian message counter.
+func incrementNonce(nonce *[aes.BlockSize]byte) {
+	for i := range nonce {
+		nonce[i]++
+		if nonce[i] != 0 {
+			return
+		}
+	}
+}
@@ -0,0 +1,80 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package vnc
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io"
+	"net"
+	"testing"
+)
+
+func TestEAX_Vectors(t *testing.T) {
+	// Test vectors from the EAX specification by Bellare, Rogaway, and Wagner.
+	tests := []struct {
+		key, nonce, header, msg, want string
+	}{
+		{"233952DEE4D5ED5F9B9C6D6FF80FF478", "62EC67F9C3A4A407FCB2A8C49031A8B3", "6BFB914FD07EAE6B",
+			"", "E037830E8389F27B025A2D6527E79D01"},
+		{"91945D3F4DCBEE0BF45EF52255F095A4", "BECAF043B0A23D843194BA972C66DEBD", "FA3BFD4806EB53FA",
+			"F7FB", "19DD5C4C9331049D0BDAB0277408F67967E5"},
+		{"01F74AD64077F2E704C0F60ADA3DD523", "70C3DB4F0D26368400A10ED05D2BFF5E", "234A3463C1264AC6",
+			"1A47CB4933", "D851D5BAE03A59F238A23E39199DC9266626C40F80"},
+		{"8395FCF1E95BEBD697BD010BC766AAC3", "22E7ADD93CFC6393C57EC0B3C17D6B44", "126735FCC320D25A",
+			"CA40D7446E545FFAED3BD12A740A659FFBBB3CEAB7", "CB8920F87A6C75CFF39627B56E3ED197C552D295A7CFC46AFC253B4652B1AF3795B124AB6E"},
+	}
+	for _, tt := range tests {
+		key, _ := hex.DecodeString(tt.key)
+		nonce, _ := hex.DecodeString(tt.nonce)
+		header, _ := hex.DecodeString(tt.header)
+		msg, _ := hex.DecodeString(tt.msg)
+		want, _ := hex.DecodeString(tt.want)
+
+		e, err := newEAX(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := e.seal(nil, nonce, msg, header)
+		if !bytes.Equal(got, want) {
+			t.Errorf("seal(%s) = %X, want %X", tt.msg, got, want)
+		}
+		plaintext, err := e.open(nil, nonce, got, header)
+		if err != nil || !bytes.Equal(plaintext, msg) {
+			t.Errorf("open(%X) = %X, %v, want %s", got, plaintext, err, tt.msg)
+		}
+		got[0] ^= 1
+		if _, err := e.open(nil, nonce, got, header); err == nil {
+			t.Errorf("open() accepted a modified message")
+		}
+	}
+}
+
+func TestEAXConn_RoundTrip(t *testing.T) {
+	server, client := net.Pipe()
+	defer func() { _ = server.Close() }()
+	defer func() { _ = client.Close() }()
+
+	keyA := bytes.Repeat([]byte{0xa}, 16)
+	keyB := bytes.Repeat([]byte{0xb}, 16)
+	clientConn, err := newEAXConn(client, keyA, keyB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverConn, err := newEAXConn(server, keyB, keyA)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Larger than a single message, so the write is split.
+	payload := bytes.Repeat([]byte("framebuffer"), 2*eaxMaxMessageSize/11)
+	go func() { _, _ = clientConn.Write(payload) }()
+	got := make([]byte, len(payload))
+	if _, err := io.ReadFull(serverConn, got); err != nil {
+		t.Fatalf("ReadFull() error = %v", err)
+	}
+	if !bytes.Equal(got, payload) {
+		t.Error("payload changed in transit")
+	}
+}
@@ -0,0 +1,244 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package vnc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"slices"
+	"sync"
+)
+
+// ErrElementNotFound is returned, wrapped, when a registered element cannot be
+// found on the screen.
+var ErrElementNotFound = errors.New("element not found on screen")
+
+// ElementLocator finds a screen element on a connection. Locators that need
+// the screen contents capture them with CaptureFrame.
+type ElementLocator interface {
+	Locate(ctx context.Context, c *ClientConn) (image.Rectangle, error)
+}
+
+// Region locates an element at a fixed position. With a non-zero Reference,
+// Rect is given for a desktop of that size and scaled to the current
+// display size, so a map written at one resolution keeps working at
+// another when the layout scales with the desktop.
+type Region struct {
+	Rect      image.Rectangle
+	Reference image.Point
+}
+
+// Locate returns the region, scaled to the framebuffer if Reference is set.
+func (r Region) Locate(_ context.Context, c *ClientConn) (image.Rectangle, error) {
+	if r.Reference.X <= 0 || r.Reference.Y <= 0 {
+		return r.Rect, nil
+	}
+
+	width, height := c.DisplaySize()
+	scale := func(v, size, reference int) int {
+		return v * size / reference
+	}
+	return image.Rect(
+		scale(r.Rect.Min.X, int(width), r.Reference.X),
+		scale(r.Rect.Min.Y, int(height), r.Reference.Y),
+		scale(r.Rect.Max.X, int(width), r.Reference.X),
+		scale(r.Rect.Max.Y, int(height), r.Reference.Y),
+	), nil
+}
+
+// TemplateMatcher searches frame for template and returns the matching area,
+// or false if there is none. It is the hook for matching that tolerates
+// scaling or rendering differences, such as normalized cross-correlation.
+type TemplateMatcher func(ctx context.Context, frame *Frame, template image.Image) (image.Rectangle, bool, error)
+
+// Template locates an element by searching the screen for an image of it.
+type Template struct {
+	// Image is the picture of the element.
+	Image image.Image
+
+	// Matcher searches for Image. The default, ExactMatcher(0), finds only
+	// pixel-identical copies at the original size.
+	Matcher TemplateMatcher
+}
+
+// Locate captures the screen and searches it for the template.
+func (t Template) Locate(ctx context.Context, c *ClientConn) (image.Rectangle, error) {
+	width, height := c.DisplaySize()
+	frame, err := c.CaptureFrame(ctx, image.Rect(0, 0, int(width), int(height)))
+	if err != nil {
+		return image.Rectangle{}, err
+	}
+
+	matcher := t.Matcher
+	if matcher == nil {
+		matcher = ExactMatcher(0)
+	}
+	rect, ok, err := matcher(ctx, frame, t.Image)
+	if err != nil {
+		return image.Rectangle{}, err
+	}
+	if !ok {
+		return image.Rectangle{}, ErrElementNotFound
+	}
+	return rect, nil
+}
+
+// ExactMatcher returns a TemplateMatcher that finds the first position, in
+// row-major order, where every pixel of the template matches the frame within
+// tolerance on each color channel. Transparent template pixels match
+// anything.
+func ExactMatcher(tolerance uint8) TemplateMatcher {
+	return func(ctx context.Context, frame *Frame, template image.Image) (image.Rectangle, bool, error) {
+		screen := frame.RGBA()
+		tb := template.Bounds()
+		tpl := image.NewRGBA(image.Rect(0, 0, tb.Dx(), tb.Dy()))
+		draw.Draw(tpl, tpl.Bounds(), template, tb.Min, draw.Src)
+
+		sb := screen.Bounds()
+		for y := sb.Min.Y; y+tb.Dy() <= sb.Max.Y; y++ {
+			if err := ctx.Err(); err != nil {
+				return image.Rectangle{}, false, err
+			}
+			for x := sb.Min.X; x+tb.Dx() <= sb.Max.X; x++ {
+				if templateMatches(screen, tpl, x, y, tolerance) {
+					return image.Rect(x, y, x+tb.Dx(), y+tb.Dy()), true, nil
+				}
+			}
+		}
+		return image.Rectangle{}, false, nil
+	}
+}
+
+// templateMatches reports whether tpl matches screen with its origin at (x, y).
+func templateMatches(screen, tpl *image.RGBA, x, y int, tolerance uint8) bool {
+	within := func(a, b uint8) bool {
+		return max(a, b)-min(a, b) <= tolerance
+	}
+	for ty := 0; ty < tpl.Rect.Dy(); ty++ {
+		srow := screen.Pix[screen.PixOffset(x, y+ty):]
+		trow := tpl.Pix[tpl.PixOffset(0, ty):]
+		for i := 0; i < tpl.Rect.Dx()*4; i += 4 {
+			if trow[i+3] == 0 {
+				continue
+			}
+			if !within(srow[i], trow[i]) || !within(srow[i+1], trow[i+1]) || !within(srow[i+2], trow[i+2]) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// ElementMap is a registry of named screen elements, letting scripts interact
+// with "login_button" rather than raw coordinates. It is safe for concurrent
+// use and can be shared by several connections.
+//
+// Example usage:
+//
+//	elements := vnc.NewElementMap()
+//	elements.Register("username_field", vnc.Region{Rect: image.Rect(400, 300, 700, 330)})
+//	elements.Register("login_button", vnc.Template{Image: buttonPNG})
+//
+//	client, err := vnc.ClientWithOptions(ctx, conn, vnc.WithAuth(auth), vnc.WithElementMap(elements))
+//	...
+//	err = client.ClickElement(ctx, "login_button")
+type ElementMap struct {
+	mu       sync.RWMutex
+	elements map[string]ElementLocator
+}
+
+// NewElementMap returns an empty ElementMap.
+func NewElementMap() *ElementMap {
+	return &ElementMap{elements: make(map[string]ElementLocator)}
+}
+
+// Register names an element, replacing any element of the same name. A nil
+// locator removes it.
+func (m *ElementMap) Register(name string, locator ElementLocator) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if locator == nil {
+		delete(m.elements, name)
+		return
+	}
+	if m.elements == nil {
+		m.elements = make(map[string]ElementLocator)
+	}
+	m.elements[name] = locator
+}
+
+// Lookup returns the locator registered under name.
+func (m *ElementMap) Lookup(name string) (ElementLocator, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	locator, ok := m.elements[name]
+	return locator, ok
+}
+
+// Names returns the registered element names in sorted order.
+func (m *ElementMap) Names() []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	names := make([]string, 0, len(m.elements))
+	for name := range m.elements {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// WithElementMap sets the element registry used by LocateElement and
+// ClickElement.
+func WithElementMap(elements *ElementMap) ClientOption {
+	return func(cfg *ClientConfig) {
+		cfg.Elements = elements
+	}
+}
+
+// LocateElement returns the area of the named element on the screen, clipped
+// to the framebuffer. It returns an error wrapping ErrElementNotFound if the
+// element is not visible.
+func (c *ClientConn) LocateElement(ctx context.Context, name string) (image.Rectangle, error) {
+	var locator ElementLocator
+	ok := false
+	if c.config != nil && c.config.Elements != nil {
+		locator, ok = c.config.Elements.Lookup(name)
+	}
+	if !ok {
+		return image.Rectangle{}, c.enrichError(validationError("LocateElement",
+			fmt.Sprintf("no element named %q is registered", name), nil))
+	}
+
+	rect, err := locator.Locate(ctx, c)
+	if err != nil {
+		return image.Rectangle{}, c.enrichError(validationError("LocateElement",
+			fmt.Sprintf("failed to locate element %q", name), err))
+	}
+
+	width, height := c.DisplaySize()
+	rect = rect.Intersect(image.Rect(0, 0, int(width), int(height)))
+	if rect.Empty() {
+		return image.Rectangle{}, c.enrichError(validationError("LocateElement",
+			fmt.Sprintf("element %q is outside the framebuffer", name), ErrElementNotFound))
+	}
+	return rect, nil
+}
+
+// ClickElement clicks the left button at the center of the named element.
+//
+// Example usage:
+//
+//	err := client.ClickElement(ctx, "login_button")
+func (c *ClientConn) ClickElement(ctx context.Context, name string) error {
+	rect, err := c.LocateElement(ctx, name)
+	if err != nil {
+		return err
+	}
+	center := rect.Min.Add(rect.Size().Div(2))
+	// #nosec G115 - the element is clipped to the framebuffer, whose dimensions are uint16
+	return c.Click(ctx, ButtonLeft, uint16(center.X), uint16(center.Y))
+}
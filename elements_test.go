@@ -0,0 +1,92 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package vnc
+
+import (
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestElementMap_Registry(t *testing.T) {
+	m := NewElementMap()
+	m.Register("login_button", Region{Rect: image.Rect(1, 1, 2, 2)})
+	m.Register("username_field", Region{Rect: image.Rect(0, 0, 1, 1)})
+
+	if got, want := m.Names(), []string{"login_button", "username_field"}; !slices.Equal(got, want) {
+		t.Errorf("Names() = %v, want %v", got, want)
+	}
+
+	m.Register("login_button", nil)
+	if _, ok := m.Lookup("login_button"); ok {
+		t.Error("Register(name, nil) did not remove the element")
+	}
+
+	var zero ElementMap
+	zero.Register("field", Region{})
+	if _, ok := zero.Lookup("field"); !ok {
+		t.Error("zero ElementMap did not register the element")
+	}
+}
+
+func TestElementMap_RegionScaling(t *testing.T) {
+	c := &ClientConn{}
+	c.setFrameBufferSize(2048, 1536)
+
+	region := Region{Rect: image.Rect(100, 50, 300, 150), Reference: image.Pt(1024, 768)}
+	got, err := region.Locate(context.Background(), c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := image.Rect(200, 100, 600, 300); got != want {
+		t.Errorf("Locate() = %v, want %v", got, want)
+	}
+}
+
+func TestElementMap_ClickElement(t *testing.T) {
+	elements := NewElementMap()
+	srv, conn := newUpdateServer(t, 8, 6, WithElementMap(elements))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	elements.Register("button", Region{Rect: image.Rect(2, 2, 6, 4)})
+	if err := conn.ClickElement(ctx, "button"); err != nil {
+		t.Fatalf("ClickElement failed: %v", err)
+	}
+	press := <-srv.pointers
+	if press.X != 4 || press.Y != 3 || press.Mask != uint8(ButtonLeft) {
+		t.Errorf("press = %+v, want left button at 4,3", press)
+	}
+	<-srv.pointers
+
+	// The template is cut from the desktop; the blue channel counts updates,
+	// so a small tolerance lets it match the next capture.
+	img, err := conn.Screenshot(ctx)
+	if err != nil {
+		t.Fatalf("Screenshot failed: %v", err)
+	}
+	elements.Register("icon", Template{Image: img.SubImage(image.Rect(5, 1, 7, 3)), Matcher: ExactMatcher(2)})
+	if got, err := conn.LocateElement(ctx, "icon"); err != nil || got != image.Rect(5, 1, 7, 3) {
+		t.Errorf("LocateElement() = %v, %v, want (5,1)-(7,3)", got, err)
+	}
+
+	missing := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	for i := range missing.Pix {
+		missing.Pix[i] = 0xff
+	}
+	missing.SetRGBA(0, 0, color.RGBA{R: 0xff, A: 0xff})
+	elements.Register("missing", Template{Image: missing})
+	if _, err := conn.LocateElement(ctx, "missing"); !errors.Is(err, ErrElementNotFound) {
+		t.Errorf("LocateElement(missing) error = %v, want ErrElementNotFound", err)
+	}
+
+	if err := conn.ClickElement(ctx, "unknown"); !IsVNCError(err, ErrValidation) {
+		t.Errorf("ClickElement(unknown) error = %v, want a validation error", err)
+	}
+}
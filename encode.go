@@ -0,0 +1,159 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package vnc
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Encoder is implemented by encodings that can also produce rectangles, for
+// servers, proxies, and recorders that generate framebuffer updates rather
+// than consume them. RawEncoding, CopyRectEncoding, RREEncoding,
+// HextileEncoding, and ZRLEEncoding implement it.
+type Encoder interface {
+	Encoding
+
+	// Encode returns the encoded data of a rectangle of rect's size, without
+	// the rectangle header, in the pixel format pf the viewer has set. pixels
+	// holds the rectangle row by row, with components in the ranges of pf as
+	// decoded rectangles hold them; pf must be a true color format.
+	Encode(pf *PixelFormat, rect *Rectangle, pixels []Color) ([]byte, error)
+}
+
+// pixelWriter serializes colors as pixels of a true color pixel format.
+type pixelWriter struct {
+	pf    PixelFormat
+	order binary.AppendByteOrder
+	size  int
+}
+
+// newPixelWriter checks that colors can be written in pf and that pixels
+// covers rect.
+func newPixelWriter(op string, pf *PixelFormat, rect *Rectangle, pixels []Color) (pixelWriter, error) {
+	if pf == nil || rect == nil {
+		return pixelWriter{}, validationError(op, "pixel format and rectangle are required", nil)
+	}
+	if !pf.TrueColor {
+		return pixelWriter{}, unsupportedError(op, "encoding requires a true color pixel format", nil)
+	}
+	switch pf.BPP {
+	case 8, 16, 32:
+	default:
+		return pixelWriter{}, unsupportedError(op, fmt.Sprintf("unsupported bits per pixel: %d", pf.BPP), nil)
+	}
+	if want := int(rect.Width) * int(rect.Height); len(pixels) != want {
+		return pixelWriter{}, validationError(op,
+			fmt.Sprintf("got %d pixels for a %dx%d rectangle", len(pixels), rect.Width, rect.Height), nil)
+	}
+
+	w := pixelWriter{pf: *pf, order: binary.LittleEndian, size: int(pf.BPP / 8)}
+	if pf.BigEndian {
+		w.order = binary.BigEndian
+	}
+	return w, nil
+}
+
+// pixel returns the pixel value of a color.
+func (w pixelWriter) pixel(c Color) uint32 {
+	pf := &w.pf
+	return uint32(c.R&pf.RedMax)<<pf.RedShift |
+		uint32(c.G&pf.GreenMax)<<pf.GreenShift |
+		uint32(c.B&pf.BlueMax)<<pf.BlueShift
+}
+
+// append appends a color as one pixel.
+func (w pixelWriter) append(buf []byte, c Color) []byte {
+	pixel := w.pixel(c)
+	switch w.size {
+	case 1:
+		return append(buf, uint8(pixel)) // #nosec G115 - 8 bpp formats use the low 8 bits
+	case 2:
+		return w.order.AppendUint16(buf, uint16(pixel)) // #nosec G115 - 16 bpp formats use the low 16 bits
+	default:
+		return w.order.AppendUint32(buf, pixel)
+	}
+}
+
+// pixelArea is a rectangular area of a row-major pixel buffer.
+type pixelArea struct {
+	pixels        []Color
+	stride        int
+	x, y          int
+	width, height int
+}
+
+// at returns the pixel at x, y relative to the area.
+func (a pixelArea) at(x, y int) Color {
+	return a.pixels[(a.y+y)*a.stride+a.x+x]
+}
+
+// background returns the most common color of the area.
+func (a pixelArea) background() Color {
+	counts := make(map[Color]int)
+	var bg Color
+	for y := 0; y < a.height; y++ {
+		for x := 0; x < a.width; x++ {
+			c := a.at(x, y)
+			counts[c]++
+			if counts[c] > counts[bg] {
+				bg = c
+			}
+		}
+	}
+	return bg
+}
+
+// encodeSubrect is a solid rectangle relative to the area it was found in.
+type encodeSubrect struct {
+	color         Color
+	x, y          int
+	width, height int
+}
+
+// subrects covers the pixels that differ from bg with solid rectangles, each
+// grown right along its row and then down while the rows below repeat it. It
+// reports false once more than limit rectangles are needed; a negative limit
+// means no limit.
+func (a pixelArea) subrects(bg Color, limit int) ([]encodeSubrect, bool) {
+	var subs []encodeSubrect
+	covered := make([]bool, a.width*a.height)
+	for y := 0; y < a.height; y++ {
+		for x := 0; x < a.width; x++ {
+			c := a.at(x, y)
+			if c == bg || covered[y*a.width+x] {
+				continue
+			}
+			if limit >= 0 && len(subs) == limit {
+				return nil, false
+			}
+
+			w := 1
+			for x+w < a.width && a.at(x+w, y) == c && !covered[y*a.width+x+w] {
+				w++
+			}
+			h := 1
+			for y+h < a.height && a.rowMatches(x, y+h, w, c, covered) {
+				h++
+			}
+			for sy := y; sy < y+h; sy++ {
+				for sx := x; sx < x+w; sx++ {
+					covered[sy*a.width+sx] = true
+				}
+			}
+			subs = append(subs, encodeSubrect{color: c, x: x, y: y, width: w, height: h})
+		}
+	}
+	return subs, true
+}
+
+// rowMatches reports whether w uncovered pixels of row y starting at x are c.
+func (a pixelArea) rowMatches(x, y, w int, c Color, covered []bool) bool {
+	for sx := x; sx < x+w; sx++ {
+		if a.at(sx, y) != c || covered[y*a.width+sx] {
+			return false
+		}
+	}
+	return true
+}
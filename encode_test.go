@@ -0,0 +1,210 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package vnc
+
+import (
+	"bytes"
+	"slices"
+	"testing"
+)
+
+// encodeTestFormats are the pixel formats the encoders are checked in.
+var encodeTestFormats = map[string]PixelFormat{
+	"32-bit":            *PixelFormat32BitRGBA,
+	"32-bit big-endian": {BPP: 32, Depth: 24, BigEndian: true, TrueColor: true, RedMax: 255, GreenMax: 255, BlueMax: 255, RedShift: 16, GreenShift: 8, BlueShift: 0},
+	"32-bit high bytes": {BPP: 32, Depth: 24, TrueColor: true, RedMax: 255, GreenMax: 255, BlueMax: 255, RedShift: 24, GreenShift: 16, BlueShift: 8},
+	"16-bit":            *PixelFormat16BitRGB565,
+	"8-bit":             *PixelFormat8BitBGR233,
+}
+
+// encodeTestImage returns a w x h image in pf with a solid background, a
+// block, scattered pixels, and a gradient, so encoders take each path.
+func encodeTestImage(pf PixelFormat, w, h int) []Color {
+	pixels := make([]Color, w*h)
+	for y := range h {
+		for x := range w {
+			c := Color{R: 10, G: 20, B: 30}
+			switch {
+			case x >= w/2 && y >= h/2:
+				c = Color{R: uint16(x * 7), G: uint16(y * 5), B: uint16(x + y)}
+			case x >= 3 && x < 9 && y >= 2 && y < 12:
+				c = Color{R: 200}
+			case (x*31+y*17)%23 == 0:
+				c = Color{B: uint16(x % 4 * 60)}
+			}
+			pixels[y*w+x] = Color{R: c.R & pf.RedMax, G: c.G & pf.GreenMax, B: c.B & pf.BlueMax}
+		}
+	}
+	return pixels
+}
+
+// newEncodeConn returns a connection that decodes w x h rectangles in pf.
+func newEncodeConn(pf PixelFormat, w, h uint16) *ClientConn {
+	c := &ClientConn{logger: &NoOpLogger{}}
+	c.setPixelFormat(pf)
+	c.setFrameBufferSize(w, h)
+	return c
+}
+
+// encodeRoundTrip encodes pixels with enc and decodes them with a reader of
+// the same type, returning the decoded pixels.
+func encodeRoundTrip(t *testing.T, c *ClientConn, enc Encoder, pf PixelFormat, rect *Rectangle, pixels []Color) []Color {
+	t.Helper()
+	data, err := enc.Encode(&pf, rect, pixels)
+	if err != nil {
+		t.Fatalf("Encode() error = %v", err)
+	}
+
+	r := bytes.NewReader(data)
+	decoded, err := enc.Read(c, rect, r)
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if r.Len() != 0 {
+		t.Errorf("Read() left %d of %d bytes", r.Len(), len(data))
+	}
+
+	return decodedPixels(t, decoded, int(rect.Width), int(rect.Height))
+}
+
+// decodedPixels returns the pixels of a decoded rectangle in row-major order.
+func decodedPixels(t *testing.T, enc Encoding, w, h int) []Color {
+	t.Helper()
+	pixels := make([]Color, w*h)
+	fill := func(x, y, fw, fh int, c Color) {
+		for py := y; py < y+fh; py++ {
+			for px := x; px < x+fw; px++ {
+				pixels[py*w+px] = c
+			}
+		}
+	}
+
+	switch e := enc.(type) {
+	case *RawEncoding:
+		copy(pixels, e.Colors)
+	case *RREEncoding:
+		fill(0, 0, w, h, e.BackgroundColor)
+		for _, sub := range e.Subrectangles {
+			fill(int(sub.X), int(sub.Y), int(sub.Width), int(sub.Height), sub.Color)
+		}
+	case *HextileEncoding:
+		tilesX := (w + HextileTileSize - 1) / HextileTileSize
+		for i, tile := range e.Tiles {
+			tx, ty := i%tilesX*HextileTileSize, i/tilesX*HextileTileSize
+			if tile.Colors != nil {
+				for p, c := range tile.Colors {
+					pixels[(ty+p/int(tile.Width))*w+tx+p%int(tile.Width)] = c
+				}
+				continue
+			}
+			fill(tx, ty, int(tile.Width), int(tile.Height), tile.Background)
+			for _, sub := range tile.Subrectangles {
+				fill(tx+int(sub.X), ty+int(sub.Y), int(sub.Width), int(sub.Height), sub.Color)
+			}
+		}
+	default:
+		t.Fatalf("decodedPixels() does not handle %T", enc)
+	}
+	return pixels
+}
+
+func TestEncoder_RoundTrip(t *testing.T) {
+	encoders := map[string]func() Encoder{
+		"Raw":     func() Encoder { return &RawEncoding{} },
+		"RRE":     func() Encoder { return &RREEncoding{} },
+		"Hextile": func() Encoder { return &HextileEncoding{} },
+	}
+	const w, h = 45, 37
+
+	for name, newEncoder := range encoders {
+		for format, pf := range encodeTestFormats {
+			t.Run(name+"/"+format, func(t *testing.T) {
+				pixels := encodeTestImage(pf, w, h)
+				c := newEncodeConn(pf, w, h)
+				got := encodeRoundTrip(t, c, newEncoder(), pf, &Rectangle{Width: w, Height: h}, pixels)
+				if !slices.Equal(got, pixels) {
+					t.Error("decoded pixels differ from the encoded ones")
+				}
+			})
+		}
+	}
+}
+
+func TestEncoder_CopyRect(t *testing.T) {
+	data, err := (&CopyRectEncoding{SrcX: 100, SrcY: 200}).Encode(nil, &Rectangle{Width: 8, Height: 8}, nil)
+	if err != nil {
+		t.Fatalf("Encode() error = %v", err)
+	}
+	if want := []byte{0x00, 0x64, 0x00, 0xc8}; !bytes.Equal(data, want) {
+		t.Errorf("Encode() = %x, want %x", data, want)
+	}
+}
+
+func TestEncoder_HextileSolid(t *testing.T) {
+	pf := *PixelFormat32BitRGBA
+	pixels := slices.Repeat([]Color{{R: 1, G: 2, B: 3}}, 32*16)
+
+	data, err := (&HextileEncoding{}).Encode(&pf, &Rectangle{Width: 32, Height: 16}, pixels)
+	if err != nil {
+		t.Fatalf("Encode() error = %v", err)
+	}
+	// The second tile repeats the background of the first.
+	if want := []byte{HextileBackgroundSpecified, 3, 2, 1, 0, 0}; !bytes.Equal(data, want) {
+		t.Errorf("Encode() = %v, want %v", data, want)
+	}
+}
+
+func TestEncoder_HextileRawTiles(t *testing.T) {
+	// Enough raw tiles, including partial ones, to be expanded in batches on
+	// the pixel workers.
+	const w, h = 150, 70
+	for name, pf := range encodeTestFormats {
+		t.Run(name, func(t *testing.T) {
+			pixels := make([]Color, w*h)
+			for i := range pixels {
+				pixels[i] = Color{R: uint16(i*7) & pf.RedMax, G: uint16(i*3) & pf.GreenMax, B: uint16(i) & pf.BlueMax}
+			}
+			pw, err := newPixelWriter("test", &pf, &Rectangle{Width: w, Height: h}, pixels)
+			if err != nil {
+				t.Fatal(err)
+			}
+			area := pixelArea{pixels: pixels, stride: w, width: w, height: h}
+			var data []byte
+			for ty := 0; ty < h; ty += HextileTileSize {
+				for tx := 0; tx < w; tx += HextileTileSize {
+					data = append(data, HextileRaw)
+					for y := ty; y < min(ty+HextileTileSize, h); y++ {
+						for x := tx; x < min(tx+HextileTileSize, w); x++ {
+							data = pw.append(data, area.at(x, y))
+						}
+					}
+				}
+			}
+
+			rect := &Rectangle{Width: w, Height: h}
+			decoded, err := (&HextileEncoding{}).Read(newEncodeConn(pf, w, h), rect, bytes.NewReader(data))
+			if err != nil {
+				t.Fatalf("Read() error = %v", err)
+			}
+			if got := decodedPixels(t, decoded, w, h); !slices.Equal(got, pixels) {
+				t.Error("decoded raw tiles differ from the encoded pixels")
+			}
+		})
+	}
+}
+
+func TestEncoder_Invalid(t *testing.T) {
+	rect := &Rectangle{Width: 2, Height: 2}
+	pixels := make([]Color, 4)
+
+	if _, err := (&RawEncoding{}).Encode(PixelFormat8BitIndexed, rect, pixels); !IsVNCError(err, ErrUnsupported) {
+		t.Errorf("Encode() in a color map format error = %v, want an unsupported error", err)
+	}
+	if _, err := (&RREEncoding{}).Encode(PixelFormat32BitRGBA, rect, pixels[:3]); !IsVNCError(err, ErrValidation) {
+		t.Errorf("Encode() of too few pixels error = %v, want a validation error", err)
+	}
+	if _, err := (&HextileEncoding{}).Encode(nil, rect, pixels); !IsVNCError(err, ErrValidation) {
+		t.Errorf("Encode() without a pixel format error = %v, want a validation error", err)
+	}
+}
@@ -21,3 +21,32 @@ type PseudoEncoding interface {
 	IsPseudo() bool
 	Handle(*ClientConn, *Rectangle) error
 }
+
+// decoderState returns the per-connection state of a stateful encoding, such
+// as the zlib streams of Tight, creating it on first use. It is only called by
+// the goroutine decoding server messages.
+func decoderState[T any](c *ClientConn, encodingType int32) *T {
+	if state, ok := c.decoders[encodingType].(*T); ok {
+		return state
+	}
+	if c.decoders == nil {
+		c.decoders = make(map[int32]any)
+	}
+	state := new(T)
+	c.decoders[encodingType] = state
+	return state
+}
+
+// releaseDecoders closes the decoder states that hold resources, such as the
+// contexts of H.264, once the goroutine decoding server messages has stopped
+// using them.
+func (c *ClientConn) releaseDecoders() {
+	c.pumpMu.Lock()
+	defer c.pumpMu.Unlock()
+	for _, state := range c.decoders {
+		if closer, ok := state.(io.Closer); ok {
+			_ = closer.Close()
+		}
+	}
+	c.decoders = nil
+}
@@ -0,0 +1,142 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package vnc
+
+import (
+	"image"
+	"io"
+
+	"github.com/tenthirtyam/go-vnc/rfb"
+)
+
+// maxCursorSize is the largest cursor width or height accepted from a server.
+const maxCursorSize = 256
+
+// CursorImage is a cursor shape ready for local rendering. The image's bounds
+// start at the origin, and its pixels carry premultiplied alpha, as
+// image.RGBA defines, so it can be drawn with image/draw as is.
+type CursorImage struct {
+	// Image is the cursor shape. It is empty when the server hides the
+	// cursor.
+	Image *image.RGBA
+
+	// Hotspot is the position of the pointer within Image, such as the tip
+	// of an arrow.
+	Hotspot image.Point
+}
+
+// Hidden reports whether the server hid the cursor.
+func (ci *CursorImage) Hidden() bool {
+	return ci.Image == nil || ci.Image.Rect.Empty()
+}
+
+// clone returns a deep copy of ci.
+func (ci *CursorImage) clone() *CursorImage {
+	out := *ci
+	if ci.Image != nil {
+		img := *ci.Image
+		img.Pix = append([]uint8(nil), ci.Image.Pix...)
+		out.Image = &img
+	}
+	return &out
+}
+
+// AlphaCursorPseudoEncoding represents the Cursor With Alpha pseudo-encoding
+// of TigerVNC. Unlike CursorPseudoEncoding, whose mask makes each pixel either
+// opaque or transparent, it carries a full alpha channel, so shadows and
+// anti-aliased edges render as on the remote desktop. Servers that support it
+// prefer it to the classic cursor when a client requests both.
+//
+// Once handled, the shape is available from ClientConn.Cursor.
+type AlphaCursorPseudoEncoding struct {
+	// Width and Height are the size of the cursor in pixels. Both are zero
+	// when the cursor is hidden.
+	Width, Height uint16
+
+	// HotspotX and HotspotY are the position of the pointer within the
+	// cursor.
+	HotspotX, HotspotY uint16
+
+	// Pixels holds four bytes per pixel, red, green, blue, and alpha, in
+	// row-major order. The color components are premultiplied by alpha.
+	Pixels []uint8
+}
+
+// Type returns the encoding type identifier for the Cursor With Alpha
+// pseudo-encoding.
+func (*AlphaCursorPseudoEncoding) Type() int32 {
+	return rfb.PseudoEncodingCursorWithAlpha
+}
+
+// IsPseudo returns true indicating this is a pseudo-encoding.
+func (*AlphaCursorPseudoEncoding) IsPseudo() bool {
+	return true
+}
+
+// Read decodes a cursor shape. The rectangle's position is the hotspot and
+// its size the size of the cursor. The payload names the encoding of the
+// pixels, which are always 32-bit RGBA regardless of the pixel format of the
+// connection; Raw is the only encoding servers use for it.
+func (*AlphaCursorPseudoEncoding) Read(_ *ClientConn, rect *Rectangle, r io.Reader) (Encoding, error) {
+	cursor := &AlphaCursorPseudoEncoding{
+		Width:    rect.Width,
+		Height:   rect.Height,
+		HotspotX: rect.X,
+		HotspotY: rect.Y,
+	}
+
+	var header [4]byte
+	fields, err := readFields(r, "cursor encoding", header[:])
+	if err != nil {
+		return nil, encodingError("AlphaCursorPseudoEncoding.Read", "failed to read cursor encoding", err)
+	}
+	encodingType := fields.int32()
+	if encodingType != rfb.EncodingRaw {
+		return nil, unsupportedError("AlphaCursorPseudoEncoding.Read", "unsupported cursor encoding", nil)
+	}
+
+	if rect.Width == 0 || rect.Height == 0 {
+		return cursor, nil
+	}
+	if rect.Width > maxCursorSize || rect.Height > maxCursorSize {
+		return nil, encodingError("AlphaCursorPseudoEncoding.Read", "cursor dimensions too large", nil)
+	}
+
+	cursor.Pixels, err = readBytes(r, "cursor pixels", int(rect.Width)*int(rect.Height)*4)
+	if err != nil {
+		return nil, encodingError("AlphaCursorPseudoEncoding.Read", "failed to read cursor pixel data", err)
+	}
+
+	return cursor, nil
+}
+
+// Image returns the cursor as a CursorImage. The image shares Pixels.
+func (cursor *AlphaCursorPseudoEncoding) Image() *CursorImage {
+	img := &image.RGBA{}
+	if len(cursor.Pixels) > 0 {
+		img = &image.RGBA{
+			Pix:    cursor.Pixels,
+			Stride: int(cursor.Width) * 4,
+			Rect:   image.Rect(0, 0, int(cursor.Width), int(cursor.Height)),
+		}
+	}
+	return &CursorImage{
+		Image:   img,
+		Hotspot: image.Pt(int(cursor.HotspotX), int(cursor.HotspotY)),
+	}
+}
+
+// Handle records the cursor shape for ClientConn.Cursor.
+func (cursor *AlphaCursorPseudoEncoding) Handle(c *ClientConn, _ *Rectangle) error {
+	// The decoded message is handed to the application, so the recorded
+	// shape must not share its pixels.
+	c.setCursor(cursor.Image().clone())
+
+	c.logger.Debug("Alpha cursor updated",
+		Field{Key: "width", Value: cursor.Width},
+		Field{Key: "height", Value: cursor.Height},
+		Field{Key: "hotspot_x", Value: cursor.HotspotX},
+		Field{Key: "hotspot_y", Value: cursor.HotspotY})
+	return nil
+}
@@ -0,0 +1,102 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package vnc
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/tenthirtyam/go-vnc/rfb"
+)
+
+func TestAlphaCursor_Shape(t *testing.T) {
+	s := &replayStream{}
+	s.write(uint8(0), uint8(0), uint16(1))
+	s.rect(1, 0, 2, 1, rfb.PseudoEncodingCursorWithAlpha)
+	s.write(rfb.EncodingRaw)
+	// An opaque red pixel and a half-transparent white one.
+	s.write([]byte{0xff, 0, 0, 0xff, 0x80, 0x80, 0x80, 0x80})
+
+	// A hidden cursor has no pixels.
+	s.write(uint8(0), uint8(0), uint16(1))
+	s.rect(0, 0, 0, 0, rfb.PseudoEncodingCursorWithAlpha)
+	s.write(rfb.EncodingRaw)
+
+	c, msgs := runReplay(t, replayCase{
+		handshake:    replayHandshake(4, 4, "cursor"),
+		messages:     s.bytes(),
+		encodings:    []Encoding{&AlphaCursorPseudoEncoding{}, &RawEncoding{}},
+		wantMessages: []string{"shape", "hidden"},
+	})
+
+	enc, ok := msgs[0].(*FramebufferUpdateMessage).Rectangles[0].Enc.(*AlphaCursorPseudoEncoding)
+	if !ok {
+		t.Fatalf("first update decoded as %T", msgs[0].(*FramebufferUpdateMessage).Rectangles[0].Enc)
+	}
+	img := enc.Image()
+	if img.Hotspot != image.Pt(1, 0) || img.Image.Bounds() != image.Rect(0, 0, 2, 1) {
+		t.Errorf("Image() = hotspot %v, bounds %v; want (1,0) and 2x1", img.Hotspot, img.Image.Bounds())
+	}
+	if got := img.Image.RGBAAt(0, 0); got != (color.RGBA{R: 0xff, A: 0xff}) {
+		t.Errorf("pixel (0,0) = %v, want opaque red", got)
+	}
+	if got := img.Image.RGBAAt(1, 0); got != (color.RGBA{R: 0x80, G: 0x80, B: 0x80, A: 0x80}) {
+		t.Errorf("pixel (1,0) = %v, want half-transparent white", got)
+	}
+
+	if cursor := c.Cursor(); cursor == nil || !cursor.Hidden() {
+		t.Errorf("Cursor() = %+v after the hidden cursor, want a hidden cursor", cursor)
+	}
+}
+
+func TestAlphaCursor_UnsupportedEncoding(t *testing.T) {
+	s := &replayStream{}
+	s.write(rfb.EncodingZRLE)
+
+	rect := &Rectangle{Width: 1, Height: 1}
+	if _, err := (&AlphaCursorPseudoEncoding{}).Read(&ClientConn{logger: &NoOpLogger{}}, rect, bytes.NewReader(s.bytes())); !IsVNCError(err, ErrUnsupported) {
+		t.Errorf("Read() error = %v, want an unsupported error", err)
+	}
+}
+
+func TestCursor_Image(t *testing.T) {
+	c := &ClientConn{logger: &NoOpLogger{}}
+	c.setPixelFormat(PixelFormat{
+		BPP: 32, Depth: 24, TrueColor: true,
+		RedMax: 255, GreenMax: 255, BlueMax: 255,
+		RedShift: 16, GreenShift: 8,
+	})
+	if c.Cursor() != nil {
+		t.Fatal("Cursor() is set before the server sent a cursor")
+	}
+
+	// A blue pixel shown by the mask and a green one hidden by it.
+	cursor := &CursorPseudoEncoding{
+		Width: 2, Height: 1, HotspotX: 1,
+		PixelData: []uint8{0xff, 0, 0, 0, 0, 0xff, 0, 0},
+		MaskData:  []uint8{0x80},
+	}
+	if err := cursor.Handle(c, &Rectangle{}); err != nil {
+		t.Fatal(err)
+	}
+
+	got := c.Cursor()
+	if got == nil || got.Hidden() || got.Hotspot != image.Pt(1, 0) {
+		t.Fatalf("Cursor() = %+v, want a 2x1 cursor with hotspot (1,0)", got)
+	}
+	if px := got.Image.RGBAAt(0, 0); px != (color.RGBA{B: 0xff, A: 0xff}) {
+		t.Errorf("pixel (0,0) = %v, want opaque blue", px)
+	}
+	if px := got.Image.RGBAAt(1, 0); px != (color.RGBA{}) {
+		t.Errorf("pixel (1,0) = %v, want transparent", px)
+	}
+
+	// Cursor returns a copy.
+	got.Image.SetRGBA(0, 0, color.RGBA{})
+	if px := c.Cursor().Image.RGBAAt(0, 0); px.A == 0 {
+		t.Error("modifying the result of Cursor() changed the recorded cursor")
+	}
+}
@@ -140,15 +140,12 @@ func (*CopyRectEncoding) Type() int32 {
 //	// Wire bytes: [0x00, 0x64, 0x00, 0xC8]
 //	//             |  100   |  200   |
 func (*CopyRectEncoding) Read(c *ClientConn, rect *Rectangle, r io.Reader) (Encoding, error) {
-	var srcX, srcY uint16
-
-	if err := binary.Read(r, binary.BigEndian, &srcX); err != nil {
-		return nil, encodingError("CopyRectEncoding.Read", "failed to read source X coordinate", err)
-	}
-
-	if err := binary.Read(r, binary.BigEndian, &srcY); err != nil {
-		return nil, encodingError("CopyRectEncoding.Read", "failed to read source Y coordinate", err)
+	var buf [4]byte
+	fields, err := readFields(r, "source position", buf[:])
+	if err != nil {
+		return nil, encodingError("CopyRectEncoding.Read", "failed to read source position", err)
 	}
+	srcX, srcY := fields.uint16(), fields.uint16()
 
 	// Basic validation - full bounds checking should be done by application.
 	if srcX > 32767 || srcY > 32767 {
@@ -160,3 +157,16 @@ func (*CopyRectEncoding) Read(c *ClientConn, rect *Rectangle, r io.Reader) (Enco
 		SrcY: srcY,
 	}, nil
 }
+
+// Encode returns the source position of e. CopyRect carries no pixels, so
+// pixels is ignored and may be nil.
+func (e *CopyRectEncoding) Encode(_ *PixelFormat, _ *Rectangle, _ []Color) ([]byte, error) {
+	buf := make([]byte, 0, 4)
+	buf = binary.BigEndian.AppendUint16(buf, e.SrcX)
+	return binary.BigEndian.AppendUint16(buf, e.SrcY), nil
+}
+
+// paint copies the source area within the client framebuffer.
+func (e *CopyRectEncoding) paint(fb *framebuffer, rect *Rectangle) {
+	fb.copyRect(int(e.SrcX), int(e.SrcY), int(rect.X), int(rect.Y), int(rect.Width), int(rect.Height))
+}
@@ -4,6 +4,7 @@
 package vnc
 
 import (
+	"image"
 	"io"
 )
 
@@ -134,15 +135,14 @@ func (*CursorPseudoEncoding) Read(c *ClientConn, rect *Rectangle, r io.Reader) (
 		return cursor, nil
 	}
 
-	if rect.Width > 256 || rect.Height > 256 {
+	if rect.Width > maxCursorSize || rect.Height > maxCursorSize {
 		return nil, encodingError("CursorPseudoEncoding.Read", "cursor dimensions too large", nil)
 	}
 
-	pixelDataSize := calculatePixelDataSize(rect.Width, rect.Height, c.PixelFormat)
+	pixelReader := c.pixelReader()
+	pixelDataSize := calculatePixelDataSize(rect.Width, rect.Height, pixelReader.pixelFormat)
 	maskDataSize := calculateMaskDataSize(rect.Width, rect.Height)
 
-	pixelReader := NewPixelReader(c.PixelFormat, c.ColorMap)
-
 	var err error
 	cursor.PixelData, err = pixelReader.ReadPixelData(r, pixelDataSize)
 	if err != nil {
@@ -154,6 +154,12 @@ func (*CursorPseudoEncoding) Read(c *ClientConn, rect *Rectangle, r io.Reader) (
 		return nil, encodingError("CursorPseudoEncoding.Read", "failed to read cursor mask data", err)
 	}
 
+	// Cursor images are drawn by the server in its framebuffer format, so
+	// they help detect a mislabeled byte order before any update arrives.
+	if pixelReader.probe != nil && !pixelReader.probe.done {
+		pixelReader.probe.observeData(cursor.PixelData, pixelReader.byteOrder)
+	}
+
 	return cursor, nil
 }
 
@@ -180,9 +186,10 @@ func (*CursorPseudoEncoding) Read(c *ClientConn, rect *Rectangle, r io.Reader) (
 //		log.Printf("Failed to handle cursor update: %v", err)
 //	}
 //
-// Note: This is a basic implementation that logs the cursor update.
-// Applications should extend this to integrate with their cursor management system.
+// The cursor shape is converted to RGBA and recorded for ClientConn.Cursor.
 func (cursor *CursorPseudoEncoding) Handle(c *ClientConn, rect *Rectangle) error {
+	c.setCursor(cursor.image(c.loadState()))
+
 	if cursor.Width == 0 && cursor.Height == 0 {
 		c.logger.Debug("Cursor hidden")
 	} else {
@@ -193,7 +200,34 @@ func (cursor *CursorPseudoEncoding) Handle(c *ClientConn, rect *Rectangle) error
 			Field{Key: "hotspot_y", Value: cursor.HotspotY})
 	}
 
-	// Applications should override this method or handle cursor updates
-	// in their message processing loop to integrate with their cursor system
 	return nil
 }
+
+// image converts the cursor to a CursorImage using the pixel format and color
+// map of s. Pixels outside the mask are transparent.
+func (cursor *CursorPseudoEncoding) image(s *connState) *CursorImage {
+	img := image.NewRGBA(image.Rect(0, 0, int(cursor.Width), int(cursor.Height)))
+	ci := &CursorImage{
+		Image:   img,
+		Hotspot: image.Pt(int(cursor.HotspotX), int(cursor.HotspotY)),
+	}
+
+	pr := newPixelReader(s.pixelFormat, s.colorMap)
+	bpp := pr.BytesPerPixel()
+	maskStride := (int(cursor.Width) + 7) / 8
+	if len(cursor.PixelData) < int(cursor.Width)*int(cursor.Height)*bpp ||
+		len(cursor.MaskData) < maskStride*int(cursor.Height) {
+		return ci
+	}
+
+	for y := range int(cursor.Height) {
+		for x := range int(cursor.Width) {
+			if cursor.MaskData[y*maskStride+x/8]&(0x80>>(x%8)) == 0 {
+				continue
+			}
+			i := (y*int(cursor.Width) + x) * bpp
+			img.SetRGBA(x, y, pixelRGBA(&s.pixelFormat, pr.pixelToColor(pr.bytesToPixel(cursor.PixelData[i:i+bpp]))))
+		}
+	}
+	return ci
+}
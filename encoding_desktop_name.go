@@ -0,0 +1,76 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package vnc
+
+import (
+	"io"
+
+	"github.com/tenthirtyam/go-vnc/rfb"
+)
+
+// DesktopNamePseudoEncoding represents the DesktopName pseudo-encoding, with
+// which servers such as TigerVNC announce that the desktop was renamed during
+// the session.
+//
+// Once handled, the new name is returned by ClientConn.GetDesktopName. The
+// FramebufferUpdateMessage carrying the rectangle is the notification of the
+// change; applications watching for renames look for this encoding among its
+// rectangles.
+type DesktopNamePseudoEncoding struct {
+	// Name is the new desktop name.
+	Name string
+}
+
+// Type returns the encoding type identifier for the DesktopName
+// pseudo-encoding.
+func (*DesktopNamePseudoEncoding) Type() int32 {
+	return rfb.PseudoEncodingDesktopName
+}
+
+// IsPseudo returns true indicating this is a pseudo-encoding.
+func (*DesktopNamePseudoEncoding) IsPseudo() bool {
+	return true
+}
+
+// Read decodes the new desktop name, a length-prefixed UTF-8 string. Names
+// that are not valid text are sanitized like the name in the ServerInit.
+func (*DesktopNamePseudoEncoding) Read(c *ClientConn, _ *Rectangle, r io.Reader) (Encoding, error) {
+	var header [4]byte
+	fields, err := readFields(r, "desktop name length", header[:])
+	if err != nil {
+		return nil, encodingError("DesktopNamePseudoEncoding.Read", "failed to read desktop name length", err)
+	}
+	length := fields.uint32()
+
+	validator := newInputValidator()
+	if err := validator.ValidateMessageLength(length, rfb.MaxDesktopNameLength); err != nil {
+		return nil, protocolError("DesktopNamePseudoEncoding.Read", "server sent invalid desktop name length", err)
+	}
+
+	nameBytes, err := readBytes(r, "desktop name", int(length))
+	if err != nil {
+		return nil, encodingError("DesktopNamePseudoEncoding.Read", "failed to read desktop name", err)
+	}
+
+	name := string(nameBytes)
+	if err := validator.ValidateTextData(name, rfb.MaxDesktopNameLength); err != nil {
+		c.logger.Warn("Invalid desktop name received from server, sanitizing",
+			Field{Key: "original_name", Value: name},
+			Field{Key: "error", Value: err})
+		name = validator.SanitizeText(name)
+	}
+
+	return &DesktopNamePseudoEncoding{Name: name}, nil
+}
+
+// Handle records the new desktop name for ClientConn.GetDesktopName.
+func (desktop *DesktopNamePseudoEncoding) Handle(c *ClientConn, _ *Rectangle) error {
+	oldName := c.GetDesktopName()
+	c.setDesktopName(desktop.Name)
+
+	c.logger.Info("Desktop name changed",
+		Field{Key: "old_name", Value: oldName},
+		Field{Key: "new_name", Value: desktop.Name})
+	return nil
+}
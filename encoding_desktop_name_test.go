@@ -0,0 +1,83 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package vnc
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/tenthirtyam/go-vnc/rfb"
+)
+
+func TestDesktopName_Rename(t *testing.T) {
+	s := &replayStream{}
+	s.write(uint8(0), uint8(0), uint16(1))
+	s.rect(0, 0, 0, 0, rfb.PseudoEncodingDesktopName)
+	s.write(uint32(len("renamed")), []byte("renamed"))
+
+	c, msgs := runReplay(t, replayCase{
+		handshake:    replayHandshake(4, 4, "original"),
+		messages:     s.bytes(),
+		encodings:    []Encoding{&DesktopNamePseudoEncoding{}, &RawEncoding{}},
+		wantMessages: []string{"rename"},
+	})
+
+	enc, ok := msgs[0].(*FramebufferUpdateMessage).Rectangles[0].Enc.(*DesktopNamePseudoEncoding)
+	if !ok || enc.Name != "renamed" {
+		t.Fatalf("update rectangle = %+v, want the DesktopName notification", msgs[0])
+	}
+	if got := c.GetDesktopName(); got != "renamed" {
+		t.Errorf("GetDesktopName() = %q, want %q", got, "renamed")
+	}
+}
+
+func TestDesktopName_Read(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload func(s *replayStream)
+		want    string
+		wantErr bool
+	}{
+		{
+			name:    "UTF8",
+			payload: func(s *replayStream) { s.write(uint32(len("café")), []byte("café")) },
+			want:    "café",
+		},
+		{
+			name:    "Invalid",
+			payload: func(s *replayStream) { s.write(uint32(3), []byte{'a', 0xff, 'b'}) },
+			want:    "a�b",
+		},
+		{
+			name:    "TooLong",
+			payload: func(s *replayStream) { s.write(uint32(rfb.MaxDesktopNameLength + 1)) },
+			wantErr: true,
+		},
+		{
+			name:    "Truncated",
+			payload: func(s *replayStream) { s.write(uint32(10), []byte("short")) },
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &replayStream{}
+			tt.payload(s)
+			enc, err := (&DesktopNamePseudoEncoding{}).Read(&ClientConn{logger: &NoOpLogger{}}, &Rectangle{}, bytes.NewReader(s.bytes()))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("Read() = %+v, want an error", enc)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := enc.(*DesktopNamePseudoEncoding).Name; got != tt.want {
+				t.Errorf("Name = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
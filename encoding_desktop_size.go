@@ -29,31 +29,40 @@ func (*DesktopSizePseudoEncoding) IsPseudo() bool {
 
 // Read decodes DesktopSize pseudo-encoding data from the server.
 func (*DesktopSizePseudoEncoding) Read(c *ClientConn, rect *Rectangle, r io.Reader) (Encoding, error) {
-	if rect.Width == 0 || rect.Height == 0 {
-		return nil, validationError("DesktopSizePseudoEncoding.Read", "desktop dimensions cannot be zero", nil)
+	if err := validateDesktopSize("DesktopSizePseudoEncoding.Read", rect.Width, rect.Height); err != nil {
+		return nil, err
 	}
 
-	if rect.Width > 32767 || rect.Height > 32767 {
-		return nil, validationError("DesktopSizePseudoEncoding.Read", "desktop dimensions too large", nil)
+	return &DesktopSizePseudoEncoding{
+		Width:  rect.Width,
+		Height: rect.Height,
+	}, nil
+}
+
+// validateDesktopSize checks the dimensions of a resized desktop.
+func validateDesktopSize(op string, width, height uint16) error {
+	if width == 0 || height == 0 {
+		return validationError(op, "desktop dimensions cannot be zero", nil)
+	}
+
+	if width > 32767 || height > 32767 {
+		return validationError(op, "desktop dimensions too large", nil)
 	}
 
 	const maxPixels = 100 * 1024 * 1024
-	if uint64(rect.Width)*uint64(rect.Height) > maxPixels {
-		return nil, validationError("DesktopSizePseudoEncoding.Read", "desktop size would require too much memory", nil)
+	if uint64(width)*uint64(height) > maxPixels {
+		return validationError(op, "desktop size would require too much memory", nil)
 	}
 
-	return &DesktopSizePseudoEncoding{
-		Width:  rect.Width,
-		Height: rect.Height,
-	}, nil
+	return nil
 }
 
 // Handle processes the desktop size pseudo-encoding by updating the client's framebuffer dimensions.
 // This method implements the PseudoEncoding interface and automatically updates the client
 // connection's framebuffer size when a desktop resize occurs.
 //
-// The method updates the client connection's FrameBufferWidth and FrameBufferHeight fields
-// to reflect the new desktop dimensions. Applications can then respond to the size change
+// The method updates the dimensions returned by the client connection's
+// GetFrameBufferSize to reflect the new desktop dimensions. Applications can then respond to the size change
 // by resizing their display windows, updating viewports, or requesting new framebuffer data.
 //
 // Parameters:
@@ -73,20 +82,20 @@ func (*DesktopSizePseudoEncoding) Read(c *ClientConn, rect *Rectangle, r io.Read
 //	}
 //
 //	// After handling, the client connection will have updated dimensions:
-//	fmt.Printf("New framebuffer size: %dx%d\n",
-//		clientConn.FrameBufferWidth, clientConn.FrameBufferHeight)
+//	width, height := clientConn.GetFrameBufferSize()
+//	fmt.Printf("New framebuffer size: %dx%d\n", width, height)
 //
 // Integration with application:
 //
 //	// Applications can monitor for desktop size changes:
 //	func monitorDesktopSize(client *ClientConn) {
-//		oldWidth, oldHeight := client.FrameBufferWidth, client.FrameBufferHeight
+//		oldWidth, oldHeight := client.GetFrameBufferSize()
 //
 //		// Check periodically or in message handler
-//		if client.FrameBufferWidth != oldWidth || client.FrameBufferHeight != oldHeight {
+//		if width, height := client.GetFrameBufferSize(); width != oldWidth || height != oldHeight {
 //			// Handle desktop size change
-//			handleDesktopResize(client.FrameBufferWidth, client.FrameBufferHeight)
-//			oldWidth, oldHeight = client.FrameBufferWidth, client.FrameBufferHeight
+//			handleDesktopResize(width, height)
+//			oldWidth, oldHeight = width, height
 //		}
 //	}
 //
@@ -95,10 +104,8 @@ func (*DesktopSizePseudoEncoding) Read(c *ClientConn, rect *Rectangle, r io.Read
 func (desktop *DesktopSizePseudoEncoding) Handle(c *ClientConn, rect *Rectangle) error {
 	oldWidth, oldHeight := c.GetFrameBufferSize()
 
-	c.mu.Lock()
-	c.FrameBufferWidth = desktop.Width
-	c.FrameBufferHeight = desktop.Height
-	c.mu.Unlock()
+	c.setFrameBufferSize(desktop.Width, desktop.Height)
+	c.setScreens(nil)
 
 	c.logger.Info("Desktop size changed",
 		Field{Key: "old_width", Value: oldWidth},
@@ -108,3 +115,8 @@ func (desktop *DesktopSizePseudoEncoding) Handle(c *ClientConn, rect *Rectangle)
 
 	return nil
 }
+
+// paint resizes the client framebuffer to the new desktop size.
+func (e *DesktopSizePseudoEncoding) paint(fb *framebuffer, _ *Rectangle) {
+	fb.resize(e.Width, e.Height)
+}
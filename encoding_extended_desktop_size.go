@@ -0,0 +1,177 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package vnc
+
+import (
+	"encoding/binary"
+	"io"
+
+	"github.com/tenthirtyam/go-vnc/rfb"
+)
+
+// Screen is one screen of a multi-monitor desktop, as reported by the
+// ExtendedDesktopSize pseudo-encoding. Its position is in framebuffer
+// coordinates.
+type Screen struct {
+	// ID identifies the screen across layout changes.
+	ID uint32
+
+	// X and Y are the position of the screen's top-left corner.
+	X, Y uint16
+
+	// Width and Height are the size of the screen in pixels.
+	Width, Height uint16
+
+	// Flags is reserved by the protocol and is currently always zero.
+	Flags uint32
+}
+
+// DesktopSizeReason tells why the server sent an ExtendedDesktopSize update.
+type DesktopSizeReason uint16
+
+// Reasons for an ExtendedDesktopSize update.
+const (
+	// DesktopSizeReasonServer means the server changed the desktop itself, or
+	// is reporting its initial layout.
+	DesktopSizeReasonServer DesktopSizeReason = 0
+
+	// DesktopSizeReasonClient answers a resize requested by this client.
+	DesktopSizeReasonClient DesktopSizeReason = 1
+
+	// DesktopSizeReasonOtherClient reports a resize requested by another
+	// client of the same desktop.
+	DesktopSizeReasonOtherClient DesktopSizeReason = 2
+)
+
+// DesktopSizeStatus is the result of a resize requested by this client.
+type DesktopSizeStatus uint16
+
+// Results of a requested resize. Updates for other reasons always report
+// DesktopSizeStatusOK.
+const (
+	// DesktopSizeStatusOK means the desktop has the reported layout.
+	DesktopSizeStatusOK DesktopSizeStatus = 0
+
+	// DesktopSizeStatusProhibited means the server does not allow clients to
+	// resize the desktop.
+	DesktopSizeStatusProhibited DesktopSizeStatus = 1
+
+	// DesktopSizeStatusOutOfResources means the server could not allocate
+	// the requested desktop.
+	DesktopSizeStatusOutOfResources DesktopSizeStatus = 2
+
+	// DesktopSizeStatusInvalidLayout means the requested screen layout was
+	// rejected.
+	DesktopSizeStatusInvalidLayout DesktopSizeStatus = 3
+)
+
+// extendedDesktopScreenSize is the size of one screen on the wire.
+const extendedDesktopScreenSize = 16
+
+// ExtendedDesktopSizePseudoEncoding represents the ExtendedDesktopSize
+// pseudo-encoding. It supersedes DesktopSize on servers such as TigerVNC and
+// QEMU: besides the new framebuffer size it carries the layout of every
+// screen, which Screens returns once the update has been handled.
+type ExtendedDesktopSizePseudoEncoding struct {
+	// Reason tells why the update was sent.
+	Reason DesktopSizeReason
+
+	// Status is the result of a resize requested by this client.
+	Status DesktopSizeStatus
+
+	// Width is the new framebuffer width in pixels.
+	Width uint16
+
+	// Height is the new framebuffer height in pixels.
+	Height uint16
+
+	// Screens is the new screen layout.
+	Screens []Screen
+}
+
+// Type returns the encoding type identifier for the ExtendedDesktopSize
+// pseudo-encoding.
+func (*ExtendedDesktopSizePseudoEncoding) Type() int32 {
+	return rfb.PseudoEncodingExtendedDesktopSize
+}
+
+// IsPseudo returns true indicating this is a pseudo-encoding.
+func (*ExtendedDesktopSizePseudoEncoding) IsPseudo() bool {
+	return true
+}
+
+// Read decodes ExtendedDesktopSize data from the server. The rectangle's
+// position carries the reason and status, its size the new framebuffer size,
+// and the payload the screen list.
+func (*ExtendedDesktopSizePseudoEncoding) Read(c *ClientConn, rect *Rectangle, r io.Reader) (Encoding, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, encodingError("ExtendedDesktopSizePseudoEncoding.Read", "failed to read number of screens", err)
+	}
+
+	screens := make([]Screen, header[0])
+	buf := make([]byte, extendedDesktopScreenSize)
+	for i := range screens {
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, encodingError("ExtendedDesktopSizePseudoEncoding.Read", "failed to read screen", err)
+		}
+		screens[i] = Screen{
+			ID:     binary.BigEndian.Uint32(buf[0:4]),
+			X:      binary.BigEndian.Uint16(buf[4:6]),
+			Y:      binary.BigEndian.Uint16(buf[6:8]),
+			Width:  binary.BigEndian.Uint16(buf[8:10]),
+			Height: binary.BigEndian.Uint16(buf[10:12]),
+			Flags:  binary.BigEndian.Uint32(buf[12:16]),
+		}
+	}
+
+	e := &ExtendedDesktopSizePseudoEncoding{
+		Reason:  DesktopSizeReason(rect.X),
+		Status:  DesktopSizeStatus(rect.Y),
+		Width:   rect.Width,
+		Height:  rect.Height,
+		Screens: screens,
+	}
+	if e.Status == DesktopSizeStatusOK {
+		if err := validateDesktopSize("ExtendedDesktopSizePseudoEncoding.Read", e.Width, e.Height); err != nil {
+			return nil, err
+		}
+	}
+	return e, nil
+}
+
+// Handle records the new framebuffer size and screen layout. Failed resize
+// requests leave both unchanged and are only logged. A resize by another
+// client shows that the desktop is shared; see SharingOtherClient.
+func (e *ExtendedDesktopSizePseudoEncoding) Handle(c *ClientConn, _ *Rectangle) error {
+	if e.Reason == DesktopSizeReasonOtherClient {
+		c.observeOtherClient()
+	}
+	if e.Status != DesktopSizeStatusOK {
+		c.logger.Warn("Server rejected desktop resize",
+			Field{Key: "status", Value: uint16(e.Status)})
+		return nil
+	}
+
+	oldWidth, oldHeight := c.GetFrameBufferSize()
+	c.setFrameBufferSize(e.Width, e.Height)
+	c.setScreens(e.Screens)
+
+	c.logger.Info("Desktop size changed",
+		Field{Key: "old_width", Value: oldWidth},
+		Field{Key: "old_height", Value: oldHeight},
+		Field{Key: "new_width", Value: e.Width},
+		Field{Key: "new_height", Value: e.Height},
+		Field{Key: "screens", Value: len(e.Screens)},
+		Field{Key: "reason", Value: uint16(e.Reason)})
+
+	return nil
+}
+
+// paint resizes the client framebuffer to the new desktop size.
+func (e *ExtendedDesktopSizePseudoEncoding) paint(fb *framebuffer, _ *Rectangle) {
+	if e.Status == DesktopSizeStatusOK {
+		fb.resize(e.Width, e.Height)
+	}
+}
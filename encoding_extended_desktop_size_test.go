@@ -0,0 +1,61 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package vnc
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/tenthirtyam/go-vnc/rfb"
+)
+
+func TestExtendedDesktopSize_Layout(t *testing.T) {
+	s := &replayStream{}
+	s.write(uint8(0), uint8(0), uint16(1))
+	s.rect(0, 0, 8, 4, rfb.PseudoEncodingExtendedDesktopSize)
+	s.write(uint8(2), [3]byte{})
+	s.write(uint32(1), uint16(0), uint16(0), uint16(4), uint16(4), uint32(0))
+	s.write(uint32(7), uint16(4), uint16(0), uint16(4), uint16(4), uint32(0))
+
+	// A rejected resize request leaves the layout unchanged.
+	s.write(uint8(0), uint8(0), uint16(1))
+	s.rect(uint16(DesktopSizeReasonClient), uint16(DesktopSizeStatusProhibited), 0, 0, rfb.PseudoEncodingExtendedDesktopSize)
+	s.write(uint8(0), [3]byte{})
+
+	tc := replayCase{
+		handshake:    replayHandshake(2, 2, "screens"),
+		messages:     s.bytes(),
+		encodings:    []Encoding{&ExtendedDesktopSizePseudoEncoding{}, &RawEncoding{}},
+		wantMessages: []string{"resize", "rejected"},
+	}
+	c, msgs := runReplay(t, tc)
+
+	enc, ok := msgs[1].(*FramebufferUpdateMessage).Rectangles[0].Enc.(*ExtendedDesktopSizePseudoEncoding)
+	if !ok || enc.Status != DesktopSizeStatusProhibited || enc.Reason != DesktopSizeReasonClient {
+		t.Errorf("second update = %+v, want a prohibited client resize", enc)
+	}
+
+	if w, h := c.GetFrameBufferSize(); w != 8 || h != 4 {
+		t.Errorf("GetFrameBufferSize() = %dx%d, want 8x4", w, h)
+	}
+	want := []Screen{
+		{ID: 1, Width: 4, Height: 4},
+		{ID: 7, X: 4, Width: 4, Height: 4},
+	}
+	if got := c.Screens(); !slices.Equal(got, want) {
+		t.Errorf("Screens() = %+v, want %+v", got, want)
+	}
+}
+
+func TestExtendedDesktopSize_DesktopSizeClearsLayout(t *testing.T) {
+	c := &ClientConn{logger: &NoOpLogger{}}
+	c.setScreens([]Screen{{ID: 1, Width: 4, Height: 4}})
+
+	if err := (&DesktopSizePseudoEncoding{Width: 10, Height: 10}).Handle(c, &Rectangle{}); err != nil {
+		t.Fatal(err)
+	}
+	if screens := c.Screens(); screens != nil {
+		t.Errorf("Screens() = %+v after DesktopSize, want nil", screens)
+	}
+}
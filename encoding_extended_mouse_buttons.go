@@ -0,0 +1,47 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package vnc
+
+import (
+	"io"
+
+	"github.com/tenthirtyam/go-vnc/rfb"
+)
+
+// ExtendedMouseButtonsPseudoEncoding represents the ExtendedMouseButtons pseudo-encoding.
+// Including it in SetEncodings asks the server to accept pointer events for buttons
+// beyond the eight covered by the standard button mask, such as back and forward.
+// The server confirms support by sending an empty rectangle with this encoding.
+type ExtendedMouseButtonsPseudoEncoding struct{}
+
+// Type returns the encoding type identifier for ExtendedMouseButtons pseudo-encoding.
+func (*ExtendedMouseButtonsPseudoEncoding) Type() int32 {
+	return rfb.PseudoEncodingExtendedMouseButtons
+}
+
+// IsPseudo returns true indicating this is a pseudo-encoding.
+func (*ExtendedMouseButtonsPseudoEncoding) IsPseudo() bool {
+	return true
+}
+
+// Read decodes the ExtendedMouseButtons confirmation, which carries no payload.
+func (e *ExtendedMouseButtonsPseudoEncoding) Read(*ClientConn, *Rectangle, io.Reader) (Encoding, error) {
+	return e, nil
+}
+
+// Handle records that the server accepts extended pointer events. Once confirmed,
+// PointerEvent sends masks containing ButtonBack, ButtonForward, or higher buttons
+// in the extended format.
+func (*ExtendedMouseButtonsPseudoEncoding) Handle(c *ClientConn, _ *Rectangle) error {
+	if !c.extendedMouseButtons.Swap(true) {
+		c.logger.Info("Server confirmed extended mouse buttons")
+	}
+	return nil
+}
+
+// ExtendedMouseButtons reports whether the server has confirmed the
+// ExtendedMouseButtons pseudo-encoding for this connection.
+func (c *ClientConn) ExtendedMouseButtons() bool {
+	return c.extendedMouseButtons.Load()
+}
@@ -0,0 +1,215 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+//go:build !vnc_minimal
+
+package vnc
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"io"
+
+	"github.com/tenthirtyam/go-vnc/rfb"
+)
+
+const (
+	// h264MaxLength bounds the length of the H.264 data of a single
+	// rectangle.
+	h264MaxLength = 64 << 20
+
+	// h264MaxContexts is the number of H.264 contexts kept per connection,
+	// as in TigerVNC. Creating another one closes the least recently created.
+	h264MaxContexts = 64
+)
+
+// Flags of an H.264 rectangle.
+const (
+	// h264ResetContext discards the context of the rectangle before its data
+	// is decoded.
+	h264ResetContext = 1 << 0
+
+	// h264ResetAllContexts discards every context before the data is decoded.
+	h264ResetAllContexts = 1 << 1
+)
+
+// VideoDecoder decodes the H.264 stream of one area of the framebuffer. The
+// package includes no H.264 codec; applications supply a decoder that wraps
+// one, such as FFmpeg through cgo or a pure-Go implementation, with
+// H264Encoding.NewDecoder. A decoder is only used by the goroutine decoding
+// server messages.
+type VideoDecoder interface {
+	// Decode decodes data, one or more NAL units in Annex B byte stream
+	// format, and returns the most recent frame, or nil if data completed no
+	// frame. Frames may be larger than the area, as H.264 pads them to whole
+	// macroblocks; their top-left part is used.
+	Decode(data []byte) (image.Image, error)
+
+	// Close releases the decoder.
+	Close() error
+}
+
+// VideoDecoderFactory creates the decoder of a new H.264 context, which
+// covers an area of the given size.
+type VideoDecoderFactory func(width, height int) (VideoDecoder, error)
+
+// H264Encoding represents the Open H.264 encoding (type 50) streamed by
+// TigerVNC and KasmVNC. Each rectangle carries H.264 data continuing the video
+// stream, or context, of earlier rectangles with the same position and size,
+// so an application offers it only with a decoder:
+//
+//	client.SetEncodings([]vnc.Encoding{
+//		&vnc.H264Encoding{NewDecoder: newFFmpegDecoder},
+//		&vnc.ZRLEEncoding{},
+//		&vnc.RawEncoding{},
+//	})
+//
+// Contexts are closed when the server resets them and when the connection is
+// closed. Because they span rectangles, a session that has received H.264
+// data cannot be resumed after Detach.
+type H264Encoding struct {
+	// NewDecoder creates the decoder of each context. Rectangles fail to
+	// decode without it.
+	NewDecoder VideoDecoderFactory
+
+	// Colors contains the decoded pixel data for the rectangle in row-major
+	// order, or nil if the data completed no frame and the rectangle keeps
+	// its contents. Components are in the ranges of the session pixel
+	// format, as for RawEncoding.
+	Colors []Color
+}
+
+// Type returns the encoding type identifier for H.264 encoding.
+func (*H264Encoding) Type() int32 {
+	return rfb.EncodingH264
+}
+
+// h264Key identifies the context of a rectangle by its position and size.
+type h264Key struct {
+	x, y, width, height uint16
+}
+
+// h264State holds the H.264 contexts of a connection.
+type h264State struct {
+	contexts map[h264Key]VideoDecoder
+
+	// order lists the keys of contexts from the least recently created.
+	order []h264Key
+}
+
+// decoder returns the decoder of the context for key, creating it with
+// newDecoder if there is none.
+func (s *h264State) decoder(key h264Key, newDecoder VideoDecoderFactory) (VideoDecoder, error) {
+	if dec, ok := s.contexts[key]; ok {
+		return dec, nil
+	}
+	if len(s.order) == h264MaxContexts {
+		s.reset(s.order[0])
+	}
+
+	dec, err := newDecoder(int(key.width), int(key.height))
+	if err != nil {
+		return nil, err
+	}
+	if s.contexts == nil {
+		s.contexts = make(map[h264Key]VideoDecoder)
+	}
+	s.contexts[key] = dec
+	s.order = append(s.order, key)
+	return dec, nil
+}
+
+// reset closes the context for key, if any.
+func (s *h264State) reset(key h264Key) {
+	dec, ok := s.contexts[key]
+	if !ok {
+		return
+	}
+	_ = dec.Close()
+	delete(s.contexts, key)
+	for i, k := range s.order {
+		if k == key {
+			s.order = append(s.order[:i], s.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// Close closes every context.
+func (s *h264State) Close() error {
+	var errs []error
+	for _, dec := range s.contexts {
+		errs = append(errs, dec.Close())
+	}
+	s.contexts, s.order = nil, nil
+	return errors.Join(errs...)
+}
+
+// Read decodes an H.264 rectangle with the decoder of its context.
+func (e *H264Encoding) Read(c *ClientConn, rect *Rectangle, r io.Reader) (Encoding, error) {
+	var header [8]byte
+	fields, err := readFields(r, "H.264 header", header[:])
+	if err != nil {
+		return nil, encodingError("H264Encoding.Read", "failed to read rectangle header", err)
+	}
+	length, flags := fields.uint32(), fields.uint32()
+	if length > h264MaxLength {
+		return nil, encodingError("H264Encoding.Read",
+			fmt.Sprintf("data length %d exceeds maximum %d", length, h264MaxLength), nil)
+	}
+
+	data, err := readBytes(r, "video data", int(length))
+	if err != nil {
+		return nil, encodingError("H264Encoding.Read", "failed to read video data", err)
+	}
+
+	state := decoderState[h264State](c, rfb.EncodingH264)
+	key := h264Key{rect.X, rect.Y, rect.Width, rect.Height}
+	if flags&h264ResetAllContexts != 0 {
+		_ = state.Close()
+	}
+	if flags&h264ResetContext != 0 {
+		state.reset(key)
+	}
+	if length == 0 {
+		return &H264Encoding{}, nil
+	}
+
+	if e.NewDecoder == nil {
+		return nil, encodingError("H264Encoding.Read", "no video decoder configured", nil)
+	}
+	pf := c.pixelReader().pixelFormat
+	if !pf.TrueColor {
+		return nil, encodingError("H264Encoding.Read", "H.264 rectangle in a color map pixel format", nil)
+	}
+
+	dec, err := state.decoder(key, e.NewDecoder)
+	if err != nil {
+		return nil, encodingError("H264Encoding.Read", "failed to create video decoder", err)
+	}
+	img, err := dec.Decode(data)
+	if err != nil {
+		// The decoder may have lost its reference frames, so the server's
+		// next frames for the context cannot be decoded either.
+		state.reset(key)
+		return nil, encodingError("H264Encoding.Read", "failed to decode video data", err)
+	}
+	if img == nil {
+		return &H264Encoding{}, nil
+	}
+
+	width, height := int(rect.Width), int(rect.Height)
+	if size := img.Bounds().Size(); size.X < width || size.Y < height {
+		return nil, encodingError("H264Encoding.Read",
+			fmt.Sprintf("video frame is %v, rectangle is %dx%d", size, width, height), nil)
+	}
+	return &H264Encoding{Colors: imageColors(img, pf, width, height)}, nil
+}
+
+// paint renders the decoded frame into the client framebuffer.
+func (e *H264Encoding) paint(fb *framebuffer, rect *Rectangle) {
+	if len(e.Colors) > 0 {
+		fb.setColors(rect, e.Colors)
+	}
+}
@@ -0,0 +1,190 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+//go:build !vnc_minimal
+
+package vnc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"testing"
+)
+
+// fakeVideoDecoder is a VideoDecoder whose frames are solid gray images of the
+// level given by the last byte of the data, padded to whole macroblocks. Data
+// ending in zero completes no frame.
+type fakeVideoDecoder struct {
+	width, height int
+	frames        int
+	closed        bool
+}
+
+func (d *fakeVideoDecoder) Decode(data []byte) (image.Image, error) {
+	level := data[len(data)-1]
+	if level == 0xff {
+		return nil, errors.New("corrupt stream")
+	}
+	if level == 0 {
+		return nil, nil
+	}
+	d.frames++
+	img := image.NewRGBA(image.Rect(0, 0, (d.width+15)&^15, (d.height+15)&^15))
+	for i := range img.Pix {
+		img.Pix[i] = level
+	}
+	return img, nil
+}
+
+func (d *fakeVideoDecoder) Close() error {
+	d.closed = true
+	return nil
+}
+
+// fakeVideoDecoders records the decoders it creates.
+type fakeVideoDecoders []*fakeVideoDecoder
+
+func (f *fakeVideoDecoders) new(width, height int) (VideoDecoder, error) {
+	dec := &fakeVideoDecoder{width: width, height: height}
+	*f = append(*f, dec)
+	return dec, nil
+}
+
+// h264Rect returns the payload of an H.264 rectangle.
+func h264Rect(flags uint32, data ...byte) []byte {
+	buf := binary.BigEndian.AppendUint32(nil, uint32(len(data))) // #nosec G115 - Test data
+	buf = binary.BigEndian.AppendUint32(buf, flags)
+	return append(buf, data...)
+}
+
+func TestH264Encoding_Contexts(t *testing.T) {
+	var decoders fakeVideoDecoders
+	enc := &H264Encoding{NewDecoder: decoders.new}
+	c := newTightConn(*PixelFormat32BitRGBA)
+	read := func(rect *Rectangle, payload []byte) *H264Encoding {
+		t.Helper()
+		got, err := enc.Read(c, rect, bytes.NewReader(payload))
+		if err != nil {
+			t.Fatalf("Read() error = %v", err)
+		}
+		return got.(*H264Encoding)
+	}
+
+	rect := &Rectangle{X: 8, Y: 4, Width: 20, Height: 10}
+	got := read(rect, h264Rect(0, 0x80))
+	if len(got.Colors) != 200 || got.Colors[199] != (Color{R: 0x80, G: 0x80, B: 0x80}) {
+		t.Fatalf("decoded %d colors ending in %+v, want 200 gray pixels", len(got.Colors), got.Colors[len(got.Colors)-1])
+	}
+	if got := read(rect, h264Rect(0, 0)); got.Colors != nil {
+		t.Error("data completing no frame produced colors")
+	}
+
+	// The same area continues its context; another area gets its own.
+	read(rect, h264Rect(0, 0x40))
+	read(&Rectangle{Width: 16, Height: 16}, h264Rect(0, 0x40))
+	if len(decoders) != 2 || decoders[0].frames != 2 || decoders[0].width != 20 || decoders[0].height != 10 {
+		t.Fatalf("decoders = %+v, want one per area", decoders)
+	}
+
+	read(rect, h264Rect(h264ResetContext, 0x40))
+	if !decoders[0].closed || decoders[1].closed || len(decoders) != 3 {
+		t.Fatal("resetting a context did not replace only its decoder")
+	}
+	read(rect, h264Rect(h264ResetAllContexts))
+	if !decoders[1].closed || !decoders[2].closed {
+		t.Fatal("resetting all contexts left decoders open")
+	}
+
+	// A decoding failure discards the context.
+	read(rect, h264Rect(0, 0x40))
+	if _, err := enc.Read(c, rect, bytes.NewReader(h264Rect(0, 0xff))); !IsVNCError(err, ErrEncoding) {
+		t.Fatalf("Read() of a corrupt stream = %v, want an encoding error", err)
+	}
+	if !decoders[3].closed {
+		t.Error("the context of a failed decode was kept")
+	}
+
+	read(rect, h264Rect(0, 0x40))
+	c.releaseDecoders()
+	if !decoders[len(decoders)-1].closed {
+		t.Error("releaseDecoders left a decoder open")
+	}
+}
+
+func TestH264Encoding_ContextLimit(t *testing.T) {
+	var decoders fakeVideoDecoders
+	enc := &H264Encoding{NewDecoder: decoders.new}
+	c := newTightConn(*PixelFormat32BitRGBA)
+
+	for i := range h264MaxContexts + 1 {
+		rect := &Rectangle{X: uint16(i), Width: 1, Height: 1} // #nosec G115 - small loop index
+		if _, err := enc.Read(c, rect, bytes.NewReader(h264Rect(0, 1))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !decoders[0].closed || decoders[1].closed {
+		t.Error("the oldest context was not closed at the limit")
+	}
+}
+
+func TestH264Encoding_Invalid(t *testing.T) {
+	var decoders fakeVideoDecoders
+	tests := map[string]struct {
+		enc     *H264Encoding
+		pf      *PixelFormat
+		payload []byte
+	}{
+		"NoDecoder":  {enc: &H264Encoding{}, pf: PixelFormat32BitRGBA, payload: h264Rect(0, 1)},
+		"ColorMap":   {enc: &H264Encoding{NewDecoder: decoders.new}, pf: PixelFormat8BitIndexed, payload: h264Rect(0, 1)},
+		"Truncated":  {enc: &H264Encoding{NewDecoder: decoders.new}, pf: PixelFormat32BitRGBA, payload: h264Rect(0, 1)[:8]},
+		"LongLength": {enc: &H264Encoding{NewDecoder: decoders.new}, pf: PixelFormat32BitRGBA, payload: []byte{0xff, 0xff, 0xff, 0xff, 0, 0, 0, 0}},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			c := newTightConn(*tt.pf)
+			if _, err := tt.enc.Read(c, &Rectangle{Width: 2, Height: 2}, bytes.NewReader(tt.payload)); err == nil {
+				t.Error("Read() accepted invalid data")
+			}
+		})
+	}
+
+	// Frames smaller than the rectangle are rejected.
+	small := func(int, int) (VideoDecoder, error) { return &fakeVideoDecoder{width: 1, height: 1}, nil }
+	c := newTightConn(*PixelFormat32BitRGBA)
+	if _, err := (&H264Encoding{NewDecoder: small}).Read(c, &Rectangle{Width: 32, Height: 32}, bytes.NewReader(h264Rect(0, 1))); err == nil {
+		t.Error("Read() accepted a frame smaller than the rectangle")
+	}
+}
+
+func TestH264Encoding_Paint(t *testing.T) {
+	var decoders fakeVideoDecoders
+	c := newTightConn(*PixelFormat32BitRGBA)
+	c.setFrameBufferSize(16, 16)
+	c.setEncodings([]Encoding{&H264Encoding{NewDecoder: decoders.new}, &RawEncoding{}})
+
+	// A FramebufferUpdate body with one 16x16 H.264 rectangle.
+	msg := binary.BigEndian.AppendUint16([]byte{0}, 1)
+	for _, v := range []uint16{0, 0, 16, 16} {
+		msg = binary.BigEndian.AppendUint16(msg, v)
+	}
+	msg = binary.BigEndian.AppendUint32(msg, 50)
+	msg = append(msg, h264Rect(0, 0x80)...)
+
+	update, err := (&FramebufferUpdateMessage{}).Read(c, bytes.NewReader(msg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rects := update.(*FramebufferUpdateMessage).Rectangles
+	if len(rects) != 1 {
+		t.Fatalf("decoded %d rectangles, want 1", len(rects))
+	}
+	fb := newFramebuffer(16, 16)
+	fb.pf = *PixelFormat32BitRGBA
+	rects[0].Enc.(rectPainter).paint(fb, &rects[0])
+	if got := fb.grid.rgbaAt(15, 15); got != (color.RGBA{R: 0x80, G: 0x80, B: 0x80, A: 0xff}) {
+		t.Errorf("pixel (15,15) = %v, want gray", got)
+	}
+}
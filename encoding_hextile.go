@@ -4,7 +4,6 @@
 package vnc
 
 import (
-	"encoding/binary"
 	"io"
 )
 
@@ -94,13 +93,16 @@ func (*HextileEncoding) Type() int32 {
 func (*HextileEncoding) Read(c *ClientConn, rect *Rectangle, r io.Reader) (Encoding, error) {
 	validator := newInputValidator()
 
-	if c.FrameBufferWidth > 0 && c.FrameBufferHeight > 0 {
+	fbWidth, fbHeight := c.GetFrameBufferSize()
+	if fbWidth > 0 && fbHeight > 0 {
 		if err := validator.ValidateRectangle(rect.X, rect.Y, rect.Width, rect.Height,
-			c.FrameBufferWidth, c.FrameBufferHeight); err != nil {
+			fbWidth, fbHeight); err != nil {
 			return nil, encodingError("HextileEncoding.Read", "invalid rectangle dimensions", err)
 		}
 	}
 
+	pixelReader := c.pixelReader()
+
 	tilesX := (rect.Width + HextileTileSize - 1) / HextileTileSize
 	tilesY := (rect.Height + HextileTileSize - 1) / HextileTileSize
 	totalTiles := int(tilesX * tilesY)
@@ -113,7 +115,12 @@ func (*HextileEncoding) Read(c *ClientConn, rect *Rectangle, r io.Reader) (Encod
 	tiles := make([]HextileTile, totalTiles)
 	tileIndex := 0
 
+	// Raw tiles are read in turn but expanded to colors in parallel.
+	var jobs pixelJobs
+	defer jobs.wg.Wait()
+
 	var background, foreground Color
+	var buf [2]byte
 
 	for tileY := uint16(0); tileY < tilesY; tileY++ {
 		for tileX := uint16(0); tileX < tilesX; tileX++ {
@@ -131,26 +138,30 @@ func (*HextileEncoding) Read(c *ClientConn, rect *Rectangle, r io.Reader) (Encod
 			tile.Width = tileWidth
 			tile.Height = tileHeight
 
-			var subencoding uint8
-			if err := binary.Read(r, binary.BigEndian, &subencoding); err != nil {
+			fields, err := readFields(r, "tile subencoding", buf[:1])
+			if err != nil {
 				return nil, encodingError("HextileEncoding.Read", "failed to read tile subencoding", err)
 			}
+			subencoding := fields.uint8()
 
 			if subencoding&HextileRaw != 0 {
 				pixelCount := int(tileWidth * tileHeight)
-				tile.Colors = make([]Color, pixelCount)
-
-				for i := 0; i < pixelCount; i++ {
-					color, err := readPixelColor(r, c.PixelFormat, c.ColorMap)
-					if err != nil {
-						return nil, encodingError("HextileEncoding.Read", "failed to read raw tile pixel", err)
-					}
-					tile.Colors[i] = color
+				data := make([]byte, pixelCount*pixelReader.BytesPerPixel())
+				if _, err := io.ReadFull(r, data); err != nil {
+					return nil, encodingError("HextileEncoding.Read", "failed to read raw tile pixels", err)
 				}
+				if pixelReader.probe != nil && !pixelReader.probe.done {
+					pixelReader.probe.observeData(data, pixelReader.byteOrder)
+				}
+
+				tile.Colors = make([]Color, pixelCount)
+				jobs.add(pixelCount, func() {
+					pixelReader.decodePixels(tile.Colors, data)
+				})
 			} else {
 				if subencoding&HextileBackgroundSpecified != 0 {
 					var err error
-					background, err = readPixelColor(r, c.PixelFormat, c.ColorMap)
+					background, err = pixelReader.ReadPixelColor(r)
 					if err != nil {
 						return nil, encodingError("HextileEncoding.Read", "failed to read background color", err)
 					}
@@ -159,7 +170,7 @@ func (*HextileEncoding) Read(c *ClientConn, rect *Rectangle, r io.Reader) (Encod
 
 				if subencoding&HextileForegroundSpecified != 0 {
 					var err error
-					foreground, err = readPixelColor(r, c.PixelFormat, c.ColorMap)
+					foreground, err = pixelReader.ReadPixelColor(r)
 					if err != nil {
 						return nil, encodingError("HextileEncoding.Read", "failed to read foreground color", err)
 					}
@@ -167,10 +178,11 @@ func (*HextileEncoding) Read(c *ClientConn, rect *Rectangle, r io.Reader) (Encod
 				tile.Foreground = foreground
 
 				if subencoding&HextileAnySubrects != 0 {
-					var numSubrects uint8
-					if err := binary.Read(r, binary.BigEndian, &numSubrects); err != nil {
+					fields, err := readFields(r, "subrectangle count", buf[:1])
+					if err != nil {
 						return nil, encodingError("HextileEncoding.Read", "failed to read subrectangle count", err)
 					}
+					numSubrects := fields.uint8()
 
 					if numSubrects > MaxSubrectsPerTile {
 						return nil, encodingError("HextileEncoding.Read", "too many subrectangles in tile", nil)
@@ -183,20 +195,18 @@ func (*HextileEncoding) Read(c *ClientConn, rect *Rectangle, r io.Reader) (Encod
 
 						if subencoding&HextileSubrectsColoured != 0 {
 							var err error
-							subrect.Color, err = readPixelColor(r, c.PixelFormat, c.ColorMap)
+							subrect.Color, err = pixelReader.ReadPixelColor(r)
 							if err != nil {
 								return nil, encodingError("HextileEncoding.Read", "failed to read subrectangle color", err)
 							}
 						} else {
 							subrect.Color = foreground
 						}
-						var xyData, whData uint8
-						if err := binary.Read(r, binary.BigEndian, &xyData); err != nil {
-							return nil, encodingError("HextileEncoding.Read", "failed to read subrectangle position", err)
-						}
-						if err := binary.Read(r, binary.BigEndian, &whData); err != nil {
-							return nil, encodingError("HextileEncoding.Read", "failed to read subrectangle dimensions", err)
+						fields, err := readFields(r, "subrectangle geometry", buf[:2])
+						if err != nil {
+							return nil, encodingError("HextileEncoding.Read", "failed to read subrectangle geometry", err)
 						}
+						xyData, whData := fields.uint8(), fields.uint8()
 
 						subrect.X = (xyData >> 4) & 0x0F
 						subrect.Y = xyData & 0x0F
@@ -220,5 +230,125 @@ func (*HextileEncoding) Read(c *ClientConn, rect *Rectangle, r io.Reader) (Encod
 		}
 	}
 
+	jobs.finish()
 	return &HextileEncoding{Tiles: tiles}, nil
 }
+
+// Encode returns the rectangle as Hextile data. Each tile is sent as a solid
+// background, as subrectangles over its most common color, or raw when that
+// is smaller, and repeats the background and foreground of the previous tile
+// when it can.
+func (*HextileEncoding) Encode(pf *PixelFormat, rect *Rectangle, pixels []Color) ([]byte, error) {
+	pw, err := newPixelWriter("HextileEncoding.Encode", pf, rect, pixels)
+	if err != nil {
+		return nil, err
+	}
+
+	width, height := int(rect.Width), int(rect.Height)
+	var (
+		buf              []byte
+		bg, fg           Color
+		bgValid, fgValid bool
+	)
+	for ty := 0; ty < height; ty += HextileTileSize {
+		for tx := 0; tx < width; tx += HextileTileSize {
+			tile := pixelArea{
+				pixels: pixels,
+				stride: width,
+				x:      tx,
+				y:      ty,
+				width:  min(HextileTileSize, width-tx),
+				height: min(HextileTileSize, height-ty),
+			}
+			tileBg := tile.background()
+			subs, ok := tile.subrects(tileBg, MaxSubrectsPerTile)
+
+			mask := uint8(0)
+			size := 1
+			if !bgValid || tileBg != bg {
+				mask |= HextileBackgroundSpecified
+				size += pw.size
+			}
+			coloured := false
+			if len(subs) > 0 {
+				mask |= HextileAnySubrects
+				size += 1 + 2*len(subs)
+				for _, sub := range subs[1:] {
+					coloured = coloured || sub.color != subs[0].color
+				}
+				switch {
+				case coloured:
+					mask |= HextileSubrectsColoured
+					size += pw.size * len(subs)
+				case !fgValid || subs[0].color != fg:
+					mask |= HextileForegroundSpecified
+					size += pw.size
+				}
+			}
+
+			if !ok || size > 1+tile.width*tile.height*pw.size {
+				// Raw tiles leave the background and foreground undefined.
+				buf = append(buf, HextileRaw)
+				for y := 0; y < tile.height; y++ {
+					for x := 0; x < tile.width; x++ {
+						buf = pw.append(buf, tile.at(x, y))
+					}
+				}
+				bgValid, fgValid = false, false
+				continue
+			}
+
+			buf = append(buf, mask)
+			if mask&HextileBackgroundSpecified != 0 {
+				buf = pw.append(buf, tileBg)
+			}
+			bg, bgValid = tileBg, true
+			if mask&HextileForegroundSpecified != 0 {
+				buf = pw.append(buf, subs[0].color)
+			}
+			if len(subs) == 0 {
+				continue
+			}
+			if coloured {
+				fgValid = false
+			} else {
+				fg, fgValid = subs[0].color, true
+			}
+
+			buf = append(buf, uint8(len(subs))) // #nosec G115 - At most MaxSubrectsPerTile
+			for _, sub := range subs {
+				if coloured {
+					buf = pw.append(buf, sub.color)
+				}
+				// #nosec G115 - Positions and sizes within a tile fit in 4 bits
+				buf = append(buf, uint8(sub.x<<4|sub.y), uint8((sub.width-1)<<4|(sub.height-1)))
+			}
+		}
+	}
+	return buf, nil
+}
+
+// paint renders each tile into the client framebuffer. Tiles are stored in
+// row-major order across the rectangle.
+func (e *HextileEncoding) paint(fb *framebuffer, rect *Rectangle) {
+	x, y := int(rect.X), int(rect.Y)
+	tilesX := (int(rect.Width) + HextileTileSize - 1) / HextileTileSize
+
+	for i, tile := range e.Tiles {
+		tx := x + (i%tilesX)*HextileTileSize
+		ty := y + (i/tilesX)*HextileTileSize
+
+		if tile.Colors != nil {
+			w := int(tile.Width)
+			for p, color := range tile.Colors {
+				fb.set(tx+p%w, ty+p/w, color)
+			}
+			continue
+		}
+
+		fb.fill(tx, ty, int(tile.Width), int(tile.Height), tile.Background)
+		for _, sub := range tile.Subrectangles {
+			fb.fill(tx+int(sub.X), ty+int(sub.Y), int(sub.Width), int(sub.Height), sub.Color)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package vnc
+
+import (
+	"io"
+
+	"github.com/tenthirtyam/go-vnc/rfb"
+)
+
+// LastRectPseudoEncoding represents the LastRect pseudo-encoding. Including it
+// in SetEncodings tells the server that the client accepts updates whose
+// rectangle count is unknown up front; the server announces 65535 rectangles
+// and ends the update with a LastRect rectangle. Tight-capable servers such as
+// TigerVNC and TurboVNC use it to stream rectangles as they are encoded.
+//
+// FramebufferUpdateMessage.Read always honors LastRect, so the rectangle never
+// appears in FramebufferUpdateMessage.Rectangles.
+type LastRectPseudoEncoding struct{}
+
+// Type returns the encoding type identifier for the LastRect pseudo-encoding.
+func (*LastRectPseudoEncoding) Type() int32 {
+	return rfb.PseudoEncodingLastRect
+}
+
+// IsPseudo returns true indicating this is a pseudo-encoding.
+func (*LastRectPseudoEncoding) IsPseudo() bool {
+	return true
+}
+
+// Read decodes the LastRect marker, which carries no payload.
+func (e *LastRectPseudoEncoding) Read(*ClientConn, *Rectangle, io.Reader) (Encoding, error) {
+	return e, nil
+}
+
+// Handle does nothing; the end of the update is handled while reading it.
+func (*LastRectPseudoEncoding) Handle(*ClientConn, *Rectangle) error {
+	return nil
+}
@@ -0,0 +1,55 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package vnc
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/tenthirtyam/go-vnc/rfb"
+)
+
+func TestLastRect_EndsUpdate(t *testing.T) {
+	s := &replayStream{}
+	s.write(uint8(0), uint8(0), rfb.LastRectUnknownCount)
+	s.rect(0, 0, 1, 1, 0).pixel(10, 20, 30)
+	s.rect(1, 0, 1, 1, 0).pixel(40, 50, 60)
+	s.rect(0, 0, 0, 0, rfb.PseudoEncodingLastRect)
+	s.write(uint8(2)) // Bell, read only if the update ended at LastRect
+
+	tc := replayCase{
+		handshake:    replayHandshake(2, 1, "lastrect"),
+		messages:     s.bytes(),
+		encodings:    []Encoding{&RawEncoding{}, &LastRectPseudoEncoding{}},
+		wantMessages: []string{"update", "bell"},
+	}
+	_, msgs := runReplay(t, tc)
+
+	update, ok := msgs[0].(*FramebufferUpdateMessage)
+	if !ok {
+		t.Fatalf("first message = %T, want *FramebufferUpdateMessage", msgs[0])
+	}
+	if len(update.Rectangles) != 2 {
+		t.Errorf("update has %d rectangles, want 2", len(update.Rectangles))
+	}
+	if _, ok := msgs[1].(*BellMessage); !ok {
+		t.Errorf("second message = %T, want *BellMessage", msgs[1])
+	}
+}
+
+func TestLastRect_UnterminatedUpdate(t *testing.T) {
+	s := &replayStream{}
+	s.write(uint8(0), uint8(0), rfb.LastRectUnknownCount)
+	for range MaxRectanglesPerUpdate + 1 {
+		s.rect(0, 0, 1, 1, 0).pixel(0, 0, 0)
+	}
+
+	c := &ClientConn{logger: &NoOpLogger{}}
+	c.setPixelFormat(replayPixelFormat)
+	c.setFrameBufferSize(1, 1)
+	_, err := (&FramebufferUpdateMessage{}).Read(c, bytes.NewReader(s.bytes()[1:]))
+	if !IsVNCError(err, ErrProtocol) {
+		t.Errorf("Read() error = %v, want a protocol error", err)
+	}
+}
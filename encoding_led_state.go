@@ -0,0 +1,122 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package vnc
+
+import (
+	"context"
+	"io"
+	"strings"
+
+	"github.com/tenthirtyam/go-vnc/rfb"
+)
+
+// LEDState is the state of the lock key LEDs of the remote keyboard.
+type LEDState uint8
+
+// Lock key LEDs, as reported by the LED State pseudo-encoding.
+const (
+	LEDScrollLock LEDState = 1 << iota
+	LEDNumLock
+	LEDCapsLock
+)
+
+// lockKeys maps each LED to the keysym of the key that toggles it.
+var lockKeys = []struct {
+	led    LEDState
+	keysym uint32
+}{
+	{LEDCapsLock, 0xffe5},   // Caps_Lock
+	{LEDNumLock, 0xff7f},    // Num_Lock
+	{LEDScrollLock, 0xff14}, // Scroll_Lock
+}
+
+// String returns the names of the lit LEDs, such as "caps|num", or "none".
+func (s LEDState) String() string {
+	var names []string
+	for _, led := range []struct {
+		led  LEDState
+		name string
+	}{{LEDCapsLock, "caps"}, {LEDNumLock, "num"}, {LEDScrollLock, "scroll"}} {
+		if s&led.led != 0 {
+			names = append(names, led.name)
+		}
+	}
+	if len(names) == 0 {
+		return "none"
+	}
+	return strings.Join(names, "|")
+}
+
+// LEDStatePseudoEncoding represents the LED State pseudo-encoding of QEMU
+// and TigerVNC, with which the server reports the state of the Caps Lock,
+// Num Lock, and Scroll Lock LEDs of the remote keyboard whenever it changes.
+//
+// Once handled, the state is available from ClientConn.LEDState.
+type LEDStatePseudoEncoding struct {
+	// State is the reported LED state.
+	State LEDState
+}
+
+// Type returns the encoding type identifier for the LED State
+// pseudo-encoding.
+func (*LEDStatePseudoEncoding) Type() int32 {
+	return rfb.PseudoEncodingLEDState
+}
+
+// IsPseudo returns true indicating this is a pseudo-encoding.
+func (*LEDStatePseudoEncoding) IsPseudo() bool {
+	return true
+}
+
+// Read decodes the LED state, a single byte.
+func (*LEDStatePseudoEncoding) Read(_ *ClientConn, _ *Rectangle, r io.Reader) (Encoding, error) {
+	var state [1]uint8
+	if _, err := io.ReadFull(r, state[:]); err != nil {
+		return nil, encodingError("LEDStatePseudoEncoding.Read", "failed to read LED state", err)
+	}
+	return &LEDStatePseudoEncoding{State: LEDState(state[0]) & (LEDScrollLock | LEDNumLock | LEDCapsLock)}, nil
+}
+
+// Handle records the LED state for ClientConn.LEDState.
+func (e *LEDStatePseudoEncoding) Handle(c *ClientConn, _ *Rectangle) error {
+	c.setLEDState(e.State)
+
+	c.logger.Debug("LED state changed",
+		Field{Key: "leds", Value: e.State.String()})
+	return nil
+}
+
+// SetLockKeys taps the lock keys whose LEDs differ from want, so that text
+// typed next is not inverted by a Caps Lock left on at the remote desktop.
+// It fails if the server has not reported its LED state, which requires
+// LEDStatePseudoEncoding in SetEncodings. The reported state changes once
+// the server has processed the keys.
+//
+// Example usage:
+//
+//	if err := client.SetLockKeys(ctx, vnc.LEDNumLock); err != nil {
+//		return err
+//	}
+//	err := client.SendKeys(ctx, "h", "i")
+func (c *ClientConn) SetLockKeys(ctx context.Context, want LEDState) error {
+	state, ok := c.LEDState()
+	if !ok {
+		return c.enrichError(unsupportedError("SetLockKeys", "server has not reported its LED state", nil))
+	}
+
+	rtt, _ := c.RoundTripTime()
+	delay := c.gestureTiming().Delay(rtt)
+	for _, key := range lockKeys {
+		if (state^want)&key.led == 0 {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := c.pressKeys(ctx, []uint32{key.keysym}, delay); err != nil {
+			return err
+		}
+	}
+	return nil
+}
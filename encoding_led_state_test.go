@@ -0,0 +1,60 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package vnc
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/tenthirtyam/go-vnc/rfb"
+)
+
+func TestLEDState_Update(t *testing.T) {
+	s := &replayStream{}
+	s.write(uint8(0), uint8(0), uint16(1))
+	s.rect(0, 0, 0, 0, rfb.PseudoEncodingLEDState)
+	s.write(uint8(0b101))
+
+	c, msgs := runReplay(t, replayCase{
+		handshake:    replayHandshake(4, 4, "leds"),
+		messages:     s.bytes(),
+		encodings:    []Encoding{&LEDStatePseudoEncoding{}, &RawEncoding{}},
+		wantMessages: []string{"leds"},
+	})
+
+	enc, ok := msgs[0].(*FramebufferUpdateMessage).Rectangles[0].Enc.(*LEDStatePseudoEncoding)
+	if !ok || enc.State != LEDCapsLock|LEDScrollLock {
+		t.Fatalf("update rectangle = %+v, want the LED state", msgs[0])
+	}
+	state, known := c.LEDState()
+	if !known || state != LEDCapsLock|LEDScrollLock {
+		t.Errorf("LEDState() = %v, %t, want caps|scroll, true", state, known)
+	}
+	if got := state.String(); got != "caps|scroll" {
+		t.Errorf("String() = %q, want %q", got, "caps|scroll")
+	}
+}
+
+func TestSetLockKeys(t *testing.T) {
+	srv, conn := newUpdateServer(t, 16, 16)
+
+	if err := conn.SetLockKeys(context.Background(), 0); !IsVNCError(err, ErrUnsupported) {
+		t.Fatalf("SetLockKeys before any report = %v, want an unsupported error", err)
+	}
+
+	conn.setLEDState(LEDCapsLock)
+	if err := conn.SetLockKeys(context.Background(), LEDNumLock); err != nil {
+		t.Fatal(err)
+	}
+	want := []rfb.KeyEvent{
+		{Down: true, Key: 0xffe5},
+		{Down: false, Key: 0xffe5},
+		{Down: true, Key: 0xff7f},
+		{Down: false, Key: 0xff7f},
+	}
+	if got := receiveKeys(t, srv, len(want)); !reflect.DeepEqual(got, want) {
+		t.Errorf("key events = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,105 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package vnc
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/tenthirtyam/go-vnc/rfb"
+)
+
+// QEMUExtendedKeyEventPseudoEncoding represents the QEMU Extended Key Event
+// pseudo-encoding. Including it in SetEncodings asks the server to accept key
+// events carrying the scancode of the key as well as its keysym, which QEMU and
+// KVM consoles need to deliver keys independently of the guest keyboard layout.
+// The server confirms support by sending an empty rectangle with this encoding.
+type QEMUExtendedKeyEventPseudoEncoding struct{}
+
+// Type returns the encoding type identifier for QEMU Extended Key Event pseudo-encoding.
+func (*QEMUExtendedKeyEventPseudoEncoding) Type() int32 {
+	return rfb.PseudoEncodingQEMUExtendedKeyEvent
+}
+
+// IsPseudo returns true indicating this is a pseudo-encoding.
+func (*QEMUExtendedKeyEventPseudoEncoding) IsPseudo() bool {
+	return true
+}
+
+// Read decodes the QEMU Extended Key Event confirmation, which carries no payload.
+func (e *QEMUExtendedKeyEventPseudoEncoding) Read(*ClientConn, *Rectangle, io.Reader) (Encoding, error) {
+	return e, nil
+}
+
+// Handle records that the server accepts extended key events, enabling
+// ExtendedKeyEvent.
+func (*QEMUExtendedKeyEventPseudoEncoding) Handle(c *ClientConn, _ *Rectangle) error {
+	if !c.qemuExtendedKeyEvents.Swap(true) {
+		c.logger.Info("Server confirmed QEMU extended key events")
+	}
+	return nil
+}
+
+// QEMUExtendedKeyEvents reports whether the server has confirmed the QEMU
+// Extended Key Event pseudo-encoding for this connection.
+func (c *ClientConn) QEMUExtendedKeyEvents() bool {
+	return c.qemuExtendedKeyEvents.Load()
+}
+
+// ExtendedKeyEvent presses or releases a key identified by both its X11 keysym
+// and its XT scancode, using the QEMU extended key event message. The server
+// injects the scancode, so the guest interprets the key with its own keyboard
+// layout; the keysym serves servers that cannot use the scancode.
+//
+// Scancodes of extended keys, which keyboards send with an 0xe0 prefix, are
+// given with the high bit of the low byte set: Right Ctrl (0xe0 0x1d) is 0x9d
+// and Left Windows (0xe0 0x5b) is 0xdb. A keysym of 0 leaves the key to the
+// scancode alone.
+//
+// The server must have confirmed QEMUExtendedKeyEventPseudoEncoding, which
+// QEMUExtendedKeyEvents reports; until then ExtendedKeyEvent returns an
+// unsupported error, as servers without the extension close the connection on
+// receiving the message.
+//
+// Example usage:
+//
+//	client.SetEncodings([]Encoding{&RawEncoding{}, &QEMUExtendedKeyEventPseudoEncoding{}})
+//	...
+//	if client.QEMUExtendedKeyEvents() {
+//		// Press and release the key left of 1, whatever the guest layout.
+//		_ = client.ExtendedKeyEvent(0x60, 0x29, true)
+//		_ = client.ExtendedKeyEvent(0x60, 0x29, false)
+//	}
+func (c *ClientConn) ExtendedKeyEvent(keysym, keycode uint32, down bool) error {
+	if !c.qemuExtendedKeyEvents.Load() {
+		return c.enrichError(unsupportedError("ExtendedKeyEvent",
+			"the server has not confirmed the QEMU Extended Key Event pseudo-encoding", nil))
+	}
+	if keysym != 0 {
+		if err := newInputValidator().ValidateKeySymbol(keysym); err != nil {
+			c.logger.Error("Invalid keysym value",
+				Field{Key: "keysym", Value: keysym},
+				Field{Key: "error", Value: err})
+			return c.enrichError(validationError("ExtendedKeyEvent", "invalid keysym value", err))
+		}
+	}
+
+	c.logger.Debug("Sending extended key event",
+		Field{Key: "keysym", Value: keysym},
+		Field{Key: "keycode", Value: keycode},
+		Field{Key: "down", Value: down})
+
+	var buf bytes.Buffer
+	if err := rfb.WriteExtendedKeyEvent(&buf, rfb.ExtendedKeyEvent{Down: down, Keysym: keysym, Keycode: keycode}); err != nil {
+		c.logger.Error("Failed to encode extended key event", Field{Key: "error", Value: err})
+		return c.enrichError(encodingError("ExtendedKeyEvent", "failed to encode extended key event", err))
+	}
+
+	if err := c.writeWithContext(c.ctx, buf.Bytes()); err != nil {
+		c.logger.Error("Failed to send extended key event", Field{Key: "error", Value: err})
+		return c.enrichError(networkError("ExtendedKeyEvent", "failed to send extended key event", err))
+	}
+
+	return nil
+}
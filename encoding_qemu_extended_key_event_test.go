@@ -0,0 +1,61 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package vnc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/tenthirtyam/go-vnc/rfb"
+)
+
+func TestQEMUExtendedKeyEvent_Confirm(t *testing.T) {
+	s := &replayStream{}
+	s.write(uint8(0), uint8(0), uint16(1))
+	s.rect(0, 0, 0, 0, rfb.PseudoEncodingQEMUExtendedKeyEvent)
+
+	c, _ := runReplay(t, replayCase{
+		handshake:    replayHandshake(4, 4, "qemu"),
+		messages:     s.bytes(),
+		encodings:    []Encoding{&QEMUExtendedKeyEventPseudoEncoding{}, &RawEncoding{}},
+		wantMessages: []string{"confirmation"},
+	})
+
+	if !c.QEMUExtendedKeyEvents() {
+		t.Error("QEMUExtendedKeyEvents() = false after server confirmation")
+	}
+}
+
+func TestExtendedKeyEvent(t *testing.T) {
+	srv, conn := newUpdateServer(t, 16, 16)
+
+	if err := conn.ExtendedKeyEvent(0xffe4, 0x9d, true); !IsVNCError(err, ErrUnsupported) {
+		t.Fatalf("ExtendedKeyEvent before confirmation = %v, want an unsupported error", err)
+	}
+
+	conn.qemuExtendedKeyEvents.Store(true)
+	if err := conn.ExtendedKeyEvent(0x1ffffff+1, 0x1e, true); !IsVNCError(err, ErrValidation) {
+		t.Fatalf("ExtendedKeyEvent with an invalid keysym = %v, want a validation error", err)
+	}
+	if err := conn.ExtendedKeyEvent(0xffe4, 0x9d, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.ExtendedKeyEvent(0, 0x9d, false); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []rfb.ExtendedKeyEvent{
+		{Down: true, Keysym: 0xffe4, Keycode: 0x9d},
+		{Keycode: 0x9d},
+	} {
+		select {
+		case got := <-srv.qemuKeys:
+			if got != want {
+				t.Errorf("extended key event = %+v, want %+v", got, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for an extended key event")
+		}
+	}
+}
@@ -4,6 +4,7 @@
 package vnc
 
 import (
+	"encoding/binary"
 	"io"
 )
 
@@ -11,6 +12,27 @@ import (
 type RawEncoding struct {
 	// Colors contains the decoded pixel data for the rectangle.
 	Colors []Color
+
+	// Packed, set on the RawEncoding passed to SetEncodings, keeps the pixels
+	// of decoded rectangles as the server sent them, in Pixels, instead of
+	// converting them to Colors, which take six bytes per pixel. It suits
+	// screenshots and recordings of large desktops, which the client
+	// framebuffer and ConvertRectToRGBA still render from the packed pixels:
+	//
+	//	client.SetEncodings([]vnc.Encoding{&vnc.RawEncoding{Packed: true}})
+	Packed bool
+
+	// Pixels contains the pixels of a rectangle decoded with Packed, row by
+	// row, in PixelFormat. Color map formats also need the color map of the
+	// connection, as returned by GetColorMap, to be interpreted.
+	Pixels []byte
+
+	// PixelFormat is the format of Pixels. Its byte order is the one the
+	// pixels were decoded in, which WithForcePixelEndianness may override.
+	PixelFormat PixelFormat
+
+	// colorMap is the color map of the connection when Pixels were read.
+	colorMap *[ColorMapSize]Color
 }
 
 // Type returns the encoding type identifier for Raw encoding.
@@ -82,8 +104,13 @@ func (*RawEncoding) Type() int32 {
 // - Insufficient pixel data is available in the reader
 // - I/O errors occur while reading pixel data
 // - Invalid pixel format parameters are encountered.
-func (*RawEncoding) Read(c *ClientConn, rect *Rectangle, r io.Reader) (Encoding, error) {
-	pixelReader := NewPixelReader(c.PixelFormat, c.ColorMap)
+//
+// When e.Packed is set, the pixels are kept in Pixels without conversion.
+func (e *RawEncoding) Read(c *ClientConn, rect *Rectangle, r io.Reader) (Encoding, error) {
+	pixelReader := c.pixelReader()
+	if e.Packed {
+		return readPackedRaw(pixelReader, rect, r)
+	}
 	colors := make([]Color, int(rect.Height)*int(rect.Width))
 
 	for y := uint16(0); y < rect.Height; y++ {
@@ -96,5 +123,59 @@ func (*RawEncoding) Read(c *ClientConn, rect *Rectangle, r io.Reader) (Encoding,
 		}
 	}
 
-	return &RawEncoding{colors}, nil
+	return &RawEncoding{Colors: colors}, nil
+}
+
+// readPackedRaw reads the pixels of a rectangle without converting them.
+func readPackedRaw(pixelReader *PixelReader, rect *Rectangle, r io.Reader) (Encoding, error) {
+	pixels := make([]byte, int(rect.Width)*int(rect.Height)*pixelReader.BytesPerPixel())
+	if _, err := io.ReadFull(r, pixels); err != nil {
+		return nil, encodingError("RawEncoding.Read", "failed to read pixel data", err)
+	}
+	if pixelReader.probe != nil && !pixelReader.probe.done {
+		pixelReader.probe.observeData(pixels, pixelReader.byteOrder)
+	}
+
+	pf := pixelReader.pixelFormat
+	pf.BigEndian = pixelReader.byteOrder == binary.BigEndian
+	return &RawEncoding{Packed: true, Pixels: pixels, PixelFormat: pf, colorMap: pixelReader.colorMap}, nil
+}
+
+// Encode returns the pixels of the rectangle as Raw data.
+func (*RawEncoding) Encode(pf *PixelFormat, rect *Rectangle, pixels []Color) ([]byte, error) {
+	pw, err := newPixelWriter("RawEncoding.Encode", pf, rect, pixels)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, 0, len(pixels)*pw.size)
+	for _, c := range pixels {
+		buf = pw.append(buf, c)
+	}
+	return buf, nil
+}
+
+// paint renders the raw pixels into the client framebuffer, converting
+// packed pixels a row at a time.
+func (e *RawEncoding) paint(fb *framebuffer, rect *Rectangle) {
+	if !e.Packed {
+		fb.setColors(rect, e.Colors)
+		return
+	}
+
+	colorMap := e.colorMap
+	if colorMap == nil {
+		colorMap = new([ColorMapSize]Color)
+	}
+	pr := newPixelReader(e.PixelFormat, colorMap)
+	stride := int(rect.Width) * pr.BytesPerPixel()
+	if stride == 0 {
+		return
+	}
+	row := make([]Color, rect.Width)
+	for y := 0; y < int(rect.Height) && (y+1)*stride <= len(e.Pixels); y++ {
+		pr.decodePixels(row, e.Pixels[y*stride:])
+		// #nosec G115 - y is below rect.Height
+		fb.setColors(&Rectangle{X: rect.X, Y: rect.Y + uint16(y), Width: rect.Width, Height: 1}, row)
+	}
 }
@@ -0,0 +1,236 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package vnc
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+)
+
+// EncodingFactory is a function type that creates new instances of an
+// encoding.
+type EncodingFactory func() Encoding
+
+// EncodingRegistry manages custom encodings, such as vendor extensions the
+// package does not implement. Clients configured with WithEncodingRegistry
+// advertise every registered encoding in SetEncodings, ahead of the encodings
+// passed to it, and decode rectangles of a registered type with the encoding
+// the factory creates:
+//
+//	registry := vnc.NewEncodingRegistry()
+//	registry.Register(myEncodingType, func() vnc.Encoding { return &MyEncoding{} })
+//	client, err := vnc.ClientWithOptions(ctx, conn, vnc.WithEncodingRegistry(registry))
+//
+// Rectangles of custom encodings reach the application in
+// FramebufferUpdateMessage.Rectangles; the client framebuffer only paints the
+// encodings of the package.
+type EncodingRegistry struct {
+	factories map[int32]EncodingFactory
+
+	// order lists the registered types in registration order, which is the
+	// order they are advertised in.
+	order  []int32
+	mu     sync.RWMutex
+	logger Logger
+}
+
+// NewEncodingRegistry creates an empty encoding registry. The encodings of the
+// package need no registration.
+func NewEncodingRegistry() *EncodingRegistry {
+	return &EncodingRegistry{
+		factories: make(map[int32]EncodingFactory),
+		logger:    &NoOpLogger{},
+	}
+}
+
+// Register adds an encoding factory to the registry, replacing any factory
+// registered for the same type. The encodings it creates must report
+// encodingType from Type.
+func (r *EncodingRegistry) Register(encodingType int32, factory EncodingFactory) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.logger != nil {
+		r.logger.Debug("Registering encoding",
+			Field{Key: "encoding_type", Value: encodingType})
+	}
+
+	if _, exists := r.factories[encodingType]; !exists {
+		r.order = append(r.order, encodingType)
+	}
+	r.factories[encodingType] = factory
+}
+
+// Unregister removes an encoding from the registry.
+func (r *EncodingRegistry) Unregister(encodingType int32) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.factories[encodingType]; !exists {
+		return false
+	}
+
+	delete(r.factories, encodingType)
+	r.order = slices.DeleteFunc(r.order, func(t int32) bool { return t == encodingType })
+
+	if r.logger != nil {
+		r.logger.Debug("Unregistered encoding",
+			Field{Key: "encoding_type", Value: encodingType})
+	}
+
+	return true
+}
+
+// CreateEncoding creates a new instance of the encoding for the given type.
+func (r *EncodingRegistry) CreateEncoding(encodingType int32) (Encoding, error) {
+	r.mu.RLock()
+	factory, exists := r.factories[encodingType]
+	r.mu.RUnlock()
+
+	if !exists {
+		return nil, unsupportedError("EncodingRegistry.CreateEncoding",
+			fmt.Sprintf("unsupported encoding type: %d", encodingType), nil)
+	}
+
+	enc := factory()
+	if enc == nil || enc.Type() != encodingType {
+		return nil, configurationError("EncodingRegistry.CreateEncoding",
+			fmt.Sprintf("factory for encoding type %d created a different encoding", encodingType), nil)
+	}
+
+	return enc, nil
+}
+
+// GetSupportedTypes returns the registered encoding types in registration
+// order.
+func (r *EncodingRegistry) GetSupportedTypes() []int32 {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return slices.Clone(r.order)
+}
+
+// IsSupported checks if an encoding type is registered.
+func (r *EncodingRegistry) IsSupported(encodingType int32) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	_, exists := r.factories[encodingType]
+	return exists
+}
+
+// SetLogger sets the logger for the encoding registry.
+func (r *EncodingRegistry) SetLogger(logger Logger) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.logger = logger
+}
+
+// PseudoEncodingHandler handles a rectangle of a pseudo-encoding registered
+// with EncodingRegistry.RegisterPseudoEncoding. rect holds the rectangle
+// header, whose position and size mean whatever the extension defines, and r
+// the data that follows it. The handler must read exactly the data of the
+// rectangle, since RFB does not frame it; an error ends the connection, as
+// the rest of the update can no longer be parsed.
+//
+// Handlers run on the goroutine that reads server messages, so they must not
+// wait for messages to be delivered.
+type PseudoEncodingHandler func(c *ClientConn, encodingType int32, rect *Rectangle, r io.Reader) error
+
+// RegisterPseudoEncoding registers handler for the rectangles of a
+// pseudo-encoding, such as a vendor extension, without implementing
+// Encoding:
+//
+//	registry.RegisterPseudoEncoding(-1000, func(c *vnc.ClientConn, _ int32, rect *vnc.Rectangle, r io.Reader) error {
+//		var state [4]byte
+//		_, err := io.ReadFull(r, state[:])
+//		return err
+//	})
+//
+// Like other registered encodings, it is advertised in SetEncodings, so
+// servers that support the extension send its rectangles instead of ones the
+// client would fail on. The rectangles reach the application as
+// CustomPseudoEncoding rectangles. It returns a validation error unless
+// encodingType is negative, the range RFB reserves for pseudo-encodings.
+func (r *EncodingRegistry) RegisterPseudoEncoding(encodingType int32, handler PseudoEncodingHandler) error {
+	if encodingType >= 0 {
+		return validationError("EncodingRegistry.RegisterPseudoEncoding",
+			fmt.Sprintf("encoding type %d is not a pseudo-encoding", encodingType), nil)
+	}
+	if handler == nil {
+		return validationError("EncodingRegistry.RegisterPseudoEncoding", "handler is required", nil)
+	}
+	r.Register(encodingType, func() Encoding {
+		return &CustomPseudoEncoding{EncodingType: encodingType, handler: handler}
+	})
+	return nil
+}
+
+// CustomPseudoEncoding is a pseudo-encoding registered with
+// EncodingRegistry.RegisterPseudoEncoding. Its rectangles carry no data of
+// their own; the handler has consumed it.
+type CustomPseudoEncoding struct {
+	// EncodingType is the registered pseudo-encoding type.
+	EncodingType int32
+
+	handler PseudoEncodingHandler
+}
+
+// Type returns the registered pseudo-encoding type.
+func (e *CustomPseudoEncoding) Type() int32 {
+	return e.EncodingType
+}
+
+// IsPseudo returns true indicating this is a pseudo-encoding.
+func (*CustomPseudoEncoding) IsPseudo() bool {
+	return true
+}
+
+// Read passes the rectangle to the registered handler.
+func (e *CustomPseudoEncoding) Read(c *ClientConn, rect *Rectangle, r io.Reader) (Encoding, error) {
+	if e.handler == nil {
+		return nil, configurationError("CustomPseudoEncoding.Read",
+			fmt.Sprintf("no handler registered for pseudo-encoding %d", e.EncodingType), nil)
+	}
+	if err := e.handler(c, e.EncodingType, rect, r); err != nil {
+		return nil, err
+	}
+	return &CustomPseudoEncoding{EncodingType: e.EncodingType, handler: e.handler}, nil
+}
+
+// Handle does nothing; the handler ran as the rectangle was read.
+func (*CustomPseudoEncoding) Handle(*ClientConn, *Rectangle) error {
+	return nil
+}
+
+// withRegisteredEncodings returns encs preceded by an instance of each
+// registered encoding that encs does not already include.
+func (r *EncodingRegistry) withRegisteredEncodings(encs []Encoding) ([]Encoding, error) {
+	types := r.GetSupportedTypes()
+	merged := make([]Encoding, 0, len(types)+len(encs))
+	for _, encodingType := range types {
+		if slices.ContainsFunc(encs, func(enc Encoding) bool { return enc.Type() == encodingType }) {
+			continue
+		}
+		enc, err := r.CreateEncoding(encodingType)
+		if err != nil {
+			return nil, err
+		}
+		merged = append(merged, enc)
+	}
+	return append(merged, encs...), nil
+}
+
+// registeredEncoding returns a new instance of the registered encoding for
+// encodingType, if the client has a registry that includes it.
+func (c *ClientConn) registeredEncoding(encodingType int32) (Encoding, bool) {
+	if c.config == nil || c.config.EncodingRegistry == nil {
+		return nil, false
+	}
+	enc, err := c.config.EncodingRegistry.CreateEncoding(encodingType)
+	return enc, err == nil
+}
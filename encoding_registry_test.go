@@ -0,0 +1,143 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package vnc
+
+import (
+	"io"
+	"slices"
+	"testing"
+)
+
+const testCustomEncodingType int32 = 1000
+
+// testCustomEncoding is an application encoding carrying one byte per
+// rectangle.
+type testCustomEncoding struct {
+	Value byte
+}
+
+func (*testCustomEncoding) Type() int32 {
+	return testCustomEncodingType
+}
+
+func (*testCustomEncoding) Read(_ *ClientConn, _ *Rectangle, r io.Reader) (Encoding, error) {
+	var buf [1]byte
+	if _, err := io.ReadFull(r, buf[:]); err != nil {
+		return nil, err
+	}
+	return &testCustomEncoding{Value: buf[0]}, nil
+}
+
+func TestEncodingRegistry_Register(t *testing.T) {
+	registry := NewEncodingRegistry()
+	registry.Register(testCustomEncodingType, func() Encoding { return &testCustomEncoding{} })
+	registry.Register(-1000, func() Encoding { return &testCustomEncoding{} })
+
+	if got := registry.GetSupportedTypes(); !slices.Equal(got, []int32{testCustomEncodingType, -1000}) {
+		t.Errorf("GetSupportedTypes() = %v, want registration order", got)
+	}
+	if _, err := registry.CreateEncoding(testCustomEncodingType); err != nil {
+		t.Errorf("CreateEncoding() error = %v", err)
+	}
+	if _, err := registry.CreateEncoding(-1000); !IsVNCError(err, ErrConfiguration) {
+		t.Errorf("CreateEncoding() of a mismatched factory error = %v, want a configuration error", err)
+	}
+
+	if !registry.Unregister(-1000) || registry.Unregister(-1000) {
+		t.Error("Unregister() should report removing the encoding once")
+	}
+	if registry.IsSupported(-1000) {
+		t.Error("IsSupported() = true after Unregister")
+	}
+	if _, err := registry.CreateEncoding(-1000); !IsVNCError(err, ErrUnsupported) {
+		t.Errorf("CreateEncoding() of an unregistered type error = %v, want an unsupported error", err)
+	}
+}
+
+func TestEncodingRegistry_AdvertiseAndDecode(t *testing.T) {
+	registry := NewEncodingRegistry()
+	registry.Register(testCustomEncodingType, func() Encoding { return &testCustomEncoding{} })
+
+	s := &replayStream{}
+	s.write(uint8(0), uint8(0), uint16(2))
+	s.rect(0, 0, 1, 1, testCustomEncodingType).write(uint8(42))
+	s.rect(1, 0, 1, 1, 0).pixel(10, 20, 30)
+
+	tc := replayCase{
+		handshake:    replayHandshake(2, 1, "custom"),
+		messages:     s.bytes(),
+		encodings:    []Encoding{&RawEncoding{}},
+		wantMessages: []string{"update"},
+	}
+	conn, msgs := runReplay(t, tc, WithEncodingRegistry(registry))
+
+	encs := conn.GetEncodings()
+	if len(encs) != 2 || encs[0].Type() != testCustomEncodingType || encs[1].Type() != 0 {
+		t.Errorf("GetEncodings() = %v, want the registered encoding ahead of Raw", encs)
+	}
+
+	update := msgs[0].(*FramebufferUpdateMessage)
+	custom, ok := update.Rectangles[0].Enc.(*testCustomEncoding)
+	if !ok || custom.Value != 42 {
+		t.Errorf("first rectangle = %#v, want the custom encoding with value 42", update.Rectangles[0].Enc)
+	}
+	if _, ok := update.Rectangles[1].Enc.(*RawEncoding); !ok {
+		t.Errorf("second rectangle = %T, want *RawEncoding", update.Rectangles[1].Enc)
+	}
+}
+
+func TestEncodingRegistry_RegisterPseudoEncoding(t *testing.T) {
+	const vendorType int32 = -1000
+	registry := NewEncodingRegistry()
+	if err := registry.RegisterPseudoEncoding(5, func(*ClientConn, int32, *Rectangle, io.Reader) error { return nil }); !IsVNCError(err, ErrValidation) {
+		t.Errorf("RegisterPseudoEncoding() of a non-negative type error = %v, want a validation error", err)
+	}
+
+	var got []Rectangle
+	var payloads [][]byte
+	err := registry.RegisterPseudoEncoding(vendorType, func(_ *ClientConn, encodingType int32, rect *Rectangle, r io.Reader) error {
+		if encodingType != vendorType {
+			t.Errorf("handler encoding type = %d, want %d", encodingType, vendorType)
+		}
+		payload := make([]byte, rect.Width)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return err
+		}
+		got = append(got, *rect)
+		payloads = append(payloads, payload)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("RegisterPseudoEncoding() error = %v", err)
+	}
+
+	// The vendor extension sends Width bytes, at a position outside the
+	// desktop that must not be validated as pixels.
+	s := &replayStream{}
+	s.write(uint8(0), uint8(0), uint16(2))
+	s.rect(7, 9, 3, 0, vendorType).write(uint8(1), uint8(2), uint8(3))
+	s.rect(1, 0, 1, 1, 0).pixel(10, 20, 30)
+
+	tc := replayCase{
+		handshake:    replayHandshake(2, 1, "vendor"),
+		messages:     s.bytes(),
+		encodings:    []Encoding{&RawEncoding{}},
+		wantMessages: []string{"update"},
+	}
+	conn, msgs := runReplay(t, tc, WithEncodingRegistry(registry))
+
+	if encs := conn.GetEncodings(); len(encs) != 2 || encs[0].Type() != vendorType {
+		t.Errorf("GetEncodings() = %v, want the pseudo-encoding advertised", encs)
+	}
+	if len(got) != 1 || got[0].X != 7 || got[0].Y != 9 || got[0].Width != 3 || !slices.Equal(payloads[0], []byte{1, 2, 3}) {
+		t.Errorf("handler got %v with %v, want the rectangle header and its 3 bytes", got, payloads)
+	}
+	update := msgs[0].(*FramebufferUpdateMessage)
+	if enc, ok := update.Rectangles[0].Enc.(*CustomPseudoEncoding); !ok || enc.Type() != vendorType {
+		t.Errorf("first rectangle = %#v, want a CustomPseudoEncoding", update.Rectangles[0].Enc)
+	}
+	if _, ok := update.Rectangles[1].Enc.(*RawEncoding); !ok {
+		t.Errorf("second rectangle = %T, want *RawEncoding", update.Rectangles[1].Enc)
+	}
+}
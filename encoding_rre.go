@@ -105,18 +105,21 @@ func (*RREEncoding) Read(c *ClientConn, rect *Rectangle, r io.Reader) (Encoding,
 	validator := newInputValidator()
 
 	// Validate rectangle dimensions first (skip validation if framebuffer dimensions are zero, likely test scenario)
-	if c.FrameBufferWidth > 0 && c.FrameBufferHeight > 0 {
+	fbWidth, fbHeight := c.GetFrameBufferSize()
+	if fbWidth > 0 && fbHeight > 0 {
 		if err := validator.ValidateRectangle(rect.X, rect.Y, rect.Width, rect.Height,
-			c.FrameBufferWidth, c.FrameBufferHeight); err != nil {
+			fbWidth, fbHeight); err != nil {
 			return nil, encodingError("RREEncoding.Read", "invalid rectangle dimensions", err)
 		}
 	}
 
 	// Read number of subrectangles
-	var numSubrects uint32
-	if err := binary.Read(r, binary.BigEndian, &numSubrects); err != nil {
+	var buf [8]byte
+	fields, err := readFields(r, "subrectangle count", buf[:4])
+	if err != nil {
 		return nil, encodingError("RREEncoding.Read", "failed to read number of subrectangles", err)
 	}
+	numSubrects := fields.uint32()
 
 	// Validate subrectangle count with enhanced security checks
 	const maxSubrects = 1000000
@@ -126,7 +129,8 @@ func (*RREEncoding) Read(c *ClientConn, rect *Rectangle, r io.Reader) (Encoding,
 	}
 
 	// Read background color
-	backgroundColor, err := readPixelColor(r, c.PixelFormat, c.ColorMap)
+	pixelReader := c.pixelReader()
+	backgroundColor, err := pixelReader.ReadPixelColor(r)
 	if err != nil {
 		return nil, encodingError("RREEncoding.Read", "failed to read background color", err)
 	}
@@ -135,19 +139,17 @@ func (*RREEncoding) Read(c *ClientConn, rect *Rectangle, r io.Reader) (Encoding,
 	subrects := make([]RRESubrectangle, numSubrects)
 	for i := uint32(0); i < numSubrects; i++ {
 		// Read subrectangle color
-		color, err := readPixelColor(r, c.PixelFormat, c.ColorMap)
+		color, err := pixelReader.ReadPixelColor(r)
 		if err != nil {
 			return nil, encodingError("RREEncoding.Read", "failed to read subrectangle color", err)
 		}
 
 		// Read subrectangle position and dimensions
-		var x, y, width, height uint16
-		data := []interface{}{&x, &y, &width, &height}
-		for _, val := range data {
-			if err := binary.Read(r, binary.BigEndian, val); err != nil {
-				return nil, encodingError("RREEncoding.Read", "failed to read subrectangle geometry", err)
-			}
+		fields, err := readFields(r, "subrectangle geometry", buf[:])
+		if err != nil {
+			return nil, encodingError("RREEncoding.Read", "failed to read subrectangle geometry", err)
 		}
+		x, y, width, height := fields.uint16(), fields.uint16(), fields.uint16(), fields.uint16()
 
 		// Validate subrectangle bounds with comprehensive security checks
 		if err := validator.ValidateRectangle(x, y, width, height, rect.Width, rect.Height); err != nil {
@@ -168,3 +170,37 @@ func (*RREEncoding) Read(c *ClientConn, rect *Rectangle, r io.Reader) (Encoding,
 		Subrectangles:   subrects,
 	}, nil
 }
+
+// Encode returns the rectangle as RRE data, with its most common color as the
+// background and the other pixels covered by subrectangles.
+func (*RREEncoding) Encode(pf *PixelFormat, rect *Rectangle, pixels []Color) ([]byte, error) {
+	pw, err := newPixelWriter("RREEncoding.Encode", pf, rect, pixels)
+	if err != nil {
+		return nil, err
+	}
+
+	area := pixelArea{pixels: pixels, stride: int(rect.Width), width: int(rect.Width), height: int(rect.Height)}
+	bg := area.background()
+	subs, _ := area.subrects(bg, -1)
+
+	buf := make([]byte, 0, 4+pw.size+len(subs)*(pw.size+8))
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(subs))) // #nosec G115 - At most one subrectangle per pixel
+	buf = pw.append(buf, bg)
+	for _, sub := range subs {
+		buf = pw.append(buf, sub.color)
+		buf = binary.BigEndian.AppendUint16(buf, uint16(sub.x))      // #nosec G115 - Within the rectangle width
+		buf = binary.BigEndian.AppendUint16(buf, uint16(sub.y))      // #nosec G115 - Within the rectangle height
+		buf = binary.BigEndian.AppendUint16(buf, uint16(sub.width))  // #nosec G115 - Within the rectangle width
+		buf = binary.BigEndian.AppendUint16(buf, uint16(sub.height)) // #nosec G115 - Within the rectangle height
+	}
+	return buf, nil
+}
+
+// paint renders the background and subrectangles into the client framebuffer.
+func (e *RREEncoding) paint(fb *framebuffer, rect *Rectangle) {
+	x, y := int(rect.X), int(rect.Y)
+	fb.fill(x, y, int(rect.Width), int(rect.Height), e.BackgroundColor)
+	for _, sub := range e.Subrectangles {
+		fb.fill(x+int(sub.X), y+int(sub.Y), int(sub.Width), int(sub.Height), sub.Color)
+	}
+}
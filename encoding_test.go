@@ -11,6 +11,12 @@ import (
 	"testing"
 )
 
+// newEncodingTestConn returns a connection without a network peer for
+// exercising decoders directly.
+func newEncodingTestConn() *ClientConn {
+	return &ClientConn{logger: &NoOpLogger{}}
+}
+
 // TestRawEncoding tests Raw encoding with various pixel formats and data sizes.
 func TestEncoding_Raw(t *testing.T) {
 	tests := []struct {
@@ -92,10 +98,8 @@ func TestEncoding_Raw(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			// Create mock client connection
-			mockConn := &ClientConn{
-				PixelFormat: tt.pixelFormat,
-				logger:      &NoOpLogger{},
-			}
+			mockConn := newEncodingTestConn()
+			mockConn.setPixelFormat(tt.pixelFormat)
 
 			// Create rectangle
 			rect := &Rectangle{
@@ -165,6 +169,53 @@ func TestEncoding_Raw(t *testing.T) {
 	}
 }
 
+func TestEncoding_RawPacked(t *testing.T) {
+	const w, h = 12, 9
+	for name, pf := range encodeTestFormats {
+		t.Run(name, func(t *testing.T) {
+			pixels := encodeTestImage(pf, w, h)
+			rect := &Rectangle{X: 3, Y: 2, Width: w, Height: h}
+			data, err := (&RawEncoding{}).Encode(&pf, rect, pixels)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			c := newEncodeConn(pf, 32, 32)
+			decoded, err := (&RawEncoding{Packed: true}).Read(c, rect, bytes.NewReader(data))
+			if err != nil {
+				t.Fatalf("Read() error = %v", err)
+			}
+			raw := decoded.(*RawEncoding)
+			if !bytes.Equal(raw.Pixels, data) || raw.Colors != nil || raw.PixelFormat != pf {
+				t.Fatalf("Read() = %d pixel bytes, %d colors in %+v, want the %d bytes sent in %+v",
+					len(raw.Pixels), len(raw.Colors), raw.PixelFormat, len(data), pf)
+			}
+
+			rect.Enc = raw
+			img, err := c.ConvertRectToRGBA(rect)
+			if err != nil {
+				t.Fatalf("ConvertRectToRGBA() error = %v", err)
+			}
+			for i, px := range pixels {
+				if got, want := img.RGBAAt(3+i%w, 2+i/w), pixelRGBA(&pf, px); got != want {
+					t.Fatalf("pixel %d = %v, want %v", i, got, want)
+				}
+			}
+		})
+	}
+
+	// The byte order forced on the connection is recorded with the pixels.
+	c := newEncodeConn(*PixelFormat32BitRGBA, 4, 4)
+	c.config = &ClientConfig{PixelEndianness: PixelEndiannessBig}
+	decoded, err := (&RawEncoding{Packed: true}).Read(c, &Rectangle{Width: 1, Height: 1}, bytes.NewReader(make([]byte, 4)))
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if !decoded.(*RawEncoding).PixelFormat.BigEndian {
+		t.Error("PixelFormat.BigEndian = false with big-endian pixels forced")
+	}
+}
+
 // TestCopyRectEncoding tests CopyRect encoding.
 func TestEncoding_CopyRect(t *testing.T) {
 	tests := []struct {
@@ -197,11 +248,8 @@ func TestEncoding_CopyRect(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			// Create mock client connection
-			mockConn := &ClientConn{
-				FrameBufferWidth:  800,
-				FrameBufferHeight: 600,
-				logger:            &NoOpLogger{},
-			}
+			mockConn := newEncodingTestConn()
+			mockConn.setFrameBufferSize(800, 600)
 
 			// Create rectangle
 			rect := &Rectangle{
@@ -355,10 +403,8 @@ func TestEncoding_RRE(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			// Create mock client connection
-			mockConn := &ClientConn{
-				PixelFormat: tt.pixelFormat,
-				logger:      &NoOpLogger{},
-			}
+			mockConn := newEncodingTestConn()
+			mockConn.setPixelFormat(tt.pixelFormat)
 
 			// Create rectangle
 			rect := &Rectangle{
@@ -517,10 +563,8 @@ func TestEncoding_Hextile(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			// Create mock client connection
-			mockConn := &ClientConn{
-				PixelFormat: tt.pixelFormat,
-				logger:      &NoOpLogger{},
-			}
+			mockConn := newEncodingTestConn()
+			mockConn.setPixelFormat(tt.pixelFormat)
 
 			// Create rectangle
 			rect := &Rectangle{
@@ -629,26 +673,96 @@ func TestEncoding_ErrorHandling(t *testing.T) {
 			expectError: true,
 			errorType:   ErrEncoding,
 		},
+		{
+			name:     "Cursor pseudo-encoding - empty data",
+			encoding: &CursorPseudoEncoding{},
+			setupReader: func() io.Reader {
+				return bytes.NewReader([]byte{})
+			},
+			expectError: true,
+			errorType:   ErrEncoding,
+		},
+		{
+			name:     "AlphaCursor pseudo-encoding - empty data",
+			encoding: &AlphaCursorPseudoEncoding{},
+			setupReader: func() io.Reader {
+				return bytes.NewReader([]byte{})
+			},
+			expectError: true,
+			errorType:   ErrEncoding,
+		},
+		{
+			name:     "XCursor pseudo-encoding - empty data",
+			encoding: &XCursorPseudoEncoding{},
+			setupReader: func() io.Reader {
+				return bytes.NewReader([]byte{})
+			},
+			expectError: true,
+			errorType:   ErrEncoding,
+		},
+		{
+			name:     "VMwareCursor pseudo-encoding - empty data",
+			encoding: &VMwareCursorPseudoEncoding{},
+			setupReader: func() io.Reader {
+				return bytes.NewReader([]byte{})
+			},
+			expectError: true,
+			errorType:   ErrEncoding,
+		},
+		{
+			name:     "VMwareCursorState pseudo-encoding - empty data",
+			encoding: &VMwareCursorStatePseudoEncoding{},
+			setupReader: func() io.Reader {
+				return bytes.NewReader([]byte{})
+			},
+			expectError: true,
+			errorType:   ErrEncoding,
+		},
+		{
+			name:     "DesktopName pseudo-encoding - empty data",
+			encoding: &DesktopNamePseudoEncoding{},
+			setupReader: func() io.Reader {
+				return bytes.NewReader([]byte{})
+			},
+			expectError: true,
+			errorType:   ErrEncoding,
+		},
+		{
+			name:     "LEDState pseudo-encoding - empty data",
+			encoding: &LEDStatePseudoEncoding{},
+			setupReader: func() io.Reader {
+				return bytes.NewReader([]byte{})
+			},
+			expectError: true,
+			errorType:   ErrEncoding,
+		},
+		{
+			name:     "ExtendedDesktopSize pseudo-encoding - empty data",
+			encoding: &ExtendedDesktopSizePseudoEncoding{},
+			setupReader: func() io.Reader {
+				return bytes.NewReader([]byte{})
+			},
+			expectError: true,
+			errorType:   ErrEncoding,
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			// Create mock client connection
-			mockConn := &ClientConn{
-				PixelFormat: PixelFormat{
-					BPP:        32,
-					Depth:      24,
-					BigEndian:  false,
-					TrueColor:  true,
-					RedMax:     255,
-					GreenMax:   255,
-					BlueMax:    255,
-					RedShift:   16,
-					GreenShift: 8,
-					BlueShift:  0,
-				},
-				logger: &NoOpLogger{},
-			}
+			mockConn := newEncodingTestConn()
+			mockConn.setPixelFormat(PixelFormat{
+				BPP:        32,
+				Depth:      24,
+				BigEndian:  false,
+				TrueColor:  true,
+				RedMax:     255,
+				GreenMax:   255,
+				BlueMax:    255,
+				RedShift:   16,
+				GreenShift: 8,
+				BlueShift:  0,
+			})
 
 			// Create rectangle
 			rect := &Rectangle{
@@ -739,10 +853,8 @@ func TestEncoding_PixelFormatCompatibility(t *testing.T) {
 
 	for _, pf := range pixelFormats {
 		t.Run(fmt.Sprintf("PixelFormat_%d_bit", pf.BPP), func(t *testing.T) {
-			mockConn := &ClientConn{
-				PixelFormat: pf,
-				logger:      &NoOpLogger{},
-			}
+			mockConn := newEncodingTestConn()
+			mockConn.setPixelFormat(pf)
 
 			rect := &Rectangle{X: 0, Y: 0, Width: 1, Height: 1}
 
@@ -772,14 +884,12 @@ func TestEncoding_PixelFormatCompatibility(t *testing.T) {
 
 // Benchmark tests for encoding performance.
 func BenchmarkRawEncoding(b *testing.B) {
-	mockConn := &ClientConn{
-		PixelFormat: PixelFormat{
-			BPP: 32, Depth: 24, BigEndian: false, TrueColor: true,
-			RedMax: 255, GreenMax: 255, BlueMax: 255,
-			RedShift: 16, GreenShift: 8, BlueShift: 0,
-		},
-		logger: &NoOpLogger{},
-	}
+	mockConn := newEncodingTestConn()
+	mockConn.setPixelFormat(PixelFormat{
+		BPP: 32, Depth: 24, BigEndian: false, TrueColor: true,
+		RedMax: 255, GreenMax: 255, BlueMax: 255,
+		RedShift: 16, GreenShift: 8, BlueShift: 0,
+	})
 
 	rect := &Rectangle{X: 0, Y: 0, Width: 100, Height: 100}
 	pixelData := make([]byte, 100*100*4) // 100x100 pixels at 32-bit
@@ -797,11 +907,8 @@ func BenchmarkRawEncoding(b *testing.B) {
 }
 
 func BenchmarkCopyRectEncoding(b *testing.B) {
-	mockConn := &ClientConn{
-		FrameBufferWidth:  800,
-		FrameBufferHeight: 600,
-		logger:            &NoOpLogger{},
-	}
+	mockConn := newEncodingTestConn()
+	mockConn.setFrameBufferSize(800, 600)
 
 	rect := &Rectangle{X: 10, Y: 20, Width: 50, Height: 30}
 
@@ -0,0 +1,449 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+//go:build !vnc_minimal
+
+package vnc
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+
+	"github.com/tenthirtyam/go-vnc/rfb"
+)
+
+// Tight compression control values, from the high nibble of the first byte of
+// a rectangle. Values below tightFill select basic compression.
+const (
+	tightFill = 0x08
+	tightJPEG = 0x09
+
+	// tightPNG is only valid in TightPNG rectangles.
+	tightPNG = 0x0a
+
+	// tightExplicitFilter is set in basic compression when a filter ID follows.
+	tightExplicitFilter = 0x04
+)
+
+// Tight filters applied before basic compression.
+const (
+	tightFilterCopy     = 0
+	tightFilterPalette  = 1
+	tightFilterGradient = 2
+)
+
+const (
+	// tightMinToCompress is the data size below which basic compression sends
+	// the data without zlib.
+	tightMinToCompress = 12
+
+	// tightMaxCompactLength is the largest length a compact length field can
+	// hold, 22 bits.
+	tightMaxCompactLength = 1<<22 - 1
+)
+
+// TightEncoding represents the Tight encoding (type 7) implemented by
+// TightVNC, TigerVNC, TurboVNC, and QEMU. Each rectangle is a solid fill, a
+// JPEG image, or pixel data reduced by a copy, palette, or gradient filter and
+// compressed with one of four zlib streams that persist for the whole session.
+//
+// Servers use JPEG only when a JPEGQualityPseudoEncoding is also requested;
+// CompressionLevelPseudoEncoding trades server CPU for bandwidth. Because the
+// zlib streams span rectangles, a session that has received Tight data cannot
+// be resumed after Detach.
+type TightEncoding struct {
+	// Colors contains the decoded pixel data for the rectangle in row-major
+	// order, or the single fill color when Fill is set. Components are in the
+	// ranges of the session pixel format, as for RawEncoding.
+	Colors []Color
+
+	// Fill reports that every pixel of the rectangle has the color Colors[0].
+	Fill bool
+}
+
+// Type returns the encoding type identifier for Tight encoding.
+func (*TightEncoding) Type() int32 {
+	return rfb.EncodingTight
+}
+
+// tightState holds the four zlib streams of a connection.
+type tightState struct {
+	streams [4]zlibStream
+}
+
+// tightPixels converts pixels sent in the Tight pixel representation. Tight
+// sends 32-bit pixels with a depth of 24 and 8-bit components as three bytes,
+// red first.
+type tightPixels struct {
+	reader  *PixelReader
+	compact bool
+}
+
+// newTightPixels returns the pixel representation for the session pixel
+// format.
+func newTightPixels(c *ClientConn) tightPixels {
+	pr := c.pixelReader()
+	pf := pr.pixelFormat
+	return tightPixels{
+		reader: pr,
+		compact: pf.TrueColor && pf.BPP == 32 && pf.Depth == 24 &&
+			pf.RedMax == 255 && pf.GreenMax == 255 && pf.BlueMax == 255,
+	}
+}
+
+// size returns the number of bytes per pixel.
+func (p tightPixels) size() int {
+	if p.compact {
+		return 3
+	}
+	return p.reader.BytesPerPixel()
+}
+
+// color converts the pixel at the start of b.
+func (p tightPixels) color(b []byte) Color {
+	if p.compact {
+		return Color{R: uint16(b[0]), G: uint16(b[1]), B: uint16(b[2])}
+	}
+	return p.reader.pixelToColor(p.reader.bytesToPixel(b))
+}
+
+// colors converts a run of pixels.
+func (p tightPixels) colors(data []byte) []Color {
+	size := p.size()
+	colors := make([]Color, len(data)/size)
+	for i := range colors {
+		colors[i] = p.color(data[i*size:])
+	}
+	return colors
+}
+
+// Read decodes a Tight rectangle, updating the zlib streams of the connection.
+func (*TightEncoding) Read(c *ClientConn, rect *Rectangle, r io.Reader) (Encoding, error) {
+	enc, err := decodeTight(c, rect, r, false)
+	if err != nil {
+		return nil, err
+	}
+	return enc, nil
+}
+
+// decodeTight decodes a Tight or, with pngMode set, a TightPNG rectangle.
+// TightPNG replaces basic compression with PNG images.
+func decodeTight(c *ClientConn, rect *Rectangle, r io.Reader, pngMode bool) (*TightEncoding, error) {
+	var control [1]byte
+	if _, err := io.ReadFull(r, control[:]); err != nil {
+		return nil, encodingError("TightEncoding.Read", "failed to read compression control", err)
+	}
+
+	state := decoderState[tightState](c, rfb.EncodingTight)
+	for i := range state.streams {
+		if control[0]&(1<<i) != 0 {
+			state.streams[i].reset()
+		}
+	}
+
+	pixels := newTightPixels(c)
+	switch compression := control[0] >> 4; {
+	case compression == tightFill:
+		buf := make([]byte, pixels.size())
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, encodingError("TightEncoding.Read", "failed to read fill color", err)
+		}
+		return &TightEncoding{Colors: []Color{pixels.color(buf)}, Fill: true}, nil
+
+	case compression == tightJPEG:
+		return readTightImage(pixels, rect, r, "JPEG", jpeg.Decode)
+
+	case compression == tightPNG && pngMode:
+		return readTightImage(pixels, rect, r, "PNG", png.Decode)
+
+	case compression > tightJPEG:
+		return nil, encodingError("TightEncoding.Read",
+			fmt.Sprintf("unsupported compression type %#x", compression), nil)
+
+	case pngMode:
+		return nil, encodingError("TightPNGEncoding.Read", "basic compression in a TightPNG rectangle", nil)
+
+	default:
+		return readTightBasic(state, pixels, compression, rect, r)
+	}
+}
+
+// readTightBasic decodes a rectangle with basic compression.
+func readTightBasic(state *tightState, pixels tightPixels, compression byte, rect *Rectangle, r io.Reader) (*TightEncoding, error) {
+	stream := &state.streams[compression&0x03]
+	width, height := int(rect.Width), int(rect.Height)
+
+	filter := byte(tightFilterCopy)
+	if compression&tightExplicitFilter != 0 {
+		var id [1]byte
+		if _, err := io.ReadFull(r, id[:]); err != nil {
+			return nil, encodingError("TightEncoding.Read", "failed to read filter", err)
+		}
+		filter = id[0]
+	}
+
+	var palette []Color
+	dataSize := width * height * pixels.size()
+	switch filter {
+	case tightFilterCopy, tightFilterGradient:
+	case tightFilterPalette:
+		var count [1]byte
+		if _, err := io.ReadFull(r, count[:]); err != nil {
+			return nil, encodingError("TightEncoding.Read", "failed to read palette size", err)
+		}
+		buf := make([]byte, (int(count[0])+1)*pixels.size())
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, encodingError("TightEncoding.Read", "failed to read palette", err)
+		}
+		palette = pixels.colors(buf)
+
+		dataSize = width * height
+		if len(palette) == 2 {
+			dataSize = (width + 7) / 8 * height
+		}
+	default:
+		return nil, encodingError("TightEncoding.Read", fmt.Sprintf("unsupported filter %d", filter), nil)
+	}
+
+	data, err := readTightData(stream, dataSize, r)
+	if err != nil {
+		return nil, err
+	}
+
+	var colors []Color
+	switch filter {
+	case tightFilterCopy:
+		colors = pixels.colors(data)
+	case tightFilterPalette:
+		colors, err = tightPalette(palette, data, width, height)
+	case tightFilterGradient:
+		colors = tightGradient(pixels.reader.pixelFormat, pixels.colors(data), width)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &TightEncoding{Colors: colors}, nil
+}
+
+// readTightData reads size bytes of filtered data, inflating it through
+// stream unless it is too small to have been compressed.
+func readTightData(stream *zlibStream, size int, r io.Reader) ([]byte, error) {
+	if size < tightMinToCompress {
+		data := make([]byte, size)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, encodingError("TightEncoding.Read", "failed to read pixel data", err)
+		}
+		return data, nil
+	}
+
+	compressed, err := readTightCompact(r)
+	if err != nil {
+		return nil, err
+	}
+	data, err := stream.inflate(compressed, size)
+	if err != nil {
+		return nil, encodingError("TightEncoding.Read", "failed to inflate pixel data", err)
+	}
+	return data, nil
+}
+
+// readTightCompact reads a block preceded by a compact length: one to three
+// bytes holding seven bits each, least significant first, with the high bit
+// set when another byte follows.
+func readTightCompact(r io.Reader) ([]byte, error) {
+	length := 0
+	var b [1]byte
+	for i := 0; i < 3; i++ {
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			return nil, encodingError("TightEncoding.Read", "failed to read data length", err)
+		}
+		if i == 2 {
+			length |= int(b[0]) << 14
+			break
+		}
+		length |= int(b[0]&0x7f) << (7 * i)
+		if b[0]&0x80 == 0 {
+			break
+		}
+	}
+	if length > tightMaxCompactLength {
+		return nil, encodingError("TightEncoding.Read", fmt.Sprintf("data length %d too large", length), nil)
+	}
+
+	data := make([]byte, length)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, encodingError("TightEncoding.Read", "failed to read compressed data", err)
+	}
+	return data, nil
+}
+
+// tightPalette expands palette indexes. Two-color palettes pack a row of
+// pixels into bits, most significant first, padding each row to a byte.
+func tightPalette(palette []Color, data []byte, width, height int) ([]Color, error) {
+	colors := make([]Color, width*height)
+	if len(palette) == 2 {
+		stride := (width + 7) / 8
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				bit := data[y*stride+x/8] >> (7 - x%8) & 1
+				colors[y*width+x] = palette[bit]
+			}
+		}
+		return colors, nil
+	}
+
+	for i, index := range data {
+		if int(index) >= len(palette) {
+			return nil, encodingError("TightEncoding.Read",
+				fmt.Sprintf("palette index %d out of range for %d colors", index, len(palette)), nil)
+		}
+		colors[i] = palette[index]
+	}
+	return colors, nil
+}
+
+// tightGradient reverses the gradient filter. Each component was sent as the
+// difference from the prediction left + above - above-left, clamped to the
+// component range, modulo the range.
+func tightGradient(pf PixelFormat, diffs []Color, width int) []Color {
+	predict := func(left, above, aboveLeft, limit uint16) uint16 {
+		p := int(left) + int(above) - int(aboveLeft)
+		return uint16(min(max(p, 0), int(limit))) // #nosec G115 - clamped to the component range
+	}
+
+	colors := make([]Color, len(diffs))
+	for i := range diffs {
+		var left, above, aboveLeft Color
+		x := i % width
+		if x > 0 {
+			left = colors[i-1]
+		}
+		if i >= width {
+			above = colors[i-width]
+			if x > 0 {
+				aboveLeft = colors[i-width-1]
+			}
+		}
+
+		colors[i] = Color{
+			R: (predict(left.R, above.R, aboveLeft.R, pf.RedMax) + diffs[i].R) & pf.RedMax,
+			G: (predict(left.G, above.G, aboveLeft.G, pf.GreenMax) + diffs[i].G) & pf.GreenMax,
+			B: (predict(left.B, above.B, aboveLeft.B, pf.BlueMax) + diffs[i].B) & pf.BlueMax,
+		}
+	}
+	return colors
+}
+
+// readTightImage decodes a JPEG or PNG rectangle, scaling its colors to the
+// session pixel format.
+func readTightImage(pixels tightPixels, rect *Rectangle, r io.Reader, format string,
+	decode func(io.Reader) (image.Image, error)) (*TightEncoding, error) {
+	pf := pixels.reader.pixelFormat
+	if !pf.TrueColor || pf.BPP < 16 {
+		return nil, encodingError("TightEncoding.Read",
+			fmt.Sprintf("%s rectangle in a pixel format below 16 bits per pixel", format), nil)
+	}
+
+	data, err := readTightCompact(r)
+	if err != nil {
+		return nil, err
+	}
+	img, err := decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, encodingError("TightEncoding.Read", fmt.Sprintf("failed to decode %s data", format), err)
+	}
+
+	width, height := int(rect.Width), int(rect.Height)
+	if img.Bounds().Size() != image.Pt(width, height) {
+		return nil, encodingError("TightEncoding.Read",
+			fmt.Sprintf("%s image is %v, rectangle is %dx%d", format, img.Bounds().Size(), width, height), nil)
+	}
+
+	return &TightEncoding{Colors: imageColors(img, pf, width, height)}, nil
+}
+
+// imageColors converts the top-left width by height pixels of img to colors
+// in the ranges of true color format pf, in row-major order.
+func imageColors(img image.Image, pf PixelFormat, width, height int) []Color {
+	scale := func(v uint32, limit uint16) uint16 {
+		return uint16((v >> 8) * uint32(limit) / 255) // #nosec G115 - at most limit
+	}
+	colors := make([]Color, width*height)
+	bounds := img.Bounds()
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			red, green, blue, _ := img.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+			colors[y*width+x] = Color{R: scale(red, pf.RedMax), G: scale(green, pf.GreenMax), B: scale(blue, pf.BlueMax)}
+		}
+	}
+	return colors
+}
+
+// paint renders the decoded rectangle into the client framebuffer.
+func (e *TightEncoding) paint(fb *framebuffer, rect *Rectangle) {
+	if e.Fill {
+		if len(e.Colors) > 0 {
+			fb.fill(int(rect.X), int(rect.Y), int(rect.Width), int(rect.Height), e.Colors[0])
+		}
+		return
+	}
+	fb.setColors(rect, e.Colors)
+}
+
+// JPEGQualityPseudoEncoding requests JPEG compression of photographic areas
+// from servers that support it, most notably with TightEncoding. Level ranges
+// from 0 (smallest) to 9 (best quality); higher values are treated as 9.
+type JPEGQualityPseudoEncoding struct {
+	Level uint8
+}
+
+// Type returns the encoding type identifier for the requested quality level.
+func (e *JPEGQualityPseudoEncoding) Type() int32 {
+	return rfb.PseudoEncodingJPEGQualityLevel0 + int32(min(e.Level, 9))
+}
+
+// IsPseudo returns true indicating this is a pseudo-encoding.
+func (*JPEGQualityPseudoEncoding) IsPseudo() bool {
+	return true
+}
+
+// Read returns the encoding; the server never sends rectangles of it.
+func (e *JPEGQualityPseudoEncoding) Read(*ClientConn, *Rectangle, io.Reader) (Encoding, error) {
+	return e, nil
+}
+
+// Handle does nothing; the quality level only affects the server.
+func (*JPEGQualityPseudoEncoding) Handle(*ClientConn, *Rectangle) error {
+	return nil
+}
+
+// CompressionLevelPseudoEncoding requests a zlib compression level from
+// servers that support it, most notably with TightEncoding. Level ranges from
+// 0 (fastest) to 9 (smallest); higher values are treated as 9.
+type CompressionLevelPseudoEncoding struct {
+	Level uint8
+}
+
+// Type returns the encoding type identifier for the requested level.
+func (e *CompressionLevelPseudoEncoding) Type() int32 {
+	return rfb.PseudoEncodingCompressionLevel0 + int32(min(e.Level, 9))
+}
+
+// IsPseudo returns true indicating this is a pseudo-encoding.
+func (*CompressionLevelPseudoEncoding) IsPseudo() bool {
+	return true
+}
+
+// Read returns the encoding; the server never sends rectangles of it.
+func (e *CompressionLevelPseudoEncoding) Read(*ClientConn, *Rectangle, io.Reader) (Encoding, error) {
+	return e, nil
+}
+
+// Handle does nothing; the compression level only affects the server.
+func (*CompressionLevelPseudoEncoding) Handle(*ClientConn, *Rectangle) error {
+	return nil
+}
@@ -0,0 +1,200 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+//go:build !vnc_minimal
+
+package vnc
+
+import (
+	"bytes"
+	"compress/zlib"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"slices"
+	"testing"
+)
+
+// tightCompact returns data preceded by its Tight compact length.
+func tightCompact(data []byte) []byte {
+	n := len(data)
+	var out []byte
+	switch {
+	case n < 1<<7:
+		out = []byte{byte(n)}
+	case n < 1<<14:
+		out = []byte{byte(n) | 0x80, byte(n >> 7)}
+	default:
+		out = []byte{byte(n) | 0x80, byte(n>>7) | 0x80, byte(n >> 14)}
+	}
+	return append(out, data...)
+}
+
+// tightDeflater compresses data for one Tight zlib stream, flushing after
+// every rectangle like a server does.
+type tightDeflater struct {
+	buf bytes.Buffer
+	w   *zlib.Writer
+}
+
+func (d *tightDeflater) deflate(t *testing.T, data []byte) []byte {
+	t.Helper()
+	if d.w == nil {
+		d.w = zlib.NewWriter(&d.buf)
+	}
+	if _, err := d.w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	out := append([]byte(nil), d.buf.Bytes()...)
+	d.buf.Reset()
+	return out
+}
+
+func newTightConn(pf PixelFormat) *ClientConn {
+	c := &ClientConn{logger: &NoOpLogger{}}
+	c.setPixelFormat(pf)
+	return c
+}
+
+func readTight(t *testing.T, c *ClientConn, w, h uint16, data []byte) *TightEncoding {
+	t.Helper()
+	enc, err := (&TightEncoding{}).Read(c, &Rectangle{Width: w, Height: h}, bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	return enc.(*TightEncoding)
+}
+
+func TestTightEncoding_Fill(t *testing.T) {
+	c := newTightConn(*PixelFormat32BitRGBA)
+	enc := readTight(t, c, 100, 100, []byte{tightFill << 4, 10, 20, 30})
+
+	if !enc.Fill || len(enc.Colors) != 1 || enc.Colors[0] != (Color{R: 10, G: 20, B: 30}) {
+		t.Errorf("fill = %+v", enc)
+	}
+}
+
+func TestTightEncoding_BasicStreams(t *testing.T) {
+	c := newTightConn(*PixelFormat32BitRGBA)
+	var stream tightDeflater
+
+	// Small rectangles are sent without compression.
+	enc := readTight(t, c, 2, 1, []byte{0x00, 1, 2, 3, 4, 5, 6})
+	if want := []Color{{R: 1, G: 2, B: 3}, {R: 4, G: 5, B: 6}}; !slices.Equal(enc.Colors, want) {
+		t.Errorf("uncompressed colors = %v, want %v", enc.Colors, want)
+	}
+
+	// Two rectangles share stream 1, the second relying on the first's
+	// dictionary.
+	pixels := bytes.Repeat([]byte{7, 8, 9}, 16)
+	for i := 0; i < 2; i++ {
+		data := append([]byte{0x10}, tightCompact(stream.deflate(t, pixels))...)
+		enc = readTight(t, c, 4, 4, data)
+		if len(enc.Colors) != 16 || enc.Colors[15] != (Color{R: 7, G: 8, B: 9}) {
+			t.Fatalf("rectangle %d colors = %v", i, enc.Colors)
+		}
+	}
+
+	// A reset of stream 1 starts a new zlib stream.
+	stream = tightDeflater{}
+	data := append([]byte{0x12}, tightCompact(stream.deflate(t, pixels))...)
+	if enc = readTight(t, c, 4, 4, data); len(enc.Colors) != 16 {
+		t.Errorf("colors after reset = %d, want 16", len(enc.Colors))
+	}
+}
+
+func TestTightEncoding_Palette(t *testing.T) {
+	c := newTightConn(*PixelFormat16BitRGB565)
+	black, white := []byte{0, 0}, []byte{0xff, 0xff}
+
+	// Two colors: one bit per pixel, rows padded to a byte.
+	data := []byte{0x04 << 4, tightFilterPalette, 1}
+	data = append(data, black...)
+	data = append(data, white...)
+	data = append(data, 0b10100000, 0b01000000)
+	enc := readTight(t, c, 3, 2, data)
+
+	w := Color{R: 31, G: 63, B: 31}
+	if want := []Color{w, {}, w, {}, w, {}}; !slices.Equal(enc.Colors, want) {
+		t.Errorf("two-color palette = %v, want %v", enc.Colors, want)
+	}
+
+	// An index past the palette is rejected.
+	data = []byte{0x04 << 4, tightFilterPalette, 2}
+	data = append(data, black...)
+	data = append(data, white...)
+	data = append(data, black...)
+	data = append(data, 0, 1, 2, 3)
+	if _, err := (&TightEncoding{}).Read(c, &Rectangle{Width: 4, Height: 1}, bytes.NewReader(data)); err == nil {
+		t.Error("Read() accepted an out-of-range palette index")
+	}
+}
+
+func TestTightEncoding_Gradient(t *testing.T) {
+	c := newTightConn(*PixelFormat32BitRGBA)
+	want := []Color{{R: 10}, {R: 20}, {R: 15}, {R: 30}}
+
+	// Encode the differences from the prediction left + above - above-left.
+	diffs := []byte{
+		10, 0, 0, // predicted 0
+		10, 0, 0, // predicted 10 (left)
+		5, 0, 0, // predicted 10 (above)
+		5, 0, 0, // predicted 20 + 15 - 10 = 25
+	}
+	data := append([]byte{0x04 << 4, tightFilterGradient}, tightCompact(new(tightDeflater).deflate(t, diffs))...)
+	enc := readTight(t, c, 2, 2, data)
+
+	if !slices.Equal(enc.Colors, want) {
+		t.Errorf("gradient colors = %v, want %v", enc.Colors, want)
+	}
+}
+
+func TestTightEncoding_JPEG(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 16, 8))
+	for i := 0; i < len(img.Pix); i += 4 {
+		img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = 200, 100, 50, 255
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 100}); err != nil {
+		t.Fatal(err)
+	}
+
+	c := newTightConn(*PixelFormat32BitRGBA)
+	enc := readTight(t, c, 16, 8, append([]byte{tightJPEG << 4}, tightCompact(buf.Bytes())...))
+
+	got := enc.Colors[0]
+	near := func(a uint16, b int) bool { return int(a) >= b-4 && int(a) <= b+4 }
+	if len(enc.Colors) != 128 || !near(got.R, 200) || !near(got.G, 100) || !near(got.B, 50) {
+		t.Errorf("JPEG color = %+v, want about {200 100 50}", got)
+	}
+
+	if _, err := (&TightEncoding{}).Read(c, &Rectangle{Width: 8, Height: 8},
+		bytes.NewReader(append([]byte{tightJPEG << 4}, tightCompact(buf.Bytes())...))); err == nil {
+		t.Error("Read() accepted a JPEG image of the wrong size")
+	}
+}
+
+func TestTightEncoding_Paint(t *testing.T) {
+	fb := newFramebuffer(4, 4)
+	fb.pf = *PixelFormat32BitRGBA
+	(&TightEncoding{Colors: []Color{{G: 255}}, Fill: true}).paint(fb, &Rectangle{X: 1, Y: 1, Width: 2, Height: 2})
+
+	if got := fb.grid.rgbaAt(2, 2); got != (color.RGBA{G: 255, A: 255}) {
+		t.Errorf("filled pixel = %v", got)
+	}
+	if got := fb.grid.rgbaAt(3, 3); got != (color.RGBA{A: 255}) {
+		t.Errorf("pixel outside fill = %v", got)
+	}
+}
+
+func TestTightEncoding_PseudoEncodingTypes(t *testing.T) {
+	if got := (&JPEGQualityPseudoEncoding{Level: 5}).Type(); got != -27 {
+		t.Errorf("JPEG quality 5 type = %d, want -27", got)
+	}
+	if got := (&CompressionLevelPseudoEncoding{Level: 12}).Type(); got != -247 {
+		t.Errorf("compression level 12 type = %d, want -247", got)
+	}
+}
@@ -0,0 +1,46 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+//go:build !vnc_minimal
+
+package vnc
+
+import (
+	"io"
+
+	"github.com/tenthirtyam/go-vnc/rfb"
+)
+
+// TightPNGEncoding represents the TightPNG encoding (type -260) of noVNC and
+// the servers and websockify deployments built for it. It is Tight without
+// basic compression: each rectangle is a solid fill, a JPEG image, or a PNG
+// image, so it keeps no state between rectangles. Despite its negative
+// number it is a pixel encoding, not a pseudo-encoding.
+type TightPNGEncoding struct {
+	// Colors contains the decoded pixel data for the rectangle in row-major
+	// order, or the single fill color when Fill is set. Components are in the
+	// ranges of the session pixel format, as for RawEncoding.
+	Colors []Color
+
+	// Fill reports that every pixel of the rectangle has the color Colors[0].
+	Fill bool
+}
+
+// Type returns the encoding type identifier for TightPNG encoding.
+func (*TightPNGEncoding) Type() int32 {
+	return rfb.EncodingTightPNG
+}
+
+// Read decodes a TightPNG rectangle.
+func (*TightPNGEncoding) Read(c *ClientConn, rect *Rectangle, r io.Reader) (Encoding, error) {
+	enc, err := decodeTight(c, rect, r, true)
+	if err != nil {
+		return nil, err
+	}
+	return &TightPNGEncoding{Colors: enc.Colors, Fill: enc.Fill}, nil
+}
+
+// paint renders the decoded rectangle into the client framebuffer.
+func (e *TightPNGEncoding) paint(fb *framebuffer, rect *Rectangle) {
+	(&TightEncoding{Colors: e.Colors, Fill: e.Fill}).paint(fb, rect)
+}
@@ -0,0 +1,75 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+//go:build !vnc_minimal
+
+package vnc
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+)
+
+func readTightPNG(t *testing.T, c *ClientConn, w, h uint16, data []byte) *TightPNGEncoding {
+	t.Helper()
+	enc, err := (&TightPNGEncoding{}).Read(c, &Rectangle{Width: w, Height: h}, bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	return enc.(*TightPNGEncoding)
+}
+
+func TestTightPNGEncoding_PNG(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 3, 2))
+	img.SetRGBA(0, 0, color.RGBA{R: 255, A: 255})
+	img.SetRGBA(2, 1, color.RGBA{G: 128, B: 64, A: 255})
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+
+	c := newTightConn(*PixelFormat16BitRGB565)
+	enc := readTightPNG(t, c, 3, 2, append([]byte{tightPNG << 4}, tightCompact(buf.Bytes())...))
+
+	// PNG is lossless, so colors are exact after scaling to RGB565.
+	if got, want := enc.Colors[0], (Color{R: 31}); got != want {
+		t.Errorf("pixel (0, 0) = %+v, want %+v", got, want)
+	}
+	if got, want := enc.Colors[5], (Color{G: 31, B: 7}); got != want {
+		t.Errorf("pixel (2, 1) = %+v, want %+v", got, want)
+	}
+}
+
+func TestTightPNGEncoding_Fill(t *testing.T) {
+	c := newTightConn(*PixelFormat32BitRGBA)
+	enc := readTightPNG(t, c, 10, 10, []byte{tightFill << 4, 10, 20, 30})
+	if !enc.Fill || enc.Colors[0] != (Color{R: 10, G: 20, B: 30}) {
+		t.Errorf("fill = %+v", enc)
+	}
+}
+
+func TestTightPNGEncoding_Invalid(t *testing.T) {
+	tests := map[string][]byte{
+		// Basic compression is Tight only.
+		"Basic":       {0x00, 1, 2, 3, 4, 5, 6},
+		"Corrupt":     {tightPNG << 4, 4, 0xde, 0xad, 0xbe, 0xef},
+		"Compression": {0xb0},
+	}
+	for name, data := range tests {
+		t.Run(name, func(t *testing.T) {
+			c := newTightConn(*PixelFormat32BitRGBA)
+			if _, err := (&TightPNGEncoding{}).Read(c, &Rectangle{Width: 2, Height: 1}, bytes.NewReader(data)); err == nil {
+				t.Error("Read() accepted invalid data")
+			}
+		})
+	}
+
+	// PNG rectangles are TightPNG only.
+	c := newTightConn(*PixelFormat32BitRGBA)
+	if _, err := (&TightEncoding{}).Read(c, &Rectangle{Width: 2, Height: 1}, bytes.NewReader([]byte{tightPNG << 4, 0})); err == nil {
+		t.Error("TightEncoding accepted a PNG rectangle")
+	}
+}
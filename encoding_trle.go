@@ -0,0 +1,67 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+//go:build !vnc_minimal
+
+package vnc
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/tenthirtyam/go-vnc/rfb"
+)
+
+// trleTileSize is the edge length of the tiles a TRLE rectangle is split into.
+const trleTileSize = 16
+
+// TRLEEncoding represents the TRLE encoding (type 15) defined in RFC 6143
+// Section 7.7.5. It uses the tile subencodings of ZRLE on 16x16 tiles without
+// compression, and adds two subencodings that reuse the palette of the
+// previous tile. Many embedded servers offer it as their only encoding beyond
+// Raw. Unlike ZRLE it keeps no state between rectangles, so sessions using it
+// can be resumed after Detach.
+type TRLEEncoding struct {
+	// Colors contains the decoded pixel data for the rectangle in row-major
+	// order. Components are in the ranges of the session pixel format, as for
+	// RawEncoding.
+	Colors []Color
+}
+
+// Type returns the encoding type identifier for TRLE encoding.
+func (*TRLEEncoding) Type() int32 {
+	return rfb.EncodingTRLE
+}
+
+// Read decodes a TRLE rectangle.
+func (*TRLEEncoding) Read(c *ClientConn, rect *Rectangle, r io.Reader) (Encoding, error) {
+	width, height := int(rect.Width), int(rect.Height)
+	colors := make([]Color, width*height)
+	pixels := newZRLEPixels(c)
+
+	// Palettes are reused across tiles, in the same order as ZRLE tiles.
+	var palette []Color
+	for ty := 0; ty < height; ty += trleTileSize {
+		for tx := 0; tx < width; tx += trleTileSize {
+			tile := zrleTile{
+				colors: colors,
+				stride: width,
+				x:      tx,
+				y:      ty,
+				width:  min(trleTileSize, width-tx),
+				height: min(trleTileSize, height-ty),
+			}
+			if err := tile.read(pixels, r, &palette); err != nil {
+				return nil, encodingError("TRLEEncoding.Read",
+					fmt.Sprintf("failed to decode tile at %d,%d", tx, ty), err)
+			}
+		}
+	}
+
+	return &TRLEEncoding{Colors: colors}, nil
+}
+
+// paint renders the decoded rectangle into the client framebuffer.
+func (e *TRLEEncoding) paint(fb *framebuffer, rect *Rectangle) {
+	fb.setColors(rect, e.Colors)
+}
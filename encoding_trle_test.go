@@ -0,0 +1,129 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+//go:build !vnc_minimal
+
+package vnc
+
+import (
+	"bytes"
+	"slices"
+	"testing"
+)
+
+func readTRLE(t *testing.T, c *ClientConn, w, h uint16, data []byte) []Color {
+	t.Helper()
+	r := bytes.NewReader(data)
+	enc, err := (&TRLEEncoding{}).Read(c, &Rectangle{Width: w, Height: h}, r)
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if r.Len() != 0 {
+		t.Errorf("Read() left %d bytes unread", r.Len())
+	}
+	return enc.(*TRLEEncoding).Colors
+}
+
+func TestTRLEEncoding_Subencodings(t *testing.T) {
+	c := newTightConn(*PixelFormat32BitRGBA)
+
+	red, green, blue := Color{R: 255}, Color{G: 255}, Color{B: 255}
+	cRed, cGreen, cBlue := []byte{0, 0, 255}, []byte{0, 255, 0}, []byte{255, 0, 0}
+
+	tests := []struct {
+		name string
+		data []byte
+		want []Color
+	}{
+		{"Raw", slices.Concat([]byte{zrleRaw}, cRed, cGreen, cBlue, cRed), []Color{red, green, blue, red}},
+		{"Solid", slices.Concat([]byte{zrleSolid}, cGreen), []Color{green, green, green, green}},
+		{"Packed", slices.Concat([]byte{3}, cRed, cGreen, cBlue, []byte{0b00100000, 0b01000000}), []Color{red, blue, green, red}},
+		{"PlainRLE", slices.Concat([]byte{zrlePlainRLE}, cRed, []byte{2}, cGreen, []byte{0}), []Color{red, red, red, green}},
+		{"PaletteRLE", slices.Concat([]byte{130}, cRed, cBlue, []byte{0x81, 1, 0, 1}), []Color{blue, blue, red, blue}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := readTRLE(t, c, 2, 2, tt.data); !slices.Equal(got, tt.want) {
+				t.Errorf("colors = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTRLEEncoding_ReusedPalettes(t *testing.T) {
+	c := newTightConn(*PixelFormat32BitRGBA)
+	red, green, blue := Color{R: 255}, Color{G: 255}, Color{B: 255}
+	cRed, cGreen, cBlue := []byte{0, 0, 255}, []byte{0, 255, 0}, []byte{255, 0, 0}
+
+	t.Run("Packed", func(t *testing.T) {
+		// A 20x2 rectangle has a 16x2 and a 4x2 tile; the second reuses the
+		// two-color palette of the first.
+		data := slices.Concat(
+			[]byte{2}, cRed, cBlue, []byte{0xaa, 0xaa, 0x00, 0x00},
+			[]byte{trleReusePacked, 0xf0, 0x50},
+		)
+		got := readTRLE(t, c, 20, 2, data)
+
+		want := make([]Color, 40)
+		for x := range 16 {
+			want[x] = []Color{blue, red}[x%2]
+			want[20+x] = red
+		}
+		for x := range 4 {
+			want[16+x] = blue
+			want[36+x] = []Color{red, blue}[x%2]
+		}
+		if !slices.Equal(got, want) {
+			t.Errorf("colors = %v, want %v", got, want)
+		}
+	})
+
+	t.Run("PaletteRLE", func(t *testing.T) {
+		// A 32x1 rectangle has two 16x1 tiles; the second reuses the palette
+		// of the first.
+		data := slices.Concat(
+			[]byte{130}, cGreen, cRed, []byte{0x80, 14, 0x01},
+			[]byte{trleReusePaletteRLE, 0x81, 15},
+		)
+		got := readTRLE(t, c, 32, 1, data)
+
+		want := make([]Color, 32)
+		for x := range want {
+			want[x] = red
+			if x < 15 {
+				want[x] = green
+			}
+		}
+		if !slices.Equal(got, want) {
+			t.Errorf("colors = %v, want %v", got, want)
+		}
+	})
+}
+
+func TestTRLEEncoding_InvalidTiles(t *testing.T) {
+	tests := map[string][]byte{
+		"UnusedSubencoding":      {17},
+		"ReuseWithoutPalette":    {trleReusePacked, 0, 0},
+		"ReuseRLEWithoutPalette": {trleReusePaletteRLE, 0x80, 3},
+		"RunOverflow":            {zrlePlainRLE, 0, 0, 0, 4},
+		"PaletteIndex":           {130, 0, 0, 0, 0, 0, 0, 5},
+		"Truncated":              {zrleRaw, 0, 0},
+	}
+	for name, data := range tests {
+		t.Run(name, func(t *testing.T) {
+			c := newTightConn(*PixelFormat32BitRGBA)
+			if _, err := (&TRLEEncoding{}).Read(c, &Rectangle{Width: 2, Height: 2}, bytes.NewReader(data)); err == nil {
+				t.Error("Read() accepted an invalid tile")
+			}
+		})
+	}
+}
+
+func TestZRLEEncoding_RejectsReusedPalettes(t *testing.T) {
+	c := newTightConn(*PixelFormat32BitRGBA)
+	data := zrleRect(t, &tightDeflater{}, []byte{trleReusePacked, 0, 0})
+	if _, err := (&ZRLEEncoding{}).Read(c, &Rectangle{Width: 2, Height: 2}, bytes.NewReader(data)); err == nil {
+		t.Error("ZRLE accepted a TRLE-only subencoding")
+	}
+}
@@ -0,0 +1,264 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package vnc
+
+import (
+	"fmt"
+	"image"
+	"io"
+
+	"github.com/tenthirtyam/go-vnc/rfb"
+)
+
+// VMware cursor types of VMwareCursorPseudoEncoding.
+const (
+	// VMwareCursorClassic cursors are AND and XOR masks in the pixel format
+	// of the connection, as Windows draws them.
+	VMwareCursorClassic uint8 = 0
+
+	// VMwareCursorAlpha cursors are 32-bit RGBA images.
+	VMwareCursorAlpha uint8 = 1
+)
+
+// Flags of VMwareCursorStatePseudoEncoding.
+const (
+	// VMwareCursorVisible is set while the cursor is shown.
+	VMwareCursorVisible uint16 = 0x01
+
+	// VMwareCursorAbsolute is set while the guest uses absolute pointer
+	// coordinates.
+	VMwareCursorAbsolute uint16 = 0x02
+
+	// VMwareCursorWarped is set when the guest moved the pointer itself.
+	VMwareCursorWarped uint16 = 0x04
+)
+
+// isVMwarePseudoEncoding reports whether encodingType is one of the VMware
+// pseudo-encodings, whose numbers are positive.
+func isVMwarePseudoEncoding(encodingType int32) bool {
+	return encodingType >= 0x574d5664 && encodingType <= 0x574d566a
+}
+
+// VMwareCursorPseudoEncoding represents the cursor pseudo-encoding of the VNC
+// consoles of ESXi and VMware Workstation, which send the cursor shape as
+// either AND and XOR masks or an RGBA image.
+//
+// Once handled, the shape is available from ClientConn.Cursor. Classic
+// cursor pixels that invert the screen cannot be drawn with image/draw and
+// are shown as black.
+type VMwareCursorPseudoEncoding struct {
+	// Width and Height are the size of the cursor in pixels.
+	Width, Height uint16
+
+	// HotspotX and HotspotY are the position of the pointer within the
+	// cursor.
+	HotspotX, HotspotY uint16
+
+	// CursorType is VMwareCursorClassic or VMwareCursorAlpha.
+	CursorType uint8
+
+	// Pixels holds the decoded shape, four bytes per pixel, red, green,
+	// blue, and alpha, in row-major order, with the color components
+	// premultiplied by alpha.
+	Pixels []uint8
+}
+
+// Type returns the encoding type identifier for the VMware cursor
+// pseudo-encoding.
+func (*VMwareCursorPseudoEncoding) Type() int32 {
+	return rfb.PseudoEncodingVMwareCursor
+}
+
+// IsPseudo returns true indicating this is a pseudo-encoding.
+func (*VMwareCursorPseudoEncoding) IsPseudo() bool {
+	return true
+}
+
+// Read decodes a cursor shape. The rectangle's position is the hotspot and
+// its size the size of the cursor. The payload starts with the cursor type
+// and a padding byte; classic cursors follow with an AND mask and an XOR mask
+// of one pixel per cursor pixel each, and alpha cursors with four bytes per
+// pixel, regardless of the pixel format.
+func (*VMwareCursorPseudoEncoding) Read(c *ClientConn, rect *Rectangle, r io.Reader) (Encoding, error) {
+	cursor := &VMwareCursorPseudoEncoding{
+		Width:    rect.Width,
+		Height:   rect.Height,
+		HotspotX: rect.X,
+		HotspotY: rect.Y,
+	}
+
+	var header [2]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, encodingError("VMwareCursorPseudoEncoding.Read", "failed to read cursor type", err)
+	}
+	cursor.CursorType = header[0]
+
+	if rect.Width > maxCursorSize || rect.Height > maxCursorSize {
+		return nil, encodingError("VMwareCursorPseudoEncoding.Read", "cursor dimensions too large", nil)
+	}
+	pixels := int(rect.Width) * int(rect.Height)
+
+	switch cursor.CursorType {
+	case VMwareCursorClassic:
+		pr := c.pixelReader()
+		size := pixels * pr.BytesPerPixel()
+		masks, err := readBytes(r, "cursor masks", 2*size)
+		if err != nil {
+			return nil, encodingError("VMwareCursorPseudoEncoding.Read", "failed to read cursor masks", err)
+		}
+		cursor.Pixels = classicCursorPixels(pr, masks[:size], masks[size:])
+	case VMwareCursorAlpha:
+		data, err := readBytes(r, "cursor pixels", pixels*4)
+		if err != nil {
+			return nil, encodingError("VMwareCursorPseudoEncoding.Read", "failed to read cursor pixel data", err)
+		}
+		for i := 0; i < len(data); i += 4 {
+			a := uint16(data[i+3])
+			data[i] = uint8(uint16(data[i]) * a / 0xff)     // #nosec G115 - At most 0xff
+			data[i+1] = uint8(uint16(data[i+1]) * a / 0xff) // #nosec G115 - At most 0xff
+			data[i+2] = uint8(uint16(data[i+2]) * a / 0xff) // #nosec G115 - At most 0xff
+		}
+		cursor.Pixels = data
+	default:
+		return nil, unsupportedError("VMwareCursorPseudoEncoding.Read",
+			fmt.Sprintf("unsupported VMware cursor type %d", cursor.CursorType), nil)
+	}
+
+	return cursor, nil
+}
+
+// classicCursorPixels combines the AND and XOR masks of a classic cursor into
+// premultiplied RGBA. A clear AND pixel shows the XOR color; a set one shows
+// the screen, which is transparent when the XOR pixel is clear and inverted,
+// drawn as black, otherwise.
+func classicCursorPixels(pr *PixelReader, andMask, xorMask []uint8) []uint8 {
+	pf := &pr.pixelFormat
+	bpp := pr.BytesPerPixel()
+	full := uint32(1)<<pf.Depth - 1
+	if pf.TrueColor {
+		full = uint32(pf.RedMax)<<pf.RedShift | uint32(pf.GreenMax)<<pf.GreenShift | uint32(pf.BlueMax)<<pf.BlueShift
+	}
+
+	out := make([]uint8, len(andMask)/bpp*4)
+	for i := 0; i < len(andMask); i += bpp {
+		and := pr.bytesToPixel(andMask[i:i+bpp]) & full
+		xor := pr.bytesToPixel(xorMask[i:i+bpp]) & full
+		px := out[i/bpp*4 : i/bpp*4+4]
+		switch {
+		case and == 0:
+			rgba := pixelRGBA(pf, pr.pixelToColor(xor))
+			px[0], px[1], px[2], px[3] = rgba.R, rgba.G, rgba.B, 0xff
+		case and == full && xor == 0:
+			// Transparent.
+		default:
+			px[3] = 0xff
+		}
+	}
+	return out
+}
+
+// Image returns the cursor as a CursorImage. The image shares Pixels.
+func (cursor *VMwareCursorPseudoEncoding) Image() *CursorImage {
+	img := &image.RGBA{}
+	if len(cursor.Pixels) > 0 {
+		img = &image.RGBA{
+			Pix:    cursor.Pixels,
+			Stride: int(cursor.Width) * 4,
+			Rect:   image.Rect(0, 0, int(cursor.Width), int(cursor.Height)),
+		}
+	}
+	return &CursorImage{
+		Image:   img,
+		Hotspot: image.Pt(int(cursor.HotspotX), int(cursor.HotspotY)),
+	}
+}
+
+// Handle records the cursor shape for ClientConn.Cursor.
+func (cursor *VMwareCursorPseudoEncoding) Handle(c *ClientConn, _ *Rectangle) error {
+	// The decoded message is handed to the application, so the recorded
+	// shape must not share its pixels.
+	c.setCursor(cursor.Image().clone())
+
+	c.logger.Debug("VMware cursor updated",
+		Field{Key: "type", Value: cursor.CursorType},
+		Field{Key: "width", Value: cursor.Width},
+		Field{Key: "height", Value: cursor.Height})
+	return nil
+}
+
+// VMwareCursorStatePseudoEncoding represents the cursor state pseudo-encoding
+// of VMware consoles, with which the server reports whether the cursor is
+// shown and how the guest positions it.
+type VMwareCursorStatePseudoEncoding struct {
+	// Flags combines VMwareCursorVisible, VMwareCursorAbsolute, and
+	// VMwareCursorWarped.
+	Flags uint16
+}
+
+// Type returns the encoding type identifier for the VMware cursor state
+// pseudo-encoding.
+func (*VMwareCursorStatePseudoEncoding) Type() int32 {
+	return rfb.PseudoEncodingVMwareCursorState
+}
+
+// IsPseudo returns true indicating this is a pseudo-encoding.
+func (*VMwareCursorStatePseudoEncoding) IsPseudo() bool {
+	return true
+}
+
+// Read decodes the cursor state, a 16-bit set of flags.
+func (*VMwareCursorStatePseudoEncoding) Read(_ *ClientConn, _ *Rectangle, r io.Reader) (Encoding, error) {
+	var state [2]byte
+	fields, err := readFields(r, "cursor state", state[:])
+	if err != nil {
+		return nil, encodingError("VMwareCursorStatePseudoEncoding.Read", "failed to read cursor state", err)
+	}
+	return &VMwareCursorStatePseudoEncoding{Flags: fields.uint16()}, nil
+}
+
+// Visible reports whether the cursor is shown.
+func (e *VMwareCursorStatePseudoEncoding) Visible() bool {
+	return e.Flags&VMwareCursorVisible != 0
+}
+
+// Handle logs the cursor state, which reaches the application in the
+// FramebufferUpdateMessage.
+func (e *VMwareCursorStatePseudoEncoding) Handle(c *ClientConn, _ *Rectangle) error {
+	c.logger.Debug("VMware cursor state changed",
+		Field{Key: "flags", Value: fmt.Sprintf("%#x", e.Flags)})
+	return nil
+}
+
+// VMwareCursorPositionPseudoEncoding represents the cursor position
+// pseudo-encoding of VMware consoles, with which the server reports that the
+// guest moved the pointer. The position is that of the rectangle.
+type VMwareCursorPositionPseudoEncoding struct {
+	// X and Y are the position of the pointer on the desktop.
+	X, Y uint16
+}
+
+// Type returns the encoding type identifier for the VMware cursor position
+// pseudo-encoding.
+func (*VMwareCursorPositionPseudoEncoding) Type() int32 {
+	return rfb.PseudoEncodingVMwareCursorPosition
+}
+
+// IsPseudo returns true indicating this is a pseudo-encoding.
+func (*VMwareCursorPositionPseudoEncoding) IsPseudo() bool {
+	return true
+}
+
+// Read decodes the cursor position, which carries no payload.
+func (*VMwareCursorPositionPseudoEncoding) Read(_ *ClientConn, rect *Rectangle, _ io.Reader) (Encoding, error) {
+	return &VMwareCursorPositionPseudoEncoding{X: rect.X, Y: rect.Y}, nil
+}
+
+// Handle logs the cursor position, which reaches the application in the
+// FramebufferUpdateMessage.
+func (e *VMwareCursorPositionPseudoEncoding) Handle(c *ClientConn, _ *Rectangle) error {
+	c.logger.Debug("VMware cursor moved",
+		Field{Key: "x", Value: e.X},
+		Field{Key: "y", Value: e.Y})
+	return nil
+}
@@ -0,0 +1,86 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package vnc
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/tenthirtyam/go-vnc/rfb"
+)
+
+func TestVMwareCursor_Shapes(t *testing.T) {
+	s := &replayStream{}
+	s.write(uint8(0), uint8(0), uint16(4))
+
+	// A classic cursor whose hotspot lies outside the 4x4 desktop: a red
+	// pixel, a transparent one, and an inverting one.
+	s.rect(5, 7, 3, 1, rfb.PseudoEncodingVMwareCursor).write(VMwareCursorClassic, uint8(0))
+	s.write([]byte{0, 0, 0, 0, 0xff, 0xff, 0xff, 0, 0xff, 0xff, 0xff, 0})
+	s.write([]byte{0, 0, 0xff, 0, 0, 0, 0, 0, 0xff, 0xff, 0xff, 0})
+
+	// An alpha cursor with a half-transparent white pixel.
+	s.rect(1, 0, 1, 1, rfb.PseudoEncodingVMwareCursor).write(VMwareCursorAlpha, uint8(0))
+	s.write([]byte{0xff, 0xff, 0xff, 0x80})
+
+	s.rect(0, 0, 0, 0, rfb.PseudoEncodingVMwareCursorState).write(VMwareCursorVisible | VMwareCursorAbsolute)
+	s.rect(3, 2, 0, 0, rfb.PseudoEncodingVMwareCursorPosition)
+
+	c, msgs := runReplay(t, replayCase{
+		handshake: replayHandshake(4, 4, "esxi"),
+		messages:  s.bytes(),
+		encodings: []Encoding{&VMwareCursorPseudoEncoding{}, &VMwareCursorStatePseudoEncoding{},
+			&VMwareCursorPositionPseudoEncoding{}, &RawEncoding{}},
+		wantMessages: []string{"update"},
+	})
+	rects := msgs[0].(*FramebufferUpdateMessage).Rectangles
+
+	classic, ok := rects[0].Enc.(*VMwareCursorPseudoEncoding)
+	if !ok {
+		t.Fatalf("first rectangle decoded as %T", rects[0].Enc)
+	}
+	img := classic.Image()
+	if img.Hotspot != image.Pt(5, 7) || img.Image.Bounds() != image.Rect(0, 0, 3, 1) {
+		t.Errorf("classic Image() = hotspot %v, bounds %v; want (5,7) and 3x1", img.Hotspot, img.Image.Bounds())
+	}
+	for x, want := range []color.RGBA{{R: 0xff, A: 0xff}, {}, {A: 0xff}} {
+		if got := img.Image.RGBAAt(x, 0); got != want {
+			t.Errorf("classic pixel %d = %v, want %v", x, got, want)
+		}
+	}
+
+	alpha := rects[1].Enc.(*VMwareCursorPseudoEncoding)
+	if got := alpha.Image().Image.RGBAAt(0, 0); got != (color.RGBA{R: 0x80, G: 0x80, B: 0x80, A: 0x80}) {
+		t.Errorf("alpha pixel = %v, want premultiplied half-transparent white", got)
+	}
+	if cursor := c.Cursor(); cursor == nil || cursor.Image.Bounds() != image.Rect(0, 0, 1, 1) {
+		t.Errorf("Cursor() = %+v, want the alpha cursor", cursor)
+	}
+
+	if state, ok := rects[2].Enc.(*VMwareCursorStatePseudoEncoding); !ok || !state.Visible() ||
+		state.Flags != VMwareCursorVisible|VMwareCursorAbsolute {
+		t.Errorf("third rectangle = %#v, want a visible absolute cursor state", rects[2].Enc)
+	}
+	if pos, ok := rects[3].Enc.(*VMwareCursorPositionPseudoEncoding); !ok || pos.X != 3 || pos.Y != 2 {
+		t.Errorf("fourth rectangle = %#v, want the position (3,2)", rects[3].Enc)
+	}
+}
+
+func TestVMwareCursor_UnsupportedType(t *testing.T) {
+	rect := &Rectangle{Width: 1, Height: 1}
+	r := bytes.NewReader([]byte{2, 0})
+	if _, err := (&VMwareCursorPseudoEncoding{}).Read(&ClientConn{logger: &NoOpLogger{}}, rect, r); !IsVNCError(err, ErrUnsupported) {
+		t.Errorf("Read() of cursor type 2 error = %v, want an unsupported error", err)
+	}
+}
+
+func TestVMwareCursor_TruncatedType(t *testing.T) {
+	rect := &Rectangle{Width: 1, Height: 1}
+	r := bytes.NewReader([]byte{0})
+	if _, err := (&VMwareCursorPseudoEncoding{}).Read(&ClientConn{logger: &NoOpLogger{}}, rect, r); !IsVNCError(err, ErrEncoding) {
+		t.Errorf("Read() of a truncated cursor type error = %v, want an encoding error", err)
+	}
+}
@@ -0,0 +1,122 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package vnc
+
+import (
+	"image"
+	"image/color"
+	"io"
+
+	"github.com/tenthirtyam/go-vnc/rfb"
+)
+
+// XCursorPseudoEncoding represents the X Cursor pseudo-encoding, which
+// describes the cursor as two colors and a bitmap choosing between them, in
+// the manner of X11 cursors. Unlike CursorPseudoEncoding it does not depend on
+// the pixel format, and servers such as x11vnc offer it to clients that
+// request it instead of the Cursor pseudo-encoding.
+//
+// Once handled, the shape is available from ClientConn.Cursor.
+type XCursorPseudoEncoding struct {
+	// Width and Height are the size of the cursor in pixels. Both are zero
+	// when the cursor is hidden.
+	Width, Height uint16
+
+	// HotspotX and HotspotY are the position of the pointer within the
+	// cursor.
+	HotspotX, HotspotY uint16
+
+	// Foreground is the color of the pixels set in Bitmap, and Background
+	// the color of the others.
+	Foreground, Background color.RGBA
+
+	// Bitmap selects the color of each pixel, one bit per pixel with rows
+	// padded to a whole byte, most significant bit first.
+	Bitmap []uint8
+
+	// Mask has the layout of Bitmap; its set bits are the visible pixels.
+	Mask []uint8
+}
+
+// Type returns the encoding type identifier for the X Cursor pseudo-encoding.
+func (*XCursorPseudoEncoding) Type() int32 {
+	return rfb.PseudoEncodingXCursor
+}
+
+// IsPseudo returns true indicating this is a pseudo-encoding.
+func (*XCursorPseudoEncoding) IsPseudo() bool {
+	return true
+}
+
+// Read decodes an X cursor shape. The rectangle's position is the hotspot and
+// its size the size of the cursor; an empty rectangle hides the cursor and
+// carries no payload.
+func (*XCursorPseudoEncoding) Read(_ *ClientConn, rect *Rectangle, r io.Reader) (Encoding, error) {
+	cursor := &XCursorPseudoEncoding{
+		Width:    rect.Width,
+		Height:   rect.Height,
+		HotspotX: rect.X,
+		HotspotY: rect.Y,
+	}
+
+	if rect.Width == 0 || rect.Height == 0 {
+		return cursor, nil
+	}
+	if rect.Width > maxCursorSize || rect.Height > maxCursorSize {
+		return nil, encodingError("XCursorPseudoEncoding.Read", "cursor dimensions too large", nil)
+	}
+
+	var colors [6]uint8
+	if _, err := io.ReadFull(r, colors[:]); err != nil {
+		return nil, encodingError("XCursorPseudoEncoding.Read", "failed to read cursor colors", err)
+	}
+	cursor.Foreground = color.RGBA{R: colors[0], G: colors[1], B: colors[2], A: 0xff}
+	cursor.Background = color.RGBA{R: colors[3], G: colors[4], B: colors[5], A: 0xff}
+
+	size := calculateMaskDataSize(rect.Width, rect.Height)
+	data := make([]uint8, 2*size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, encodingError("XCursorPseudoEncoding.Read", "failed to read cursor bitmap and mask", err)
+	}
+	cursor.Bitmap, cursor.Mask = data[:size:size], data[size:]
+
+	return cursor, nil
+}
+
+// Image returns the cursor as a CursorImage. Pixels outside the mask are
+// transparent.
+func (cursor *XCursorPseudoEncoding) Image() *CursorImage {
+	img := image.NewRGBA(image.Rect(0, 0, int(cursor.Width), int(cursor.Height)))
+	stride := (int(cursor.Width) + 7) / 8
+	if len(cursor.Bitmap) >= stride*int(cursor.Height) && len(cursor.Mask) >= stride*int(cursor.Height) {
+		for y := range int(cursor.Height) {
+			for x := range int(cursor.Width) {
+				i, bit := y*stride+x/8, uint8(0x80>>(x%8))
+				switch {
+				case cursor.Mask[i]&bit == 0:
+				case cursor.Bitmap[i]&bit != 0:
+					img.SetRGBA(x, y, cursor.Foreground)
+				default:
+					img.SetRGBA(x, y, cursor.Background)
+				}
+			}
+		}
+	}
+	return &CursorImage{
+		Image:   img,
+		Hotspot: image.Pt(int(cursor.HotspotX), int(cursor.HotspotY)),
+	}
+}
+
+// Handle records the cursor shape for ClientConn.Cursor.
+func (cursor *XCursorPseudoEncoding) Handle(c *ClientConn, _ *Rectangle) error {
+	c.setCursor(cursor.Image())
+
+	c.logger.Debug("X cursor updated",
+		Field{Key: "width", Value: cursor.Width},
+		Field{Key: "height", Value: cursor.Height},
+		Field{Key: "hotspot_x", Value: cursor.HotspotX},
+		Field{Key: "hotspot_y", Value: cursor.HotspotY})
+	return nil
+}
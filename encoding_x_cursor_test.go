@@ -0,0 +1,63 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package vnc
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/tenthirtyam/go-vnc/rfb"
+)
+
+func TestXCursor_Shape(t *testing.T) {
+	s := &replayStream{}
+	s.write(uint8(0), uint8(0), uint16(1))
+	s.rect(2, 1, 3, 2, rfb.PseudoEncodingXCursor)
+	s.write([]byte{0xff, 0xff, 0xff, 0, 0, 0})
+	// Bitmap: white, black, white / black, white, black.
+	s.write([]byte{0b10100000, 0b01000000})
+	// Mask: the last pixel of the second row is transparent.
+	s.write([]byte{0b11100000, 0b11000000})
+
+	// The cursor stream continues after the shape.
+	s.write(uint8(0), uint8(0), uint16(1))
+	s.rect(0, 0, 1, 1, 0)
+	s.pixel(0, 0, 0xff)
+
+	c, _ := runReplay(t, replayCase{
+		handshake:    replayHandshake(4, 4, "xcursor"),
+		messages:     s.bytes(),
+		encodings:    []Encoding{&XCursorPseudoEncoding{}, &RawEncoding{}},
+		wantMessages: []string{"cursor", "update"},
+	})
+
+	cursor := c.Cursor()
+	if cursor == nil || cursor.Hotspot != image.Pt(2, 1) || cursor.Image.Bounds() != image.Rect(0, 0, 3, 2) {
+		t.Fatalf("Cursor() = %+v, want a 3x2 cursor with hotspot (2,1)", cursor)
+	}
+	white, black := color.RGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff}, color.RGBA{A: 0xff}
+	want := [2][3]color.RGBA{{white, black, white}, {black, white, {}}}
+	for y, row := range want {
+		for x, px := range row {
+			if got := cursor.Image.RGBAAt(x, y); got != px {
+				t.Errorf("pixel (%d,%d) = %v, want %v", x, y, got, px)
+			}
+		}
+	}
+}
+
+func TestXCursor_Hidden(t *testing.T) {
+	c := &ClientConn{logger: &NoOpLogger{}}
+	enc, err := (&XCursorPseudoEncoding{}).Read(c, &Rectangle{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.(*XCursorPseudoEncoding).Handle(c, &Rectangle{}); err != nil {
+		t.Fatal(err)
+	}
+	if cursor := c.Cursor(); cursor == nil || !cursor.Hidden() {
+		t.Errorf("Cursor() = %+v, want a hidden cursor", cursor)
+	}
+}
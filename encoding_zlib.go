@@ -0,0 +1,76 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+//go:build !vnc_minimal
+
+package vnc
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/tenthirtyam/go-vnc/rfb"
+)
+
+// zlibMaxLength bounds the compressed length of a single Zlib rectangle.
+const zlibMaxLength = 64 << 20
+
+// ZlibEncoding represents the Zlib encoding (type 6), preferred by x11vnc,
+// UltraVNC, and other older servers. A rectangle holds Raw pixel data
+// compressed with a single zlib stream that persists for the whole session.
+// Because the stream spans rectangles, a session that has received Zlib data
+// cannot be resumed after Detach.
+type ZlibEncoding struct {
+	// Colors contains the decoded pixel data for the rectangle in row-major
+	// order. Components are in the ranges of the session pixel format, as for
+	// RawEncoding.
+	Colors []Color
+}
+
+// Type returns the encoding type identifier for Zlib encoding.
+func (*ZlibEncoding) Type() int32 {
+	return rfb.EncodingZlib
+}
+
+// Read decodes a Zlib rectangle, updating the zlib stream of the connection.
+func (*ZlibEncoding) Read(c *ClientConn, rect *Rectangle, r io.Reader) (Encoding, error) {
+	var header [4]byte
+	fields, err := readFields(r, "data length", header[:])
+	if err != nil {
+		return nil, encodingError("ZlibEncoding.Read", "failed to read data length", err)
+	}
+	length := fields.uint32()
+	if length > zlibMaxLength {
+		return nil, encodingError("ZlibEncoding.Read",
+			fmt.Sprintf("data length %d exceeds maximum %d", length, zlibMaxLength), nil)
+	}
+
+	compressed, err := readBytes(r, "compressed data", int(length))
+	if err != nil {
+		return nil, encodingError("ZlibEncoding.Read", "failed to read compressed data", err)
+	}
+
+	stream := decoderState[zlibStream](c, rfb.EncodingZlib)
+	zr, err := stream.feed(compressed)
+	if err != nil {
+		return nil, encodingError("ZlibEncoding.Read", "failed to start zlib stream", err)
+	}
+
+	pixelReader := c.pixelReader()
+	colors := make([]Color, int(rect.Width)*int(rect.Height))
+	for i := range colors {
+		if colors[i], err = pixelReader.ReadPixelColor(zr); err != nil {
+			// The stream position is lost, so later rectangles cannot be
+			// decoded either.
+			stream.reset()
+			return nil, encodingError("ZlibEncoding.Read", "failed to decompress pixel data", err)
+		}
+	}
+
+	return &ZlibEncoding{Colors: colors}, nil
+}
+
+// paint renders the decoded rectangle into the client framebuffer.
+func (e *ZlibEncoding) paint(fb *framebuffer, rect *Rectangle) {
+	fb.setColors(rect, e.Colors)
+}
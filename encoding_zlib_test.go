@@ -0,0 +1,57 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+//go:build !vnc_minimal
+
+package vnc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"slices"
+	"testing"
+)
+
+func TestZlibEncoding_PersistentStream(t *testing.T) {
+	c := newTightConn(*PixelFormat16BitRGB565)
+	var stream tightDeflater
+
+	rects := []struct {
+		pixels []byte
+		want   []Color
+	}{
+		{[]byte{0x1f, 0x00, 0xe0, 0x07}, []Color{{B: 31}, {G: 63}}},
+		{[]byte{0x00, 0xf8, 0xff, 0xff}, []Color{{R: 31}, {R: 31, G: 63, B: 31}}},
+	}
+
+	// The second rectangle only decodes if the inflater kept the state of
+	// the first.
+	for i, rect := range rects {
+		compressed := stream.deflate(t, rect.pixels)
+		data := append(binary.BigEndian.AppendUint32(nil, uint32(len(compressed))), compressed...)
+
+		enc, err := (&ZlibEncoding{}).Read(c, &Rectangle{Width: 2, Height: 1}, bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("rectangle %d: Read() error = %v", i, err)
+		}
+		if got := enc.(*ZlibEncoding).Colors; !slices.Equal(got, rect.want) {
+			t.Errorf("rectangle %d: colors = %v, want %v", i, got, rect.want)
+		}
+	}
+}
+
+func TestZlibEncoding_Invalid(t *testing.T) {
+	tests := map[string][]byte{
+		"Length":    binary.BigEndian.AppendUint32(nil, zlibMaxLength+1),
+		"Truncated": {0, 0, 0, 8, 0x78},
+		"Corrupt":   {0, 0, 0, 4, 0xde, 0xad, 0xbe, 0xef},
+	}
+	for name, data := range tests {
+		t.Run(name, func(t *testing.T) {
+			c := newTightConn(*PixelFormat32BitRGBA)
+			if _, err := (&ZlibEncoding{}).Read(c, &Rectangle{Width: 2, Height: 2}, bytes.NewReader(data)); err == nil {
+				t.Error("Read() accepted invalid data")
+			}
+		})
+	}
+}
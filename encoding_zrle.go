@@ -0,0 +1,585 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+//go:build !vnc_minimal
+
+package vnc
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/tenthirtyam/go-vnc/rfb"
+)
+
+const (
+	// zrleTileSize is the edge length of the tiles a ZRLE rectangle is split
+	// into.
+	zrleTileSize = 64
+
+	// zrleMaxLength bounds the compressed length of a single rectangle.
+	zrleMaxLength = 64 << 20
+
+	// zrleMaxPalette is the largest palette of a palette RLE tile.
+	zrleMaxPalette = 127
+)
+
+// ZRLE and TRLE tile subencodings. Values from 2 to 16 are packed palettes,
+// values from 130 to 255 are palette RLE, and the rest are unused apart from
+// the two TRLE subencodings that reuse the palette of the previous tile.
+const (
+	zrleRaw             = 0
+	zrleSolid           = 1
+	zrleMaxPacked       = 16
+	trleReusePacked     = 127
+	zrlePlainRLE        = 128
+	trleReusePaletteRLE = 129
+	zrleMinPalette      = 130
+)
+
+// ZRLEEncoding represents the ZRLE encoding (type 16) defined in RFC 6143
+// Section 7.7.6, implemented by RealVNC, TigerVNC, QEMU, and most other
+// current servers. A rectangle is split into 64x64 tiles, each sent raw, as a
+// solid color, as packed palette indexes, or run-length encoded, and the
+// result is compressed with a single zlib stream that persists for the whole
+// session. Because the stream spans rectangles, a session that has received
+// ZRLE data cannot be resumed after Detach.
+//
+// To encode, keep one ZRLEEncoding per viewer: it holds the zlib stream of the
+// rectangles it has encoded, which the viewer's stream must match, and is not
+// safe for concurrent use.
+type ZRLEEncoding struct {
+	// Colors contains the decoded pixel data for the rectangle in row-major
+	// order. Components are in the ranges of the session pixel format, as for
+	// RawEncoding.
+	Colors []Color
+
+	// stream compresses the rectangles produced by Encode.
+	stream *zrleWriter
+}
+
+// zrleWriter is the zlib stream of an encoding session.
+type zrleWriter struct {
+	out bytes.Buffer
+	zw  *zlib.Writer
+}
+
+// Type returns the encoding type identifier for ZRLE encoding.
+func (*ZRLEEncoding) Type() int32 {
+	return rfb.EncodingZRLE
+}
+
+// zrlePixels converts CPIXELs, the compressed pixel representation of ZRLE.
+// 32-bit true color pixels with a depth of 24 or less whose color bits all
+// fit in the three least or most significant bytes are sent as those three
+// bytes; other pixels are sent whole.
+type zrlePixels struct {
+	reader *PixelReader
+	size   int
+
+	// pad is the offset of the omitted byte within a 32-bit pixel.
+	pad int
+}
+
+// newZRLEPixels returns the CPIXEL representation for the session pixel
+// format.
+func newZRLEPixels(c *ClientConn) zrlePixels {
+	pr := c.pixelReader()
+	p := zrlePixels{reader: pr}
+	p.size, p.pad = zrleCPixelLayout(&pr.pixelFormat, pr.byteOrder == binary.BigEndian)
+	return p
+}
+
+// zrleCPixelLayout returns the size of a CPIXEL in pf and, for 3-byte
+// CPIXELs, the offset of the byte of the full pixel that is left out.
+func zrleCPixelLayout(pf *PixelFormat, bigEndian bool) (size, pad int) {
+	size = int(pf.BPP / 8)
+	if !pf.TrueColor || pf.BPP != 32 || pf.Depth > 24 {
+		return size, 0
+	}
+
+	used := uint32(pf.RedMax)<<pf.RedShift | uint32(pf.GreenMax)<<pf.GreenShift | uint32(pf.BlueMax)<<pf.BlueShift
+	switch {
+	case used&0xff000000 == 0:
+		// The least significant byte is last on the wire in big-endian order.
+		if bigEndian {
+			return 3, 0
+		}
+		return 3, 3
+	case used&0x000000ff == 0:
+		if bigEndian {
+			return 3, 3
+		}
+		return 3, 0
+	}
+	return size, 0
+}
+
+// read reads one CPIXEL.
+func (p zrlePixels) read(r io.Reader) (Color, error) {
+	var buf [4]byte
+	if p.size == 3 {
+		cpixel := buf[:3]
+		if p.pad == 0 {
+			cpixel = buf[1:]
+		}
+		if _, err := io.ReadFull(r, cpixel); err != nil {
+			return Color{}, err
+		}
+		return p.reader.pixelToColor(p.reader.bytesToPixel(buf[:])), nil
+	}
+
+	if _, err := io.ReadFull(r, buf[:p.size]); err != nil {
+		return Color{}, err
+	}
+	return p.reader.pixelToColor(p.reader.bytesToPixel(buf[:p.size])), nil
+}
+
+// readPalette reads size CPIXELs.
+func (p zrlePixels) readPalette(r io.Reader, size int) ([]Color, error) {
+	palette := make([]Color, size)
+	for i := range palette {
+		var err error
+		if palette[i], err = p.read(r); err != nil {
+			return nil, err
+		}
+	}
+	return palette, nil
+}
+
+// Read decodes a ZRLE rectangle, updating the zlib stream of the connection.
+func (*ZRLEEncoding) Read(c *ClientConn, rect *Rectangle, r io.Reader) (Encoding, error) {
+	var header [4]byte
+	fields, err := readFields(r, "data length", header[:])
+	if err != nil {
+		return nil, encodingError("ZRLEEncoding.Read", "failed to read data length", err)
+	}
+	length := fields.uint32()
+	if length > zrleMaxLength {
+		return nil, encodingError("ZRLEEncoding.Read",
+			fmt.Sprintf("data length %d exceeds maximum %d", length, zrleMaxLength), nil)
+	}
+
+	compressed, err := readBytes(r, "compressed data", int(length))
+	if err != nil {
+		return nil, encodingError("ZRLEEncoding.Read", "failed to read compressed data", err)
+	}
+
+	stream := decoderState[zlibStream](c, rfb.EncodingZRLE)
+	zr, err := stream.feed(compressed)
+	if err != nil {
+		return nil, encodingError("ZRLEEncoding.Read", "failed to start zlib stream", err)
+	}
+
+	width, height := int(rect.Width), int(rect.Height)
+	colors := make([]Color, width*height)
+	pixels := newZRLEPixels(c)
+
+	for ty := 0; ty < height; ty += zrleTileSize {
+		for tx := 0; tx < width; tx += zrleTileSize {
+			tile := zrleTile{
+				colors: colors,
+				stride: width,
+				x:      tx,
+				y:      ty,
+				width:  min(zrleTileSize, width-tx),
+				height: min(zrleTileSize, height-ty),
+			}
+			if err := tile.read(pixels, zr, nil); err != nil {
+				// The stream position is lost, so later rectangles cannot
+				// be decoded either.
+				stream.reset()
+				return nil, encodingError("ZRLEEncoding.Read",
+					fmt.Sprintf("failed to decode tile at %d,%d", tx, ty), err)
+			}
+		}
+	}
+
+	return &ZRLEEncoding{Colors: colors}, nil
+}
+
+// Encode returns the rectangle as ZRLE data, continuing the zlib stream of
+// the rectangles e encoded before. Each tile is sent in whichever of the
+// solid, packed palette, palette RLE, plain RLE, and raw forms is smallest.
+func (e *ZRLEEncoding) Encode(pf *PixelFormat, rect *Rectangle, pixels []Color) ([]byte, error) {
+	pw, err := newPixelWriter("ZRLEEncoding.Encode", pf, rect, pixels)
+	if err != nil {
+		return nil, err
+	}
+
+	enc := zrleEncoder{pw: pw}
+	enc.cpixelSize, enc.pad = zrleCPixelLayout(pf, pf.BigEndian)
+	width, height := int(rect.Width), int(rect.Height)
+	for ty := 0; ty < height; ty += zrleTileSize {
+		for tx := 0; tx < width; tx += zrleTileSize {
+			enc.tile(pixelArea{
+				pixels: pixels,
+				stride: width,
+				x:      tx,
+				y:      ty,
+				width:  min(zrleTileSize, width-tx),
+				height: min(zrleTileSize, height-ty),
+			})
+		}
+	}
+
+	if e.stream == nil {
+		e.stream = &zrleWriter{}
+		e.stream.zw = zlib.NewWriter(&e.stream.out)
+	}
+	e.stream.out.Reset()
+	if _, err := e.stream.zw.Write(enc.buf); err != nil {
+		return nil, encodingError("ZRLEEncoding.Encode", "failed to compress tiles", err)
+	}
+	if err := e.stream.zw.Flush(); err != nil {
+		return nil, encodingError("ZRLEEncoding.Encode", "failed to flush zlib stream", err)
+	}
+
+	compressed := e.stream.out.Bytes()
+	buf := make([]byte, 0, 4+len(compressed))
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(compressed))) // #nosec G115 - Bounded by the rectangle size
+	return append(buf, compressed...), nil
+}
+
+// zrleEncoder builds the uncompressed tiles of a ZRLE rectangle.
+type zrleEncoder struct {
+	pw         pixelWriter
+	cpixelSize int
+	pad        int
+	buf        []byte
+}
+
+// zrleRun is a run of pixels of one color.
+type zrleRun struct {
+	color  Color
+	length int
+}
+
+// cpixel appends a color as one CPIXEL.
+func (z *zrleEncoder) cpixel(c Color) {
+	if z.cpixelSize != 3 {
+		z.buf = z.pw.append(z.buf, c)
+		return
+	}
+	var full [4]byte
+	z.pw.append(full[:0], c)
+	if z.pad == 0 {
+		z.buf = append(z.buf, full[1:]...)
+	} else {
+		z.buf = append(z.buf, full[:3]...)
+	}
+}
+
+// runLength appends the length of a run.
+func (z *zrleEncoder) runLength(length int) {
+	for length--; length >= 255; length -= 255 {
+		z.buf = append(z.buf, 255)
+	}
+	z.buf = append(z.buf, uint8(length)) // #nosec G115 - Below 255 after the loop
+}
+
+// tile appends the smallest encoding of a tile.
+func (z *zrleEncoder) tile(t pixelArea) {
+	var (
+		runs    []zrleRun
+		palette []Color
+		index   = make(map[Color]int)
+	)
+	for y := 0; y < t.height; y++ {
+		for x := 0; x < t.width; x++ {
+			c := t.at(x, y)
+			if n := len(runs); n > 0 && runs[n-1].color == c {
+				runs[n-1].length++
+			} else {
+				runs = append(runs, zrleRun{color: c, length: 1})
+			}
+			if _, ok := index[c]; !ok && len(palette) <= zrleMaxPalette {
+				index[c] = len(palette)
+				palette = append(palette, c)
+			}
+		}
+	}
+
+	if len(palette) == 1 {
+		z.buf = append(z.buf, zrleSolid)
+		z.cpixel(palette[0])
+		return
+	}
+
+	count := t.width * t.height
+	runBytes := func(length int) int { return (length-1)/255 + 1 }
+	sub, size := zrleRaw, count*z.cpixelSize
+
+	plainRLE := 0
+	for _, run := range runs {
+		plainRLE += z.cpixelSize + runBytes(run.length)
+	}
+	if plainRLE < size {
+		sub, size = zrlePlainRLE, plainRLE
+	}
+
+	// The palette stops growing once it exceeds zrleMaxPalette colors.
+	if len(palette) <= zrleMaxPalette {
+		paletteRLE := len(palette) * z.cpixelSize
+		for _, run := range runs {
+			paletteRLE++
+			if run.length > 1 {
+				paletteRLE += runBytes(run.length)
+			}
+		}
+		if paletteRLE < size {
+			sub, size = zrlePlainRLE+len(palette), paletteRLE
+		}
+	}
+	if len(palette) <= zrleMaxPacked {
+		packed := len(palette)*z.cpixelSize + t.height*((t.width*zrlePackedBits(len(palette))+7)/8)
+		if packed < size {
+			sub = len(palette)
+		}
+	}
+
+	z.buf = append(z.buf, uint8(sub)) // #nosec G115 - Subencodings are below 256
+	switch {
+	case sub == zrleRaw:
+		for y := 0; y < t.height; y++ {
+			for x := 0; x < t.width; x++ {
+				z.cpixel(t.at(x, y))
+			}
+		}
+
+	case sub == zrlePlainRLE:
+		for _, run := range runs {
+			z.cpixel(run.color)
+			z.runLength(run.length)
+		}
+
+	case sub > zrlePlainRLE:
+		for _, c := range palette {
+			z.cpixel(c)
+		}
+		for _, run := range runs {
+			if run.length == 1 {
+				z.buf = append(z.buf, uint8(index[run.color])) // #nosec G115 - Palette indexes are below 127
+				continue
+			}
+			z.buf = append(z.buf, uint8(index[run.color])|0x80) // #nosec G115 - Palette indexes are below 127
+			z.runLength(run.length)
+		}
+
+	default:
+		for _, c := range palette {
+			z.cpixel(c)
+		}
+		bits := zrlePackedBits(len(palette))
+		for y := 0; y < t.height; y++ {
+			row := make([]byte, (t.width*bits+7)/8)
+			for x := 0; x < t.width; x++ {
+				bit := x * bits
+				row[bit/8] |= uint8(index[t.at(x, y)] << (8 - bits - bit%8)) // #nosec G115 - Indexes fit in bits
+			}
+			z.buf = append(z.buf, row...)
+		}
+	}
+}
+
+// zrleTile is a ZRLE or TRLE tile being decoded into the pixels of its
+// rectangle.
+type zrleTile struct {
+	colors        []Color
+	stride        int
+	x, y          int
+	width, height int
+}
+
+// set stores the color of the i-th pixel of the tile.
+func (t *zrleTile) set(i int, color Color) {
+	t.colors[(t.y+i/t.width)*t.stride+t.x+i%t.width] = color
+}
+
+// read decodes the tile from the uncompressed stream. For TRLE, palette holds
+// the palette of the previous tile and is updated with the one of this tile;
+// for ZRLE it is nil and the subencodings reusing it are rejected.
+func (t *zrleTile) read(pixels zrlePixels, r io.Reader, palette *[]Color) error {
+	var subencoding [1]byte
+	if _, err := io.ReadFull(r, subencoding[:]); err != nil {
+		return err
+	}
+	count := t.width * t.height
+
+	readPalette := func(size int) ([]Color, error) {
+		colors, err := pixels.readPalette(r, size)
+		if err == nil && palette != nil {
+			*palette = colors
+		}
+		return colors, err
+	}
+	previousPalette := func(sub int) ([]Color, error) {
+		if palette == nil || len(*palette) == 0 {
+			return nil, fmt.Errorf("subencoding %d without a previous palette", sub)
+		}
+		return *palette, nil
+	}
+
+	switch sub := int(subencoding[0]); {
+	case sub == zrleRaw:
+		for i := 0; i < count; i++ {
+			color, err := pixels.read(r)
+			if err != nil {
+				return err
+			}
+			t.set(i, color)
+		}
+		return nil
+
+	case sub == zrleSolid:
+		color, err := pixels.read(r)
+		if err != nil {
+			return err
+		}
+		for i := 0; i < count; i++ {
+			t.set(i, color)
+		}
+		return nil
+
+	case sub <= zrleMaxPacked:
+		colors, err := readPalette(sub)
+		if err != nil {
+			return err
+		}
+		return t.readPacked(colors, r)
+
+	case sub == trleReusePacked:
+		colors, err := previousPalette(sub)
+		if err != nil {
+			return err
+		}
+		return t.readPacked(colors, r)
+
+	case sub == zrlePlainRLE:
+		return t.readRLE(r, func() (Color, bool, error) {
+			color, err := pixels.read(r)
+			return color, true, err
+		})
+
+	case sub >= zrleMinPalette:
+		colors, err := readPalette(sub - zrlePlainRLE)
+		if err != nil {
+			return err
+		}
+		return t.readPaletteRLE(colors, r)
+
+	case sub == trleReusePaletteRLE:
+		colors, err := previousPalette(sub)
+		if err != nil {
+			return err
+		}
+		return t.readPaletteRLE(colors, r)
+
+	default:
+		return fmt.Errorf("unused subencoding %d", sub)
+	}
+}
+
+// readPacked decodes palette indexes packed into 1, 2, or 4 bits, most
+// significant first, with each row padded to a byte.
+func (t *zrleTile) readPacked(palette []Color, r io.Reader) error {
+	bits := zrlePackedBits(len(palette))
+	stride := (t.width*bits + 7) / 8
+	row := make([]byte, stride)
+	for y := 0; y < t.height; y++ {
+		if _, err := io.ReadFull(r, row); err != nil {
+			return err
+		}
+		for x := 0; x < t.width; x++ {
+			bit := x * bits
+			index := int(row[bit/8]>>(8-bits-bit%8)) & (1<<bits - 1)
+			if index >= len(palette) {
+				return fmt.Errorf("palette index %d out of range for %d colors", index, len(palette))
+			}
+			t.set(y*t.width+x, palette[index])
+		}
+	}
+	return nil
+}
+
+// zrlePackedBits returns the bits per index of a packed palette of size
+// colors.
+func zrlePackedBits(size int) int {
+	switch {
+	case size == 2:
+		return 1
+	case size <= 4:
+		return 2
+	default:
+		return 4
+	}
+}
+
+// readPaletteRLE decodes runs of palette indexes. The top bit of an index
+// marks a run; without it the index is a single pixel.
+func (t *zrleTile) readPaletteRLE(palette []Color, r io.Reader) error {
+	return t.readRLE(r, func() (Color, bool, error) {
+		var index [1]byte
+		if _, err := io.ReadFull(r, index[:]); err != nil {
+			return Color{}, false, err
+		}
+		i := int(index[0] & 0x7f)
+		if i >= len(palette) {
+			return Color{}, false, fmt.Errorf("palette index %d out of range for %d colors", i, len(palette))
+		}
+		return palette[i], index[0]&0x80 != 0, nil
+	})
+}
+
+// readRLE decodes runs until the tile is full. next reads the color of a run
+// and whether a run length follows it; single pixels have none.
+func (t *zrleTile) readRLE(r io.Reader, next func() (Color, bool, error)) error {
+	count := t.width * t.height
+	for i := 0; i < count; {
+		color, isRun, err := next()
+		if err != nil {
+			return err
+		}
+
+		run := 1
+		if isRun {
+			if run, err = readZRLERunLength(r); err != nil {
+				return err
+			}
+		}
+		if run > count-i {
+			return fmt.Errorf("run of %d pixels overflows the tile", run)
+		}
+		for end := i + run; i < end; i++ {
+			t.set(i, color)
+		}
+	}
+	return nil
+}
+
+// readZRLERunLength reads a run length: bytes of 255 followed by a final byte
+// below 255, whose sum is one less than the length.
+func readZRLERunLength(r io.Reader) (int, error) {
+	length := 1
+	var b [1]byte
+	for {
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			return 0, err
+		}
+		length += int(b[0])
+		if b[0] != 255 {
+			return length, nil
+		}
+		if length > zrleTileSize*zrleTileSize {
+			return 0, fmt.Errorf("run length exceeds the tile size")
+		}
+	}
+}
+
+// paint renders the decoded rectangle into the client framebuffer.
+func (e *ZRLEEncoding) paint(fb *framebuffer, rect *Rectangle) {
+	fb.setColors(rect, e.Colors)
+}
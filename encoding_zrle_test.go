@@ -0,0 +1,167 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+//go:build !vnc_minimal
+
+package vnc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"slices"
+	"testing"
+)
+
+// zrleRect returns a ZRLE rectangle carrying tiles compressed by stream.
+func zrleRect(t *testing.T, stream *tightDeflater, tiles ...[]byte) []byte {
+	t.Helper()
+	compressed := stream.deflate(t, bytes.Join(tiles, nil))
+	return append(binary.BigEndian.AppendUint32(nil, uint32(len(compressed))), compressed...)
+}
+
+func readZRLE(t *testing.T, c *ClientConn, w, h uint16, data []byte) []Color {
+	t.Helper()
+	enc, err := (&ZRLEEncoding{}).Read(c, &Rectangle{Width: w, Height: h}, bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	return enc.(*ZRLEEncoding).Colors
+}
+
+func TestZRLEEncoding_Subencodings(t *testing.T) {
+	c := newTightConn(*PixelFormat32BitRGBA)
+	var stream tightDeflater
+
+	red, green, blue := Color{R: 255}, Color{G: 255}, Color{B: 255}
+	// PixelFormat32BitRGBA is little-endian with the padding in the top byte,
+	// so a CPIXEL is blue, green, red.
+	cRed, cGreen, cBlue := []byte{0, 0, 255}, []byte{0, 255, 0}, []byte{255, 0, 0}
+
+	tests := []struct {
+		name string
+		tile []byte
+		want []Color
+	}{
+		{"Raw", slices.Concat([]byte{zrleRaw}, cRed, cGreen, cBlue, cRed), []Color{red, green, blue, red}},
+		{"Solid", slices.Concat([]byte{zrleSolid}, cGreen), []Color{green, green, green, green}},
+		{"Packed", slices.Concat([]byte{2}, cRed, cBlue, []byte{0b01000000, 0b10000000}), []Color{red, blue, blue, red}},
+		{"PlainRLE", slices.Concat([]byte{zrlePlainRLE}, cRed, []byte{2}, cGreen, []byte{0}), []Color{red, red, red, green}},
+		{"PaletteRLE", slices.Concat([]byte{130}, cRed, cBlue, []byte{0x81, 1, 0, 1}), []Color{blue, blue, red, blue}},
+	}
+
+	// The cases share the zlib stream, as consecutive rectangles do.
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := readZRLE(t, c, 2, 2, zrleRect(t, &stream, tt.tile)); !slices.Equal(got, tt.want) {
+				t.Errorf("colors = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestZRLEEncoding_Tiles(t *testing.T) {
+	c := newTightConn(*PixelFormat16BitRGB565)
+	var stream tightDeflater
+
+	// A 70x65 rectangle has four tiles: 64x64, 6x64, 64x1, and 6x1.
+	tiles := [][]byte{
+		{zrleSolid, 0x1f, 0x00},
+		{zrleSolid, 0xe0, 0x07},
+		{zrleSolid, 0x00, 0xf8},
+		{zrleSolid, 0xff, 0xff},
+	}
+	got := readZRLE(t, c, 70, 65, zrleRect(t, &stream, tiles...))
+
+	checks := map[[2]int]Color{
+		{0, 0}:   {B: 31},
+		{69, 63}: {G: 63},
+		{63, 64}: {R: 31},
+		{69, 64}: {R: 31, G: 63, B: 31},
+	}
+	for pt, want := range checks {
+		if color := got[pt[1]*70+pt[0]]; color != want {
+			t.Errorf("pixel %v = %+v, want %+v", pt, color, want)
+		}
+	}
+}
+
+func TestZRLEEncoding_InvalidTiles(t *testing.T) {
+	tests := map[string][]byte{
+		"UnusedSubencoding": {17},
+		"RunOverflow":       {zrlePlainRLE, 0, 0, 0, 4},
+		"PaletteIndex":      {130, 0, 0, 0, 0, 0, 0, 5},
+	}
+	for name, tile := range tests {
+		t.Run(name, func(t *testing.T) {
+			c := newTightConn(*PixelFormat32BitRGBA)
+			data := zrleRect(t, &tightDeflater{}, tile)
+			if _, err := (&ZRLEEncoding{}).Read(c, &Rectangle{Width: 2, Height: 2}, bytes.NewReader(data)); err == nil {
+				t.Error("Read() accepted an invalid tile")
+			}
+		})
+	}
+}
+
+func TestZRLEEncoding_EncodeRoundTrip(t *testing.T) {
+	const w, h = 150, 70
+	for format, pf := range encodeTestFormats {
+		t.Run(format, func(t *testing.T) {
+			c := newEncodeConn(pf, w, h)
+			enc := &ZRLEEncoding{}
+			rect := &Rectangle{Width: w, Height: h}
+
+			// Consecutive rectangles continue one zlib stream.
+			for i := range 3 {
+				pixels := encodeTestImage(pf, w, h)
+				pixels[i] = Color{G: pf.GreenMax}
+				data, err := enc.Encode(&pf, rect, pixels)
+				if err != nil {
+					t.Fatalf("Encode() error = %v", err)
+				}
+				if got := readZRLE(t, c, w, h, data); !slices.Equal(got, pixels) {
+					t.Fatalf("rectangle %d: decoded pixels differ from the encoded ones", i)
+				}
+			}
+		})
+	}
+}
+
+func TestZRLEEncoding_EncodeSubencodings(t *testing.T) {
+	pf := *PixelFormat32BitRGBA
+	red, blue := Color{R: 255}, Color{B: 255}
+	gradient := make([]Color, 16)
+	for i := range gradient {
+		gradient[i] = Color{R: uint16(i * 16), G: uint16(255 - i*16)}
+	}
+	// Runs of two pixels cycling through 20 colors.
+	stripes := make([]Color, 40)
+	for i := range stripes {
+		stripes[i] = Color{B: uint16(i / 2)}
+	}
+
+	tests := []struct {
+		name   string
+		pixels []Color
+		want   byte
+	}{
+		{"Solid", slices.Repeat([]Color{red}, 16), zrleSolid},
+		{"Packed", slices.Repeat([]Color{red, blue}, 8), 2},
+		{"PlainRLE", slices.Concat(slices.Repeat([]Color{red}, 8), slices.Repeat([]Color{blue}, 8)), zrlePlainRLE},
+		{"PaletteRLE", slices.Repeat(stripes, 10), zrlePlainRLE + 20},
+		{"Raw", gradient, zrleRaw},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			enc := zrleEncoder{pw: pixelWriter{pf: pf, order: binary.LittleEndian, size: 4}}
+			enc.cpixelSize, enc.pad = zrleCPixelLayout(&pf, false)
+			side := 4
+			if len(tt.pixels) > 16 {
+				side = 20
+			}
+			enc.tile(pixelArea{pixels: tt.pixels, stride: side, width: side, height: side})
+			if enc.buf[0] != tt.want {
+				t.Errorf("subencoding = %d, want %d", enc.buf[0], tt.want)
+			}
+		})
+	}
+}
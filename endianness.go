@@ -0,0 +1,186 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package vnc
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+// PixelEndianness selects the byte order used to decode multi-byte pixels.
+type PixelEndianness uint8
+
+const (
+	// PixelEndiannessAuto decodes pixels in the byte order of the negotiated
+	// pixel format. This is the default.
+	PixelEndiannessAuto PixelEndianness = iota
+
+	// PixelEndiannessLittle decodes pixels as little-endian regardless of the
+	// negotiated pixel format.
+	PixelEndiannessLittle
+
+	// PixelEndiannessBig decodes pixels as big-endian regardless of the
+	// negotiated pixel format.
+	PixelEndiannessBig
+)
+
+// String returns the name of the byte order.
+func (e PixelEndianness) String() string {
+	switch e {
+	case PixelEndiannessAuto:
+		return "auto"
+	case PixelEndiannessLittle:
+		return "little-endian"
+	case PixelEndiannessBig:
+		return "big-endian"
+	default:
+		return "unknown"
+	}
+}
+
+// endiannessOf returns the byte order declared by pf.
+func endiannessOf(pf PixelFormat) PixelEndianness {
+	if pf.BigEndian {
+		return PixelEndiannessBig
+	}
+	return PixelEndiannessLittle
+}
+
+// Limits of the endianness detector. It reaches a verdict only after
+// endiannessMinSamples pixels and gives up after endiannessMaxSamples.
+const (
+	endiannessMinSamples = 1024
+	endiannessMaxSamples = 1 << 16
+)
+
+// endiannessProbe detects servers that send pixels in the opposite byte order
+// from the one their pixel format declares. It relies on the padding bits that
+// most true color formats carry, such as the top byte of 32-bit pixels with a
+// depth of 24: in the declared byte order they hold a constant filler (zero or
+// an opaque alpha), while a swapped pixel moves a color component into them,
+// which varies across any real desktop. Formats without padding bits, such as
+// RGB565 and 8-bit formats, cannot be checked.
+//
+// The probe is only used by the goroutine decoding server messages.
+type endiannessProbe struct {
+	ready   bool
+	pf      PixelFormat
+	padding uint32
+	samples int
+	done    bool
+
+	// mismatch is set when the probe concludes that the byte order is wrong,
+	// and reported once it has been logged.
+	mismatch, reported bool
+
+	// Padding bits of the first sample and whether a later sample differed,
+	// as declared and byte swapped.
+	declared, swapped             uint32
+	declaredVaries, swappedVaries bool
+}
+
+// reset prepares the probe for pixels in pf.
+func (p *endiannessProbe) reset(pf PixelFormat) {
+	*p = endiannessProbe{ready: true, pf: pf}
+	if !pf.TrueColor || pf.BPP <= 8 {
+		p.done = true
+		return
+	}
+
+	used := uint32(pf.RedMax)<<pf.RedShift | uint32(pf.GreenMax)<<pf.GreenShift | uint32(pf.BlueMax)<<pf.BlueShift
+	all := uint32(1)<<pf.BPP - 1
+	if pf.BPP == 32 {
+		all = ^uint32(0)
+	}
+	p.padding = all &^ used
+	p.done = p.padding == 0
+}
+
+// swap reverses the bytes of a pixel of the probe's size.
+func (p *endiannessProbe) swap(pixel uint32) uint32 {
+	if p.pf.BPP == 16 {
+		return uint32(bits.ReverseBytes16(uint16(pixel))) // #nosec G115 - 16-bit pixels fit in uint16
+	}
+	return bits.ReverseBytes32(pixel)
+}
+
+// observe records a pixel decoded in the declared byte order.
+func (p *endiannessProbe) observe(pixel uint32) {
+	declared := pixel & p.padding
+	swapped := p.swap(pixel) & p.padding
+
+	if p.samples == 0 {
+		p.declared, p.swapped = declared, swapped
+	} else {
+		p.declaredVaries = p.declaredVaries || declared != p.declared
+		p.swappedVaries = p.swappedVaries || swapped != p.swapped
+	}
+	p.samples++
+
+	switch {
+	case p.samples >= endiannessMinSamples && p.declaredVaries && !p.swappedVaries:
+		p.mismatch, p.done = true, true
+	case p.samples >= endiannessMaxSamples:
+		p.done = true
+	}
+}
+
+// observeData records raw pixel data, such as cursor images, in the declared
+// byte order.
+func (p *endiannessProbe) observeData(data []byte, order binary.ByteOrder) {
+	size := int(p.pf.BPP / 8)
+	for i := 0; i+size <= len(data) && !p.done; i += size {
+		if size == 2 {
+			p.observe(uint32(order.Uint16(data[i:])))
+		} else {
+			p.observe(order.Uint32(data[i:]))
+		}
+	}
+}
+
+// pixelEndiannessProbe returns the probe for pixels in pf, or nil if the
+// configured byte order is forced or the probe is finished.
+func (c *ClientConn) pixelEndiannessProbe(pf PixelFormat) *endiannessProbe {
+	if c.config != nil && c.config.PixelEndianness != PixelEndiannessAuto {
+		return nil
+	}
+	if !c.endianness.ready || c.endianness.pf != pf {
+		c.endianness.reset(pf)
+	}
+	if c.endianness.done {
+		return nil
+	}
+	return &c.endianness
+}
+
+// reportPixelEndianness logs a warning the first time the probe concludes that
+// the server sends pixels in the opposite byte order from its pixel format.
+func (c *ClientConn) reportPixelEndianness() {
+	p := &c.endianness
+	if !p.mismatch || p.reported {
+		return
+	}
+	p.reported = true
+
+	declared := endiannessOf(p.pf)
+	observed := PixelEndiannessBig
+	if declared == PixelEndiannessBig {
+		observed = PixelEndiannessLittle
+	}
+	c.logger.Warn("Server pixel data does not match the byte order of its pixel format; use WithForcePixelEndianness to override it",
+		Field{Key: "declared", Value: declared.String()},
+		Field{Key: "observed", Value: observed.String()})
+}
+
+// pixelByteOrder returns the byte order used to decode pixels in pf.
+func (c *ClientConn) pixelByteOrder(pf PixelFormat) binary.ByteOrder {
+	order := endiannessOf(pf)
+	if c.config != nil && c.config.PixelEndianness != PixelEndiannessAuto {
+		order = c.config.PixelEndianness
+	}
+	if order == PixelEndiannessBig {
+		return binary.BigEndian
+	}
+	return binary.LittleEndian
+}
@@ -0,0 +1,75 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package vnc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"log"
+	"strings"
+	"testing"
+)
+
+// gradientPixels returns count 32-bit pixels of a gradient in
+// PixelFormat32BitRGBA, encoded in order.
+func gradientPixels(count int, order binary.AppendByteOrder) []byte {
+	data := make([]byte, 0, count*4)
+	for i := 0; i < count; i++ {
+		pixel := uint32(i%256)<<16 | uint32(i/4%256)<<8 | uint32(255-i%256)
+		data = order.AppendUint32(data, pixel)
+	}
+	return data
+}
+
+func TestPixelEndianness_Detection(t *testing.T) {
+	tests := []struct {
+		name  string
+		order binary.AppendByteOrder
+		warn  bool
+	}{
+		{"Declared", binary.LittleEndian, false},
+		{"Swapped", binary.BigEndian, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			c := &ClientConn{logger: &StandardLogger{Logger: log.New(&buf, "", 0)}}
+			c.setPixelFormat(*PixelFormat32BitRGBA)
+
+			rect := &Rectangle{Width: 64, Height: 32}
+			if _, err := (&RawEncoding{}).Read(c, rect, bytes.NewReader(gradientPixels(64*32, tt.order))); err != nil {
+				t.Fatal(err)
+			}
+			c.reportPixelEndianness()
+			c.reportPixelEndianness()
+
+			warnings := strings.Count(buf.String(), "does not match the byte order")
+			if tt.warn && warnings != 1 || !tt.warn && warnings != 0 {
+				t.Errorf("logged %d byte order warnings, want warning = %v:\n%s", warnings, tt.warn, buf.String())
+			}
+		})
+	}
+}
+
+func TestPixelEndianness_Force(t *testing.T) {
+	c := &ClientConn{
+		config: &ClientConfig{PixelEndianness: PixelEndiannessBig},
+		logger: &NoOpLogger{},
+	}
+	c.setPixelFormat(*PixelFormat32BitRGBA)
+
+	rect := &Rectangle{Width: 2, Height: 1}
+	enc, err := (&RawEncoding{}).Read(c, rect, bytes.NewReader(gradientPixels(2, binary.BigEndian)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := enc.(*RawEncoding).Colors[1], (Color{R: 1, G: 0, B: 254}); got != want {
+		t.Errorf("decoded color = %+v, want %+v", got, want)
+	}
+	if c.pixelReader().probe != nil {
+		t.Error("endianness probe is active although the byte order is forced")
+	}
+}
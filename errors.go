@@ -54,13 +54,50 @@ func (e ErrorCode) String() string {
 	}
 }
 
+// Phase identifies the stage of the RFB protocol a connection is in.
+type Phase string
+
+// Protocol phases recorded on errors produced by a client connection.
+const (
+	// PhaseProtocolVersion covers the ProtocolVersion exchange (RFC 6143 Section 7.1.1).
+	PhaseProtocolVersion Phase = "protocol-version"
+	// PhaseSecurity covers security type negotiation (RFC 6143 Section 7.1.2).
+	PhaseSecurity Phase = "security"
+	// PhaseAuthentication covers the security handshake and SecurityResult.
+	PhaseAuthentication Phase = "authentication"
+	// PhaseInitialization covers the ClientInit and ServerInit exchange.
+	PhaseInitialization Phase = "initialization"
+	// PhaseSession covers normal message processing after the handshake.
+	PhaseSession Phase = "session"
+)
+
 // VNCError provides structured error information with operation context,
 // error codes, and message wrapping for comprehensive error handling.
+//
+// Errors returned by a ClientConn additionally carry the connection context
+// (remote address, connection ID, protocol phase and, for failures while
+// processing server messages, the message type) so that errors collected from
+// many connections can be correlated without separate bookkeeping.
 type VNCError struct {
 	Op      string
 	Code    ErrorCode
 	Message string
 	Err     error
+
+	// RemoteAddr is the network address of the server, if known.
+	RemoteAddr string
+
+	// ConnID uniquely identifies the client connection that produced the error.
+	ConnID string
+
+	// Phase is the protocol phase during which the error occurred.
+	Phase Phase
+
+	// MessageType names the server message being processed, if any.
+	MessageType string
+
+	// catalog localizes UserMessage.
+	catalog MessageCatalog
 }
 
 // Error returns the formatted error message.
@@ -71,6 +108,28 @@ func (e *VNCError) Error() string {
 	return fmt.Sprintf("vnc %s: %s: %s", e.Code.String(), e.Op, e.Message)
 }
 
+// Fields returns the connection context of the error as structured logging fields.
+// Only populated context is included.
+func (e *VNCError) Fields() []Field {
+	fields := []Field{
+		{Key: "code", Value: e.Code.String()},
+		{Key: "op", Value: e.Op},
+	}
+	if e.ConnID != "" {
+		fields = append(fields, Field{Key: "conn_id", Value: e.ConnID})
+	}
+	if e.RemoteAddr != "" {
+		fields = append(fields, Field{Key: "remote_addr", Value: e.RemoteAddr})
+	}
+	if e.Phase != "" {
+		fields = append(fields, Field{Key: "phase", Value: string(e.Phase)})
+	}
+	if e.MessageType != "" {
+		fields = append(fields, Field{Key: "message_type", Value: e.MessageType})
+	}
+	return fields
+}
+
 // Unwrap returns the underlying error for error chain unwrapping.
 func (e *VNCError) Unwrap() error {
 	return e.Err
@@ -4,8 +4,11 @@
 package vnc
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"io"
+	"net"
 	"testing"
 )
 
@@ -97,6 +100,47 @@ func TestErrors_VNCErrorUnwrap(t *testing.T) {
 	}
 }
 
+func TestErrors_VNCErrorFields(t *testing.T) {
+	bare := &VNCError{Op: "test", Code: ErrNetwork, Message: "test message"}
+	if got := len(bare.Fields()); got != 2 {
+		t.Errorf("Fields() without context returned %d fields, want 2", got)
+	}
+
+	vncErr := &VNCError{
+		Op:          "test",
+		Code:        ErrProtocol,
+		Message:     "test message",
+		RemoteAddr:  "127.0.0.1:5900",
+		ConnID:      "abc123",
+		Phase:       PhaseSession,
+		MessageType: "FramebufferUpdate",
+	}
+
+	want := map[string]interface{}{
+		"code":         "protocol",
+		"op":           "test",
+		"conn_id":      "abc123",
+		"remote_addr":  "127.0.0.1:5900",
+		"phase":        "session",
+		"message_type": "FramebufferUpdate",
+	}
+
+	fields := vncErr.Fields()
+	if len(fields) != len(want) {
+		t.Fatalf("Fields() returned %d fields, want %d", len(fields), len(want))
+	}
+	for _, f := range fields {
+		if want[f.Key] != f.Value {
+			t.Errorf("Fields()[%q] = %v, want %v", f.Key, f.Value, want[f.Key])
+		}
+	}
+
+	// Context must not change the formatted message.
+	if got := vncErr.Error(); got != "vnc protocol: test: test message" {
+		t.Errorf("VNCError.Error() = %v", got)
+	}
+}
+
 func TestErrors_VNCErrorIs(t *testing.T) {
 	err1 := &VNCError{Op: "handshake", Code: ErrProtocol, Message: "test"}
 	err2 := &VNCError{Op: "handshake", Code: ErrProtocol, Message: "different message"}
@@ -444,3 +488,66 @@ func containsAt(s, substr string) bool {
 	}
 	return false
 }
+
+func TestErrors_MessageKey(t *testing.T) {
+	tests := []struct {
+		name string
+		err  *VNCError
+		want MessageKey
+	}{
+		{"Authentication", &VNCError{Code: ErrAuthentication}, MessageAuthenticationFailed},
+		{"Unsupported server", &VNCError{Code: ErrUnsupported, Phase: PhaseProtocolVersion}, MessageUnsupportedServer},
+		{"Unsupported feature", &VNCError{Code: ErrUnsupported, Phase: PhaseSession}, MessageUnsupportedFeature},
+		{"Timeout code", &VNCError{Code: ErrTimeout}, MessageTimeout},
+		{"Network deadline", &VNCError{Code: ErrNetwork, Err: context.DeadlineExceeded}, MessageTimeout},
+		{"Canceled", &VNCError{Code: ErrTimeout, Err: context.Canceled}, MessageCanceled},
+		{"Network", &VNCError{Code: ErrNetwork, Err: io.EOF}, MessageConnectionFailed},
+		{"Encoding", &VNCError{Code: ErrEncoding}, MessageProtocolError},
+		{"Validation", &VNCError{Code: ErrValidation}, MessageInvalidInput},
+		{"Unknown", &VNCError{Code: ErrorCode(99)}, MessageUnknown},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.err.Key(); got != tt.want {
+				t.Errorf("Key() = %q, want %q", got, tt.want)
+			}
+			if tt.err.UserMessage() == "" {
+				t.Error("UserMessage() is empty")
+			}
+		})
+	}
+}
+
+func TestErrors_MessageCatalog(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+
+	go func() {
+		_, _ = serverConn.Write([]byte("RFB 003.003\n"))
+	}()
+
+	catalog := func(key MessageKey, err *VNCError) string {
+		if key == MessageUnsupportedServer {
+			return "Le serveur VNC n'est pas pris en charge."
+		}
+		return ""
+	}
+
+	_, err := ClientWithOptions(context.Background(), clientConn,
+		WithAuth(&ClientAuthNone{}), WithMessageCatalog(catalog))
+
+	var vncErr *VNCError
+	if !errors.As(err, &vncErr) {
+		t.Fatalf("expected *VNCError, got %v", err)
+	}
+	if got := vncErr.UserMessage(); got != "Le serveur VNC n'est pas pris en charge." {
+		t.Errorf("UserMessage() = %q", got)
+	}
+
+	// Keys the catalog does not translate fall back to English.
+	vncErr.Code = ErrNetwork
+	if got := vncErr.UserMessage(); got != defaultMessages[MessageConnectionFailed] {
+		t.Errorf("UserMessage() fallback = %q", got)
+	}
+}
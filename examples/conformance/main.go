@@ -0,0 +1,56 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+//go:build !vnc_minimal
+
+// Package main checks a VNC server for conformance with RFC 6143.
+//
+// Usage:
+//
+//	conformance -addr localhost:5900 -password secret -probe-auth-failure
+//
+// The report lists one line per check. The exit status is 1 if any check
+// failed and 2 if the server could not be checked at all. Run it against an
+// idle desktop: the encoding checks compare screen captures.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/tenthirtyam/go-vnc"
+)
+
+func main() {
+	addr := flag.String("addr", "localhost:5900", "address of the VNC server")
+	password := flag.String("password", "", "password for VNC authentication")
+	probeAuthFailure := flag.Bool("probe-auth-failure", false, "send a wrong VNC authentication response to check the failure reason")
+	timeout := flag.Duration("timeout", 2*time.Minute, "time limit for the whole run")
+	flag.Parse()
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	auth := []vnc.ClientAuth{&vnc.ClientAuthNone{}}
+	if *password != "" {
+		auth = []vnc.ClientAuth{vnc.NewPasswordAuth(*password), &vnc.ClientAuthNone{}}
+	}
+
+	report, err := vnc.RunConformance(ctx, *addr,
+		vnc.WithConformanceClientOptions(vnc.WithAuth(auth...)),
+		vnc.WithAuthFailureProbe(*probeAuthFailure),
+	)
+	if report != nil {
+		fmt.Print(report)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "conformance: %v\n", err)
+		os.Exit(2)
+	}
+	if !report.Passed() {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,151 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+//go:build !vnc_minimal
+
+package vnc
+
+import (
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// ImageFormat identifies a file format for exporting frames and screenshots.
+type ImageFormat string
+
+// Image formats known to EncodeImage. PNG and JPEG are built in; WebP and
+// AVIF, which compress desktop images far better than PNG, need an encoder
+// registered with RegisterImageEncoder because the standard library has
+// none.
+const (
+	ImageFormatPNG  ImageFormat = "png"
+	ImageFormatJPEG ImageFormat = "jpeg"
+	ImageFormatWebP ImageFormat = "webp"
+	ImageFormatAVIF ImageFormat = "avif"
+)
+
+// ImageEncoder writes img to w in one image format. Quality ranges from 1 to
+// 100 for lossy compression; 0 asks for lossless compression where the format
+// supports it and for the encoder's default quality otherwise.
+type ImageEncoder func(w io.Writer, img image.Image, quality int) error
+
+// ExportOption configures a single EncodeImage call.
+type ExportOption func(*exportConfig)
+
+// exportConfig holds the settings applied by ExportOptions.
+type exportConfig struct {
+	quality int
+}
+
+// WithExportQuality sets the quality of lossy formats, from 1 to 100. Values
+// outside that range select lossless compression or the encoder default.
+func WithExportQuality(quality int) ExportOption {
+	return func(cfg *exportConfig) {
+		if quality < 1 || quality > 100 {
+			quality = 0
+		}
+		cfg.quality = quality
+	}
+}
+
+var (
+	imageEncodersMu sync.RWMutex
+	imageEncoders   = map[ImageFormat]ImageEncoder{
+		ImageFormatPNG:  encodePNG,
+		ImageFormatJPEG: encodeJPEG,
+	}
+)
+
+// RegisterImageEncoder makes EncodeImage use encoder for format, replacing any
+// encoder registered before, including the built-in ones. A nil encoder
+// removes the registration. It is typically called from an init function of a
+// package that wraps a WebP or AVIF library:
+//
+//	func init() {
+//		vnc.RegisterImageEncoder(vnc.ImageFormatWebP, func(w io.Writer, img image.Image, quality int) error {
+//			return webp.Encode(w, img, &webp.Options{Lossless: quality == 0, Quality: float32(quality)})
+//		})
+//	}
+func RegisterImageEncoder(format ImageFormat, encoder ImageEncoder) {
+	imageEncodersMu.Lock()
+	defer imageEncodersMu.Unlock()
+
+	if encoder == nil {
+		delete(imageEncoders, format)
+		return
+	}
+	imageEncoders[format] = encoder
+}
+
+// ImageFormatForPath returns the image format matching the extension of path,
+// such as ".png", ".jpg", ".webp", or ".avif".
+func ImageFormatForPath(path string) (ImageFormat, bool) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".png":
+		return ImageFormatPNG, true
+	case ".jpg", ".jpeg":
+		return ImageFormatJPEG, true
+	case ".webp":
+		return ImageFormatWebP, true
+	case ".avif":
+		return ImageFormatAVIF, true
+	default:
+		return "", false
+	}
+}
+
+// EncodeImage writes img, typically a Frame or a screenshot, to w in format.
+// It returns an ErrUnsupported error if no encoder is registered for format.
+//
+// Example usage:
+//
+//	frame := client.CurrentFrame()
+//	err := vnc.EncodeImage(file, frame, vnc.ImageFormatJPEG, vnc.WithExportQuality(80))
+func EncodeImage(w io.Writer, img image.Image, format ImageFormat, options ...ExportOption) error {
+	cfg := exportConfig{}
+	for _, option := range options {
+		option(&cfg)
+	}
+
+	imageEncodersMu.RLock()
+	encoder := imageEncoders[format]
+	imageEncodersMu.RUnlock()
+	if encoder == nil {
+		return unsupportedError("EncodeImage",
+			fmt.Sprintf("no encoder registered for image format %q; see RegisterImageEncoder", format), nil)
+	}
+
+	// The standard encoders have fast paths for *image.RGBA.
+	if frame, ok := img.(*Frame); ok {
+		img = frame.RGBA()
+	}
+	if err := encoder(w, img, cfg.quality); err != nil {
+		return encodingError("EncodeImage", fmt.Sprintf("failed to encode %s image", format), err)
+	}
+	return nil
+}
+
+// Encode writes the frame to w in format. See EncodeImage.
+func (f *Frame) Encode(w io.Writer, format ImageFormat, options ...ExportOption) error {
+	return EncodeImage(w, f, format, options...)
+}
+
+// encodePNG writes img as PNG, which is always lossless.
+func encodePNG(w io.Writer, img image.Image, _ int) error {
+	encoder := png.Encoder{CompressionLevel: png.BestCompression}
+	return encoder.Encode(w, img)
+}
+
+// encodeJPEG writes img as JPEG at quality, or at the jpeg package default.
+func encodeJPEG(w io.Writer, img image.Image, quality int) error {
+	if quality == 0 {
+		quality = jpeg.DefaultQuality
+	}
+	return jpeg.Encode(w, img, &jpeg.Options{Quality: quality})
+}
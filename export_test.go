@@ -0,0 +1,88 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+//go:build !vnc_minimal
+
+package vnc
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"testing"
+)
+
+func TestExport_BuiltinFormats(t *testing.T) {
+	fb := newFramebuffer(8, 4)
+	fb.pf = replayPixelFormat
+	fb.fill(0, 0, 4, 4, Color{R: 255})
+	frame := fb.frame()
+
+	var buf bytes.Buffer
+	if err := frame.Encode(&buf, ImageFormatPNG); err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r, _, _, _ := img.At(1, 1).RGBA(); img.Bounds() != frame.Bounds() || r>>8 != 255 {
+		t.Errorf("PNG bounds = %v, red = %d", img.Bounds(), r>>8)
+	}
+
+	buf.Reset()
+	if err := EncodeImage(&buf, frame, ImageFormatJPEG, WithExportQuality(90)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := jpeg.Decode(&buf); err != nil {
+		t.Errorf("JPEG output does not decode: %v", err)
+	}
+}
+
+func TestExport_RegisteredEncoder(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 2, 2))
+
+	err := EncodeImage(io.Discard, img, ImageFormatAVIF)
+	if !IsVNCError(err, ErrUnsupported) {
+		t.Fatalf("EncodeImage(AVIF) without an encoder = %v, want ErrUnsupported", err)
+	}
+
+	var gotQuality int
+	RegisterImageEncoder(ImageFormatWebP, func(w io.Writer, _ image.Image, quality int) error {
+		gotQuality = quality
+		_, err := w.Write([]byte("RIFF"))
+		return err
+	})
+	defer RegisterImageEncoder(ImageFormatWebP, nil)
+
+	var buf bytes.Buffer
+	if err := EncodeImage(&buf, img, ImageFormatWebP, WithExportQuality(75)); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "RIFF" || gotQuality != 75 {
+		t.Errorf("output = %q, quality = %d", buf.String(), gotQuality)
+	}
+
+	if err := EncodeImage(&buf, img, ImageFormatWebP, WithExportQuality(500)); err != nil || gotQuality != 0 {
+		t.Errorf("out-of-range quality = %d, err = %v, want 0 (lossless)", gotQuality, err)
+	}
+}
+
+func TestExport_ImageFormatForPath(t *testing.T) {
+	tests := map[string]ImageFormat{
+		"shot.png":       ImageFormatPNG,
+		"shot.JPG":       ImageFormatJPEG,
+		"a/b/shot.jpeg":  ImageFormatJPEG,
+		"shot.webp":      ImageFormatWebP,
+		"artifact.avif":  ImageFormatAVIF,
+		"screenshot.bmp": "",
+	}
+	for path, want := range tests {
+		got, ok := ImageFormatForPath(path)
+		if got != want || ok != (want != "") {
+			t.Errorf("ImageFormatForPath(%q) = %q, %v, want %q", path, got, ok, want)
+		}
+	}
+}
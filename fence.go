@@ -0,0 +1,162 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package vnc
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/tenthirtyam/go-vnc/rfb"
+)
+
+// Fence flags for ClientConn.Fence and FenceMessage.
+const (
+	// FenceBlockBefore asks the server to finish processing the client's
+	// earlier messages before handling the fence.
+	FenceBlockBefore = rfb.FenceBlockBefore
+
+	// FenceBlockAfter asks the server not to process later messages until it
+	// has responded to the fence.
+	FenceBlockAfter = rfb.FenceBlockAfter
+
+	// FenceSyncNext asks the server to handle the next message at the same
+	// point as the fence.
+	FenceSyncNext = rfb.FenceSyncNext
+
+	// FenceRequest marks a fence that must be answered.
+	FenceRequest = rfb.FenceRequest
+)
+
+// supportedFenceFlags are the flags the client honors in fence requests from
+// the server. The message loop handles messages one at a time and responds
+// before reading the next, which satisfies all of them.
+const supportedFenceFlags = FenceBlockBefore | FenceBlockAfter | FenceSyncNext
+
+// FencePseudoEncoding represents the Fence pseudo-encoding. Including it in
+// SetEncodings announces support for Fence messages, which let each side
+// learn when the other has processed everything sent before the fence. The
+// server confirms support by sending a fence request, which the client
+// answers automatically, rather than a rectangle.
+type FencePseudoEncoding struct{}
+
+// Type returns the encoding type identifier for Fence pseudo-encoding.
+func (*FencePseudoEncoding) Type() int32 {
+	return rfb.PseudoEncodingFence
+}
+
+// IsPseudo returns true indicating this is a pseudo-encoding.
+func (*FencePseudoEncoding) IsPseudo() bool {
+	return true
+}
+
+// Read decodes a Fence rectangle, which carries no payload. Servers confirm
+// the pseudo-encoding with a FenceMessage instead.
+func (e *FencePseudoEncoding) Read(*ClientConn, *Rectangle, io.Reader) (Encoding, error) {
+	return e, nil
+}
+
+// FenceMessage represents a Fence message from the server (message type 248).
+// Fence requests from the server, with FenceRequest set, are answered by the
+// client before the message is delivered. Fences without it are the server's
+// responses to ClientConn.Fence and carry the payload the client sent.
+type FenceMessage struct {
+	// Flags are the fence flags.
+	Flags uint32
+
+	// Payload is the opaque data of the fence, at most 64 bytes.
+	Payload []byte
+}
+
+// Type returns the message type identifier for fence messages.
+func (*FenceMessage) Type() uint8 {
+	return rfb.FenceMsg
+}
+
+// Read parses a Fence message from the server and answers it if it is a
+// request. The first fence confirms the server's support, enabling
+// ClientConn.Fence.
+func (*FenceMessage) Read(c *ClientConn, r io.Reader) (ServerMessage, error) {
+	f, err := rfb.ReadFence(r)
+	if err != nil {
+		return nil, networkError("FenceMessage.Read", "failed to read fence", err)
+	}
+
+	if !c.fence.Swap(true) {
+		c.logger.Info("Server supports fences")
+	}
+	if f.Flags&FenceRequest != 0 {
+		c.logger.Debug("Answering fence request",
+			Field{Key: "flags", Value: fmt.Sprintf("%#x", f.Flags)},
+			Field{Key: "payload_length", Value: len(f.Payload)})
+		if err := c.writeFence(f.Response(supportedFenceFlags)); err != nil {
+			return nil, networkError("FenceMessage.Read", "failed to answer fence request", err)
+		}
+	}
+	return &FenceMessage{Flags: f.Flags, Payload: f.Payload}, nil
+}
+
+// Write encodes the message for a client, as servers built with the server
+// package send it.
+func (m *FenceMessage) Write(w io.Writer) error {
+	if err := rfb.WriteFence(w, rfb.Fence{Flags: m.Flags, Payload: m.Payload}); err != nil {
+		return networkError("FenceMessage.Write", "failed to write fence", err)
+	}
+	return nil
+}
+
+// FenceSupported reports whether the server has sent a fence, confirming the
+// Fence pseudo-encoding for this connection.
+func (c *ClientConn) FenceSupported() bool {
+	return c.fence.Load()
+}
+
+// Fence sends a Fence message with the given flags and an opaque payload of
+// at most 64 bytes. A fence with FenceRequest set is answered by the server
+// with a FenceMessage carrying the same payload once it has processed the
+// messages the flags ask for, which gives a synchronization point for flow
+// control and a precise round-trip measurement:
+//
+//	start := time.Now()
+//	_ = client.Fence(vnc.FenceRequest|vnc.FenceBlockBefore, []byte("rtt"))
+//	for msg := range msgCh {
+//		if f, ok := msg.(*vnc.FenceMessage); ok && f.Flags&vnc.FenceRequest == 0 {
+//			log.Printf("round trip: %v", time.Since(start))
+//			break
+//		}
+//	}
+//
+// The server must have confirmed FencePseudoEncoding, which FenceSupported
+// reports; until then Fence returns an unsupported error.
+func (c *ClientConn) Fence(flags uint32, payload []byte) error {
+	if !c.fence.Load() {
+		return c.enrichError(unsupportedError("Fence",
+			"the server has not confirmed the Fence pseudo-encoding", nil))
+	}
+	if flags&^rfb.FenceFlagsMask != 0 {
+		return c.enrichError(validationError("Fence", fmt.Sprintf("undefined fence flags %#x", flags&^rfb.FenceFlagsMask), nil))
+	}
+	if len(payload) > rfb.MaxFencePayload {
+		return c.enrichError(validationError("Fence",
+			fmt.Sprintf("fence payload of %d bytes exceeds %d", len(payload), rfb.MaxFencePayload), nil))
+	}
+
+	c.logger.Debug("Sending fence",
+		Field{Key: "flags", Value: fmt.Sprintf("%#x", flags)},
+		Field{Key: "payload_length", Value: len(payload)})
+	if err := c.writeFence(rfb.Fence{Flags: flags, Payload: payload}); err != nil {
+		c.logger.Error("Failed to send fence", Field{Key: "error", Value: err})
+		return c.enrichError(networkError("Fence", "failed to send fence", err))
+	}
+	return nil
+}
+
+// writeFence sends f to the server.
+func (c *ClientConn) writeFence(f rfb.Fence) error {
+	var buf bytes.Buffer
+	if err := rfb.WriteFence(&buf, f); err != nil {
+		return err
+	}
+	return c.writeWithContext(c.ctx, buf.Bytes())
+}
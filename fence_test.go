@@ -0,0 +1,78 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package vnc
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/tenthirtyam/go-vnc/rfb"
+)
+
+// receiveFence waits for the next fence the client sends to srv.
+func receiveFence(t *testing.T, srv *updateServer) rfb.Fence {
+	t.Helper()
+	select {
+	case f := <-srv.fences:
+		return f
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a fence")
+		return rfb.Fence{}
+	}
+}
+
+func TestFence_AnswerRequest(t *testing.T) {
+	msgs := make(chan ServerMessage, 4)
+	srv, conn := newUpdateServer(t, 16, 16, WithServerMessageChannel(msgs))
+
+	if err := conn.Fence(FenceRequest, nil); !IsVNCError(err, ErrUnsupported) {
+		t.Fatalf("Fence before confirmation = %v, want an unsupported error", err)
+	}
+
+	var buf bytes.Buffer
+	request := rfb.Fence{Flags: FenceRequest | FenceBlockAfter | 1<<8, Payload: []byte{1, 2, 3}}
+	if err := rfb.WriteFence(&buf, request); err != nil {
+		t.Fatal(err)
+	}
+	go func() { _, _ = srv.conn.Write(buf.Bytes()) }()
+
+	// Unknown flags are dropped from the response.
+	want := rfb.Fence{Flags: FenceBlockAfter, Payload: []byte{1, 2, 3}}
+	if got := receiveFence(t, srv); !reflect.DeepEqual(got, want) {
+		t.Errorf("fence response = %+v, want %+v", got, want)
+	}
+	select {
+	case msg := <-msgs:
+		if f, ok := msg.(*FenceMessage); !ok || f.Flags != request.Flags {
+			t.Errorf("delivered message = %#v, want the fence request", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the fence message")
+	}
+	if !conn.FenceSupported() {
+		t.Fatal("FenceSupported() = false after a server fence")
+	}
+
+	if err := conn.Fence(FenceRequest|FenceBlockBefore, []byte("rtt")); err != nil {
+		t.Fatal(err)
+	}
+	want = rfb.Fence{Flags: FenceRequest | FenceBlockBefore, Payload: []byte("rtt")}
+	if got := receiveFence(t, srv); !reflect.DeepEqual(got, want) {
+		t.Errorf("client fence = %+v, want %+v", got, want)
+	}
+}
+
+func TestFence_Validation(t *testing.T) {
+	_, conn := newUpdateServer(t, 16, 16)
+	conn.fence.Store(true)
+
+	if err := conn.Fence(1<<8, nil); !IsVNCError(err, ErrValidation) {
+		t.Errorf("Fence with undefined flags = %v, want a validation error", err)
+	}
+	if err := conn.Fence(FenceRequest, make([]byte, rfb.MaxFencePayload+1)); !IsVNCError(err, ErrValidation) {
+		t.Errorf("Fence with a long payload = %v, want a validation error", err)
+	}
+}
@@ -0,0 +1,272 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package vnc
+
+import (
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/tenthirtyam/go-vnc/rfb"
+)
+
+// WithMaxFrameRate caps the frames the connection requests and delivers at
+// fps per second, however fast the application asks for updates or the server
+// pushes them, for viewers that render at a fixed rate:
+//
+//	client, err := vnc.ClientWithOptions(ctx, conn,
+//		vnc.WithMaxFrameRate(30),
+//		vnc.WithServerMessageChannel(messages))
+//
+// An incremental FramebufferUpdateRequest sent sooner than 1/fps after the
+// previous request is held until the interval ends, and further requests
+// while it is held widen its area instead of being sent, so the server folds
+// the changes of the interval into one update that is decoded once.
+// Non-incremental requests are sent at once. FramebufferUpdateMessages the
+// server sends anyway, as with continuous updates, are still decoded and
+// applied as they arrive, but one arriving sooner than 1/fps after the last
+// delivered one is held and merged with those that follow, and delivered as
+// a single message when the interval ends or before the next message of
+// another type. Held requests and merged updates are counted in
+// Stats.PacedRequests and Stats.CoalescedUpdates.
+//
+// Delivery is only paced for the channel set with WithServerMessageChannel;
+// applications using WithManualPump pace their own loop. Zero, the default,
+// disables pacing.
+func WithMaxFrameRate(fps float64) ClientOption {
+	return func(cfg *ClientConfig) {
+		cfg.MaxFrameRate = fps
+	}
+}
+
+// framePacer holds the update requests and framebuffer updates that arrive
+// sooner than the frame interval configured by MaxFrameRate.
+type framePacer struct {
+	// deliver orders the deliveries of the pump and the release goroutine.
+	deliver sync.Mutex
+
+	mu          sync.Mutex
+	lastRequest time.Time
+	request     *rfb.FramebufferUpdateRequest
+	lastFrame   time.Time
+	frame       *FramebufferUpdateMessage
+	releasing   bool
+}
+
+// frameInterval returns the minimum time between frames, or 0 without
+// MaxFrameRate.
+func (c *ClientConn) frameInterval() time.Duration {
+	if c.config == nil || c.config.MaxFrameRate <= 0 {
+		return 0
+	}
+	return time.Duration(float64(time.Second) / c.config.MaxFrameRate)
+}
+
+// holdUpdateRequest reports whether req must wait for the next frame
+// interval, in which case it is held, or merged into the request already
+// held, and sent by the release goroutine.
+func (c *ClientConn) holdUpdateRequest(req rfb.FramebufferUpdateRequest) bool {
+	interval := c.frameInterval()
+	if interval <= 0 {
+		return false
+	}
+
+	p := &c.pacer
+	now := time.Now()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	switch {
+	case !req.Incremental:
+		p.lastRequest = now
+		return false
+	case p.request != nil:
+		*p.request = unionUpdateRequest(*p.request, req)
+	case p.lastRequest.IsZero() || now.Sub(p.lastRequest) >= interval:
+		p.lastRequest = now
+		return false
+	default:
+		p.request = &req
+	}
+
+	c.stats.mu.Lock()
+	c.stats.pacedRequests++
+	c.stats.mu.Unlock()
+	c.startRelease()
+	return true
+}
+
+// unionUpdateRequest returns an incremental request covering the areas of a
+// and b.
+func unionUpdateRequest(a, b rfb.FramebufferUpdateRequest) rfb.FramebufferUpdateRequest {
+	x := min(a.X, b.X)
+	y := min(a.Y, b.Y)
+	right := max(int(a.X)+int(a.Width), int(b.X)+int(b.Width))
+	bottom := max(int(a.Y)+int(a.Height), int(b.Y)+int(b.Height))
+	return rfb.FramebufferUpdateRequest{
+		Incremental: true,
+		X:           x,
+		Y:           y,
+		Width:       uint16(min(right-int(x), 0xffff)),  // #nosec G115 - Clamped to the uint16 range
+		Height:      uint16(min(bottom-int(y), 0xffff)), // #nosec G115 - Clamped to the uint16 range
+	}
+}
+
+// deliverPaced delivers msg like deliverMessage, holding framebuffer updates
+// that arrive sooner than the frame interval and delivering a held update
+// ahead of any other message. It reports false if the connection closed.
+func (c *ClientConn) deliverPaced(msg ServerMessage) bool {
+	interval := c.frameInterval()
+	if interval <= 0 || c.config.ServerMessageCh == nil {
+		return c.deliverMessage(msg)
+	}
+
+	p := &c.pacer
+	p.deliver.Lock()
+	defer p.deliver.Unlock()
+
+	now := time.Now()
+	p.mu.Lock()
+	held := p.frame
+	update, isUpdate := msg.(*FramebufferUpdateMessage)
+	if isUpdate {
+		if held != nil || (!p.lastFrame.IsZero() && now.Sub(p.lastFrame) < interval) {
+			if held == nil {
+				merged := *update
+				merged.Rectangles = slices.Clone(update.Rectangles)
+				p.frame = &merged
+			} else {
+				held.Rectangles = append(held.Rectangles, update.Rectangles...)
+				held.Width, held.Height = update.Width, update.Height
+			}
+			c.startRelease()
+			p.mu.Unlock()
+
+			c.stats.mu.Lock()
+			c.stats.coalescedUpdates++
+			c.stats.mu.Unlock()
+			return true
+		}
+		p.lastFrame = now
+	} else if held != nil {
+		p.frame = nil
+		p.lastFrame = now
+	}
+	p.mu.Unlock()
+
+	if held != nil && !c.deliverMessage(held) {
+		return false
+	}
+	return c.deliverMessage(msg)
+}
+
+// startRelease starts the goroutine that releases held requests and updates
+// unless it is running. The caller holds c.pacer.mu.
+func (c *ClientConn) startRelease() {
+	if !c.pacer.releasing {
+		c.pacer.releasing = c.goTracked(c.releaseHeld)
+	}
+}
+
+// releaseHeld sends the held request and delivers the held update as their
+// frame intervals end, returning once nothing is held or the connection
+// closes.
+func (c *ClientConn) releaseHeld() {
+	interval := c.frameInterval()
+	p := &c.pacer
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-timer.C:
+		case <-c.ctx.Done():
+			p.mu.Lock()
+			dropped := p.frame != nil
+			p.request, p.frame, p.releasing = nil, nil, false
+			p.mu.Unlock()
+			if dropped {
+				c.recordDelivery(false, 0, 0)
+			}
+			return
+		}
+
+		wait, ok := c.releaseRequest(interval)
+		if frameWait, frameOK := c.releaseFrame(interval); frameOK && (!ok || frameWait < wait) {
+			wait, ok = frameWait, true
+		}
+		if !ok {
+			if !c.holding() {
+				return
+			}
+			// A request or update was held after the checks above.
+			wait = 0
+		}
+		timer.Reset(wait)
+	}
+}
+
+// releaseRequest sends the held request if its interval has ended. It
+// reports the time left and true while the request is still held.
+func (c *ClientConn) releaseRequest(interval time.Duration) (time.Duration, bool) {
+	p := &c.pacer
+	now := time.Now()
+	p.mu.Lock()
+	req := p.request
+	if req == nil {
+		p.mu.Unlock()
+		return 0, false
+	}
+	if wait := p.lastRequest.Add(interval).Sub(now); wait > 0 {
+		p.mu.Unlock()
+		return wait, true
+	}
+	p.request = nil
+	p.lastRequest = now
+	p.mu.Unlock()
+
+	if err := c.writeUpdateRequest(*req); err != nil {
+		c.logger.Warn("Failed to send paced framebuffer update request", Field{Key: "error", Value: err})
+	}
+	return 0, false
+}
+
+// releaseFrame delivers the held update if its interval has ended, like
+// releaseRequest.
+func (c *ClientConn) releaseFrame(interval time.Duration) (time.Duration, bool) {
+	p := &c.pacer
+	p.deliver.Lock()
+	defer p.deliver.Unlock()
+
+	now := time.Now()
+	p.mu.Lock()
+	held := p.frame
+	if held == nil {
+		p.mu.Unlock()
+		return 0, false
+	}
+	if wait := p.lastFrame.Add(interval).Sub(now); wait > 0 {
+		p.mu.Unlock()
+		return wait, true
+	}
+	p.frame = nil
+	p.lastFrame = now
+	p.mu.Unlock()
+
+	c.deliverMessage(held)
+	return 0, false
+}
+
+// holding reports whether a request or update is held, and otherwise marks
+// the release goroutine as stopped, so that the next hold starts it again.
+func (c *ClientConn) holding() bool {
+	p := &c.pacer
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.request != nil || p.frame != nil {
+		return true
+	}
+	p.releasing = false
+	return false
+}
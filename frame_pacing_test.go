@@ -0,0 +1,114 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package vnc
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestFramePacing_Requests(t *testing.T) {
+	messages := make(chan ServerMessage, 16)
+	srv, conn := newUpdateServer(t, 8, 4, WithMaxFrameRate(10), WithServerMessageChannel(messages))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	for _, area := range [][4]uint16{{0, 0, 2, 2}, {1, 1, 2, 2}, {4, 2, 2, 1}, {2, 1, 1, 1}} {
+		if err := conn.FramebufferUpdateRequest(true, area[0], area[1], area[2], area[3]); err != nil {
+			t.Fatalf("FramebufferUpdateRequest failed: %v", err)
+		}
+	}
+
+	want := []Rectangle{{X: 0, Y: 0, Width: 2, Height: 2}, {X: 1, Y: 1, Width: 5, Height: 2}}
+	for i, w := range want {
+		select {
+		case msg := <-messages:
+			update, ok := msg.(*FramebufferUpdateMessage)
+			if !ok || len(update.Rectangles) != 1 {
+				t.Fatalf("message %d = %#v, want an update with one rectangle", i, msg)
+			}
+			r := update.Rectangles[0]
+			if r.X != w.X || r.Y != w.Y || r.Width != w.Width || r.Height != w.Height {
+				t.Errorf("update %d covers %dx%d at (%d,%d), want %dx%d at (%d,%d)",
+					i, r.Width, r.Height, r.X, r.Y, w.Width, w.Height, w.X, w.Y)
+			}
+		case <-ctx.Done():
+			t.Fatalf("timed out waiting for update %d", i)
+		}
+	}
+
+	if got := srv.requests.Load(); got != 2 {
+		t.Errorf("server received %d requests, want 2", got)
+	}
+	if got := conn.Stats().PacedRequests; got != 3 {
+		t.Errorf("PacedRequests = %d, want 3", got)
+	}
+
+	// A full update request is never held.
+	if err := conn.FramebufferUpdateRequest(false, 0, 0, 8, 4); err != nil {
+		t.Fatalf("FramebufferUpdateRequest failed: %v", err)
+	}
+	select {
+	case <-messages:
+	case <-ctx.Done():
+		t.Fatal("timed out waiting for the full update")
+	}
+	if got := conn.Stats().PacedRequests; got != 3 {
+		t.Errorf("PacedRequests = %d after a full update request, want 3", got)
+	}
+}
+
+func TestFramePacing_Coalesce(t *testing.T) {
+	messages := make(chan ServerMessage, 16)
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &ClientConn{
+		logger: &NoOpLogger{},
+		config: &ClientConfig{ServerMessageCh: messages, MaxFrameRate: 20},
+		ctx:    ctx,
+		cancel: cancel,
+	}
+	t.Cleanup(func() {
+		cancel()
+		c.wg.Wait()
+	})
+
+	update := func(x uint16) *FramebufferUpdateMessage {
+		return &FramebufferUpdateMessage{Rectangles: []Rectangle{{X: x, Width: 1, Height: 1}}}
+	}
+	for _, msg := range []ServerMessage{update(0), update(1), update(2), new(BellMessage), update(3)} {
+		if !c.deliverPaced(msg) {
+			t.Fatal("deliverPaced() = false on an open connection")
+		}
+	}
+
+	wantRects := [][]uint16{{0}, {1, 2}, nil, {3}}
+	for i, want := range wantRects {
+		var msg ServerMessage
+		select {
+		case msg = <-messages:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for message %d", i)
+		}
+		if want == nil {
+			if _, ok := msg.(*BellMessage); !ok {
+				t.Errorf("message %d = %T, want the bell after the held update", i, msg)
+			}
+			continue
+		}
+		update, ok := msg.(*FramebufferUpdateMessage)
+		if !ok || len(update.Rectangles) != len(want) {
+			t.Fatalf("message %d = %#v, want an update with %d rectangles", i, msg, len(want))
+		}
+		for j, x := range want {
+			if update.Rectangles[j].X != x {
+				t.Errorf("message %d rectangle %d at x=%d, want %d", i, j, update.Rectangles[j].X, x)
+			}
+		}
+	}
+
+	if got := c.Stats().CoalescedUpdates; got != 3 {
+		t.Errorf("CoalescedUpdates = %d, want 3", got)
+	}
+}
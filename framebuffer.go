@@ -0,0 +1,554 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package vnc
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"math/bits"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// frameTileSize is the edge length in pixels of the tiles frames share.
+const frameTileSize = 64
+
+// frameTile is a frameTileSize x frameTileSize block of RGBA pixels. Once a
+// tile has been handed to a Frame it is never written again; the framebuffer
+// clones it before the next write instead.
+type frameTile struct {
+	pix []byte
+
+	// owned reports that no Frame references the tile. It is only accessed by
+	// the framebuffer under its write lock.
+	owned bool
+}
+
+// tileGrid is a width x height image stored as a row-major grid of tiles. A nil
+// tile is entirely black.
+type tileGrid struct {
+	width, height int
+	tilesX        int
+	tiles         []*frameTile
+}
+
+// newTileGrid creates a black grid of the given size.
+func newTileGrid(width, height int) tileGrid {
+	tilesX := (width + frameTileSize - 1) / frameTileSize
+	tilesY := (height + frameTileSize - 1) / frameTileSize
+	return tileGrid{
+		width:  width,
+		height: height,
+		tilesX: tilesX,
+		tiles:  make([]*frameTile, tilesX*tilesY),
+	}
+}
+
+// bounds returns the rectangle covered by the grid.
+func (g *tileGrid) bounds() image.Rectangle {
+	return image.Rect(0, 0, g.width, g.height)
+}
+
+// tileOffset returns the index of the tile containing (x, y) and the offset of
+// the pixel within it.
+func (g *tileGrid) tileOffset(x, y int) (int, int) {
+	tile := (y/frameTileSize)*g.tilesX + x/frameTileSize
+	return tile, ((y%frameTileSize)*frameTileSize + x%frameTileSize) * 4
+}
+
+// rgbaAt returns the pixel at (x, y), which must be within the grid.
+func (g *tileGrid) rgbaAt(x, y int) color.RGBA {
+	tile, off := g.tileOffset(x, y)
+	t := g.tiles[tile]
+	if t == nil {
+		return color.RGBA{A: 0xff}
+	}
+	return color.RGBA{R: t.pix[off], G: t.pix[off+1], B: t.pix[off+2], A: t.pix[off+3]}
+}
+
+// copyTo copies region r of the grid into dst at the same coordinates.
+func (g *tileGrid) copyTo(dst *image.RGBA, r image.Rectangle) {
+	r = r.Intersect(g.bounds()).Intersect(dst.Rect)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; {
+			// Copy the run of pixels up to the end of the current tile.
+			end := min((x/frameTileSize+1)*frameTileSize, r.Max.X)
+			tile, off := g.tileOffset(x, y)
+			out := dst.Pix[dst.PixOffset(x, y) : dst.PixOffset(x, y)+4*(end-x)]
+			if t := g.tiles[tile]; t != nil {
+				copy(out, t.pix[off:off+len(out)])
+			} else {
+				for i := 0; i < len(out); i += 4 {
+					out[i], out[i+1], out[i+2], out[i+3] = 0, 0, 0, 0xff
+				}
+			}
+			x = end
+		}
+	}
+}
+
+// framebuffer is the client-side copy of the remote desktop. It is created on
+// the first capture and kept current by every FramebufferUpdate afterwards.
+type framebuffer struct {
+	mu   sync.RWMutex
+	grid tileGrid
+
+	// pf is the pixel format the rectangles being painted were decoded with.
+	pf PixelFormat
+
+	// lowPower selects the division-free conversion of ClientConfig.LowPower.
+	lowPower bool
+}
+
+// rectPainter is implemented by encodings that can render their decoded data
+// into the client framebuffer. Encodings without it, such as the cursor
+// pseudo-encoding, leave the framebuffer untouched.
+type rectPainter interface {
+	paint(fb *framebuffer, rect *Rectangle)
+}
+
+// newFramebuffer creates a black framebuffer of the given size.
+func newFramebuffer(width, height uint16) *framebuffer {
+	return &framebuffer{grid: newTileGrid(int(width), int(height))}
+}
+
+// rgba converts a decoded Color to 8-bit RGBA. True color components are scaled
+// from their maximum; color map entries are 16-bit.
+func (fb *framebuffer) rgba(c Color) color.RGBA {
+	if fb.lowPower && fb.pf.TrueColor {
+		return color.RGBA{
+			R: expandComponent(c.R, fb.pf.RedMax),
+			G: expandComponent(c.G, fb.pf.GreenMax),
+			B: expandComponent(c.B, fb.pf.BlueMax),
+			A: 0xff,
+		}
+	}
+	return pixelRGBA(&fb.pf, c)
+}
+
+// pixelRGBA converts a Color decoded in pixel format pf to opaque 8-bit RGBA.
+func pixelRGBA(pf *PixelFormat, c Color) color.RGBA {
+	if !pf.TrueColor {
+		return color.RGBA{R: uint8(c.R >> 8), G: uint8(c.G >> 8), B: uint8(c.B >> 8), A: 0xff}
+	}
+	return color.RGBA{
+		R: scaleComponent(c.R, pf.RedMax),
+		G: scaleComponent(c.G, pf.GreenMax),
+		B: scaleComponent(c.B, pf.BlueMax),
+		A: 0xff,
+	}
+}
+
+// scaleComponent scales a color component in the range 0..max to 0..255.
+func scaleComponent(v, max uint16) uint8 {
+	if max == 0 {
+		return 0
+	}
+	if v >= max {
+		return 0xff
+	}
+	return uint8(uint32(v) * 255 / uint32(max)) // #nosec G115 - v < max, so the result is below 255
+}
+
+// expandComponent scales a color component in the range 0..max to 0..255 by
+// shifting it to the top of the byte and replicating its high bits below. It
+// uses no division, which many ARM cores lack in hardware, and differs from
+// scaleComponent by at most one step when max is one less than a power of two.
+func expandComponent(v, max uint16) uint8 {
+	width := bits.Len16(max)
+	switch {
+	case width == 0:
+		return 0
+	case width >= 8:
+		return uint8(v >> (width - 8)) // #nosec G115 - v has at most width bits
+	}
+
+	v <<= 8 - width
+	for shift := width; shift < 8; shift *= 2 {
+		v |= v >> shift
+	}
+	return uint8(v) // #nosec G115 - v has at most 8 bits
+}
+
+// writable returns the tile at index i, allocating it or cloning it first if
+// it is black or shared with a Frame.
+func (fb *framebuffer) writable(i int) *frameTile {
+	t := fb.grid.tiles[i]
+	switch {
+	case t == nil:
+		t = &frameTile{pix: make([]byte, frameTileSize*frameTileSize*4), owned: true}
+		for p := 3; p < len(t.pix); p += 4 {
+			t.pix[p] = 0xff
+		}
+	case !t.owned:
+		t = &frameTile{pix: append([]byte(nil), t.pix...), owned: true}
+	default:
+		return t
+	}
+	fb.grid.tiles[i] = t
+	return t
+}
+
+// setRGBA paints a single converted pixel. Pixels outside the framebuffer are
+// ignored.
+func (fb *framebuffer) setRGBA(x, y int, px color.RGBA) {
+	if !image.Pt(x, y).In(fb.grid.bounds()) {
+		return
+	}
+	tile, off := fb.grid.tileOffset(x, y)
+	t := fb.writable(tile)
+	t.pix[off], t.pix[off+1], t.pix[off+2], t.pix[off+3] = px.R, px.G, px.B, px.A
+}
+
+// set paints a single pixel. Pixels outside the framebuffer are ignored.
+func (fb *framebuffer) set(x, y int, c Color) {
+	fb.setRGBA(x, y, fb.rgba(c))
+}
+
+// setColors paints decoded pixels, in row-major order, into rect.
+func (fb *framebuffer) setColors(rect *Rectangle, colors []Color) {
+	x, y, w := int(rect.X), int(rect.Y), int(rect.Width)
+	if w == 0 {
+		return
+	}
+	for i, color := range colors {
+		fb.set(x+i%w, y+i/w, color)
+	}
+}
+
+// fill paints a solid rectangle, clipped to the framebuffer.
+func (fb *framebuffer) fill(x, y, w, h int, c Color) {
+	r := image.Rect(x, y, x+w, y+h).Intersect(fb.grid.bounds())
+	if r.Empty() {
+		return
+	}
+
+	px := fb.rgba(c)
+	for row := r.Min.Y; row < r.Max.Y; row++ {
+		for col := r.Min.X; col < r.Max.X; {
+			end := min((col/frameTileSize+1)*frameTileSize, r.Max.X)
+			tile, off := fb.grid.tileOffset(col, row)
+			t := fb.writable(tile)
+			for ; col < end; col++ {
+				t.pix[off], t.pix[off+1], t.pix[off+2], t.pix[off+3] = px.R, px.G, px.B, px.A
+				off += 4
+			}
+		}
+	}
+}
+
+// paste writes img into the framebuffer at img's own coordinates.
+func (fb *framebuffer) paste(img *image.RGBA) {
+	r := img.Rect.Intersect(fb.grid.bounds())
+	for row := r.Min.Y; row < r.Max.Y; row++ {
+		for col := r.Min.X; col < r.Max.X; {
+			end := min((col/frameTileSize+1)*frameTileSize, r.Max.X)
+			tile, off := fb.grid.tileOffset(col, row)
+			src := img.Pix[img.PixOffset(col, row) : img.PixOffset(end-1, row)+4]
+			copy(fb.writable(tile).pix[off:], src)
+			col = end
+		}
+	}
+}
+
+// copyRect copies a w x h block from (sx, sy) to (dx, dy). Overlapping source
+// and destination areas are handled correctly.
+func (fb *framebuffer) copyRect(sx, sy, dx, dy, w, h int) {
+	src := image.Rect(sx, sy, sx+w, sy+h).Intersect(fb.grid.bounds())
+	if src.Empty() {
+		return
+	}
+
+	block := image.NewRGBA(src)
+	fb.grid.copyTo(block, src)
+	block.Rect = src.Add(image.Pt(dx-sx, dy-sy))
+	fb.paste(block)
+}
+
+// resize changes the framebuffer size, keeping the overlapping content.
+func (fb *framebuffer) resize(width, height uint16) {
+	old := fb.grid
+	fb.grid = newTileGrid(int(width), int(height))
+
+	overlap := old.bounds().Intersect(fb.grid.bounds())
+	if overlap.Empty() {
+		return
+	}
+	block := image.NewRGBA(overlap)
+	old.copyTo(block, overlap)
+	fb.paste(block)
+}
+
+// frame returns an immutable view of the whole framebuffer. Taking a frame
+// costs one pointer per tile; tiles are cloned lazily when next written.
+func (fb *framebuffer) frame() *Frame {
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+
+	grid := fb.grid
+	grid.tiles = make([]*frameTile, len(fb.grid.tiles))
+	for i, t := range fb.grid.tiles {
+		if t != nil {
+			t.owned = false
+		}
+		grid.tiles[i] = t
+	}
+	return &Frame{grid: grid, rect: grid.bounds()}
+}
+
+// Frame is an immutable snapshot of the client framebuffer. Frames share
+// pixel storage with the framebuffer and with each other, so taking one does
+// not copy the desktop; the decoder copies only the tiles it later changes.
+// A Frame is safe for concurrent use and implements image.Image. Frames of a
+// connection with a Rotation are in display coordinates.
+type Frame struct {
+	grid tileGrid
+	rect image.Rectangle
+
+	// rotation maps the frame coordinates to the framebuffer.
+	rotation Rotation
+}
+
+// Bounds returns the area of the desktop covered by the frame.
+func (f *Frame) Bounds() image.Rectangle {
+	return f.rect
+}
+
+// ColorModel returns color.RGBAModel.
+func (f *Frame) ColorModel() color.Model {
+	return color.RGBAModel
+}
+
+// At returns the color of the pixel at (x, y).
+func (f *Frame) At(x, y int) color.Color {
+	return f.RGBAAt(x, y)
+}
+
+// RGBAAt returns the color of the pixel at (x, y), or transparent black if the
+// point is outside the frame.
+func (f *Frame) RGBAAt(x, y int) color.RGBA {
+	if !image.Pt(x, y).In(f.rect) {
+		return color.RGBA{}
+	}
+	if f.rotation != Rotate0 {
+		p := rotatedPoint(image.Pt(x, y), f.grid.bounds().Size(), f.rotation)
+		x, y = p.X, p.Y
+	}
+	return f.grid.rgbaAt(x, y)
+}
+
+// SubImage returns a view of the part of the frame visible through r without
+// copying pixel data.
+func (f *Frame) SubImage(r image.Rectangle) *Frame {
+	return &Frame{grid: f.grid, rect: r.Intersect(f.rect), rotation: f.rotation}
+}
+
+// RGBA returns a mutable copy of the frame. The copy keeps the frame's
+// coordinates as its bounds.
+func (f *Frame) RGBA() *image.RGBA {
+	img := image.NewRGBA(f.rect)
+	if f.rotation == Rotate0 {
+		f.grid.copyTo(img, f.rect)
+		return img
+	}
+
+	size := f.grid.bounds().Size()
+	for y := f.rect.Min.Y; y < f.rect.Max.Y; y++ {
+		for x := f.rect.Min.X; x < f.rect.Max.X; x++ {
+			p := rotatedPoint(image.Pt(x, y), size, f.rotation)
+			img.SetRGBA(x, y, f.grid.rgbaAt(p.X, p.Y))
+		}
+	}
+	return img
+}
+
+// captureFlight is an in-flight framebuffer update request shared by every
+// capture whose region it covers.
+type captureFlight struct {
+	region image.Rectangle
+	done   chan struct{}
+	err    error
+}
+
+// capture holds the client framebuffer and the shared capture request state.
+type capture struct {
+	fb atomic.Pointer[framebuffer]
+
+	mu     sync.Mutex
+	flight *captureFlight
+}
+
+// enableFramebuffer allocates the client framebuffer if it does not exist yet.
+func (c *ClientConn) enableFramebuffer() *framebuffer {
+	if fb := c.capture.fb.Load(); fb != nil {
+		return fb
+	}
+
+	width, height := c.GetFrameBufferSize()
+	fb := newFramebuffer(width, height)
+	fb.lowPower = c.config != nil && c.config.LowPower
+	c.capture.fb.CompareAndSwap(nil, fb)
+	return c.capture.fb.Load()
+}
+
+// applyUpdate paints a decoded FramebufferUpdate into the client framebuffer,
+// if one is maintained, and completes any capture waiting for it. The whole
+// update is applied under a single lock so captures never observe a partially
+// painted frame.
+func (c *ClientConn) applyUpdate(rects []Rectangle) {
+	fb := c.capture.fb.Load()
+	if fb == nil {
+		return
+	}
+
+	fb.mu.Lock()
+	fb.pf = c.GetPixelFormat()
+	for i := range rects {
+		switch enc := rects[i].Enc.(type) {
+		case *CopyRectEncoding:
+			c.paintCopyRect(fb, enc, &rects[i])
+		case rectPainter:
+			enc.paint(fb, &rects[i])
+		}
+	}
+	fb.mu.Unlock()
+	c.recordFrame(fb)
+
+	c.capture.mu.Lock()
+	if f := c.capture.flight; f != nil {
+		close(f.done)
+		c.capture.flight = nil
+	}
+	c.capture.mu.Unlock()
+}
+
+// refresh requests a non-incremental update of region and waits until the
+// next FramebufferUpdate has been applied. Concurrent callers whose regions
+// are covered by an outstanding request share it instead of sending their own.
+func (c *ClientConn) refresh(ctx context.Context, region image.Rectangle) error {
+	c.enableFramebuffer()
+
+	for {
+		c.capture.mu.Lock()
+		f := c.capture.flight
+		if f != nil {
+			c.capture.mu.Unlock()
+			if err := c.awaitFlight(ctx, f); err != nil {
+				return err
+			}
+			if region.In(f.region) {
+				return nil
+			}
+			continue
+		}
+
+		// The flight is registered before the request is sent so that an
+		// update arriving while the write completes is not missed.
+		f = &captureFlight{region: region, done: make(chan struct{})}
+		c.capture.flight = f
+		c.capture.mu.Unlock()
+
+		start := time.Now()
+
+		// #nosec G115 - region is within the framebuffer, whose dimensions are uint16
+		err := c.FramebufferUpdateRequest(false, uint16(region.Min.X), uint16(region.Min.Y),
+			uint16(region.Dx()), uint16(region.Dy()))
+		if err != nil {
+			c.capture.mu.Lock()
+			if c.capture.flight == f {
+				f.err = err
+				close(f.done)
+				c.capture.flight = nil
+			}
+			c.capture.mu.Unlock()
+		}
+
+		if err := c.awaitFlight(ctx, f); err != nil {
+			return err
+		}
+		c.recordRoundTrip(time.Since(start))
+		return nil
+	}
+}
+
+// awaitFlight waits for a capture request to complete.
+func (c *ClientConn) awaitFlight(ctx context.Context, f *captureFlight) error {
+	select {
+	case <-f.done:
+		return f.err
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-c.ctx.Done():
+		return c.enrichError(networkError("refresh", "connection closed", c.ctx.Err()))
+	}
+}
+
+// Screenshot requests a full framebuffer update and returns a copy of the
+// desktop once it has been applied. The first capture allocates a client-side
+// framebuffer that is kept current by every later update.
+//
+// Screenshot is safe for concurrent use: callers that overlap share a single
+// update request and each receive their own copy of the resulting frame. The
+// frame reflects the first update completed after the request was issued. In
+// manual pump mode another goroutine must call ProcessNextMessage while
+// Screenshot waits. Use CaptureFrame to avoid copying the pixel data.
+//
+// Example usage:
+//
+//	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+//	defer cancel()
+//
+//	img, err := client.Screenshot(ctx)
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	_ = png.Encode(file, img)
+func (c *ClientConn) Screenshot(ctx context.Context) (*image.RGBA, error) {
+	width, height := c.DisplaySize()
+	return c.CaptureRegion(ctx, image.Rect(0, 0, int(width), int(height)))
+}
+
+// CaptureRegion is like Screenshot but requests and returns only region. The
+// returned image keeps the region's coordinates as its bounds. With a
+// Rotation configured, region is in display coordinates (see DisplaySize).
+func (c *ClientConn) CaptureRegion(ctx context.Context, region image.Rectangle) (*image.RGBA, error) {
+	frame, err := c.CaptureFrame(ctx, region)
+	if err != nil {
+		return nil, err
+	}
+	return frame.RGBA(), nil
+}
+
+// CaptureFrame is like CaptureRegion but returns an immutable Frame that shares
+// pixel storage with the client framebuffer instead of copying it.
+func (c *ClientConn) CaptureFrame(ctx context.Context, region image.Rectangle) (*Frame, error) {
+	width, height := c.DisplaySize()
+	bounds := image.Rect(0, 0, int(width), int(height))
+	if region.Empty() || !region.In(bounds) {
+		return nil, c.enrichError(validationError("CaptureFrame",
+			fmt.Sprintf("region %v is empty or outside the framebuffer %v", region, bounds), nil))
+	}
+
+	fbWidth, fbHeight := c.GetFrameBufferSize()
+	request := rotatedRect(region, image.Pt(int(fbWidth), int(fbHeight)), c.displayRotation())
+	if err := c.refresh(ctx, request); err != nil {
+		return nil, err
+	}
+
+	return c.displayFrame(c.capture.fb.Load().frame()).SubImage(region), nil
+}
+
+// CurrentFrame returns the latest state of the client framebuffer without
+// requesting an update, or nil if no capture has been made yet. Frames are
+// cheap to take and are never modified by later updates.
+func (c *ClientConn) CurrentFrame() *Frame {
+	fb := c.capture.fb.Load()
+	if fb == nil {
+		return nil
+	}
+	return c.displayFrame(fb.frame())
+}
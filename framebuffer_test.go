@@ -0,0 +1,436 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package vnc
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/tenthirtyam/go-vnc/rfb"
+)
+
+// updateServer is a fake server that answers every FramebufferUpdateRequest
+// with a Raw rectangle whose pixels are derived from their coordinates.
+type updateServer struct {
+	conn     net.Conn
+	requests atomic.Int32
+	pointers chan rfb.PointerEvent
+	keys     chan rfb.KeyEvent
+	qemuKeys chan rfb.ExtendedKeyEvent
+	fences   chan rfb.Fence
+	cutTexts chan []byte
+	enables  chan rfb.EnableContinuousUpdates
+}
+
+// updatePixel returns the color the server paints at (x, y) in response number n.
+func updatePixel(x, y, n int) color.RGBA {
+	return color.RGBA{R: uint8(x * 10), G: uint8(y * 10), B: uint8(n), A: 0xff}
+}
+
+// newUpdateServer starts an updateServer and returns a client connected to it.
+func newUpdateServer(t *testing.T, width, height uint16, options ...ClientOption) (*updateServer, *ClientConn) {
+	t.Helper()
+
+	srv, clientConn := startUpdateServer(t, width, height)
+
+	// The handshake context bounds the connection lifetime, so it must outlive
+	// this helper.
+	conn, err := ClientWithOptions(context.Background(), clientConn,
+		append([]ClientOption{WithAuth(&ClientAuthNone{})}, options...)...)
+	if err != nil {
+		t.Fatalf("handshake failed: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.CloseAndWait() })
+
+	return srv, conn
+}
+
+// startUpdateServer starts an updateServer and returns the client end of its
+// connection.
+func startUpdateServer(t *testing.T, width, height uint16) (*updateServer, net.Conn) {
+	t.Helper()
+
+	serverConn, clientConn := net.Pipe()
+	srv := &updateServer{
+		conn:     serverConn,
+		pointers: make(chan rfb.PointerEvent, 256),
+		keys:     make(chan rfb.KeyEvent, 256),
+		qemuKeys: make(chan rfb.ExtendedKeyEvent, 16),
+		fences:   make(chan rfb.Fence, 16),
+		cutTexts: make(chan []byte, 16),
+		enables:  make(chan rfb.EnableContinuousUpdates, 16),
+	}
+	t.Cleanup(func() { serverConn.Close() })
+
+	// net.Pipe is synchronous, so the handshake is written while serve reads
+	// the client's responses.
+	go func() {
+		_, _ = serverConn.Write(replayHandshake(width, height, "updates"))
+	}()
+	go func() {
+		_ = srv.serve()
+	}()
+
+	return srv, clientConn
+}
+
+// serve reads client messages until the connection closes.
+func (s *updateServer) serve() error {
+	if _, _, err := rfb.ReadProtocolVersion(s.conn); err != nil {
+		return err
+	}
+	if _, err := rfb.ReadSecurityType(s.conn); err != nil {
+		return err
+	}
+	if _, err := rfb.ReadClientInit(s.conn); err != nil {
+		return err
+	}
+
+	for {
+		msgType, err := rfb.ReadMessageType(s.conn)
+		if err != nil {
+			return err
+		}
+
+		switch msgType {
+		case rfb.SetPixelFormatMsg:
+			_, err = rfb.ReadSetPixelFormat(s.conn)
+		case rfb.SetEncodingsMsg:
+			_, err = rfb.ReadSetEncodings(s.conn)
+		case rfb.KeyEventMsg:
+			var ev rfb.KeyEvent
+			if ev, err = rfb.ReadKeyEvent(s.conn); err == nil {
+				s.keys <- ev
+			}
+		case rfb.QEMUClientMsg:
+			var ev rfb.ExtendedKeyEvent
+			if ev, err = rfb.ReadExtendedKeyEvent(s.conn); err == nil {
+				s.qemuKeys <- ev
+			}
+		case rfb.FenceMsg:
+			var f rfb.Fence
+			if f, err = rfb.ReadFence(s.conn); err == nil {
+				s.fences <- f
+			}
+		case rfb.EnableContinuousUpdatesMsg:
+			var m rfb.EnableContinuousUpdates
+			if m, err = rfb.ReadEnableContinuousUpdates(s.conn); err == nil {
+				s.enables <- m
+			}
+		case rfb.PointerEventMsg:
+			var ev rfb.PointerEvent
+			if ev, err = rfb.ReadPointerEvent(s.conn); err == nil {
+				s.pointers <- ev
+			}
+		case rfb.ClientCutTextMsg:
+			var text []byte
+			if text, err = rfb.ReadCutText(s.conn); err == nil {
+				s.cutTexts <- text
+			}
+		case rfb.FramebufferUpdateRequestMsg:
+			var req rfb.FramebufferUpdateRequest
+			if req, err = rfb.ReadFramebufferUpdateRequest(s.conn); err == nil {
+				err = s.respond(req, int(s.requests.Add(1)))
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// respond sends a Raw update covering the requested area.
+func (s *updateServer) respond(req rfb.FramebufferUpdateRequest, n int) error {
+	var buf bytes.Buffer
+	_ = rfb.WriteFramebufferUpdate(&buf, 1)
+	_ = rfb.WriteRectangle(&buf, rfb.Rectangle{X: req.X, Y: req.Y, Width: req.Width, Height: req.Height})
+	for y := int(req.Y); y < int(req.Y+req.Height); y++ {
+		for x := int(req.X); x < int(req.X+req.Width); x++ {
+			px := updatePixel(x, y, n)
+			buf.Write([]byte{px.B, px.G, px.R, 0})
+		}
+	}
+	_, err := s.conn.Write(buf.Bytes())
+	return err
+}
+
+func TestFramebuffer_Screenshot(t *testing.T) {
+	srv, conn := newUpdateServer(t, 8, 4)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	img, err := conn.Screenshot(ctx)
+	if err != nil {
+		t.Fatalf("Screenshot failed: %v", err)
+	}
+
+	if img.Bounds() != image.Rect(0, 0, 8, 4) {
+		t.Fatalf("Bounds() = %v", img.Bounds())
+	}
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 8; x++ {
+			if got, want := img.RGBAAt(x, y), updatePixel(x, y, 1); got != want {
+				t.Fatalf("pixel (%d,%d) = %v, want %v", x, y, got, want)
+			}
+		}
+	}
+	if got := srv.requests.Load(); got != 1 {
+		t.Errorf("server received %d requests, want 1", got)
+	}
+}
+
+func TestFramebuffer_CaptureRegion(t *testing.T) {
+	_, conn := newUpdateServer(t, 8, 4)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	region := image.Rect(2, 1, 5, 3)
+	img, err := conn.CaptureRegion(ctx, region)
+	if err != nil {
+		t.Fatalf("CaptureRegion failed: %v", err)
+	}
+
+	if img.Bounds() != region {
+		t.Fatalf("Bounds() = %v, want %v", img.Bounds(), region)
+	}
+	if got, want := img.RGBAAt(4, 2), updatePixel(4, 2, 1); got != want {
+		t.Errorf("pixel (4,2) = %v, want %v", got, want)
+	}
+
+	if _, err := conn.CaptureRegion(ctx, image.Rect(6, 0, 10, 2)); !IsVNCError(err, ErrValidation) {
+		t.Errorf("expected validation error for out-of-bounds region, got %v", err)
+	}
+}
+
+func TestFramebuffer_ConcurrentScreenshots(t *testing.T) {
+	srv, conn := newUpdateServer(t, 16, 16)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	const callers = 16
+	images := make([]*image.RGBA, callers)
+	errs := make([]error, callers)
+
+	var wg sync.WaitGroup
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			images[i], errs[i] = conn.Screenshot(ctx)
+		}(i)
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			t.Fatalf("caller %d: %v", i, err)
+		}
+	}
+
+	if got := srv.requests.Load(); got > callers {
+		t.Errorf("server received %d requests for %d callers", got, callers)
+	}
+
+	// Every caller owns its copy: mutating one must not affect another.
+	images[0].SetRGBA(0, 0, color.RGBA{R: 1, G: 2, B: 3, A: 4})
+	for i := 1; i < callers; i++ {
+		if images[i] == images[0] || images[i].RGBAAt(0, 0) == images[0].RGBAAt(0, 0) {
+			t.Fatalf("caller %d shares pixel data with caller 0", i)
+		}
+	}
+}
+
+func TestFramebuffer_CopyRectOverlap(t *testing.T) {
+	fb := newFramebuffer(4, 1)
+	fb.pf = replayPixelFormat
+	for x := 0; x < 4; x++ {
+		fb.set(x, 0, Color{R: uint16(x * 50)})
+	}
+
+	fb.copyRect(0, 0, 1, 0, 3, 1)
+
+	want := []uint8{0, 0, 50, 100}
+	for x, r := range want {
+		if got := fb.grid.rgbaAt(x, 0).R; got != r {
+			t.Errorf("pixel %d red = %d, want %d", x, got, r)
+		}
+	}
+}
+
+func TestFramebuffer_CopyRectVerification(t *testing.T) {
+	c := &ClientConn{config: &ClientConfig{VerifyCopyRect: true}, logger: &NoOpLogger{}}
+	c.setFrameBufferSize(8, 8)
+	c.setPixelFormat(replayPixelFormat)
+	fb := c.enableFramebuffer()
+	fb.pf = replayPixelFormat
+	fb.fill(0, 0, 4, 4, Color{R: 200})
+
+	c.applyUpdate([]Rectangle{
+		{X: 4, Y: 4, Width: 4, Height: 4, Enc: &CopyRectEncoding{SrcX: 0, SrcY: 0}},
+		{X: 2, Y: 0, Width: 4, Height: 4, Enc: &CopyRectEncoding{SrcX: 0, SrcY: 0}},
+	})
+	if got := c.Stats().CopyRectMismatches; got != 0 {
+		t.Fatalf("CopyRectMismatches = %d after valid copies, want 0", got)
+	}
+	if got := fb.grid.rgbaAt(7, 7).R; got != 200 {
+		t.Errorf("copied pixel red = %d, want 200", got)
+	}
+
+	// A source beyond the framebuffer, as after a missed desktop resize.
+	c.applyUpdate([]Rectangle{
+		{X: 0, Y: 0, Width: 4, Height: 4, Enc: &CopyRectEncoding{SrcX: 6, SrcY: 6}},
+	})
+	if got := c.Stats().CopyRectMismatches; got != 1 {
+		t.Errorf("CopyRectMismatches = %d after an out-of-bounds copy, want 1", got)
+	}
+}
+
+func TestFramebuffer_IndexedColor(t *testing.T) {
+	fb := newFramebuffer(1, 1)
+	fb.pf = PixelFormat{BPP: 8, Depth: 8}
+	fb.set(0, 0, Color{R: 0xffff, G: 0x8000, B: 0})
+
+	if got, want := fb.grid.rgbaAt(0, 0), (color.RGBA{R: 0xff, G: 0x80, A: 0xff}); got != want {
+		t.Errorf("pixel = %v, want %v", got, want)
+	}
+}
+
+func TestFramebuffer_LowPowerConversion(t *testing.T) {
+	for _, max := range []uint16{1, 3, 7, 31, 63, 255, 1023, 65535} {
+		for v := uint16(0); ; v++ {
+			exact, got := int(scaleComponent(v, max)), int(expandComponent(v, max))
+			if got < exact-1 || got > exact+1 {
+				t.Errorf("expandComponent(%d, %d) = %d, want %d within one step", v, max, got, exact)
+			}
+			if v == max {
+				if got != 0xff {
+					t.Errorf("expandComponent(%d, %d) = %d, want 255", v, max, got)
+				}
+				break
+			}
+		}
+	}
+
+	fb := newFramebuffer(1, 1)
+	fb.pf = *PixelFormat16BitRGB565
+	fb.lowPower = true
+	fb.set(0, 0, Color{R: 31, G: 32, B: 0})
+
+	if got, want := fb.grid.rgbaAt(0, 0), (color.RGBA{R: 0xff, G: 0x82, A: 0xff}); got != want {
+		t.Errorf("pixel = %v, want %v", got, want)
+	}
+}
+
+func TestFramebuffer_FrameIsImmutable(t *testing.T) {
+	fb := newFramebuffer(130, 70)
+	fb.pf = replayPixelFormat
+	fb.fill(0, 0, 130, 70, Color{R: 255})
+
+	frame := fb.frame()
+	fb.fill(60, 60, 10, 10, Color{G: 255})
+
+	if got := frame.RGBAAt(65, 65); got != (color.RGBA{R: 0xff, A: 0xff}) {
+		t.Errorf("frame changed after update: %v", got)
+	}
+	if got := fb.grid.rgbaAt(65, 65); got != (color.RGBA{G: 0xff, A: 0xff}) {
+		t.Errorf("framebuffer not updated: %v", got)
+	}
+
+	// Only the tiles touched by the update are copied; the rest stay shared.
+	shared := 0
+	current := fb.frame()
+	for i := range frame.grid.tiles {
+		if frame.grid.tiles[i] == current.grid.tiles[i] {
+			shared++
+		}
+	}
+	if want := len(frame.grid.tiles) - 4; shared != want {
+		t.Errorf("%d tiles shared between frames, want %d", shared, want)
+	}
+}
+
+func TestFramebuffer_FrameSubImage(t *testing.T) {
+	fb := newFramebuffer(8, 8)
+	fb.pf = replayPixelFormat
+	fb.set(5, 5, Color{B: 255})
+
+	sub := fb.frame().SubImage(image.Rect(4, 4, 8, 8))
+	if sub.Bounds() != image.Rect(4, 4, 8, 8) {
+		t.Fatalf("Bounds() = %v", sub.Bounds())
+	}
+	if got := sub.RGBAAt(0, 0); got != (color.RGBA{}) {
+		t.Errorf("pixel outside the view = %v, want transparent", got)
+	}
+
+	img := sub.RGBA()
+	if got := img.RGBAAt(5, 5); got != (color.RGBA{B: 0xff, A: 0xff}) {
+		t.Errorf("RGBA() pixel = %v", got)
+	}
+	if got := img.RGBAAt(4, 4); got != (color.RGBA{A: 0xff}) {
+		t.Errorf("RGBA() untouched pixel = %v, want opaque black", got)
+	}
+}
+
+func TestFramebuffer_ResizeKeepsContent(t *testing.T) {
+	fb := newFramebuffer(4, 4)
+	fb.pf = replayPixelFormat
+	fb.set(1, 1, Color{R: 255})
+
+	fb.resize(100, 2)
+
+	if fb.grid.bounds() != image.Rect(0, 0, 100, 2) {
+		t.Fatalf("bounds = %v", fb.grid.bounds())
+	}
+	if got := fb.grid.rgbaAt(1, 1); got != (color.RGBA{R: 0xff, A: 0xff}) {
+		t.Errorf("pixel (1,1) = %v after resize", got)
+	}
+}
+
+func TestFramebuffer_FrameStableDuringUpdates(t *testing.T) {
+	_, conn := newUpdateServer(t, 80, 80)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if conn.CurrentFrame() != nil {
+		t.Fatal("CurrentFrame() before first capture should be nil")
+	}
+	if _, err := conn.Screenshot(ctx); err != nil {
+		t.Fatal(err)
+	}
+	frame := conn.CurrentFrame()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := conn.Screenshot(ctx)
+		done <- err
+	}()
+
+	// Read the held frame while the second update is decoded.
+	for i := 0; i < 100; i++ {
+		if got, want := frame.RGBAAt(70, 70), updatePixel(70, 70, 1); got != want {
+			t.Fatalf("held frame changed: %v, want %v", got, want)
+		}
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := conn.CurrentFrame().RGBAAt(70, 70), updatePixel(70, 70, 2); got != want {
+		t.Errorf("current frame = %v, want %v", got, want)
+	}
+	if got, want := frame.RGBAAt(70, 70), updatePixel(70, 70, 1); got != want {
+		t.Errorf("held frame changed: %v, want %v", got, want)
+	}
+}
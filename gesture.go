@@ -0,0 +1,168 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package vnc
+
+import (
+	"context"
+	"time"
+)
+
+// Default gesture timing used for zero GestureTiming fields.
+const (
+	DefaultGestureMinDelay            = 10 * time.Millisecond
+	DefaultGestureMaxDelay            = 100 * time.Millisecond
+	DefaultGestureDoubleClickInterval = 400 * time.Millisecond
+)
+
+// GestureTiming controls how composite gestures such as Click, DoubleClick,
+// and Drag pace their pointer events.
+//
+// The delay between events follows the measured round-trip time (see
+// RoundTripTime): half a round trip, clamped to [MinDelay, MaxDelay]. Fast
+// links therefore feel immediate, while slow links do not deliver a press and
+// its release so close together that the server observes neither. DoubleClick
+// additionally shortens its delays so that the time between its two presses,
+// including the expected network jitter, stays within DoubleClickInterval and
+// the server does not see two single clicks.
+type GestureTiming struct {
+	// MinDelay is the delay between events on a low-latency link.
+	MinDelay time.Duration
+
+	// MaxDelay caps the delay between events on a high-latency link.
+	MaxDelay time.Duration
+
+	// DoubleClickInterval is the double-click threshold of the remote desktop.
+	DoubleClickInterval time.Duration
+}
+
+// withDefaults returns t with zero fields replaced by the defaults.
+func (t GestureTiming) withDefaults() GestureTiming {
+	if t.MinDelay <= 0 {
+		t.MinDelay = DefaultGestureMinDelay
+	}
+	if t.MaxDelay <= 0 {
+		t.MaxDelay = DefaultGestureMaxDelay
+	}
+	if t.MaxDelay < t.MinDelay {
+		t.MaxDelay = t.MinDelay
+	}
+	if t.DoubleClickInterval <= 0 {
+		t.DoubleClickInterval = DefaultGestureDoubleClickInterval
+	}
+	return t
+}
+
+// Delay returns the delay between gesture events for a link with the given
+// round-trip time.
+func (t GestureTiming) Delay(rtt time.Duration) time.Duration {
+	t = t.withDefaults()
+	return min(max(rtt/2, t.MinDelay), t.MaxDelay)
+}
+
+// DoubleClickDelay returns the delay DoubleClick uses between its events for a
+// link with the given round-trip time and variation. The two presses are two
+// delays apart, and jitter of up to four times the variation may stretch that
+// gap on its way to the server.
+func (t GestureTiming) DoubleClickDelay(rtt, variation time.Duration) time.Duration {
+	t = t.withDefaults()
+	limit := (t.DoubleClickInterval - 4*variation) / 2
+	return max(min(t.Delay(rtt), limit), t.MinDelay)
+}
+
+// gestureTiming returns the configured gesture timing with defaults applied.
+func (c *ClientConn) gestureTiming() GestureTiming {
+	if c.config == nil {
+		return GestureTiming{}.withDefaults()
+	}
+	return c.config.GestureTiming.withDefaults()
+}
+
+// Click presses and releases button at (x, y).
+//
+// Example usage:
+//
+//	err := client.Click(ctx, vnc.ButtonLeft, 100, 200)
+func (c *ClientConn) Click(ctx context.Context, button ButtonMask, x, y uint16) error {
+	rtt, _ := c.RoundTripTime()
+	return c.click(ctx, button, x, y, c.gestureTiming().Delay(rtt))
+}
+
+// DoubleClick clicks button twice at (x, y), keeping both clicks within the
+// configured DoubleClickInterval after compensating for network latency.
+//
+// Example usage:
+//
+//	// Refresh the latency estimate on an idle connection, then double-click
+//	_, _ = client.Ping(ctx)
+//	err := client.DoubleClick(ctx, vnc.ButtonLeft, 100, 200)
+func (c *ClientConn) DoubleClick(ctx context.Context, button ButtonMask, x, y uint16) error {
+	rtt, variation := c.RoundTripTime()
+	delay := c.gestureTiming().DoubleClickDelay(rtt, variation)
+
+	if err := c.click(ctx, button, x, y, delay); err != nil {
+		return err
+	}
+	if err := sleepContext(ctx, delay); err != nil {
+		return err
+	}
+	return c.click(ctx, button, x, y, delay)
+}
+
+// Drag presses button at (fromX, fromY), moves to (toX, toY) with the button
+// held, and releases it there. If ctx ends mid-gesture the button is still
+// released.
+func (c *ClientConn) Drag(ctx context.Context, button ButtonMask, fromX, fromY, toX, toY uint16) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	rtt, _ := c.RoundTripTime()
+	delay := c.gestureTiming().Delay(rtt)
+
+	if err := c.PointerEvent(button, fromX, fromY); err != nil {
+		return err
+	}
+	if err := sleepContext(ctx, delay); err != nil {
+		_ = c.PointerEvent(0, fromX, fromY)
+		return err
+	}
+	if err := c.PointerEvent(button, toX, toY); err != nil {
+		return err
+	}
+	if err := sleepContext(ctx, delay); err != nil {
+		_ = c.PointerEvent(0, toX, toY)
+		return err
+	}
+	return c.PointerEvent(0, toX, toY)
+}
+
+// click sends a press and a release of button separated by delay. The release
+// is sent even if ctx ends while the button is held.
+func (c *ClientConn) click(ctx context.Context, button ButtonMask, x, y uint16, delay time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if err := c.PointerEvent(button, x, y); err != nil {
+		return err
+	}
+	sleepErr := sleepContext(ctx, delay)
+	if err := c.PointerEvent(0, x, y); err != nil {
+		return err
+	}
+	return sleepErr
+}
+
+// sleepContext waits for d or until ctx is done.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
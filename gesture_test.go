@@ -0,0 +1,102 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package vnc
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/tenthirtyam/go-vnc/rfb"
+)
+
+func TestGesture_Delay(t *testing.T) {
+	timing := GestureTiming{}
+
+	tests := []struct {
+		name      string
+		rtt       time.Duration
+		variation time.Duration
+		delay     time.Duration
+		double    time.Duration
+	}{
+		{name: "Unmeasured", delay: 10 * time.Millisecond, double: 10 * time.Millisecond},
+		{name: "LAN", rtt: time.Millisecond, delay: 10 * time.Millisecond, double: 10 * time.Millisecond},
+		{name: "WAN", rtt: 120 * time.Millisecond, variation: 10 * time.Millisecond, delay: 60 * time.Millisecond, double: 60 * time.Millisecond},
+		{name: "Satellite", rtt: 600 * time.Millisecond, variation: 20 * time.Millisecond, delay: 100 * time.Millisecond, double: 100 * time.Millisecond},
+		{name: "Jittery", rtt: 200 * time.Millisecond, variation: 80 * time.Millisecond, delay: 100 * time.Millisecond, double: 40 * time.Millisecond},
+		{name: "Extreme jitter", rtt: 200 * time.Millisecond, variation: time.Second, delay: 100 * time.Millisecond, double: 10 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := timing.Delay(tt.rtt); got != tt.delay {
+				t.Errorf("Delay(%v) = %v, want %v", tt.rtt, got, tt.delay)
+			}
+			if got := timing.DoubleClickDelay(tt.rtt, tt.variation); got != tt.double {
+				t.Errorf("DoubleClickDelay(%v, %v) = %v, want %v", tt.rtt, tt.variation, got, tt.double)
+			}
+		})
+	}
+}
+
+func TestGesture_LatencyEstimator(t *testing.T) {
+	var l latencyEstimator
+	l.add(100 * time.Millisecond)
+	if l.srtt != 100*time.Millisecond || l.rttvar != 50*time.Millisecond {
+		t.Fatalf("first sample: srtt %v, rttvar %v", l.srtt, l.rttvar)
+	}
+
+	l.add(20 * time.Millisecond)
+	if l.srtt != 90*time.Millisecond || l.rttvar != 57500*time.Microsecond {
+		t.Errorf("second sample: srtt %v, rttvar %v", l.srtt, l.rttvar)
+	}
+}
+
+func TestGesture_PingAndDoubleClick(t *testing.T) {
+	srv, conn := newUpdateServer(t, 8, 8)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rtt, err := conn.Ping(ctx)
+	if err != nil {
+		t.Fatalf("Ping failed: %v", err)
+	}
+	if srtt, _ := conn.RoundTripTime(); rtt <= 0 || srtt <= 0 {
+		t.Errorf("Ping() = %v, RoundTripTime() = %v; want positive durations", rtt, srtt)
+	}
+	if got := conn.Stats().RoundTripTime; got <= 0 {
+		t.Errorf("Stats().RoundTripTime = %v", got)
+	}
+
+	if err := conn.DoubleClick(ctx, ButtonLeft, 2, 3); err != nil {
+		t.Fatalf("DoubleClick failed: %v", err)
+	}
+	want := []rfb.PointerEvent{
+		{Mask: 1, X: 2, Y: 3}, {X: 2, Y: 3},
+		{Mask: 1, X: 2, Y: 3}, {X: 2, Y: 3},
+	}
+	for i, ev := range receivePointers(t, srv, len(want)) {
+		if ev != want[i] {
+			t.Errorf("event %d = %+v, want %+v", i, ev, want[i])
+		}
+	}
+
+	if err := conn.Drag(ctx, ButtonLeft, 1, 1, 6, 6); err != nil {
+		t.Fatalf("Drag failed: %v", err)
+	}
+	want = []rfb.PointerEvent{{Mask: 1, X: 1, Y: 1}, {Mask: 1, X: 6, Y: 6}, {X: 6, Y: 6}}
+	for i, ev := range receivePointers(t, srv, len(want)) {
+		if ev != want[i] {
+			t.Errorf("drag event %d = %+v, want %+v", i, ev, want[i])
+		}
+	}
+
+	canceled, cancelNow := context.WithCancel(context.Background())
+	cancelNow()
+	if err := conn.Click(canceled, ButtonLeft, 1, 1); err != context.Canceled {
+		t.Errorf("Click with canceled context = %v, want context.Canceled", err)
+	}
+}
@@ -0,0 +1,303 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package vnc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"time"
+)
+
+// SessionStateVersion is the format version of SessionState written by Detach.
+const SessionStateVersion = 1
+
+// errDetached is returned by readServerMessage when Detach interrupts the
+// wait for the next server message.
+var errDetached = errors.New("connection detached")
+
+// SessionState is the serializable state of an established session, produced
+// by Detach and consumed by Resume. It is encoded as JSON when a session is
+// handed to another process with SendSession.
+type SessionState struct {
+	// Version is the format version, SessionStateVersion.
+	Version int `json:"version"`
+
+	// ConnID is carried over so errors and logs of the resumed session can be
+	// correlated with those of the original process.
+	ConnID string `json:"conn_id"`
+
+	// ProtocolMinor is the negotiated minor version of RFB 3. Zero, from
+	// states written before it was recorded, means 8.
+	ProtocolMinor uint `json:"protocol_minor,omitempty"`
+
+	Width       uint16      `json:"width"`
+	Height      uint16      `json:"height"`
+	DesktopName string      `json:"desktop_name"`
+	PixelFormat PixelFormat `json:"pixel_format"`
+
+	// ColorMap is only set for indexed-color pixel formats.
+	ColorMap []Color `json:"color_map,omitempty"`
+
+	// Encodings lists the encoding types last sent with SetEncodings.
+	Encodings []int32 `json:"encodings"`
+
+	// ExtendedMouseButtons records that the server confirmed the extended
+	// mouse buttons pseudo-encoding.
+	ExtendedMouseButtons bool `json:"extended_mouse_buttons"`
+
+	// QEMUExtendedKeyEvents records that the server confirmed the QEMU
+	// extended key event pseudo-encoding.
+	QEMUExtendedKeyEvents bool `json:"qemu_extended_key_events"`
+
+	// Fence records that the server confirmed the Fence pseudo-encoding.
+	Fence bool `json:"fence"`
+
+	// ContinuousUpdates records that the server confirmed the
+	// ContinuousUpdates pseudo-encoding.
+	ContinuousUpdates bool `json:"continuous_updates"`
+
+	// ContinuousUpdatesActive records that continuous updates were enabled
+	// and the server had not ended them.
+	ContinuousUpdatesActive bool `json:"continuous_updates_active,omitempty"`
+
+	// PendingMessageType is the type byte of a server message that had started
+	// arriving when the session was detached. Its body is still unread on the
+	// connection.
+	PendingMessageType *uint8 `json:"pending_message_type,omitempty"`
+}
+
+// Detach stops processing server messages at a message boundary and returns a
+// duplicate of the connection's file descriptor along with the session state,
+// so that another process can continue the session with Resume (see
+// Handoff). The ClientConn is closed afterwards; the returned file keeps the
+// network connection open and must be closed by the caller once it has been
+// handed over.
+//
+// The underlying net.Conn must provide File, as *net.TCPConn and
+// *net.UnixConn do. Client-side framebuffer contents are not transferred; the
+// resumed session should request a full update.
+//
+// Sessions that have received ZRLE, Zlib, Tight, or H.264 data hold
+// decompression state spanning rectangles, which the resumed process could
+// not rebuild, so Detach fails for them with an ErrUnsupported error. When
+// Detach fails, message processing resumes where it stopped and the session
+// remains usable.
+func (c *ClientConn) Detach() (*os.File, SessionState, error) {
+	filer, ok := c.c.(interface{ File() (*os.File, error) })
+	if !ok {
+		return nil, SessionState{}, c.enrichError(unsupportedError("Detach",
+			"connection does not expose a file descriptor", nil))
+	}
+
+	c.detachMu.Lock()
+	defer c.detachMu.Unlock()
+	if c.ctx.Err() != nil {
+		return nil, SessionState{}, c.enrichError(networkError("Detach", "connection closed", c.ctx.Err()))
+	}
+
+	// Interrupt the wait for the next message, then take the pump so that no
+	// message is read partially.
+	close(c.detaching)
+	c.pumpMu.Lock()
+	defer c.pumpMu.Unlock()
+
+	var pending *messageTypeResult
+	if c.pendingType != nil {
+		_ = c.c.SetReadDeadline(time.Now())
+		result := <-c.pendingType
+		c.pendingType = nil
+		if !errors.Is(result.err, os.ErrDeadlineExceeded) {
+			pending = &result
+		}
+	}
+	fail := func(err error) (*os.File, SessionState, error) {
+		c.resumePump(pending)
+		return nil, SessionState{}, c.enrichError(err)
+	}
+
+	if pending != nil && pending.err != nil {
+		return fail(networkError("Detach", "failed to read message type", pending.err))
+	}
+	if encodingType, ok := c.streamingDecoder(); ok {
+		return fail(unsupportedError("Detach",
+			fmt.Sprintf("decoder of encoding %d holds stream state that cannot be resumed", encodingType), nil))
+	}
+	if err := c.c.SetReadDeadline(time.Time{}); err != nil {
+		return fail(networkError("Detach", "failed to clear read deadline", err))
+	}
+
+	f, err := filer.File()
+	if err != nil {
+		return fail(networkError("Detach", "failed to duplicate connection", err))
+	}
+
+	state := SessionState{
+		Version:                 SessionStateVersion,
+		ConnID:                  c.connID,
+		ProtocolMinor:           c.protocolMinor,
+		DesktopName:             c.GetDesktopName(),
+		PixelFormat:             c.GetPixelFormat(),
+		ExtendedMouseButtons:    c.extendedMouseButtons.Load(),
+		QEMUExtendedKeyEvents:   c.qemuExtendedKeyEvents.Load(),
+		Fence:                   c.fence.Load(),
+		ContinuousUpdates:       c.continuousUpdates.Load(),
+		ContinuousUpdatesActive: c.continuousUpdatesActive.Load(),
+	}
+	if pending != nil {
+		state.PendingMessageType = &pending.messageType
+	}
+	state.Width, state.Height = c.GetFrameBufferSize()
+	if !state.PixelFormat.TrueColor {
+		colorMap := c.GetColorMap()
+		state.ColorMap = colorMap[:]
+	}
+	state.Encodings = []int32{}
+	for _, enc := range c.GetEncodings() {
+		state.Encodings = append(state.Encodings, enc.Type())
+	}
+
+	_ = c.Close()
+	return f, state, nil
+}
+
+// resumePump lets message processing continue after a failed Detach, with
+// the message type read while stopping it, if any. It is called with the pump
+// held.
+func (c *ClientConn) resumePump(pending *messageTypeResult) {
+	_ = c.c.SetReadDeadline(time.Time{})
+	if pending != nil {
+		c.pendingType = make(chan messageTypeResult, 1)
+		c.pendingType <- *pending
+	}
+	c.detaching = make(chan struct{})
+}
+
+// Resume continues a session detached by another ClientConn on conn, which
+// must be the connection that was detached, without repeating the handshake.
+// Encodings in the state are matched by type against the built-in encodings
+// and any passed with WithInitialEncodings; WithInitialEncodings,
+// WithPixelFormat, and WithAutoFullUpdate are otherwise ignored, as the
+// session is already configured.
+//
+// Example usage:
+//
+//	conn, state, err := vnc.ReceiveSession(supervisor)
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	client, err := vnc.Resume(ctx, conn, state, vnc.WithServerMessageChannel(msgCh))
+func Resume(ctx context.Context, conn net.Conn, state SessionState, options ...ClientOption) (*ClientConn, error) {
+	if state.Version != SessionStateVersion {
+		return nil, unsupportedError("Resume", "unsupported session state version", nil)
+	}
+
+	cfg := &ClientConfig{}
+	for _, option := range options {
+		option(cfg)
+	}
+
+	var logger Logger = &NoOpLogger{}
+	if cfg.Logger != nil {
+		logger = cfg.Logger
+	}
+
+	connCtx, cancel := context.WithCancel(ctx)
+	c := &ClientConn{
+		c:         conn,
+		config:    cfg,
+		ctx:       connCtx,
+		cancel:    cancel,
+		connID:    state.ConnID,
+		detaching: make(chan struct{}),
+	}
+	c.logger = &transcriptLogger{Logger: logger, transcript: &c.transcript}
+	if c.connID == "" {
+		c.connID = newConnID()
+	}
+	c.protocolMinor = state.ProtocolMinor
+	if c.protocolMinor == 0 {
+		c.protocolMinor = 8
+	}
+
+	c.setFrameBufferSize(state.Width, state.Height)
+	c.setDesktopName(state.DesktopName)
+	c.setPixelFormat(state.PixelFormat)
+	if len(state.ColorMap) > 0 {
+		if len(state.ColorMap) > ColorMapSize {
+			cancel()
+			return nil, c.enrichError(validationError("Resume", "color map too large", nil))
+		}
+		c.setColorMapEntries(0, state.ColorMap)
+	}
+
+	encs, err := resumeEncodings(state.Encodings, cfg.InitialEncodings)
+	if err != nil {
+		cancel()
+		return nil, c.enrichError(err)
+	}
+	c.setEncodings(encs)
+	c.extendedMouseButtons.Store(state.ExtendedMouseButtons)
+	c.qemuExtendedKeyEvents.Store(state.QEMUExtendedKeyEvents)
+	c.fence.Store(state.Fence)
+	c.continuousUpdates.Store(state.ContinuousUpdates)
+	c.continuousUpdatesActive.Store(state.ContinuousUpdatesActive)
+
+	if state.PendingMessageType != nil {
+		pending := make(chan messageTypeResult, 1)
+		pending <- messageTypeResult{messageType: *state.PendingMessageType}
+		c.pendingType = pending
+	}
+
+	c.setPhase(PhaseSession)
+	c.messageTypes = newServerMessageTypes(cfg)
+
+	if !cfg.ManualPump {
+		c.goTracked(c.mainLoop)
+	}
+	c.startIdleWatch()
+	c.startKeepalive()
+	return c, nil
+}
+
+// resumeEncodings maps encoding types to Encoding values, preferring the
+// candidates over the built-in encodings.
+func resumeEncodings(types []int32, candidates []Encoding) ([]Encoding, error) {
+	candidates = append(append([]Encoding{}, candidates...),
+		&RawEncoding{},
+		&CopyRectEncoding{},
+		&RREEncoding{},
+		&HextileEncoding{},
+		&CursorPseudoEncoding{},
+		&AlphaCursorPseudoEncoding{},
+		&XCursorPseudoEncoding{},
+		&VMwareCursorPseudoEncoding{},
+		&VMwareCursorStatePseudoEncoding{},
+		&VMwareCursorPositionPseudoEncoding{},
+		&DesktopSizePseudoEncoding{},
+		&DesktopNamePseudoEncoding{},
+		&LEDStatePseudoEncoding{},
+		&ExtendedDesktopSizePseudoEncoding{},
+		&ExtendedMouseButtonsPseudoEncoding{},
+		&QEMUExtendedKeyEventPseudoEncoding{},
+		&FencePseudoEncoding{},
+		&ContinuousUpdatesPseudoEncoding{},
+	)
+
+	encs := make([]Encoding, 0, len(types))
+Types:
+	for _, encType := range types {
+		for _, enc := range candidates {
+			if enc.Type() == encType {
+				encs = append(encs, enc)
+				continue Types
+			}
+		}
+		return nil, configurationError("Resume",
+			"no encoding registered for type in session state; pass it with WithInitialEncodings", nil)
+	}
+	return encs, nil
+}
@@ -0,0 +1,146 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+//go:build unix && !vnc_minimal
+
+package vnc
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/tenthirtyam/go-vnc/rfb"
+)
+
+func TestHandoff_DetachStreamingDecoder(t *testing.T) {
+	var compressed bytes.Buffer
+	zw := zlib.NewWriter(&compressed)
+	_, _ = zw.Write([]byte{1, 2, 3})
+	_ = zw.Flush()
+
+	tests := []struct {
+		name  string
+		start func(c *ClientConn) error
+	}{
+		{"ZRLE", func(c *ClientConn) error {
+			_, err := decoderState[zlibStream](c, rfb.EncodingZRLE).feed(compressed.Bytes())
+			return err
+		}},
+		{"Zlib", func(c *ClientConn) error {
+			_, err := decoderState[zlibStream](c, rfb.EncodingZlib).feed(compressed.Bytes())
+			return err
+		}},
+		{"Tight", func(c *ClientConn) error {
+			_, err := decoderState[tightState](c, rfb.EncodingTight).streams[2].feed(compressed.Bytes())
+			return err
+		}},
+		{"H.264", func(c *ClientConn) error {
+			_, err := decoderState[h264State](c, rfb.EncodingH264).decoder(h264Key{width: 1, height: 1},
+				func(width, height int) (VideoDecoder, error) {
+					return &fakeVideoDecoder{width: width, height: height}, nil
+				})
+			return err
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			address, accepted := handoffServer(t)
+			tcpConn, err := net.Dial("tcp", address)
+			if err != nil {
+				t.Fatal(err)
+			}
+			conn, err := ClientWithOptions(context.Background(), tcpConn,
+				WithAuth(&ClientAuthNone{}), WithManualPump(true))
+			if err != nil {
+				t.Fatalf("handshake failed: %v", err)
+			}
+			if err := tt.start(conn); err != nil {
+				t.Fatal(err)
+			}
+
+			server := <-accepted
+			// Detach interrupts the wait for a message type, which must then
+			// be read once processing resumes.
+			pollCtx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+			_, err = conn.ProcessNextMessage(pollCtx)
+			cancel()
+			if !errors.Is(err, context.DeadlineExceeded) {
+				t.Fatalf("ProcessNextMessage() error = %v, want %v", err, context.DeadlineExceeded)
+			}
+			if _, err := server.Write([]byte{rfb.BellMsg}); err != nil {
+				t.Fatal(err)
+			}
+
+			f, _, err := conn.Detach()
+			if !IsVNCError(err, ErrUnsupported) {
+				t.Fatalf("Detach() error = %v, want unsupported error", err)
+			}
+			if f != nil {
+				_ = f.Close()
+				t.Error("Detach() returned a file")
+			}
+
+			msg, err := conn.ProcessNextMessage(context.Background())
+			if _, ok := msg.(*BellMessage); !ok || err != nil {
+				t.Errorf("ProcessNextMessage() after Detach = %T, %v, want the bell", msg, err)
+			}
+			_ = conn.Close()
+		})
+	}
+}
+
+func TestHandoff_DetachKeepsSession(t *testing.T) {
+	address, accepted := handoffServer(t)
+	tcpConn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msgs := make(chan ServerMessage, 1)
+	conn, err := ClientWithOptions(context.Background(), tcpConn, WithAuth(&ClientAuthNone{}),
+		WithInitialEncodings(&ZlibEncoding{}, &RawEncoding{}), WithServerMessageChannel(msgs))
+	if err != nil {
+		t.Fatalf("handshake failed: %v", err)
+	}
+	defer func() { _ = conn.CloseAndWait() }()
+	server := <-accepted
+
+	receive := func(want ServerMessage) {
+		t.Helper()
+		select {
+		case msg := <-msgs:
+			if reflect.TypeOf(msg) != reflect.TypeOf(want) {
+				t.Fatalf("received %T, want %T", msg, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("received no %T", want)
+		}
+	}
+
+	// A Zlib rectangle starts the zlib stream of the connection.
+	var compressed bytes.Buffer
+	zw := zlib.NewWriter(&compressed)
+	_, _ = zw.Write(make([]byte, 4))
+	_ = zw.Flush()
+	update := &replayStream{}
+	update.write(rfb.FramebufferUpdateMsg, uint8(0), uint16(1))
+	update.rect(0, 0, 1, 1, rfb.EncodingZlib)
+	update.write(uint32(compressed.Len()), compressed.Bytes())
+	if _, err := server.Write(update.bytes()); err != nil {
+		t.Fatal(err)
+	}
+	receive(&FramebufferUpdateMessage{})
+
+	if _, _, err := conn.Detach(); !IsVNCError(err, ErrUnsupported) {
+		t.Fatalf("Detach() error = %v, want unsupported error", err)
+	}
+	if _, err := server.Write([]byte{rfb.BellMsg}); err != nil {
+		t.Fatal(err)
+	}
+	receive(new(BellMessage))
+}
@@ -0,0 +1,96 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+//go:build unix
+
+package vnc
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+)
+
+// maxSessionStateSize bounds the serialized SessionState accepted by
+// ReceiveSession.
+const maxSessionStateSize = 64 * 1024
+
+// Handoff detaches the session and sends it over the Unix domain socket uc to a
+// process that calls ReceiveSession and Resume. The ClientConn is closed
+// whether or not the handoff succeeds.
+//
+// Example usage:
+//
+//	// Supervisor: hand the live session to a freshly started worker.
+//	if err := client.Handoff(workerConn); err != nil {
+//		log.Printf("handoff failed: %v", err)
+//	}
+func (c *ClientConn) Handoff(uc *net.UnixConn) error {
+	f, state, err := c.Detach()
+	if err != nil {
+		_ = c.Close()
+		return err
+	}
+	defer f.Close()
+
+	return SendSession(uc, f, state)
+}
+
+// SendSession sends the file descriptor f and the session state over the Unix
+// domain socket uc using SCM_RIGHTS.
+func SendSession(uc *net.UnixConn, f *os.File, state SessionState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return encodingError("SendSession", "failed to encode session state", err)
+	}
+	if len(data) > maxSessionStateSize {
+		return validationError("SendSession", "session state too large", nil)
+	}
+
+	rights := syscall.UnixRights(int(f.Fd())) // #nosec G115 - file descriptors fit in int
+	n, oobn, err := uc.WriteMsgUnix(data, rights, nil)
+	if err != nil {
+		return networkError("SendSession", "failed to send session", err)
+	}
+	if n != len(data) || oobn != len(rights) {
+		return networkError("SendSession", "short write sending session", nil)
+	}
+	return nil
+}
+
+// ReceiveSession receives a session sent with SendSession or Handoff and
+// returns the connection and state to pass to Resume.
+func ReceiveSession(uc *net.UnixConn) (net.Conn, SessionState, error) {
+	data := make([]byte, maxSessionStateSize)
+	oob := make([]byte, syscall.CmsgSpace(4))
+	n, oobn, _, _, err := uc.ReadMsgUnix(data, oob)
+	if err != nil {
+		return nil, SessionState{}, networkError("ReceiveSession", "failed to receive session", err)
+	}
+
+	messages, err := syscall.ParseSocketControlMessage(oob[:oobn])
+	if err != nil || len(messages) != 1 {
+		return nil, SessionState{}, protocolError("ReceiveSession", "missing file descriptor", err)
+	}
+	fds, err := syscall.ParseUnixRights(&messages[0])
+	if err != nil || len(fds) != 1 {
+		return nil, SessionState{}, protocolError("ReceiveSession", "invalid file descriptor message", err)
+	}
+
+	f := os.NewFile(uintptr(fds[0]), "vnc-session") // #nosec G115 - file descriptors are non-negative
+	defer f.Close()
+
+	var state SessionState
+	if err := json.Unmarshal(data[:n], &state); err != nil {
+		return nil, SessionState{}, protocolError("ReceiveSession", "invalid session state", err)
+	}
+
+	conn, err := net.FileConn(f)
+	if err != nil {
+		return nil, SessionState{}, networkError("ReceiveSession",
+			fmt.Sprintf("failed to restore connection for session %s", state.ConnID), err)
+	}
+	return conn, state, nil
+}
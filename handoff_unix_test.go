@@ -0,0 +1,200 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+//go:build unix
+
+package vnc
+
+import (
+	"context"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/tenthirtyam/go-vnc/rfb"
+)
+
+// unixSocketPair returns the two ends of a connected Unix domain socket pair.
+func unixSocketPair(t *testing.T) (*net.UnixConn, *net.UnixConn) {
+	t.Helper()
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ends := make([]*net.UnixConn, 2)
+	for i, fd := range fds {
+		f := os.NewFile(uintptr(fd), "socketpair")
+		conn, err := net.FileConn(f)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		ends[i] = conn.(*net.UnixConn)
+	}
+	return ends[0], ends[1]
+}
+
+// handoffServer accepts one TCP client, completes the handshake, and returns
+// the server side of the connection.
+func handoffServer(t *testing.T) (string, <-chan net.Conn) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		t.Cleanup(func() { conn.Close() })
+		_, _ = conn.Write(replayHandshake(8, 8, "handoff"))
+		// Protocol version, security type, and ClientInit.
+		_, _ = io.ReadFull(conn, make([]byte, 12+1+1))
+		accepted <- conn
+	}()
+	return ln.Addr().String(), accepted
+}
+
+func TestHandoff_Resume(t *testing.T) {
+	address, accepted := handoffServer(t)
+
+	tcpConn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	original, err := ClientWithOptions(context.Background(), tcpConn,
+		WithAuth(&ClientAuthNone{}), WithManualPump(true))
+	if err != nil {
+		t.Fatalf("handshake failed: %v", err)
+	}
+	server := <-accepted
+
+	if err := original.SetEncodings([]Encoding{&HextileEncoding{}, &RawEncoding{}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rfb.ReadMessageType(server); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rfb.ReadSetEncodings(server); err != nil {
+		t.Fatal(err)
+	}
+
+	// Leave a message half-delivered: its type byte arrives while the client
+	// is waiting for the next message, and the body follows the handoff.
+	pollCtx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	_, _ = original.ProcessNextMessage(pollCtx)
+	cancel()
+	if _, err := server.Write([]byte{rfb.ServerCutTextMsg}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	supervisor, worker := unixSocketPair(t)
+	if err := original.Handoff(supervisor); err != nil {
+		t.Fatalf("Handoff failed: %v", err)
+	}
+
+	conn, state, err := ReceiveSession(worker)
+	if err != nil {
+		t.Fatalf("ReceiveSession failed: %v", err)
+	}
+	if state.PendingMessageType == nil || *state.PendingMessageType != rfb.ServerCutTextMsg {
+		t.Fatalf("pending message type = %v, want ServerCutText", state.PendingMessageType)
+	}
+	if state.DesktopName != "handoff" || state.Width != 8 || len(state.Encodings) != 2 {
+		t.Errorf("state = %+v", state)
+	}
+
+	msgs := make(chan ServerMessage, 1)
+	resumed, err := Resume(context.Background(), conn, state, WithServerMessageChannel(msgs))
+	if err != nil {
+		t.Fatalf("Resume failed: %v", err)
+	}
+	defer func() { _ = resumed.CloseAndWait() }()
+
+	if resumed.ConnID() != original.ConnID() || len(resumed.GetEncodings()) != 2 {
+		t.Errorf("resumed session lost state: conn ID %q, encodings %v", resumed.ConnID(), resumed.GetEncodings())
+	}
+
+	var s replayStream
+	s.write([]byte{0, 0, 0}, uint32(2), []byte("ok"))
+	if _, err := server.Write(s.bytes()); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-msgs:
+		if cut, ok := msg.(*ServerCutTextMessage); !ok || cut.Text != "ok" {
+			t.Fatalf("resumed session received %#v", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("resumed session received no message")
+	}
+
+	if err := resumed.PointerEvent(ButtonLeft, 1, 2); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rfb.ReadMessageType(server); err != nil {
+		t.Fatal(err)
+	}
+	if ev, err := rfb.ReadPointerEvent(server); err != nil || ev != (rfb.PointerEvent{Mask: 1, X: 1, Y: 2}) {
+		t.Fatalf("server received %+v, %v", ev, err)
+	}
+}
+
+func TestHandoff_DetachRequiresFile(t *testing.T) {
+	conn, _ := runReplay(t, replayCase{handshake: replayHandshake(4, 4, "pipe")})
+	if _, _, err := conn.Detach(); !IsVNCError(err, ErrUnsupported) {
+		t.Fatalf("expected unsupported error for net.Pipe, got %v", err)
+	}
+}
+
+func TestHandoff_MainLoop(t *testing.T) {
+	address, accepted := handoffServer(t)
+
+	tcpConn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	original, err := ClientWithOptions(context.Background(), tcpConn, WithAuth(&ClientAuthNone{}))
+	if err != nil {
+		t.Fatalf("handshake failed: %v", err)
+	}
+	server := <-accepted
+
+	supervisor, worker := unixSocketPair(t)
+	if err := original.Handoff(supervisor); err != nil {
+		t.Fatalf("Handoff failed: %v", err)
+	}
+	conn, state, err := ReceiveSession(worker)
+	if err != nil {
+		t.Fatalf("ReceiveSession failed: %v", err)
+	}
+
+	msgs := make(chan ServerMessage, 1)
+	resumed, err := Resume(context.Background(), conn, state, WithServerMessageChannel(msgs))
+	if err != nil {
+		t.Fatalf("Resume failed: %v", err)
+	}
+	defer func() { _ = resumed.CloseAndWait() }()
+
+	if _, err := server.Write([]byte{rfb.BellMsg}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-msgs:
+		if _, ok := msg.(*BellMessage); !ok {
+			t.Fatalf("resumed session received %T", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("resumed session received no message")
+	}
+}
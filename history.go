@@ -0,0 +1,119 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package vnc
+
+import (
+	"sync"
+	"time"
+)
+
+// defaultFrameHistoryLimit bounds the frame history when only a time window is
+// configured.
+const defaultFrameHistoryLimit = 600
+
+// HistoryFrame is a frame kept by the frame history, with the time the update
+// that produced it was applied.
+type HistoryFrame struct {
+	Time  time.Time
+	Frame *Frame
+}
+
+// WithFrameHistory keeps the frames produced by the most recent framebuffer
+// updates in memory for RecentFrames. At most frames frames are kept, and
+// with a positive window none older than window; a window alone keeps up to
+// 600 frames. Frames share unchanged tiles, so the cost is proportional to
+// how much of the desktop changes rather than to its size.
+//
+// Example usage:
+//
+//	client, err := vnc.ClientWithOptions(ctx, conn,
+//		vnc.WithAuth(auth),
+//		vnc.WithFrameHistory(100, 30*time.Second),
+//	)
+func WithFrameHistory(frames int, window time.Duration) ClientOption {
+	return func(cfg *ClientConfig) {
+		cfg.FrameHistory = frames
+		cfg.FrameHistoryWindow = window
+	}
+}
+
+// RecentFrames returns the frames kept by the frame history, oldest first, or
+// nil if it is not enabled. It is meant for failure handlers that need to show
+// what the screen looked like just before an error, without recording the
+// session continuously:
+//
+//	if err := waitForLogin(ctx, client); err != nil {
+//		for i, f := range client.RecentFrames() {
+//			out, _ := os.Create(fmt.Sprintf("frame-%03d.png", i))
+//			_ = png.Encode(out, f.Frame)
+//			out.Close()
+//		}
+//	}
+func (c *ClientConn) RecentFrames() []HistoryFrame {
+	return c.history.snapshot(time.Now())
+}
+
+// frameHistoryEnabled reports whether the configuration enables the frame
+// history.
+func (c *ClientConn) frameHistoryEnabled() bool {
+	return c.config != nil && (c.config.FrameHistory > 0 || c.config.FrameHistoryWindow > 0)
+}
+
+// recordFrame adds the current state of fb to the frame history.
+func (c *ClientConn) recordFrame(fb *framebuffer) {
+	if !c.frameHistoryEnabled() {
+		return
+	}
+
+	limit := c.config.FrameHistory
+	if limit <= 0 {
+		limit = defaultFrameHistoryLimit
+	}
+	c.history.add(HistoryFrame{Time: time.Now(), Frame: c.displayFrame(fb.frame())}, limit, c.config.FrameHistoryWindow)
+}
+
+// frameHistory is a ring buffer of recent frames.
+type frameHistory struct {
+	mu     sync.Mutex
+	frames []HistoryFrame
+	next   int
+	count  int
+	window time.Duration
+}
+
+// add appends a frame, replacing the oldest one once limit frames are kept.
+func (h *frameHistory) add(frame HistoryFrame, limit int, window time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if len(h.frames) != limit {
+		h.frames = make([]HistoryFrame, limit)
+		h.next, h.count = 0, 0
+	}
+	h.window = window
+	h.frames[h.next] = frame
+	h.next = (h.next + 1) % limit
+	h.count = min(h.count+1, limit)
+}
+
+// snapshot returns the kept frames, oldest first, dropping those outside the
+// window. The newest frame is always kept, because it is still the current
+// state of the desktop.
+func (h *frameHistory) snapshot(now time.Time) []HistoryFrame {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.count == 0 {
+		return nil
+	}
+	frames := make([]HistoryFrame, 0, h.count)
+	for i := range h.count {
+		frame := h.frames[(h.next-h.count+i+len(h.frames))%len(h.frames)]
+		if h.window > 0 && now.Sub(frame.Time) > h.window && i < h.count-1 {
+			continue
+		}
+		frames = append(frames, frame)
+	}
+	return frames
+}
@@ -0,0 +1,63 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package vnc
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestFrameHistory_RecentFrames(t *testing.T) {
+	_, conn := newUpdateServer(t, 4, 3, WithFrameHistory(2, 0))
+
+	if frames := conn.RecentFrames(); frames != nil {
+		t.Fatalf("RecentFrames() = %d frames before any update", len(frames))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var last [3]uint8
+	for i := range last {
+		img, err := conn.Screenshot(ctx)
+		if err != nil {
+			t.Fatalf("Screenshot failed: %v", err)
+		}
+		last[i] = img.RGBAAt(0, 0).B
+	}
+
+	frames := conn.RecentFrames()
+	if len(frames) != 2 {
+		t.Fatalf("RecentFrames() = %d frames, want 2", len(frames))
+	}
+	for i, want := range last[1:] {
+		if got := frames[i].Frame.RGBAAt(0, 0).B; got != want {
+			t.Errorf("frame %d has blue %d, want %d", i, got, want)
+		}
+	}
+	if frames[1].Time.Before(frames[0].Time) {
+		t.Error("frames are not oldest first")
+	}
+}
+
+func TestFrameHistory_Window(t *testing.T) {
+	var h frameHistory
+	now := time.Now()
+	for i := range 5 {
+		h.add(HistoryFrame{Time: now.Add(time.Duration(i-4) * time.Second)}, 10, 2500*time.Millisecond)
+	}
+
+	frames := h.snapshot(now)
+	if len(frames) != 3 {
+		t.Fatalf("snapshot() = %d frames, want the 3 inside the window", len(frames))
+	}
+	if !frames[0].Time.Equal(now.Add(-2 * time.Second)) {
+		t.Errorf("oldest frame is %v before now, want 2s", now.Sub(frames[0].Time))
+	}
+
+	// The newest frame is the current desktop, so it is kept however old.
+	if frames := h.snapshot(now.Add(time.Hour)); len(frames) != 1 || !frames[0].Time.Equal(now) {
+		t.Errorf("snapshot() after an idle hour = %+v, want the newest frame", frames)
+	}
+}
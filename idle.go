@@ -0,0 +1,73 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package vnc
+
+import "time"
+
+// WithIdleDisconnect closes the connection once no message has been sent to
+// the server for d, freeing the server slot of a session nobody is using.
+// Every message the client sends other than the keepalives of WithKeepalive
+// counts as activity, including input events, update requests, and the
+// Pings of StartAdaptiveEncodings; server messages do not, so a viewer that
+// stops requesting updates goes idle even while the server streams
+// continuous updates.
+//
+// IdleDisconnected reports whether the connection was closed this way. A
+// Session keeps the configuration of the closed connection and
+// re-establishes it on the next call to Session.Client, which suits pools
+// holding many rarely used targets:
+//
+//	session := vnc.NewSession(dial, vnc.WithIdleDisconnect(5*time.Minute))
+//	defer session.Close()
+//
+//	client, err := session.Client(ctx) // reconnects if the session went idle
+func WithIdleDisconnect(d time.Duration) ClientOption {
+	return func(cfg *ClientConfig) {
+		cfg.IdleDisconnect = d
+	}
+}
+
+// IdleDisconnected reports whether the connection was closed by
+// WithIdleDisconnect.
+func (c *ClientConn) IdleDisconnected() bool {
+	return c.idleClosed.Load()
+}
+
+// startIdleWatch starts the goroutine that closes the connection once it has
+// been idle for IdleDisconnect.
+func (c *ClientConn) startIdleWatch() {
+	if c.config == nil || c.config.IdleDisconnect <= 0 {
+		return
+	}
+	c.lastActivity.Store(time.Now().UnixNano())
+	c.goTracked(c.watchIdle)
+}
+
+// watchIdle closes the connection once no message has been sent for
+// IdleDisconnect, returning when the connection closes.
+func (c *ClientConn) watchIdle() {
+	limit := c.config.IdleDisconnect
+	timer := time.NewTimer(limit)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-timer.C:
+		case <-c.ctx.Done():
+			return
+		}
+
+		idle := time.Since(time.Unix(0, c.lastActivity.Load()))
+		if idle < limit {
+			timer.Reset(limit - idle)
+			continue
+		}
+
+		c.logger.Info("Closing idle connection",
+			Field{Key: "idle", Value: idle})
+		c.idleClosed.Store(true)
+		_ = c.Close()
+		return
+	}
+}
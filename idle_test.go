@@ -0,0 +1,90 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package vnc
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestIdle_Disconnect(t *testing.T) {
+	_, conn := newUpdateServer(t, 8, 8, WithIdleDisconnect(100*time.Millisecond))
+
+	// Activity within the limit keeps the connection open.
+	for range 4 {
+		time.Sleep(40 * time.Millisecond)
+		if err := conn.KeyEvent(0x61, false); err != nil {
+			t.Fatalf("KeyEvent() error = %v", err)
+		}
+	}
+	if conn.ctx.Err() != nil || conn.IdleDisconnected() {
+		t.Fatal("connection closed while active")
+	}
+
+	select {
+	case <-conn.ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("idle connection was not closed")
+	}
+	if !conn.IdleDisconnected() {
+		t.Error("IdleDisconnected() = false after the idle timeout")
+	}
+}
+
+func TestIdle_SessionReconnects(t *testing.T) {
+	dials := 0
+	session := NewSession(func(context.Context) (net.Conn, error) {
+		dials++
+		_, clientConn := startUpdateServer(t, 8, 8)
+		return clientConn, nil
+	}, WithAuth(&ClientAuthNone{}), WithIdleDisconnect(50*time.Millisecond))
+	defer session.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	first, err := session.Client(ctx)
+	if err != nil {
+		t.Fatalf("Client() error = %v", err)
+	}
+	if again, err := session.Client(ctx); err != nil || again != first {
+		t.Fatalf("Client() = %p, %v; want the open connection %p", again, err, first)
+	}
+	if err := first.SetEncodings([]Encoding{&HextileEncoding{}, &RawEncoding{}}); err != nil {
+		t.Fatalf("SetEncodings() error = %v", err)
+	}
+	if err := first.SetPixelFormat(PixelFormat16BitRGB565); err != nil {
+		t.Fatalf("SetPixelFormat() error = %v", err)
+	}
+	first.setPixelFormat(*PixelFormat16BitRGB565)
+
+	select {
+	case <-first.ctx.Done():
+	case <-ctx.Done():
+		t.Fatal("idle connection was not closed")
+	}
+
+	second, err := session.Client(ctx)
+	if err != nil {
+		t.Fatalf("Client() after idle disconnect error = %v", err)
+	}
+	if second == first || dials != 2 || session.Connects() != 2 {
+		t.Fatalf("Client() did not reconnect: %d dials, %d connects", dials, session.Connects())
+	}
+	if got := second.GetEncodings(); len(got) != 2 || got[0].Type() != 5 || got[1].Type() != 0 {
+		t.Errorf("encodings after reconnect = %v, want Hextile and Raw", got)
+	}
+	if got := second.GetPixelFormat(); got != *PixelFormat16BitRGB565 {
+		t.Errorf("pixel format after reconnect = %+v, want RGB565", got)
+	}
+
+	if err := session.Close(); err != nil {
+		t.Errorf("Close() error = %v", err)
+	}
+	if _, err := session.Client(ctx); !IsVNCError(err, ErrValidation) {
+		t.Errorf("Client() after Close error = %v, want a validation error", err)
+	}
+}
@@ -0,0 +1,371 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package vnc
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// interopServer describes a dockerized VNC server under testdata/interop and
+// the features it is expected to support.
+type interopServer struct {
+	name     string
+	password string
+
+	// pattern is the solid root window color painted by the container, if any.
+	pattern *color.RGBA
+
+	// resize is the command run in the container to change the desktop size.
+	resize []string
+
+	// clipboard reports whether the container syncs the X clipboard with VNC.
+	clipboard bool
+}
+
+// interopPattern is the root window color set by the X11-based containers.
+var interopPattern = &color.RGBA{R: 0x33, G: 0x66, B: 0x99, A: 0xff}
+
+// interopServers is the feature matrix exercised by TestInterop_DockerServers.
+var interopServers = []interopServer{
+	{
+		name:      "tigervnc",
+		password:  "secret",
+		pattern:   interopPattern,
+		resize:    []string{"xrandr", "-s", "800x600"},
+		clipboard: true,
+	},
+	{
+		name:      "x11vnc",
+		password:  "secret",
+		pattern:   interopPattern,
+		clipboard: true,
+	},
+	{
+		name: "qemu",
+	},
+}
+
+// TestInterop_DockerServers runs the client feature matrix against real VNC
+// servers started in Docker containers built from testdata/interop. It runs
+// with VNC_INTEGRATION_TESTS=1 when Docker is available; VNC_INTEROP_SERVERS
+// restricts the run to a comma-separated list of server names.
+func TestInterop_DockerServers(t *testing.T) {
+	if os.Getenv("VNC_INTEGRATION_TESTS") != "1" {
+		t.Skip("Skipping dockerized interop tests. Set VNC_INTEGRATION_TESTS=1 to enable.")
+	}
+	if _, err := exec.LookPath("docker"); err != nil {
+		t.Skip("Skipping dockerized interop tests: docker not found in PATH.")
+	}
+
+	selected := map[string]bool{}
+	for _, name := range strings.Split(os.Getenv("VNC_INTEROP_SERVERS"), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			selected[name] = true
+		}
+	}
+
+	for _, srv := range interopServers {
+		t.Run(srv.name, func(t *testing.T) {
+			if len(selected) > 0 && !selected[srv.name] {
+				t.Skipf("%s not listed in VNC_INTEROP_SERVERS", srv.name)
+			}
+
+			container, address := startInteropContainer(t, srv)
+
+			t.Run("Auth", func(t *testing.T) { testInteropAuth(t, srv, address) })
+			t.Run("Encodings", func(t *testing.T) { testInteropEncodings(t, srv, address) })
+			t.Run("Resize", func(t *testing.T) { testInteropResize(t, srv, container, address) })
+			t.Run("Clipboard", func(t *testing.T) { testInteropClipboard(t, srv, container, address) })
+		})
+	}
+}
+
+// docker runs a docker command and returns its trimmed standard output.
+func docker(ctx context.Context, args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "docker", args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("docker %s: %w: %s", strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// startInteropContainer builds and starts the container for srv and returns its
+// ID and the published VNC address once the server greets clients.
+func startInteropContainer(t *testing.T, srv interopServer) (container, address string) {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	tag := "go-vnc-interop-" + srv.name
+	if _, err := docker(ctx, "build", "-t", tag, filepath.Join("testdata", "interop", srv.name)); err != nil {
+		t.Fatalf("building %s: %v", tag, err)
+	}
+
+	container, err := docker(ctx, "run", "-d", "--rm", "-p", "127.0.0.1::5900",
+		"-e", "VNC_PASSWORD="+srv.password, tag)
+	if err != nil {
+		t.Fatalf("starting %s: %v", tag, err)
+	}
+	t.Cleanup(func() {
+		_, _ = docker(context.Background(), "rm", "-f", container)
+	})
+
+	address, err = docker(ctx, "port", container, "5900/tcp")
+	if err != nil {
+		t.Fatalf("resolving published port: %v", err)
+	}
+	address = strings.SplitN(address, "\n", 2)[0]
+
+	// Docker accepts connections on the published port before the server is
+	// listening, so wait for the protocol version greeting.
+	deadline := time.Now().Add(60 * time.Second)
+	for {
+		if interopGreets(address) {
+			return container, address
+		}
+		if time.Now().After(deadline) {
+			logs, _ := docker(context.Background(), "logs", container)
+			t.Fatalf("%s did not become ready at %s:\n%s", srv.name, address, logs)
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+}
+
+// interopGreets reports whether a VNC server at address sends its protocol version.
+func interopGreets(address string) bool {
+	conn, err := net.DialTimeout("tcp", address, time.Second)
+	if err != nil {
+		return false
+	}
+	defer conn.Close()
+
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	version := make([]byte, pvLen)
+	n, _ := conn.Read(version)
+	return n > 0 && bytes.HasPrefix(version[:n], []byte("RFB "))
+}
+
+// dialInterop connects to the server at address with the given password and
+// returns a client whose server messages are delivered to msgs.
+func dialInterop(t *testing.T, address, password string, msgs chan ServerMessage) (*ClientConn, error) {
+	t.Helper()
+
+	conn, err := net.DialTimeout("tcp", address, 10*time.Second)
+	if err != nil {
+		return nil, err
+	}
+
+	auth := []ClientAuth{&ClientAuthNone{}}
+	if password != "" {
+		auth = []ClientAuth{NewPasswordAuth(password)}
+	}
+
+	options := []ClientOption{WithAuth(auth...)}
+	if msgs != nil {
+		options = append(options, WithServerMessageChannel(msgs))
+	}
+
+	client, err := ClientWithOptions(context.Background(), conn, options...)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	t.Cleanup(func() { _ = client.CloseAndWait() })
+
+	return client, nil
+}
+
+// drainInterop starts a goroutine that discards server messages until the test
+// ends, forwarding the ones accepted by keep.
+func drainInterop(t *testing.T, keep func(ServerMessage) bool) (chan ServerMessage, <-chan ServerMessage) {
+	t.Helper()
+
+	msgs := make(chan ServerMessage, 16)
+	kept := make(chan ServerMessage, 16)
+	done := make(chan struct{})
+	t.Cleanup(func() { close(done) })
+
+	go func() {
+		for {
+			select {
+			case msg := <-msgs:
+				if keep != nil && keep(msg) {
+					select {
+					case kept <- msg:
+					default:
+					}
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return msgs, kept
+}
+
+func testInteropAuth(t *testing.T, srv interopServer, address string) {
+	if _, err := dialInterop(t, address, srv.password, nil); err != nil {
+		t.Fatalf("connecting with valid credentials: %v", err)
+	}
+
+	if srv.password == "" {
+		t.Skip("server does not require authentication")
+	}
+	if _, err := dialInterop(t, address, srv.password+"-wrong", nil); !IsVNCError(err, ErrAuthentication) {
+		t.Errorf("expected authentication error for a wrong password, got %v", err)
+	}
+}
+
+func testInteropEncodings(t *testing.T, srv interopServer, address string) {
+	encodings := []Encoding{
+		&RawEncoding{},
+		&CopyRectEncoding{},
+		&RREEncoding{},
+		&HextileEncoding{},
+	}
+
+	for _, enc := range encodings {
+		t.Run(fmt.Sprintf("Type%d", enc.Type()), func(t *testing.T) {
+			msgs, _ := drainInterop(t, nil)
+			client, err := dialInterop(t, address, srv.password, msgs)
+			if err != nil {
+				t.Fatalf("connecting: %v", err)
+			}
+
+			if err := client.SetEncodings([]Encoding{enc, &RawEncoding{}}); err != nil {
+				t.Fatalf("SetEncodings failed: %v", err)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+
+			img, err := client.Screenshot(ctx)
+			if err != nil {
+				t.Fatalf("Screenshot failed: %v", err)
+			}
+
+			if srv.pattern != nil {
+				center := image.Pt(img.Bounds().Dx()/2, img.Bounds().Dy()/2)
+				if got := img.RGBAAt(center.X, center.Y); got != *srv.pattern {
+					t.Errorf("center pixel = %v, want %v", got, *srv.pattern)
+				}
+			}
+
+			stats := client.Stats()
+			t.Logf("%s: encodings used %v, compression ratio %.2f",
+				srv.name, stats.Encodings, stats.CompressionRatio())
+		})
+	}
+}
+
+func testInteropResize(t *testing.T, srv interopServer, container, address string) {
+	if len(srv.resize) == 0 {
+		t.Skip("server has no scriptable desktop resize")
+	}
+
+	msgs, _ := drainInterop(t, nil)
+	client, err := dialInterop(t, address, srv.password, msgs)
+	if err != nil {
+		t.Fatalf("connecting: %v", err)
+	}
+	if err := client.SetEncodings([]Encoding{&RawEncoding{}, &DesktopSizePseudoEncoding{}}); err != nil {
+		t.Fatalf("SetEncodings failed: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if _, err := docker(ctx, append([]string{"exec", container}, srv.resize...)...); err != nil {
+		t.Fatalf("resizing desktop: %v", err)
+	}
+
+	for {
+		width, height := client.GetFrameBufferSize()
+		if width == 800 && height == 600 {
+			break
+		}
+		if ctx.Err() != nil {
+			t.Fatalf("framebuffer size = %dx%d, want 800x600", width, height)
+		}
+		_ = client.FramebufferUpdateRequest(true, 0, 0, width, height)
+		time.Sleep(200 * time.Millisecond)
+	}
+
+	img, err := client.Screenshot(ctx)
+	if err != nil {
+		t.Fatalf("Screenshot after resize failed: %v", err)
+	}
+	if img.Bounds() != image.Rect(0, 0, 800, 600) {
+		t.Errorf("screenshot bounds after resize = %v", img.Bounds())
+	}
+}
+
+func testInteropClipboard(t *testing.T, srv interopServer, container, address string) {
+	if !srv.clipboard {
+		t.Skip("server has no clipboard")
+	}
+
+	msgs, cutText := drainInterop(t, func(msg ServerMessage) bool {
+		_, ok := msg.(*ServerCutTextMessage)
+		return ok
+	})
+	client, err := dialInterop(t, address, srv.password, msgs)
+	if err != nil {
+		t.Fatalf("connecting: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	t.Run("ClientToServer", func(t *testing.T) {
+		const text = "go-vnc interop client"
+		if err := client.CutText(text); err != nil {
+			t.Fatalf("CutText failed: %v", err)
+		}
+
+		for {
+			got, _ := docker(ctx, "exec", container, "xclip", "-o", "-selection", "clipboard")
+			if got == text {
+				return
+			}
+			if ctx.Err() != nil {
+				t.Fatalf("server clipboard = %q, want %q", got, text)
+			}
+			time.Sleep(200 * time.Millisecond)
+		}
+	})
+
+	t.Run("ServerToClient", func(t *testing.T) {
+		const text = "go-vnc interop server"
+		if _, err := docker(ctx, "exec", container, "sh", "-c",
+			"printf '%s' '"+text+"' | xclip -i -selection clipboard"); err != nil {
+			t.Fatalf("setting server clipboard: %v", err)
+		}
+
+		select {
+		case msg := <-cutText:
+			if got := msg.(*ServerCutTextMessage).Text; got != text {
+				t.Errorf("received clipboard %q, want %q", got, text)
+			}
+		case <-ctx.Done():
+			t.Fatal("no ServerCutText received")
+		}
+	})
+}
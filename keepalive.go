@@ -0,0 +1,199 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package vnc
+
+import (
+	"bytes"
+	"context"
+	"time"
+
+	"github.com/tenthirtyam/go-vnc/rfb"
+)
+
+// keepalivePayload is the payload of the fences sent as keepalives, whose
+// answers are not delivered.
+const keepalivePayload = "go-vnc keepalive"
+
+// KeepaliveStrategy selects the message WithKeepalive sends. Every strategy
+// uses a message that RFC 6143 or a confirmed extension defines as free of
+// side effects, but servers differ in which they handle gracefully.
+type KeepaliveStrategy int
+
+const (
+	// KeepaliveAuto chooses a strategy from what the connection has learned
+	// about the server, each time a keepalive is sent: a fence once the
+	// server has confirmed fences; otherwise an update request for servers
+	// configured with QuirkNoPseudoEncodings, such as the BMC firmwares of
+	// ForBMCKVM, some of which restart their encoders on every SetEncodings,
+	// and for RFB 3.3 servers; and otherwise the current encodings sent
+	// again, which no server answers.
+	KeepaliveAuto KeepaliveStrategy = iota
+
+	// KeepaliveFence sends a fence request, which the server answers. The
+	// answer is not delivered. Until the server confirms fences, an update
+	// request is sent instead.
+	KeepaliveFence
+
+	// KeepaliveUpdateRequest sends an incremental update request for the
+	// top-left pixel. The server answers with an update if that pixel
+	// changes.
+	KeepaliveUpdateRequest
+
+	// KeepaliveSetEncodings sends the current encodings again. Until
+	// encodings have been set, an update request is sent instead.
+	KeepaliveSetEncodings
+)
+
+// String returns the name of the strategy.
+func (s KeepaliveStrategy) String() string {
+	switch s {
+	case KeepaliveAuto:
+		return "auto"
+	case KeepaliveFence:
+		return "fence"
+	case KeepaliveUpdateRequest:
+		return "update-request"
+	case KeepaliveSetEncodings:
+		return "set-encodings"
+	default:
+		return "unknown"
+	}
+}
+
+// keepaliveKey marks the context of keepalive writes, which do not count as
+// activity for IdleDisconnect.
+type keepaliveKey struct{}
+
+// WithKeepalive sends a message to the server whenever the client has sent
+// nothing for interval, so that NAT gateways, firewalls, and servers with
+// idle timeouts keep the connection open, and a connection whose peer has
+// gone away fails instead of waiting forever. strategy selects the message;
+// KeepaliveAuto suits most servers, and the others override it for servers
+// known to mishandle its choice.
+//
+// Keepalives are counted in Stats.Keepalives. They do not count as activity
+// for WithIdleDisconnect.
+func WithKeepalive(interval time.Duration, strategy KeepaliveStrategy) ClientOption {
+	return func(cfg *ClientConfig) {
+		cfg.KeepaliveInterval = interval
+		cfg.KeepaliveStrategy = strategy
+	}
+}
+
+// KeepaliveStrategy returns the strategy the next keepalive uses, resolving
+// KeepaliveAuto and the fallbacks of the other strategies.
+func (c *ClientConn) KeepaliveStrategy() KeepaliveStrategy {
+	strategy := KeepaliveAuto
+	if c.config != nil {
+		strategy = c.config.KeepaliveStrategy
+	}
+	if strategy == KeepaliveAuto {
+		switch {
+		case c.fence.Load():
+			strategy = KeepaliveFence
+		case c.hasQuirk(QuirkNoPseudoEncodings), c.protocolMinor == 3:
+			strategy = KeepaliveUpdateRequest
+		default:
+			strategy = KeepaliveSetEncodings
+		}
+	}
+
+	switch {
+	case strategy == KeepaliveFence && !c.fence.Load():
+		return KeepaliveUpdateRequest
+	case strategy == KeepaliveSetEncodings && len(c.loadState().encodings) == 0:
+		return KeepaliveUpdateRequest
+	}
+	return strategy
+}
+
+// startKeepalive starts the goroutine that sends keepalives, if configured.
+func (c *ClientConn) startKeepalive() {
+	if c.config == nil || c.config.KeepaliveInterval <= 0 {
+		return
+	}
+	if c.lastActivity.Load() == 0 {
+		c.lastActivity.Store(time.Now().UnixNano())
+	}
+	c.goTracked(c.runKeepalive)
+}
+
+// runKeepalive sends a keepalive whenever neither a message nor a keepalive
+// has been sent for KeepaliveInterval, returning when the connection closes.
+func (c *ClientConn) runKeepalive() {
+	interval := c.config.KeepaliveInterval
+	ctx := context.WithValue(c.ctx, keepaliveKey{}, true)
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+
+	var lastSent time.Time
+	for {
+		select {
+		case <-timer.C:
+		case <-c.ctx.Done():
+			return
+		}
+
+		last := time.Unix(0, c.lastActivity.Load())
+		if lastSent.After(last) {
+			last = lastSent
+		}
+		if wait := interval - time.Since(last); wait > 0 {
+			timer.Reset(wait)
+			continue
+		}
+
+		strategy := c.KeepaliveStrategy()
+		if err := c.sendKeepalive(ctx, strategy); err != nil {
+			if c.ctx.Err() != nil {
+				return
+			}
+			c.logger.Warn("Keepalive failed, closing connection",
+				Field{Key: "strategy", Value: strategy.String()},
+				Field{Key: "error", Value: err})
+			_ = c.Close()
+			return
+		}
+		lastSent = time.Now()
+		c.stats.mu.Lock()
+		c.stats.keepalives++
+		c.stats.mu.Unlock()
+		timer.Reset(interval)
+	}
+}
+
+// sendKeepalive sends one keepalive with strategy.
+func (c *ClientConn) sendKeepalive(ctx context.Context, strategy KeepaliveStrategy) error {
+	var buf bytes.Buffer
+	var err error
+	switch strategy {
+	case KeepaliveFence:
+		err = rfb.WriteFence(&buf, rfb.Fence{
+			Flags:   FenceRequest | FenceBlockBefore,
+			Payload: []byte(keepalivePayload),
+		})
+	case KeepaliveSetEncodings:
+		encs := c.loadState().encodings
+		types := make([]int32, len(encs))
+		for i, enc := range encs {
+			types[i] = enc.Type()
+		}
+		err = rfb.WriteSetEncodings(&buf, types)
+	default:
+		err = rfb.WriteFramebufferUpdateRequest(&buf,
+			rfb.FramebufferUpdateRequest{Incremental: true, Width: 1, Height: 1})
+	}
+	if err != nil {
+		return err
+	}
+
+	c.logger.Debug("Sending keepalive", Field{Key: "strategy", Value: strategy.String()})
+	return c.writeWithContext(ctx, buf.Bytes())
+}
+
+// isKeepaliveAnswer reports whether msg answers a fence sent as a keepalive.
+func isKeepaliveAnswer(msg ServerMessage) bool {
+	f, ok := msg.(*FenceMessage)
+	return ok && f.Flags&FenceRequest == 0 && string(f.Payload) == keepalivePayload
+}
@@ -0,0 +1,94 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package vnc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/tenthirtyam/go-vnc/rfb"
+)
+
+func TestKeepalive_Strategy(t *testing.T) {
+	tests := []struct {
+		name       string
+		configured KeepaliveStrategy
+		quirks     Quirks
+		minor      uint
+		fence      bool
+		encodings  []Encoding
+		want       KeepaliveStrategy
+	}{
+		{"auto with fences", KeepaliveAuto, 0, 8, true, []Encoding{&RawEncoding{}}, KeepaliveFence},
+		{"auto for BMC firmware", KeepaliveAuto, QuirkNoPseudoEncodings, 8, false, []Encoding{&RawEncoding{}}, KeepaliveUpdateRequest},
+		{"auto for RFB 3.3", KeepaliveAuto, 0, 3, false, []Encoding{&RawEncoding{}}, KeepaliveUpdateRequest},
+		{"auto", KeepaliveAuto, 0, 8, false, []Encoding{&RawEncoding{}}, KeepaliveSetEncodings},
+		{"auto without encodings", KeepaliveAuto, 0, 8, false, nil, KeepaliveUpdateRequest},
+		{"fence override without fences", KeepaliveFence, 0, 8, false, nil, KeepaliveUpdateRequest},
+		{"set encodings override", KeepaliveSetEncodings, QuirkNoPseudoEncodings, 8, true, []Encoding{&RawEncoding{}}, KeepaliveSetEncodings},
+		{"update request override", KeepaliveUpdateRequest, 0, 8, true, nil, KeepaliveUpdateRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &ClientConn{
+				config:        &ClientConfig{KeepaliveStrategy: tt.configured, Quirks: tt.quirks},
+				protocolMinor: tt.minor,
+			}
+			c.fence.Store(tt.fence)
+			c.setEncodings(tt.encodings)
+			if got := c.KeepaliveStrategy(); got != tt.want {
+				t.Errorf("KeepaliveStrategy() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKeepalive_Send(t *testing.T) {
+	srv, conn := newUpdateServer(t, 8, 8,
+		WithKeepalive(20*time.Millisecond, KeepaliveAuto),
+		WithIdleDisconnect(time.Hour))
+
+	// Without encodings, the auto strategy requests the top-left pixel.
+	deadline := time.Now().Add(5 * time.Second)
+	for srv.requests.Load() < 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("no keepalive update requests were sent")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if got := conn.Stats().Keepalives; got < 2 {
+		t.Errorf("Stats().Keepalives = %d, want at least 2", got)
+	}
+	if idle := time.Since(time.Unix(0, conn.lastActivity.Load())); idle < 40*time.Millisecond {
+		t.Errorf("keepalives counted as activity: idle for %v", idle)
+	}
+
+	// The fence strategy applies once the server confirms fences.
+	conn.fence.Store(true)
+	select {
+	case f := <-srv.fences:
+		if f.Flags&rfb.FenceRequest == 0 || string(f.Payload) != keepalivePayload {
+			t.Errorf("keepalive fence = %+v, want a request with the keepalive payload", f)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no keepalive fence was sent")
+	}
+}
+
+func TestKeepalive_AnswerSuppressed(t *testing.T) {
+	s := &replayStream{}
+	answer := rfb.Fence{Flags: FenceBlockBefore, Payload: []byte(keepalivePayload)}
+	_ = rfb.WriteFence(&s.buf, answer)
+	s.write(uint8(rfb.BellMsg))
+
+	tc := replayCase{
+		handshake:    replayHandshake(2, 1, "keepalive"),
+		messages:     s.bytes(),
+		wantMessages: []string{"bell"},
+	}
+	_, msgs := runReplay(t, tc)
+	if _, ok := msgs[0].(*BellMessage); !ok {
+		t.Errorf("first delivered message = %T, want the bell after the keepalive answer", msgs[0])
+	}
+}
@@ -0,0 +1,195 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package vnc
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// X11 keysyms of the modifier keys. The left keysyms are listed first.
+const (
+	keysymShiftL   = 0xffe1
+	keysymShiftR   = 0xffe2
+	keysymControlR = 0xffe4
+	keysymMetaL    = 0xffe7
+	keysymMetaR    = 0xffe8
+	keysymAltL     = 0xffe9
+	keysymAltR     = 0xffea
+	keysymSuperL   = 0xffeb
+	keysymSuperR   = 0xffec
+
+	// keysymAltGr is ISO_Level3_Shift, sent by the AltGr key of
+	// international layouts.
+	keysymAltGr = 0xfe03
+
+	// keysymF1 is the keysym of F1; F2 to F35 follow it consecutively.
+	keysymF1 = 0xffbe
+)
+
+// chordModifiers maps modifier names to their left and right keysyms. The
+// bare name selects the left key, as physical keyboards do when typing a
+// shortcut; an "l" or "left" prefix selects it explicitly and an "r" or
+// "right" prefix selects the right key. Following the conventions of VNC
+// viewers, the Windows and macOS Command keys are Super and the macOS Option
+// key is Alt.
+var chordModifiers = map[string][2]uint32{
+	"shift":   {keysymShiftL, keysymShiftR},
+	"ctrl":    {keysymControlL, keysymControlR},
+	"control": {keysymControlL, keysymControlR},
+	"alt":     {keysymAltL, keysymAltR},
+	"option":  {keysymAltL, keysymAltR},
+	"opt":     {keysymAltL, keysymAltR},
+	"meta":    {keysymMetaL, keysymMetaR},
+	"super":   {keysymSuperL, keysymSuperR},
+	"win":     {keysymSuperL, keysymSuperR},
+	"windows": {keysymSuperL, keysymSuperR},
+	"cmd":     {keysymSuperL, keysymSuperR},
+	"command": {keysymSuperL, keysymSuperR},
+}
+
+// chordKeys maps the names of keys other than modifiers, function keys, and
+// single characters to their keysyms.
+var chordKeys = map[string]uint32{
+	"altgr":       keysymAltGr,
+	"backspace":   0xff08,
+	"bs":          0xff08,
+	"tab":         keysymTab,
+	"enter":       keysymReturn,
+	"return":      keysymReturn,
+	"pause":       0xff13,
+	"scrolllock":  0xff14,
+	"esc":         0xff1b,
+	"escape":      0xff1b,
+	"home":        0xff50,
+	"left":        0xff51,
+	"up":          0xff52,
+	"right":       0xff53,
+	"down":        0xff54,
+	"pageup":      0xff55,
+	"pgup":        0xff55,
+	"pagedown":    0xff56,
+	"pgdn":        0xff56,
+	"end":         0xff57,
+	"print":       0xff61,
+	"printscreen": 0xff61,
+	"prtsc":       0xff61,
+	"insert":      0xff63,
+	"ins":         0xff63,
+	"menu":        0xff67,
+	"numlock":     0xff7f,
+	"capslock":    0xffe5,
+	"delete":      0xffff,
+	"del":         0xffff,
+	"space":       ' ',
+	"plus":        '+',
+}
+
+// ParseKeyChord converts a chord such as "ctrl+shift+esc" into the keysyms
+// pressed, in order, to type it. Keys are separated by "+" and matched
+// without regard to case, spaces, "-", or "_", so "Page_Up" and "pageup" are
+// the same key. A key is a modifier (shift, ctrl, alt, meta, super, or an
+// alias such as cmd, win, or option, optionally prefixed with "l", "left",
+// "r", or "right"), a named key (esc, enter, tab, f1 to f35, arrows, and so
+// on), or a single character. Single letters are sent lowercase, so that
+// shortcuts do not depend on the state of Shift; use "shift+a" for an
+// uppercase A.
+func ParseKeyChord(chord string) ([]uint32, error) {
+	names := strings.Split(chord, "+")
+	// A trailing "+" names the plus key itself, as in "ctrl++".
+	if chord == "+" || strings.HasSuffix(chord, "++") {
+		names = append(names[:len(names)-2], "+")
+	}
+
+	keysyms := make([]uint32, 0, len(names))
+	for _, name := range names {
+		keysym, err := parseKeyName(name)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key chord %q: %w", chord, err)
+		}
+		keysyms = append(keysyms, keysym)
+	}
+	return keysyms, nil
+}
+
+// parseKeyName returns the keysym of a single key of a chord.
+func parseKeyName(name string) (uint32, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return 0, fmt.Errorf("empty key name")
+	}
+	if char, size := utf8.DecodeRuneInString(name); size == len(name) && char != utf8.RuneError {
+		return runeKeysym(unicode.ToLower(char)), nil
+	}
+
+	normalized := strings.NewReplacer(" ", "", "-", "", "_", "").Replace(strings.ToLower(name))
+	if keysym, ok := chordKeys[normalized]; ok {
+		return keysym, nil
+	}
+	if modifier, ok := chordModifiers[normalized]; ok {
+		return modifier[0], nil
+	}
+	for side, prefixes := range [][]string{{"left", "l"}, {"right", "r"}} {
+		for _, prefix := range prefixes {
+			if base, ok := strings.CutPrefix(normalized, prefix); ok {
+				if modifier, ok := chordModifiers[base]; ok {
+					return modifier[side], nil
+				}
+			}
+		}
+	}
+
+	if digits, ok := strings.CutPrefix(normalized, "f"); ok {
+		if n, err := strconv.Atoi(digits); err == nil && n >= 1 && n <= 35 && digits[0] != '0' {
+			return keysymF1 + uint32(n-1), nil // #nosec G115 - n is between 1 and 35
+		}
+	}
+	return 0, fmt.Errorf("unknown key %q", name)
+}
+
+// SendKeys types each chord in turn, such as "ctrl+shift+esc", "alt+F4", or
+// "cmd+space" (see ParseKeyChord for the syntax). The keys of a chord are
+// pressed in order and released in reverse order, paced by the measured
+// round-trip time like Click. Keysyms are layout-independent, so the result
+// does not depend on the keyboard layout of the remote desktop as long as it
+// can produce the keysym.
+//
+// Every chord is parsed before anything is sent, so a validation error means
+// no key was pressed. SendKeys stops when ctx ends, releasing any keys it is
+// holding.
+//
+// Example usage:
+//
+//	err := client.SendKeys(ctx, "ctrl+alt+t", "e", "x", "i", "t", "enter")
+func (c *ClientConn) SendKeys(ctx context.Context, chords ...string) error {
+	sequences := make([][]uint32, len(chords))
+	for i, chord := range chords {
+		keysyms, err := ParseKeyChord(chord)
+		if err != nil {
+			return c.enrichError(validationError("SendKeys", "invalid key chord", err))
+		}
+		sequences[i] = keysyms
+	}
+
+	rtt, _ := c.RoundTripTime()
+	delay := c.gestureTiming().Delay(rtt)
+	for i, keysyms := range sequences {
+		if i > 0 {
+			if err := sleepContext(ctx, delay); err != nil {
+				return err
+			}
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := c.pressKeys(ctx, keysyms, delay); err != nil {
+			return err
+		}
+	}
+	return nil
+}
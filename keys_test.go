@@ -0,0 +1,85 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package vnc
+
+import (
+	"context"
+	"reflect"
+	"slices"
+	"testing"
+
+	"github.com/tenthirtyam/go-vnc/rfb"
+)
+
+func TestParseKeyChord(t *testing.T) {
+	tests := []struct {
+		chord string
+		want  []uint32
+	}{
+		{"ctrl+shift+esc", []uint32{keysymControlL, keysymShiftL, 0xff1b}},
+		{"alt+F4", []uint32{keysymAltL, keysymF1 + 3}},
+		{"cmd+space", []uint32{keysymSuperL, ' '}},
+		{"RCtrl+Right_Shift+Page_Up", []uint32{keysymControlR, keysymShiftR, 0xff55}},
+		{"left-alt+right", []uint32{keysymAltL, 0xff53}},
+		{"ctrl+C", []uint32{keysymControlL, 'c'}},
+		{"shift+1", []uint32{keysymShiftL, '1'}},
+		{"ctrl++", []uint32{keysymControlL, '+'}},
+		{"+", []uint32{'+'}},
+		{"f35", []uint32{keysymF1 + 34}},
+		{"super", []uint32{keysymSuperL}},
+		{"altgr+e", []uint32{keysymAltGr, 'e'}},
+		{"é", []uint32{0xe9}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.chord, func(t *testing.T) {
+			got, err := ParseKeyChord(tt.chord)
+			if err != nil {
+				t.Fatalf("ParseKeyChord() error = %v", err)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("ParseKeyChord() = %#x, want %#x", got, tt.want)
+			}
+		})
+	}
+
+	for _, chord := range []string{"", "ctrl+", "ctrl+foo", "f0", "f36", "f01", "hyper+a"} {
+		if _, err := ParseKeyChord(chord); err == nil {
+			t.Errorf("ParseKeyChord(%q) accepted an invalid chord", chord)
+		}
+	}
+}
+
+func TestSendKeys_ReleaseOrder(t *testing.T) {
+	srv, conn := newUpdateServer(t, 16, 16)
+
+	if err := conn.SendKeys(context.Background(), "ctrl+shift+esc", "alt+F4"); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []rfb.KeyEvent{
+		{Down: true, Key: keysymControlL},
+		{Down: true, Key: keysymShiftL},
+		{Down: true, Key: 0xff1b},
+		{Down: false, Key: 0xff1b},
+		{Down: false, Key: keysymShiftL},
+		{Down: false, Key: keysymControlL},
+		{Down: true, Key: keysymAltL},
+		{Down: true, Key: keysymF1 + 3},
+		{Down: false, Key: keysymF1 + 3},
+		{Down: false, Key: keysymAltL},
+	}
+	if got := receiveKeys(t, srv, len(want)); !reflect.DeepEqual(got, want) {
+		t.Errorf("key events = %+v, want %+v", got, want)
+	}
+
+	// A bad chord anywhere in the list stops the whole sequence up front.
+	if err := conn.SendKeys(context.Background(), "ctrl+a", "bogus"); !IsVNCError(err, ErrValidation) {
+		t.Fatalf("SendKeys error = %v, want a validation error", err)
+	}
+	select {
+	case ev := <-srv.keys:
+		t.Errorf("unexpected key event %+v after a validation error", ev)
+	default:
+	}
+}
@@ -0,0 +1,66 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package vnc
+
+import (
+	"context"
+	"image"
+	"time"
+)
+
+// latencyEstimator tracks a smoothed round-trip time and its variation using
+// the estimator from RFC 6298.
+type latencyEstimator struct {
+	srtt    time.Duration
+	rttvar  time.Duration
+	samples uint64
+}
+
+// add folds a round-trip sample into the estimate.
+func (l *latencyEstimator) add(sample time.Duration) {
+	if l.samples == 0 {
+		l.srtt = sample
+		l.rttvar = sample / 2
+	} else {
+		delta := l.srtt - sample
+		if delta < 0 {
+			delta = -delta
+		}
+		l.rttvar = (3*l.rttvar + delta) / 4
+		l.srtt = (7*l.srtt + sample) / 8
+	}
+	l.samples++
+}
+
+// recordRoundTrip adds a measured request/response round trip to the
+// connection's latency estimate.
+func (c *ClientConn) recordRoundTrip(rtt time.Duration) {
+	c.stats.mu.Lock()
+	c.stats.latency.add(rtt)
+	c.stats.mu.Unlock()
+}
+
+// RoundTripTime returns the smoothed round-trip time and its mean deviation,
+// or zeros if no round trip has been measured yet. Samples come from Ping and
+// from the framebuffer requests issued by Screenshot and CaptureRegion.
+func (c *ClientConn) RoundTripTime() (rtt, variation time.Duration) {
+	c.stats.mu.Lock()
+	defer c.stats.mu.Unlock()
+	return c.stats.latency.srtt, c.stats.latency.rttvar
+}
+
+// Ping measures the round-trip time to the server by requesting a
+// non-incremental update of a single pixel and waiting for it to be applied.
+// The sample also updates the estimate returned by RoundTripTime, which
+// composite gestures such as DoubleClick use to pace their events.
+//
+// In manual pump mode another goroutine must call ProcessNextMessage while
+// Ping waits.
+func (c *ClientConn) Ping(ctx context.Context) (time.Duration, error) {
+	start := time.Now()
+	if err := c.refresh(ctx, image.Rect(0, 0, 1, 1)); err != nil {
+		return 0, err
+	}
+	return time.Since(start), nil
+}
@@ -0,0 +1,68 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package vnc
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// LockStats reports contention on one of the connection's internal locks.
+type LockStats struct {
+	// Acquisitions is the number of times the lock was taken.
+	Acquisitions uint64
+
+	// Contended is the number of acquisitions that had to wait because the
+	// lock was held by another goroutine.
+	Contended uint64
+
+	// WaitTime is the total time spent waiting in contended acquisitions.
+	WaitTime time.Duration
+}
+
+// ContentionRate returns the fraction of acquisitions that were contended, or
+// 0 if the lock has not been taken.
+func (s LockStats) ContentionRate() float64 {
+	if s.Acquisitions == 0 {
+		return 0
+	}
+	return float64(s.Contended) / float64(s.Acquisitions)
+}
+
+// contentionMutex is a mutex that counts acquisitions and the time spent
+// waiting for it. The uncontended path costs one TryLock and one atomic add.
+type contentionMutex struct {
+	mu           sync.Mutex
+	acquisitions atomic.Uint64
+	contended    atomic.Uint64
+	waitNanos    atomic.Int64
+}
+
+// Lock acquires the mutex, recording whether it had to wait.
+func (m *contentionMutex) Lock() {
+	m.acquisitions.Add(1)
+	if m.mu.TryLock() {
+		return
+	}
+
+	start := time.Now()
+	m.mu.Lock()
+	m.contended.Add(1)
+	m.waitNanos.Add(int64(time.Since(start)))
+}
+
+// Unlock releases the mutex.
+func (m *contentionMutex) Unlock() {
+	m.mu.Unlock()
+}
+
+// stats returns the contention counters of the mutex.
+func (m *contentionMutex) stats() LockStats {
+	return LockStats{
+		Acquisitions: m.acquisitions.Load(),
+		Contended:    m.contended.Load(),
+		WaitTime:     time.Duration(m.waitNanos.Load()),
+	}
+}
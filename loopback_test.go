@@ -0,0 +1,432 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package vnc_test
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tenthirtyam/go-vnc"
+	"github.com/tenthirtyam/go-vnc/rfb"
+	"github.com/tenthirtyam/go-vnc/server"
+	"github.com/tenthirtyam/go-vnc/vnctest"
+)
+
+var (
+	red  = color.RGBA{R: 0xff, A: 0xff}
+	blue = color.RGBA{B: 0xff, A: 0xff}
+)
+
+// newLoopback returns a vnctest server with a display of the given size that
+// is closed when the test ends.
+func newLoopback(t *testing.T, width, height int) *vnctest.Server {
+	t.Helper()
+	srv := vnctest.NewServer(width, height)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// screenshot captures the client's framebuffer.
+func screenshot(t *testing.T, client *vnc.ClientConn) *image.RGBA {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	img, err := client.Screenshot(ctx)
+	if err != nil {
+		t.Fatalf("Screenshot: %v", err)
+	}
+	return img
+}
+
+// receive waits for the next message of type T.
+func receive[T vnc.ServerMessage](t *testing.T, msgs <-chan vnc.ServerMessage) T {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case msg := <-msgs:
+			if m, ok := msg.(T); ok {
+				return m
+			}
+		case <-timeout:
+			var zero T
+			t.Fatalf("timed out waiting for %T", zero)
+			return zero
+		}
+	}
+}
+
+func TestLoopback_Handshake(t *testing.T) {
+	srv := newLoopback(t, 320, 200)
+	client, viewer := srv.Client(t)
+
+	if w, h := client.GetFrameBufferSize(); w != 320 || h != 200 {
+		t.Errorf("GetFrameBufferSize() = %dx%d, want 320x200", w, h)
+	}
+	if got := client.GetDesktopName(); got != vnctest.DefaultName {
+		t.Errorf("GetDesktopName() = %q, want %q", got, vnctest.DefaultName)
+	}
+	if got := viewer.Access(); got != server.AccessInteractive {
+		t.Errorf("Access() = %v, want %v", got, server.AccessInteractive)
+	}
+}
+
+func TestLoopback_Screenshot(t *testing.T) {
+	tests := []struct {
+		name   string
+		format *vnc.PixelFormat
+	}{
+		{name: "Default"},
+		{name: "RGBA", format: vnc.PixelFormat32BitRGBA},
+		{name: "RGB565", format: vnc.PixelFormat16BitRGB565},
+		{name: "BGR233", format: vnc.PixelFormat8BitBGR233},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newLoopback(t, 32, 16)
+			srv.Display.Update(image.NewUniform(red), image.Rect(0, 0, 16, 16))
+			srv.Display.Update(image.NewUniform(blue), image.Rect(16, 8, 32, 16))
+
+			var options []vnc.ClientOption
+			if tt.format != nil {
+				options = append(options, vnc.WithPixelFormat(tt.format))
+			}
+			client, _ := srv.Client(t, options...)
+
+			img := screenshot(t, client)
+			for _, px := range []struct {
+				x, y int
+				want color.RGBA
+			}{
+				{4, 4, red},
+				{20, 12, blue},
+				{20, 2, color.RGBA{A: 0xff}},
+			} {
+				if got := img.RGBAAt(px.x, px.y); got != px.want {
+					t.Errorf("pixel (%d,%d) = %v, want %v", px.x, px.y, got, px.want)
+				}
+			}
+		})
+	}
+}
+
+func TestLoopback_Input(t *testing.T) {
+	events := make(chan any, 8)
+	srv := newLoopback(t, 320, 200)
+	srv.Display.Input = server.InputFuncs{
+		KeyFunc: func(_ *server.Conn, ev rfb.KeyEvent) error {
+			events <- ev
+			return nil
+		},
+		PointerFunc: func(_ *server.Conn, ev rfb.PointerEvent) error {
+			events <- ev
+			return nil
+		},
+		CutTextFunc: func(_ *server.Conn, text string) error {
+			events <- text
+			return nil
+		},
+	}
+	client, _ := srv.Client(t)
+
+	if err := client.KeyEvent(0x41, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.KeyEvent(0x41, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.PointerEvent(vnc.ButtonLeft, 100, 150); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.CutText("Hello, World!"); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []any{
+		rfb.KeyEvent{Down: true, Key: 0x41},
+		rfb.KeyEvent{Key: 0x41},
+		rfb.PointerEvent{Mask: uint8(vnc.ButtonLeft), X: 100, Y: 150},
+		"Hello, World!",
+	}
+	for i, w := range want {
+		select {
+		case got := <-events:
+			if got != w {
+				t.Errorf("event %d = %#v, want %#v", i, got, w)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for event %d", i)
+		}
+	}
+}
+
+func TestLoopback_Auth(t *testing.T) {
+	tests := []struct {
+		name       string
+		serverAuth []server.Authenticator
+		clientAuth []vnc.ClientAuth
+		wantErr    bool
+	}{
+		{
+			name:       "None",
+			clientAuth: []vnc.ClientAuth{&vnc.ClientAuthNone{}},
+		},
+		{
+			name:       "Password",
+			serverAuth: []server.Authenticator{&server.PasswordAuth{Password: "secret"}},
+			clientAuth: []vnc.ClientAuth{vnc.NewPasswordAuth("secret")},
+		},
+		{
+			name:       "MultipleMethods",
+			serverAuth: []server.Authenticator{&server.PasswordAuth{Password: "secret"}},
+			clientAuth: []vnc.ClientAuth{vnc.NewPasswordAuth("secret"), &vnc.ClientAuthNone{}},
+		},
+		{
+			name:       "WrongPassword",
+			serverAuth: []server.Authenticator{&server.PasswordAuth{Password: "secret"}},
+			clientAuth: []vnc.ClientAuth{vnc.NewPasswordAuth("guess")},
+			wantErr:    true,
+		},
+		{
+			name:       "NoCommonMethod",
+			serverAuth: []server.Authenticator{&server.PasswordAuth{Password: "secret"}},
+			clientAuth: []vnc.ClientAuth{&vnc.ClientAuthNone{}},
+			wantErr:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newLoopback(t, 64, 64)
+			srv.Config.Auth = tt.serverAuth
+
+			client, _, err := srv.Connect(context.Background(),
+				vnc.WithAuth(tt.clientAuth...),
+				vnc.WithConnectTimeout(5*time.Second))
+			if tt.wantErr {
+				if err == nil {
+					_ = client.Close()
+					t.Fatal("Connect succeeded, want an authentication error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Connect: %v", err)
+			}
+			_ = client.Close()
+		})
+	}
+}
+
+func TestLoopback_ProtocolVersion(t *testing.T) {
+	for _, version := range []struct {
+		name  string
+		minor uint
+	}{
+		{"RFB 003.003", 3},
+		{"RFB 003.007", 7},
+		{"RFB 003.008", 8},
+	} {
+		t.Run(version.name, func(t *testing.T) {
+			for _, tt := range []struct {
+				name     string
+				password string
+				wantErr  bool
+			}{
+				{name: "None"},
+				{name: "Password", password: "secret"},
+				{name: "WrongPassword", password: "guess", wantErr: true},
+			} {
+				t.Run(tt.name, func(t *testing.T) {
+					srv := newLoopback(t, 64, 64)
+					auth := []vnc.ClientAuth{&vnc.ClientAuthNone{}}
+					if tt.password != "" {
+						srv.Config.Auth = []server.Authenticator{&server.PasswordAuth{Password: "secret"}}
+						auth = []vnc.ClientAuth{vnc.NewPasswordAuth(tt.password)}
+					}
+
+					client, viewer, err := srv.Connect(context.Background(),
+						vnc.WithProtocolVersion(version.name),
+						vnc.WithAuth(auth...),
+						vnc.WithConnectTimeout(5*time.Second))
+					if tt.wantErr {
+						if err == nil {
+							_ = client.Close()
+							t.Fatal("Connect succeeded, want an authentication error")
+						}
+						return
+					}
+					if err != nil {
+						t.Fatalf("Connect: %v", err)
+					}
+					defer client.Close()
+
+					if _, minor := client.ProtocolVersion(); minor != version.minor {
+						t.Errorf("client ProtocolVersion() minor = %d, want %d", minor, version.minor)
+					}
+					if _, minor := viewer.ProtocolVersion(); minor != version.minor {
+						t.Errorf("server ProtocolVersion() minor = %d, want %d", minor, version.minor)
+					}
+					screenshot(t, client)
+				})
+			}
+		})
+	}
+}
+
+func TestLoopback_ServerMessages(t *testing.T) {
+	srv := newLoopback(t, 64, 64)
+	msgs := make(chan vnc.ServerMessage, 8)
+	_, viewer := srv.Client(t, vnc.WithServerMessageChannel(msgs))
+
+	if err := viewer.CutText("from the server"); err != nil {
+		t.Fatal(err)
+	}
+	if got := receive[*vnc.ServerCutTextMessage](t, msgs).Text; got != "from the server" {
+		t.Errorf("ServerCutText = %q, want %q", got, "from the server")
+	}
+
+	if err := viewer.Bell(); err != nil {
+		t.Fatal(err)
+	}
+	receive[*vnc.BellMessage](t, msgs)
+}
+
+func TestLoopback_Resize(t *testing.T) {
+	srv := newLoopback(t, 32, 16)
+	client, _ := srv.Client(t,
+		vnc.WithInitialEncodings(&vnc.RawEncoding{}, &vnc.DesktopSizePseudoEncoding{}))
+	screenshot(t, client)
+
+	srv.Display.Resize(48, 24)
+	srv.Display.Update(image.NewUniform(red), image.Rect(40, 20, 48, 24))
+
+	// The update answering the next request announces the new size.
+	screenshot(t, client)
+	if w, h := client.GetFrameBufferSize(); w != 48 || h != 24 {
+		t.Fatalf("GetFrameBufferSize() = %dx%d after resize, want 48x24", w, h)
+	}
+
+	img := screenshot(t, client)
+	if got := img.Bounds(); got != image.Rect(0, 0, 48, 24) {
+		t.Errorf("screenshot bounds = %v, want the resized display", got)
+	}
+	if got := img.RGBAAt(44, 22); got != red {
+		t.Errorf("pixel (44,22) = %v, want red", got)
+	}
+}
+
+func TestLoopback_Fence(t *testing.T) {
+	srv := newLoopback(t, 32, 32)
+	msgs := make(chan vnc.ServerMessage, 8)
+	client, _ := srv.Client(t,
+		vnc.WithServerMessageChannel(msgs),
+		vnc.WithInitialEncodings(&vnc.RawEncoding{}, &vnc.FencePseudoEncoding{}))
+
+	// The server confirms the pseudo-encoding with a fence request.
+	if f := receive[*vnc.FenceMessage](t, msgs); f.Flags&vnc.FenceRequest == 0 {
+		t.Fatalf("first fence flags = %#x, want a request", f.Flags)
+	}
+	if !client.FenceSupported() {
+		t.Fatal("FenceSupported() = false after the server's fence")
+	}
+
+	if err := client.Fence(vnc.FenceRequest|vnc.FenceBlockBefore, []byte("rtt")); err != nil {
+		t.Fatal(err)
+	}
+	resp := receive[*vnc.FenceMessage](t, msgs)
+	if resp.Flags != vnc.FenceBlockBefore || string(resp.Payload) != "rtt" {
+		t.Errorf("fence response = %#x %q, want FenceBlockBefore \"rtt\"", resp.Flags, resp.Payload)
+	}
+}
+
+func TestLoopback_ContinuousUpdates(t *testing.T) {
+	srv := newLoopback(t, 32, 32)
+	msgs := make(chan vnc.ServerMessage, 8)
+	client, _ := srv.Client(t,
+		vnc.WithServerMessageChannel(msgs),
+		vnc.WithInitialEncodings(&vnc.RawEncoding{}, &vnc.ContinuousUpdatesPseudoEncoding{}))
+
+	receive[*vnc.EndOfContinuousUpdatesMessage](t, msgs)
+	if err := client.EnableContinuousUpdates(true, 0, 0, 32, 32); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every change arrives without an update request.
+	for i, area := range []image.Rectangle{image.Rect(0, 0, 8, 8), image.Rect(16, 16, 32, 32)} {
+		srv.Display.Update(image.NewUniform(red), area)
+		update := receive[*vnc.FramebufferUpdateMessage](t, msgs)
+		if len(update.Rectangles) == 0 {
+			t.Fatalf("update %d has no rectangles", i)
+		}
+		r := update.Rectangles[0]
+		got := image.Rect(int(r.X), int(r.Y), int(r.X)+int(r.Width), int(r.Y)+int(r.Height))
+		if !got.Overlaps(area) {
+			t.Errorf("update %d covers %v, want the changed area %v", i, got, area)
+		}
+	}
+
+	if err := client.EnableContinuousUpdates(false, 0, 0, 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	receive[*vnc.EndOfContinuousUpdatesMessage](t, msgs)
+	if client.ContinuousUpdatesActive() {
+		t.Error("ContinuousUpdatesActive() = true after the server ended them")
+	}
+}
+
+func TestLoopback_ConcurrentOperations(t *testing.T) {
+	var (
+		mu   sync.Mutex
+		keys = make(map[uint32]bool)
+	)
+	srv := newLoopback(t, 100, 100)
+	srv.Display.Input = server.InputFuncs{
+		KeyFunc: func(_ *server.Conn, ev rfb.KeyEvent) error {
+			mu.Lock()
+			keys[ev.Key] = true
+			mu.Unlock()
+			return nil
+		},
+	}
+	client, _ := srv.Client(t)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := range 5 {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if err := client.FramebufferUpdateRequest(true, 0, 0, 100, 100); err != nil {
+				errs <- err
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			if err := client.KeyEvent(uint32(0x41+i), true); err != nil { // #nosec G115 - small loop index
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("concurrent operation: %v", err)
+	}
+
+	// The session still works, and every key arrived intact.
+	screenshot(t, client)
+	mu.Lock()
+	defer mu.Unlock()
+	for i := range uint32(5) {
+		if !keys[0x41+i] {
+			t.Errorf("key %#x was not delivered", 0x41+i)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package vnc
+
+import (
+	"context"
+	"errors"
+	"os"
+)
+
+// MessageKey identifies a user-facing error message. Keys are stable across
+// releases, so applications can map them to localized text instead of parsing
+// the English text returned by VNCError.Error.
+type MessageKey string
+
+// User-facing error messages.
+const (
+	MessageAuthenticationFailed MessageKey = "authentication_failed"
+	MessageUnsupportedServer    MessageKey = "unsupported_server"
+	MessageUnsupportedFeature   MessageKey = "unsupported_feature"
+	MessageTimeout              MessageKey = "timeout"
+	MessageCanceled             MessageKey = "canceled"
+	MessageConnectionFailed     MessageKey = "connection_failed"
+	MessageProtocolError        MessageKey = "protocol_error"
+	MessageInvalidInput         MessageKey = "invalid_input"
+	MessageInvalidConfiguration MessageKey = "invalid_configuration"
+	MessageUnknown              MessageKey = "unknown"
+)
+
+// defaultMessages holds the English text for each MessageKey.
+var defaultMessages = map[MessageKey]string{
+	MessageAuthenticationFailed: "Authentication with the VNC server failed.",
+	MessageUnsupportedServer:    "The VNC server is not supported.",
+	MessageUnsupportedFeature:   "The VNC server does not support this operation.",
+	MessageTimeout:              "The VNC server did not respond in time.",
+	MessageCanceled:             "The operation was canceled.",
+	MessageConnectionFailed:     "The connection to the VNC server failed.",
+	MessageProtocolError:        "The VNC server sent data that could not be understood.",
+	MessageInvalidInput:         "The request contained invalid input.",
+	MessageInvalidConfiguration: "The VNC client is not configured correctly.",
+	MessageUnknown:              "An unexpected VNC error occurred.",
+}
+
+// MessageCatalog returns the localized text for key. err provides context such
+// as the remote address for messages that include it. Returning an empty
+// string falls back to the default English text.
+type MessageCatalog func(key MessageKey, err *VNCError) string
+
+// Key returns the user-facing message key for the error.
+func (e *VNCError) Key() MessageKey {
+	switch {
+	case errors.Is(e.Err, context.Canceled):
+		return MessageCanceled
+	case e.Code == ErrTimeout, errors.Is(e.Err, context.DeadlineExceeded), errors.Is(e.Err, os.ErrDeadlineExceeded):
+		return MessageTimeout
+	}
+
+	switch e.Code {
+	case ErrAuthentication:
+		return MessageAuthenticationFailed
+	case ErrUnsupported:
+		if e.Phase == PhaseSession {
+			return MessageUnsupportedFeature
+		}
+		return MessageUnsupportedServer
+	case ErrNetwork:
+		return MessageConnectionFailed
+	case ErrProtocol, ErrEncoding:
+		return MessageProtocolError
+	case ErrValidation:
+		return MessageInvalidInput
+	case ErrConfiguration:
+		return MessageInvalidConfiguration
+	default:
+		return MessageUnknown
+	}
+}
+
+// UserMessage returns a short message suitable for showing to end users,
+// localized by the MessageCatalog of the connection that returned the error.
+// Errors that did not come from a connection with a catalog use English.
+//
+// Example usage:
+//
+//	var vncErr *vnc.VNCError
+//	if errors.As(err, &vncErr) {
+//		showDialog(vncErr.UserMessage())
+//	}
+func (e *VNCError) UserMessage() string {
+	key := e.Key()
+	if e.catalog != nil {
+		if text := e.catalog(key, e); text != "" {
+			return text
+		}
+	}
+	return defaultMessages[key]
+}
@@ -0,0 +1,163 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package vnc
+
+import (
+	"context"
+	"crypto/cipher"
+	"crypto/des" // #nosec G502 - Required by the MS-Logon II protocol
+	"crypto/rand"
+	"math/big"
+	"net"
+
+	"github.com/tenthirtyam/go-vnc/rfb"
+)
+
+// Sizes of the NUL-terminated credential fields of MS-Logon II.
+const (
+	msLogonUsernameSize = 256
+	msLogonPasswordSize = 64
+)
+
+// MSLogonIIAuth implements the MS-Logon II authentication of UltraVNC
+// (security type 113), with which Windows installations check the
+// credentials of a Windows account. The client agrees on a 64-bit key with
+// the server by Diffie-Hellman and sends the username and password encrypted
+// with DES in CBC mode under it.
+//
+// A Domain is sent with the username as DOMAIN\username. The username is
+// truncated to 255 bytes and the password to 63.
+type MSLogonIIAuth struct {
+	Domain   string
+	Username string
+	Password string
+
+	// Credentials, if set, supplies the username and password at handshake
+	// time in place of Username and Password.
+	Credentials CredentialProvider
+
+	logger Logger
+}
+
+// SecurityType returns the security type identifier for MS-Logon II.
+func (m *MSLogonIIAuth) SecurityType() uint8 {
+	return rfb.SecurityUltraMSLogonII
+}
+
+// String returns a human-readable description of the authentication method.
+func (m *MSLogonIIAuth) String() string {
+	return "MS-Logon II"
+}
+
+// SetLogger sets the logger for the authentication method.
+func (m *MSLogonIIAuth) SetLogger(logger Logger) {
+	m.logger = logger
+}
+
+// ClearPassword securely clears the password from memory.
+func (m *MSLogonIIAuth) ClearPassword() {
+	if m.Password != "" {
+		m.Password = (&SecureMemory{}).ClearString(m.Password)
+	}
+}
+
+// Handshake reads the Diffie-Hellman parameters of the server and sends the
+// public key of the client with the encrypted credentials.
+func (m *MSLogonIIAuth) Handshake(ctx context.Context, conn net.Conn) error {
+	select {
+	case <-ctx.Done():
+		return timeoutError("MSLogonIIAuth.Handshake", "authentication cancelled", ctx.Err())
+	default:
+	}
+
+	logger := m.logger
+	if logger == nil {
+		logger = &NoOpLogger{}
+	}
+
+	params, err := readBytes(conn, "MS-Logon II parameters", 24)
+	if err != nil {
+		return networkError("MSLogonIIAuth.Handshake", "failed to read Diffie-Hellman parameters", err)
+	}
+	generator := new(big.Int).SetBytes(params[:8])
+	modulus := new(big.Int).SetBytes(params[8:16])
+	serverPublic := new(big.Int).SetBytes(params[16:])
+	if modulus.Cmp(big.NewInt(3)) < 0 || generator.Sign() <= 0 || serverPublic.Sign() <= 0 {
+		return protocolError("MSLogonIIAuth.Handshake", "invalid Diffie-Hellman parameters", nil)
+	}
+
+	private, err := rand.Int(rand.Reader, new(big.Int).Sub(modulus, big.NewInt(2)))
+	if err != nil {
+		return authenticationError("MSLogonIIAuth.Handshake", "failed to generate private key", err)
+	}
+	private.Add(private, big.NewInt(1))
+	public := new(big.Int).Exp(generator, private, modulus)
+
+	memProtection := newMemoryProtection()
+	key := memProtection.NewProtectedBytes(des.BlockSize)
+	defer key.Clear()
+	new(big.Int).Exp(serverPublic, private, modulus).FillBytes(key.Data())
+
+	username, password, err := resolveCredentials(ctx, "MSLogonIIAuth.Handshake", m.Credentials, m.Username, m.Password, true)
+	if err != nil {
+		return err
+	}
+	defer (&SecureMemory{}).ClearBytes(password)
+	if m.Domain != "" {
+		username = m.Domain + `\` + username
+	}
+	response := memProtection.NewProtectedBytes(8 + msLogonUsernameSize + msLogonPasswordSize)
+	defer response.Clear()
+	buf := response.Data()
+	public.FillBytes(buf[:8])
+	if err := msLogonField(buf[8:8+msLogonUsernameSize], []byte(username)); err != nil {
+		return authenticationError("MSLogonIIAuth.Handshake", "failed to prepare credentials", err)
+	}
+	if err := msLogonField(buf[8+msLogonUsernameSize:], password); err != nil {
+		return authenticationError("MSLogonIIAuth.Handshake", "failed to prepare credentials", err)
+	}
+
+	if err := msLogonEncrypt(key.Data(), buf[8:8+msLogonUsernameSize]); err != nil {
+		return authenticationError("MSLogonIIAuth.Handshake", "failed to encrypt credentials", err)
+	}
+	if err := msLogonEncrypt(key.Data(), buf[8+msLogonUsernameSize:]); err != nil {
+		return authenticationError("MSLogonIIAuth.Handshake", "failed to encrypt credentials", err)
+	}
+
+	if _, err := conn.Write(buf); err != nil {
+		return networkError("MSLogonIIAuth.Handshake", "failed to send credentials", err)
+	}
+	logger.Debug("Sent MS-Logon II credentials", Field{Key: "domain", Value: m.Domain != ""})
+	return nil
+}
+
+// msLogonField fills field with random bytes and writes value into it,
+// NUL-terminated and truncated to fit.
+func msLogonField(field, value []byte) error {
+	if _, err := rand.Read(field); err != nil {
+		return err
+	}
+	n := copy(field[:len(field)-1], value)
+	field[n] = 0
+	return nil
+}
+
+// msLogonEncrypt encrypts data in place with DES in CBC mode, using key as
+// both the key and the initialization vector. Like VNC authentication, the
+// protocol takes the key bits of each byte in reverse order.
+func msLogonEncrypt(key, data []byte) error {
+	sdc := NewSecureDESCipher()
+	var reversed [des.BlockSize]byte
+	for i := range reversed {
+		reversed[i] = sdc.reverseBitsSecure(key[i])
+	}
+	defer (&SecureMemory{}).ClearBytes(reversed[:])
+
+	block, err := des.NewCipher(reversed[:])
+	if err != nil {
+		return err
+	}
+	cipher.NewCBCEncrypter(block, key).CryptBlocks(data, data)
+	return nil
+}
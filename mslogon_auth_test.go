@@ -0,0 +1,95 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package vnc
+
+import (
+	"bytes"
+	"context"
+	"crypto/cipher"
+	"crypto/des" // #nosec G502 - Required by the MS-Logon II protocol
+	"io"
+	"math/big"
+	"net"
+	"testing"
+)
+
+// serveMSLogonII plays the server side of MS-Logon II and returns the
+// username and password it decrypted.
+func serveMSLogonII(conn net.Conn) (string, string, error) {
+	generator, modulus, private := big.NewInt(5), big.NewInt(2147483647), big.NewInt(123456)
+	public := new(big.Int).Exp(generator, private, modulus)
+
+	params := make([]byte, 24)
+	generator.FillBytes(params[:8])
+	modulus.FillBytes(params[8:16])
+	public.FillBytes(params[16:])
+	if _, err := conn.Write(params); err != nil {
+		return "", "", err
+	}
+
+	response := make([]byte, 8+msLogonUsernameSize+msLogonPasswordSize)
+	if _, err := io.ReadFull(conn, response); err != nil {
+		return "", "", err
+	}
+	key := new(big.Int).Exp(new(big.Int).SetBytes(response[:8]), private, modulus).FillBytes(make([]byte, 8))
+	sdc := NewSecureDESCipher()
+	reversed := make([]byte, 8)
+	for i := range reversed {
+		reversed[i] = sdc.reverseBitsSecure(key[i])
+	}
+	block, err := des.NewCipher(reversed)
+	if err != nil {
+		return "", "", err
+	}
+
+	var fields [2]string
+	for i, field := range [][]byte{response[8 : 8+msLogonUsernameSize], response[8+msLogonUsernameSize:]} {
+		cipher.NewCBCDecrypter(block, key).CryptBlocks(field, field)
+		fields[i] = string(field[:bytes.IndexByte(field, 0)])
+	}
+	return fields[0], fields[1], nil
+}
+
+func TestMSLogonIIAuth_Handshake(t *testing.T) {
+	server, client := net.Pipe()
+	defer func() { _ = client.Close() }()
+
+	type result struct {
+		username, password string
+		err                error
+	}
+	done := make(chan result, 1)
+	go func() {
+		defer func() { _ = server.Close() }()
+		var r result
+		r.username, r.password, r.err = serveMSLogonII(server)
+		done <- r
+	}()
+
+	auth := &MSLogonIIAuth{Domain: "CORP", Username: "alice", Password: "hunter2"}
+	if err := auth.Handshake(context.Background(), client); err != nil {
+		t.Fatalf("Handshake() error = %v", err)
+	}
+	r := <-done
+	if r.err != nil {
+		t.Fatalf("server error = %v", r.err)
+	}
+	if r.username != `CORP\alice` || r.password != "hunter2" {
+		t.Errorf("credentials = %q/%q, want CORP\\alice/hunter2", r.username, r.password)
+	}
+}
+
+func TestMSLogonIIAuth_InvalidParameters(t *testing.T) {
+	server, client := net.Pipe()
+	defer func() { _ = client.Close() }()
+	go func() {
+		defer func() { _ = server.Close() }()
+		_, _ = server.Write(make([]byte, 24))
+	}()
+
+	err := (&MSLogonIIAuth{}).Handshake(context.Background(), client)
+	if !IsVNCError(err, ErrProtocol) {
+		t.Errorf("Handshake() error = %v, want protocol error", err)
+	}
+}
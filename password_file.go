@@ -0,0 +1,93 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package vnc
+
+import (
+	"bytes"
+	"crypto/des" // #nosec G502 - Required by the VNC password file format
+	"fmt"
+	"os"
+)
+
+// vncPasswordFileKey is the fixed DES key with which vncpasswd obfuscates
+// passwords, written as the d3des key {23, 82, 107, 6, 35, 78, 88, 7} with
+// the bits of each byte reversed for crypto/des.
+var vncPasswordFileKey = []byte{0xe8, 0x4a, 0xd6, 0x60, 0xc4, 0x72, 0x1a, 0xe0}
+
+// EncodePasswordFile returns the contents of a VNC password file, such as
+// ~/.vnc/passwd, for password: its first 8 bytes, padded with zeros and
+// encrypted with the fixed key of vncpasswd. The encryption only obfuscates
+// the password, so the file must be kept private.
+func EncodePasswordFile(password string) ([]byte, error) {
+	block, err := des.NewCipher(vncPasswordFileKey) // #nosec G405 - Required by the VNC password file format
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext := make([]byte, DESKeySize)
+	defer (&SecureMemory{}).ClearBytes(plaintext)
+	copy(plaintext, password)
+
+	data := make([]byte, DESKeySize)
+	block.Encrypt(data, plaintext)
+	return data, nil
+}
+
+// DecodePasswordFile returns the password stored in the contents of a VNC
+// password file. Files written by TigerVNC may hold a second, view-only
+// password after the first, which is ignored.
+func DecodePasswordFile(data []byte) (string, error) {
+	if len(data) < DESKeySize {
+		return "", validationError("DecodePasswordFile",
+			fmt.Sprintf("password file holds %d bytes, want at least %d", len(data), DESKeySize), nil)
+	}
+
+	block, err := des.NewCipher(vncPasswordFileKey) // #nosec G405 - Required by the VNC password file format
+	if err != nil {
+		return "", err
+	}
+
+	plaintext := make([]byte, DESKeySize)
+	defer (&SecureMemory{}).ClearBytes(plaintext)
+	block.Decrypt(plaintext, data[:DESKeySize])
+	if n := bytes.IndexByte(plaintext, 0); n >= 0 {
+		return string(plaintext[:n]), nil
+	}
+	return string(plaintext), nil
+}
+
+// ReadPasswordFile returns the password stored in the VNC password file at
+// path, such as one written by vncpasswd.
+func ReadPasswordFile(path string) (string, error) {
+	data, err := os.ReadFile(path) // #nosec G304 - the path is chosen by the caller
+	if err != nil {
+		return "", configurationError("ReadPasswordFile", "failed to read password file", err)
+	}
+	defer (&SecureMemory{}).ClearBytes(data)
+	return DecodePasswordFile(data)
+}
+
+// WritePasswordFile stores password in a VNC password file at path, readable
+// by vncpasswd-compatible servers and viewers. The file is created with
+// permissions 0600.
+func WritePasswordFile(path, password string) error {
+	data, err := EncodePasswordFile(password)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		return configurationError("WritePasswordFile", "failed to write password file", err)
+	}
+	return nil
+}
+
+// NewPasswordAuthFromFile creates a PasswordAuth with the password stored in
+// the VNC password file at path.
+func NewPasswordAuthFromFile(path string) (*PasswordAuth, error) {
+	password, err := ReadPasswordFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewPasswordAuth(password), nil
+}
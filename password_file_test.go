@@ -0,0 +1,67 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package vnc
+
+import (
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPasswordFile_KnownValue(t *testing.T) {
+	// vncpasswd stores "password" as these bytes.
+	want, _ := hex.DecodeString("dbd83cfd727a1458")
+
+	got, err := EncodePasswordFile("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hex.EncodeToString(got) != hex.EncodeToString(want) {
+		t.Errorf("EncodePasswordFile() = %x, want %x", got, want)
+	}
+
+	// A TigerVNC file with a view-only password appended.
+	password, err := DecodePasswordFile(append(want, want...))
+	if err != nil || password != "password" {
+		t.Errorf("DecodePasswordFile() = %q, %v, want password", password, err)
+	}
+}
+
+func TestPasswordFile_RoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "passwd")
+	for _, tt := range []struct{ password, want string }{
+		{"secret", "secret"},
+		{"", ""},
+		{"longerthan8", "longerth"},
+	} {
+		if err := WritePasswordFile(path, tt.password); err != nil {
+			t.Fatalf("WritePasswordFile() error = %v", err)
+		}
+		auth, err := NewPasswordAuthFromFile(path)
+		if err != nil {
+			t.Fatalf("NewPasswordAuthFromFile() error = %v", err)
+		}
+		if auth.Password != tt.want {
+			t.Errorf("password = %q, want %q", auth.Password, tt.want)
+		}
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0o600 {
+		t.Errorf("permissions = %o, want 600", perm)
+	}
+}
+
+func TestPasswordFile_Errors(t *testing.T) {
+	if _, err := DecodePasswordFile([]byte{1, 2, 3}); !IsVNCError(err, ErrValidation) {
+		t.Errorf("DecodePasswordFile(short) error = %v, want validation error", err)
+	}
+	if _, err := ReadPasswordFile(filepath.Join(t.TempDir(), "missing")); !IsVNCError(err, ErrConfiguration) {
+		t.Errorf("ReadPasswordFile(missing) error = %v, want configuration error", err)
+	}
+}
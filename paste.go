@@ -0,0 +1,286 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package vnc
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// X11 keysyms used by Paste.
+const (
+	keysymTab      = 0xff09
+	keysymReturn   = 0xff0d
+	keysymControlL = 0xffe3
+
+	// keysymUnicode is OR-ed with a code point to form the keysym of a
+	// character that has no legacy keysym.
+	keysymUnicode = 0x01000000
+)
+
+// PasteOption configures a single Paste call.
+type PasteOption func(*pasteConfig)
+
+// pasteConfig holds the settings applied by PasteOptions.
+type pasteConfig struct {
+	chunkSize int
+	keys      []uint32
+	typing    bool
+	rate      float64
+	shift     bool
+	progress  func(sent, total int)
+}
+
+// WithPasteChunkSize sets the largest number of bytes sent in one client cut
+// text message. Values outside (0, MaxClipboardLength] use MaxClipboardLength.
+func WithPasteChunkSize(size int) PasteOption {
+	return func(cfg *pasteConfig) {
+		cfg.chunkSize = size
+	}
+}
+
+// WithPasteKeys sets the keysyms pressed, in order, to paste each chunk once it
+// is on the server clipboard. The default is Control_L+v. With no keysyms Paste
+// only sets the server clipboard, and text longer than one chunk is rejected
+// because each chunk would replace the previous one.
+func WithPasteKeys(keysyms ...uint32) PasteOption {
+	return func(cfg *pasteConfig) {
+		cfg.keys = keysyms
+	}
+}
+
+// WithPasteTyping makes Paste type the text as key events instead of using the
+// clipboard. Use it for servers that ignore client cut text, or for text
+// outside Latin-1, which cut text cannot carry.
+func WithPasteTyping(typing bool) PasteOption {
+	return func(cfg *pasteConfig) {
+		cfg.typing = typing
+	}
+}
+
+// WithPasteTypingRate limits WithPasteTyping to cps characters per second.
+// Slow targets such as BMC KVM consoles drop keys typed faster than they can
+// forward them to the host. Zero or less types as fast as possible.
+func WithPasteTypingRate(cps float64) PasteOption {
+	return func(cfg *pasteConfig) {
+		cfg.rate = cps
+	}
+}
+
+// WithPasteShift makes WithPasteTyping hold Shift_L around characters typed
+// with Shift on a US keyboard, such as uppercase letters and "!". Servers
+// that turn keysyms into scan codes, as BMC KVM consoles do, otherwise type
+// "a" for "A" and "1" for "!".
+func WithPasteShift(enabled bool) PasteOption {
+	return func(cfg *pasteConfig) {
+		cfg.shift = enabled
+	}
+}
+
+// WithPasteProgress registers a function called after each chunk or typed
+// character with the number of characters delivered so far and in total.
+func WithPasteProgress(fn func(sent, total int)) PasteOption {
+	return func(cfg *pasteConfig) {
+		cfg.progress = fn
+	}
+}
+
+// Paste delivers text to the focused application on the remote desktop.
+//
+// By default the text is split into chunks that fit in a client cut text
+// message (see MaxClipboardLength); each chunk is placed on the server
+// clipboard and pasted with Control_L+v before the next one replaces it. Chunks
+// are split on character boundaries and paced by the measured round-trip time,
+// giving the remote application a chance to read the clipboard. With
+// WithPasteTyping the text is typed instead, one key event pair per character,
+// using Unicode keysyms for characters without a legacy keysym.
+//
+// Paste stops when ctx ends, releasing any keys it is holding. The text is
+// validated before anything is sent, so a validation error means nothing was
+// pasted.
+//
+// Example usage:
+//
+//	err := client.Paste(ctx, text,
+//		vnc.WithPasteProgress(func(sent, total int) {
+//			log.Printf("pasted %d/%d characters", sent, total)
+//		}))
+func (c *ClientConn) Paste(ctx context.Context, text string, options ...PasteOption) error {
+	cfg := pasteConfig{keys: []uint32{keysymControlL, 'v'}}
+	for _, option := range options {
+		option(&cfg)
+	}
+	if cfg.chunkSize <= 0 || cfg.chunkSize > MaxClipboardLength {
+		cfg.chunkSize = MaxClipboardLength
+	}
+
+	if err := newInputValidator().ValidateTextData(text, len(text)); err != nil {
+		return c.enrichError(validationError("Paste", "invalid paste text", err))
+	}
+
+	if cfg.typing {
+		return c.pasteTyping(ctx, text, cfg)
+	}
+	return c.pasteClipboard(ctx, text, cfg)
+}
+
+// pasteClipboard pastes text through the server clipboard in chunks.
+func (c *ClientConn) pasteClipboard(ctx context.Context, text string, cfg pasteConfig) error {
+	for i, char := range text {
+		if char > Latin1MaxCodePoint {
+			return c.enrichError(validationError("Paste",
+				fmt.Sprintf("character '%c' at position %d is not valid Latin-1; use WithPasteTyping", char, i), nil))
+		}
+	}
+
+	chunks := splitPasteChunks(text, cfg.chunkSize)
+	if len(cfg.keys) == 0 && len(chunks) > 1 {
+		return c.enrichError(validationError("Paste",
+			fmt.Sprintf("text of %d bytes exceeds the chunk size %d and no paste keys are set", len(text), cfg.chunkSize), nil))
+	}
+
+	rtt, _ := c.RoundTripTime()
+	delay := c.gestureTiming().Delay(rtt)
+	total := utf8.RuneCountInString(text)
+	sent := 0
+
+	for _, chunk := range chunks {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := c.CutText(chunk); err != nil {
+			return err
+		}
+		if len(cfg.keys) > 0 {
+			if err := sleepContext(ctx, delay); err != nil {
+				return err
+			}
+			if err := c.pressKeys(ctx, cfg.keys, delay); err != nil {
+				return err
+			}
+		}
+
+		sent += utf8.RuneCountInString(chunk)
+		if cfg.progress != nil {
+			cfg.progress(sent, total)
+		}
+	}
+
+	return nil
+}
+
+// TypeClipboardFallback types text at cps characters per second, holding
+// Shift where a US keyboard needs it. It is the fallback for servers that
+// ignore client cut text, which is common on BMC KVM consoles, and is
+// equivalent to Paste with WithPasteTyping, WithPasteTypingRate, and
+// WithPasteShift.
+//
+// Example usage:
+//
+//	err := client.TypeClipboardFallback(ctx, "P@ssw0rd!", 20)
+func (c *ClientConn) TypeClipboardFallback(ctx context.Context, text string, cps float64) error {
+	return c.Paste(ctx, text, WithPasteTyping(true), WithPasteTypingRate(cps), WithPasteShift(true))
+}
+
+// pasteTyping types text as key events.
+func (c *ClientConn) pasteTyping(ctx context.Context, text string, cfg pasteConfig) error {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	total := utf8.RuneCountInString(text)
+	sent := 0
+
+	var interval time.Duration
+	if cfg.rate > 0 {
+		interval = time.Duration(float64(time.Second) / cfg.rate)
+	}
+
+	for _, char := range text {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if sent > 0 && interval > 0 {
+			if err := sleepContext(ctx, interval); err != nil {
+				return err
+			}
+		}
+
+		keysyms := []uint32{runeKeysym(char)}
+		if cfg.shift && strings.ContainsRune(shiftedCharacters, char) {
+			keysyms = []uint32{keysymShiftL, keysyms[0]}
+		}
+		if err := c.pressKeys(ctx, keysyms, 0); err != nil {
+			return err
+		}
+
+		sent++
+		if cfg.progress != nil {
+			cfg.progress(sent, total)
+		}
+	}
+
+	return nil
+}
+
+// pressKeys presses keysyms in order, waits delay, and releases them in
+// reverse order. The keys are released even if ctx ends while they are held.
+func (c *ClientConn) pressKeys(ctx context.Context, keysyms []uint32, delay time.Duration) error {
+	pressed := 0
+	var err error
+	for _, keysym := range keysyms {
+		if err = c.KeyEvent(keysym, true); err != nil {
+			break
+		}
+		pressed++
+	}
+	if err == nil {
+		err = sleepContext(ctx, delay)
+	}
+
+	for i := pressed - 1; i >= 0; i-- {
+		if releaseErr := c.KeyEvent(keysyms[i], false); releaseErr != nil && err == nil {
+			err = releaseErr
+		}
+	}
+	return err
+}
+
+// shiftedCharacters are the characters typed with Shift on a US keyboard.
+const shiftedCharacters = `ABCDEFGHIJKLMNOPQRSTUVWXYZ~!@#$%^&*()_+{}|:"<>?`
+
+// splitPasteChunks splits text into chunks of at most size bytes without
+// splitting a character.
+func splitPasteChunks(text string, size int) []string {
+	var chunks []string
+	for len(text) > size {
+		end := size
+		for end > 0 && !utf8.RuneStart(text[end]) {
+			end--
+		}
+		if end == 0 {
+			_, end = utf8.DecodeRuneInString(text)
+		}
+		chunks = append(chunks, text[:end])
+		text = text[end:]
+	}
+	if text != "" {
+		chunks = append(chunks, text)
+	}
+	return chunks
+}
+
+// runeKeysym returns the X11 keysym that types char.
+func runeKeysym(char rune) uint32 {
+	switch {
+	case char == '\n' || char == '\r':
+		return keysymReturn
+	case char == '\t':
+		return keysymTab
+	case char >= 0x20 && char <= 0x7e, char >= 0xa0 && char <= Latin1MaxCodePoint:
+		return uint32(char) // #nosec G115 - char is within Latin-1
+	default:
+		return keysymUnicode | uint32(char) // #nosec G115 - char is a valid code point, at most 0x10FFFF
+	}
+}
@@ -0,0 +1,141 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package vnc
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tenthirtyam/go-vnc/rfb"
+)
+
+// receiveKeys reads n key events recorded by the update server.
+func receiveKeys(t *testing.T, srv *updateServer, n int) []rfb.KeyEvent {
+	t.Helper()
+	events := make([]rfb.KeyEvent, n)
+	for i := range events {
+		events[i] = <-srv.keys
+	}
+	return events
+}
+
+func TestPaste_Chunks(t *testing.T) {
+	srv, conn := newUpdateServer(t, 16, 16)
+
+	var progress [][2]int
+	text := strings.Repeat("a", 5) + "é" + strings.Repeat("b", 3)
+	err := conn.Paste(context.Background(), text,
+		WithPasteChunkSize(6),
+		WithPasteProgress(func(sent, total int) {
+			progress = append(progress, [2]int{sent, total})
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The two-byte "é" does not fit in the first chunk and moves to the second.
+	for i, want := range []string{"aaaaa", "ébbb"} {
+		if got := string(<-srv.cutTexts); got != latin1String(want) {
+			t.Errorf("chunk %d = %q, want %q", i, got, want)
+		}
+
+		keys := receiveKeys(t, srv, 4)
+		wantKeys := []rfb.KeyEvent{
+			{Down: true, Key: keysymControlL},
+			{Down: true, Key: 'v'},
+			{Down: false, Key: 'v'},
+			{Down: false, Key: keysymControlL},
+		}
+		if !reflect.DeepEqual(keys, wantKeys) {
+			t.Errorf("chunk %d paste keys = %+v, want %+v", i, keys, wantKeys)
+		}
+	}
+
+	if want := [][2]int{{5, 9}, {9, 9}}; !reflect.DeepEqual(progress, want) {
+		t.Errorf("progress = %v, want %v", progress, want)
+	}
+}
+
+func TestPaste_Typing(t *testing.T) {
+	srv, conn := newUpdateServer(t, 16, 16)
+
+	if err := conn.Paste(context.Background(), "a\r\n世", WithPasteTyping(true)); err != nil {
+		t.Fatal(err)
+	}
+
+	var typed []uint32
+	for i, ev := range receiveKeys(t, srv, 6) {
+		if ev.Down != (i%2 == 0) {
+			t.Fatalf("event %d = %+v, want alternating press and release", i, ev)
+		}
+		if ev.Down {
+			typed = append(typed, ev.Key)
+		}
+	}
+	if want := []uint32{'a', keysymReturn, keysymUnicode | '世'}; !reflect.DeepEqual(typed, want) {
+		t.Errorf("typed keysyms = %#x, want %#x", typed, want)
+	}
+}
+
+func TestPaste_TypingShiftAndRate(t *testing.T) {
+	srv, conn := newUpdateServer(t, 16, 16)
+
+	start := time.Now()
+	if err := conn.TypeClipboardFallback(context.Background(), "A!a", 50); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+		t.Errorf("typed 3 characters at 50 cps in %v, want at least 40ms", elapsed)
+	}
+
+	want := []rfb.KeyEvent{
+		{Down: true, Key: keysymShiftL},
+		{Down: true, Key: 'A'},
+		{Down: false, Key: 'A'},
+		{Down: false, Key: keysymShiftL},
+		{Down: true, Key: keysymShiftL},
+		{Down: true, Key: '!'},
+		{Down: false, Key: '!'},
+		{Down: false, Key: keysymShiftL},
+		{Down: true, Key: 'a'},
+		{Down: false, Key: 'a'},
+	}
+	if got := receiveKeys(t, srv, len(want)); !reflect.DeepEqual(got, want) {
+		t.Errorf("key events = %+v, want %+v", got, want)
+	}
+}
+
+func TestPaste_Validation(t *testing.T) {
+	_, conn := newUpdateServer(t, 16, 16)
+
+	tests := []struct {
+		name    string
+		text    string
+		options []PasteOption
+	}{
+		{name: "Not Latin-1", text: "世界"},
+		{name: "Control character", text: "a\x00b", options: []PasteOption{WithPasteTyping(true)}},
+		{name: "Too long without paste keys", text: "abcdef", options: []PasteOption{WithPasteChunkSize(4), WithPasteKeys()}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := conn.Paste(context.Background(), tt.text, tt.options...); !IsVNCError(err, ErrValidation) {
+				t.Errorf("expected validation error, got %v", err)
+			}
+		})
+	}
+}
+
+// latin1String returns the Latin-1 encoding of s as a string.
+func latin1String(s string) string {
+	b := make([]byte, 0, len(s))
+	for _, r := range s {
+		b = append(b, byte(r))
+	}
+	return string(b)
+}
@@ -9,6 +9,8 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io"
+
+	"github.com/tenthirtyam/go-vnc/rfb"
 )
 
 // PixelFormat describes how pixel color data is encoded and interpreted in a VNC connection.
@@ -51,133 +53,40 @@ type PixelFormat struct {
 // readPixelFormat reads a VNC pixel format from the wire format.
 // Parses the 16-byte pixel format structure as defined in RFC 6143.
 func readPixelFormat(r io.Reader, result *PixelFormat) error {
-	var rawPixelFormat [16]byte
-	if _, err := io.ReadFull(r, rawPixelFormat[:]); err != nil {
+	pf, err := rfb.ReadPixelFormat(r)
+	if err != nil {
 		return networkError("readPixelFormat", "failed to read pixel format data", err)
 	}
 
-	var pfBoolByte uint8
-	brPF := bytes.NewReader(rawPixelFormat[:])
-	if err := binary.Read(brPF, binary.BigEndian, &result.BPP); err != nil {
-		return protocolError("readPixelFormat", "failed to read BPP field", err)
-	}
-
-	if err := binary.Read(brPF, binary.BigEndian, &result.Depth); err != nil {
-		return protocolError("readPixelFormat", "failed to read depth field", err)
-	}
-
-	if err := binary.Read(brPF, binary.BigEndian, &pfBoolByte); err != nil {
-		return protocolError("readPixelFormat", "failed to read big endian flag", err)
-	}
-
-	if pfBoolByte != 0 {
-		// Big endian is true
-		result.BigEndian = true
-	}
-
-	if err := binary.Read(brPF, binary.BigEndian, &pfBoolByte); err != nil {
-		return protocolError("readPixelFormat", "failed to read true color flag", err)
-	}
-
-	if pfBoolByte != 0 {
-		// True Color is true. So we also have to read all the color max & shifts.
-		result.TrueColor = true
-
-		if err := binary.Read(brPF, binary.BigEndian, &result.RedMax); err != nil {
-			return protocolError("readPixelFormat", "failed to read red max value", err)
-		}
-
-		if err := binary.Read(brPF, binary.BigEndian, &result.GreenMax); err != nil {
-			return protocolError("readPixelFormat", "failed to read green max value", err)
-		}
-
-		if err := binary.Read(brPF, binary.BigEndian, &result.BlueMax); err != nil {
-			return protocolError("readPixelFormat", "failed to read blue max value", err)
-		}
-
-		if err := binary.Read(brPF, binary.BigEndian, &result.RedShift); err != nil {
-			return protocolError("readPixelFormat", "failed to read red shift value", err)
-		}
-
-		if err := binary.Read(brPF, binary.BigEndian, &result.GreenShift); err != nil {
-			return protocolError("readPixelFormat", "failed to read green shift value", err)
-		}
-
-		if err := binary.Read(brPF, binary.BigEndian, &result.BlueShift); err != nil {
-			return protocolError("readPixelFormat", "failed to read blue shift value", err)
-		}
+	// Color maximums and shifts are only meaningful in true color mode.
+	if !pf.TrueColor {
+		pf.RedMax, pf.GreenMax, pf.BlueMax = 0, 0, 0
+		pf.RedShift, pf.GreenShift, pf.BlueShift = 0, 0, 0
 	}
 
+	*result = PixelFormat(pf)
 	return nil
 }
 
 // writePixelFormat converts a PixelFormat to its wire format representation.
 // Returns the 16-byte pixel format structure as defined in RFC 6143.
 func writePixelFormat(format *PixelFormat) ([]byte, error) {
-	var buf bytes.Buffer
-
-	// Byte 1
-	if err := binary.Write(&buf, binary.BigEndian, format.BPP); err != nil {
-		return nil, encodingError("writePixelFormat", "failed to write BPP field", err)
-	}
-
-	// Byte 2
-	if err := binary.Write(&buf, binary.BigEndian, format.Depth); err != nil {
-		return nil, encodingError("writePixelFormat", "failed to write depth field", err)
-	}
-
-	var boolByte byte
-	if format.BigEndian {
-		boolByte = 1
-	} else {
-		boolByte = 0
-	}
-
-	// Byte 3 (BigEndian)
-	if err := binary.Write(&buf, binary.BigEndian, boolByte); err != nil {
-		return nil, encodingError("writePixelFormat", "failed to write big endian flag", err)
-	}
-
-	if format.TrueColor {
-		boolByte = 1
-	} else {
-		boolByte = 0
-	}
-
-	// Byte 4 (TrueColor)
-	if err := binary.Write(&buf, binary.BigEndian, boolByte); err != nil {
-		return nil, encodingError("writePixelFormat", "failed to write true color flag", err)
+	b, err := wirePixelFormat(format).MarshalBinary()
+	if err != nil {
+		return nil, encodingError("writePixelFormat", "failed to encode pixel format", err)
 	}
+	return b, nil
+}
 
-	// If we have true color enabled then we have to fill in the rest of the
-	// structure with the color values.
-	if format.TrueColor {
-		if err := binary.Write(&buf, binary.BigEndian, format.RedMax); err != nil {
-			return nil, encodingError("writePixelFormat", "failed to write red max value", err)
-		}
-
-		if err := binary.Write(&buf, binary.BigEndian, format.GreenMax); err != nil {
-			return nil, encodingError("writePixelFormat", "failed to write green max value", err)
-		}
-
-		if err := binary.Write(&buf, binary.BigEndian, format.BlueMax); err != nil {
-			return nil, encodingError("writePixelFormat", "failed to write blue max value", err)
-		}
-
-		if err := binary.Write(&buf, binary.BigEndian, format.RedShift); err != nil {
-			return nil, encodingError("writePixelFormat", "failed to write red shift value", err)
-		}
-
-		if err := binary.Write(&buf, binary.BigEndian, format.GreenShift); err != nil {
-			return nil, encodingError("writePixelFormat", "failed to write green shift value", err)
-		}
-
-		if err := binary.Write(&buf, binary.BigEndian, format.BlueShift); err != nil {
-			return nil, encodingError("writePixelFormat", "failed to write blue shift value", err)
-		}
+// wirePixelFormat converts a PixelFormat to its rfb representation, clearing
+// the color maximums and shifts when true color is disabled.
+func wirePixelFormat(format *PixelFormat) rfb.PixelFormat {
+	pf := rfb.PixelFormat(*format)
+	if !pf.TrueColor {
+		pf.RedMax, pf.GreenMax, pf.BlueMax = 0, 0, 0
+		pf.RedShift, pf.GreenShift, pf.BlueShift = 0, 0, 0
 	}
-
-	return buf.Bytes()[0:16], nil
+	return pf
 }
 
 // PixelFormatValidationError represents a pixel format validation error with detailed context.
@@ -354,6 +263,22 @@ var (
 		BlueShift:  0,
 	}
 
+	// PixelFormat8BitBGR233 represents 8-bit BGR233 true color format.
+	// This format uses the least bandwidth without a color map, at the cost of
+	// visible banding in gradients.
+	PixelFormat8BitBGR233 = &PixelFormat{
+		BPP:        8,
+		Depth:      8,
+		BigEndian:  false,
+		TrueColor:  true,
+		RedMax:     7,
+		GreenMax:   7,
+		BlueMax:    3,
+		RedShift:   0,
+		GreenShift: 3,
+		BlueShift:  6,
+	}
+
 	// PixelFormat8BitIndexed represents bandwidth-efficient 8-bit indexed color format.
 	// This format uses the least bandwidth but is limited to 256 simultaneous colors.
 	PixelFormat8BitIndexed = &PixelFormat{
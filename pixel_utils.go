@@ -11,12 +11,21 @@ import (
 // PixelReader provides utilities for reading pixel data from VNC streams.
 type PixelReader struct {
 	pixelFormat PixelFormat
-	colorMap    [ColorMapSize]Color
+	colorMap    *[ColorMapSize]Color
 	byteOrder   binary.ByteOrder
+
+	// probe, if set, samples decoded pixels for a byte order mismatch.
+	probe *endiannessProbe
 }
 
 // NewPixelReader creates a new pixel reader for the given pixel format and color map.
 func NewPixelReader(pixelFormat PixelFormat, colorMap [ColorMapSize]Color) *PixelReader {
+	return newPixelReader(pixelFormat, &colorMap)
+}
+
+// newPixelReader creates a pixel reader that shares colorMap, which the caller
+// must not modify while the reader is in use.
+func newPixelReader(pixelFormat PixelFormat, colorMap *[ColorMapSize]Color) *PixelReader {
 	var byteOrder binary.ByteOrder = binary.LittleEndian
 	if pixelFormat.BigEndian {
 		byteOrder = binary.BigEndian
@@ -45,9 +54,21 @@ func (pr *PixelReader) ReadPixelColor(r io.Reader) (Color, error) {
 	}
 
 	rawPixel := pr.bytesToPixel(pixelBytes)
+	if pr.probe != nil && !pr.probe.done {
+		pr.probe.observe(rawPixel)
+	}
 	return pr.pixelToColor(rawPixel), nil
 }
 
+// decodePixels converts the pixels in data to dst. It does not feed the
+// endianness probe, so it may run on any goroutine.
+func (pr *PixelReader) decodePixels(dst []Color, data []byte) {
+	size := pr.BytesPerPixel()
+	for i := range dst {
+		dst[i] = pr.pixelToColor(pr.bytesToPixel(data[i*size : (i+1)*size]))
+	}
+}
+
 // ReadPixelData reads raw pixel data without color conversion.
 // Used by encodings that need the raw pixel bytes (like cursor encoding).
 func (pr *PixelReader) ReadPixelData(r io.Reader, size int) ([]uint8, error) {
@@ -87,13 +108,6 @@ func (pr *PixelReader) pixelToColor(rawPixel uint32) Color {
 
 // Convenience functions for backward compatibility and ease of use
 
-// readPixelColor is a convenience function that maintains the existing API.
-// It creates a temporary PixelReader and reads a single pixel color.
-func readPixelColor(r io.Reader, pixelFormat PixelFormat, colorMap [ColorMapSize]Color) (Color, error) {
-	reader := NewPixelReader(pixelFormat, colorMap)
-	return reader.ReadPixelColor(r)
-}
-
 // calculatePixelDataSize calculates the size needed for pixel data.
 func calculatePixelDataSize(width, height uint16, pixelFormat PixelFormat) int {
 	bytesPerPixel := int(pixelFormat.BPP / 8)
@@ -0,0 +1,82 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package vnc
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+
+	"github.com/tenthirtyam/go-vnc/rfb"
+)
+
+// PlainAuth implements the Plain authentication of VeNCrypt (sub-type 256),
+// which sends a username and password, each preceded by its length. QEMU
+// consoles commonly use it behind TLS as the TLSPlain and X509Plain
+// sub-types. It is not a security type of its own: set it as the Plain field
+// of VeNCryptAuth, or run its Handshake from another method wrapping the
+// connection.
+type PlainAuth struct {
+	Username string
+	Password string
+
+	// Credentials, if set, supplies the username and password at handshake
+	// time in place of Username and Password.
+	Credentials CredentialProvider
+
+	logger Logger
+}
+
+// SubType returns the VeNCrypt sub-type identifier for Plain authentication.
+func (p *PlainAuth) SubType() uint32 {
+	return rfb.VeNCryptPlain
+}
+
+// String returns a human-readable description of the authentication method.
+func (p *PlainAuth) String() string {
+	return "Plain"
+}
+
+// SetLogger sets the logger for the authentication method.
+func (p *PlainAuth) SetLogger(logger Logger) {
+	p.logger = logger
+}
+
+// ClearPassword securely clears the password from memory.
+func (p *PlainAuth) ClearPassword() {
+	if p.Password != "" {
+		p.Password = (&SecureMemory{}).ClearString(p.Password)
+	}
+}
+
+// Handshake sends the username and password.
+func (p *PlainAuth) Handshake(ctx context.Context, conn net.Conn) error {
+	select {
+	case <-ctx.Done():
+		return timeoutError("PlainAuth.Handshake", "authentication cancelled", ctx.Err())
+	default:
+	}
+
+	username, password, err := resolveCredentials(ctx, "PlainAuth.Handshake", p.Credentials, p.Username, p.Password, true)
+	if err != nil {
+		return err
+	}
+	sm := &SecureMemory{}
+	defer sm.ClearBytes(password)
+
+	buf := make([]byte, 8, 8+len(username)+len(password))
+	binary.BigEndian.PutUint32(buf, uint32(len(username)))     // #nosec G115 - Credentials are far shorter than 4 GiB
+	binary.BigEndian.PutUint32(buf[4:], uint32(len(password))) // #nosec G115 - Credentials are far shorter than 4 GiB
+	buf = append(buf, username...)
+	buf = append(buf, password...)
+	defer sm.ClearBytes(buf)
+
+	if _, err := conn.Write(buf); err != nil {
+		return networkError("PlainAuth.Handshake", "failed to send credentials", err)
+	}
+	if p.logger != nil {
+		p.logger.Debug("Sent plain credentials", Field{Key: "username_length", Value: len(username)})
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package vnc
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/tenthirtyam/go-vnc/rfb"
+)
+
+func TestPlainAuth_Handshake(t *testing.T) {
+	server, client := net.Pipe()
+	defer func() { _ = client.Close() }()
+
+	type result struct {
+		username, password string
+		err                error
+	}
+	done := make(chan result, 1)
+	go func() {
+		defer func() { _ = server.Close() }()
+		var r result
+		r.username, r.password, r.err = readPlainCredentials(server)
+		done <- r
+	}()
+
+	if err := (&PlainAuth{Username: "qemu", Password: "secret"}).Handshake(context.Background(), client); err != nil {
+		t.Fatalf("Handshake() error = %v", err)
+	}
+	r := <-done
+	if r.err != nil {
+		t.Fatalf("server error = %v", r.err)
+	}
+	if r.username != "qemu" || r.password != "secret" {
+		t.Errorf("credentials = %q/%q, want qemu/secret", r.username, r.password)
+	}
+}
+
+func TestVeNCryptAuth_PlainSubAuth(t *testing.T) {
+	server, client := net.Pipe()
+	defer func() { _ = client.Close() }()
+
+	done := make(chan string, 1)
+	go func() {
+		defer func() { _ = server.Close() }()
+		defer close(done)
+		if _, err := serveVeNCryptNegotiation(server, rfb.VeNCryptPlain); err != nil {
+			return
+		}
+		username, _, err := readPlainCredentials(server)
+		if err == nil {
+			done <- username
+		}
+	}()
+
+	auth := &VeNCryptAuth{
+		Username: "ignored",
+		SubTypes: []uint32{rfb.VeNCryptPlain},
+		Plain:    &PlainAuth{Username: "qemu", Password: "secret"},
+	}
+	if err := auth.Handshake(context.Background(), client); err != nil {
+		t.Fatalf("Handshake() error = %v", err)
+	}
+	if username := <-done; username != "qemu" {
+		t.Errorf("username = %q, want qemu", username)
+	}
+}
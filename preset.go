@@ -0,0 +1,203 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package vnc
+
+import (
+	"slices"
+	"time"
+
+	"github.com/tenthirtyam/go-vnc/rfb"
+)
+
+// ForQEMU returns the settings for the built-in VNC server of QEMU and
+// QEMU-based hypervisors such as Proxmox VE and libvirt. QEMU renders in
+// 32-bit true color, so the client requests it to avoid server-side
+// conversion, prefers Tight where it is compiled in, and enables desktop
+// resizing, client-side cursors, LED state reporting, and extended key
+// events for scancode-level input.
+func ForQEMU() ClientOption {
+	return presetOption(ClientConfig{
+		InitialEncodings: append(compressedEncodings(),
+			&HextileEncoding{},
+			&RawEncoding{},
+			&ExtendedDesktopSizePseudoEncoding{},
+			&DesktopSizePseudoEncoding{},
+			&CursorPseudoEncoding{},
+			&LEDStatePseudoEncoding{},
+			&QEMUExtendedKeyEventPseudoEncoding{},
+		),
+		PixelFormat:        PixelFormat32BitRGBA,
+		SecurityPreference: []uint8{rfb.SecurityVeNCrypt, rfb.SecurityVNCAuth, rfb.SecurityNone},
+	})
+}
+
+// ForTigerVNC returns the settings for TigerVNC and other servers derived from
+// RealVNC 4, such as TurboVNC. These servers support every encoding this
+// package decodes, so the full set is offered in order of efficiency.
+func ForTigerVNC() ClientOption {
+	return presetOption(ClientConfig{
+		InitialEncodings: append(compressedEncodings(),
+			&CopyRectEncoding{},
+			&HextileEncoding{},
+			&RREEncoding{},
+			&RawEncoding{},
+			&ExtendedDesktopSizePseudoEncoding{},
+			&DesktopSizePseudoEncoding{},
+			&AlphaCursorPseudoEncoding{},
+			&CursorPseudoEncoding{},
+			&DesktopNamePseudoEncoding{},
+			&LEDStatePseudoEncoding{},
+			&FencePseudoEncoding{},
+			&ContinuousUpdatesPseudoEncoding{},
+			&ExtendedMouseButtonsPseudoEncoding{},
+			&LastRectPseudoEncoding{},
+		),
+		PixelFormat:        PixelFormat32BitRGBA,
+		SecurityPreference: []uint8{rfb.SecurityVeNCrypt, rfb.SecurityVNCAuth, rfb.SecurityNone},
+	})
+}
+
+// ForMacScreenSharing returns the settings for the Screen Sharing server built
+// into macOS, which serves 32-bit true color. It offers Apple Remote Desktop
+// authentication, which logs in with the username and password of a macOS
+// account, and VNC authentication when "VNC viewers may control screen with
+// password" is enabled. The preset prefers an ARDAuth among the Auth methods
+// to a PasswordAuth, so configuring account credentials uses them.
+func ForMacScreenSharing() ClientOption {
+	return presetOption(ClientConfig{
+		InitialEncodings: []Encoding{
+			&CopyRectEncoding{},
+			&HextileEncoding{},
+			&RawEncoding{},
+			&DesktopSizePseudoEncoding{},
+			&CursorPseudoEncoding{},
+		},
+		PixelFormat:        PixelFormat32BitRGBA,
+		SecurityPreference: []uint8{rfb.SecurityARD, rfb.SecurityVNCAuth},
+	})
+}
+
+// ForBMCKVM returns the settings for the KVM consoles of baseboard management
+// controllers (iDRAC, iLO, Supermicro and other ATEN-based IPMI firmware).
+// These embedded servers are slow to complete the handshake, often reset the
+// connection when offered pseudo-encodings, and are limited to simple
+// encodings, so the preset uses a longer connect timeout, disables
+// pseudo-encodings, and requests 16-bit color to reduce bandwidth. Some send
+// their first updates before the client's pixel format takes effect, which
+// QuirkEarlyServerData absorbs.
+func ForBMCKVM() ClientOption {
+	return presetOption(ClientConfig{
+		InitialEncodings: []Encoding{
+			&HextileEncoding{},
+			&RawEncoding{},
+		},
+		PixelFormat:        PixelFormat16BitRGB565,
+		SecurityPreference: []uint8{rfb.SecurityVNCAuth, rfb.SecurityNone},
+		Quirks:             QuirkNoPseudoEncodings | QuirkEarlyServerData,
+		ConnectTimeout:     30 * time.Second,
+	})
+}
+
+// ForVino returns the settings for Vino, the screen sharing server of GNOME 2
+// and 3, and for gnome-remote-desktop in VNC mode. Vino speaks RFB 3.7, lists
+// its TLS security type (18) ahead of None and VNC authentication, and sends
+// a SecurityResult after the None type, which RFB 3.7 does not have;
+// QuirkEarlyServerData skips it. The preset prefers a TLSAuth among the Auth
+// methods, which needs an AnonymousTLS implementation, and otherwise falls
+// back to the unencrypted types. Both servers send empty cut text as a
+// keep-alive, which QuirkCutTextKeepAlive drops. When Vino is set to ask the
+// desktop user before accepting a connection, the handshake waits for the
+// answer, so the preset uses a longer connect timeout.
+func ForVino() ClientOption {
+	return presetOption(ClientConfig{
+		InitialEncodings: append(compressedEncodings(),
+			&CopyRectEncoding{},
+			&HextileEncoding{},
+			&RREEncoding{},
+			&RawEncoding{},
+			&DesktopSizePseudoEncoding{},
+			&CursorPseudoEncoding{},
+		),
+		PixelFormat:        PixelFormat32BitRGBA,
+		SecurityPreference: []uint8{rfb.SecurityTLS, rfb.SecurityVNCAuth, rfb.SecurityNone},
+		Quirks:             QuirkEarlyServerData | QuirkCutTextKeepAlive,
+		ConnectTimeout:     2 * time.Minute,
+		ProtocolVersion:    "RFB 003.007",
+	})
+}
+
+// WithLowPowerProfile returns the settings for Raspberry Pi-class viewers,
+// such as kiosks and digital signage, that decode on small ARM cores. It
+// requests 8-bit (BGR233) true color when bpp is 8 and 16-bit (RGB565)
+// otherwise, offers only encodings that decode without decompression or
+// filtering, and sets LowPower so the client framebuffer converts pixels with
+// integer shifts alone. Colors are reduced accordingly; use a preset or
+// WithPixelFormat instead where fidelity matters more than decoding cost.
+//
+// Unlike the server presets it leaves the security preference and quirks
+// untouched, so it can follow one of them:
+//
+//	client, err := vnc.ClientWithOptions(ctx, conn,
+//		vnc.ForQEMU(),
+//		vnc.WithLowPowerProfile(16),
+//		vnc.WithAuth(vnc.NewPasswordAuth("secret")),
+//	)
+func WithLowPowerProfile(bpp uint8) ClientOption {
+	pixelFormat := PixelFormat16BitRGB565
+	if bpp == 8 {
+		pixelFormat = PixelFormat8BitBGR233
+	}
+
+	return func(cfg *ClientConfig) {
+		cfg.InitialEncodings = []Encoding{
+			&CopyRectEncoding{},
+			&HextileEncoding{},
+			&RREEncoding{},
+			&RawEncoding{},
+			&DesktopSizePseudoEncoding{},
+		}
+		cfg.PixelFormat = pixelFormat
+		cfg.LowPower = true
+	}
+}
+
+// presetOption returns an option that applies the preset settings in preset.
+func presetOption(preset ClientConfig) ClientOption {
+	return func(cfg *ClientConfig) {
+		cfg.InitialEncodings = slices.Clone(preset.InitialEncodings)
+		cfg.PixelFormat = preset.PixelFormat
+		cfg.SecurityPreference = preset.SecurityPreference
+		cfg.Quirks |= preset.Quirks
+		if preset.ConnectTimeout > 0 {
+			cfg.ConnectTimeout = preset.ConnectTimeout
+		}
+		if preset.ProtocolVersion != "" {
+			cfg.ProtocolVersion = preset.ProtocolVersion
+		}
+	}
+}
+
+// orderedAuth returns the configured Auth methods ranked by the configured
+// SecurityPreference.
+func (c *ClientConn) orderedAuth() []ClientAuth {
+	if c.config == nil {
+		return nil
+	}
+	if len(c.config.SecurityPreference) == 0 {
+		return c.config.Auth
+	}
+
+	rank := func(auth ClientAuth) int {
+		if i := slices.Index(c.config.SecurityPreference, auth.SecurityType()); i >= 0 {
+			return i
+		}
+		return len(c.config.SecurityPreference)
+	}
+
+	ordered := slices.Clone(c.config.Auth)
+	slices.SortStableFunc(ordered, func(a, b ClientAuth) int {
+		return rank(a) - rank(b)
+	})
+	return ordered
+}
@@ -0,0 +1,179 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package vnc
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/tenthirtyam/go-vnc/rfb"
+)
+
+func TestPreset_Options(t *testing.T) {
+	presets := map[string]ClientOption{
+		"QEMU":               ForQEMU(),
+		"TigerVNC":           ForTigerVNC(),
+		"Mac Screen Sharing": ForMacScreenSharing(),
+		"BMC KVM":            ForBMCKVM(),
+		"Vino":               ForVino(),
+	}
+
+	for name, preset := range presets {
+		t.Run(name, func(t *testing.T) {
+			cfg := &ClientConfig{}
+			preset(cfg)
+
+			if len(cfg.InitialEncodings) == 0 || cfg.PixelFormat == nil || len(cfg.SecurityPreference) == 0 {
+				t.Fatalf("preset left settings unset: %+v", cfg)
+			}
+			if err := cfg.PixelFormat.Validate(); err != nil {
+				t.Errorf("preset pixel format is invalid: %v", err)
+			}
+			validator := newInputValidator()
+			for _, enc := range cfg.InitialEncodings {
+				if err := validator.ValidateEncodingType(enc.Type()); err != nil {
+					t.Errorf("preset encoding %d is invalid: %v", enc.Type(), err)
+				}
+			}
+		})
+	}
+
+	t.Run("Override", func(t *testing.T) {
+		cfg := &ClientConfig{}
+		for _, option := range []ClientOption{ForBMCKVM(), WithConnectTimeout(time.Second), WithInitialEncodings(&RawEncoding{})} {
+			option(cfg)
+		}
+		if cfg.ConnectTimeout != time.Second || len(cfg.InitialEncodings) != 1 {
+			t.Errorf("later options did not override the preset: %+v", cfg)
+		}
+		if !cfg.Quirks.Has(QuirkNoPseudoEncodings) {
+			t.Error("preset quirks were lost")
+		}
+	})
+}
+
+func TestPreset_LowPowerProfile(t *testing.T) {
+	for bpp, want := range map[uint8]*PixelFormat{8: PixelFormat8BitBGR233, 16: PixelFormat16BitRGB565, 32: PixelFormat16BitRGB565} {
+		cfg := &ClientConfig{}
+		for _, option := range []ClientOption{ForQEMU(), WithLowPowerProfile(bpp)} {
+			option(cfg)
+		}
+
+		if cfg.PixelFormat != want || !cfg.LowPower {
+			t.Errorf("WithLowPowerProfile(%d): PixelFormat = %+v, LowPower = %v", bpp, cfg.PixelFormat, cfg.LowPower)
+		}
+		if err := cfg.PixelFormat.Validate(); err != nil {
+			t.Errorf("WithLowPowerProfile(%d) pixel format is invalid: %v", bpp, err)
+		}
+		if len(cfg.SecurityPreference) == 0 {
+			t.Error("WithLowPowerProfile cleared the security preference of the preset")
+		}
+		for _, enc := range cfg.InitialEncodings {
+			if _, ok := enc.(*CursorPseudoEncoding); ok {
+				t.Error("WithLowPowerProfile offers the cursor pseudo-encoding")
+			}
+		}
+	}
+}
+
+func TestPreset_SecurityPreference(t *testing.T) {
+	none, password := &ClientAuthNone{}, NewPasswordAuth("secret")
+	conn := &ClientConn{config: &ClientConfig{
+		Auth:               []ClientAuth{none, password},
+		SecurityPreference: []uint8{rfb.SecurityVNCAuth},
+	}}
+
+	if got, want := conn.orderedAuth(), []ClientAuth{password, none}; !reflect.DeepEqual(got, want) {
+		t.Errorf("orderedAuth() = %v, want %v", got, want)
+	}
+	if conn.config.Auth[0] != none {
+		t.Error("orderedAuth modified the configured Auth slice")
+	}
+}
+
+func TestPreset_MacScreenSharingPrefersARD(t *testing.T) {
+	password, ard := NewPasswordAuth("secret"), &ARDAuth{Username: "admin", Password: "secret"}
+	cfg := &ClientConfig{Auth: []ClientAuth{password, ard}}
+	ForMacScreenSharing()(cfg)
+
+	conn := &ClientConn{config: cfg}
+	if got, want := conn.orderedAuth(), []ClientAuth{ard, password}; !reflect.DeepEqual(got, want) {
+		t.Errorf("orderedAuth() = %v, want %v", got, want)
+	}
+}
+
+func TestPreset_VinoPrefersTLS(t *testing.T) {
+	password, tlsAuth := NewPasswordAuth("secret"), &TLSAuth{Auth: []ClientAuth{NewPasswordAuth("secret")}}
+	cfg := &ClientConfig{Auth: []ClientAuth{password, tlsAuth}}
+	ForVino()(cfg)
+
+	conn := &ClientConn{config: cfg}
+	if got, want := conn.orderedAuth(), []ClientAuth{tlsAuth, password}; !reflect.DeepEqual(got, want) {
+		t.Errorf("orderedAuth() = %v, want %v", got, want)
+	}
+}
+
+func TestPreset_InitialSettings(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+
+	type initial struct {
+		format    rfb.PixelFormat
+		encodings []int32
+		err       error
+	}
+	received := make(chan initial, 1)
+
+	go func() {
+		_, _ = serverConn.Write(replayHandshake(4, 4, "preset"))
+	}()
+	go func() {
+		var got initial
+		defer func() { received <- got }()
+
+		if _, _, got.err = rfb.ReadProtocolVersion(serverConn); got.err != nil {
+			return
+		}
+		if _, got.err = rfb.ReadSecurityType(serverConn); got.err != nil {
+			return
+		}
+		if _, got.err = rfb.ReadClientInit(serverConn); got.err != nil {
+			return
+		}
+		if _, got.err = rfb.ReadMessageType(serverConn); got.err != nil {
+			return
+		}
+		if got.format, got.err = rfb.ReadSetPixelFormat(serverConn); got.err != nil {
+			return
+		}
+		if _, got.err = rfb.ReadMessageType(serverConn); got.err != nil {
+			return
+		}
+		got.encodings, got.err = rfb.ReadSetEncodings(serverConn)
+	}()
+
+	conn, err := ClientWithOptions(context.Background(), clientConn,
+		ForBMCKVM(), WithAuth(&ClientAuthNone{}), WithManualPump(true))
+	if err != nil {
+		t.Fatalf("handshake failed: %v", err)
+	}
+	defer func() { _ = conn.CloseAndWait() }()
+
+	got := <-received
+	if got.err != nil {
+		t.Fatalf("server failed to read initial settings: %v", got.err)
+	}
+	if got.format != wirePixelFormat(PixelFormat16BitRGB565) {
+		t.Errorf("requested pixel format = %+v, want RGB565", got.format)
+	}
+	if want := []int32{5, 0}; !reflect.DeepEqual(got.encodings, want) {
+		t.Errorf("requested encodings = %v, want %v", got.encodings, want)
+	}
+	if pf := conn.GetPixelFormat(); pf != *PixelFormat16BitRGB565 {
+		t.Errorf("GetPixelFormat() = %+v, want RGB565", pf)
+	}
+}
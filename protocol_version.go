@@ -0,0 +1,104 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package vnc
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/tenthirtyam/go-vnc/rfb"
+)
+
+// WithProtocolVersion makes the client negotiate the given protocol version,
+// written as in the ProtocolVersion message: "RFB 003.003", "RFB 003.007", or
+// "RFB 003.008", with or without the trailing newline. It lets tests exercise
+// the RFB 3.3 and 3.7 handshakes against servers that also speak 3.8, and
+// works around servers that misbehave on 3.8. The handshake fails if the
+// server's version is older than the requested one.
+//
+// Without this option the client speaks RFB 3.8 and refuses older servers.
+func WithProtocolVersion(version string) ClientOption {
+	return func(cfg *ClientConfig) {
+		cfg.ProtocolVersion = version
+	}
+}
+
+// ProtocolVersion returns the protocol version negotiated with the server.
+func (c *ClientConn) ProtocolVersion() (major, minor uint) {
+	return 3, c.protocolMinor
+}
+
+// requestedProtocolMinor returns the minor version of RFB 3 configured with
+// WithProtocolVersion, or 0 if none is configured.
+func (c *ClientConn) requestedProtocolMinor() (uint, error) {
+	if c.config == nil || c.config.ProtocolVersion == "" {
+		return 0, nil
+	}
+
+	version := c.config.ProtocolVersion
+	if !strings.HasSuffix(version, "\n") {
+		version += "\n"
+	}
+	major, minor, err := rfb.ParseProtocolVersion([]byte(version))
+	if err != nil {
+		return 0, configurationError("handshake", "invalid protocol version", err)
+	}
+	if major != 3 || (minor != 3 && minor != 7 && minor != 8) {
+		return 0, configurationError("handshake",
+			fmt.Sprintf("unsupported protocol version %d.%d; use 3.3, 3.7, or 3.8", major, minor), nil)
+	}
+	return minor, nil
+}
+
+// negotiateProtocolMinor returns the minor version of RFB 3 the client speaks
+// with a server offering serverMajor.serverMinor.
+func (c *ClientConn) negotiateProtocolMinor(serverMajor, serverMinor uint) (uint, error) {
+	if serverMajor < 3 {
+		c.logger.Error("Unsupported major version", Field{Key: "version", Value: serverMajor})
+		return 0, unsupportedError("handshake", fmt.Sprintf("unsupported major version, less than 3: %d", serverMajor), nil)
+	}
+
+	requested, err := c.requestedProtocolMinor()
+	if err != nil {
+		return 0, err
+	}
+	if requested == 0 {
+		if serverMinor < 8 {
+			c.logger.Error("Unsupported minor version", Field{Key: "version", Value: serverMinor})
+			return 0, unsupportedError("handshake", fmt.Sprintf("unsupported minor version, less than 8: %d", serverMinor), nil)
+		}
+		return 8, nil
+	}
+
+	// Clients may not speak a newer version than the server offers.
+	if serverMajor == 3 && serverMinor < requested {
+		c.logger.Error("Server version older than the requested version",
+			Field{Key: "version", Value: serverMinor},
+			Field{Key: "requested", Value: requested})
+		return 0, unsupportedError("handshake",
+			fmt.Sprintf("server offers RFB 3.%d, older than the requested 3.%d", serverMinor, requested), nil)
+	}
+	return requested, nil
+}
+
+// readRFB33SecurityType reads the security type an RFB 3.3 server selected.
+// Type 0 is followed by the reason for refusing the connection.
+func (c *ClientConn) readRFB33SecurityType(ctx context.Context) (uint8, error) {
+	var securityType uint32
+	if err := c.readBinaryWithContext(ctx, &securityType); err != nil {
+		c.logger.Error("Failed to read security type", Field{Key: "error", Value: err})
+		return 0, networkError("handshake", "failed to read security type", err)
+	}
+
+	switch {
+	case securityType == uint32(rfb.SecurityInvalid):
+		reason := c.readErrorReason()
+		c.logger.Error("Server refused the connection", Field{Key: "reason", Value: reason})
+		return 0, authenticationError("handshake", fmt.Sprintf("no security types available: %s", reason), nil)
+	case securityType > 0xff:
+		return 0, protocolError("handshake", fmt.Sprintf("server selected invalid security type %d", securityType), nil)
+	}
+	return uint8(securityType), nil // #nosec G115 - checked above
+}
@@ -0,0 +1,37 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package vnc
+
+import (
+	"context"
+	"net"
+	"testing"
+)
+
+func TestProtocolVersion_Refused(t *testing.T) {
+	tests := []struct {
+		name      string
+		server    string
+		requested string
+		wantErr   ErrorCode
+	}{
+		{name: "OlderServer", server: "003.003", requested: "RFB 003.007", wantErr: ErrUnsupported},
+		{name: "UnknownVersion", server: "003.008", requested: "RFB 003.005", wantErr: ErrConfiguration},
+		{name: "Malformed", server: "003.008", requested: "3.3", wantErr: ErrConfiguration},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nc, err := net.Dial("tcp", newMockServer(t, tt.server))
+			if err != nil {
+				t.Fatalf("error connecting to mock server: %s", err)
+			}
+
+			_, err = ClientWithOptions(context.Background(), nc, WithProtocolVersion(tt.requested))
+			if !IsVNCError(err, tt.wantErr) {
+				t.Fatalf("ClientWithOptions = %v, want error code %v", err, tt.wantErr)
+			}
+		})
+	}
+}
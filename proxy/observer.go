@@ -0,0 +1,132 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+//go:build !vnc_minimal
+
+package proxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"slices"
+
+	"github.com/tenthirtyam/go-vnc"
+	"github.com/tenthirtyam/go-vnc/rfb"
+	"github.com/tenthirtyam/go-vnc/server"
+)
+
+// ServeObserver lets a viewer silently watch the session recorded by obs, as
+// a supervisor joining a help-desk session in progress does: the viewer is
+// accepted like any other, with ViewerAuth and Authorizer, but is served
+// the recorded server messages, from the last full update on, instead of a
+// connection to the upstream server, so neither the server nor the people in
+// the session see another client. Its input is dropped as for view-only
+// viewers, and since the messages keep the pixel format and encodings of the
+// recorded session, its SetPixelFormat and SetEncodings are ignored too.
+//
+// The recording must be started with vnc.WithObservers. ServeObserver returns
+// when the viewer disconnects, the recording stops, or ctx ends; conn and obs
+// are closed when it returns.
+//
+// Example usage:
+//
+//	rec, err := client.StartRecording(sink, vnc.WithObservers(16<<20))
+//	...
+//	obs, err := rec.Observe()
+//	if err != nil {
+//		return err
+//	}
+//	err = p.ServeObserver(ctx, supervisorConn, obs)
+func (p *Proxy) ServeObserver(ctx context.Context, conn net.Conn, obs *vnc.RecordingObserver) error {
+	defer func() { _ = conn.Close() }()
+	defer obs.Close()
+
+	timeout := p.HandshakeTimeout
+	if timeout <= 0 {
+		timeout = DefaultHandshakeTimeout
+	}
+	hsCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	viewer, err := server.Accept(hsCtx, conn, &server.Config{
+		ServerInit: rfb.ServerInit{
+			Width:       obs.Width,
+			Height:      obs.Height,
+			PixelFormat: rfb.PixelFormat(obs.PixelFormat),
+			Name:        obs.DesktopName,
+		},
+		Auth:             p.ViewerAuth,
+		Authorizer:       p.Authorizer,
+		HandshakeTimeout: timeout,
+		Logger:           p.Logger,
+	})
+	if err != nil {
+		return err
+	}
+
+	s := p.startSession(viewer)
+	s.access = min(s.access, server.AccessViewOnly)
+	s.observer = true
+	defer p.endSession(s)
+
+	obsCtx, cancelObs := context.WithCancel(ctx)
+	defer cancelObs()
+	stop := context.AfterFunc(obsCtx, func() { _ = conn.Close() })
+	defer stop()
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- p.discardViewer(s, conn)
+		cancelObs()
+	}()
+
+	for {
+		var msg []byte
+		if msg, err = obs.Next(obsCtx); err == nil {
+			_, err = conn.Write(msg)
+		}
+		if err != nil {
+			break
+		}
+	}
+	cancelObs()
+	viewerErr := <-errc
+
+	switch {
+	case ctx.Err() != nil:
+		return ctx.Err()
+	case errors.Is(err, io.EOF):
+		// The recording stopped.
+		return nil
+	case errors.Is(err, context.Canceled):
+		// The viewer disconnected.
+		err = viewerErr
+	}
+	if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+		return nil
+	}
+	return err
+}
+
+// discardViewer reads the messages of an observer until its connection
+// fails, accounting them and reporting its input as rejected.
+func (p *Proxy) discardViewer(s *session, viewer io.Reader) error {
+	extendedPointer := false
+	for {
+		msg, err := readViewerMessage(viewer, extendedPointer, p.Quota.MaxMessageSize)
+		if err != nil {
+			return err
+		}
+		if msg.msgType == rfb.SetEncodingsMsg && msg.raw != nil {
+			extendedPointer = slices.Contains(msg.encodings, rfb.PseudoEncodingExtendedMouseButtons)
+		}
+
+		input := isInput(msg.msgType)
+		s.account(msg.msgType, msg.size, input)
+		if input {
+			p.reject(s, msg, RejectViewOnly)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+//go:build !vnc_minimal
+
+package proxy
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/tenthirtyam/go-vnc"
+	"github.com/tenthirtyam/go-vnc/server"
+	"github.com/tenthirtyam/go-vnc/vnctest"
+)
+
+// discardSegment is a recording segment that drops its data.
+type discardSegment struct{ io.Writer }
+
+func (discardSegment) Close() error { return nil }
+
+func TestProxy_ServeObserver(t *testing.T) {
+	srv := vnctest.NewServer(8, 6)
+	t.Cleanup(srv.Close)
+	client, _ := srv.Client(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rec, err := client.StartRecording(vnc.RecordingSinkFunc(func(vnc.SegmentInfo) (io.WriteCloser, error) {
+		return discardSegment{io.Discard}, nil
+	}), vnc.WithObservers(1<<20))
+	if err != nil {
+		t.Fatalf("StartRecording failed: %v", err)
+	}
+	if _, err := client.Screenshot(ctx); err != nil {
+		t.Fatalf("Screenshot failed: %v", err)
+	}
+	obs, err := rec.Observe()
+	if err != nil {
+		t.Fatalf("Observe failed: %v", err)
+	}
+
+	rejections := make(chan Rejection, 4)
+	p := &Proxy{OnReject: func(r Rejection) { rejections <- r }}
+	viewerConn, proxyConn := net.Pipe()
+	served := make(chan error, 1)
+	go func() {
+		served <- p.ServeObserver(ctx, proxyConn, obs)
+	}()
+
+	messages := make(chan vnc.ServerMessage, 16)
+	supervisor, err := vnc.ClientWithOptions(ctx, viewerConn,
+		vnc.WithAuth(&vnc.ClientAuthNone{}),
+		vnc.WithServerMessageChannel(messages))
+	if err != nil {
+		t.Fatalf("observer handshake failed: %v", err)
+	}
+	defer func() { _ = supervisor.Close() }()
+	if w, h := supervisor.GetFrameBufferSize(); w != 8 || h != 6 {
+		t.Errorf("observer desktop = %dx%d, want 8x6", w, h)
+	}
+
+	select {
+	case msg := <-messages:
+		if _, ok := msg.(*vnc.FramebufferUpdateMessage); !ok {
+			t.Errorf("first message = %T, want a framebuffer update", msg)
+		}
+	case <-ctx.Done():
+		t.Fatal("timed out waiting for the backlog")
+	}
+
+	sessions := p.Sessions()
+	if len(sessions) != 1 || !sessions[0].Observer || sessions[0].Access != server.AccessViewOnly {
+		t.Fatalf("sessions = %+v, want one view-only observer", sessions)
+	}
+
+	if err := supervisor.KeyEvent('a', true); err != nil {
+		t.Fatalf("KeyEvent failed: %v", err)
+	}
+	select {
+	case r := <-rejections:
+		if r.Reason != RejectViewOnly {
+			t.Errorf("rejection reason = %s, want %s", r.Reason, RejectViewOnly)
+		}
+	case <-ctx.Done():
+		t.Fatal("timed out waiting for the input to be rejected")
+	}
+
+	if err := rec.Stop(); err != nil {
+		t.Fatalf("Stop failed: %v", err)
+	}
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Errorf("ServeObserver() error = %v after the recording stopped, want nil", err)
+		}
+	case <-ctx.Done():
+		t.Fatal("ServeObserver did not return after the recording stopped")
+	}
+}
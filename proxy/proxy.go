@@ -0,0 +1,420 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+// Package proxy relays VNC viewers to an upstream VNC server.
+//
+// The proxy terminates the RFB handshake on both sides: it accepts each viewer
+// with the server package, which authenticates and authorizes it, and only
+// then authenticates to the upstream server with the configured vnc.ClientAuth
+// methods, presents the upstream ServerInit to the viewer, and relays the
+// session. Messages
+// from the server are copied unchanged. Messages from a viewer are parsed one
+// at a time so that they can be accounted and limited individually, which
+// keeps a misbehaving viewer from degrading the desktop it shares with others
+// (see Quota).
+//
+// ServeObserver serves a viewer from a recording started with
+// vnc.WithObservers instead of the upstream server, so that it watches the
+// session without joining it.
+//
+// Example usage:
+//
+//	p := &proxy.Proxy{
+//		Dial: func(ctx context.Context) (net.Conn, error) {
+//			var d net.Dialer
+//			return d.DialContext(ctx, "tcp", "desktop:5900")
+//		},
+//		Auth:  []vnc.ClientAuth{vnc.NewPasswordAuth("secret")},
+//		Quota: proxy.Quota{EventsPerSecond: 200, MaxMessageSize: 64 << 10},
+//		OnReject: func(r proxy.Rejection) {
+//			log.Printf("dropped message from %s: %s", r.RemoteAddr, r.Reason)
+//		},
+//	}
+//	err := p.Serve(ctx, listener)
+package proxy
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/tenthirtyam/go-vnc"
+	"github.com/tenthirtyam/go-vnc/rfb"
+	"github.com/tenthirtyam/go-vnc/server"
+)
+
+// DefaultHandshakeTimeout bounds the upstream and viewer handshakes when
+// Proxy.HandshakeTimeout is zero.
+const DefaultHandshakeTimeout = 30 * time.Second
+
+// Proxy relays VNC viewers to an upstream server. The zero value is not
+// usable; Dial must be set. A Proxy must not be copied after first use.
+type Proxy struct {
+	// Dial opens a connection to the upstream server. It is called once for
+	// every viewer that has been authenticated and granted access.
+	Dial func(ctx context.Context) (net.Conn, error)
+
+	// Auth lists the methods used to authenticate to the upstream server, in
+	// order of preference. With no methods only None is attempted.
+	Auth []vnc.ClientAuth
+
+	// ViewerAuth lists the security types offered to viewers, in order of
+	// preference. With none, viewers are admitted with None.
+	ViewerAuth []server.Authenticator
+
+	// Authorizer, if set, decides on the access of each authenticated
+	// viewer. Viewers granted view-only access cannot send input upstream.
+	Authorizer server.Authorizer
+
+	// Quota limits the messages each viewer may send upstream.
+	Quota Quota
+
+	// OnReject, if set, is called for every viewer message the proxy drops.
+	// It runs on the viewer's relay goroutine and must not block.
+	OnReject func(Rejection)
+
+	// HandshakeTimeout bounds the upstream and viewer handshakes. Zero uses
+	// DefaultHandshakeTimeout.
+	HandshakeTimeout time.Duration
+
+	// Logger receives diagnostics. Nil disables logging.
+	Logger vnc.Logger
+
+	mu       sync.Mutex
+	sessions map[*session]struct{}
+}
+
+// SessionInfo describes a viewer connected through the proxy.
+type SessionInfo struct {
+	// RemoteAddr is the address of the viewer.
+	RemoteAddr net.Addr
+
+	// Identity is who the viewer authenticated as.
+	Identity server.Identity
+
+	// Access is the access granted to the viewer.
+	Access server.Access
+
+	// Started is when the viewer completed the handshake.
+	Started time.Time
+
+	// Observer reports whether the viewer watches a recording with
+	// ServeObserver rather than being relayed to the upstream server.
+	Observer bool
+
+	// Messages accounts the messages received from the viewer by client
+	// message type, including rejected ones.
+	Messages map[uint8]MessageStats
+
+	// Rejected is the number of messages the proxy dropped.
+	Rejected uint64
+}
+
+// MessageStats accounts the messages of one type.
+type MessageStats struct {
+	// Count is the number of messages.
+	Count uint64
+
+	// Bytes is their total size on the wire, including the type byte.
+	Bytes uint64
+}
+
+// Serve accepts viewers from l and relays each of them until ctx ends or l
+// fails. It closes l and waits for all relayed sessions before returning.
+func (p *Proxy) Serve(ctx context.Context, l net.Listener) error {
+	stop := context.AfterFunc(ctx, func() { _ = l.Close() })
+	defer stop()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		wg.Go(func() {
+			if err := p.ServeConn(ctx, conn); err != nil {
+				p.logger().Debug("proxy session ended",
+					vnc.Field{Key: "remote_addr", Value: conn.RemoteAddr()},
+					vnc.Field{Key: "error", Value: err})
+			}
+		})
+	}
+}
+
+// ServeConn relays a single viewer connection until either side closes it or
+// ctx ends. The connection is closed when ServeConn returns.
+func (p *Proxy) ServeConn(ctx context.Context, conn net.Conn) error {
+	defer func() { _ = conn.Close() }()
+	if p.Dial == nil {
+		return errors.New("proxy: Dial is not set")
+	}
+
+	timeout := p.HandshakeTimeout
+	if timeout <= 0 {
+		timeout = DefaultHandshakeTimeout
+	}
+	hsCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	// The upstream server is only connected to for viewers that have been
+	// authenticated and granted access.
+	var upstream net.Conn
+	var upstreamErr error
+	viewer, err := server.Accept(hsCtx, conn, &server.Config{
+		Init: func(ctx context.Context) (rfb.ServerInit, error) {
+			var init rfb.ServerInit
+			if upstream, init, upstreamErr = p.connectUpstream(ctx); upstreamErr != nil {
+				p.logger().Warn("proxy refused viewer: upstream unavailable",
+					vnc.Field{Key: "remote_addr", Value: conn.RemoteAddr()},
+					vnc.Field{Key: "error", Value: upstreamErr})
+				return rfb.ServerInit{}, errors.New("upstream server unavailable")
+			}
+			return init, nil
+		},
+		Auth:             p.ViewerAuth,
+		Authorizer:       p.Authorizer,
+		HandshakeTimeout: timeout,
+		Logger:           p.Logger,
+	})
+	if upstream != nil {
+		defer func() { _ = upstream.Close() }()
+	}
+	if upstreamErr != nil {
+		return upstreamErr
+	}
+	if err != nil {
+		return err
+	}
+
+	s := p.startSession(viewer)
+	defer p.endSession(s)
+
+	stop := context.AfterFunc(ctx, func() {
+		_ = conn.Close()
+		_ = upstream.Close()
+	})
+	defer stop()
+
+	errc := make(chan error, 2)
+	go func() {
+		_, err := io.Copy(conn, upstream)
+		errc <- err
+	}()
+	go func() {
+		errc <- p.relayViewer(ctx, s, conn, upstream)
+	}()
+
+	err = <-errc
+	_ = conn.Close()
+	_ = upstream.Close()
+	<-errc
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+		return nil
+	}
+	return err
+}
+
+// Sessions returns the viewers currently relayed, oldest first.
+func (p *Proxy) Sessions() []SessionInfo {
+	p.mu.Lock()
+	sessions := slices.Collect(maps.Keys(p.sessions))
+	p.mu.Unlock()
+
+	infos := make([]SessionInfo, 0, len(sessions))
+	for _, s := range sessions {
+		infos = append(infos, s.info())
+	}
+	slices.SortFunc(infos, func(a, b SessionInfo) int { return a.Started.Compare(b.Started) })
+	return infos
+}
+
+// logger returns the configured logger or a no-op logger.
+func (p *Proxy) logger() vnc.Logger {
+	if p.Logger == nil {
+		return &vnc.NoOpLogger{}
+	}
+	return p.Logger
+}
+
+// startSession registers a viewer that completed the handshake.
+func (p *Proxy) startSession(viewer *server.Conn) *session {
+	s := &session{
+		remote:   viewer.RemoteAddr(),
+		identity: viewer.Identity(),
+		access:   viewer.Access(),
+		started:  time.Now(),
+		quota:    newQuotaState(p.Quota),
+		messages: make(map[uint8]MessageStats),
+	}
+	p.mu.Lock()
+	if p.sessions == nil {
+		p.sessions = make(map[*session]struct{})
+	}
+	p.sessions[s] = struct{}{}
+	p.mu.Unlock()
+	return s
+}
+
+// endSession unregisters a viewer.
+func (p *Proxy) endSession(s *session) {
+	p.mu.Lock()
+	delete(p.sessions, s)
+	p.mu.Unlock()
+}
+
+// connectUpstream dials the upstream server and completes the client side of
+// the handshake as a shared client.
+func (p *Proxy) connectUpstream(ctx context.Context) (net.Conn, rfb.ServerInit, error) {
+	conn, err := p.Dial(ctx)
+	if err != nil {
+		return nil, rfb.ServerInit{}, fmt.Errorf("proxy: dial upstream: %w", err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	conn, init, err := p.upstreamHandshake(ctx, conn)
+	if err != nil {
+		_ = conn.Close()
+		return nil, rfb.ServerInit{}, fmt.Errorf("proxy: upstream handshake: %w", err)
+	}
+	_ = conn.SetDeadline(time.Time{})
+	return conn, init, nil
+}
+
+// upstreamHandshake negotiates RFB 3.3, 3.7, or 3.8 with the upstream server
+// and returns the connection the session continues on, which authentication
+// methods such as vnc.VeNCryptAuth wrap in TLS. The connection is returned
+// even on failure so that it can be closed.
+func (p *Proxy) upstreamHandshake(ctx context.Context, conn net.Conn) (net.Conn, rfb.ServerInit, error) {
+	major, minor, err := rfb.ReadProtocolVersion(conn)
+	if err != nil {
+		return conn, rfb.ServerInit{}, err
+	}
+	switch {
+	case major != 3 || minor < 3:
+		return conn, rfb.ServerInit{}, fmt.Errorf("unsupported protocol version %d.%d", major, minor)
+	case minor >= 8:
+		minor = 8
+	case minor < 7:
+		minor = 3
+	}
+	if err := rfb.WriteProtocolVersion(conn, 3, minor); err != nil {
+		return conn, rfb.ServerInit{}, err
+	}
+
+	var types []uint8
+	if minor >= 7 {
+		if types, err = rfb.ReadSecurityTypes(conn); err != nil {
+			return conn, rfb.ServerInit{}, err
+		}
+	} else {
+		var buf [4]byte
+		if _, err := io.ReadFull(conn, buf[:]); err != nil {
+			return conn, rfb.ServerInit{}, err
+		}
+		securityType := binary.BigEndian.Uint32(buf[:])
+		if securityType == 0 {
+			reason, err := rfb.ReadReason(conn)
+			if err != nil {
+				return conn, rfb.ServerInit{}, err
+			}
+			return conn, rfb.ServerInit{}, &rfb.FailureError{Op: "security negotiation", Reason: reason}
+		}
+		types = []uint8{uint8(securityType)} // #nosec G115 - RFB 3.3 security types fit in a byte
+	}
+
+	methods := p.Auth
+	if len(methods) == 0 {
+		methods = []vnc.ClientAuth{&vnc.ClientAuthNone{}}
+	}
+	i := slices.IndexFunc(methods, func(auth vnc.ClientAuth) bool {
+		return slices.Contains(types, auth.SecurityType())
+	})
+	if i < 0 {
+		return conn, rfb.ServerInit{}, fmt.Errorf("no configured authentication method among security types %v", types)
+	}
+	auth := methods[i]
+
+	if minor >= 7 {
+		if err := rfb.WriteSecurityType(conn, auth.SecurityType()); err != nil {
+			return conn, rfb.ServerInit{}, err
+		}
+	}
+	conn, err = vnc.AuthHandshake(ctx, auth, conn)
+	if err != nil {
+		return conn, rfb.ServerInit{}, err
+	}
+	if minor >= 8 || auth.SecurityType() != rfb.SecurityNone {
+		if err := rfb.ReadSecurityResult(conn); err != nil {
+			return conn, rfb.ServerInit{}, err
+		}
+	}
+
+	if err := rfb.WriteClientInit(conn, true); err != nil {
+		return conn, rfb.ServerInit{}, err
+	}
+	init, err := rfb.ReadServerInit(conn)
+	return conn, init, err
+}
+
+// session is the state of one relayed viewer.
+type session struct {
+	remote   net.Addr
+	identity server.Identity
+	access   server.Access
+	started  time.Time
+	observer bool
+
+	// quota is only used by the relay goroutine.
+	quota *quotaState
+
+	mu       sync.Mutex
+	messages map[uint8]MessageStats
+	rejected uint64
+}
+
+// account records a message received from the viewer.
+func (s *session) account(msgType uint8, size int, rejected bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := s.messages[msgType]
+	stats.Count++
+	stats.Bytes += uint64(size) // #nosec G115 - sizes are never negative
+	s.messages[msgType] = stats
+	if rejected {
+		s.rejected++
+	}
+}
+
+// info returns a snapshot of the session.
+func (s *session) info() SessionInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return SessionInfo{
+		RemoteAddr: s.remote,
+		Identity:   s.identity,
+		Access:     s.access,
+		Started:    s.started,
+		Observer:   s.observer,
+		Messages:   maps.Clone(s.messages),
+		Rejected:   s.rejected,
+	}
+}
@@ -0,0 +1,497 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tenthirtyam/go-vnc"
+	"github.com/tenthirtyam/go-vnc/rfb"
+	"github.com/tenthirtyam/go-vnc/server"
+)
+
+// testServerInit is the ServerInit of the fake upstream server.
+var testServerInit = rfb.ServerInit{
+	Width:  64,
+	Height: 48,
+	PixelFormat: rfb.PixelFormat{
+		BPP: 32, Depth: 24, TrueColor: true,
+		RedMax: 255, GreenMax: 255, BlueMax: 255,
+		RedShift: 16, GreenShift: 8,
+	},
+	Name: "upstream",
+}
+
+// proxyHarness connects a raw viewer through a Proxy to a fake upstream
+// server that records the messages it receives.
+type proxyHarness struct {
+	proxy    *Proxy
+	viewer   net.Conn
+	upstream net.Conn
+	received chan viewerMessage
+
+	mu         sync.Mutex
+	rejections []Rejection
+}
+
+// newProxyHarness starts a proxy with quota, applies configure to it, and
+// completes both handshakes.
+func newProxyHarness(t *testing.T, quota Quota, configure ...func(*Proxy)) *proxyHarness {
+	t.Helper()
+
+	h := &proxyHarness{received: make(chan viewerMessage, 64)}
+	upstreams := make(chan net.Conn, 1)
+	h.proxy = &Proxy{
+		Dial: func(context.Context) (net.Conn, error) {
+			serverConn, clientConn := net.Pipe()
+			go func() {
+				if serveUpstreamHandshake(serverConn) == nil {
+					upstreams <- serverConn
+				}
+			}()
+			return clientConn, nil
+		},
+		Quota: quota,
+		OnReject: func(r Rejection) {
+			h.mu.Lock()
+			h.rejections = append(h.rejections, r)
+			h.mu.Unlock()
+		},
+	}
+	for _, f := range configure {
+		f(h.proxy)
+	}
+
+	viewerConn, proxyConn := net.Pipe()
+	h.viewer = viewerConn
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = h.proxy.ServeConn(ctx, proxyConn)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		_ = viewerConn.Close()
+		<-done
+	})
+
+	if err := viewerHandshake(viewerConn); err != nil {
+		t.Fatalf("viewer handshake: %v", err)
+	}
+	h.upstream = <-upstreams
+	go func() {
+		for {
+			msg, err := readViewerMessage(h.upstream, false, 0)
+			if err != nil {
+				close(h.received)
+				return
+			}
+			h.received <- msg
+		}
+	}()
+	return h
+}
+
+// serveUpstreamHandshake performs the server side of an RFB 3.8 handshake
+// with None security.
+func serveUpstreamHandshake(conn net.Conn) error {
+	if err := rfb.WriteProtocolVersion(conn, 3, 8); err != nil {
+		return err
+	}
+	if _, _, err := rfb.ReadProtocolVersion(conn); err != nil {
+		return err
+	}
+	if err := rfb.WriteSecurityTypes(conn, []uint8{rfb.SecurityNone}); err != nil {
+		return err
+	}
+	if _, err := rfb.ReadSecurityType(conn); err != nil {
+		return err
+	}
+	if err := rfb.WriteSecurityResult(conn, nil); err != nil {
+		return err
+	}
+	if _, err := rfb.ReadClientInit(conn); err != nil {
+		return err
+	}
+	return rfb.WriteServerInit(conn, testServerInit)
+}
+
+// viewerHandshake performs the client side of an RFB 3.8 handshake with None
+// security and checks the ServerInit relayed by the proxy.
+func viewerHandshake(conn net.Conn) error {
+	if _, _, err := rfb.ReadProtocolVersion(conn); err != nil {
+		return err
+	}
+	if err := rfb.WriteProtocolVersion(conn, 3, 8); err != nil {
+		return err
+	}
+	if _, err := rfb.ReadSecurityTypes(conn); err != nil {
+		return err
+	}
+	if err := rfb.WriteSecurityType(conn, rfb.SecurityNone); err != nil {
+		return err
+	}
+	if err := rfb.ReadSecurityResult(conn); err != nil {
+		return err
+	}
+	if err := rfb.WriteClientInit(conn, true); err != nil {
+		return err
+	}
+	init, err := rfb.ReadServerInit(conn)
+	if err != nil {
+		return err
+	}
+	if init.Name != testServerInit.Name || init.Width != testServerInit.Width {
+		return errors.New("proxy relayed a different ServerInit")
+	}
+	return nil
+}
+
+// sync sends a FramebufferUpdateRequest, which the proxy never drops, and
+// returns the messages received upstream before it.
+func (h *proxyHarness) sync(t *testing.T) []viewerMessage {
+	t.Helper()
+
+	if err := rfb.WriteFramebufferUpdateRequest(h.viewer, rfb.FramebufferUpdateRequest{Width: 1, Height: 1}); err != nil {
+		t.Fatalf("write FramebufferUpdateRequest: %v", err)
+	}
+	var msgs []viewerMessage
+	for {
+		select {
+		case msg, ok := <-h.received:
+			if !ok {
+				t.Fatal("upstream connection closed")
+			}
+			if msg.msgType == rfb.FramebufferUpdateRequestMsg {
+				return msgs
+			}
+			msgs = append(msgs, msg)
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for upstream messages")
+		}
+	}
+}
+
+// reasons returns the reasons of the rejections so far.
+func (h *proxyHarness) reasons() []RejectReason {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	reasons := make([]RejectReason, len(h.rejections))
+	for i, r := range h.rejections {
+		reasons[i] = r.Reason
+	}
+	return reasons
+}
+
+func TestProxy_Relay(t *testing.T) {
+	h := newProxyHarness(t, Quota{})
+
+	if err := rfb.WriteKeyEvent(h.viewer, rfb.KeyEvent{Down: true, Key: 'a'}); err != nil {
+		t.Fatal(err)
+	}
+	msgs := h.sync(t)
+	if len(msgs) != 1 || msgs[0].key != (rfb.KeyEvent{Down: true, Key: 'a'}) {
+		t.Fatalf("upstream received %+v, want one key press", msgs)
+	}
+
+	go func() { _, _ = h.upstream.Write([]byte{rfb.BellMsg}) }()
+	msgType, err := rfb.ReadMessageType(h.viewer)
+	if err != nil || msgType != rfb.BellMsg {
+		t.Fatalf("viewer read message %d, %v; want Bell", msgType, err)
+	}
+
+	sessions := h.proxy.Sessions()
+	if len(sessions) != 1 {
+		t.Fatalf("Sessions() returned %d sessions, want 1", len(sessions))
+	}
+	if got := sessions[0].Messages[rfb.KeyEventMsg]; got != (MessageStats{Count: 1, Bytes: 8}) {
+		t.Errorf("key event stats = %+v, want 1 message of 8 bytes", got)
+	}
+}
+
+func TestProxy_RelayFence(t *testing.T) {
+	h := newProxyHarness(t, Quota{})
+
+	fence := rfb.Fence{Flags: rfb.FenceBlockBefore, Payload: []byte("sync")}
+	if err := rfb.WriteFence(h.viewer, fence); err != nil {
+		t.Fatal(err)
+	}
+	msgs := h.sync(t)
+	if len(msgs) != 1 || msgs[0].msgType != rfb.FenceMsg || msgs[0].size != 13 {
+		t.Fatalf("upstream received %+v, want one fence of 13 bytes", msgs)
+	}
+}
+
+func TestProxy_RelayExtendedKeyEvent(t *testing.T) {
+	h := newProxyHarness(t, Quota{})
+
+	if err := rfb.WriteExtendedKeyEvent(h.viewer, rfb.ExtendedKeyEvent{Down: true, Keysym: 'a', Keycode: 0x1e}); err != nil {
+		t.Fatal(err)
+	}
+	msgs := h.sync(t)
+	if len(msgs) != 1 || msgs[0].size != 12 || msgs[0].key != (rfb.KeyEvent{Down: true, Key: 'a'}) {
+		t.Fatalf("upstream received %+v, want one extended key press", msgs)
+	}
+}
+
+func TestProxy_RelayEnableContinuousUpdates(t *testing.T) {
+	h := newProxyHarness(t, Quota{})
+
+	msg := rfb.EnableContinuousUpdates{Enable: true, Width: 64, Height: 64}
+	if err := rfb.WriteEnableContinuousUpdates(h.viewer, msg); err != nil {
+		t.Fatal(err)
+	}
+	msgs := h.sync(t)
+	if len(msgs) != 1 || msgs[0].msgType != rfb.EnableContinuousUpdatesMsg || msgs[0].size != 10 {
+		t.Fatalf("upstream received %+v, want one enable continuous updates of 10 bytes", msgs)
+	}
+}
+
+func TestProxy_MaxMessageSize(t *testing.T) {
+	h := newProxyHarness(t, Quota{MaxMessageSize: 64})
+
+	if err := rfb.WriteClientCutText(h.viewer, make([]byte, 100)); err != nil {
+		t.Fatal(err)
+	}
+	if err := rfb.WriteClientCutText(h.viewer, []byte("short")); err != nil {
+		t.Fatal(err)
+	}
+	msgs := h.sync(t)
+	if len(msgs) != 1 || msgs[0].size != 13 {
+		t.Fatalf("upstream received %d messages, want only the short cut text", len(msgs))
+	}
+
+	reasons := h.reasons()
+	if len(reasons) != 1 || reasons[0] != RejectMessageSize {
+		t.Errorf("rejections = %v, want one %s", reasons, RejectMessageSize)
+	}
+	if got := h.proxy.Sessions()[0]; got.Rejected != 1 || got.Messages[rfb.ClientCutTextMsg].Bytes != 108+13 {
+		t.Errorf("session = %+v, want 1 rejection and 121 cut text bytes", got)
+	}
+}
+
+func TestProxy_EventRate(t *testing.T) {
+	h := newProxyHarness(t, Quota{EventsPerSecond: 2})
+
+	for _, key := range []uint32{'a', 'b', 'c'} {
+		if err := rfb.WriteKeyEvent(h.viewer, rfb.KeyEvent{Down: true, Key: key}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, key := range []uint32{'a', 'b', 'c'} {
+		if err := rfb.WriteKeyEvent(h.viewer, rfb.KeyEvent{Down: false, Key: key}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A button press is forwarded even over the rate.
+	if err := rfb.WritePointerEvent(h.viewer, rfb.PointerEvent{Mask: 1, X: 5, Y: 5}); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []rfb.KeyEvent
+	var pointers int
+	for _, msg := range h.sync(t) {
+		switch msg.msgType {
+		case rfb.KeyEventMsg:
+			got = append(got, msg.key)
+		case rfb.PointerEventMsg:
+			pointers++
+		}
+	}
+	want := []rfb.KeyEvent{{Down: true, Key: 'a'}, {Down: true, Key: 'b'}, {Key: 'a'}, {Key: 'b'}}
+	if len(got) != len(want) {
+		t.Fatalf("upstream received keys %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("upstream received keys %v, want %v", got, want)
+		}
+	}
+	if pointers != 1 {
+		t.Errorf("upstream received %d pointer events, want 1", pointers)
+	}
+
+	reasons := h.reasons()
+	if len(reasons) != 2 || reasons[0] != RejectEventRate || reasons[1] != RejectEventRate {
+		t.Errorf("rejections = %v, want the press and release of 'c'", reasons)
+	}
+}
+
+func TestProxy_ViewOnly(t *testing.T) {
+	h := newProxyHarness(t, Quota{}, func(p *Proxy) {
+		p.Authorizer = server.AuthorizerFunc(func(context.Context, server.AccessRequest) (server.Access, error) {
+			return server.AccessViewOnly, nil
+		})
+	})
+
+	if err := rfb.WriteKeyEvent(h.viewer, rfb.KeyEvent{Down: true, Key: 'a'}); err != nil {
+		t.Fatal(err)
+	}
+	if err := rfb.WritePointerEvent(h.viewer, rfb.PointerEvent{Mask: 1, X: 5, Y: 5}); err != nil {
+		t.Fatal(err)
+	}
+	if msgs := h.sync(t); len(msgs) != 0 {
+		t.Fatalf("upstream received %d messages from a view-only viewer, want 0", len(msgs))
+	}
+
+	reasons := h.reasons()
+	if len(reasons) != 2 || reasons[0] != RejectViewOnly || reasons[1] != RejectViewOnly {
+		t.Errorf("rejections = %v, want two %s", reasons, RejectViewOnly)
+	}
+	if got := h.proxy.Sessions()[0].Access; got != server.AccessViewOnly {
+		t.Errorf("session access = %s, want %s", got, server.AccessViewOnly)
+	}
+}
+
+func TestProxy_UpstreamUnavailable(t *testing.T) {
+	p := &Proxy{Dial: func(context.Context) (net.Conn, error) {
+		return nil, errors.New("connection refused")
+	}}
+
+	viewerConn, proxyConn := net.Pipe()
+	defer viewerConn.Close()
+	go func() { _ = p.ServeConn(context.Background(), proxyConn) }()
+
+	if _, _, err := rfb.ReadProtocolVersion(viewerConn); err != nil {
+		t.Fatal(err)
+	}
+	if err := rfb.WriteProtocolVersion(viewerConn, 3, 8); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rfb.ReadSecurityTypes(viewerConn); err != nil {
+		t.Fatal(err)
+	}
+	if err := rfb.WriteSecurityType(viewerConn, rfb.SecurityNone); err != nil {
+		t.Fatal(err)
+	}
+	err := rfb.ReadSecurityResult(viewerConn)
+	var failure *rfb.FailureError
+	if !errors.As(err, &failure) || failure.Reason != "upstream server unavailable" {
+		t.Errorf("ReadSecurityResult error = %v, want the upstream failure reason", err)
+	}
+}
+
+func TestProxy_DeniedViewerDoesNotDial(t *testing.T) {
+	var dials int
+	p := &Proxy{
+		Dial: func(context.Context) (net.Conn, error) {
+			dials++
+			return nil, errors.New("unexpected dial")
+		},
+		Authorizer: server.AuthorizerFunc(func(context.Context, server.AccessRequest) (server.Access, error) {
+			return server.AccessDenied, nil
+		}),
+	}
+
+	viewerConn, proxyConn := net.Pipe()
+	defer viewerConn.Close()
+	done := make(chan error, 1)
+	go func() { done <- p.ServeConn(context.Background(), proxyConn) }()
+
+	if _, _, err := rfb.ReadProtocolVersion(viewerConn); err != nil {
+		t.Fatal(err)
+	}
+	if err := rfb.WriteProtocolVersion(viewerConn, 3, 8); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rfb.ReadSecurityTypes(viewerConn); err != nil {
+		t.Fatal(err)
+	}
+	if err := rfb.WriteSecurityType(viewerConn, rfb.SecurityNone); err != nil {
+		t.Fatal(err)
+	}
+	if err := rfb.ReadSecurityResult(viewerConn); err == nil {
+		t.Fatal("ReadSecurityResult succeeded for a denied viewer")
+	}
+
+	if err := <-done; !errors.Is(err, server.ErrAccessDenied) {
+		t.Errorf("ServeConn error = %v, want %v", err, server.ErrAccessDenied)
+	}
+	if dials != 0 {
+		t.Errorf("upstream dialed %d times for a denied viewer, want 0", dials)
+	}
+}
+
+// xorConn stands in for an encrypted connection by inverting every byte.
+type xorConn struct {
+	net.Conn
+}
+
+func (c *xorConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	for i := range p[:n] {
+		p[i] ^= 0xff
+	}
+	return n, err
+}
+
+func (c *xorConn) Write(p []byte) (int, error) {
+	inverted := make([]byte, len(p))
+	for i, b := range p {
+		inverted[i] = b ^ 0xff
+	}
+	return c.Conn.Write(inverted)
+}
+
+func TestProxy_WrappingUpstreamAuth(t *testing.T) {
+	upstreams := make(chan net.Conn, 1)
+	p := &Proxy{
+		Dial: func(context.Context) (net.Conn, error) {
+			serverConn, clientConn := net.Pipe()
+			go func() {
+				// The upstream offers the TLS security type and continues the
+				// handshake inside it.
+				_ = rfb.WriteProtocolVersion(serverConn, 3, 8)
+				_, _, _ = rfb.ReadProtocolVersion(serverConn)
+				_ = rfb.WriteSecurityTypes(serverConn, []uint8{rfb.SecurityTLS})
+				if _, err := rfb.ReadSecurityType(serverConn); err != nil {
+					return
+				}
+				secured := &xorConn{serverConn}
+				_ = rfb.WriteSecurityTypes(secured, []uint8{rfb.SecurityNone})
+				if _, err := rfb.ReadSecurityType(secured); err != nil {
+					return
+				}
+				_ = rfb.WriteSecurityResult(secured, nil)
+				if _, err := rfb.ReadClientInit(secured); err != nil {
+					return
+				}
+				if rfb.WriteServerInit(secured, testServerInit) == nil {
+					upstreams <- secured
+				}
+			}()
+			return clientConn, nil
+		},
+		Auth: []vnc.ClientAuth{&vnc.TLSAuth{
+			AnonymousTLS: func(ctx context.Context, conn net.Conn) (net.Conn, error) {
+				return &xorConn{conn}, nil
+			},
+		}},
+	}
+
+	viewerConn, proxyConn := net.Pipe()
+	defer viewerConn.Close()
+	go func() { _ = p.ServeConn(context.Background(), proxyConn) }()
+
+	_ = viewerConn.SetDeadline(time.Now().Add(5 * time.Second))
+	if err := viewerHandshake(viewerConn); err != nil {
+		t.Fatalf("viewer handshake: %v", err)
+	}
+	upstream := <-upstreams
+	defer upstream.Close()
+
+	if err := rfb.WriteKeyEvent(viewerConn, rfb.KeyEvent{Down: true, Key: 'a'}); err != nil {
+		t.Fatal(err)
+	}
+	msg, err := readViewerMessage(upstream, false, 0)
+	if err != nil || msg.key != (rfb.KeyEvent{Down: true, Key: 'a'}) {
+		t.Fatalf("upstream received %+v, %v; want the key press", msg, err)
+	}
+}
@@ -0,0 +1,199 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package proxy
+
+import (
+	"net"
+	"time"
+
+	"github.com/tenthirtyam/go-vnc/rfb"
+)
+
+// Quota limits the messages a viewer may send upstream. Zero fields are
+// unlimited. Every viewer gets its own allowance.
+//
+// The proxy never drops a message that would leave the upstream session in a
+// different state than the viewer expects: releases of forwarded keys and
+// pointer events that change the button mask are always forwarded, and
+// SetPixelFormat, SetEncodings, and FramebufferUpdateRequest are delayed
+// rather than dropped when the viewer exceeds its byte rate.
+type Quota struct {
+	// BytesPerSecond limits the size of the messages a viewer sends, counted
+	// on the wire including the type byte. Key presses, pointer motion, and
+	// cut text over the rate are dropped.
+	BytesPerSecond int
+
+	// EventsPerSecond limits key and pointer events. Key presses and pointer
+	// motion over the rate are dropped.
+	EventsPerSecond int
+
+	// MaxMessageSize is the largest message forwarded, in bytes including
+	// the header. Larger messages, typically cut text, are discarded without
+	// being buffered.
+	MaxMessageSize int
+}
+
+// RejectReason identifies the limit that made the proxy drop a message.
+type RejectReason string
+
+// Reasons reported in Rejection events.
+const (
+	// RejectMessageSize means the message exceeded Quota.MaxMessageSize.
+	RejectMessageSize RejectReason = "message_size"
+
+	// RejectByteRate means the viewer exceeded Quota.BytesPerSecond.
+	RejectByteRate RejectReason = "byte_rate"
+
+	// RejectEventRate means the viewer exceeded Quota.EventsPerSecond.
+	RejectEventRate RejectReason = "event_rate"
+
+	// RejectViewOnly means the viewer sent input but was granted
+	// server.AccessViewOnly.
+	RejectViewOnly RejectReason = "view_only"
+)
+
+// Rejection describes a viewer message dropped by the proxy. The release of a
+// key whose press was dropped is dropped as well and reported with the reason
+// of the press.
+type Rejection struct {
+	// Time is when the message was dropped.
+	Time time.Time
+
+	// RemoteAddr is the address of the viewer.
+	RemoteAddr net.Addr
+
+	// Reason is the limit the message exceeded.
+	Reason RejectReason
+
+	// MessageType is the client message type, such as rfb.KeyEventMsg.
+	MessageType uint8
+
+	// Size is the size of the message on the wire.
+	Size int
+}
+
+// quotaState applies a Quota to the messages of one viewer.
+type quotaState struct {
+	bytes  tokenBucket
+	events tokenBucket
+
+	// droppedKeys holds the keys whose press was dropped, so that their
+	// release is dropped too.
+	droppedKeys map[uint32]RejectReason
+
+	// pointerMask is the button mask of the last forwarded pointer event.
+	pointerMask uint16
+}
+
+// newQuotaState returns the state of a viewer that has sent nothing yet.
+func newQuotaState(quota Quota) *quotaState {
+	return &quotaState{
+		bytes:       tokenBucket{rate: float64(quota.BytesPerSecond), tokens: float64(quota.BytesPerSecond)},
+		events:      tokenBucket{rate: float64(quota.EventsPerSecond), tokens: float64(quota.EventsPerSecond)},
+		droppedKeys: make(map[uint32]RejectReason),
+	}
+}
+
+// admit decides whether msg is forwarded. It returns the reason if the
+// message must be dropped, and otherwise how long to delay it.
+func (q *quotaState) admit(msg viewerMessage, now time.Time) (RejectReason, time.Duration) {
+	if msg.raw == nil {
+		return RejectMessageSize, 0
+	}
+
+	switch msg.msgType {
+	case rfb.KeyEventMsg, rfb.QEMUClientMsg:
+		if !msg.key.Down {
+			if reason, dropped := q.droppedKeys[msg.key.Key]; dropped {
+				delete(q.droppedKeys, msg.key.Key)
+				return reason, 0
+			}
+			q.takeInput(now, msg.size)
+			return "", 0
+		}
+		if reason := q.limitInput(now, msg.size); reason != "" {
+			q.droppedKeys[msg.key.Key] = reason
+			return reason, 0
+		}
+		delete(q.droppedKeys, msg.key.Key)
+		return "", 0
+
+	case rfb.PointerEventMsg:
+		if msg.pointerMask != q.pointerMask {
+			q.pointerMask = msg.pointerMask
+			q.takeInput(now, msg.size)
+			return "", 0
+		}
+		return q.limitInput(now, msg.size), 0
+
+	case rfb.ClientCutTextMsg:
+		if !q.bytes.fits(now, float64(msg.size)) {
+			return RejectByteRate, 0
+		}
+		q.bytes.take(now, float64(msg.size))
+		return "", 0
+
+	default:
+		return "", q.bytes.take(now, float64(msg.size))
+	}
+}
+
+// limitInput charges an input event that may be dropped and returns the
+// reason if it exceeds a rate.
+func (q *quotaState) limitInput(now time.Time, size int) RejectReason {
+	switch {
+	case !q.events.fits(now, 1):
+		return RejectEventRate
+	case !q.bytes.fits(now, float64(size)):
+		return RejectByteRate
+	}
+	q.takeInput(now, size)
+	return ""
+}
+
+// takeInput charges an input event that is forwarded regardless of the rates.
+func (q *quotaState) takeInput(now time.Time, size int) {
+	q.events.take(now, 1)
+	q.bytes.take(now, float64(size))
+}
+
+// tokenBucket is a token bucket holding at most one second of its rate. A
+// zero rate is unlimited.
+type tokenBucket struct {
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+// refill adds the tokens accrued since the last call.
+func (b *tokenBucket) refill(now time.Time) {
+	if !b.last.IsZero() {
+		b.tokens = min(b.rate, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	}
+	b.last = now
+}
+
+// fits reports whether n tokens are available. A full bucket admits a single
+// request of any size, so messages larger than the rate are not starved.
+func (b *tokenBucket) fits(now time.Time, n float64) bool {
+	if b.rate <= 0 {
+		return true
+	}
+	b.refill(now)
+	return b.tokens >= n || b.tokens >= b.rate
+}
+
+// take removes n tokens, going into debt if necessary, and returns how long
+// it takes to repay the debt.
+func (b *tokenBucket) take(now time.Time, n float64) time.Duration {
+	if b.rate <= 0 {
+		return 0
+	}
+	b.refill(now)
+	b.tokens -= n
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
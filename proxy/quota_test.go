@@ -0,0 +1,65 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package proxy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/tenthirtyam/go-vnc/rfb"
+)
+
+func TestQuota_ByteRate(t *testing.T) {
+	q := newQuotaState(Quota{BytesPerSecond: 100})
+	now := time.Unix(0, 0)
+
+	cutText := viewerMessage{msgType: rfb.ClientCutTextMsg, raw: []byte{}, size: 80}
+	if reason, _ := q.admit(cutText, now); reason != "" {
+		t.Fatalf("first cut text rejected: %s", reason)
+	}
+	if reason, _ := q.admit(cutText, now); reason != RejectByteRate {
+		t.Fatalf("second cut text: reason %q, want %s", reason, RejectByteRate)
+	}
+
+	// Requests are delayed instead of dropped.
+	request := viewerMessage{msgType: rfb.FramebufferUpdateRequestMsg, raw: []byte{}, size: 70}
+	reason, delay := q.admit(request, now)
+	if reason != "" || delay != 500*time.Millisecond {
+		t.Fatalf("request: reason %q, delay %v; want a 500ms delay", reason, delay)
+	}
+
+	if reason, _ := q.admit(cutText, now.Add(2*time.Second)); reason != "" {
+		t.Errorf("cut text after the bucket refilled rejected: %s", reason)
+	}
+}
+
+func TestQuota_PointerMotion(t *testing.T) {
+	q := newQuotaState(Quota{EventsPerSecond: 1})
+	now := time.Unix(0, 0)
+
+	motion := viewerMessage{msgType: rfb.PointerEventMsg, raw: []byte{}, size: 6}
+	press := viewerMessage{msgType: rfb.PointerEventMsg, raw: []byte{}, size: 6, pointerMask: 1}
+
+	if reason, _ := q.admit(motion, now); reason != "" {
+		t.Fatalf("first motion rejected: %s", reason)
+	}
+	if reason, _ := q.admit(motion, now); reason != RejectEventRate {
+		t.Fatalf("second motion: reason %q, want %s", reason, RejectEventRate)
+	}
+	if reason, _ := q.admit(press, now); reason != "" {
+		t.Errorf("button press rejected: %s", reason)
+	}
+}
+
+func TestQuota_Unlimited(t *testing.T) {
+	q := newQuotaState(Quota{})
+	now := time.Unix(0, 0)
+
+	msg := viewerMessage{msgType: rfb.KeyEventMsg, raw: []byte{}, size: 8, key: rfb.KeyEvent{Down: true, Key: 'a'}}
+	for range 1000 {
+		if reason, delay := q.admit(msg, now); reason != "" || delay != 0 {
+			t.Fatalf("unlimited quota: reason %q, delay %v", reason, delay)
+		}
+	}
+}
@@ -0,0 +1,221 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"slices"
+	"time"
+
+	"github.com/tenthirtyam/go-vnc"
+	"github.com/tenthirtyam/go-vnc/rfb"
+	"github.com/tenthirtyam/go-vnc/server"
+)
+
+// viewerMessage is a message received from a viewer.
+type viewerMessage struct {
+	msgType uint8
+
+	// raw is the complete message including the type byte, or nil if the
+	// message exceeded the maximum size and was discarded.
+	raw []byte
+
+	// size is the size of the message on the wire.
+	size int
+
+	// key is the body of a KeyEvent. QEMU extended key events are recorded
+	// with their keysym, or their keycode if the keysym is zero.
+	key rfb.KeyEvent
+
+	// pointerMask is the button mask of a PointerEvent.
+	pointerMask uint16
+
+	// encodings is the body of a SetEncodings message.
+	encodings []int32
+}
+
+// viewerHeaderSizes is the size of the fixed part of each client message
+// after the type byte.
+var viewerHeaderSizes = map[uint8]int{
+	rfb.SetPixelFormatMsg:           19,
+	rfb.SetEncodingsMsg:             3,
+	rfb.FramebufferUpdateRequestMsg: 9,
+	rfb.KeyEventMsg:                 7,
+	rfb.PointerEventMsg:             5,
+	rfb.ClientCutTextMsg:            7,
+	rfb.FenceMsg:                    8,
+	rfb.QEMUClientMsg:               11,
+	rfb.EnableContinuousUpdatesMsg:  9,
+}
+
+// relayViewer forwards the messages of a viewer upstream until either
+// connection fails, applying the viewer's access and the proxy's quota.
+func (p *Proxy) relayViewer(ctx context.Context, s *session, viewer io.Reader, upstream io.Writer) error {
+	extendedPointer := false
+	for {
+		msg, err := readViewerMessage(viewer, extendedPointer, p.Quota.MaxMessageSize)
+		if err != nil {
+			return err
+		}
+		if msg.msgType == rfb.SetEncodingsMsg && msg.raw != nil {
+			extendedPointer = slices.Contains(msg.encodings, rfb.PseudoEncodingExtendedMouseButtons)
+		}
+
+		var (
+			reason RejectReason
+			delay  time.Duration
+		)
+		if s.access < server.AccessInteractive && isInput(msg.msgType) {
+			reason = RejectViewOnly
+		} else {
+			reason, delay = s.quota.admit(msg, time.Now())
+		}
+		s.account(msg.msgType, msg.size, reason != "")
+		if reason != "" {
+			p.reject(s, msg, reason)
+			continue
+		}
+
+		if err := sleepContext(ctx, delay); err != nil {
+			return err
+		}
+		if _, err := upstream.Write(msg.raw); err != nil {
+			return err
+		}
+	}
+}
+
+// isInput reports whether messages of msgType control the desktop.
+func isInput(msgType uint8) bool {
+	switch msgType {
+	case rfb.KeyEventMsg, rfb.QEMUClientMsg, rfb.PointerEventMsg, rfb.ClientCutTextMsg:
+		return true
+	default:
+		return false
+	}
+}
+
+// reject reports a dropped viewer message.
+func (p *Proxy) reject(s *session, msg viewerMessage, reason RejectReason) {
+	rejection := Rejection{
+		Time:        time.Now(),
+		RemoteAddr:  s.remote,
+		Reason:      reason,
+		MessageType: msg.msgType,
+		Size:        msg.size,
+	}
+	p.logger().Debug("proxy dropped viewer message",
+		vnc.Field{Key: "remote_addr", Value: s.remote},
+		vnc.Field{Key: "reason", Value: string(reason)},
+		vnc.Field{Key: "message_type", Value: msg.msgType},
+		vnc.Field{Key: "size", Value: msg.size})
+	if p.OnReject != nil {
+		p.OnReject(rejection)
+	}
+}
+
+// readViewerMessage reads one client message. Messages larger than maxSize,
+// if positive, are discarded and returned without raw bytes. extendedPointer
+// reports whether the viewer negotiated ExtendedMouseButtons, which adds a
+// byte to some pointer events.
+func readViewerMessage(r io.Reader, extendedPointer bool, maxSize int) (viewerMessage, error) {
+	msgType, err := rfb.ReadMessageType(r)
+	if err != nil {
+		return viewerMessage{}, err
+	}
+	headerSize, ok := viewerHeaderSizes[msgType]
+	if !ok {
+		return viewerMessage{}, fmt.Errorf("proxy: unsupported viewer message type %d", msgType)
+	}
+
+	raw := make([]byte, 1+headerSize)
+	raw[0] = msgType
+	if _, err := io.ReadFull(r, raw[1:]); err != nil {
+		return viewerMessage{}, err
+	}
+	// Only the extended key event has the size of the QEMU header.
+	if msgType == rfb.QEMUClientMsg && raw[1] != rfb.QEMUExtendedKeyEventSubtype {
+		return viewerMessage{}, fmt.Errorf("proxy: unsupported QEMU viewer message subtype %d", raw[1])
+	}
+
+	var bodySize int64
+	switch msgType {
+	case rfb.SetEncodingsMsg:
+		count := binary.BigEndian.Uint16(raw[2:4])
+		if count > rfb.MaxEncodings {
+			return viewerMessage{}, &rfb.LengthError{Field: "encodings", Length: uint32(count), Max: rfb.MaxEncodings}
+		}
+		bodySize = 4 * int64(count)
+	case rfb.PointerEventMsg:
+		if extendedPointer && raw[1]&0x80 != 0 {
+			bodySize = 1
+		}
+	case rfb.ClientCutTextMsg:
+		// Extended clipboard messages carry a negative length.
+		length := int64(int32(binary.BigEndian.Uint32(raw[4:8]))) // #nosec G115 - two's complement on the wire
+		bodySize = max(length, -length)
+		if bodySize > rfb.MaxCutTextLength {
+			return viewerMessage{}, &rfb.LengthError{Field: "cut text", Length: uint32(bodySize), Max: rfb.MaxCutTextLength} // #nosec G115 - at most 2^31
+		}
+	case rfb.FenceMsg:
+		bodySize = int64(raw[8])
+		if bodySize > rfb.MaxFencePayload {
+			return viewerMessage{}, &rfb.LengthError{Field: "fence payload", Length: uint32(bodySize), Max: rfb.MaxFencePayload} // #nosec G115 - at most 255
+		}
+	}
+
+	msg := viewerMessage{msgType: msgType, size: len(raw) + int(bodySize)}
+	if maxSize > 0 && msg.size > maxSize {
+		if _, err := io.CopyN(io.Discard, r, bodySize); err != nil {
+			return viewerMessage{}, err
+		}
+		return msg, nil
+	}
+
+	raw = append(raw, make([]byte, bodySize)...)
+	if _, err := io.ReadFull(r, raw[1+headerSize:]); err != nil {
+		return viewerMessage{}, err
+	}
+	msg.raw = raw
+
+	switch msgType {
+	case rfb.KeyEventMsg:
+		msg.key = rfb.KeyEvent{Down: raw[1] != 0, Key: binary.BigEndian.Uint32(raw[4:8])}
+	case rfb.QEMUClientMsg:
+		ev, _ := rfb.ReadExtendedKeyEvent(bytes.NewReader(raw[1:]))
+		msg.key = rfb.KeyEvent{Down: ev.Down, Key: ev.Keysym}
+		if ev.Keysym == 0 {
+			msg.key.Key = ev.Keycode
+		}
+	case rfb.PointerEventMsg:
+		msg.pointerMask = uint16(raw[1])
+		if bodySize == 1 {
+			msg.pointerMask = uint16(raw[1]&0x7f) | uint16(raw[6])<<7
+		}
+	case rfb.SetEncodingsMsg:
+		for i := 4; i < len(raw); i += 4 {
+			msg.encodings = append(msg.encodings, int32(binary.BigEndian.Uint32(raw[i:i+4]))) // #nosec G115 - two's complement on the wire
+		}
+	}
+	return msg, nil
+}
+
+// sleepContext waits for d or until ctx ends.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,94 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package vnc
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Quirks is a set of workarounds for servers that deviate from RFC 6143.
+// Quirks are usually enabled through a preset such as ForBMCKVM rather than
+// individually.
+type Quirks uint32
+
+const (
+	// QuirkNoPseudoEncodings omits pseudo-encodings from SetEncodings, for
+	// servers that drop the connection when offered encodings they do not
+	// recognize.
+	QuirkNoPseudoEncodings Quirks = 1 << iota
+
+	// QuirkEarlyServerData tolerates servers that send data before the
+	// session is set up: a SecurityResult after the None security type of
+	// RFB 3.3 and 3.7, which have none, and framebuffer updates pipelined
+	// right after ServerInit, in the server's pixel format, before the
+	// client's SetPixelFormat reaches the server. The stray SecurityResult is
+	// skipped, and messages arriving within earlyMessageWindow of ServerInit
+	// are buffered and decoded before the client sends its settings, then
+	// delivered ahead of later messages.
+	QuirkEarlyServerData
+
+	// QuirkCutTextKeepAlive drops ServerCutText messages without text,
+	// which Vino and gnome-remote-desktop send periodically to keep idle
+	// connections open, so they neither reach the application nor read as
+	// the remote clipboard being cleared.
+	QuirkCutTextKeepAlive
+)
+
+// earlyMessageWindow is how long a connection with QuirkEarlyServerData
+// waits after ServerInit for messages the server pipelines before the client
+// has sent its settings.
+const earlyMessageWindow = 200 * time.Millisecond
+
+// Has reports whether every quirk in q2 is set in q.
+func (q Quirks) Has(q2 Quirks) bool {
+	return q&q2 == q2
+}
+
+// hasQuirk reports whether the connection was configured with quirk.
+func (c *ClientConn) hasQuirk(quirk Quirks) bool {
+	return c.config != nil && c.config.Quirks.Has(quirk)
+}
+
+// readEarlyMessages reads the server messages that arrive within
+// earlyMessageWindow of the handshake, while the pixel format of ServerInit is
+// still in effect, and queues them for delivery before later messages.
+func (c *ClientConn) readEarlyMessages() error {
+	ctx, cancel := context.WithTimeout(c.ctx, earlyMessageWindow)
+	defer cancel()
+
+	var early []ServerMessage
+	for {
+		msg, err := c.readServerMessage(ctx)
+		if errors.Is(err, context.DeadlineExceeded) && c.ctx.Err() == nil {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		early = append(early, msg)
+	}
+	if len(early) > 0 {
+		c.logger.Warn("Server sent messages before the client settings",
+			Field{Key: "count", Value: len(early)})
+	}
+
+	c.pumpMu.Lock()
+	c.early = early
+	c.pumpMu.Unlock()
+	return nil
+}
+
+// withoutPseudoEncodings returns encs without its pseudo-encodings.
+func withoutPseudoEncodings(encs []Encoding) []Encoding {
+	filtered := make([]Encoding, 0, len(encs))
+	for _, enc := range encs {
+		if pseudo, ok := enc.(PseudoEncoding); ok && pseudo.IsPseudo() {
+			continue
+		}
+		filtered = append(filtered, enc)
+	}
+	return filtered
+}
@@ -0,0 +1,495 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+//go:build !vnc_minimal
+
+package vnc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tenthirtyam/go-vnc/rfb"
+)
+
+// fbsHeader starts every recording segment. Segments use the FBS format of
+// vncrec and rfbproxy, which existing players and converters understand.
+const fbsHeader = "FBS 001.000\n"
+
+// SegmentInfo describes a recording segment being opened.
+type SegmentInfo struct {
+	// Index is the position of the segment in the recording, starting at 0.
+	Index int
+
+	// Start is the time the segment was opened.
+	Start time.Time
+}
+
+// RecordingSink stores the segments of a session recording. NextSegment is
+// called when a recording starts and again whenever a segment limit is
+// reached; the recording closes each writer when its segment ends.
+type RecordingSink interface {
+	NextSegment(info SegmentInfo) (io.WriteCloser, error)
+}
+
+// AnnotationSink is implemented by RecordingSinks that store annotations
+// added with ClientConn.Annotate. WriteAnnotations is called when a segment
+// with annotations ends, before its writer is closed.
+type AnnotationSink interface {
+	WriteAnnotations(info SegmentInfo, annotations []RecordingAnnotation, duration time.Duration) error
+}
+
+// RecordingAnnotation is an annotation placed in a recording segment.
+type RecordingAnnotation struct {
+	// Offset is the position of the annotation from the start of the segment.
+	Offset time.Duration
+
+	// Text is the text passed to ClientConn.Annotate.
+	Text string
+}
+
+// WriteWebVTT writes annotations as a WebVTT file whose cues last until the
+// next annotation or the end of the segment, which players show as subtitles
+// or, with kind "chapters", as chapter markers.
+func WriteWebVTT(w io.Writer, annotations []RecordingAnnotation, duration time.Duration) error {
+	var b strings.Builder
+	b.WriteString("WEBVTT\n")
+	escaper := strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+	for i, annotation := range annotations {
+		end := duration
+		if i+1 < len(annotations) {
+			end = annotations[i+1].Offset
+		}
+		end = max(end, annotation.Offset+time.Millisecond)
+		fmt.Fprintf(&b, "\n%d\n%s --> %s\n%s\n", i+1,
+			webVTTTimestamp(annotation.Offset), webVTTTimestamp(end), escaper.Replace(annotation.Text))
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// webVTTTimestamp formats d as hh:mm:ss.ttt.
+func webVTTTimestamp(d time.Duration) string {
+	ms := d.Milliseconds()
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
+
+// writeWebVTTFile writes annotations to a WebVTT file at path.
+func writeWebVTTFile(path string, annotations []RecordingAnnotation, duration time.Duration) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600) // #nosec G304 - the path is derived from the caller's segment path
+	if err != nil {
+		return err
+	}
+	if err := WriteWebVTT(f, annotations, duration); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// RecordingSinkFunc adapts a function to a RecordingSink. It is the hook for
+// remote storage: return a writer that uploads the segment, for example an
+// io.Pipe feeding an S3 or GCS upload, and finish the upload in Close.
+type RecordingSinkFunc func(info SegmentInfo) (io.WriteCloser, error)
+
+// NextSegment calls f.
+func (f RecordingSinkFunc) NextSegment(info SegmentInfo) (io.WriteCloser, error) {
+	return f(info)
+}
+
+// FileSink records into a single local file, replacing any existing file. It
+// holds one segment, so it cannot be combined with segment limits.
+// Annotations are written next to it, with ".vtt" appended to Path.
+type FileSink struct {
+	Path string
+}
+
+// NextSegment creates the file for the first segment.
+func (s *FileSink) NextSegment(info SegmentInfo) (io.WriteCloser, error) {
+	if info.Index > 0 {
+		return nil, configurationError("FileSink.NextSegment",
+			"a file sink holds a single segment; use RollingFileSink with segment limits", nil)
+	}
+	return os.OpenFile(s.Path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600) // #nosec G304 - the path is chosen by the caller
+}
+
+// WriteAnnotations writes the annotations of the segment as WebVTT.
+func (s *FileSink) WriteAnnotations(_ SegmentInfo, annotations []RecordingAnnotation, duration time.Duration) error {
+	return writeWebVTTFile(s.Path+".vtt", annotations, duration)
+}
+
+// RollingFileSink records into a directory of segment files named
+// <Prefix>-<start time>-<index>.fbs, optionally keeping only the newest ones.
+// Use it with WithSegmentMaxSize or WithSegmentMaxDuration. Annotations are
+// written next to each segment, with ".vtt" appended to its name.
+type RollingFileSink struct {
+	// Dir is the directory the segments are written to. It is created if it
+	// does not exist.
+	Dir string
+
+	// Prefix starts every segment file name. The default is "recording".
+	Prefix string
+
+	// MaxSegments, if positive, deletes the oldest segments written by this
+	// sink so that at most MaxSegments remain.
+	MaxSegments int
+
+	mu       sync.Mutex
+	segments []string
+}
+
+// NextSegment creates the file for a segment and applies MaxSegments.
+func (s *RollingFileSink) NextSegment(info SegmentInfo) (io.WriteCloser, error) {
+	if err := os.MkdirAll(s.Dir, 0o750); err != nil {
+		return nil, err
+	}
+
+	name := s.path(info)
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600) // #nosec G304 - the directory is chosen by the caller
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.segments = append(s.segments, name)
+	for s.MaxSegments > 0 && len(s.segments) > s.MaxSegments {
+		if err := os.Remove(s.segments[0]); err != nil && !errors.Is(err, os.ErrNotExist) {
+			_ = f.Close()
+			return nil, err
+		}
+		if err := os.Remove(s.segments[0] + ".vtt"); err != nil && !errors.Is(err, os.ErrNotExist) {
+			_ = f.Close()
+			return nil, err
+		}
+		s.segments = s.segments[1:]
+	}
+	return f, nil
+}
+
+// WriteAnnotations writes the annotations of a segment as WebVTT.
+func (s *RollingFileSink) WriteAnnotations(info SegmentInfo, annotations []RecordingAnnotation, duration time.Duration) error {
+	return writeWebVTTFile(s.path(info)+".vtt", annotations, duration)
+}
+
+// path returns the file name of a segment.
+func (s *RollingFileSink) path(info SegmentInfo) string {
+	prefix := s.Prefix
+	if prefix == "" {
+		prefix = "recording"
+	}
+	return filepath.Join(s.Dir, fmt.Sprintf("%s-%s-%04d.fbs", prefix, info.Start.UTC().Format("20060102T150405Z"), info.Index))
+}
+
+// RecordingOption configures a recording started with StartRecording.
+type RecordingOption func(*recordingConfig)
+
+// recordingConfig holds the settings applied by RecordingOptions.
+type recordingConfig struct {
+	maxSize     int64
+	maxDuration time.Duration
+	keys        RecordingKeyProvider
+	maxBacklog  int64
+}
+
+// WithSegmentMaxSize starts a new segment once the current one holds at least
+// size bytes.
+func WithSegmentMaxSize(size int64) RecordingOption {
+	return func(cfg *recordingConfig) {
+		cfg.maxSize = size
+	}
+}
+
+// WithSegmentMaxDuration starts a new segment once the current one is at
+// least d old. Segments end at message boundaries, so an idle session keeps
+// its segment open until the next server message.
+func WithSegmentMaxDuration(d time.Duration) RecordingOption {
+	return func(cfg *recordingConfig) {
+		cfg.maxDuration = d
+	}
+}
+
+// Recording is a session recording started with StartRecording.
+type Recording struct {
+	c    *ClientConn
+	sink RecordingSink
+	cfg  recordingConfig
+	hook *recorderHook
+
+	// message collects the server message being read. It is only used by
+	// the goroutine decoding server messages.
+	message bytes.Buffer
+
+	mu          sync.Mutex
+	segment     io.WriteCloser
+	index       int
+	start       time.Time
+	size        int64
+	annotations []RecordingAnnotation
+	stopped     bool
+	err         error
+
+	// backlog holds the messages since the last full update request for
+	// observers that join later, when WithObservers is set.
+	backlog      [][]byte
+	backlogSize  int64
+	backlogStart backlogStart
+	observers    map[*RecordingObserver]struct{}
+}
+
+// StartRecording records the server side of the session into sink, starting
+// with the next server message, and requests a full framebuffer update so the
+// recording begins with a complete picture. Every segment starts with a
+// synthetic RFB 3.3 handshake describing the current desktop and a full
+// update, so it plays back on its own.
+//
+// Recordings hold the server messages as received. Tight, ZRLE, and Zlib
+// rectangles depend on zlib data sent before them, so with those encodings
+// only a recording started before the first such rectangle, and only its
+// first segment, can be decoded; choose other encodings for segmented
+// recordings.
+// The pixel format must not change while recording.
+//
+// Annotations added with Annotate are handed to sinks that implement
+// AnnotationSink when their segment ends. Only one recording can run at a
+// time. Closing the connection stops it.
+//
+// Example usage:
+//
+//	rec, err := client.StartRecording(&vnc.RollingFileSink{Dir: "recordings", MaxSegments: 24},
+//		vnc.WithSegmentMaxDuration(time.Hour))
+//	if err != nil {
+//		return err
+//	}
+//	defer rec.Stop()
+func (c *ClientConn) StartRecording(sink RecordingSink, options ...RecordingOption) (*Recording, error) {
+	rec := &Recording{c: c, sink: sink}
+	for _, option := range options {
+		option(&rec.cfg)
+	}
+	rec.hook = &recorderHook{rec}
+
+	if !c.recorder.CompareAndSwap(nil, rec.hook) {
+		return nil, c.enrichError(validationError("StartRecording", "a recording is already running", nil))
+	}
+
+	rec.mu.Lock()
+	err := rec.openSegment()
+	if err != nil && rec.segment != nil {
+		_ = rec.segment.Close()
+	}
+	rec.mu.Unlock()
+	if err != nil {
+		c.recorder.CompareAndSwap(rec.hook, nil)
+		return nil, c.enrichError(configurationError("StartRecording", "failed to open recording segment", err))
+	}
+
+	if err := rec.requestKeyframe(); err != nil {
+		_ = rec.Stop()
+		return nil, err
+	}
+	return rec, nil
+}
+
+// Stop ends the recording and closes the current segment. It returns the
+// error that stopped the recording early, if any, or the error closing the
+// segment.
+func (r *Recording) Stop() error {
+	r.c.recorder.CompareAndSwap(r.hook, nil)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.stopped {
+		return r.err
+	}
+	r.stopped = true
+	r.endObservers(io.EOF)
+	if err := r.closeSegment(); err != nil && r.err == nil {
+		r.err = err
+	}
+	return r.err
+}
+
+// Err returns the error that stopped the recording early, if any.
+func (r *Recording) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+// stop implements messageRecorder.
+func (r *Recording) stop() error {
+	return r.Stop()
+}
+
+// tee implements messageRecorder.
+func (r *Recording) tee(messageType uint8, body io.Reader) io.Reader {
+	r.message.Reset()
+	r.message.WriteByte(messageType)
+	return io.TeeReader(body, &r.message)
+}
+
+// commit implements messageRecorder. Messages that failed to parse are not
+// recorded; the connection is unusable after them anyway.
+func (r *Recording) commit(ok bool) {
+	if !ok {
+		return
+	}
+
+	r.mu.Lock()
+	if r.stopped {
+		r.mu.Unlock()
+		return
+	}
+
+	err := r.writeBlock(r.message.Bytes())
+	rolled, refresh := false, false
+	if err == nil {
+		refresh = r.observe()
+	}
+	if err == nil && r.full() {
+		if err = r.closeSegment(); err == nil {
+			r.index++
+			err = r.openSegment()
+			rolled = err == nil
+		}
+	}
+	if err != nil {
+		r.fail(err)
+	}
+	r.mu.Unlock()
+
+	// The request is sent from another goroutine so that reading server
+	// messages never waits on writing to the server.
+	if rolled || refresh {
+		r.c.goTracked(func() {
+			if err := r.requestKeyframe(); err != nil {
+				r.c.logger.Warn("Failed to request a full update for the recording", Field{Key: "error", Value: err})
+			}
+		})
+	}
+}
+
+// annotate implements messageRecorder.
+func (r *Recording) annotate(annotation Annotation) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.stopped {
+		return
+	}
+	r.annotations = append(r.annotations, RecordingAnnotation{
+		Offset: max(annotation.Time.Sub(r.start), 0),
+		Text:   annotation.Text,
+	})
+}
+
+// closeSegment stores the annotations of the current segment, if the sink
+// supports them, and closes it. The caller holds r.mu.
+func (r *Recording) closeSegment() error {
+	var err error
+	if sink, ok := r.sink.(AnnotationSink); ok && len(r.annotations) > 0 {
+		err = sink.WriteAnnotations(SegmentInfo{Index: r.index, Start: r.start}, r.annotations, time.Since(r.start))
+	}
+	r.annotations = nil
+	if closeErr := r.segment.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// full reports whether the current segment has reached a limit.
+func (r *Recording) full() bool {
+	return r.cfg.maxSize > 0 && r.size >= r.cfg.maxSize ||
+		r.cfg.maxDuration > 0 && time.Since(r.start) >= r.cfg.maxDuration
+}
+
+// fail stops the recording after a storage error. The caller holds r.mu.
+func (r *Recording) fail(err error) {
+	r.err = err
+	r.stopped = true
+	r.endObservers(err)
+	r.c.recorder.CompareAndSwap(r.hook, nil)
+	if r.segment != nil {
+		_ = r.segment.Close()
+	}
+	r.c.logger.Warn("Recording stopped after a storage error",
+		Field{Key: "segment", Value: r.index},
+		Field{Key: "error", Value: err})
+}
+
+// openSegment opens the next segment and writes the FBS header and the
+// synthetic handshake. The caller holds r.mu.
+func (r *Recording) openSegment() error {
+	r.start = time.Now()
+	r.size = 0
+	r.annotations = nil
+	info := SegmentInfo{Index: r.index, Start: r.start}
+	segment, err := r.sink.NextSegment(info)
+	if err != nil {
+		r.segment = nil
+		return err
+	}
+	r.segment = segment
+	if r.cfg.keys != nil {
+		key, keyID, err := r.cfg.keys.RecordingKey(info)
+		if err != nil {
+			return err
+		}
+		if segment, err = newEncryptingWriter(segment, key, keyID); err != nil {
+			return err
+		}
+		r.segment = segment
+	}
+
+	if _, err := io.WriteString(segment, fbsHeader); err != nil {
+		return err
+	}
+	r.size += int64(len(fbsHeader))
+
+	width, height := r.c.GetFrameBufferSize()
+	pf := r.c.GetPixelFormat()
+	var handshake bytes.Buffer
+	handshake.Write(rfb.FormatProtocolVersion(3, 3))
+	_ = binary.Write(&handshake, binary.BigEndian, uint32(rfb.SecurityNone))
+	if err := rfb.WriteServerInit(&handshake, rfb.ServerInit{
+		Width:       width,
+		Height:      height,
+		PixelFormat: wirePixelFormat(&pf),
+		Name:        r.c.GetDesktopName(),
+	}); err != nil {
+		return err
+	}
+	return r.writeBlock(handshake.Bytes())
+}
+
+// writeBlock writes data as an FBS block: its length, the data padded to four
+// bytes, and the milliseconds since the segment started. The caller holds
+// r.mu.
+func (r *Recording) writeBlock(data []byte) error {
+	padded := (len(data) + 3) &^ 3
+	block := make([]byte, 0, padded+8)
+	block = binary.BigEndian.AppendUint32(block, uint32(len(data))) // #nosec G115 - server messages are far below 4 GiB
+	block = append(block, data...)
+	block = append(block, make([]byte, padded-len(data))...)
+	block = binary.BigEndian.AppendUint32(block, uint32(time.Since(r.start).Milliseconds())) // #nosec G115 - wraps after 49 days, as in the FBS format
+
+	n, err := r.segment.Write(block)
+	r.size += int64(n)
+	return err
+}
+
+// requestKeyframe requests a full framebuffer update to start a segment or
+// the backlog of observers.
+func (r *Recording) requestKeyframe() error {
+	r.resetBacklog()
+	width, height := r.c.GetFrameBufferSize()
+	return r.c.FramebufferUpdateRequest(false, 0, 0, width, height)
+}
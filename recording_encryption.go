@@ -0,0 +1,274 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+//go:build !vnc_minimal
+
+package vnc
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	// encryptedRecordingMagic starts every encrypted recording segment.
+	encryptedRecordingMagic = "VNCENC\x00\x01"
+
+	// recordingSaltSize is the size of the random salt from which the key of
+	// a segment is derived.
+	recordingSaltSize = 16
+
+	// recordingChunkSize is the largest plaintext sealed in one chunk.
+	recordingChunkSize = 64 << 10
+
+	// recordingKeyInfo is the HKDF info of segment keys.
+	recordingKeyInfo = "go-vnc recording segment"
+)
+
+// RecordingKeyProvider supplies the keys that encrypt recording segments
+// started with WithRecordingEncryption. It is the hook for key management
+// services: RecordingKey can return a data key generated by the service along
+// with the wrapped form of that key as the key ID, which is stored in the
+// clear in the segment so DecryptRecording can have the service unwrap it.
+type RecordingKeyProvider interface {
+	// RecordingKey returns the AES key of a segment, 16, 24, or 32 bytes
+	// long, and an ID of at most 65535 bytes that identifies it.
+	RecordingKey(info SegmentInfo) (key, keyID []byte, err error)
+}
+
+// RecordingKeyFunc adapts a function to a RecordingKeyProvider.
+type RecordingKeyFunc func(info SegmentInfo) (key, keyID []byte, err error)
+
+// RecordingKey calls f.
+func (f RecordingKeyFunc) RecordingKey(info SegmentInfo) (key, keyID []byte, err error) {
+	return f(info)
+}
+
+// StaticRecordingKey returns a RecordingKeyProvider that encrypts every
+// segment with key, identified by an empty key ID. Each segment still gets
+// its own key, derived from key and a random salt.
+func StaticRecordingKey(key []byte) RecordingKeyProvider {
+	return RecordingKeyFunc(func(SegmentInfo) ([]byte, []byte, error) {
+		return key, nil, nil
+	})
+}
+
+// WithRecordingEncryption encrypts every segment with AES-GCM, using keys
+// from keys, before it reaches the sink:
+//
+//	key := make([]byte, 32) // from a secret store
+//	rec, err := client.StartRecording(&vnc.FileSink{Path: "session.fbs.enc"},
+//		vnc.WithRecordingEncryption(vnc.StaticRecordingKey(key)))
+//
+// DecryptRecording restores the FBS data. Segments are sealed in chunks as
+// messages are recorded, so a segment cut short by a crash decrypts up to
+// its last complete chunk but is reported as truncated. Annotations handed to
+// an AnnotationSink are not encrypted.
+func WithRecordingEncryption(keys RecordingKeyProvider) RecordingOption {
+	return func(cfg *recordingConfig) {
+		cfg.keys = keys
+	}
+}
+
+// recordingAEAD derives the AES-GCM cipher of a segment from its key and
+// salt.
+func recordingAEAD(key, salt []byte) (cipher.AEAD, error) {
+	switch len(key) {
+	case 16, 24, 32:
+	default:
+		return nil, fmt.Errorf("invalid AES key size %d", len(key))
+	}
+	segmentKey, err := hkdf.Key(sha256.New, key, salt, recordingKeyInfo, len(key))
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(segmentKey)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// recordingNonce returns the nonce of the n-th chunk. Segment keys are never
+// reused, so a counter is a safe nonce.
+func recordingNonce(aead cipher.AEAD, n uint64) []byte {
+	nonce := make([]byte, aead.NonceSize())
+	binary.BigEndian.PutUint64(nonce[len(nonce)-8:], n)
+	return nonce
+}
+
+// recordingAAD returns the additional data of a chunk: the segment header,
+// so the key ID cannot be swapped, and whether the chunk is the last one, so
+// truncation is detected.
+func recordingAAD(header []byte, final bool) []byte {
+	aad := append([]byte(nil), header...)
+	if final {
+		return append(aad, 1)
+	}
+	return append(aad, 0)
+}
+
+// encryptingWriter seals a segment as a header followed by chunks, each a
+// big-endian uint32 length and the sealed data. Close writes an empty final
+// chunk and closes the underlying writer.
+type encryptingWriter struct {
+	w      io.WriteCloser
+	aead   cipher.AEAD
+	header []byte
+	chunks uint64
+}
+
+// newEncryptingWriter writes the header of a segment encrypted with key to
+// w.
+func newEncryptingWriter(w io.WriteCloser, key, keyID []byte) (*encryptingWriter, error) {
+	if len(keyID) > 0xffff {
+		return nil, fmt.Errorf("key ID of %d bytes exceeds 65535", len(keyID))
+	}
+	salt := make([]byte, recordingSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	aead, err := recordingAEAD(key, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	header := []byte(encryptedRecordingMagic)
+	header = append(header, salt...)
+	header = binary.BigEndian.AppendUint16(header, uint16(len(keyID))) // #nosec G115 - Checked above
+	header = append(header, keyID...)
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+	return &encryptingWriter{w: w, aead: aead, header: header}, nil
+}
+
+// Write seals p in chunks of at most recordingChunkSize bytes.
+func (e *encryptingWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := min(len(p), recordingChunkSize)
+		if err := e.seal(p[:n], false); err != nil {
+			return written, err
+		}
+		written += n
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// Close writes the final chunk and closes the underlying writer.
+func (e *encryptingWriter) Close() error {
+	err := e.seal(nil, true)
+	if closeErr := e.w.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// seal writes one chunk.
+func (e *encryptingWriter) seal(p []byte, final bool) error {
+	chunk := make([]byte, 4, 4+len(p)+e.aead.Overhead())
+	chunk = e.aead.Seal(chunk, recordingNonce(e.aead, e.chunks), p, recordingAAD(e.header, final))
+	binary.BigEndian.PutUint32(chunk, uint32(len(chunk)-4)) // #nosec G115 - Chunks are at most recordingChunkSize plus the tag
+	e.chunks++
+	_, err := e.w.Write(chunk)
+	return err
+}
+
+// DecryptRecording returns a reader of the FBS data of a segment encrypted
+// with WithRecordingEncryption. key returns the key the RecordingKeyProvider
+// gave for the key ID stored in the segment. Reads fail with an
+// ErrAuthentication error if the data was modified or the key is wrong, and
+// with io.ErrUnexpectedEOF if the segment ends before its final chunk.
+func DecryptRecording(r io.Reader, key func(keyID []byte) ([]byte, error)) (io.Reader, error) {
+	var fixed [len(encryptedRecordingMagic) + recordingSaltSize + 2]byte
+	fields, err := readFields(r, "recording header", fixed[:])
+	if err != nil {
+		return nil, validationError("DecryptRecording", "failed to read recording header", err)
+	}
+	if !bytes.HasPrefix(fixed[:], []byte(encryptedRecordingMagic)) {
+		return nil, validationError("DecryptRecording", "not an encrypted recording", nil)
+	}
+	fields.skip(len(encryptedRecordingMagic) + recordingSaltSize)
+	keyID, err := readBytes(r, "key ID", int(fields.uint16()))
+	if err != nil {
+		return nil, validationError("DecryptRecording", "failed to read key ID", err)
+	}
+
+	k, err := key(keyID)
+	if err != nil {
+		return nil, authenticationError("DecryptRecording", "failed to get recording key", err)
+	}
+	salt := fixed[len(encryptedRecordingMagic) : len(encryptedRecordingMagic)+recordingSaltSize]
+	aead, err := recordingAEAD(k, salt)
+	if err != nil {
+		return nil, configurationError("DecryptRecording", "invalid recording key", err)
+	}
+	return &decryptingReader{r: r, aead: aead, header: append(fixed[:], keyID...)}, nil
+}
+
+// decryptingReader opens the chunks written by an encryptingWriter.
+type decryptingReader struct {
+	r      io.Reader
+	aead   cipher.AEAD
+	header []byte
+	chunks uint64
+	buf    []byte
+	err    error
+}
+
+// Read returns decrypted data, opening chunks as needed.
+func (d *decryptingReader) Read(p []byte) (int, error) {
+	for len(d.buf) == 0 && d.err == nil {
+		d.err = d.open()
+	}
+	if len(d.buf) > 0 {
+		n := copy(p, d.buf)
+		d.buf = d.buf[n:]
+		return n, nil
+	}
+	return 0, d.err
+}
+
+// open reads and opens the next chunk, returning io.EOF after the final one.
+func (d *decryptingReader) open() error {
+	var length [4]byte
+	if _, err := io.ReadFull(d.r, length[:]); err != nil {
+		if errors.Is(err, io.EOF) {
+			return io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	size := int(binary.BigEndian.Uint32(length[:]))
+	if size < d.aead.Overhead() || size > recordingChunkSize+d.aead.Overhead() {
+		return validationError("DecryptRecording", fmt.Sprintf("invalid chunk length %d", size), nil)
+	}
+	sealed := make([]byte, size)
+	if _, err := io.ReadFull(d.r, sealed); err != nil {
+		if errors.Is(err, io.EOF) {
+			return io.ErrUnexpectedEOF
+		}
+		return err
+	}
+
+	nonce := recordingNonce(d.aead, d.chunks)
+	d.chunks++
+	if plain, err := d.aead.Open(nil, nonce, sealed, recordingAAD(d.header, false)); err == nil {
+		d.buf = plain
+		return nil
+	}
+	if _, err := d.aead.Open(nil, nonce, sealed, recordingAAD(d.header, true)); err == nil {
+		return io.EOF
+	}
+	return authenticationError("DecryptRecording",
+		fmt.Sprintf("chunk %d failed authentication", d.chunks-1), nil)
+}
@@ -0,0 +1,135 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+//go:build !vnc_minimal
+
+package vnc
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+// encryptSegment encrypts data as a recording segment with key and keyID.
+func encryptSegment(t *testing.T, key, keyID []byte, data ...[]byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := newEncryptingWriter(nopCloser{&buf}, key, keyID)
+	if err != nil {
+		t.Fatalf("newEncryptingWriter() error = %v", err)
+	}
+	for _, p := range data {
+		if _, err := w.Write(p); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	return buf.Bytes()
+}
+
+// decryptSegment decrypts a segment with key, returning what it read and the
+// first error other than io.EOF.
+func decryptSegment(segment, key []byte) ([]byte, error) {
+	r, err := DecryptRecording(bytes.NewReader(segment), func([]byte) ([]byte, error) { return key, nil })
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+func TestRecordingEncryption_RoundTrip(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, 32)
+	large := bytes.Repeat([]byte("frame"), recordingChunkSize/2)
+	segment := encryptSegment(t, key, []byte("wrapped-key"), []byte("FBS 001.000\n"), large)
+
+	if bytes.Contains(segment, []byte("FBS 001.000")) {
+		t.Error("segment contains plaintext")
+	}
+
+	var gotID []byte
+	r, err := DecryptRecording(bytes.NewReader(segment), func(keyID []byte) ([]byte, error) {
+		gotID = keyID
+		return key, nil
+	})
+	if err != nil {
+		t.Fatalf("DecryptRecording() error = %v", err)
+	}
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll() error = %v", err)
+	}
+	if string(gotID) != "wrapped-key" {
+		t.Errorf("key ID = %q, want %q", gotID, "wrapped-key")
+	}
+	if want := append([]byte("FBS 001.000\n"), large...); !bytes.Equal(got, want) {
+		t.Errorf("decrypted %d bytes, want %d", len(got), len(want))
+	}
+}
+
+func TestRecordingEncryption_Tampering(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, 16)
+	segment := encryptSegment(t, key, []byte("id"), []byte("first"), []byte("second"))
+
+	if _, err := decryptSegment(segment, bytes.Repeat([]byte{8}, 16)); !IsVNCError(err, ErrAuthentication) {
+		t.Errorf("wrong key error = %v, want an authentication error", err)
+	}
+
+	modified := bytes.Clone(segment)
+	modified[len(encryptedRecordingMagic)+recordingSaltSize+2] = 'X' // key ID
+	if _, err := decryptSegment(modified, key); !IsVNCError(err, ErrAuthentication) {
+		t.Errorf("modified key ID error = %v, want an authentication error", err)
+	}
+
+	// Dropping the final chunk, an empty one, leaves the data chunks intact.
+	truncated := segment[:len(segment)-4-16]
+	got, err := decryptSegment(truncated, key)
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("truncated segment error = %v, want io.ErrUnexpectedEOF", err)
+	}
+	if string(got) != "firstsecond" {
+		t.Errorf("truncated segment decrypted to %q, want the complete chunks", got)
+	}
+
+	if _, err := DecryptRecording(bytes.NewReader([]byte("FBS 001.000\nRFB 003.008\n....")), nil); !IsVNCError(err, ErrValidation) {
+		t.Errorf("plain FBS error = %v, want a validation error", err)
+	}
+}
+
+func TestRecordingEncryption_Recording(t *testing.T) {
+	_, conn := newUpdateServer(t, 4, 3)
+	sink := &memorySink{}
+	key := bytes.Repeat([]byte{1}, 32)
+
+	if _, err := conn.StartRecording(sink, WithRecordingEncryption(StaticRecordingKey(key[:5]))); !IsVNCError(err, ErrConfiguration) {
+		t.Fatalf("StartRecording() with a short key error = %v, want a configuration error", err)
+	}
+
+	rec, err := conn.StartRecording(sink, WithRecordingEncryption(StaticRecordingKey(key)))
+	if err != nil {
+		t.Fatalf("StartRecording failed: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := conn.Screenshot(ctx); err != nil {
+		t.Fatalf("Screenshot failed: %v", err)
+	}
+	if err := rec.Stop(); err != nil {
+		t.Fatalf("Stop failed: %v", err)
+	}
+
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	data, err := decryptSegment(sink.segments[len(sink.segments)-1].Bytes(), key)
+	if err != nil {
+		t.Fatalf("decrypting the segment failed: %v", err)
+	}
+	if blocks := readFBS(t, data); len(blocks) < 2 || !bytes.HasPrefix(blocks[0], []byte("RFB 003.003\n")) {
+		t.Errorf("decrypted segment has %d blocks, want a handshake and an update", len(blocks))
+	}
+}
@@ -0,0 +1,213 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+//go:build !vnc_minimal
+
+package vnc
+
+import (
+	"context"
+	"io"
+	"slices"
+	"sync"
+)
+
+// WithObservers lets watch-only observers join the recording while it runs,
+// with Recording.Observe. The recording keeps the server messages received
+// since the last full update it requested, so a late observer starts from a
+// complete picture; once they exceed maxBacklog bytes, and twice the size of
+// the desktop as Raw pixels so that a full update alone never does, it
+// requests another full update and starts the backlog over.
+func WithObservers(maxBacklog int64) RecordingOption {
+	return func(cfg *recordingConfig) {
+		cfg.maxBacklog = maxBacklog
+	}
+}
+
+// RecordingObserver is a watch-only view of a running recording, created by
+// Recording.Observe. It receives the server messages of the session from the
+// last full update on, which proxy.Proxy.ServeObserver relays to a viewer so
+// that a supervisor can join a session in progress without the server or the
+// other viewers noticing.
+//
+// The messages are those of the recorded session, in its pixel format and
+// encodings; the caveats of StartRecording about encodings that depend on
+// earlier data apply.
+type RecordingObserver struct {
+	// Width, Height, PixelFormat, and DesktopName describe the desktop at
+	// the start of the first message, as a ServerInit does.
+	Width       uint16
+	Height      uint16
+	PixelFormat PixelFormat
+	DesktopName string
+
+	rec    *Recording
+	limit  int64
+	notify chan struct{}
+
+	mu     sync.Mutex
+	queue  [][]byte
+	queued int64
+	err    error
+}
+
+// Observe attaches an observer to the recording. It fails unless the
+// recording was started with WithObservers and is still running. The
+// observer must be closed when no longer needed.
+func (r *Recording) Observe() (*RecordingObserver, error) {
+	if r.cfg.maxBacklog <= 0 {
+		return nil, configurationError("Recording.Observe", "the recording was not started with WithObservers", nil)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.stopped {
+		return nil, validationError("Recording.Observe", "the recording has stopped", nil)
+	}
+
+	o := &RecordingObserver{
+		Width:       r.backlogStart.Width,
+		Height:      r.backlogStart.Height,
+		PixelFormat: r.backlogStart.PixelFormat,
+		DesktopName: r.backlogStart.DesktopName,
+		rec:         r,
+		limit:       2 * max(r.backlogLimit(), r.backlogSize),
+		notify:      make(chan struct{}, 1),
+		queue:       slices.Clone(r.backlog),
+		queued:      r.backlogSize,
+	}
+	if r.observers == nil {
+		r.observers = make(map[*RecordingObserver]struct{})
+	}
+	r.observers[o] = struct{}{}
+	return o, nil
+}
+
+// Next returns the next server message, as sent on the wire, waiting for one
+// until ctx ends. It returns io.EOF once the recording has stopped and every
+// message has been returned, and an error if the observer fell so far behind
+// that it was cut off; the session itself never waits for observers.
+func (o *RecordingObserver) Next(ctx context.Context) ([]byte, error) {
+	for {
+		o.mu.Lock()
+		if len(o.queue) > 0 {
+			msg := o.queue[0]
+			o.queue[0] = nil
+			o.queue = o.queue[1:]
+			o.queued -= int64(len(msg))
+			o.mu.Unlock()
+			return msg, nil
+		}
+		err := o.err
+		o.mu.Unlock()
+		if err != nil {
+			return nil, err
+		}
+
+		select {
+		case <-o.notify:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Close detaches the observer from the recording.
+func (o *RecordingObserver) Close() {
+	o.rec.mu.Lock()
+	delete(o.rec.observers, o)
+	o.rec.mu.Unlock()
+	o.end(io.EOF)
+}
+
+// push queues a message, cutting the observer off if its queue is full.
+func (o *RecordingObserver) push(msg []byte) {
+	o.mu.Lock()
+	switch {
+	case o.err != nil:
+	case o.queued+int64(len(msg)) > o.limit:
+		o.queue, o.queued = nil, 0
+		o.err = networkError("RecordingObserver.Next", "observer fell behind the session", nil)
+	default:
+		o.queue = append(o.queue, msg)
+		o.queued += int64(len(msg))
+	}
+	o.mu.Unlock()
+	o.wake()
+}
+
+// end ends the observer with err after its queued messages.
+func (o *RecordingObserver) end(err error) {
+	o.mu.Lock()
+	if o.err == nil {
+		o.err = err
+	}
+	o.mu.Unlock()
+	o.wake()
+}
+
+// wake signals a waiting Next.
+func (o *RecordingObserver) wake() {
+	select {
+	case o.notify <- struct{}{}:
+	default:
+	}
+}
+
+// backlogStart describes the desktop where the backlog starts.
+type backlogStart struct {
+	Width, Height uint16
+	PixelFormat   PixelFormat
+	DesktopName   string
+}
+
+// resetBacklog starts the backlog over ahead of a full update request.
+func (r *Recording) resetBacklog() {
+	if r.cfg.maxBacklog <= 0 {
+		return
+	}
+	width, height := r.c.GetFrameBufferSize()
+	start := backlogStart{
+		Width:       width,
+		Height:      height,
+		PixelFormat: r.c.GetPixelFormat(),
+		DesktopName: r.c.GetDesktopName(),
+	}
+
+	r.mu.Lock()
+	r.backlog, r.backlogSize, r.backlogStart = nil, 0, start
+	r.mu.Unlock()
+}
+
+// observe adds the committed message to the backlog and sends it to the
+// observers, reporting whether the backlog has outgrown its limit. The
+// caller holds r.mu.
+func (r *Recording) observe() bool {
+	if r.cfg.maxBacklog <= 0 {
+		return false
+	}
+	msg := slices.Clone(r.message.Bytes())
+	r.backlog = append(r.backlog, msg)
+	r.backlogSize += int64(len(msg))
+	for o := range r.observers {
+		o.push(msg)
+	}
+	return r.backlogSize > r.backlogLimit()
+}
+
+// backlogLimit returns the backlog size past which a full update is
+// requested. The caller holds r.mu.
+func (r *Recording) backlogLimit() int64 {
+	start := r.backlogStart
+	frameSize := int64(start.Width) * int64(start.Height) * int64(start.PixelFormat.BPP/8)
+	return max(r.cfg.maxBacklog, 2*frameSize)
+}
+
+// endObservers ends every observer with err. The caller holds r.mu.
+func (r *Recording) endObservers(err error) {
+	for o := range r.observers {
+		o.end(err)
+	}
+	r.observers = nil
+	r.backlog, r.backlogSize = nil, 0
+}
@@ -0,0 +1,328 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+//go:build !vnc_minimal
+
+package vnc
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memorySink records segments into memory.
+type memorySink struct {
+	mu       sync.Mutex
+	segments []*bytes.Buffer
+}
+
+// nopCloser adds a no-op Close to a buffer.
+type nopCloser struct{ *bytes.Buffer }
+
+func (nopCloser) Close() error { return nil }
+
+func (s *memorySink) NextSegment(info SegmentInfo) (io.WriteCloser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	buf := &bytes.Buffer{}
+	s.segments = append(s.segments, buf)
+	return nopCloser{buf}, nil
+}
+
+// readFBS splits an FBS segment into its blocks.
+func readFBS(t *testing.T, data []byte) [][]byte {
+	t.Helper()
+
+	if !bytes.HasPrefix(data, []byte(fbsHeader)) {
+		t.Fatalf("segment does not start with the FBS header: %q", data[:min(len(data), 12)])
+	}
+	data = data[len(fbsHeader):]
+
+	var blocks [][]byte
+	for len(data) > 0 {
+		if len(data) < 4 {
+			t.Fatalf("truncated block length")
+		}
+		size := int(binary.BigEndian.Uint32(data))
+		padded := (size + 3) &^ 3
+		if len(data) < 8+padded {
+			t.Fatalf("truncated block of %d bytes", size)
+		}
+		blocks = append(blocks, data[4:4+size])
+		data = data[8+padded:]
+	}
+	return blocks
+}
+
+func TestRecording_Segment(t *testing.T) {
+	_, conn := newUpdateServer(t, 4, 3)
+	sink := &memorySink{}
+
+	rec, err := conn.StartRecording(sink)
+	if err != nil {
+		t.Fatalf("StartRecording failed: %v", err)
+	}
+	if _, err := conn.StartRecording(sink); err == nil {
+		t.Error("expected an error starting a second recording")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := conn.Screenshot(ctx); err != nil {
+		t.Fatalf("Screenshot failed: %v", err)
+	}
+	if err := rec.Stop(); err != nil {
+		t.Fatalf("Stop failed: %v", err)
+	}
+
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	if len(sink.segments) != 1 {
+		t.Fatalf("expected 1 segment, got %d", len(sink.segments))
+	}
+	blocks := readFBS(t, sink.segments[0].Bytes())
+	if len(blocks) < 2 {
+		t.Fatalf("expected a handshake and an update, got %d blocks", len(blocks))
+	}
+	if !bytes.HasPrefix(blocks[0], []byte("RFB 003.003\n")) {
+		t.Errorf("first block is not a handshake: %q", blocks[0])
+	}
+	// Handshake: version, security type, and ServerInit with a 4x3 desktop.
+	if got := binary.BigEndian.Uint16(blocks[0][16:]); got != 4 {
+		t.Errorf("handshake width = %d, want 4", got)
+	}
+	update := blocks[1]
+	if update[0] != 0 {
+		t.Errorf("second block has message type %d, want 0", update[0])
+	}
+	// Type, padding, rectangle count, rectangle header, and 4x3 raw pixels.
+	if want := 4 + 12 + 4*3*4; len(update) != want {
+		t.Errorf("update block has %d bytes, want %d", len(update), want)
+	}
+}
+
+func TestRecording_SegmentLimit(t *testing.T) {
+	_, conn := newUpdateServer(t, 4, 3)
+	sink := &memorySink{}
+
+	rec, err := conn.StartRecording(sink, WithSegmentMaxSize(1))
+	if err != nil {
+		t.Fatalf("StartRecording failed: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := conn.Screenshot(ctx); err != nil {
+		t.Fatalf("Screenshot failed: %v", err)
+	}
+	if err := rec.Stop(); err != nil {
+		t.Fatalf("Stop failed: %v", err)
+	}
+
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	if len(sink.segments) < 2 {
+		t.Fatalf("expected several segments, got %d", len(sink.segments))
+	}
+	for i, segment := range sink.segments {
+		blocks := readFBS(t, segment.Bytes())
+		if len(blocks) == 0 || !bytes.HasPrefix(blocks[0], []byte("RFB 003.003\n")) {
+			t.Errorf("segment %d does not start with a handshake", i)
+		}
+	}
+}
+
+func TestRecording_RollingFileSink(t *testing.T) {
+	dir := t.TempDir()
+	sink := &RollingFileSink{Dir: dir, Prefix: "session", MaxSegments: 2}
+
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	for i := range 4 {
+		w, err := sink.NextSegment(SegmentInfo{Index: i, Start: start})
+		if err != nil {
+			t.Fatalf("NextSegment(%d) failed: %v", i, err)
+		}
+		if _, err := io.WriteString(w, fbsHeader); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	want := []string{"session-20240501T120000Z-0002.fbs", "session-20240501T120000Z-0003.fbs"}
+	if !slices.Equal(names, want) {
+		t.Errorf("segments = %v, want %v", names, want)
+	}
+
+	if _, err := (&FileSink{Path: dir + "/single.fbs"}).NextSegment(SegmentInfo{Index: 1}); err == nil {
+		t.Error("expected FileSink to reject a second segment")
+	}
+}
+
+func TestRecording_WriteWebVTT(t *testing.T) {
+	var buf bytes.Buffer
+	annotations := []RecordingAnnotation{
+		{Offset: 1500 * time.Millisecond, Text: "login complete"},
+		{Offset: time.Hour + 2*time.Minute + 3*time.Second, Text: "report <ready> & saved"},
+		{Offset: time.Hour + 2*time.Minute + 3*time.Second, Text: "done"},
+	}
+	if err := WriteWebVTT(&buf, annotations, 2*time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "WEBVTT\n" +
+		"\n1\n00:00:01.500 --> 01:02:03.000\nlogin complete\n" +
+		"\n2\n01:02:03.000 --> 01:02:03.001\nreport &lt;ready&gt; &amp; saved\n" +
+		"\n3\n01:02:03.000 --> 02:00:00.000\ndone\n"
+	if got := buf.String(); got != want {
+		t.Errorf("WriteWebVTT() =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestRecording_Annotations(t *testing.T) {
+	_, conn := newUpdateServer(t, 4, 3)
+	path := t.TempDir() + "/session.fbs"
+
+	rec, err := conn.StartRecording(&FileSink{Path: path})
+	if err != nil {
+		t.Fatalf("StartRecording failed: %v", err)
+	}
+	conn.Annotate("login complete")
+	if err := rec.Stop(); err != nil {
+		t.Fatalf("Stop failed: %v", err)
+	}
+	conn.Annotate("after recording")
+
+	vtt, err := os.ReadFile(path + ".vtt")
+	if err != nil {
+		t.Fatalf("annotations were not written: %v", err)
+	}
+	if !bytes.Contains(vtt, []byte("\nlogin complete\n")) || bytes.Contains(vtt, []byte("after recording")) {
+		t.Errorf("unexpected annotations:\n%s", vtt)
+	}
+	if got := len(conn.Stats().Annotations); got != 2 {
+		t.Errorf("Stats has %d annotations, want 2", got)
+	}
+}
+
+func TestRecording_Observers(t *testing.T) {
+	_, conn := newUpdateServer(t, 4, 3)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	plain, err := conn.StartRecording(&memorySink{})
+	if err != nil {
+		t.Fatalf("StartRecording failed: %v", err)
+	}
+	if _, err := plain.Observe(); !IsVNCError(err, ErrConfiguration) {
+		t.Errorf("Observe() without WithObservers error = %v, want a configuration error", err)
+	}
+	if err := plain.Stop(); err != nil {
+		t.Fatalf("Stop failed: %v", err)
+	}
+
+	rec, err := conn.StartRecording(&memorySink{}, WithObservers(1<<20))
+	if err != nil {
+		t.Fatalf("StartRecording failed: %v", err)
+	}
+	if _, err := conn.Screenshot(ctx); err != nil {
+		t.Fatalf("Screenshot failed: %v", err)
+	}
+
+	obs, err := rec.Observe()
+	if err != nil {
+		t.Fatalf("Observe() error = %v", err)
+	}
+	defer obs.Close()
+	if obs.Width != 4 || obs.Height != 3 || obs.DesktopName != "updates" {
+		t.Errorf("observer desktop = %dx%d %q, want 4x3 %q", obs.Width, obs.Height, obs.DesktopName, "updates")
+	}
+	obs.mu.Lock()
+	backlog := len(obs.queue)
+	obs.mu.Unlock()
+	if backlog == 0 {
+		t.Fatal("observer joined without the backlog")
+	}
+
+	// A later update reaches the observer live.
+	if _, err := conn.Screenshot(ctx); err != nil {
+		t.Fatalf("Screenshot failed: %v", err)
+	}
+	if err := rec.Stop(); err != nil {
+		t.Fatalf("Stop failed: %v", err)
+	}
+
+	var messages int
+	for {
+		msg, err := obs.Next(ctx)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Next() error = %v", err)
+		}
+		if msg[0] != 0 {
+			t.Errorf("message type = %d, want a FramebufferUpdate", msg[0])
+		}
+		messages++
+	}
+	if messages <= backlog {
+		t.Errorf("observer received %d messages, want more than the backlog of %d", messages, backlog)
+	}
+	if _, err := rec.Observe(); !IsVNCError(err, ErrValidation) {
+		t.Errorf("Observe() after Stop error = %v, want a validation error", err)
+	}
+}
+
+func TestRecording_ObserverBacklogLimit(t *testing.T) {
+	_, conn := newUpdateServer(t, 4, 3)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// A Raw full update of the 4x3 desktop is 64 bytes, and the backlog is
+	// restarted past twice the 48 bytes of its pixels.
+	rec, err := conn.StartRecording(&memorySink{}, WithObservers(1))
+	if err != nil {
+		t.Fatalf("StartRecording failed: %v", err)
+	}
+	defer rec.Stop()
+	for range 5 {
+		if _, err := conn.Screenshot(ctx); err != nil {
+			t.Fatalf("Screenshot failed: %v", err)
+		}
+	}
+
+	rec.mu.Lock()
+	backlog := len(rec.backlog)
+	rec.mu.Unlock()
+	if backlog >= 5 {
+		t.Errorf("backlog holds %d updates, want it restarted once over the limit", backlog)
+	}
+}
+
+func TestRecording_ObserverFallsBehind(t *testing.T) {
+	o := &RecordingObserver{limit: 10, notify: make(chan struct{}, 1)}
+	o.push(make([]byte, 8))
+	o.push(make([]byte, 8))
+
+	if _, err := o.Next(context.Background()); !IsVNCError(err, ErrNetwork) {
+		t.Errorf("Next() error = %v, want a network error after falling behind", err)
+	}
+}
@@ -0,0 +1,550 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package vnc
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// replayPixelFormat is the 32-bit little-endian true color format announced by
+// replay streams in ServerInit.
+var replayPixelFormat = PixelFormat{
+	BPP: 32, Depth: 24, BigEndian: false, TrueColor: true,
+	RedMax: 255, GreenMax: 255, BlueMax: 255,
+	RedShift: 16, GreenShift: 8, BlueShift: 0,
+}
+
+// replayStream builds a byte-exact server-to-client stream.
+type replayStream struct {
+	buf bytes.Buffer
+}
+
+// write appends big-endian encoded values to the stream.
+func (s *replayStream) write(values ...interface{}) *replayStream {
+	for _, v := range values {
+		if err := binary.Write(&s.buf, binary.BigEndian, v); err != nil {
+			panic(err)
+		}
+	}
+	return s
+}
+
+// serverInit appends the ServerInit message.
+func (s *replayStream) serverInit(width, height uint16, name string) *replayStream {
+	pf, err := writePixelFormat(&replayPixelFormat)
+	if err != nil {
+		panic(err)
+	}
+	s.write(width, height, pf, uint32(len(name)), []byte(name)) // #nosec G115 - Test data
+	return s
+}
+
+// pixel appends a single pixel in replayPixelFormat.
+func (s *replayStream) pixel(r, g, b uint8) *replayStream {
+	return s.write([]byte{b, g, r, 0})
+}
+
+// rect appends a rectangle header.
+func (s *replayStream) rect(x, y, w, h uint16, encoding int32) *replayStream {
+	return s.write(x, y, w, h, encoding)
+}
+
+// bytes returns the accumulated stream.
+func (s *replayStream) bytes() []byte {
+	return s.buf.Bytes()
+}
+
+// replayCase describes a captured session and the expected client behavior.
+type replayCase struct {
+	name string
+
+	// handshake is the stream up to and including ServerInit.
+	handshake []byte
+
+	// messages is the stream of server messages replayed after the client
+	// has configured its encodings.
+	messages []byte
+
+	encodings []Encoding
+
+	wantMessages  []string
+	wantWidth     uint16
+	wantHeight    uint16
+	wantName      string
+	wantHash      string
+	check         func(t *testing.T, c *ClientConn)
+	checkMessages func(t *testing.T, msgs []ServerMessage)
+}
+
+// runReplay replays a capture through a full ClientConn over net.Pipe and
+// returns the connection together with the messages delivered to the channel.
+func runReplay(t *testing.T, tc replayCase, options ...ClientOption) (*ClientConn, []ServerMessage) {
+	t.Helper()
+
+	serverConn, clientConn := net.Pipe()
+	t.Cleanup(func() {
+		serverConn.Close()
+	})
+
+	// Drain everything the client writes so it never blocks on the pipe.
+	go func() {
+		_, _ = io.Copy(io.Discard, serverConn)
+	}()
+
+	ready := make(chan struct{})
+	go func() {
+		if _, err := serverConn.Write(tc.handshake); err != nil {
+			return
+		}
+		<-ready
+		_, _ = serverConn.Write(tc.messages)
+	}()
+
+	msgCh := make(chan ServerMessage, len(tc.wantMessages)+1)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	options = append([]ClientOption{
+		WithAuth(&ClientAuthNone{}),
+		WithServerMessageChannel(msgCh),
+	}, options...)
+
+	conn, err := ClientWithOptions(ctx, clientConn, options...)
+	if err != nil {
+		close(ready)
+		t.Fatalf("handshake failed: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = conn.CloseAndWait()
+	})
+
+	if len(tc.encodings) > 0 {
+		if err := conn.SetEncodings(tc.encodings); err != nil {
+			t.Fatalf("SetEncodings failed: %v", err)
+		}
+	}
+	close(ready)
+
+	received := make([]ServerMessage, 0, len(tc.wantMessages))
+	timeout := time.After(5 * time.Second)
+	for len(received) < len(tc.wantMessages) {
+		select {
+		case msg, ok := <-msgCh:
+			if !ok {
+				t.Fatalf("message channel closed after %d messages", len(received))
+			}
+			received = append(received, msg)
+		case <-timeout:
+			t.Fatalf("timed out after %d of %d messages", len(received), len(tc.wantMessages))
+		}
+	}
+
+	return conn, received
+}
+
+// replayFramebuffer is a reference framebuffer that applies decoded rectangles.
+type replayFramebuffer struct {
+	width, height uint16
+	pixels        []Color
+}
+
+// newReplayFramebuffer creates a black framebuffer of the given size.
+func newReplayFramebuffer(width, height uint16) *replayFramebuffer {
+	return &replayFramebuffer{
+		width:  width,
+		height: height,
+		pixels: make([]Color, int(width)*int(height)),
+	}
+}
+
+// fill paints a solid rectangle.
+func (fb *replayFramebuffer) fill(x, y, w, h int, color Color) {
+	for row := y; row < y+h; row++ {
+		for col := x; col < x+w; col++ {
+			fb.pixels[row*int(fb.width)+col] = color
+		}
+	}
+}
+
+// apply renders every rectangle of an update into the framebuffer.
+func (fb *replayFramebuffer) apply(update *FramebufferUpdateMessage) {
+	for _, rect := range update.Rectangles {
+		x, y, w := int(rect.X), int(rect.Y), int(rect.Width)
+
+		switch enc := rect.Enc.(type) {
+		case *RawEncoding:
+			for i, color := range enc.Colors {
+				fb.pixels[(y+i/w)*int(fb.width)+x+i%w] = color
+			}
+		case *CopyRectEncoding:
+			src := make([]Color, len(fb.pixels))
+			copy(src, fb.pixels)
+			for row := 0; row < int(rect.Height); row++ {
+				for col := 0; col < w; col++ {
+					fb.pixels[(y+row)*int(fb.width)+x+col] =
+						src[(int(enc.SrcY)+row)*int(fb.width)+int(enc.SrcX)+col]
+				}
+			}
+		case *RREEncoding:
+			fb.fill(x, y, w, int(rect.Height), enc.BackgroundColor)
+			for _, sub := range enc.Subrectangles {
+				fb.fill(x+int(sub.X), y+int(sub.Y), int(sub.Width), int(sub.Height), sub.Color)
+			}
+		case *HextileEncoding:
+			tilesX := (w + HextileTileSize - 1) / HextileTileSize
+			for i, tile := range enc.Tiles {
+				tx := x + (i%tilesX)*HextileTileSize
+				ty := y + (i/tilesX)*HextileTileSize
+				if tile.Colors != nil {
+					for p, color := range tile.Colors {
+						fb.pixels[(ty+p/int(tile.Width))*int(fb.width)+tx+p%int(tile.Width)] = color
+					}
+					continue
+				}
+				fb.fill(tx, ty, int(tile.Width), int(tile.Height), tile.Background)
+				for _, sub := range tile.Subrectangles {
+					fb.fill(tx+int(sub.X), ty+int(sub.Y), int(sub.Width), int(sub.Height), sub.Color)
+				}
+			}
+		case *DesktopSizePseudoEncoding:
+			*fb = *newReplayFramebuffer(enc.Width, enc.Height)
+		}
+	}
+}
+
+// hash returns a hex-encoded SHA-256 of the framebuffer contents.
+func (fb *replayFramebuffer) hash() string {
+	h := sha256.New()
+	_ = binary.Write(h, binary.BigEndian, fb.width)
+	_ = binary.Write(h, binary.BigEndian, fb.height)
+	for _, p := range fb.pixels {
+		_ = binary.Write(h, binary.BigEndian, p)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// replayHandshake returns a None-auth handshake ending with ServerInit.
+func replayHandshake(width, height uint16, name string) []byte {
+	s := &replayStream{}
+	s.write([]byte("RFB 003.008\n"))
+	s.write(uint8(1), uint8(1)) // One security type: None
+	s.write(uint32(0))          // SecurityResult OK
+	s.serverInit(width, height, name)
+	return s.bytes()
+}
+
+// replayMixedSession returns a capture exercising every standard message type
+// and encoding.
+func replayMixedSession() []byte {
+	s := &replayStream{}
+
+	// FramebufferUpdate: 4x2 Raw rectangle at the origin.
+	s.write(uint8(0), uint8(0), uint16(1))
+	s.rect(0, 0, 4, 2, 0)
+	for i := 0; i < 8; i++ {
+		s.pixel(uint8(i*30), uint8(255-i*30), 0x80)
+	}
+
+	// SetColorMapEntries: two entries starting at index 5.
+	s.write(uint8(1), uint8(0), uint16(5), uint16(2))
+	s.write(uint16(0xffff), uint16(0), uint16(0))
+	s.write(uint16(0), uint16(0xffff), uint16(0))
+
+	// Bell.
+	s.write(uint8(2))
+
+	// ServerCutText.
+	s.write(uint8(3), []byte{0, 0, 0}, uint32(5), []byte("hello"))
+
+	// FramebufferUpdate: CopyRect, RRE and Hextile rectangles.
+	s.write(uint8(0), uint8(0), uint16(3))
+	s.rect(4, 0, 4, 2, 1).write(uint16(0), uint16(0))
+	s.rect(0, 2, 4, 2, 2).write(uint32(1))
+	s.pixel(0x10, 0x20, 0x30)
+	s.pixel(0xff, 0xff, 0xff).write(uint16(1), uint16(0), uint16(2), uint16(2))
+	s.rect(4, 2, 4, 2, 5)
+	s.write(uint8(HextileBackgroundSpecified | HextileForegroundSpecified | HextileAnySubrects))
+	s.pixel(0x00, 0x00, 0xff)
+	s.pixel(0xff, 0x00, 0x00)
+	s.write(uint8(1), uint8(0x11), uint8(0x10))
+
+	return s.bytes()
+}
+
+// TestReplay_MainLoop replays captured server streams through the complete
+// dispatch loop and verifies messages, connection state and rendered output.
+func TestReplay_MainLoop(t *testing.T) {
+	resize := &replayStream{}
+	resize.write(uint8(0), uint8(0), uint16(1))
+	resize.rect(0, 0, 16, 8, -223)
+
+	tests := []replayCase{
+		{
+			name:      "Mixed session",
+			handshake: replayHandshake(8, 4, "replay"),
+			messages:  replayMixedSession(),
+			encodings: []Encoding{&HextileEncoding{}, &CopyRectEncoding{}, &RREEncoding{}, &RawEncoding{}},
+			wantMessages: []string{
+				"*vnc.FramebufferUpdateMessage",
+				"*vnc.SetColorMapEntriesMessage",
+				"*vnc.BellMessage",
+				"*vnc.ServerCutTextMessage",
+				"*vnc.FramebufferUpdateMessage",
+			},
+			wantWidth:  8,
+			wantHeight: 4,
+			wantName:   "replay",
+			wantHash:   "aa344f164c1c8a50dfc2ae4668c5b422ee564b429caecdd7f78a75e54bc6dc83",
+			check: func(t *testing.T, c *ClientConn) {
+				if got := c.ColorMap[5]; got != (Color{R: 0xffff}) {
+					t.Errorf("ColorMap[5] = %+v, want red", got)
+				}
+				if got := c.ColorMap[6]; got != (Color{G: 0xffff}) {
+					t.Errorf("ColorMap[6] = %+v, want green", got)
+				}
+				if got := c.GetColorMap(); got != c.ColorMap {
+					t.Error("GetColorMap() differs from the ColorMap mirror")
+				}
+
+				stats := c.Stats()
+				if stats.FramebufferUpdates != 2 {
+					t.Errorf("FramebufferUpdates = %d, want 2", stats.FramebufferUpdates)
+				}
+				wantEncodings := map[int32]EncodingStats{
+					0: {Rectangles: 1, WireBytes: 32, DecodedBytes: 32},
+					1: {Rectangles: 1, WireBytes: 4, DecodedBytes: 32},
+					2: {Rectangles: 1, WireBytes: 20, DecodedBytes: 32},
+					5: {Rectangles: 1, WireBytes: 12, DecodedBytes: 32},
+				}
+				for encType, want := range wantEncodings {
+					got := stats.Encodings[encType]
+					got.DecodeTime, got.MaxDecodeTime = 0, 0
+					if got != want {
+						t.Errorf("Encodings[%d] = %+v, want %+v", encType, got, want)
+					}
+				}
+			},
+			checkMessages: func(t *testing.T, msgs []ServerMessage) {
+				if text := msgs[3].(*ServerCutTextMessage).Text; text != "hello" {
+					t.Errorf("cut text = %q, want %q", text, "hello")
+				}
+			},
+		},
+		{
+			name:      "Desktop resize",
+			handshake: replayHandshake(8, 4, "resize"),
+			messages:  resize.bytes(),
+			encodings: []Encoding{&DesktopSizePseudoEncoding{}, &RawEncoding{}},
+			wantMessages: []string{
+				"*vnc.FramebufferUpdateMessage",
+			},
+			wantWidth:  16,
+			wantHeight: 8,
+			wantName:   "resize",
+			wantHash:   "1e8eb26d957cf2c34bdeddbe0d8e7fdbfd0605fb0072e477d740e6598ed4d0c9",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			conn, received := runReplay(t, tc)
+
+			fb := newReplayFramebuffer(8, 4)
+			for i, msg := range received {
+				if got := fmt.Sprintf("%T", msg); got != tc.wantMessages[i] {
+					t.Errorf("message %d = %s, want %s", i, got, tc.wantMessages[i])
+				}
+				if update, ok := msg.(*FramebufferUpdateMessage); ok {
+					fb.apply(update)
+				}
+			}
+
+			width, height := conn.GetFrameBufferSize()
+			if width != tc.wantWidth || height != tc.wantHeight {
+				t.Errorf("framebuffer size = %dx%d, want %dx%d", width, height, tc.wantWidth, tc.wantHeight)
+			}
+			if name := conn.GetDesktopName(); name != tc.wantName {
+				t.Errorf("desktop name = %q, want %q", name, tc.wantName)
+			}
+			if got := fb.hash(); got != tc.wantHash {
+				t.Errorf("framebuffer hash = %s, want %s", got, tc.wantHash)
+			}
+			if tc.check != nil {
+				tc.check(t, conn)
+			}
+			if tc.checkMessages != nil {
+				tc.checkMessages(t, received)
+			}
+		})
+	}
+}
+
+// TestReplay_Ordering checks the delivery order of resizes, color map
+// changes, and updates, with and without frame pacing.
+func TestReplay_Ordering(t *testing.T) {
+	s := &replayStream{}
+	s.write(uint8(0), uint8(0), uint16(1))
+	s.rect(0, 0, 4, 2, 0)
+	for i := 0; i < 8; i++ {
+		s.pixel(0x10, 0x20, 0x30)
+	}
+	// The Raw rectangle is only within the framebuffer after the resize.
+	s.write(uint8(0), uint8(0), uint16(2))
+	s.rect(0, 0, 8, 4, -223)
+	s.rect(4, 2, 4, 2, 0)
+	for i := 0; i < 8; i++ {
+		s.pixel(0x40, 0x50, 0x60)
+	}
+	s.write(uint8(1), uint8(0), uint16(0), uint16(1), uint16(0xffff), uint16(0), uint16(0))
+	s.write(uint8(0), uint8(0), uint16(1))
+	s.rect(6, 3, 2, 1, 0)
+	s.pixel(0x70, 0x80, 0x90).pixel(0x70, 0x80, 0x90)
+
+	tc := replayCase{
+		handshake: replayHandshake(4, 2, "ordering"),
+		messages:  s.bytes(),
+		encodings: []Encoding{&DesktopSizePseudoEncoding{}, &RawEncoding{}},
+		wantMessages: []string{
+			"*vnc.FramebufferUpdateMessage",
+			"*vnc.FramebufferUpdateMessage",
+			"*vnc.SetColorMapEntriesMessage",
+			"*vnc.FramebufferUpdateMessage",
+		},
+	}
+	wantSizes := [][2]uint16{{4, 2}, {8, 4}, {}, {8, 4}}
+
+	for name, options := range map[string][]ClientOption{
+		"unpaced": nil,
+		"paced":   {WithMaxFrameRate(5)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, received := runReplay(t, tc, options...)
+			for i, msg := range received {
+				if got := fmt.Sprintf("%T", msg); got != tc.wantMessages[i] {
+					t.Fatalf("message %d = %s, want %s", i, got, tc.wantMessages[i])
+				}
+				update, ok := msg.(*FramebufferUpdateMessage)
+				if !ok {
+					continue
+				}
+				if got := [2]uint16{update.Width, update.Height}; got != wantSizes[i] {
+					t.Errorf("message %d size = %dx%d, want %dx%d", i, got[0], got[1], wantSizes[i][0], wantSizes[i][1])
+				}
+			}
+
+			rects := received[1].(*FramebufferUpdateMessage).Rectangles
+			if len(rects) != 2 {
+				t.Fatalf("resize update has %d rectangles, want 2", len(rects))
+			}
+			if _, ok := rects[0].Enc.(*DesktopSizePseudoEncoding); !ok {
+				t.Errorf("first rectangle = %T, want the resize ahead of the pixels", rects[0].Enc)
+			}
+		})
+	}
+}
+
+// TestReplay_EarlyServerData replays captures of servers that send data before
+// the session is set up, which QuirkEarlyServerData must absorb.
+func TestReplay_EarlyServerData(t *testing.T) {
+	t.Run("Update before SetPixelFormat", func(t *testing.T) {
+		// The server sends a red Raw update in its own 32-bit format right
+		// after ServerInit, then answers in the client's 16-bit format.
+		early := &replayStream{}
+		early.write(replayHandshake(4, 2, "early"))
+		early.write(uint8(0), uint8(0), uint16(1)).rect(0, 0, 4, 2, 0)
+		for range 8 {
+			early.pixel(0xff, 0, 0)
+		}
+		later := &replayStream{}
+		later.write(uint8(0), uint8(0), uint16(1)).rect(0, 0, 2, 1, 0)
+		later.write([]byte{0x00, 0xf8, 0x00, 0xf8})
+
+		_, msgs := runReplay(t, replayCase{
+			handshake:    early.bytes(),
+			messages:     later.bytes(),
+			wantMessages: []string{"*vnc.FramebufferUpdateMessage", "*vnc.FramebufferUpdateMessage"},
+		}, WithQuirks(QuirkEarlyServerData), WithPixelFormat(PixelFormat16BitRGB565))
+
+		var colors []Color
+		for i, msg := range msgs {
+			update := msg.(*FramebufferUpdateMessage)
+			raw, ok := update.Rectangles[0].Enc.(*RawEncoding)
+			if !ok || len(raw.Colors) != int(update.Rectangles[0].Width)*int(update.Rectangles[0].Height) {
+				t.Fatalf("update %d = %+v, want a whole Raw rectangle", i, update.Rectangles[0])
+			}
+			colors = append(colors, raw.Colors...)
+		}
+		for i, color := range colors {
+			if color.R == 0 || color.G != 0 || color.B != 0 {
+				t.Errorf("pixel %d = %+v, want red", i, color)
+			}
+		}
+	})
+
+	t.Run("SecurityResult after None in RFB 3.7", func(t *testing.T) {
+		// RFB 3.7 has no SecurityResult for the None security type, but the
+		// server sends one before ServerInit.
+		handshake := &replayStream{}
+		handshake.write([]byte("RFB 003.007\n"))
+		handshake.write(uint8(1), uint8(1), uint32(0))
+		handshake.serverInit(8, 4, "stray")
+
+		conn, _ := runReplay(t, replayCase{
+			handshake:    handshake.bytes(),
+			messages:     []byte{2},
+			wantMessages: []string{"*vnc.BellMessage"},
+		}, WithQuirks(QuirkEarlyServerData), WithProtocolVersion("RFB 003.007"))
+
+		if width, height := conn.GetFrameBufferSize(); width != 8 || height != 4 {
+			t.Errorf("framebuffer size = %dx%d, want 8x4", width, height)
+		}
+		if name := conn.GetDesktopName(); name != "stray" {
+			t.Errorf("desktop name = %q, want %q", name, "stray")
+		}
+	})
+}
+
+// TestReplay_Vino replays a Vino session: RFB 3.7 with the TLS security type
+// listed first, a SecurityResult after None, and empty cut text sent as a
+// keep-alive between the messages the application wants.
+func TestReplay_Vino(t *testing.T) {
+	handshake := &replayStream{}
+	handshake.write([]byte("RFB 003.007\n"))
+	handshake.write(uint8(2), uint8(18), uint8(1), uint32(0))
+	handshake.serverInit(8, 4, "vino")
+
+	keepAlive := []byte{3, 0, 0, 0, 0, 0, 0, 0}
+	messages := &replayStream{}
+	messages.write(keepAlive, uint8(2), keepAlive)
+	messages.write(uint8(3), [3]byte{}, uint32(4), []byte("copy"))
+	messages.write(keepAlive, uint8(2))
+
+	conn, msgs := runReplay(t, replayCase{
+		handshake:    handshake.bytes(),
+		messages:     messages.bytes(),
+		wantMessages: []string{"*vnc.BellMessage", "*vnc.ServerCutTextMessage", "*vnc.BellMessage"},
+	}, ForVino())
+
+	if _, minor := conn.ProtocolVersion(); minor != 7 {
+		t.Errorf("protocol version 3.%d, want 3.7", minor)
+	}
+	if width, height := conn.GetFrameBufferSize(); width != 8 || height != 4 {
+		t.Errorf("framebuffer size = %dx%d, want 8x4", width, height)
+	}
+	if cut, ok := msgs[1].(*ServerCutTextMessage); !ok || cut.Text != "copy" {
+		t.Errorf("second message = %+v, want the cut text %q", msgs[1], "copy")
+	}
+	if _, ok := msgs[2].(*BellMessage); !ok {
+		t.Errorf("third message = %T, want the bell after the last keep-alive", msgs[2])
+	}
+}
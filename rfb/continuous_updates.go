@@ -0,0 +1,72 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package rfb
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+// Message types of the ContinuousUpdates extension. Clients and servers use
+// the same number for different messages.
+const (
+	// EnableContinuousUpdatesMsg is the client message that starts or stops
+	// continuous updates.
+	EnableContinuousUpdatesMsg uint8 = 150
+
+	// EndOfContinuousUpdatesMsg is the server message that confirms
+	// PseudoEncodingContinuousUpdates and marks the end of continuous
+	// updates after the client disables them.
+	EndOfContinuousUpdatesMsg uint8 = 150
+)
+
+// PseudoEncodingContinuousUpdates announces support for continuous updates.
+// The server confirms it by sending an EndOfContinuousUpdates message.
+const PseudoEncodingContinuousUpdates int32 = -313
+
+// EnableContinuousUpdates is the body of an EnableContinuousUpdates message.
+// While enabled, the server sends the changes within the area without
+// waiting for FramebufferUpdateRequest messages.
+type EnableContinuousUpdates struct {
+	Enable              bool
+	X, Y, Width, Height uint16
+}
+
+// WriteEnableContinuousUpdates writes an EnableContinuousUpdates message.
+func WriteEnableContinuousUpdates(w io.Writer, m EnableContinuousUpdates) error {
+	var buf [10]byte
+	buf[0] = EnableContinuousUpdatesMsg
+	if m.Enable {
+		buf[1] = 1
+	}
+	binary.BigEndian.PutUint16(buf[2:], m.X)
+	binary.BigEndian.PutUint16(buf[4:], m.Y)
+	binary.BigEndian.PutUint16(buf[6:], m.Width)
+	binary.BigEndian.PutUint16(buf[8:], m.Height)
+	_, err := w.Write(buf[:])
+	return err
+}
+
+// ReadEnableContinuousUpdates reads the body of an EnableContinuousUpdates
+// message.
+func ReadEnableContinuousUpdates(r io.Reader) (EnableContinuousUpdates, error) {
+	var buf [9]byte
+	if _, err := io.ReadFull(r, buf[:]); err != nil {
+		return EnableContinuousUpdates{}, err
+	}
+	return EnableContinuousUpdates{
+		Enable: buf[0] != 0,
+		X:      binary.BigEndian.Uint16(buf[1:]),
+		Y:      binary.BigEndian.Uint16(buf[3:]),
+		Width:  binary.BigEndian.Uint16(buf[5:]),
+		Height: binary.BigEndian.Uint16(buf[7:]),
+	}, nil
+}
+
+// WriteEndOfContinuousUpdates writes an EndOfContinuousUpdates message, which
+// has no body.
+func WriteEndOfContinuousUpdates(w io.Writer) error {
+	_, err := w.Write([]byte{EndOfContinuousUpdatesMsg})
+	return err
+}
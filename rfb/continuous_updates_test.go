@@ -0,0 +1,38 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package rfb
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestContinuousUpdates_RoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	want := EnableContinuousUpdates{Enable: true, X: 1, Y: 2, Width: 640, Height: 480}
+	if err := WriteEnableContinuousUpdates(&buf, want); err != nil {
+		t.Fatal(err)
+	}
+
+	wire := []byte{150, 1, 0, 1, 0, 2, 2, 128, 1, 224}
+	if !bytes.Equal(buf.Bytes(), wire) {
+		t.Fatalf("EnableContinuousUpdates encoded as %v, want %v", buf.Bytes(), wire)
+	}
+
+	readBody(t, &buf, EnableContinuousUpdatesMsg)
+	got, err := ReadEnableContinuousUpdates(&buf)
+	if err != nil || got != want {
+		t.Fatalf("ReadEnableContinuousUpdates() = %+v, %v", got, err)
+	}
+}
+
+func TestContinuousUpdates_EndOfContinuousUpdates(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteEndOfContinuousUpdates(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), []byte{EndOfContinuousUpdatesMsg}) {
+		t.Fatalf("EndOfContinuousUpdates encoded as %v", buf.Bytes())
+	}
+}
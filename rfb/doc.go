@@ -0,0 +1,53 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+// Package rfb implements the wire format of the Remote Framebuffer protocol
+// (RFB) as specified in RFC 6143.
+//
+// The package provides the framing and handshake primitives that the vnc
+// package is built on: protocol version exchange, security negotiation,
+// initialization messages, and encoders and decoders for the client-to-server
+// and server-to-client messages. It performs no I/O scheduling, holds no
+// connection state, and does not decode rectangle payloads, which makes it
+// suitable for custom clients, servers, and proxies that need full control over
+// the protocol flow. Most applications should use the high-level vnc.ClientConn
+// instead.
+//
+// Every function operates on an io.Reader or io.Writer, typically a net.Conn.
+// Each message is written with a single Write call so that concurrent writers
+// that serialize their calls do not interleave partial messages. Readers return
+// io.EOF and io.ErrUnexpectedEOF unchanged so callers can detect closed
+// connections.
+//
+// # Handshake
+//
+// A minimal client handshake using only this package:
+//
+//	major, minor, err := rfb.ReadProtocolVersion(conn)
+//	if err != nil || major != 3 || minor < 8 {
+//		return err
+//	}
+//	if err := rfb.WriteProtocolVersion(conn, 3, 8); err != nil {
+//		return err
+//	}
+//	types, err := rfb.ReadSecurityTypes(conn)
+//	if err != nil {
+//		return err
+//	}
+//	if !slices.Contains(types, rfb.SecurityNone) {
+//		return errors.New("no supported security type")
+//	}
+//	if err := rfb.WriteSecurityType(conn, rfb.SecurityNone); err != nil {
+//		return err
+//	}
+//	if err := rfb.ReadSecurityResult(conn); err != nil {
+//		return err
+//	}
+//	if err := rfb.WriteClientInit(conn, true); err != nil {
+//		return err
+//	}
+//	init, err := rfb.ReadServerInit(conn)
+//
+// After the handshake, ReadMessageType identifies the next message and the
+// matching Read function decodes its body.
+package rfb
@@ -0,0 +1,88 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package rfb
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+// FenceMsg is the message type of Fence messages, which clients and servers
+// both send once the client has announced PseudoEncodingFence.
+const FenceMsg uint8 = 248
+
+// PseudoEncodingFence announces support for Fence messages. The server
+// confirms it by sending a Fence request.
+const PseudoEncodingFence int32 = -312
+
+// Fence flags. The receiver of a fence with FenceRequest set sends it back
+// with FenceRequest cleared and only the other flags it supports.
+const (
+	// FenceBlockBefore asks the receiver to finish processing the messages
+	// received before the fence before handling it.
+	FenceBlockBefore uint32 = 1 << 0
+
+	// FenceBlockAfter asks the receiver not to process the messages received
+	// after the fence until it has responded.
+	FenceBlockAfter uint32 = 1 << 1
+
+	// FenceSyncNext asks the receiver to handle the message following the
+	// fence at the same point as the fence itself.
+	FenceSyncNext uint32 = 1 << 2
+
+	// FenceRequest marks a fence that must be answered.
+	FenceRequest uint32 = 1 << 31
+
+	// FenceFlagsMask holds every defined flag.
+	FenceFlagsMask = FenceBlockBefore | FenceBlockAfter | FenceSyncNext | FenceRequest
+)
+
+// MaxFencePayload is the largest payload a Fence message carries.
+const MaxFencePayload = 64
+
+// Fence is the body of a Fence message. The payload is opaque to the
+// receiver, which returns it unchanged in its response.
+type Fence struct {
+	Flags   uint32
+	Payload []byte
+}
+
+// WriteFence writes a Fence message.
+func WriteFence(w io.Writer, f Fence) error {
+	if len(f.Payload) > MaxFencePayload {
+		return &LengthError{Field: "fence payload", Length: uint32(len(f.Payload)), Max: MaxFencePayload} // #nosec G115 - bounded by comparison
+	}
+
+	buf := make([]byte, 4, 9+len(f.Payload))
+	buf[0] = FenceMsg
+	buf = binary.BigEndian.AppendUint32(buf, f.Flags)
+	buf = append(buf, byte(len(f.Payload))) // #nosec G115 - bounded by MaxFencePayload
+	buf = append(buf, f.Payload...)
+	_, err := w.Write(buf)
+	return err
+}
+
+// ReadFence reads the body of a Fence message.
+func ReadFence(r io.Reader) (Fence, error) {
+	var header [8]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return Fence{}, err
+	}
+	n := header[7]
+	if n > MaxFencePayload {
+		return Fence{}, &LengthError{Field: "fence payload", Length: uint32(n), Max: MaxFencePayload}
+	}
+
+	f := Fence{Flags: binary.BigEndian.Uint32(header[3:7]), Payload: make([]byte, n)}
+	if _, err := io.ReadFull(r, f.Payload); err != nil {
+		return Fence{}, err
+	}
+	return f, nil
+}
+
+// Response returns the response to the fence request f from a receiver
+// supporting the flags in supported.
+func (f Fence) Response(supported uint32) Fence {
+	return Fence{Flags: f.Flags & supported &^ FenceRequest, Payload: f.Payload}
+}
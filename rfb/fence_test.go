@@ -0,0 +1,48 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package rfb
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestFence_RoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	want := Fence{Flags: FenceRequest | FenceBlockBefore, Payload: []byte("rtt")}
+	if err := WriteFence(&buf, want); err != nil {
+		t.Fatal(err)
+	}
+
+	wire := []byte{248, 0, 0, 0, 0x80, 0, 0, 1, 3, 'r', 't', 't'}
+	if !bytes.Equal(buf.Bytes(), wire) {
+		t.Fatalf("Fence encoded as %v, want %v", buf.Bytes(), wire)
+	}
+
+	readBody(t, &buf, FenceMsg)
+	got, err := ReadFence(&buf)
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Fatalf("ReadFence() = %+v, %v", got, err)
+	}
+
+	resp := got.Response(FenceBlockBefore | FenceBlockAfter)
+	if resp.Flags != FenceBlockBefore || !bytes.Equal(resp.Payload, want.Payload) {
+		t.Errorf("Response() = %+v, want the payload with FenceBlockBefore", resp)
+	}
+}
+
+func TestFence_PayloadLimit(t *testing.T) {
+	var lengthErr *LengthError
+	err := WriteFence(&bytes.Buffer{}, Fence{Payload: make([]byte, MaxFencePayload+1)})
+	if !errors.As(err, &lengthErr) {
+		t.Fatalf("WriteFence with a long payload = %v, want a *LengthError", err)
+	}
+
+	body := []byte{0, 0, 0, 0, 0, 0, 0, MaxFencePayload + 1}
+	if _, err := ReadFence(bytes.NewReader(body)); !errors.As(err, &lengthErr) {
+		t.Fatalf("ReadFence with a long payload = %v, want a *LengthError", err)
+	}
+}
@@ -0,0 +1,366 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package rfb
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ProtocolVersionLength is the length of a ProtocolVersion message in bytes.
+const ProtocolVersionLength = 12
+
+// Security types defined by RFC 6143 Section 7.1.2.
+const (
+	SecurityInvalid uint8 = 0
+	SecurityNone    uint8 = 1
+	SecurityVNCAuth uint8 = 2
+
+	// SecurityRA2 and SecurityRA2ne are the RSA-AES security types of
+	// RealVNC and TigerVNC, which authenticate over AES-128 and keep or drop
+	// the encryption for the rest of the session.
+	SecurityRA2   uint8 = 5
+	SecurityRA2ne uint8 = 6
+
+	// SecurityRA2ne256 is the RSA-AES security type of RealVNC with AES-256
+	// that drops the encryption after authentication.
+	SecurityRA2ne256 uint8 = 13
+
+	// SecurityTLS is the anonymous TLS wrapper of older vino and QEMU
+	// servers, which repeat the security type negotiation inside it.
+	SecurityTLS uint8 = 18
+
+	// SecurityVeNCrypt is the IANA-registered TLS wrapper used by TigerVNC,
+	// QEMU, and libvirt.
+	SecurityVeNCrypt uint8 = 19
+
+	// SecuritySASL is the SASL authentication of QEMU and libvirt.
+	SecuritySASL uint8 = 20
+
+	// SecurityARD is the Diffie-Hellman based Apple Remote Desktop
+	// authentication required by macOS Screen Sharing.
+	SecurityARD uint8 = 30
+
+	// SecurityUltraMSLogonII is the MS-Logon II authentication of UltraVNC,
+	// which checks the credentials of a Windows account.
+	SecurityUltraMSLogonII uint8 = 113
+
+	// SecurityRA256 and SecurityRAne256 are the RSA-AES security types with
+	// AES-256.
+	SecurityRA256   uint8 = 129
+	SecurityRAne256 uint8 = 130
+)
+
+// VeNCrypt sub-types, the schemes negotiated inside the VeNCrypt security
+// type. The TLS sub-types use anonymous TLS and the X509 sub-types TLS with a
+// server certificate; each is followed by no authentication, VNC
+// authentication, or a plain username and password.
+const (
+	VeNCryptPlain     uint32 = 256
+	VeNCryptTLSNone   uint32 = 257
+	VeNCryptTLSVnc    uint32 = 258
+	VeNCryptTLSPlain  uint32 = 259
+	VeNCryptX509None  uint32 = 260
+	VeNCryptX509Vnc   uint32 = 261
+	VeNCryptX509Plain uint32 = 262
+)
+
+// Limits applied to variable-length strings read from the wire.
+const (
+	MaxReasonLength      = 64 * 1024
+	MaxDesktopNameLength = 1024 * 1024
+)
+
+// ErrNoSecurityTypes is returned by WriteSecurityTypes when no types are given.
+var ErrNoSecurityTypes = errors.New("rfb: no security types")
+
+// FailureError reports a failure announced by the peer together with the
+// reason string it sent.
+type FailureError struct {
+	// Op is the handshake step that failed.
+	Op string
+
+	// Reason is the human-readable reason sent by the peer.
+	Reason string
+}
+
+// Error returns the formatted failure message.
+func (e *FailureError) Error() string {
+	return fmt.Sprintf("rfb: %s failed: %s", e.Op, e.Reason)
+}
+
+// LengthError is returned when a length field exceeds the allowed maximum.
+type LengthError struct {
+	// Field names the length-prefixed value.
+	Field string
+
+	// Length is the length announced on the wire.
+	Length uint32
+
+	// Max is the largest accepted length.
+	Max uint32
+}
+
+// Error returns the formatted length message.
+func (e *LengthError) Error() string {
+	return fmt.Sprintf("rfb: %s length %d exceeds maximum %d", e.Field, e.Length, e.Max)
+}
+
+// ParseProtocolVersion parses a 12-byte ProtocolVersion message of the form
+// "RFB xxx.yyy\n".
+func ParseProtocolVersion(pv []byte) (major, minor uint, err error) {
+	if len(pv) != ProtocolVersionLength {
+		return 0, 0, fmt.Errorf("rfb: protocol version must be %d bytes, got %d", ProtocolVersionLength, len(pv))
+	}
+	if string(pv[:4]) != "RFB " || pv[7] != '.' || pv[11] != '\n' {
+		return 0, 0, fmt.Errorf("rfb: malformed protocol version %q", pv)
+	}
+	for _, i := range []int{4, 5, 6, 8, 9, 10} {
+		if pv[i] < '0' || pv[i] > '9' {
+			return 0, 0, fmt.Errorf("rfb: malformed protocol version %q", pv)
+		}
+	}
+
+	for _, b := range pv[4:7] {
+		major = major*10 + uint(b-'0')
+	}
+	for _, b := range pv[8:11] {
+		minor = minor*10 + uint(b-'0')
+	}
+	return major, minor, nil
+}
+
+// FormatProtocolVersion returns the ProtocolVersion message for major.minor.
+func FormatProtocolVersion(major, minor uint) []byte {
+	return []byte(fmt.Sprintf("RFB %03d.%03d\n", major%1000, minor%1000))
+}
+
+// ReadProtocolVersion reads and parses a ProtocolVersion message.
+func ReadProtocolVersion(r io.Reader) (major, minor uint, err error) {
+	var pv [ProtocolVersionLength]byte
+	if _, err := io.ReadFull(r, pv[:]); err != nil {
+		return 0, 0, err
+	}
+	return ParseProtocolVersion(pv[:])
+}
+
+// WriteProtocolVersion writes a ProtocolVersion message.
+func WriteProtocolVersion(w io.Writer, major, minor uint) error {
+	_, err := w.Write(FormatProtocolVersion(major, minor))
+	return err
+}
+
+// ReadSecurityTypes reads the list of security types offered by the server.
+// An empty list is reported by the server as a failure with a reason, which is
+// returned as a *FailureError.
+func ReadSecurityTypes(r io.Reader) ([]uint8, error) {
+	var count [1]byte
+	if _, err := io.ReadFull(r, count[:]); err != nil {
+		return nil, err
+	}
+
+	if count[0] == 0 {
+		reason, err := ReadReason(r)
+		if err != nil {
+			return nil, err
+		}
+		return nil, &FailureError{Op: "security negotiation", Reason: reason}
+	}
+
+	types := make([]uint8, count[0])
+	if _, err := io.ReadFull(r, types); err != nil {
+		return nil, err
+	}
+	return types, nil
+}
+
+// WriteSecurityTypes writes the list of security types offered by a server.
+func WriteSecurityTypes(w io.Writer, types []uint8) error {
+	if len(types) == 0 {
+		return ErrNoSecurityTypes
+	}
+	if len(types) > 255 {
+		return fmt.Errorf("rfb: too many security types: %d", len(types))
+	}
+
+	buf := make([]byte, 0, 1+len(types))
+	buf = append(buf, uint8(len(types))) // #nosec G115 - length checked above
+	buf = append(buf, types...)
+	_, err := w.Write(buf)
+	return err
+}
+
+// WriteSecurityFailure reports to the client that no security type is
+// available, together with the reason.
+func WriteSecurityFailure(w io.Writer, reason string) error {
+	buf := make([]byte, 0, 5+len(reason))
+	buf = append(buf, 0)
+	buf = appendReason(buf, reason)
+	_, err := w.Write(buf)
+	return err
+}
+
+// ReadSecurityType reads the security type selected by the client.
+func ReadSecurityType(r io.Reader) (uint8, error) {
+	var t [1]byte
+	if _, err := io.ReadFull(r, t[:]); err != nil {
+		return 0, err
+	}
+	return t[0], nil
+}
+
+// WriteSecurityType writes the security type selected by the client.
+func WriteSecurityType(w io.Writer, securityType uint8) error {
+	_, err := w.Write([]byte{securityType})
+	return err
+}
+
+// ReadSecurityResult reads a SecurityResult message. A failed result is
+// returned as a *FailureError carrying the server's reason.
+func ReadSecurityResult(r io.Reader) error {
+	var result [4]byte
+	if _, err := io.ReadFull(r, result[:]); err != nil {
+		return err
+	}
+
+	if binary.BigEndian.Uint32(result[:]) == 0 {
+		return nil
+	}
+
+	reason, err := ReadReason(r)
+	if err != nil {
+		return err
+	}
+	return &FailureError{Op: "security handshake", Reason: reason}
+}
+
+// WriteSecurityResult writes a SecurityResult message. A nil failure reports
+// success; otherwise its text is sent as the failure reason.
+func WriteSecurityResult(w io.Writer, failure error) error {
+	if failure == nil {
+		_, err := w.Write([]byte{0, 0, 0, 0})
+		return err
+	}
+
+	reason := failure.Error()
+	buf := make([]byte, 4, 8+len(reason))
+	binary.BigEndian.PutUint32(buf, 1)
+	buf = appendReason(buf, reason)
+	_, err := w.Write(buf)
+	return err
+}
+
+// ReadReason reads a length-prefixed reason string.
+func ReadReason(r io.Reader) (string, error) {
+	b, err := readLengthPrefixed(r, "reason", MaxReasonLength)
+	return string(b), err
+}
+
+// appendReason appends a length-prefixed reason string to buf.
+func appendReason(buf []byte, reason string) []byte {
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(reason))) // #nosec G115 - reason strings are short
+	return append(buf, reason...)
+}
+
+// ReadClientInit reads a ClientInit message and reports whether the client
+// requested a shared session.
+func ReadClientInit(r io.Reader) (shared bool, err error) {
+	var flag [1]byte
+	if _, err := io.ReadFull(r, flag[:]); err != nil {
+		return false, err
+	}
+	return flag[0] != 0, nil
+}
+
+// WriteClientInit writes a ClientInit message.
+func WriteClientInit(w io.Writer, shared bool) error {
+	_, err := w.Write([]byte{boolByte(shared)})
+	return err
+}
+
+// ServerInit is the ServerInit message sent at the end of the handshake.
+type ServerInit struct {
+	// Width is the framebuffer width in pixels.
+	Width uint16
+
+	// Height is the framebuffer height in pixels.
+	Height uint16
+
+	// PixelFormat is the server's natural pixel format.
+	PixelFormat PixelFormat
+
+	// Name is the desktop name.
+	Name string
+}
+
+// ReadServerInit reads a ServerInit message.
+func ReadServerInit(r io.Reader) (ServerInit, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return ServerInit{}, err
+	}
+
+	pf, err := ReadPixelFormat(r)
+	if err != nil {
+		return ServerInit{}, err
+	}
+
+	name, err := readLengthPrefixed(r, "desktop name", MaxDesktopNameLength)
+	if err != nil {
+		return ServerInit{}, err
+	}
+
+	return ServerInit{
+		Width:       binary.BigEndian.Uint16(header[0:2]),
+		Height:      binary.BigEndian.Uint16(header[2:4]),
+		PixelFormat: pf,
+		Name:        string(name),
+	}, nil
+}
+
+// WriteServerInit writes a ServerInit message.
+func WriteServerInit(w io.Writer, init ServerInit) error {
+	if len(init.Name) > MaxDesktopNameLength {
+		return &LengthError{Field: "desktop name", Length: uint32(len(init.Name)), Max: MaxDesktopNameLength} // #nosec G115 - bounded by comparison
+	}
+
+	buf := make([]byte, 0, 24+len(init.Name))
+	buf = binary.BigEndian.AppendUint16(buf, init.Width)
+	buf = binary.BigEndian.AppendUint16(buf, init.Height)
+	buf = init.PixelFormat.append(buf)
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(init.Name))) // #nosec G115 - bounded by MaxDesktopNameLength
+	buf = append(buf, init.Name...)
+	_, err := w.Write(buf)
+	return err
+}
+
+// readLengthPrefixed reads a uint32 length followed by that many bytes,
+// rejecting lengths above max before allocating.
+func readLengthPrefixed(r io.Reader, field string, max uint32) ([]byte, error) {
+	var length [4]byte
+	if _, err := io.ReadFull(r, length[:]); err != nil {
+		return nil, err
+	}
+
+	n := binary.BigEndian.Uint32(length[:])
+	if n > max {
+		return nil, &LengthError{Field: field, Length: n, Max: max}
+	}
+
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// boolByte converts a boolean to its wire representation.
+func boolByte(b bool) byte {
+	if b {
+		return 1
+	}
+	return 0
+}
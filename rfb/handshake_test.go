@@ -0,0 +1,166 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package rfb
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestHandshake_ParseProtocolVersion(t *testing.T) {
+	tests := []struct {
+		name      string
+		input     string
+		wantMajor uint
+		wantMinor uint
+		wantErr   bool
+	}{
+		{name: "RFB 3.8", input: "RFB 003.008\n", wantMajor: 3, wantMinor: 8},
+		{name: "RFB 3.3", input: "RFB 003.003\n", wantMajor: 3, wantMinor: 3},
+		{name: "Too short", input: "RFB 003.008", wantErr: true},
+		{name: "Missing prefix", input: "VNC 003.008\n", wantErr: true},
+		{name: "Non-digit", input: "RFB 003.0x8\n", wantErr: true},
+		{name: "Missing newline", input: "RFB 003.008 ", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			major, minor, err := ParseProtocolVersion([]byte(tt.input))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseProtocolVersion() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if major != tt.wantMajor || minor != tt.wantMinor {
+				t.Errorf("ParseProtocolVersion() = %d.%d, want %d.%d", major, minor, tt.wantMajor, tt.wantMinor)
+			}
+		})
+	}
+}
+
+func TestHandshake_ProtocolVersionRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteProtocolVersion(&buf, 3, 8); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "RFB 003.008\n" {
+		t.Fatalf("WriteProtocolVersion() wrote %q", buf.String())
+	}
+
+	major, minor, err := ReadProtocolVersion(&buf)
+	if err != nil || major != 3 || minor != 8 {
+		t.Fatalf("ReadProtocolVersion() = %d.%d, %v", major, minor, err)
+	}
+}
+
+func TestHandshake_SecurityTypes(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteSecurityTypes(&buf, []uint8{SecurityNone, SecurityVNCAuth}); err != nil {
+		t.Fatal(err)
+	}
+
+	types, err := ReadSecurityTypes(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(types, []uint8{SecurityNone, SecurityVNCAuth}) {
+		t.Errorf("ReadSecurityTypes() = %v", types)
+	}
+
+	if err := WriteSecurityTypes(&buf, nil); !errors.Is(err, ErrNoSecurityTypes) {
+		t.Errorf("WriteSecurityTypes(nil) error = %v", err)
+	}
+}
+
+func TestHandshake_SecurityFailure(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteSecurityFailure(&buf, "too many connections"); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := ReadSecurityTypes(&buf)
+	var failure *FailureError
+	if !errors.As(err, &failure) {
+		t.Fatalf("expected FailureError, got %v", err)
+	}
+	if failure.Reason != "too many connections" {
+		t.Errorf("Reason = %q", failure.Reason)
+	}
+}
+
+func TestHandshake_SecurityResult(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteSecurityResult(&buf, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := ReadSecurityResult(&buf); err != nil {
+		t.Fatalf("ReadSecurityResult() error = %v", err)
+	}
+
+	if err := WriteSecurityResult(&buf, errors.New("bad password")); err != nil {
+		t.Fatal(err)
+	}
+	err := ReadSecurityResult(&buf)
+	var failure *FailureError
+	if !errors.As(err, &failure) || failure.Reason != "bad password" {
+		t.Fatalf("ReadSecurityResult() error = %v", err)
+	}
+}
+
+func TestHandshake_ReasonLengthLimit(t *testing.T) {
+	input := []byte{0xFF, 0xFF, 0xFF, 0xFF}
+	_, err := ReadReason(bytes.NewReader(input))
+
+	var lengthErr *LengthError
+	if !errors.As(err, &lengthErr) {
+		t.Fatalf("expected LengthError, got %v", err)
+	}
+}
+
+func TestHandshake_ServerInitRoundTrip(t *testing.T) {
+	want := ServerInit{
+		Width:  1024,
+		Height: 768,
+		PixelFormat: PixelFormat{
+			BPP: 32, Depth: 24, TrueColor: true,
+			RedMax: 255, GreenMax: 255, BlueMax: 255,
+			RedShift: 16, GreenShift: 8, BlueShift: 0,
+		},
+		Name: "desktop",
+	}
+
+	var buf bytes.Buffer
+	if err := WriteServerInit(&buf, want); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() != 24+len(want.Name) {
+		t.Fatalf("ServerInit encoded to %d bytes", buf.Len())
+	}
+
+	got, err := ReadServerInit(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Errorf("ReadServerInit() = %+v, want %+v", got, want)
+	}
+}
+
+func TestHandshake_ClientInit(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteClientInit(&buf, true); err != nil {
+		t.Fatal(err)
+	}
+	shared, err := ReadClientInit(&buf)
+	if err != nil || !shared {
+		t.Fatalf("ReadClientInit() = %v, %v", shared, err)
+	}
+}
+
+func TestHandshake_TruncatedInput(t *testing.T) {
+	_, err := ReadServerInit(bytes.NewReader([]byte{0, 1}))
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("ReadServerInit() error = %v, want io.ErrUnexpectedEOF", err)
+	}
+}
@@ -0,0 +1,504 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package rfb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Client-to-server message types defined by RFC 6143 Section 7.5.
+const (
+	SetPixelFormatMsg           uint8 = 0
+	SetEncodingsMsg             uint8 = 2
+	FramebufferUpdateRequestMsg uint8 = 3
+	KeyEventMsg                 uint8 = 4
+	PointerEventMsg             uint8 = 5
+	ClientCutTextMsg            uint8 = 6
+)
+
+// QEMUClientMsg is the message type of the QEMU client messages, whose
+// second byte selects the submessage, such as QEMUExtendedKeyEventSubtype.
+const QEMUClientMsg uint8 = 255
+
+// QEMUExtendedKeyEventSubtype is the QEMU client submessage carrying a key
+// event with its scancode.
+const QEMUExtendedKeyEventSubtype uint8 = 0
+
+// Server-to-client message types defined by RFC 6143 Section 7.6.
+const (
+	FramebufferUpdateMsg  uint8 = 0
+	SetColorMapEntriesMsg uint8 = 1
+	BellMsg               uint8 = 2
+	ServerCutTextMsg      uint8 = 3
+)
+
+// EncodingRaw sends pixels uncompressed. Every client and server supports it.
+const EncodingRaw int32 = 0
+
+// PseudoEncodingExtendedMouseButtons announces support for pointer events with
+// more than eight buttons. The server confirms it with an empty rectangle of
+// this encoding.
+const PseudoEncodingExtendedMouseButtons int32 = -316
+
+// PseudoEncodingXCursor sends the cursor shape as two colors and a bitmap,
+// independent of the pixel format.
+const PseudoEncodingXCursor int32 = -240
+
+// PseudoEncodingCursorWithAlpha sends the cursor shape as an RGBA image with
+// premultiplied alpha, superseding the bitmask of the Cursor pseudo-encoding.
+const PseudoEncodingCursorWithAlpha int32 = -314
+
+// VMware pseudo-encodings, sent by the VNC consoles of ESXi and VMware
+// Workstation. Unlike other pseudo-encodings they have positive numbers,
+// "WMV" followed by a letter in ASCII. VMwareCursor sends the cursor shape as
+// AND and XOR masks or as an RGBA image, VMwareCursorState its visibility,
+// and VMwareCursorPosition its position, given by the position of the
+// rectangle.
+const (
+	PseudoEncodingVMwareCursor         int32 = 0x574d5664
+	PseudoEncodingVMwareCursorState    int32 = 0x574d5665
+	PseudoEncodingVMwareCursorPosition int32 = 0x574d5666
+)
+
+// PseudoEncodingQEMUExtendedKeyEvent announces support for QEMU extended key
+// events. The server confirms it with an empty rectangle of this encoding.
+const PseudoEncodingQEMUExtendedKeyEvent int32 = -258
+
+// PseudoEncodingLEDState reports the state of the lock key LEDs of the
+// remote keyboard in a single byte: bit 0 is Scroll Lock, bit 1 Num Lock,
+// and bit 2 Caps Lock.
+const PseudoEncodingLEDState int32 = -261
+
+// PseudoEncodingDesktopSize announces a new framebuffer size, given by the
+// width and height of the rectangle.
+const PseudoEncodingDesktopSize int32 = -223
+
+// PseudoEncodingDesktopName announces a new desktop name, which servers send
+// when the desktop is renamed during the session.
+const PseudoEncodingDesktopName int32 = -307
+
+// PseudoEncodingExtendedDesktopSize reports desktop resizes together with the
+// layout of the screens of a multi-monitor desktop.
+const PseudoEncodingExtendedDesktopSize int32 = -308
+
+// PseudoEncodingLastRect marks the end of a FramebufferUpdate whose rectangle
+// count was not known when its header was sent. Such updates announce
+// LastRectUnknownCount rectangles.
+const PseudoEncodingLastRect int32 = -224
+
+// LastRectUnknownCount is the rectangle count of a FramebufferUpdate that is
+// terminated by a PseudoEncodingLastRect rectangle.
+const LastRectUnknownCount uint16 = 0xffff
+
+// Compressed encodings and the pseudo-encodings that tune Tight. The quality
+// and compression level pseudo-encodings are the level 0 values; levels 1 to
+// 9 follow them consecutively. TightPNG is a pixel encoding despite its
+// negative number. H264 carries H.264 video, as streamed by TigerVNC and
+// KasmVNC.
+const (
+	EncodingZlib                    int32 = 6
+	EncodingTight                   int32 = 7
+	EncodingTRLE                    int32 = 15
+	EncodingZRLE                    int32 = 16
+	EncodingH264                    int32 = 50
+	EncodingTightPNG                int32 = -260
+	PseudoEncodingJPEGQualityLevel0 int32 = -32
+	PseudoEncodingCompressionLevel0 int32 = -256
+)
+
+// extendedButtonFlag marks a PointerEvent that carries an extra mask byte.
+const extendedButtonFlag = 0x80
+
+// Limits applied to variable-length message bodies.
+const (
+	MaxEncodings     = 1024
+	MaxCutTextLength = 10 * 1024 * 1024
+)
+
+// FramebufferUpdateRequest is the body of a FramebufferUpdateRequest message.
+type FramebufferUpdateRequest struct {
+	Incremental bool
+	X, Y        uint16
+	Width       uint16
+	Height      uint16
+}
+
+// KeyEvent is the body of a KeyEvent message.
+type KeyEvent struct {
+	Down bool
+	Key  uint32
+}
+
+// ExtendedKeyEvent is the body of a QEMU extended key event, which carries
+// the XT scancode of the key along with its keysym. Scancodes of extended
+// keys, sent by keyboards with an 0xe0 prefix, have the high bit of their
+// low byte set, so Right Ctrl (0xe0 0x1d) is 0x9d.
+type ExtendedKeyEvent struct {
+	Down    bool
+	Keysym  uint32
+	Keycode uint32
+}
+
+// PointerEvent is the body of a PointerEvent message.
+type PointerEvent struct {
+	Mask uint8
+	X, Y uint16
+}
+
+// ExtendedPointerEvent is a PointerEvent with a 16-bit button mask, sent once
+// the server has confirmed the ExtendedMouseButtons pseudo-encoding
+// (PseudoEncodingExtendedMouseButtons). Bit 7 is button 8 (back) and bit 8 is
+// button 9 (forward).
+type ExtendedPointerEvent struct {
+	Mask uint16
+	X, Y uint16
+}
+
+// Rectangle is the header preceding each rectangle of a FramebufferUpdate.
+type Rectangle struct {
+	X, Y     uint16
+	Width    uint16
+	Height   uint16
+	Encoding int32
+}
+
+// Color is a single SetColorMapEntries color with 16-bit components.
+type Color struct {
+	R, G, B uint16
+}
+
+// ReadMessageType reads the type byte that starts every message after the
+// handshake.
+func ReadMessageType(r io.Reader) (uint8, error) {
+	var t [1]byte
+	if _, err := io.ReadFull(r, t[:]); err != nil {
+		return 0, err
+	}
+	return t[0], nil
+}
+
+// WriteSetPixelFormat writes a SetPixelFormat message.
+func WriteSetPixelFormat(w io.Writer, pf PixelFormat) error {
+	buf := make([]byte, 4, 4+PixelFormatLength)
+	buf[0] = SetPixelFormatMsg
+	_, err := w.Write(pf.append(buf))
+	return err
+}
+
+// ReadSetPixelFormat reads the body of a SetPixelFormat message.
+func ReadSetPixelFormat(r io.Reader) (PixelFormat, error) {
+	var padding [3]byte
+	if _, err := io.ReadFull(r, padding[:]); err != nil {
+		return PixelFormat{}, err
+	}
+	return ReadPixelFormat(r)
+}
+
+// WriteSetEncodings writes a SetEncodings message.
+func WriteSetEncodings(w io.Writer, encodings []int32) error {
+	if len(encodings) > MaxEncodings {
+		return fmt.Errorf("rfb: too many encodings: %d (max %d)", len(encodings), MaxEncodings)
+	}
+
+	buf := make([]byte, 0, 4+4*len(encodings))
+	buf = append(buf, SetEncodingsMsg, 0)
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(encodings))) // #nosec G115 - bounded by MaxEncodings
+	for _, enc := range encodings {
+		buf = binary.BigEndian.AppendUint32(buf, uint32(enc)) // #nosec G115 - two's complement on the wire
+	}
+	_, err := w.Write(buf)
+	return err
+}
+
+// ReadSetEncodings reads the body of a SetEncodings message.
+func ReadSetEncodings(r io.Reader) ([]int32, error) {
+	var header [3]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+
+	n := binary.BigEndian.Uint16(header[1:3])
+	if n > MaxEncodings {
+		return nil, &LengthError{Field: "encodings", Length: uint32(n), Max: MaxEncodings}
+	}
+
+	raw := make([]byte, 4*int(n))
+	if _, err := io.ReadFull(r, raw); err != nil {
+		return nil, err
+	}
+
+	encodings := make([]int32, n)
+	for i := range encodings {
+		encodings[i] = int32(binary.BigEndian.Uint32(raw[4*i:])) // #nosec G115 - two's complement on the wire
+	}
+	return encodings, nil
+}
+
+// WriteFramebufferUpdateRequest writes a FramebufferUpdateRequest message.
+func WriteFramebufferUpdateRequest(w io.Writer, req FramebufferUpdateRequest) error {
+	buf := make([]byte, 0, 10)
+	buf = append(buf, FramebufferUpdateRequestMsg, boolByte(req.Incremental))
+	buf = binary.BigEndian.AppendUint16(buf, req.X)
+	buf = binary.BigEndian.AppendUint16(buf, req.Y)
+	buf = binary.BigEndian.AppendUint16(buf, req.Width)
+	buf = binary.BigEndian.AppendUint16(buf, req.Height)
+	_, err := w.Write(buf)
+	return err
+}
+
+// ReadFramebufferUpdateRequest reads the body of a FramebufferUpdateRequest message.
+func ReadFramebufferUpdateRequest(r io.Reader) (FramebufferUpdateRequest, error) {
+	var b [9]byte
+	if _, err := io.ReadFull(r, b[:]); err != nil {
+		return FramebufferUpdateRequest{}, err
+	}
+	return FramebufferUpdateRequest{
+		Incremental: b[0] != 0,
+		X:           binary.BigEndian.Uint16(b[1:3]),
+		Y:           binary.BigEndian.Uint16(b[3:5]),
+		Width:       binary.BigEndian.Uint16(b[5:7]),
+		Height:      binary.BigEndian.Uint16(b[7:9]),
+	}, nil
+}
+
+// WriteKeyEvent writes a KeyEvent message.
+func WriteKeyEvent(w io.Writer, ev KeyEvent) error {
+	buf := make([]byte, 4, 8)
+	buf[0] = KeyEventMsg
+	buf[1] = boolByte(ev.Down)
+	buf = binary.BigEndian.AppendUint32(buf, ev.Key)
+	_, err := w.Write(buf)
+	return err
+}
+
+// ReadKeyEvent reads the body of a KeyEvent message.
+func ReadKeyEvent(r io.Reader) (KeyEvent, error) {
+	var b [7]byte
+	if _, err := io.ReadFull(r, b[:]); err != nil {
+		return KeyEvent{}, err
+	}
+	return KeyEvent{Down: b[0] != 0, Key: binary.BigEndian.Uint32(b[3:7])}, nil
+}
+
+// WriteExtendedKeyEvent writes a QEMU extended key event message.
+func WriteExtendedKeyEvent(w io.Writer, ev ExtendedKeyEvent) error {
+	buf := make([]byte, 0, 12)
+	buf = append(buf, QEMUClientMsg, QEMUExtendedKeyEventSubtype, 0, boolByte(ev.Down))
+	buf = binary.BigEndian.AppendUint32(buf, ev.Keysym)
+	buf = binary.BigEndian.AppendUint32(buf, ev.Keycode)
+	_, err := w.Write(buf)
+	return err
+}
+
+// ReadExtendedKeyEvent reads the body of a QEMU client message that must be
+// an extended key event, starting with its submessage type.
+func ReadExtendedKeyEvent(r io.Reader) (ExtendedKeyEvent, error) {
+	var b [11]byte
+	if _, err := io.ReadFull(r, b[:]); err != nil {
+		return ExtendedKeyEvent{}, err
+	}
+	if b[0] != QEMUExtendedKeyEventSubtype {
+		return ExtendedKeyEvent{}, fmt.Errorf("rfb: unsupported QEMU client message subtype %d", b[0])
+	}
+	return ExtendedKeyEvent{
+		Down:    binary.BigEndian.Uint16(b[1:3]) != 0,
+		Keysym:  binary.BigEndian.Uint32(b[3:7]),
+		Keycode: binary.BigEndian.Uint32(b[7:11]),
+	}, nil
+}
+
+// WritePointerEvent writes a PointerEvent message.
+func WritePointerEvent(w io.Writer, ev PointerEvent) error {
+	buf := make([]byte, 0, 6)
+	buf = append(buf, PointerEventMsg, ev.Mask)
+	buf = binary.BigEndian.AppendUint16(buf, ev.X)
+	buf = binary.BigEndian.AppendUint16(buf, ev.Y)
+	_, err := w.Write(buf)
+	return err
+}
+
+// ReadPointerEvent reads the body of a PointerEvent message.
+func ReadPointerEvent(r io.Reader) (PointerEvent, error) {
+	var b [5]byte
+	if _, err := io.ReadFull(r, b[:]); err != nil {
+		return PointerEvent{}, err
+	}
+	return PointerEvent{
+		Mask: b[0],
+		X:    binary.BigEndian.Uint16(b[1:3]),
+		Y:    binary.BigEndian.Uint16(b[3:5]),
+	}, nil
+}
+
+// WriteExtendedPointerEvent writes a PointerEvent in the ExtendedMouseButtons
+// format. Masks that fit in seven bits are sent as a regular PointerEvent.
+func WriteExtendedPointerEvent(w io.Writer, ev ExtendedPointerEvent) error {
+	if ev.Mask < extendedButtonFlag {
+		return WritePointerEvent(w, PointerEvent{Mask: uint8(ev.Mask), X: ev.X, Y: ev.Y})
+	}
+
+	buf := make([]byte, 0, 7)
+	buf = append(buf, PointerEventMsg, uint8(ev.Mask&0x7f)|extendedButtonFlag)
+	buf = binary.BigEndian.AppendUint16(buf, ev.X)
+	buf = binary.BigEndian.AppendUint16(buf, ev.Y)
+	buf = append(buf, uint8(ev.Mask>>7)) // #nosec G115 - at most nine bits are meaningful
+	_, err := w.Write(buf)
+	return err
+}
+
+// ReadExtendedPointerEvent reads the body of a PointerEvent message from a
+// client that negotiated ExtendedMouseButtons, including the extra mask byte
+// when present.
+func ReadExtendedPointerEvent(r io.Reader) (ExtendedPointerEvent, error) {
+	ev, err := ReadPointerEvent(r)
+	if err != nil {
+		return ExtendedPointerEvent{}, err
+	}
+
+	ext := ExtendedPointerEvent{Mask: uint16(ev.Mask), X: ev.X, Y: ev.Y}
+	if ev.Mask&extendedButtonFlag != 0 {
+		var extra [1]byte
+		if _, err := io.ReadFull(r, extra[:]); err != nil {
+			return ExtendedPointerEvent{}, err
+		}
+		ext.Mask = uint16(ev.Mask&0x7f) | uint16(extra[0])<<7
+	}
+	return ext, nil
+}
+
+// WriteClientCutText writes a ClientCutText message. Text is sent as-is and
+// should be Latin-1 encoded.
+func WriteClientCutText(w io.Writer, text []byte) error {
+	return writeCutText(w, ClientCutTextMsg, text)
+}
+
+// WriteServerCutText writes a ServerCutText message. Text is sent as-is and
+// should be Latin-1 encoded.
+func WriteServerCutText(w io.Writer, text []byte) error {
+	return writeCutText(w, ServerCutTextMsg, text)
+}
+
+// ReadCutText reads the body of a ClientCutText or ServerCutText message,
+// which share the same layout.
+func ReadCutText(r io.Reader) ([]byte, error) {
+	var padding [3]byte
+	if _, err := io.ReadFull(r, padding[:]); err != nil {
+		return nil, err
+	}
+	return readLengthPrefixed(r, "cut text", MaxCutTextLength)
+}
+
+// writeCutText writes a cut text message of the given type.
+func writeCutText(w io.Writer, msgType uint8, text []byte) error {
+	if len(text) > MaxCutTextLength {
+		return &LengthError{Field: "cut text", Length: uint32(len(text)), Max: MaxCutTextLength} // #nosec G115 - bounded by comparison
+	}
+
+	buf := make([]byte, 4, 8+len(text))
+	buf[0] = msgType
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(text))) // #nosec G115 - bounded by MaxCutTextLength
+	buf = append(buf, text...)
+	_, err := w.Write(buf)
+	return err
+}
+
+// WriteFramebufferUpdate writes the header of a FramebufferUpdate message.
+// It must be followed by numRects rectangles, each written with WriteRectangle
+// and its encoded payload.
+func WriteFramebufferUpdate(w io.Writer, numRects uint16) error {
+	buf := make([]byte, 2, 4)
+	buf[0] = FramebufferUpdateMsg
+	_, err := w.Write(binary.BigEndian.AppendUint16(buf, numRects))
+	return err
+}
+
+// ReadFramebufferUpdate reads the body header of a FramebufferUpdate message
+// and returns the number of rectangles that follow.
+func ReadFramebufferUpdate(r io.Reader) (uint16, error) {
+	var b [3]byte
+	if _, err := io.ReadFull(r, b[:]); err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint16(b[1:3]), nil
+}
+
+// WriteRectangle writes a rectangle header.
+func WriteRectangle(w io.Writer, rect Rectangle) error {
+	buf := make([]byte, 0, 12)
+	buf = binary.BigEndian.AppendUint16(buf, rect.X)
+	buf = binary.BigEndian.AppendUint16(buf, rect.Y)
+	buf = binary.BigEndian.AppendUint16(buf, rect.Width)
+	buf = binary.BigEndian.AppendUint16(buf, rect.Height)
+	buf = binary.BigEndian.AppendUint32(buf, uint32(rect.Encoding)) // #nosec G115 - two's complement on the wire
+	_, err := w.Write(buf)
+	return err
+}
+
+// ReadRectangle reads a rectangle header.
+func ReadRectangle(r io.Reader) (Rectangle, error) {
+	var b [12]byte
+	if _, err := io.ReadFull(r, b[:]); err != nil {
+		return Rectangle{}, err
+	}
+	return Rectangle{
+		X:        binary.BigEndian.Uint16(b[0:2]),
+		Y:        binary.BigEndian.Uint16(b[2:4]),
+		Width:    binary.BigEndian.Uint16(b[4:6]),
+		Height:   binary.BigEndian.Uint16(b[6:8]),
+		Encoding: int32(binary.BigEndian.Uint32(b[8:12])), // #nosec G115 - two's complement on the wire
+	}, nil
+}
+
+// WriteSetColorMapEntries writes a SetColorMapEntries message.
+func WriteSetColorMapEntries(w io.Writer, firstColor uint16, colors []Color) error {
+	if len(colors) > 65535 {
+		return fmt.Errorf("rfb: too many colors: %d", len(colors))
+	}
+
+	buf := make([]byte, 0, 6+6*len(colors))
+	buf = append(buf, SetColorMapEntriesMsg, 0)
+	buf = binary.BigEndian.AppendUint16(buf, firstColor)
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(colors))) // #nosec G115 - length checked above
+	for _, c := range colors {
+		buf = binary.BigEndian.AppendUint16(buf, c.R)
+		buf = binary.BigEndian.AppendUint16(buf, c.G)
+		buf = binary.BigEndian.AppendUint16(buf, c.B)
+	}
+	_, err := w.Write(buf)
+	return err
+}
+
+// ReadSetColorMapEntries reads the body of a SetColorMapEntries message.
+func ReadSetColorMapEntries(r io.Reader) (firstColor uint16, colors []Color, err error) {
+	var header [5]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, nil, err
+	}
+
+	firstColor = binary.BigEndian.Uint16(header[1:3])
+	n := int(binary.BigEndian.Uint16(header[3:5]))
+
+	raw := make([]byte, 6*n)
+	if _, err := io.ReadFull(r, raw); err != nil {
+		return 0, nil, err
+	}
+
+	colors = make([]Color, n)
+	for i := range colors {
+		colors[i] = Color{
+			R: binary.BigEndian.Uint16(raw[6*i:]),
+			G: binary.BigEndian.Uint16(raw[6*i+2:]),
+			B: binary.BigEndian.Uint16(raw[6*i+4:]),
+		}
+	}
+	return firstColor, colors, nil
+}
+
+// WriteBell writes a Bell message.
+func WriteBell(w io.Writer) error {
+	_, err := w.Write([]byte{BellMsg})
+	return err
+}
@@ -0,0 +1,234 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package rfb
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+// readBody reads the message type and checks it before the body is decoded.
+func readBody(t *testing.T, buf *bytes.Buffer, want uint8) {
+	t.Helper()
+	msgType, err := ReadMessageType(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msgType != want {
+		t.Fatalf("message type = %d, want %d", msgType, want)
+	}
+}
+
+func TestMessages_KeyEvent(t *testing.T) {
+	var buf bytes.Buffer
+	want := KeyEvent{Down: true, Key: 0xFF0D}
+	if err := WriteKeyEvent(&buf, want); err != nil {
+		t.Fatal(err)
+	}
+
+	wire := []byte{4, 1, 0, 0, 0, 0, 0xFF, 0x0D}
+	if !bytes.Equal(buf.Bytes(), wire) {
+		t.Fatalf("KeyEvent encoded as %v, want %v", buf.Bytes(), wire)
+	}
+
+	readBody(t, &buf, KeyEventMsg)
+	got, err := ReadKeyEvent(&buf)
+	if err != nil || got != want {
+		t.Fatalf("ReadKeyEvent() = %+v, %v", got, err)
+	}
+}
+
+func TestMessages_ExtendedKeyEvent(t *testing.T) {
+	var buf bytes.Buffer
+	want := ExtendedKeyEvent{Down: true, Keysym: 0xFFE4, Keycode: 0x9D}
+	if err := WriteExtendedKeyEvent(&buf, want); err != nil {
+		t.Fatal(err)
+	}
+
+	wire := []byte{255, 0, 0, 1, 0, 0, 0xFF, 0xE4, 0, 0, 0, 0x9D}
+	if !bytes.Equal(buf.Bytes(), wire) {
+		t.Fatalf("ExtendedKeyEvent encoded as %v, want %v", buf.Bytes(), wire)
+	}
+
+	readBody(t, &buf, QEMUClientMsg)
+	got, err := ReadExtendedKeyEvent(&buf)
+	if err != nil || got != want {
+		t.Fatalf("ReadExtendedKeyEvent() = %+v, %v", got, err)
+	}
+
+	wire[1] = 1
+	if _, err := ReadExtendedKeyEvent(bytes.NewReader(wire[1:])); err == nil {
+		t.Error("ReadExtendedKeyEvent accepted another QEMU submessage")
+	}
+}
+
+func TestMessages_PointerEvent(t *testing.T) {
+	var buf bytes.Buffer
+	want := PointerEvent{Mask: 1, X: 300, Y: 2}
+	if err := WritePointerEvent(&buf, want); err != nil {
+		t.Fatal(err)
+	}
+
+	wire := []byte{5, 1, 0x01, 0x2C, 0, 2}
+	if !bytes.Equal(buf.Bytes(), wire) {
+		t.Fatalf("PointerEvent encoded as %v, want %v", buf.Bytes(), wire)
+	}
+
+	readBody(t, &buf, PointerEventMsg)
+	got, err := ReadPointerEvent(&buf)
+	if err != nil || got != want {
+		t.Fatalf("ReadPointerEvent() = %+v, %v", got, err)
+	}
+}
+
+func TestMessages_ExtendedPointerEvent(t *testing.T) {
+	tests := []struct {
+		name string
+		mask uint16
+		wire []byte
+	}{
+		{name: "Standard buttons", mask: 0x05, wire: []byte{5, 0x05, 0, 1, 0, 2}},
+		{name: "Back", mask: 1 << 7, wire: []byte{5, 0x80, 0, 1, 0, 2, 0x01}},
+		{name: "Forward with left", mask: 1<<8 | 1, wire: []byte{5, 0x81, 0, 1, 0, 2, 0x02}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			want := ExtendedPointerEvent{Mask: tt.mask, X: 1, Y: 2}
+			if err := WriteExtendedPointerEvent(&buf, want); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(buf.Bytes(), tt.wire) {
+				t.Fatalf("encoded as %v, want %v", buf.Bytes(), tt.wire)
+			}
+
+			readBody(t, &buf, PointerEventMsg)
+			got, err := ReadExtendedPointerEvent(&buf)
+			if err != nil || got != want {
+				t.Fatalf("ReadExtendedPointerEvent() = %+v, %v", got, err)
+			}
+		})
+	}
+}
+
+func TestMessages_FramebufferUpdateRequest(t *testing.T) {
+	var buf bytes.Buffer
+	want := FramebufferUpdateRequest{Incremental: true, X: 1, Y: 2, Width: 640, Height: 480}
+	if err := WriteFramebufferUpdateRequest(&buf, want); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() != 10 {
+		t.Fatalf("FramebufferUpdateRequest encoded to %d bytes", buf.Len())
+	}
+
+	readBody(t, &buf, FramebufferUpdateRequestMsg)
+	got, err := ReadFramebufferUpdateRequest(&buf)
+	if err != nil || got != want {
+		t.Fatalf("ReadFramebufferUpdateRequest() = %+v, %v", got, err)
+	}
+}
+
+func TestMessages_SetEncodings(t *testing.T) {
+	var buf bytes.Buffer
+	want := []int32{5, 1, 0, -239, -223}
+	if err := WriteSetEncodings(&buf, want); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() != 4+4*len(want) {
+		t.Fatalf("SetEncodings encoded to %d bytes", buf.Len())
+	}
+
+	readBody(t, &buf, SetEncodingsMsg)
+	got, err := ReadSetEncodings(&buf)
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Fatalf("ReadSetEncodings() = %v, %v", got, err)
+	}
+}
+
+func TestMessages_SetPixelFormat(t *testing.T) {
+	var buf bytes.Buffer
+	want := PixelFormat{BPP: 16, Depth: 16, BigEndian: true, TrueColor: true, RedMax: 31, GreenMax: 63, BlueMax: 31, RedShift: 11, GreenShift: 5}
+	if err := WriteSetPixelFormat(&buf, want); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() != 20 {
+		t.Fatalf("SetPixelFormat encoded to %d bytes", buf.Len())
+	}
+
+	readBody(t, &buf, SetPixelFormatMsg)
+	got, err := ReadSetPixelFormat(&buf)
+	if err != nil || got != want {
+		t.Fatalf("ReadSetPixelFormat() = %+v, %v", got, err)
+	}
+}
+
+func TestMessages_CutText(t *testing.T) {
+	for _, msgType := range []uint8{ClientCutTextMsg, ServerCutTextMsg} {
+		var buf bytes.Buffer
+		write := WriteClientCutText
+		if msgType == ServerCutTextMsg {
+			write = WriteServerCutText
+		}
+		if err := write(&buf, []byte("hello")); err != nil {
+			t.Fatal(err)
+		}
+		if buf.Len() != 8+5 {
+			t.Fatalf("cut text encoded to %d bytes", buf.Len())
+		}
+
+		readBody(t, &buf, msgType)
+		got, err := ReadCutText(&buf)
+		if err != nil || string(got) != "hello" {
+			t.Fatalf("ReadCutText() = %q, %v", got, err)
+		}
+	}
+}
+
+func TestMessages_FramebufferUpdate(t *testing.T) {
+	var buf bytes.Buffer
+	rect := Rectangle{X: 10, Y: 20, Width: 30, Height: 40, Encoding: -223}
+	if err := WriteFramebufferUpdate(&buf, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteRectangle(&buf, rect); err != nil {
+		t.Fatal(err)
+	}
+
+	readBody(t, &buf, FramebufferUpdateMsg)
+	n, err := ReadFramebufferUpdate(&buf)
+	if err != nil || n != 1 {
+		t.Fatalf("ReadFramebufferUpdate() = %d, %v", n, err)
+	}
+	got, err := ReadRectangle(&buf)
+	if err != nil || got != rect {
+		t.Fatalf("ReadRectangle() = %+v, %v", got, err)
+	}
+}
+
+func TestMessages_SetColorMapEntries(t *testing.T) {
+	var buf bytes.Buffer
+	want := []Color{{R: 65535}, {G: 65535}, {B: 1, G: 2, R: 3}}
+	if err := WriteSetColorMapEntries(&buf, 5, want); err != nil {
+		t.Fatal(err)
+	}
+
+	readBody(t, &buf, SetColorMapEntriesMsg)
+	first, got, err := ReadSetColorMapEntries(&buf)
+	if err != nil || first != 5 || !reflect.DeepEqual(got, want) {
+		t.Fatalf("ReadSetColorMapEntries() = %d, %v, %v", first, got, err)
+	}
+}
+
+func TestMessages_Bell(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteBell(&buf); err != nil {
+		t.Fatal(err)
+	}
+	readBody(t, &buf, BellMsg)
+	if buf.Len() != 0 {
+		t.Errorf("Bell has %d trailing bytes", buf.Len())
+	}
+}
@@ -0,0 +1,74 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package rfb
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+// PixelFormatLength is the length of an encoded PixelFormat in bytes.
+const PixelFormatLength = 16
+
+// PixelFormat is the PIXEL_FORMAT structure defined in RFC 6143 Section 7.4.
+// Its fields match vnc.PixelFormat so values convert directly between the two.
+type PixelFormat struct {
+	BPP        uint8
+	Depth      uint8
+	BigEndian  bool
+	TrueColor  bool
+	RedMax     uint16
+	GreenMax   uint16
+	BlueMax    uint16
+	RedShift   uint8
+	GreenShift uint8
+	BlueShift  uint8
+}
+
+// ReadPixelFormat reads an encoded PixelFormat.
+func ReadPixelFormat(r io.Reader) (PixelFormat, error) {
+	var b [PixelFormatLength]byte
+	if _, err := io.ReadFull(r, b[:]); err != nil {
+		return PixelFormat{}, err
+	}
+	return ParsePixelFormat(b[:]), nil
+}
+
+// ParsePixelFormat decodes the first PixelFormatLength bytes of b.
+// It panics if b is shorter than PixelFormatLength.
+func ParsePixelFormat(b []byte) PixelFormat {
+	_ = b[PixelFormatLength-1]
+	return PixelFormat{
+		BPP:        b[0],
+		Depth:      b[1],
+		BigEndian:  b[2] != 0,
+		TrueColor:  b[3] != 0,
+		RedMax:     binary.BigEndian.Uint16(b[4:6]),
+		GreenMax:   binary.BigEndian.Uint16(b[6:8]),
+		BlueMax:    binary.BigEndian.Uint16(b[8:10]),
+		RedShift:   b[10],
+		GreenShift: b[11],
+		BlueShift:  b[12],
+	}
+}
+
+// WritePixelFormat writes an encoded PixelFormat.
+func WritePixelFormat(w io.Writer, pf PixelFormat) error {
+	_, err := w.Write(pf.append(make([]byte, 0, PixelFormatLength)))
+	return err
+}
+
+// MarshalBinary returns the 16-byte wire encoding of the pixel format.
+func (pf PixelFormat) MarshalBinary() ([]byte, error) {
+	return pf.append(make([]byte, 0, PixelFormatLength)), nil
+}
+
+// append appends the wire encoding of the pixel format to buf.
+func (pf PixelFormat) append(buf []byte) []byte {
+	buf = append(buf, pf.BPP, pf.Depth, boolByte(pf.BigEndian), boolByte(pf.TrueColor))
+	buf = binary.BigEndian.AppendUint16(buf, pf.RedMax)
+	buf = binary.BigEndian.AppendUint16(buf, pf.GreenMax)
+	buf = binary.BigEndian.AppendUint16(buf, pf.BlueMax)
+	return append(buf, pf.RedShift, pf.GreenShift, pf.BlueShift, 0, 0, 0)
+}
@@ -0,0 +1,75 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package vnc
+
+import "image"
+
+// WithRotation presents the remote desktop rotated clockwise by rotation, for
+// servers whose framebuffer is stored sideways relative to the screen, such
+// as portrait kiosk displays. See ClientConfig.Rotation.
+func WithRotation(rotation Rotation) ClientOption {
+	return func(cfg *ClientConfig) {
+		cfg.Rotation = rotation
+	}
+}
+
+// displayRotation returns the configured rotation in the range Rotate0 to
+// Rotate270.
+func (c *ClientConn) displayRotation() Rotation {
+	if c.config == nil {
+		return Rotate0
+	}
+	return ((c.config.Rotation % 4) + 4) % 4
+}
+
+// DisplaySize returns the size of the desktop as presented to the caller: the
+// framebuffer size, with width and height swapped under a Rotation of 90 or
+// 270 degrees. Frames, capture regions, and pointer coordinates use this
+// size.
+func (c *ClientConn) DisplaySize() (width, height uint16) {
+	width, height = c.GetFrameBufferSize()
+	if r := c.displayRotation(); r == Rotate90 || r == Rotate270 {
+		return height, width
+	}
+	return width, height
+}
+
+// displayFrame presents a framebuffer frame with the configured rotation.
+func (c *ClientConn) displayFrame(f *Frame) *Frame {
+	r := c.displayRotation()
+	if r == Rotate0 {
+		return f
+	}
+	size := f.grid.bounds().Size()
+	if r == Rotate90 || r == Rotate270 {
+		size.X, size.Y = size.Y, size.X
+	}
+	return &Frame{grid: f.grid, rect: image.Rectangle{Max: size}, rotation: r}
+}
+
+// rotatedPoint maps the display pixel p to the pixel of a framebuffer of the
+// given size that is shown there under rotation.
+func rotatedPoint(p image.Point, size image.Point, rotation Rotation) image.Point {
+	switch rotation {
+	case Rotate90:
+		return image.Pt(p.Y, size.Y-1-p.X)
+	case Rotate180:
+		return image.Pt(size.X-1-p.X, size.Y-1-p.Y)
+	case Rotate270:
+		return image.Pt(size.X-1-p.Y, p.X)
+	default:
+		return p
+	}
+}
+
+// rotatedRect maps a non-empty display rectangle to the framebuffer
+// rectangle shown there under rotation.
+func rotatedRect(r image.Rectangle, size image.Point, rotation Rotation) image.Rectangle {
+	corners := image.Rectangle{
+		Min: rotatedPoint(r.Min, size, rotation),
+		Max: rotatedPoint(r.Max.Sub(image.Pt(1, 1)), size, rotation),
+	}.Canon()
+	corners.Max = corners.Max.Add(image.Pt(1, 1))
+	return corners
+}
@@ -0,0 +1,83 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package vnc
+
+import (
+	"context"
+	"image"
+	"testing"
+	"time"
+)
+
+func TestRotation_MatchesViewport(t *testing.T) {
+	size := image.Pt(5, 3)
+	for _, r := range []Rotation{Rotate0, Rotate90, Rotate180, Rotate270} {
+		view := Viewport{FramebufferWidth: size.X, FramebufferHeight: size.Y, Rotation: r}
+		w, h := view.rotatedSize()
+		view.Bounds = image.Rect(0, 0, w, h)
+
+		for y := range h {
+			for x := range w {
+				fx, fy, _ := view.ToFramebuffer(float64(x)+0.5, float64(y)+0.5)
+				if got := rotatedPoint(image.Pt(x, y), size, r); got != image.Pt(int(fx), int(fy)) {
+					t.Errorf("rotation %d: rotatedPoint(%d, %d) = %v, Viewport gives (%d, %d)", r, x, y, got, fx, fy)
+				}
+			}
+		}
+	}
+
+	if got, want := rotatedRect(image.Rect(1, 0, 3, 2), size, Rotate90), image.Rect(0, 0, 2, 2); got != want {
+		t.Errorf("rotatedRect() = %v, want %v", got, want)
+	}
+}
+
+func TestRotation_FramesAndPointer(t *testing.T) {
+	srv, conn := newUpdateServer(t, 4, 3, WithRotation(Rotate90))
+
+	if w, h := conn.DisplaySize(); w != 3 || h != 4 {
+		t.Fatalf("DisplaySize() = %dx%d, want 3x4", w, h)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	img, err := conn.Screenshot(ctx)
+	if err != nil {
+		t.Fatalf("Screenshot failed: %v", err)
+	}
+	if img.Bounds() != image.Rect(0, 0, 3, 4) {
+		t.Fatalf("Screenshot bounds = %v, want 3x4", img.Bounds())
+	}
+	n := int(img.RGBAAt(0, 0).B)
+	for y := range 4 {
+		for x := range 3 {
+			p := rotatedPoint(image.Pt(x, y), image.Pt(4, 3), Rotate90)
+			if got, want := img.RGBAAt(x, y), updatePixel(p.X, p.Y, n); got != want {
+				t.Errorf("display pixel (%d, %d) = %v, want framebuffer pixel %v = %v", x, y, got, p, want)
+			}
+		}
+	}
+
+	// The top-right display pixel is the top-left framebuffer pixel.
+	if got := conn.CurrentFrame().RGBAAt(2, 0); got != updatePixel(0, 0, n) {
+		t.Errorf("CurrentFrame().RGBAAt(2, 0) = %v, want %v", got, updatePixel(0, 0, n))
+	}
+	if err := conn.PointerEvent(0, 2, 0); err != nil {
+		t.Fatalf("PointerEvent failed: %v", err)
+	}
+	if ev := <-srv.pointers; ev.X != 0 || ev.Y != 0 {
+		t.Errorf("pointer sent at (%d, %d), want (0, 0)", ev.X, ev.Y)
+	}
+	if err := conn.PointerEvent(0, 3, 0); !IsVNCError(err, ErrValidation) {
+		t.Errorf("PointerEvent outside the display error = %v, want a validation error", err)
+	}
+
+	region, err := conn.CaptureRegion(ctx, image.Rect(1, 2, 3, 4))
+	if err != nil {
+		t.Fatalf("CaptureRegion failed: %v", err)
+	}
+	p := rotatedPoint(image.Pt(1, 2), image.Pt(4, 3), Rotate90)
+	if got, want := region.RGBAAt(1, 2), updatePixel(p.X, p.Y, n+1); got != want {
+		t.Errorf("CaptureRegion pixel = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,307 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package vnc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1" // #nosec G505 - Required by the RSA-AES protocol
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"math/big"
+	"net"
+
+	"github.com/tenthirtyam/go-vnc/rfb"
+)
+
+// Limits on the RSA key length of the RSA-AES security types, in bits.
+const (
+	rsaAESMinKeyBits = 1024
+	rsaAESMaxKeyBits = 8192
+)
+
+// RSA-AES authentication subtypes announced by the server once the channel is
+// encrypted.
+const (
+	rsaAESUserPass uint8 = 1
+	rsaAESPass     uint8 = 2
+)
+
+// RSAAESAuth implements the RSA-AES security types of RealVNC Server (RA2)
+// and TigerVNC: rfb.SecurityRA2 and rfb.SecurityRA2ne with AES-128, and
+// rfb.SecurityRA2ne256, rfb.SecurityRA256, and rfb.SecurityRAne256 with
+// AES-256. Client and server exchange RSA public keys and random keys
+// encrypted with them, derive AES session keys, and prove possession of both
+// keys before the client sends its credentials over AES-EAX. RA2 and RA256
+// keep the session encrypted, while RA2ne, RA2ne256, and RAne256 continue in
+// the clear after authentication.
+//
+// Username and Password are truncated to 255 bytes each. The username is only
+// sent to servers asking for one.
+type RSAAESAuth struct {
+	Username string
+	Password string
+
+	// Credentials, if set, supplies the username and password at handshake
+	// time in place of Username and Password.
+	Credentials CredentialProvider
+
+	// Type is the security type to negotiate. Zero selects rfb.SecurityRA2.
+	Type uint8
+
+	// ClientKeyBits is the length of the RSA key the client generates for the
+	// handshake. Zero matches the length of the server key.
+	ClientKeyBits int
+
+	// VerifyServerKey, if set, accepts or rejects the RSA key of the server
+	// before the client sends anything, such as by comparing it with a key
+	// recorded on first use.
+	VerifyServerKey func(key *rsa.PublicKey) error
+
+	logger Logger
+}
+
+// SecurityType returns the configured RSA-AES security type.
+func (r *RSAAESAuth) SecurityType() uint8 {
+	if r.Type == 0 {
+		return rfb.SecurityRA2
+	}
+	return r.Type
+}
+
+// String returns a human-readable description of the authentication method.
+func (r *RSAAESAuth) String() string {
+	switch r.SecurityType() {
+	case rfb.SecurityRA2ne:
+		return "RSA-AES Unencrypted"
+	case rfb.SecurityRA256:
+		return "RSA-AES-256"
+	case rfb.SecurityRA2ne256, rfb.SecurityRAne256:
+		return "RSA-AES-256 Unencrypted"
+	}
+	return "RSA-AES"
+}
+
+// SetLogger sets the logger for the authentication method.
+func (r *RSAAESAuth) SetLogger(logger Logger) {
+	r.logger = logger
+}
+
+// ClearPassword securely clears the password from memory.
+func (r *RSAAESAuth) ClearPassword() {
+	if r.Password != "" {
+		r.Password = (&SecureMemory{}).ClearString(r.Password)
+	}
+}
+
+// Handshake exchanges the RSA keys and session keys with the server, verifies
+// the key exchange, and sends the credentials over the encrypted channel.
+func (r *RSAAESAuth) Handshake(ctx context.Context, conn net.Conn) error {
+	select {
+	case <-ctx.Done():
+		return timeoutError("RSAAESAuth.Handshake", "authentication cancelled", ctx.Err())
+	default:
+	}
+
+	logger := r.logger
+	if logger == nil {
+		logger = &NoOpLogger{}
+	}
+
+	securityType := r.SecurityType()
+	var keySize int
+	var newHash func() hash.Hash
+	switch securityType {
+	case rfb.SecurityRA2, rfb.SecurityRA2ne:
+		keySize, newHash = 16, sha1.New
+	case rfb.SecurityRA2ne256, rfb.SecurityRA256, rfb.SecurityRAne256:
+		keySize, newHash = 32, sha256.New
+	default:
+		return configurationError("RSAAESAuth.Handshake",
+			fmt.Sprintf("security type %d is not an RSA-AES type", securityType), nil)
+	}
+
+	serverKey, serverKeyMsg, err := readRSAAESPublicKey(conn)
+	if err != nil {
+		return err
+	}
+	logger.Debug("Received server RSA key", Field{Key: "bits", Value: serverKey.N.BitLen()})
+	if r.VerifyServerKey != nil {
+		if err := r.VerifyServerKey(serverKey); err != nil {
+			return authenticationError("RSAAESAuth.Handshake", "server key rejected", err)
+		}
+	}
+
+	clientBits := r.ClientKeyBits
+	if clientBits == 0 {
+		clientBits = int(binary.BigEndian.Uint32(serverKeyMsg))
+	}
+	if clientBits < rsaAESMinKeyBits || clientBits > rsaAESMaxKeyBits {
+		return configurationError("RSAAESAuth.Handshake",
+			fmt.Sprintf("client key length %d outside %d-%d bits", clientBits, rsaAESMinKeyBits, rsaAESMaxKeyBits), nil)
+	}
+	clientKey, err := rsa.GenerateKey(rand.Reader, clientBits)
+	if err != nil {
+		return authenticationError("RSAAESAuth.Handshake", "failed to generate client key", err)
+	}
+	clientKeyMsg := appendRSAAESPublicKey(nil, &clientKey.PublicKey, clientBits)
+	if _, err := conn.Write(clientKeyMsg); err != nil {
+		return networkError("RSAAESAuth.Handshake", "failed to send client key", err)
+	}
+
+	sm := &SecureMemory{}
+	clientRandom := make([]byte, keySize)
+	if _, err := rand.Read(clientRandom); err != nil {
+		return authenticationError("RSAAESAuth.Handshake", "failed to generate random key", err)
+	}
+	defer sm.ClearBytes(clientRandom)
+	encrypted, err := rsa.EncryptPKCS1v15(rand.Reader, serverKey, clientRandom)
+	if err != nil {
+		return authenticationError("RSAAESAuth.Handshake", "failed to encrypt random key", err)
+	}
+	msg := binary.BigEndian.AppendUint16(nil, uint16(len(encrypted))) // #nosec G115 - at most rsaAESMaxKeyBits/8 bytes
+	if _, err := conn.Write(append(msg, encrypted...)); err != nil {
+		return networkError("RSAAESAuth.Handshake", "failed to send random key", err)
+	}
+
+	fields, err := readFields(conn, "RSA-AES random length", make([]byte, 2))
+	if err != nil {
+		return networkError("RSAAESAuth.Handshake", "failed to read random key", err)
+	}
+	if length := int(fields.uint16()); length != clientKey.Size() {
+		return protocolError("RSAAESAuth.Handshake",
+			fmt.Sprintf("server random key length %d, want %d", length, clientKey.Size()), nil)
+	}
+	encrypted, err = readBytes(conn, "RSA-AES random", clientKey.Size())
+	if err != nil {
+		return networkError("RSAAESAuth.Handshake", "failed to read random key", err)
+	}
+	serverRandom, err := rsa.DecryptPKCS1v15(rand.Reader, clientKey, encrypted)
+	if err != nil || len(serverRandom) != keySize {
+		return authenticationError("RSAAESAuth.Handshake", "failed to decrypt random key", err)
+	}
+	defer sm.ClearBytes(serverRandom)
+
+	readKey, writeKey := rsaAESSessionKeys(newHash, keySize, clientRandom, serverRandom)
+	secured, err := newEAXConn(conn, readKey, writeKey)
+	sm.ClearBytes(readKey)
+	sm.ClearBytes(writeKey)
+	if err != nil {
+		return authenticationError("RSAAESAuth.Handshake", "failed to create cipher", err)
+	}
+
+	if _, err := secured.Write(rsaAESDigest(newHash, clientKeyMsg, serverKeyMsg)); err != nil {
+		return networkError("RSAAESAuth.Handshake", "failed to send key hash", err)
+	}
+	serverHash, err := readBytes(secured, "RSA-AES key hash", newHash().Size())
+	if err != nil {
+		return authenticationError("RSAAESAuth.Handshake", "failed to read key hash", err)
+	}
+	if subtle.ConstantTimeCompare(serverHash, rsaAESDigest(newHash, serverKeyMsg, clientKeyMsg)) != 1 {
+		return authenticationError("RSAAESAuth.Handshake", "server key hash mismatch", nil)
+	}
+	logger.Debug("RSA-AES key exchange verified")
+
+	fields, err = readFields(secured, "RSA-AES subtype", make([]byte, 1))
+	if err != nil {
+		return authenticationError("RSAAESAuth.Handshake", "failed to read authentication subtype", err)
+	}
+	subtype := fields.uint8()
+	if subtype != rsaAESUserPass && subtype != rsaAESPass {
+		return unsupportedError("RSAAESAuth.Handshake",
+			fmt.Sprintf("unsupported RSA-AES subtype %d", subtype), nil)
+	}
+
+	username, password, err := resolveCredentials(ctx, "RSAAESAuth.Handshake", r.Credentials, r.Username, r.Password, subtype == rsaAESUserPass)
+	if err != nil {
+		return err
+	}
+	credentials := rsaAESCredentials(subtype, username, password)
+	sm.ClearBytes(password)
+	defer sm.ClearBytes(credentials)
+	if _, err := secured.Write(credentials); err != nil {
+		return networkError("RSAAESAuth.Handshake", "failed to send credentials", err)
+	}
+
+	if securityType == rfb.SecurityRA2 || securityType == rfb.SecurityRA256 {
+		setSecuredConn(ctx, secured)
+	}
+	return nil
+}
+
+// readRSAAESPublicKey reads the RSA public key of the server and returns it
+// together with its wire form, which the key hashes cover.
+func readRSAAESPublicKey(conn net.Conn) (*rsa.PublicKey, []byte, error) {
+	fields, err := readFields(conn, "RSA-AES key length", make([]byte, 4))
+	if err != nil {
+		return nil, nil, networkError("RSAAESAuth.Handshake", "failed to read server key", err)
+	}
+	bits := fields.uint32()
+	if bits < rsaAESMinKeyBits || bits > rsaAESMaxKeyBits {
+		return nil, nil, protocolError("RSAAESAuth.Handshake",
+			fmt.Sprintf("server key length %d outside %d-%d bits", bits, rsaAESMinKeyBits, rsaAESMaxKeyBits), nil)
+	}
+	size := int((bits + 7) / 8)
+	key, err := readBytes(conn, "RSA-AES key", 2*size)
+	if err != nil {
+		return nil, nil, networkError("RSAAESAuth.Handshake", "failed to read server key", err)
+	}
+
+	n := new(big.Int).SetBytes(key[:size])
+	e := new(big.Int).SetBytes(key[size:])
+	if n.Sign() == 0 || !e.IsInt64() || e.Int64() < 3 || e.Int64() > 1<<31-1 {
+		return nil, nil, protocolError("RSAAESAuth.Handshake", "invalid server key", nil)
+	}
+	msg := binary.BigEndian.AppendUint32(make([]byte, 0, 4+len(key)), bits)
+	return &rsa.PublicKey{N: n, E: int(e.Int64())}, append(msg, key...), nil
+}
+
+// appendRSAAESPublicKey appends the wire form of key: its length in bits
+// followed by the modulus and the exponent, each padded to the key size.
+func appendRSAAESPublicKey(dst []byte, key *rsa.PublicKey, bits int) []byte {
+	size := (bits + 7) / 8
+	dst = binary.BigEndian.AppendUint32(dst, uint32(bits)) // #nosec G115 - at most rsaAESMaxKeyBits
+	dst = append(dst, key.N.FillBytes(make([]byte, size))...)
+	return append(dst, big.NewInt(int64(key.E)).FillBytes(make([]byte, size))...)
+}
+
+// rsaAESCredentials returns the credentials message of subtype: the username
+// for rsaAESUserPass or an empty one, then the password, each preceded by its
+// length.
+func rsaAESCredentials(subtype uint8, username string, password []byte) []byte {
+	if subtype != rsaAESUserPass {
+		username = ""
+	}
+	username = username[:min(len(username), 255)]
+	password = password[:min(len(password), 255)]
+
+	buf := make([]byte, 0, 2+len(username)+len(password))
+	buf = append(buf, uint8(len(username))) // #nosec G115 - truncated above
+	buf = append(buf, username...)
+	buf = append(buf, uint8(len(password))) // #nosec G115 - truncated above
+	return append(buf, password...)
+}
+
+// rsaAESSessionKeys derives the AES keys of the client from the random keys
+// of both sides. As in TigerVNC and noVNC, the client reads the messages of
+// the server with the hash of the client random followed by the server random
+// and writes its own with the hash of the reverse.
+func rsaAESSessionKeys(newHash func() hash.Hash, keySize int, clientRandom, serverRandom []byte) (readKey, writeKey []byte) {
+	readKey = rsaAESDigest(newHash, clientRandom, serverRandom)[:keySize]
+	writeKey = rsaAESDigest(newHash, serverRandom, clientRandom)[:keySize]
+	return readKey, writeKey
+}
+
+// rsaAESDigest returns the hash of the concatenation of parts.
+func rsaAESDigest(newHash func() hash.Hash, parts ...[]byte) []byte {
+	h := newHash()
+	for _, part := range parts {
+		h.Write(part)
+	}
+	return h.Sum(nil)
+}
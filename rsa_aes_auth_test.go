@@ -0,0 +1,262 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package vnc
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1" // #nosec G505 - Required by the RSA-AES protocol
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"math/big"
+	"net"
+	"testing"
+
+	"github.com/tenthirtyam/go-vnc/rfb"
+)
+
+// rsaAESServer plays the server side of an RSA-AES handshake.
+type rsaAESServer struct {
+	key        *rsa.PrivateKey
+	keySize    int
+	newHash    func() hash.Hash
+	subtype    uint8
+	tamperHash bool
+}
+
+// serve runs the handshake on conn and returns the encrypted connection and
+// the credentials the client sent.
+func (s *rsaAESServer) serve(conn net.Conn) (*eaxConn, string, string, error) {
+	bits := s.key.N.BitLen()
+	serverKeyMsg := appendRSAAESPublicKey(nil, &s.key.PublicKey, bits)
+	if _, err := conn.Write(serverKeyMsg); err != nil {
+		return nil, "", "", err
+	}
+
+	var clientBits uint32
+	if err := binary.Read(conn, binary.BigEndian, &clientBits); err != nil {
+		return nil, "", "", err
+	}
+	size := int(clientBits+7) / 8
+	clientKey := make([]byte, 2*size)
+	if _, err := io.ReadFull(conn, clientKey); err != nil {
+		return nil, "", "", err
+	}
+	clientKeyMsg := append(binary.BigEndian.AppendUint32(nil, clientBits), clientKey...)
+	clientPublic := &rsa.PublicKey{
+		N: new(big.Int).SetBytes(clientKey[:size]),
+		E: int(new(big.Int).SetBytes(clientKey[size:]).Int64()),
+	}
+
+	var length uint16
+	if err := binary.Read(conn, binary.BigEndian, &length); err != nil {
+		return nil, "", "", err
+	}
+	encrypted := make([]byte, length)
+	if _, err := io.ReadFull(conn, encrypted); err != nil {
+		return nil, "", "", err
+	}
+	clientRandom, err := rsa.DecryptPKCS1v15(rand.Reader, s.key, encrypted)
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	serverRandom := make([]byte, s.keySize)
+	_, _ = rand.Read(serverRandom)
+	if encrypted, err = rsa.EncryptPKCS1v15(rand.Reader, clientPublic, serverRandom); err != nil {
+		return nil, "", "", err
+	}
+	if _, err := conn.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(encrypted))), encrypted...)); err != nil {
+		return nil, "", "", err
+	}
+
+	// The server reads with the write key of the client and writes with its
+	// read key.
+	secured, err := newEAXConn(conn,
+		rsaAESDigest(s.newHash, serverRandom, clientRandom)[:s.keySize],
+		rsaAESDigest(s.newHash, clientRandom, serverRandom)[:s.keySize])
+	if err != nil {
+		return nil, "", "", err
+	}
+	clientHash := make([]byte, s.newHash().Size())
+	if _, err := io.ReadFull(secured, clientHash); err != nil {
+		return nil, "", "", err
+	}
+	if !bytes.Equal(clientHash, rsaAESDigest(s.newHash, clientKeyMsg, serverKeyMsg)) {
+		return nil, "", "", errors.New("client key hash mismatch")
+	}
+	serverHash := rsaAESDigest(s.newHash, serverKeyMsg, clientKeyMsg)
+	if s.tamperHash {
+		serverHash[0] ^= 1
+	}
+	if _, err := secured.Write(append(serverHash, s.subtype)); err != nil {
+		return nil, "", "", err
+	}
+
+	var credentials [2]string
+	for i := range credentials {
+		var n [1]byte
+		if _, err := io.ReadFull(secured, n[:]); err != nil {
+			return nil, "", "", err
+		}
+		value := make([]byte, n[0])
+		if _, err := io.ReadFull(secured, value); err != nil {
+			return nil, "", "", err
+		}
+		credentials[i] = string(value)
+	}
+	return secured, credentials[0], credentials[1], nil
+}
+
+func TestRSAAESAuth_Handshake(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name         string
+		auth         *RSAAESAuth
+		server       *rsaAESServer
+		wantUsername string
+		wantSecured  bool
+	}{
+		{"RA2 with username", &RSAAESAuth{Username: "admin", Password: "secret"},
+			&rsaAESServer{key: key, keySize: 16, newHash: sha1.New, subtype: rsaAESUserPass}, "admin", true},
+		{"RA2ne with password only", &RSAAESAuth{Username: "admin", Password: "secret", Type: rfb.SecurityRA2ne},
+			&rsaAESServer{key: key, keySize: 16, newHash: sha1.New, subtype: rsaAESPass}, "", false},
+		{"RA256", &RSAAESAuth{Username: "admin", Password: "secret", Type: rfb.SecurityRA256, ClientKeyBits: 1024},
+			&rsaAESServer{key: key, keySize: 32, newHash: sha256.New, subtype: rsaAESUserPass}, "admin", true},
+		{"RA2ne256", &RSAAESAuth{Password: "secret", Type: rfb.SecurityRA2ne256},
+			&rsaAESServer{key: key, keySize: 32, newHash: sha256.New, subtype: rsaAESPass}, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, client := net.Pipe()
+			defer func() { _ = client.Close() }()
+
+			type result struct {
+				secured            *eaxConn
+				username, password string
+				err                error
+			}
+			done := make(chan result, 1)
+			go func() {
+				var r result
+				r.secured, r.username, r.password, r.err = tt.server.serve(server)
+				if r.err == nil && tt.wantSecured {
+					// The SecurityResult follows on the encrypted connection.
+					_, r.err = r.secured.Write([]byte{0, 0, 0, 0})
+				}
+				done <- r
+			}()
+
+			var secured net.Conn
+			ctx := context.WithValue(context.Background(), securedConnKey{}, &secured)
+			if err := tt.auth.Handshake(ctx, client); err != nil {
+				t.Fatalf("Handshake() error = %v", err)
+			}
+			if (secured != nil) != tt.wantSecured {
+				t.Errorf("secured connection = %v, want %v", secured != nil, tt.wantSecured)
+			}
+
+			if tt.wantSecured {
+				if err := rfb.ReadSecurityResult(secured); err != nil {
+					t.Errorf("ReadSecurityResult() error = %v", err)
+				}
+			}
+			r := <-done
+			if r.err != nil {
+				t.Fatalf("server error = %v", r.err)
+			}
+			if r.username != tt.wantUsername || r.password != "secret" {
+				t.Errorf("credentials = %q/%q, want %q/secret", r.username, r.password, tt.wantUsername)
+			}
+			_ = server.Close()
+		})
+	}
+}
+
+func TestRSAAESAuth_KeyHashMismatch(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, client := net.Pipe()
+	defer func() { _ = client.Close() }()
+	go func() {
+		defer func() { _ = server.Close() }()
+		_, _, _, _ = (&rsaAESServer{key: key, keySize: 16, newHash: sha1.New, subtype: rsaAESPass, tamperHash: true}).serve(server)
+	}()
+
+	err = (&RSAAESAuth{Password: "secret"}).Handshake(context.Background(), client)
+	if !IsVNCError(err, ErrAuthentication) {
+		t.Errorf("Handshake() error = %v, want authentication error", err)
+	}
+}
+
+func TestRSAAESAuth_RejectedServerKey(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, client := net.Pipe()
+	defer func() { _ = client.Close() }()
+	go func() {
+		defer func() { _ = server.Close() }()
+		_, _ = server.Write(appendRSAAESPublicKey(nil, &key.PublicKey, 1024))
+	}()
+
+	auth := &RSAAESAuth{VerifyServerKey: func(*rsa.PublicKey) error { return errors.New("unknown key") }}
+	if err := auth.Handshake(context.Background(), client); !IsVNCError(err, ErrAuthentication) {
+		t.Errorf("Handshake() error = %v, want authentication error", err)
+	}
+}
+
+// TestRSAAESAuth_SessionKeys checks the key derivation of CSecurityRSAAes in
+// TigerVNC, where the client reads with SHA(clientRandom || serverRandom) and
+// writes with SHA(serverRandom || clientRandom). The expected keys were
+// computed with an independent SHA implementation for randoms counting up
+// from zero.
+func TestRSAAESAuth_SessionKeys(t *testing.T) {
+	counting := func(from, n int) []byte {
+		b := make([]byte, n)
+		for i := range b {
+			b[i] = byte(from + i)
+		}
+		return b
+	}
+	tests := []struct {
+		name      string
+		newHash   func() hash.Hash
+		keySize   int
+		wantRead  string
+		wantWrite string
+	}{
+		{"AES-128", sha1.New, 16,
+			"ae5bd8efea5322c4d9986d06680a7813",
+			"b97cd424c4711eb518790ce07939ebac"},
+		{"AES-256", sha256.New, 32,
+			"fdeab9acf3710362bd2658cdc9a29e8f9c757fcf9811603a8c447cd1d9151108",
+			"84e4bd6ca2af96412fdc62fe44d4e9709cdc31933081a62486a48a154b582d53"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			readKey, writeKey := rsaAESSessionKeys(tt.newHash, tt.keySize,
+				counting(0, tt.keySize), counting(tt.keySize, tt.keySize))
+			if got := hex.EncodeToString(readKey); got != tt.wantRead {
+				t.Errorf("read key = %s, want %s", got, tt.wantRead)
+			}
+			if got := hex.EncodeToString(writeKey); got != tt.wantWrite {
+				t.Errorf("write key = %s, want %s", got, tt.wantWrite)
+			}
+		})
+	}
+}
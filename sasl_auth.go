@@ -0,0 +1,245 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package vnc
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+
+	"github.com/tenthirtyam/go-vnc/rfb"
+)
+
+// saslMaxDataLength bounds the mechanism list and the challenges sent by the
+// server, matching the limit QEMU applies to client data.
+const saslMaxDataLength = 1024 * 1024
+
+// SASLMechanism is a SASL mechanism, such as GSSAPI backed by a Kerberos
+// library, used by SASLAuth. SASLAuth clears the responses once sent, so
+// mechanisms return a fresh slice for each.
+type SASLMechanism interface {
+	// Name returns the IANA name of the mechanism, such as "GSSAPI".
+	Name() string
+
+	// Start returns the initial response of the client, or nil to send none.
+	Start(ctx context.Context) ([]byte, error)
+
+	// Next returns the response to a challenge of the server.
+	Next(ctx context.Context, challenge []byte) ([]byte, error)
+}
+
+// SASLAuth implements the SASL security type (20) that QEMU and libvirt can
+// require. The server lists its mechanisms, the client picks the first of
+// Mechanisms on the list, and the two exchange the messages of the mechanism
+// until the server reports completion. No SASL security layer is negotiated,
+// so the session should run over TLS, such as inside VeNCrypt, when the
+// mechanism does not protect it.
+type SASLAuth struct {
+	// Mechanisms lists the mechanisms the client supports, in order of
+	// preference.
+	Mechanisms []SASLMechanism
+
+	logger Logger
+}
+
+// SecurityType returns the security type identifier for SASL.
+func (s *SASLAuth) SecurityType() uint8 {
+	return rfb.SecuritySASL
+}
+
+// String returns a human-readable description of the authentication method.
+func (s *SASLAuth) String() string {
+	return "SASL"
+}
+
+// SetLogger sets the logger for the authentication method.
+func (s *SASLAuth) SetLogger(logger Logger) {
+	s.logger = logger
+}
+
+// ClearPassword securely clears the passwords of the mechanisms from memory.
+func (s *SASLAuth) ClearPassword() {
+	for _, mechanism := range s.Mechanisms {
+		if clearer, ok := mechanism.(interface{ ClearPassword() }); ok {
+			clearer.ClearPassword()
+		}
+	}
+}
+
+// Handshake selects a mechanism from the list of the server and runs its
+// exchange to completion.
+func (s *SASLAuth) Handshake(ctx context.Context, conn net.Conn) error {
+	select {
+	case <-ctx.Done():
+		return timeoutError("SASLAuth.Handshake", "authentication cancelled", ctx.Err())
+	default:
+	}
+
+	logger := s.logger
+	if logger == nil {
+		logger = &NoOpLogger{}
+	}
+
+	list, err := readSASLData(conn, "SASL mechanism list")
+	if err != nil {
+		return err
+	}
+	offered := strings.Split(strings.TrimRight(string(list), "\x00"), ",")
+
+	var mechanism SASLMechanism
+	for _, m := range s.Mechanisms {
+		if slices.Contains(offered, m.Name()) {
+			mechanism = m
+			break
+		}
+	}
+	if mechanism == nil {
+		return authenticationError("SASLAuth.Handshake",
+			fmt.Sprintf("no suitable SASL mechanism found. server supported: %v", offered), nil)
+	}
+	logger.Debug("Selected SASL mechanism",
+		Field{Key: "offered", Value: offered},
+		Field{Key: "mechanism", Value: mechanism.Name()})
+
+	response, err := mechanism.Start(ctx)
+	if err != nil {
+		return authenticationError("SASLAuth.Handshake", "SASL mechanism failed to start", err)
+	}
+	name := mechanism.Name()
+	msg := binary.BigEndian.AppendUint32(nil, uint32(len(name))) // #nosec G115 - Mechanism names are at most 20 characters
+	msg = append(msg, name...)
+	err = writeSASLData(conn, msg, response)
+	(&SecureMemory{}).ClearBytes(response)
+	if err != nil {
+		return err
+	}
+
+	for step := 1; ; step++ {
+		challenge, err := readSASLData(conn, "SASL challenge")
+		if err != nil {
+			return err
+		}
+		fields, err := readFields(conn, "SASL completion", make([]byte, 1))
+		if err != nil {
+			return networkError("SASLAuth.Handshake", "failed to read SASL completion", err)
+		}
+		if fields.uint8() == 1 {
+			logger.Debug("SASL exchange complete", Field{Key: "steps", Value: step})
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return timeoutError("SASLAuth.Handshake", "authentication cancelled", ctx.Err())
+		default:
+		}
+		response, err := mechanism.Next(ctx, trimSASLData(challenge))
+		if err != nil {
+			return authenticationError("SASLAuth.Handshake", "SASL mechanism failed", err)
+		}
+		err = writeSASLData(conn, nil, response)
+		(&SecureMemory{}).ClearBytes(response)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// readSASLData reads a length-prefixed SASL message of the server.
+func readSASLData(conn net.Conn, field string) ([]byte, error) {
+	fields, err := readFields(conn, field+" length", make([]byte, 4))
+	if err != nil {
+		return nil, networkError("SASLAuth.Handshake", "failed to read "+field, err)
+	}
+	length := fields.uint32()
+	if length > saslMaxDataLength {
+		return nil, protocolError("SASLAuth.Handshake",
+			fmt.Sprintf("%s length %d exceeds maximum %d", field, length, saslMaxDataLength), nil)
+	}
+	data, err := readBytes(conn, field, int(length))
+	if err != nil {
+		return nil, networkError("SASLAuth.Handshake", "failed to read "+field, err)
+	}
+	return data, nil
+}
+
+// writeSASLData appends data to prefix as a length-prefixed SASL message and
+// sends it. Like the Cyrus SASL clients QEMU expects, non-nil data is sent
+// NUL-terminated and nil data as an empty message.
+func writeSASLData(conn net.Conn, prefix, data []byte) error {
+	msg := prefix
+	if data == nil {
+		msg = binary.BigEndian.AppendUint32(msg, 0)
+	} else {
+		msg = binary.BigEndian.AppendUint32(msg, uint32(len(data)+1)) // #nosec G115 - SASL messages are far shorter than 4 GiB
+		msg = append(msg, data...)
+		msg = append(msg, 0)
+	}
+	defer (&SecureMemory{}).ClearBytes(msg)
+
+	if _, err := conn.Write(msg); err != nil {
+		return networkError("SASLAuth.Handshake", "failed to send SASL data", err)
+	}
+	return nil
+}
+
+// trimSASLData removes the NUL terminator the server appends to its
+// challenges, returning nil for an empty challenge.
+func trimSASLData(data []byte) []byte {
+	if n := len(data); n > 0 && data[n-1] == 0 {
+		data = data[:n-1]
+	}
+	if len(data) == 0 {
+		return nil
+	}
+	return data
+}
+
+// SASLPlain is the PLAIN SASL mechanism of RFC 4616, which sends the
+// username and password in the clear and so belongs inside TLS.
+type SASLPlain struct {
+	// Authzid is the identity to act as, empty to act as Username.
+	Authzid  string
+	Username string
+	Password string
+
+	// Credentials, if set, supplies the username and password at handshake
+	// time in place of Username and Password.
+	Credentials CredentialProvider
+}
+
+// Name returns "PLAIN".
+func (p *SASLPlain) Name() string {
+	return "PLAIN"
+}
+
+// Start returns the credentials as the initial response.
+func (p *SASLPlain) Start(ctx context.Context) ([]byte, error) {
+	username, password, err := resolveCredentials(ctx, "SASLPlain.Start", p.Credentials, p.Username, p.Password, true)
+	if err != nil {
+		return nil, err
+	}
+	defer (&SecureMemory{}).ClearBytes(password)
+
+	response := make([]byte, 0, len(p.Authzid)+len(username)+len(password)+2)
+	response = append(append(response, p.Authzid...), 0)
+	response = append(append(response, username...), 0)
+	return append(response, password...), nil
+}
+
+// Next rejects further challenges, which PLAIN does not have.
+func (p *SASLPlain) Next(ctx context.Context, challenge []byte) ([]byte, error) {
+	return nil, errors.New("unexpected challenge for the PLAIN mechanism")
+}
+
+// ClearPassword securely clears the password from memory.
+func (p *SASLPlain) ClearPassword() {
+	if p.Password != "" {
+		p.Password = (&SecureMemory{}).ClearString(p.Password)
+	}
+}
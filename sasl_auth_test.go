@@ -0,0 +1,150 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package vnc
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+)
+
+// saslTestMechanism answers each challenge with the challenge reversed.
+type saslTestMechanism struct {
+	challenges [][]byte
+}
+
+func (m *saslTestMechanism) Name() string { return "X-REVERSE" }
+
+func (m *saslTestMechanism) Start(ctx context.Context) ([]byte, error) { return nil, nil }
+
+func (m *saslTestMechanism) Next(ctx context.Context, challenge []byte) ([]byte, error) {
+	m.challenges = append(m.challenges, challenge)
+	response := bytes.Clone(challenge)
+	for i, j := 0, len(response)-1; i < j; i, j = i+1, j-1 {
+		response[i], response[j] = response[j], response[i]
+	}
+	return response, nil
+}
+
+// writeSASLServerData writes a length-prefixed, NUL-terminated server message.
+func writeSASLServerData(conn net.Conn, data string, complete ...byte) error {
+	msg := binary.BigEndian.AppendUint32(nil, uint32(len(data)+1))
+	msg = append(append(msg, data...), 0)
+	_, err := conn.Write(append(msg, complete...))
+	return err
+}
+
+// readSASLClientData reads a length-prefixed client message.
+func readSASLClientData(conn net.Conn) ([]byte, error) {
+	var length uint32
+	if err := binary.Read(conn, binary.BigEndian, &length); err != nil {
+		return nil, err
+	}
+	data := make([]byte, length)
+	_, err := io.ReadFull(conn, data)
+	return data, err
+}
+
+func TestSASLAuth_Plain(t *testing.T) {
+	server, client := net.Pipe()
+	defer func() { _ = client.Close() }()
+
+	type result struct {
+		mechanism, response []byte
+		err                 error
+	}
+	done := make(chan result, 1)
+	go func() {
+		defer func() { _ = server.Close() }()
+		var r result
+		defer func() { done <- r }()
+		if r.err = writeSASLServerData(server, "GSSAPI,PLAIN"); r.err != nil {
+			return
+		}
+		if r.mechanism, r.err = readSASLClientData(server); r.err != nil {
+			return
+		}
+		if r.response, r.err = readSASLClientData(server); r.err != nil {
+			return
+		}
+		_, r.err = server.Write([]byte{0, 0, 0, 0, 1})
+	}()
+
+	auth := &SASLAuth{Mechanisms: []SASLMechanism{&SASLPlain{Username: "qemu", Password: "secret"}}}
+	if err := auth.Handshake(context.Background(), client); err != nil {
+		t.Fatalf("Handshake() error = %v", err)
+	}
+	r := <-done
+	if r.err != nil {
+		t.Fatalf("server error = %v", r.err)
+	}
+	if string(r.mechanism) != "PLAIN" {
+		t.Errorf("mechanism = %q, want PLAIN", r.mechanism)
+	}
+	if want := "\x00qemu\x00secret\x00"; string(r.response) != want {
+		t.Errorf("initial response = %q, want %q", r.response, want)
+	}
+}
+
+func TestSASLAuth_MultiStep(t *testing.T) {
+	server, client := net.Pipe()
+	defer func() { _ = client.Close() }()
+
+	done := make(chan [][]byte, 1)
+	go func() {
+		defer func() { _ = server.Close() }()
+		var responses [][]byte
+		defer func() { done <- responses }()
+		if writeSASLServerData(server, "X-REVERSE") != nil {
+			return
+		}
+		for range 2 {
+			// The mechanism name, then the empty initial response.
+			if _, err := readSASLClientData(server); err != nil {
+				return
+			}
+		}
+		for _, challenge := range []string{"abc", "hello"} {
+			if writeSASLServerData(server, challenge, 0) != nil {
+				return
+			}
+			response, err := readSASLClientData(server)
+			if err != nil {
+				return
+			}
+			responses = append(responses, response)
+		}
+		_ = writeSASLServerData(server, "", 1)
+	}()
+
+	mechanism := &saslTestMechanism{}
+	auth := &SASLAuth{Mechanisms: []SASLMechanism{&SASLPlain{}, mechanism}}
+	if err := auth.Handshake(context.Background(), client); err != nil {
+		t.Fatalf("Handshake() error = %v", err)
+	}
+	responses := <-done
+	if len(mechanism.challenges) != 2 || string(mechanism.challenges[1]) != "hello" {
+		t.Errorf("challenges = %q, want [abc hello]", mechanism.challenges)
+	}
+	if len(responses) != 2 || string(responses[1]) != "olleh\x00" {
+		t.Errorf("responses = %q, want [cba\\x00 olleh\\x00]", responses)
+	}
+}
+
+func TestSASLAuth_NoMutualMechanism(t *testing.T) {
+	server, client := net.Pipe()
+	defer func() { _ = client.Close() }()
+	go func() {
+		defer func() { _ = server.Close() }()
+		_ = writeSASLServerData(server, "GSSAPI")
+	}()
+
+	auth := &SASLAuth{Mechanisms: []SASLMechanism{&SASLPlain{}}}
+	if err := auth.Handshake(context.Background(), client); !IsVNCError(err, ErrAuthentication) {
+		t.Errorf("Handshake() error = %v, want authentication error", err)
+	}
+}
@@ -0,0 +1,77 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package vnc
+
+import (
+	"fmt"
+)
+
+// MaxScrollNotches bounds the number of wheel notches sent by a single scroll
+// call, so a miscomputed delta cannot flood the server with pointer events.
+const MaxScrollNotches = 1000
+
+// ScrollUp scrolls up by the given number of wheel notches at (x, y).
+func (c *ClientConn) ScrollUp(x, y uint16, notches int) error {
+	return c.scroll("ScrollUp", ButtonScrollUp, x, y, notches)
+}
+
+// ScrollDown scrolls down by the given number of wheel notches at (x, y).
+func (c *ClientConn) ScrollDown(x, y uint16, notches int) error {
+	return c.scroll("ScrollDown", ButtonScrollDown, x, y, notches)
+}
+
+// ScrollLeft scrolls left by the given number of wheel notches at (x, y).
+func (c *ClientConn) ScrollLeft(x, y uint16, notches int) error {
+	return c.scroll("ScrollLeft", ButtonScrollLeft, x, y, notches)
+}
+
+// ScrollRight scrolls right by the given number of wheel notches at (x, y).
+func (c *ClientConn) ScrollRight(x, y uint16, notches int) error {
+	return c.scroll("ScrollRight", ButtonScrollRight, x, y, notches)
+}
+
+// Scroll scrolls by dx horizontal and dy vertical wheel notches at (x, y).
+// Positive dx scrolls right and positive dy scrolls down, matching screen
+// coordinates; negative values scroll left and up. Vertical notches are sent
+// before horizontal ones.
+//
+// Each notch is sent as a press and release of the corresponding wheel button
+// (Button4 through Button7), which is how VNC servers expect scroll input.
+//
+// Example usage:
+//
+//	// Scroll three notches down and one to the right at the pointer position
+//	err := client.Scroll(x, y, 1, 3)
+func (c *ClientConn) Scroll(x, y uint16, dx, dy int) error {
+	vertical, horizontal := ButtonScrollDown, ButtonScrollRight
+	if dy < 0 {
+		vertical, dy = ButtonScrollUp, -dy
+	}
+	if dx < 0 {
+		horizontal, dx = ButtonScrollLeft, -dx
+	}
+
+	if err := c.scroll("Scroll", vertical, x, y, dy); err != nil {
+		return err
+	}
+	return c.scroll("Scroll", horizontal, x, y, dx)
+}
+
+// scroll sends notches press and release pairs of a wheel button.
+func (c *ClientConn) scroll(op string, button ButtonMask, x, y uint16, notches int) error {
+	if notches < 0 || notches > MaxScrollNotches {
+		return c.enrichError(validationError(op,
+			fmt.Sprintf("notch count %d out of range (0-%d)", notches, MaxScrollNotches), nil))
+	}
+
+	for i := 0; i < notches; i++ {
+		if err := c.PointerEvent(button, x, y); err != nil {
+			return err
+		}
+		if err := c.PointerEvent(0, x, y); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package vnc
+
+import (
+	"testing"
+
+	"github.com/tenthirtyam/go-vnc/rfb"
+)
+
+// receivePointers reads n pointer events recorded by the update server.
+func receivePointers(t *testing.T, srv *updateServer, n int) []rfb.PointerEvent {
+	t.Helper()
+	events := make([]rfb.PointerEvent, n)
+	for i := range events {
+		events[i] = <-srv.pointers
+	}
+	return events
+}
+
+func TestScroll_Directions(t *testing.T) {
+	srv, conn := newUpdateServer(t, 16, 16)
+
+	tests := []struct {
+		name   string
+		scroll func(x, y uint16, notches int) error
+		button ButtonMask
+	}{
+		{name: "Up", scroll: conn.ScrollUp, button: Button4},
+		{name: "Down", scroll: conn.ScrollDown, button: Button5},
+		{name: "Left", scroll: conn.ScrollLeft, button: Button6},
+		{name: "Right", scroll: conn.ScrollRight, button: Button7},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.scroll(3, 4, 2); err != nil {
+				t.Fatal(err)
+			}
+			for i, ev := range receivePointers(t, srv, 4) {
+				want := rfb.PointerEvent{X: 3, Y: 4}
+				if i%2 == 0 {
+					want.Mask = uint8(tt.button)
+				}
+				if ev != want {
+					t.Errorf("event %d = %+v, want %+v", i, ev, want)
+				}
+			}
+		})
+	}
+}
+
+func TestScroll_Delta(t *testing.T) {
+	srv, conn := newUpdateServer(t, 16, 16)
+
+	if err := conn.Scroll(1, 1, -1, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	var presses []ButtonMask
+	for _, ev := range receivePointers(t, srv, 6) {
+		if ev.Mask != 0 {
+			presses = append(presses, ButtonMask(ev.Mask))
+		}
+	}
+	want := []ButtonMask{ButtonScrollDown, ButtonScrollDown, ButtonScrollLeft}
+	if len(presses) != len(want) {
+		t.Fatalf("presses = %v, want %v", presses, want)
+	}
+	for i := range want {
+		if presses[i] != want[i] {
+			t.Errorf("press %d = %v, want %v", i, presses[i], want[i])
+		}
+	}
+
+	if err := conn.Scroll(1, 1, 0, 0); err != nil {
+		t.Errorf("zero scroll failed: %v", err)
+	}
+	if err := conn.ScrollUp(1, 1, MaxScrollNotches+1); !IsVNCError(err, ErrValidation) {
+		t.Errorf("expected validation error for excessive notches, got %v", err)
+	}
+	if err := conn.ScrollUp(1, 1, -1); !IsVNCError(err, ErrValidation) {
+		t.Errorf("expected validation error for negative notches, got %v", err)
+	}
+}
@@ -114,8 +114,9 @@ type SecureDESCipher struct {
 	secMem *SecureMemory
 }
 
-// newSecureDESCipher creates a new secure DES cipher for VNC authentication.
-func newSecureDESCipher() *SecureDESCipher {
+// NewSecureDESCipher creates a new secure DES cipher for VNC authentication.
+// Servers use it to compute the response expected for a challenge.
+func NewSecureDESCipher() *SecureDESCipher {
 	return &SecureDESCipher{
 		secMem: &SecureMemory{},
 	}
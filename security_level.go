@@ -0,0 +1,145 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package vnc
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/tenthirtyam/go-vnc/rfb"
+)
+
+// SecurityLevel is the protection a security handshake provides, from none
+// to an authenticated and encrypted session. Levels are ordered, so each
+// requires what the lower ones do.
+type SecurityLevel int
+
+// Security levels, in increasing order.
+const (
+	// AllowNone accepts every security type, including None, which neither
+	// authenticates the client nor encrypts the session.
+	AllowNone SecurityLevel = iota
+
+	// RequireAuthenticated requires the client to authenticate, as with VNC
+	// authentication, but accepts an unencrypted session.
+	RequireAuthenticated
+
+	// RequireEncrypted requires an authenticated session encrypted with TLS
+	// or AES, such as by the X509 sub-types of VeNCrypt or RSA-AES. The X509
+	// None sub-type of VeNCrypt counts as authenticated when the client
+	// presents a certificate. Anonymous TLS, as used by TLSAuth and the TLS
+	// sub-types of VeNCrypt, does not authenticate the server, so a man in
+	// the middle could read the session; it only counts as
+	// RequireAuthenticated. RSA-AES authenticates the server only if
+	// RSAAESAuth.VerifyServerKey is set.
+	RequireEncrypted
+)
+
+// String returns the name of the level.
+func (l SecurityLevel) String() string {
+	switch l {
+	case AllowNone:
+		return "none"
+	case RequireAuthenticated:
+		return "authenticated"
+	case RequireEncrypted:
+		return "encrypted"
+	default:
+		return fmt.Sprintf("SecurityLevel(%d)", int(l))
+	}
+}
+
+// WithMinSecurity rejects security handshakes below level, so that a server
+// or an attacker on the path cannot silently downgrade the session by
+// offering only None or VNC authentication:
+//
+//	client, err := vnc.ClientWithOptions(ctx, conn,
+//		vnc.WithAuth(&vnc.VeNCryptAuth{Username: "admin", Password: password}),
+//		vnc.WithMinSecurity(vnc.RequireEncrypted))
+//
+// Auth methods below level are not selected, and a server whose security
+// types all fall below it fails the handshake with an ErrAuthentication
+// error before any credentials are sent. VeNCryptAuth and TLSAuth also skip
+// the sub-types and inner methods below level. The level of a method is
+// derived from its security type; custom methods can report their own with
+// a SecurityLevel() SecurityLevel method.
+func WithMinSecurity(level SecurityLevel) ClientOption {
+	return func(cfg *ClientConfig) {
+		cfg.MinSecurity = level
+	}
+}
+
+// minSecurityKey is the context key under which the handshake passes the
+// minimum security level to the authentication methods.
+type minSecurityKey struct{}
+
+// minSecurity returns the minimum security level the handshake passed in ctx.
+func minSecurity(ctx context.Context) SecurityLevel {
+	level, _ := ctx.Value(minSecurityKey{}).(SecurityLevel)
+	return level
+}
+
+// authSecurityLevel returns the highest level auth can provide when
+// negotiated as securityType.
+func authSecurityLevel(auth ClientAuth, securityType uint8) SecurityLevel {
+	if leveled, ok := auth.(interface{ SecurityLevel() SecurityLevel }); ok {
+		return leveled.SecurityLevel()
+	}
+	switch securityType {
+	case rfb.SecurityNone:
+		return AllowNone
+	case rfb.SecurityVeNCrypt, rfb.SecurityRA2, rfb.SecurityRA256:
+		// VeNCrypt enforces the level on its sub-types.
+		return RequireEncrypted
+	default:
+		return RequireAuthenticated
+	}
+}
+
+// securityTypesMeeting returns the offered security types whose methods, as
+// created by the registry, provide at least level.
+func (r *AuthRegistry) securityTypesMeeting(offered []uint8, level SecurityLevel) []uint8 {
+	if level == AllowNone {
+		return offered
+	}
+	var types []uint8
+	for _, securityType := range offered {
+		var auth ClientAuth
+		if r.IsSupported(securityType) {
+			auth, _ = r.CreateAuth(securityType)
+		}
+		if authSecurityLevel(auth, securityType) >= level {
+			types = append(types, securityType)
+		}
+	}
+	return types
+}
+
+// minSecurityError logs and returns the error of a handshake in which no
+// security type offered by the server meets MinSecurity.
+func (c *ClientConn) minSecurityError(securityTypes []uint8) error {
+	c.logger.Error("No authentication method meets the minimum security level",
+		Field{Key: "server_types", Value: securityTypes},
+		Field{Key: "min_security", Value: c.config.MinSecurity.String()})
+	return authenticationError("handshake", fmt.Sprintf("no auth scheme meeting the minimum security level %s found. server supported: %#v", c.config.MinSecurity, securityTypes), nil)
+}
+
+// veNCryptSubTypeLevel returns the level of a VeNCrypt sub-type. The None
+// sub-types authenticate the client only by the certificate it presents, and
+// the anonymous TLS sub-types do not authenticate the server.
+func veNCryptSubTypeLevel(subType uint32, clientCertificate bool) SecurityLevel {
+	switch subType {
+	case rfb.VeNCryptPlain, rfb.VeNCryptTLSVnc, rfb.VeNCryptTLSPlain:
+		return RequireAuthenticated
+	case rfb.VeNCryptTLSNone:
+		return AllowNone
+	case rfb.VeNCryptX509None:
+		if clientCertificate {
+			return RequireEncrypted
+		}
+		return AllowNone
+	default:
+		return RequireEncrypted
+	}
+}
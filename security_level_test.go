@@ -0,0 +1,180 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package vnc
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/tenthirtyam/go-vnc/rfb"
+)
+
+func TestMinSecurity_Handshake(t *testing.T) {
+	tests := []struct {
+		name    string
+		auth    ClientAuth
+		level   SecurityLevel
+		wantErr bool
+	}{
+		{"password allowed", NewPasswordAuth("letmein"), RequireAuthenticated, false},
+		{"password below encrypted", NewPasswordAuth("letmein"), RequireEncrypted, true},
+		{"default level", NewPasswordAuth("letmein"), AllowNone, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			serverConn, clientConn := net.Pipe()
+			go func() {
+				defer func() { _ = serverConn.Close() }()
+				_ = servePasswordLogin(serverConn, "letmein", "bad password")
+			}()
+
+			conn, err := ClientWithOptions(context.Background(), clientConn,
+				WithAuth(tt.auth), WithMinSecurity(tt.level))
+			if !tt.wantErr {
+				if err != nil {
+					t.Fatalf("ClientWithOptions() error = %v", err)
+				}
+				_ = conn.Close()
+				return
+			}
+			if !IsVNCError(err, ErrAuthentication) || !strings.Contains(err.Error(), "minimum security level") {
+				t.Errorf("ClientWithOptions() error = %v, want minimum security level error", err)
+			}
+		})
+	}
+}
+
+func TestMinSecurity_RejectsNone(t *testing.T) {
+	_, clientConn := startUpdateServer(t, 4, 4)
+	_, err := ClientWithOptions(context.Background(), clientConn,
+		WithAuth(&ClientAuthNone{}), WithMinSecurity(RequireAuthenticated))
+	if !IsVNCError(err, ErrAuthentication) {
+		t.Errorf("ClientWithOptions() error = %v, want authentication error", err)
+	}
+}
+
+func TestMinSecurity_VeNCryptSubTypes(t *testing.T) {
+	offered := []uint32{rfb.VeNCryptPlain, rfb.VeNCryptX509None, rfb.VeNCryptX509Vnc}
+	withCert := &VeNCryptAuth{TLSConfig: &tls.Config{Certificates: []tls.Certificate{{}}}, SubTypes: offered}
+	tests := []struct {
+		name   string
+		auth   *VeNCryptAuth
+		level  SecurityLevel
+		want   uint32
+		wantOK bool
+	}{
+		{"plain allowed", &VeNCryptAuth{SubTypes: offered}, RequireAuthenticated, rfb.VeNCryptPlain, true},
+		{"plain below encrypted", &VeNCryptAuth{SubTypes: offered}, RequireEncrypted, rfb.VeNCryptX509Vnc, true},
+		{"X509 None with client certificate", withCert, RequireEncrypted, rfb.VeNCryptX509None, true},
+		{"only plain offered", &VeNCryptAuth{SubTypes: offered[:1]}, RequireEncrypted, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := tt.auth.chooseSubType(offered, tt.level)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("chooseSubType() = %d, %v, want %d, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestMinSecurity_TLSInnerAuth(t *testing.T) {
+	auth := &TLSAuth{Auth: []ClientAuth{&ClientAuthNone{}, NewPasswordAuth("secret")}}
+	offered := []uint8{rfb.SecurityNone, rfb.SecurityVNCAuth}
+
+	if inner, ok := auth.chooseAuth(offered, AllowNone); !ok || inner.SecurityType() != rfb.SecurityNone {
+		t.Errorf("chooseAuth(AllowNone) = %v, %v, want None", inner, ok)
+	}
+	if inner, ok := auth.chooseAuth(offered, RequireAuthenticated); !ok || inner.SecurityType() != rfb.SecurityVNCAuth {
+		t.Errorf("chooseAuth(RequireAuthenticated) = %v, %v, want VNC authentication", inner, ok)
+	}
+	// Anonymous TLS does not authenticate the server.
+	if inner, ok := auth.chooseAuth(offered, RequireEncrypted); ok {
+		t.Errorf("chooseAuth(RequireEncrypted) = %v, want no method", inner)
+	}
+}
+
+func TestMinSecurity_AnonymousTLS(t *testing.T) {
+	if got := authSecurityLevel(&TLSAuth{}, rfb.SecurityTLS); got != RequireAuthenticated {
+		t.Errorf("TLS security level = %s, want %s", got, RequireAuthenticated)
+	}
+
+	offered := []uint32{rfb.VeNCryptTLSVnc, rfb.VeNCryptTLSPlain}
+	auth := &VeNCryptAuth{
+		SubTypes: offered,
+		AnonymousTLS: func(_ context.Context, conn net.Conn) (net.Conn, error) {
+			return conn, nil
+		},
+	}
+	if got, ok := auth.chooseSubType(offered, RequireAuthenticated); !ok || got != rfb.VeNCryptTLSVnc {
+		t.Errorf("chooseSubType(RequireAuthenticated) = %d, %v, want TLSVnc", got, ok)
+	}
+	if got, ok := auth.chooseSubType(offered, RequireEncrypted); ok {
+		t.Errorf("chooseSubType(RequireEncrypted) = %d, want no anonymous TLS sub-type", got)
+	}
+}
+
+func TestSecurityLevel_String(t *testing.T) {
+	for level, want := range map[SecurityLevel]string{
+		AllowNone:            "none",
+		RequireAuthenticated: "authenticated",
+		RequireEncrypted:     "encrypted",
+		SecurityLevel(9):     "SecurityLevel(9)",
+	} {
+		if got := level.String(); got != want {
+			t.Errorf("%d.String() = %q, want %q", int(level), got, want)
+		}
+	}
+}
+
+func TestMinSecurity_RegistryPrefersWeaker(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	selected := make(chan uint8, 1)
+	go func() {
+		defer func() { _ = serverConn.Close() }()
+		defer close(selected)
+		if rfb.WriteProtocolVersion(serverConn, 3, 8) != nil {
+			return
+		}
+		if _, _, err := rfb.ReadProtocolVersion(serverConn); err != nil {
+			return
+		}
+		if rfb.WriteSecurityTypes(serverConn, []uint8{rfb.SecurityNone, rfb.SecurityVeNCrypt}) != nil {
+			return
+		}
+		if securityType, err := rfb.ReadSecurityType(serverConn); err == nil {
+			selected <- securityType
+		}
+	}()
+
+	registry := NewAuthRegistry()
+	registry.Register(rfb.SecurityVeNCrypt, func() ClientAuth { return &VeNCryptAuth{} })
+	_, _ = ClientWithOptions(context.Background(), clientConn,
+		WithAuth(&ClientAuthNone{}, &VeNCryptAuth{}),
+		WithAuthRegistry(registry),
+		WithMinSecurity(RequireEncrypted))
+
+	if got := <-selected; got != rfb.SecurityVeNCrypt {
+		t.Errorf("selected security type = %d, want VeNCrypt", got)
+	}
+}
+
+func TestMinSecurity_RegistryRejectsWeaker(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	go func() {
+		defer func() { _ = serverConn.Close() }()
+		_ = servePasswordLogin(serverConn, "letmein", "bad password")
+	}()
+
+	_, err := ClientWithOptions(context.Background(), clientConn,
+		WithAuth(NewPasswordAuth("letmein")),
+		WithAuthRegistry(NewAuthRegistry()),
+		WithMinSecurity(RequireEncrypted))
+	if !IsVNCError(err, ErrAuthentication) || !strings.Contains(err.Error(), "minimum security level") {
+		t.Errorf("ClientWithOptions() error = %v, want minimum security level error", err)
+	}
+}
@@ -104,7 +104,7 @@ func TestSecurity_ConstantTimeCompare(t *testing.T) {
 }
 
 func TestSecurity_EncryptVNCChallenge(t *testing.T) {
-	cipher := newSecureDESCipher()
+	cipher := NewSecureDESCipher()
 
 	// Test with valid challenge
 	challenge := make([]byte, VNCChallengeSize)
@@ -155,7 +155,7 @@ func TestSecurity_EncryptVNCChallenge(t *testing.T) {
 }
 
 func TestSecurity_ReverseBitsSecure(t *testing.T) {
-	cipher := newSecureDESCipher()
+	cipher := NewSecureDESCipher()
 
 	tests := []struct {
 		input    byte
@@ -392,7 +392,7 @@ func TestSecurity_NewPasswordAuth(t *testing.T) {
 }
 
 func BenchmarkSecureDESCipher_EncryptVNCChallenge(b *testing.B) {
-	cipher := newSecureDESCipher()
+	cipher := NewSecureDESCipher()
 	challenge := make([]byte, VNCChallengeSize)
 	if _, err := rand.Read(challenge); err != nil {
 		b.Fatalf("Failed to generate random challenge: %v", err)
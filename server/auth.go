@@ -0,0 +1,172 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+
+	"github.com/tenthirtyam/go-vnc"
+	"github.com/tenthirtyam/go-vnc/rfb"
+)
+
+// Access is the level of access granted to a viewer.
+type Access int
+
+const (
+	// AccessDenied refuses the viewer.
+	AccessDenied Access = iota
+
+	// AccessViewOnly lets the viewer watch the desktop; its key, pointer, and
+	// cut text messages are discarded.
+	AccessViewOnly
+
+	// AccessInteractive lets the viewer control the desktop.
+	AccessInteractive
+)
+
+// String returns the name of the access level.
+func (a Access) String() string {
+	switch a {
+	case AccessDenied:
+		return "denied"
+	case AccessViewOnly:
+		return "view-only"
+	case AccessInteractive:
+		return "interactive"
+	default:
+		return fmt.Sprintf("Access(%d)", int(a))
+	}
+}
+
+// Identity describes who a viewer authenticated as.
+type Identity struct {
+	// SecurityType is the RFB security type the viewer authenticated with.
+	SecurityType uint8
+
+	// User is the authenticated user name. It is empty for security types
+	// without user names, such as None and VNC authentication.
+	User string
+}
+
+// ErrAuthenticationFailed is returned by Authenticators when the viewer
+// presented wrong credentials.
+var ErrAuthenticationFailed = errors.New("server: authentication failed")
+
+// Authenticator is the server side of an RFB security type.
+type Authenticator interface {
+	// SecurityType returns the RFB security type number offered to viewers.
+	SecurityType() uint8
+
+	// Authenticate runs the security handshake after the viewer selected the
+	// security type. It returns the viewer's identity and the access its
+	// credentials request, or ErrAuthenticationFailed.
+	Authenticate(ctx context.Context, conn net.Conn) (Identity, Access, error)
+}
+
+// NoneAuth is the None security type, which lets any viewer in with
+// interactive access unless an Authorizer decides otherwise.
+type NoneAuth struct{}
+
+// SecurityType returns rfb.SecurityNone.
+func (NoneAuth) SecurityType() uint8 {
+	return rfb.SecurityNone
+}
+
+// Authenticate accepts the viewer without an exchange.
+func (NoneAuth) Authenticate(context.Context, net.Conn) (Identity, Access, error) {
+	return Identity{SecurityType: rfb.SecurityNone}, AccessInteractive, nil
+}
+
+// PasswordAuth is VNC authentication (security type 2). Like TigerVNC and
+// x11vnc it accepts an optional second password that grants view-only access.
+// Only the first eight characters of each password are significant.
+type PasswordAuth struct {
+	// Password grants interactive access.
+	Password string
+
+	// ViewOnlyPassword, if not empty, grants view-only access.
+	ViewOnlyPassword string
+}
+
+// SecurityType returns rfb.SecurityVNCAuth.
+func (*PasswordAuth) SecurityType() uint8 {
+	return rfb.SecurityVNCAuth
+}
+
+// Authenticate sends a random challenge and checks the viewer's response
+// against both passwords.
+func (a *PasswordAuth) Authenticate(_ context.Context, conn net.Conn) (Identity, Access, error) {
+	challenge := make([]byte, vnc.VNCChallengeSize)
+	if _, err := rand.Read(challenge); err != nil {
+		return Identity{}, AccessDenied, fmt.Errorf("server: generate challenge: %w", err)
+	}
+	if _, err := conn.Write(challenge); err != nil {
+		return Identity{}, AccessDenied, err
+	}
+
+	response := make([]byte, vnc.VNCChallengeSize)
+	if _, err := io.ReadFull(conn, response); err != nil {
+		return Identity{}, AccessDenied, err
+	}
+
+	identity := Identity{SecurityType: rfb.SecurityVNCAuth}
+	candidates := []struct {
+		password string
+		access   Access
+	}{
+		{a.Password, AccessInteractive},
+		{a.ViewOnlyPassword, AccessViewOnly},
+	}
+	for _, candidate := range candidates {
+		if candidate.password == "" {
+			continue
+		}
+		expected, err := vnc.NewSecureDESCipher().EncryptVNCChallenge(candidate.password, challenge)
+		if err != nil {
+			return Identity{}, AccessDenied, err
+		}
+		if subtle.ConstantTimeCompare(expected, response) == 1 {
+			return identity, candidate.access, nil
+		}
+	}
+	return Identity{}, AccessDenied, ErrAuthenticationFailed
+}
+
+// AccessRequest is the information an Authorizer decides on.
+type AccessRequest struct {
+	// Identity is who the viewer authenticated as.
+	Identity Identity
+
+	// RemoteAddr is the address of the viewer.
+	RemoteAddr net.Addr
+
+	// Requested is the access the viewer's credentials grant, such as
+	// AccessViewOnly for a view-only password.
+	Requested Access
+}
+
+// Authorizer decides whether an authenticated viewer gets access to the
+// desktop, for example by looking up the identity in a role-based access
+// control system. It is consulted after authentication and before the
+// viewer is told the result, so a denial is reported like a failed login.
+//
+// The granted access is capped at AccessRequest.Requested: an Authorizer can
+// downgrade a viewer to view-only but cannot upgrade a view-only password.
+type Authorizer interface {
+	Authorize(ctx context.Context, req AccessRequest) (Access, error)
+}
+
+// AuthorizerFunc adapts a function to the Authorizer interface.
+type AuthorizerFunc func(ctx context.Context, req AccessRequest) (Access, error)
+
+// Authorize calls f.
+func (f AuthorizerFunc) Authorize(ctx context.Context, req AccessRequest) (Access, error) {
+	return f(ctx, req)
+}
@@ -0,0 +1,375 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"io"
+	"net"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/tenthirtyam/go-vnc"
+	"github.com/tenthirtyam/go-vnc/rfb"
+)
+
+// DefaultPixelFormat is the pixel format Display.ServerInit announces:
+// 32-bit little-endian true color with 8 bits per component.
+var DefaultPixelFormat = rfb.PixelFormat{
+	BPP: 32, Depth: 24, TrueColor: true,
+	RedMax: 255, GreenMax: 255, BlueMax: 255,
+	RedShift: 16, GreenShift: 8, BlueShift: 0,
+}
+
+// Display is a desktop shared with any number of viewers. The application
+// draws into it with Update, replaces whole frames with Swap, renders through
+// a Canvas, or mirrors a real desktop with Mirror; Serve sends each viewer
+// the damaged parts of the desktop as it asks for them. A Display must be
+// created with NewDisplay.
+//
+// Damage is tracked per viewer and coalesced until the viewer requests an
+// update, so a viewer on a slow link receives fewer, larger updates rather
+// than falling behind, and a fast viewer is not held back by a slow one.
+type Display struct {
+	// MinUpdateInterval, if positive, is the shortest time between two
+	// updates sent to a viewer. Damage accumulated in between is sent in
+	// the next update.
+	MinUpdateInterval time.Duration
+
+	// Input, if set, receives the key, pointer, and clipboard input of
+	// viewers with interactive access. Input from view-only viewers, and
+	// all input when Input is nil, is discarded.
+	Input InputSink
+
+	// Logger receives diagnostics. Nil disables logging.
+	Logger vnc.Logger
+
+	mu      sync.RWMutex
+	fb      *image.RGBA
+	viewers map[*scheduler]struct{}
+}
+
+// NewDisplay returns a black display of the given size.
+func NewDisplay(width, height int) *Display {
+	return &Display{
+		fb:      image.NewRGBA(image.Rect(0, 0, width, height)),
+		viewers: make(map[*scheduler]struct{}),
+	}
+}
+
+// Bounds returns the bounds of the display, which start at the origin.
+func (d *Display) Bounds() image.Rectangle {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.fb.Rect
+}
+
+// ServerInit returns the ServerInit message for the display with the given
+// desktop name, to be passed to Accept in Config.ServerInit.
+func (d *Display) ServerInit(name string) rfb.ServerInit {
+	bounds := d.Bounds()
+	return rfb.ServerInit{
+		Width:       uint16(bounds.Dx()), // #nosec G115 - displays are at most 65535 pixels wide
+		Height:      uint16(bounds.Dy()), // #nosec G115 - displays are at most 65535 pixels high
+		PixelFormat: DefaultPixelFormat,
+		Name:        name,
+	}
+}
+
+// Resize changes the size of the display, keeping the pixels within both
+// sizes and filling the rest with black. Viewers that support the
+// DesktopSize pseudo-encoding are sent the new size; others keep seeing the
+// part of the display within their size. A Canvas of the display must be
+// recreated after a resize.
+func (d *Display) Resize(width, height int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	fb := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(fb, fb.Rect, d.fb, image.Point{}, draw.Src)
+	d.fb = fb
+	for s := range d.viewers {
+		s.resize(fb.Rect)
+	}
+}
+
+// Update copies the pixels of src within r, in display coordinates, to the
+// display and marks them damaged for every viewer.
+func (d *Display) Update(src image.Image, r image.Rectangle) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	r = r.Intersect(d.fb.Rect)
+	if r.Empty() {
+		return
+	}
+	draw.Draw(d.fb, r, src, r.Min, draw.Src)
+	d.damageLocked(r)
+}
+
+// damageLocked marks r as changed for every viewer. d.mu must be held.
+func (d *Display) damageLocked(r image.Rectangle) {
+	for s := range d.viewers {
+		s.damage(r)
+	}
+}
+
+// Serve sends updates of the display to a viewer and reads its messages
+// until ctx ends or the connection fails. The viewer's input events and cut
+// text are passed to Input if the viewer has interactive access. conn is
+// closed when Serve returns.
+func (d *Display) Serve(ctx context.Context, conn *Conn) error {
+	defer func() { _ = conn.Close() }()
+
+	s := newScheduler(conn.pixelFormat)
+	d.mu.Lock()
+	d.viewers[s] = struct{}{}
+	d.mu.Unlock()
+	defer func() {
+		d.mu.Lock()
+		delete(d.viewers, s)
+		d.mu.Unlock()
+	}()
+
+	sessionCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stop := context.AfterFunc(sessionCtx, func() { _ = conn.Close() })
+	defer stop()
+
+	readErr := make(chan error, 1)
+	go func() {
+		readErr <- d.readViewer(conn, s)
+		cancel()
+	}()
+
+	sendErr := d.sendUpdates(sessionCtx, conn, s)
+	_ = conn.Close()
+	err := <-readErr
+	if sendErr != nil {
+		err = sendErr
+	}
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) || errors.Is(err, io.ErrClosedPipe) {
+		return nil
+	}
+	return err
+}
+
+// logger returns the configured logger or a no-op logger.
+func (d *Display) logger() vnc.Logger {
+	if d.Logger == nil {
+		return &vnc.NoOpLogger{}
+	}
+	return d.Logger
+}
+
+// sendUpdates waits for requested damage and sends it until ctx ends.
+func (d *Display) sendUpdates(ctx context.Context, conn *Conn, s *scheduler) error {
+	var last time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-s.wake:
+		}
+
+		if wait := d.MinUpdateInterval - time.Since(last); d.MinUpdateInterval > 0 && wait > 0 {
+			if err := sleepContext(ctx, wait); err != nil {
+				return nil
+			}
+		}
+
+		s.sending.Lock()
+		u, ok := s.next()
+		var err error
+		if ok {
+			err = d.sendUpdate(conn, u)
+		}
+		s.sending.Unlock()
+		if err != nil {
+			return err
+		}
+		if ok {
+			last = time.Now()
+		}
+	}
+}
+
+// sendUpdate sends u with the rectangles of the display in Raw encoding.
+func (d *Display) sendUpdate(conn *Conn, u update) error {
+	d.mu.RLock()
+	buf := appendUpdate(nil, d.fb, u)
+	d.mu.RUnlock()
+	return conn.write(buf)
+}
+
+// supportedFenceFlags are the fence flags a Display honors. readViewer
+// handles the viewer's messages in order and answers a fence before reading
+// the next message, which satisfies all of them.
+const supportedFenceFlags = rfb.FenceBlockBefore | rfb.FenceBlockAfter | rfb.FenceSyncNext
+
+// readViewer handles the viewer's messages until the connection fails.
+func (d *Display) readViewer(conn *Conn, s *scheduler) error {
+	r := conn.conn
+	fence, continuous := false, false
+	for {
+		msgType, err := rfb.ReadMessageType(r)
+		if err != nil {
+			return err
+		}
+
+		switch msgType {
+		case rfb.SetPixelFormatMsg:
+			pf, err := rfb.ReadSetPixelFormat(r)
+			if err != nil {
+				return err
+			}
+			if err := validatePixelFormat(pf); err != nil {
+				return err
+			}
+			s.setPixelFormat(pf)
+		case rfb.SetEncodingsMsg:
+			encodings, err := rfb.ReadSetEncodings(r)
+			if err != nil {
+				return err
+			}
+			s.setEncodings(encodings)
+			d.logger().Debug("Viewer set encodings",
+				vnc.Field{Key: "remote_addr", Value: conn.RemoteAddr()},
+				vnc.Field{Key: "encodings", Value: encodings})
+			// A fence request confirms the Fence pseudo-encoding.
+			if !fence && slices.Contains(encodings, rfb.PseudoEncodingFence) {
+				fence = true
+				if err := conn.Fence(rfb.FenceRequest|supportedFenceFlags, nil); err != nil {
+					return err
+				}
+			}
+			// An EndOfContinuousUpdates confirms the ContinuousUpdates
+			// pseudo-encoding.
+			if !continuous && slices.Contains(encodings, rfb.PseudoEncodingContinuousUpdates) {
+				continuous = true
+				if err := conn.Send(new(vnc.EndOfContinuousUpdatesMessage)); err != nil {
+					return err
+				}
+			}
+		case rfb.FramebufferUpdateRequestMsg:
+			req, err := rfb.ReadFramebufferUpdateRequest(r)
+			if err != nil {
+				return err
+			}
+			rect := image.Rect(int(req.X), int(req.Y), int(req.X)+int(req.Width), int(req.Y)+int(req.Height))
+			s.request(rect.Intersect(d.Bounds()), req.Incremental)
+		case rfb.EnableContinuousUpdatesMsg:
+			req, err := rfb.ReadEnableContinuousUpdates(r)
+			if err != nil {
+				return err
+			}
+			if req.Enable {
+				rect := image.Rect(int(req.X), int(req.Y), int(req.X)+int(req.Width), int(req.Y)+int(req.Height))
+				s.setContinuous(rect.Intersect(d.Bounds()))
+			} else if err := d.endContinuousUpdates(conn, s); err != nil {
+				return err
+			}
+		case rfb.KeyEventMsg:
+			ev, err := rfb.ReadKeyEvent(r)
+			if err != nil {
+				return err
+			}
+			if sink := d.input(conn); sink != nil {
+				d.reportInputError(conn, "key", sink.Key(conn, ev))
+			}
+		case rfb.PointerEventMsg:
+			ev, err := rfb.ReadPointerEvent(r)
+			if err != nil {
+				return err
+			}
+			if sink := d.input(conn); sink != nil {
+				d.reportInputError(conn, "pointer", sink.Pointer(conn, ev))
+			}
+		case rfb.ClientCutTextMsg:
+			text, err := rfb.ReadCutText(r)
+			if err != nil {
+				return err
+			}
+			if sink := d.input(conn); sink != nil {
+				d.reportInputError(conn, "cut_text", sink.CutText(conn, decodeLatin1(text)))
+			}
+		case rfb.FenceMsg:
+			f, err := rfb.ReadFence(r)
+			if err != nil {
+				return err
+			}
+			if f.Flags&rfb.FenceRequest != 0 {
+				resp := f.Response(supportedFenceFlags)
+				if err := conn.Fence(resp.Flags, resp.Payload); err != nil {
+					return err
+				}
+			}
+		default:
+			return fmt.Errorf("server: unsupported viewer message type %d", msgType)
+		}
+	}
+}
+
+// endContinuousUpdates disables the continuous updates of s and tells the
+// viewer with an EndOfContinuousUpdates message, which follows the update in
+// progress so that no continuous update arrives after it.
+func (d *Display) endContinuousUpdates(conn *Conn, s *scheduler) error {
+	s.sending.Lock()
+	defer s.sending.Unlock()
+	s.setContinuous(image.Rectangle{})
+	return conn.Send(new(vnc.EndOfContinuousUpdatesMessage))
+}
+
+// input returns the sink for the input of conn, or nil if it is discarded.
+func (d *Display) input(conn *Conn) InputSink {
+	if conn.Access() != AccessInteractive {
+		return nil
+	}
+	return d.Input
+}
+
+// reportInputError logs an error returned by the input sink.
+func (d *Display) reportInputError(conn *Conn, event string, err error) {
+	if err == nil {
+		return
+	}
+	d.logger().Warn("Input sink failed",
+		vnc.Field{Key: "remote_addr", Value: conn.RemoteAddr()},
+		vnc.Field{Key: "event", Value: event},
+		vnc.Field{Key: "error", Value: err})
+}
+
+// validatePixelFormat rejects pixel formats Display cannot encode.
+func validatePixelFormat(pf rfb.PixelFormat) error {
+	if !pf.TrueColor {
+		return errors.New("server: color map pixel formats are not supported")
+	}
+	switch pf.BPP {
+	case 8, 16, 32:
+		return nil
+	default:
+		return fmt.Errorf("server: unsupported bits per pixel %d", pf.BPP)
+	}
+}
+
+// sleepContext waits for d or until ctx ends.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,205 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package server
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/tenthirtyam/go-vnc"
+)
+
+// displayHarness serves a Display to a vnc client over a pipe.
+type displayHarness struct {
+	display *Display
+	client  *vnc.ClientConn
+	msgs    chan vnc.ServerMessage
+}
+
+// newDisplayHarness serves display to a client created with options.
+func newDisplayHarness(t *testing.T, display *Display, options ...vnc.ClientOption) *displayHarness {
+	t.Helper()
+
+	viewerConn, serverConn := net.Pipe()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		conn, err := Accept(ctx, serverConn, &Config{ServerInit: display.ServerInit("display")})
+		if err != nil {
+			_ = serverConn.Close()
+			return
+		}
+		_ = display.Serve(ctx, conn)
+	}()
+
+	h := &displayHarness{display: display, msgs: make(chan vnc.ServerMessage, 16)}
+	options = append([]vnc.ClientOption{
+		vnc.WithServerMessageChannel(h.msgs),
+		vnc.WithConnectTimeout(5 * time.Second),
+	}, options...)
+	client, err := vnc.ClientWithOptions(context.Background(), viewerConn, options...)
+	if err != nil {
+		cancel()
+		t.Fatalf("client handshake: %v", err)
+	}
+	h.client = client
+	t.Cleanup(func() {
+		_ = client.Close()
+		cancel()
+		<-done
+	})
+	return h
+}
+
+// fill paints r of the display with c.
+func (h *displayHarness) fill(r image.Rectangle, c color.RGBA) {
+	h.display.Update(&image.Uniform{C: c}, r)
+}
+
+// update waits for the next FramebufferUpdate.
+func (h *displayHarness) update(t *testing.T) *vnc.FramebufferUpdateMessage {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case msg := <-h.msgs:
+			if update, ok := msg.(*vnc.FramebufferUpdateMessage); ok {
+				return update
+			}
+		case <-timeout:
+			t.Fatal("timed out waiting for a FramebufferUpdate")
+			return nil
+		}
+	}
+}
+
+var (
+	red  = color.RGBA{R: 0xff, A: 0xff}
+	blue = color.RGBA{B: 0xff, A: 0xff}
+)
+
+func TestDisplay_Screenshot(t *testing.T) {
+	tests := []struct {
+		name   string
+		format *vnc.PixelFormat
+	}{
+		{name: "Default"},
+		{name: "RGB565", format: vnc.PixelFormat16BitRGB565},
+		{name: "BGR233", format: vnc.PixelFormat8BitBGR233},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			display := NewDisplay(16, 8)
+			display.Update(&image.Uniform{C: red}, image.Rect(0, 0, 8, 8))
+
+			var options []vnc.ClientOption
+			if tt.format != nil {
+				options = append(options, vnc.WithPixelFormat(tt.format))
+			}
+			h := newDisplayHarness(t, display, options...)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			img, err := h.client.Screenshot(ctx)
+			if err != nil {
+				t.Fatalf("Screenshot: %v", err)
+			}
+			if got := img.RGBAAt(2, 2); got != red {
+				t.Errorf("pixel (2,2) = %v, want red", got)
+			}
+			if got := img.RGBAAt(12, 2); got != (color.RGBA{A: 0xff}) {
+				t.Errorf("pixel (12,2) = %v, want black", got)
+			}
+		})
+	}
+}
+
+func TestDisplay_IncrementalUpdate(t *testing.T) {
+	display := NewDisplay(16, 8)
+	h := newDisplayHarness(t, display)
+
+	if err := h.client.FramebufferUpdateRequest(false, 0, 0, 16, 8); err != nil {
+		t.Fatal(err)
+	}
+	if rects := h.update(t).Rectangles; len(rects) != 1 || rects[0].Width != 16 || rects[0].Height != 8 {
+		t.Fatalf("full update has rectangles %+v, want the whole display", rects)
+	}
+
+	// Damage reported before the request is coalesced into one rectangle.
+	h.fill(image.Rect(0, 0, 4, 2), blue)
+	h.fill(image.Rect(0, 2, 4, 4), blue)
+	if err := h.client.FramebufferUpdateRequest(true, 0, 0, 16, 8); err != nil {
+		t.Fatal(err)
+	}
+	rects := h.update(t).Rectangles
+	if len(rects) != 1 || rects[0].X != 0 || rects[0].Y != 0 || rects[0].Width != 4 || rects[0].Height != 4 {
+		t.Fatalf("incremental update has rectangles %+v, want the damaged 4x4 area", rects)
+	}
+
+	// An incremental request is held until something changes.
+	if err := h.client.FramebufferUpdateRequest(true, 0, 0, 16, 8); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-h.msgs:
+		t.Fatalf("received %T without damage", msg)
+	case <-time.After(50 * time.Millisecond):
+	}
+	h.fill(image.Rect(10, 6, 12, 8), red)
+	rects = h.update(t).Rectangles
+	if len(rects) != 1 || rects[0].X != 10 || rects[0].Y != 6 {
+		t.Errorf("update has rectangles %+v, want the area changed at (10,6)", rects)
+	}
+}
+
+func TestDisplay_MinUpdateInterval(t *testing.T) {
+	display := NewDisplay(16, 8)
+	display.MinUpdateInterval = 200 * time.Millisecond
+	h := newDisplayHarness(t, display)
+
+	if err := h.client.FramebufferUpdateRequest(false, 0, 0, 16, 8); err != nil {
+		t.Fatal(err)
+	}
+	h.update(t)
+
+	start := time.Now()
+	if err := h.client.FramebufferUpdateRequest(true, 0, 0, 16, 8); err != nil {
+		t.Fatal(err)
+	}
+	h.fill(image.Rect(0, 0, 1, 1), red)
+	h.update(t)
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Errorf("second update after %v, want it paced to MinUpdateInterval", elapsed)
+	}
+}
+
+func TestDisplay_ResizeWithoutDesktopSize(t *testing.T) {
+	display := NewDisplay(16, 8)
+	h := newDisplayHarness(t, display)
+
+	if err := h.client.FramebufferUpdateRequest(false, 0, 0, 16, 8); err != nil {
+		t.Fatal(err)
+	}
+	h.update(t)
+
+	// A viewer that cannot resize keeps its size and receives the part of
+	// the resized display within it.
+	display.Resize(32, 4)
+	if err := h.client.FramebufferUpdateRequest(true, 0, 0, 16, 8); err != nil {
+		t.Fatal(err)
+	}
+	rects := h.update(t).Rectangles
+	if len(rects) != 1 || rects[0].Width != 16 || rects[0].Height != 4 {
+		t.Errorf("update has rectangles %+v, want the 16x4 area within both sizes", rects)
+	}
+	if w, h := h.client.GetFrameBufferSize(); w != 16 || h != 8 {
+		t.Errorf("GetFrameBufferSize() = %dx%d, want the original 16x8", w, h)
+	}
+}
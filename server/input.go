@@ -0,0 +1,92 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package server
+
+import (
+	"github.com/tenthirtyam/go-vnc/rfb"
+)
+
+// InputSink receives the input of the interactive viewers of a Display. Its
+// methods are called one at a time for each viewer, from the goroutine
+// reading the viewer's messages, but concurrently for different viewers.
+// Errors are logged and do not disconnect the viewer.
+type InputSink interface {
+	// Key presses or releases the key with the given X11 keysym.
+	Key(conn *Conn, ev rfb.KeyEvent) error
+
+	// Pointer moves the pointer to (ev.X, ev.Y) in display coordinates
+	// with the buttons of ev.Mask held; bit 0 is the left button, bits 3
+	// and 4 scroll up and down, and bits 5 and 6 scroll left and right.
+	Pointer(conn *Conn, ev rfb.PointerEvent) error
+
+	// CutText receives the viewer's clipboard, decoded from Latin-1.
+	CutText(conn *Conn, text string) error
+}
+
+// InputFuncs is an InputSink that calls its functions. Events without a
+// function are discarded, so applications set only the ones they handle.
+type InputFuncs struct {
+	KeyFunc     func(conn *Conn, ev rfb.KeyEvent) error
+	PointerFunc func(conn *Conn, ev rfb.PointerEvent) error
+	CutTextFunc func(conn *Conn, text string) error
+}
+
+// Key calls KeyFunc if it is set.
+func (f InputFuncs) Key(conn *Conn, ev rfb.KeyEvent) error {
+	if f.KeyFunc == nil {
+		return nil
+	}
+	return f.KeyFunc(conn, ev)
+}
+
+// Pointer calls PointerFunc if it is set.
+func (f InputFuncs) Pointer(conn *Conn, ev rfb.PointerEvent) error {
+	if f.PointerFunc == nil {
+		return nil
+	}
+	return f.PointerFunc(conn, ev)
+}
+
+// CutText calls CutTextFunc if it is set.
+func (f InputFuncs) CutText(conn *Conn, text string) error {
+	if f.CutTextFunc == nil {
+		return nil
+	}
+	return f.CutTextFunc(conn, text)
+}
+
+// decodeLatin1 converts Latin-1 text to a string.
+func decodeLatin1(text []byte) string {
+	runes := make([]rune, len(text))
+	for i, b := range text {
+		runes[i] = rune(b)
+	}
+	return string(runes)
+}
+
+// Buttons of the PointerEvent mask that OS input adapters translate.
+const (
+	buttonLeft   = 1 << 0
+	buttonMiddle = 1 << 1
+	buttonRight  = 1 << 2
+	wheelUp      = 1 << 3
+	wheelDown    = 1 << 4
+	wheelLeft    = 1 << 5
+	wheelRight   = 1 << 6
+)
+
+// pointerState tracks the buttons a viewer holds, which OS input adapters
+// need to turn the button masks of PointerEvent into press and release
+// events.
+type pointerState struct {
+	mask uint8
+}
+
+// update records mask and returns the buttons pressed and released since the
+// previous event.
+func (p *pointerState) update(mask uint8) (pressed, released uint8) {
+	pressed, released = mask&^p.mask, p.mask&^mask
+	p.mask = mask
+	return pressed, released
+}
@@ -0,0 +1,280 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+//go:build linux && (386 || amd64 || arm || arm64 || loong64 || riscv64)
+
+package server
+
+import (
+	"encoding/binary"
+	"fmt"
+	"image"
+	"strconv"
+	"sync"
+	"syscall"
+	"unsafe"
+
+	"github.com/tenthirtyam/go-vnc/rfb"
+)
+
+// uinput ioctl requests, from linux/uinput.h, for architectures using the
+// generic ioctl encoding.
+const (
+	uiDevCreate  = 0x5501
+	uiDevDestroy = 0x5502
+	uiDevSetup   = 0x405c5503
+	uiAbsSetup   = 0x401c5504
+	uiSetEvBit   = 0x40045564
+	uiSetKeyBit  = 0x40045565
+	uiSetRelBit  = 0x40045566
+	uiSetAbsBit  = 0x40045567
+)
+
+// evdev event types and codes, from linux/input-event-codes.h.
+const (
+	evSyn = 0x00
+	evKey = 0x01
+	evRel = 0x02
+	evAbs = 0x03
+
+	synReport = 0
+	relHWheel = 0x06
+	relWheel  = 0x08
+	absX      = 0x00
+	absY      = 0x01
+
+	btnLeft   = 0x110
+	btnRight  = 0x111
+	btnMiddle = 0x112
+
+	busVirtual = 0x06
+)
+
+// UinputSink is an InputSink that injects key and pointer input into the
+// Linux input subsystem through a virtual keyboard and absolute pointer
+// created with uinput, so viewers control the local desktop or any program
+// reading evdev devices. Keysyms are mapped to the keys of a US keyboard;
+// the clipboard is not supported and cut text is discarded. Creating a
+// UinputSink requires write access to /dev/uinput.
+type UinputSink struct {
+	mu      sync.Mutex
+	fd      int
+	buttons pointerState
+}
+
+// NewUinputSink creates a virtual input device named name whose pointer
+// spans bounds, normally the bounds of the Display it serves.
+func NewUinputSink(name string, bounds image.Rectangle) (*UinputSink, error) {
+	fd, err := syscall.Open("/dev/uinput", syscall.O_WRONLY|syscall.O_NONBLOCK|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, fmt.Errorf("server: open uinput: %w", err)
+	}
+	u := &UinputSink{fd: fd}
+	if err := u.setup(name, bounds); err != nil {
+		_ = syscall.Close(fd)
+		return nil, fmt.Errorf("server: create uinput device: %w", err)
+	}
+	return u, nil
+}
+
+// setup declares the events of the device and creates it.
+func (u *UinputSink) setup(name string, bounds image.Rectangle) error {
+	bits := []struct {
+		request uintptr
+		codes   []uint16
+	}{
+		{uiSetEvBit, []uint16{evKey, evRel, evAbs}},
+		{uiSetRelBit, []uint16{relWheel, relHWheel}},
+		{uiSetAbsBit, []uint16{absX, absY}},
+		{uiSetKeyBit, []uint16{btnLeft, btnRight, btnMiddle}},
+	}
+	for _, b := range bits {
+		for _, code := range b.codes {
+			if err := u.ioctl(b.request, uintptr(code)); err != nil {
+				return err
+			}
+		}
+	}
+	for _, code := range evdevKeys {
+		if err := u.ioctl(uiSetKeyBit, uintptr(code)); err != nil {
+			return err
+		}
+	}
+
+	for code, maximum := range map[uint16]int{absX: bounds.Dx() - 1, absY: bounds.Dy() - 1} {
+		// struct uinput_abs_setup: code, padding, and struct input_absinfo
+		// (value, minimum, maximum, fuzz, flat, resolution).
+		var abs [28]byte
+		binary.NativeEndian.PutUint16(abs[0:], code)
+		binary.NativeEndian.PutUint32(abs[12:], uint32(maximum)) // #nosec G115 - displays are at most 65535 pixels wide
+		if err := u.ioctlBuf(uiAbsSetup, abs[:]); err != nil {
+			return err
+		}
+	}
+
+	// struct uinput_setup: struct input_id (bustype, vendor, product,
+	// version), name, and ff_effects_max.
+	var setup [92]byte
+	binary.NativeEndian.PutUint16(setup[0:], busVirtual)
+	copy(setup[8:87], name)
+	if err := u.ioctlBuf(uiDevSetup, setup[:]); err != nil {
+		return err
+	}
+	return u.ioctl(uiDevCreate, 0)
+}
+
+// ioctl performs an ioctl with an integer argument.
+func (u *UinputSink) ioctl(request, arg uintptr) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(u.fd), request, arg); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// ioctlBuf performs an ioctl whose argument points to buf.
+func (u *UinputSink) ioctlBuf(request uintptr, buf []byte) error {
+	return u.ioctl(request, uintptr(unsafe.Pointer(&buf[0]))) // #nosec G103 - the kernel reads the struct during the call
+}
+
+// Key presses or releases the key mapped to the keysym of ev. Keysyms
+// without a key on a US keyboard are ignored.
+func (u *UinputSink) Key(_ *Conn, ev rfb.KeyEvent) error {
+	code, ok := evdevKeys[ev.Key]
+	if !ok {
+		return nil
+	}
+	var value int32
+	if ev.Down {
+		value = 1
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.emit(inputEvent{evKey, code, value})
+}
+
+// Pointer moves the pointer and presses, releases, or scrolls with the
+// buttons that changed.
+func (u *UinputSink) Pointer(_ *Conn, ev rfb.PointerEvent) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	events := []inputEvent{{evAbs, absX, int32(ev.X)}, {evAbs, absY, int32(ev.Y)}}
+	pressed, released := u.buttons.update(ev.Mask)
+	for bit, code := range map[uint8]uint16{buttonLeft: btnLeft, buttonMiddle: btnMiddle, buttonRight: btnRight} {
+		switch {
+		case pressed&bit != 0:
+			events = append(events, inputEvent{evKey, code, 1})
+		case released&bit != 0:
+			events = append(events, inputEvent{evKey, code, 0})
+		}
+	}
+	// Viewers press and release a wheel button for each step.
+	for bit, wheel := range map[uint8]inputEvent{
+		wheelUp:    {evRel, relWheel, 1},
+		wheelDown:  {evRel, relWheel, -1},
+		wheelLeft:  {evRel, relHWheel, -1},
+		wheelRight: {evRel, relHWheel, 1},
+	} {
+		if pressed&bit != 0 {
+			events = append(events, wheel)
+		}
+	}
+	return u.emit(events...)
+}
+
+// CutText discards the viewer's clipboard.
+func (*UinputSink) CutText(*Conn, string) error {
+	return nil
+}
+
+// Close destroys the virtual device.
+func (u *UinputSink) Close() error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	_ = u.ioctl(uiDevDestroy, 0)
+	return syscall.Close(u.fd)
+}
+
+// inputEvent is an evdev event.
+type inputEvent struct {
+	typ, code uint16
+	value     int32
+}
+
+// emit writes events followed by a SYN_REPORT as struct input_event, whose
+// timestamp the kernel fills in.
+func (u *UinputSink) emit(events ...inputEvent) error {
+	timeSize := 2 * strconv.IntSize / 8
+	buf := make([]byte, 0, (len(events)+1)*(timeSize+8))
+	for _, ev := range append(events, inputEvent{evSyn, synReport, 0}) {
+		buf = append(buf, make([]byte, timeSize)...)
+		buf = binary.NativeEndian.AppendUint16(buf, ev.typ)
+		buf = binary.NativeEndian.AppendUint16(buf, ev.code)
+		buf = binary.NativeEndian.AppendUint32(buf, uint32(ev.value)) // #nosec G115 - two's complement as in struct input_event
+	}
+	_, err := syscall.Write(u.fd, buf)
+	return err
+}
+
+// evdevKeys maps X11 keysyms to the evdev key codes of a US keyboard.
+// Shifted characters map to the key that produces them, as the viewer
+// sends Shift separately.
+var evdevKeys = func() map[uint32]uint16 {
+	keys := map[uint32]uint16{
+		0xff1b: 1,   // Escape
+		0xff08: 14,  // BackSpace
+		0xff09: 15,  // Tab
+		0xff0d: 28,  // Return
+		0xffe3: 29,  // Control_L
+		0xffe1: 42,  // Shift_L
+		0xffe2: 54,  // Shift_R
+		0xffe9: 56,  // Alt_L
+		0x0020: 57,  // space
+		0xffe5: 58,  // Caps_Lock
+		0xff7f: 69,  // Num_Lock
+		0xff14: 70,  // Scroll_Lock
+		0xffc8: 87,  // F11
+		0xffc9: 88,  // F12
+		0xffe4: 97,  // Control_R
+		0xff61: 99,  // Print
+		0xffea: 100, // Alt_R
+		0xfe03: 100, // ISO_Level3_Shift
+		0xff50: 102, // Home
+		0xff52: 103, // Up
+		0xff55: 104, // Page_Up
+		0xff51: 105, // Left
+		0xff53: 106, // Right
+		0xff57: 107, // End
+		0xff54: 108, // Down
+		0xff56: 109, // Page_Down
+		0xff63: 110, // Insert
+		0xffff: 111, // Delete
+		0xff13: 119, // Pause
+		0xffe7: 125, // Meta_L
+		0xffeb: 125, // Super_L
+		0xffe8: 126, // Meta_R
+		0xffec: 126, // Super_R
+		0xff67: 127, // Menu
+	}
+	for i := range uint32(10) {
+		keys[0xffbe+i] = uint16(59 + i) // #nosec G115 - F1 to F10
+	}
+	rows := []struct {
+		code           uint16
+		plain, shifted string
+	}{
+		{2, "1234567890-=", "!@#$%^&*()_+"},
+		{16, "qwertyuiop[]", "QWERTYUIOP{}"},
+		{30, "asdfghjkl;'`", "ASDFGHJKL:\"~"},
+		{43, "\\zxcvbnm,./", "|ZXCVBNM<>?"},
+	}
+	for _, row := range rows {
+		for i := range len(row.plain) {
+			code := row.code + uint16(i) // #nosec G115 - at most 12 keys per row
+			keys[uint32(row.plain[i])] = code
+			keys[uint32(row.shifted[i])] = code
+		}
+	}
+	return keys
+}()
@@ -0,0 +1,88 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/tenthirtyam/go-vnc"
+	"github.com/tenthirtyam/go-vnc/rfb"
+)
+
+func TestDisplay_Input(t *testing.T) {
+	events := make(chan any, 8)
+	display := NewDisplay(16, 8)
+	display.Input = InputFuncs{
+		KeyFunc: func(_ *Conn, ev rfb.KeyEvent) error {
+			events <- ev
+			return nil
+		},
+		PointerFunc: func(_ *Conn, ev rfb.PointerEvent) error {
+			events <- ev
+			return nil
+		},
+		CutTextFunc: func(_ *Conn, text string) error {
+			events <- text
+			return nil
+		},
+	}
+	h := newDisplayHarness(t, display)
+
+	if err := h.client.KeyEvent(0x61, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.client.PointerEvent(vnc.ButtonLeft, 5, 6); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.client.CutText("café"); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []any{
+		rfb.KeyEvent{Down: true, Key: 0x61},
+		rfb.PointerEvent{Mask: 1, X: 5, Y: 6},
+		"café",
+	}
+	for i, w := range want {
+		select {
+		case got := <-events:
+			if got != w {
+				t.Errorf("event %d = %#v, want %#v", i, got, w)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for event %d", i)
+		}
+	}
+}
+
+func TestDisplay_InputViewOnly(t *testing.T) {
+	display := &Display{Input: InputFuncs{}}
+	if sink := display.input(&Conn{access: AccessViewOnly}); sink != nil {
+		t.Errorf("input() = %v for a view-only viewer, want nil", sink)
+	}
+	if sink := display.input(&Conn{access: AccessInteractive}); sink == nil {
+		t.Error("input() = nil for an interactive viewer, want the sink")
+	}
+}
+
+func TestPointerState_Update(t *testing.T) {
+	var p pointerState
+	steps := []struct {
+		mask              uint8
+		pressed, released uint8
+	}{
+		{mask: buttonLeft, pressed: buttonLeft},
+		{mask: buttonLeft | buttonRight, pressed: buttonRight},
+		{mask: buttonRight, released: buttonLeft},
+		{mask: 0, released: buttonRight},
+	}
+	for i, step := range steps {
+		pressed, released := p.update(step.mask)
+		if pressed != step.pressed || released != step.released {
+			t.Errorf("step %d: update(%#b) = %#b, %#b, want %#b, %#b",
+				i, step.mask, pressed, released, step.pressed, step.released)
+		}
+	}
+}
@@ -0,0 +1,239 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+//go:build windows
+
+package server
+
+import (
+	"fmt"
+	"image"
+	"sync"
+	"syscall"
+	"unsafe"
+
+	"github.com/tenthirtyam/go-vnc/rfb"
+)
+
+// procSendInput is the SendInput function of user32.dll.
+var procSendInput = syscall.NewLazyDLL("user32.dll").NewProc("SendInput")
+
+// SendInput constants, from winuser.h.
+const (
+	inputMouse    = 0
+	inputKeyboard = 1
+
+	keyEventExtendedKey = 0x0001
+	keyEventKeyUp       = 0x0002
+	keyEventUnicode     = 0x0004
+
+	mouseEventMove       = 0x0001
+	mouseEventLeftDown   = 0x0002
+	mouseEventLeftUp     = 0x0004
+	mouseEventRightDown  = 0x0008
+	mouseEventRightUp    = 0x0010
+	mouseEventMiddleDown = 0x0020
+	mouseEventMiddleUp   = 0x0040
+	mouseEventWheel      = 0x0800
+	mouseEventHWheel     = 0x1000
+	mouseEventAbsolute   = 0x8000
+
+	wheelDelta = 120
+)
+
+// mouseInput is an INPUT structure holding a MOUSEINPUT.
+type mouseInput struct {
+	typ       uint32
+	dx, dy    int32
+	mouseData uint32
+	flags     uint32
+	time      uint32
+	extraInfo uintptr
+}
+
+// keyboardInput is an INPUT structure holding a KEYBDINPUT, padded to the
+// size of the union.
+type keyboardInput struct {
+	typ       uint32
+	vk, scan  uint16
+	flags     uint32
+	time      uint32
+	extraInfo uintptr
+	_         [8]byte
+}
+
+// SendInputSink is an InputSink that injects key and pointer input into the
+// interactive Windows desktop with SendInput, so viewers control the local
+// desktop. Keysyms of letters, digits, and special keys are sent as virtual
+// keys, so shortcuts work; other characters are typed as Unicode. The
+// clipboard is not supported and cut text is discarded.
+type SendInputSink struct {
+	mu      sync.Mutex
+	bounds  image.Rectangle
+	buttons pointerState
+}
+
+// NewSendInputSink returns a SendInputSink whose pointer coordinates, in
+// bounds, are scaled to the primary monitor. bounds is normally the bounds
+// of the Display it serves.
+func NewSendInputSink(bounds image.Rectangle) *SendInputSink {
+	return &SendInputSink{bounds: bounds}
+}
+
+// Key presses or releases the key of ev.
+func (s *SendInputSink) Key(_ *Conn, ev rfb.KeyEvent) error {
+	in := keyboardInput{typ: inputKeyboard}
+	if vk, ok := virtualKeys[ev.Key]; ok {
+		in.vk = vk.code
+		if vk.extended {
+			in.flags |= keyEventExtendedKey
+		}
+	} else if char, ok := keysymRune(ev.Key); ok {
+		in.scan = char
+		in.flags |= keyEventUnicode
+	} else {
+		return nil
+	}
+	if !ev.Down {
+		in.flags |= keyEventKeyUp
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return sendInput(unsafe.Pointer(&in), 1, unsafe.Sizeof(in)) // #nosec G103 - SendInput reads the structure during the call
+}
+
+// Pointer moves the pointer and presses, releases, or scrolls with the
+// buttons that changed.
+func (s *SendInputSink) Pointer(_ *Conn, ev rfb.PointerEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	inputs := []mouseInput{{
+		typ:   inputMouse,
+		dx:    normalize(int(ev.X)-s.bounds.Min.X, s.bounds.Dx()),
+		dy:    normalize(int(ev.Y)-s.bounds.Min.Y, s.bounds.Dy()),
+		flags: mouseEventMove | mouseEventAbsolute,
+	}}
+	pressed, released := s.buttons.update(ev.Mask)
+	for _, b := range []struct {
+		bit      uint8
+		down, up uint32
+	}{
+		{buttonLeft, mouseEventLeftDown, mouseEventLeftUp},
+		{buttonMiddle, mouseEventMiddleDown, mouseEventMiddleUp},
+		{buttonRight, mouseEventRightDown, mouseEventRightUp},
+	} {
+		switch {
+		case pressed&b.bit != 0:
+			inputs = append(inputs, mouseInput{typ: inputMouse, flags: b.down})
+		case released&b.bit != 0:
+			inputs = append(inputs, mouseInput{typ: inputMouse, flags: b.up})
+		}
+	}
+	// Viewers press and release a wheel button for each step.
+	for _, w := range []struct {
+		bit   uint8
+		flags uint32
+		delta int32
+	}{
+		{wheelUp, mouseEventWheel, wheelDelta},
+		{wheelDown, mouseEventWheel, -wheelDelta},
+		{wheelLeft, mouseEventHWheel, -wheelDelta},
+		{wheelRight, mouseEventHWheel, wheelDelta},
+	} {
+		if pressed&w.bit != 0 {
+			inputs = append(inputs, mouseInput{typ: inputMouse, flags: w.flags, mouseData: uint32(w.delta)}) // #nosec G115 - two's complement as in MOUSEINPUT
+		}
+	}
+	return sendInput(unsafe.Pointer(&inputs[0]), len(inputs), unsafe.Sizeof(inputs[0])) // #nosec G103 - SendInput reads the structures during the call
+}
+
+// CutText discards the viewer's clipboard.
+func (*SendInputSink) CutText(*Conn, string) error {
+	return nil
+}
+
+// sendInput calls SendInput with n structures of the given size at inputs.
+func sendInput(inputs unsafe.Pointer, n int, size uintptr) error {
+	sent, _, err := procSendInput.Call(uintptr(n), uintptr(inputs), size)
+	if int(sent) != n {
+		return fmt.Errorf("server: SendInput: %w", err)
+	}
+	return nil
+}
+
+// normalize scales v in 0..size-1 to the 0..65535 range of absolute
+// SendInput coordinates.
+func normalize(v, size int) int32 {
+	if size <= 1 {
+		return 0
+	}
+	return int32(v * 65535 / (size - 1)) // #nosec G115 - v is within the display
+}
+
+// keysymRune returns the UTF-16 code unit of the character keysym k types,
+// if it is in the Basic Multilingual Plane.
+func keysymRune(k uint32) (uint16, bool) {
+	switch {
+	case k >= 0x20 && k <= 0x7e, k >= 0xa0 && k <= 0xff:
+		return uint16(k), true
+	case k >= 0x01000100 && k <= 0x0100ffff:
+		return uint16(k - 0x01000000), true // #nosec G115 - within the Basic Multilingual Plane
+	default:
+		return 0, false
+	}
+}
+
+// virtualKey is a Windows virtual key code.
+type virtualKey struct {
+	code     uint16
+	extended bool
+}
+
+// virtualKeys maps X11 keysyms to Windows virtual keys.
+var virtualKeys = func() map[uint32]virtualKey {
+	keys := map[uint32]virtualKey{
+		0xff08: {code: 0x08},                 // BackSpace
+		0xff09: {code: 0x09},                 // Tab
+		0xff0d: {code: 0x0d},                 // Return
+		0xff13: {code: 0x13},                 // Pause
+		0xff14: {code: 0x91},                 // Scroll_Lock
+		0xff1b: {code: 0x1b},                 // Escape
+		0x0020: {code: 0x20},                 // space
+		0xff55: {code: 0x21, extended: true}, // Page_Up
+		0xff56: {code: 0x22, extended: true}, // Page_Down
+		0xff57: {code: 0x23, extended: true}, // End
+		0xff50: {code: 0x24, extended: true}, // Home
+		0xff51: {code: 0x25, extended: true}, // Left
+		0xff52: {code: 0x26, extended: true}, // Up
+		0xff53: {code: 0x27, extended: true}, // Right
+		0xff54: {code: 0x28, extended: true}, // Down
+		0xff61: {code: 0x2c, extended: true}, // Print
+		0xff63: {code: 0x2d, extended: true}, // Insert
+		0xffff: {code: 0x2e, extended: true}, // Delete
+		0xffeb: {code: 0x5b, extended: true}, // Super_L
+		0xffec: {code: 0x5c, extended: true}, // Super_R
+		0xff67: {code: 0x5d, extended: true}, // Menu
+		0xff7f: {code: 0x90},                 // Num_Lock
+		0xffe5: {code: 0x14},                 // Caps_Lock
+		0xffe1: {code: 0xa0},                 // Shift_L
+		0xffe2: {code: 0xa1},                 // Shift_R
+		0xffe3: {code: 0xa2},                 // Control_L
+		0xffe4: {code: 0xa3, extended: true}, // Control_R
+		0xffe9: {code: 0xa4},                 // Alt_L
+		0xffea: {code: 0xa5, extended: true}, // Alt_R
+		0xfe03: {code: 0xa5, extended: true}, // ISO_Level3_Shift
+	}
+	for i := range uint32(24) {
+		keys[0xffbe+i] = virtualKey{code: uint16(0x70 + i)} // #nosec G115 - F1 to F24
+	}
+	for c := uint32('0'); c <= '9'; c++ {
+		keys[c] = virtualKey{code: uint16(c)} // #nosec G115 - ASCII digit
+	}
+	for c := uint32('A'); c <= 'Z'; c++ {
+		keys[c] = virtualKey{code: uint16(c)}         // #nosec G115 - ASCII letter
+		keys[c+'a'-'A'] = virtualKey{code: uint16(c)} // #nosec G115 - ASCII letter
+	}
+	return keys
+}()
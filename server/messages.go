@@ -0,0 +1,75 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/tenthirtyam/go-vnc"
+)
+
+// Message is a message a server sends to a viewer. The message types of the
+// vnc package that clients parse, such as vnc.BellMessage,
+// vnc.ServerCutTextMessage, and vnc.SetColorMapEntriesMessage, implement it,
+// so both halves of a connection share one encoding of each message.
+type Message interface {
+	vnc.ServerMessage
+
+	// Write encodes the message, including its type byte, to w.
+	Write(w io.Writer) error
+}
+
+// Send writes msg to the viewer. It is safe to call from several goroutines;
+// each message is written whole.
+func (c *Conn) Send(msg Message) error {
+	var buf bytes.Buffer
+	if err := msg.Write(&buf); err != nil {
+		return err
+	}
+
+	return c.write(buf.Bytes())
+}
+
+// write writes an encoded message to the viewer.
+func (c *Conn) write(msg []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	_, err := c.conn.Write(msg)
+	return err
+}
+
+// Bell rings the viewer's bell.
+func (c *Conn) Bell() error {
+	return c.Send(new(vnc.BellMessage))
+}
+
+// CutText sets the viewer's clipboard to text. Like vnc.ClientConn.CutText it
+// converts text to Latin-1, the only character set of the message, and fails
+// if text contains other characters.
+func (c *Conn) CutText(text string) error {
+	latin1 := make([]byte, 0, len(text))
+	for _, char := range text {
+		if char > vnc.Latin1MaxCodePoint {
+			return fmt.Errorf("server: character %q is not valid Latin-1", char)
+		}
+		latin1 = append(latin1, byte(char)) // #nosec G115 - char is at most Latin1MaxCodePoint
+	}
+	return c.Send(&vnc.ServerCutTextMessage{Text: string(latin1)})
+}
+
+// Fence sends a Fence message with the given flags and payload of at most 64
+// bytes. Only viewers that announced the Fence pseudo-encoding accept it;
+// Display does so for them and answers their fence requests.
+func (c *Conn) Fence(flags uint32, payload []byte) error {
+	return c.Send(&vnc.FenceMessage{Flags: flags, Payload: payload})
+}
+
+// SetColorMapEntries sets the colors of the viewer's color map from index
+// firstColor onwards. Viewers only use the color map with a pixel format that
+// is not true color.
+func (c *Conn) SetColorMapEntries(firstColor uint16, colors []vnc.Color) error {
+	return c.Send(&vnc.SetColorMapEntriesMessage{FirstColor: firstColor, Colors: colors})
+}
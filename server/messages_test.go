@@ -0,0 +1,102 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package server
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/tenthirtyam/go-vnc"
+	"github.com/tenthirtyam/go-vnc/rfb"
+)
+
+func TestConn_Send(t *testing.T) {
+	viewerConn, results := startAccept(t, &Config{})
+
+	msgs := make(chan vnc.ServerMessage, 8)
+	client, err := vnc.ClientWithOptions(context.Background(), viewerConn,
+		vnc.WithServerMessageChannel(msgs),
+		vnc.WithConnectTimeout(5*time.Second))
+	if err != nil {
+		t.Fatalf("client handshake: %v", err)
+	}
+	defer client.Close()
+
+	res := <-results
+	if res.err != nil {
+		t.Fatalf("Accept: %v", res.err)
+	}
+	conn := res.conn
+	drain(conn.NetConn())
+
+	colors := []vnc.Color{{R: 0xffff}, {G: 0x8000, B: 0x1234}}
+	if err := conn.Bell(); err != nil {
+		t.Fatalf("Bell: %v", err)
+	}
+	if err := conn.CutText("copied"); err != nil {
+		t.Fatalf("CutText: %v", err)
+	}
+	if err := conn.SetColorMapEntries(3, colors); err != nil {
+		t.Fatalf("SetColorMapEntries: %v", err)
+	}
+
+	receive := func() vnc.ServerMessage {
+		t.Helper()
+		select {
+		case msg := <-msgs:
+			return msg
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for a server message")
+			return nil
+		}
+	}
+
+	if msg, ok := receive().(*vnc.BellMessage); !ok {
+		t.Errorf("first message = %T, want *vnc.BellMessage", msg)
+	}
+	if msg, ok := receive().(*vnc.ServerCutTextMessage); !ok || msg.Text != "copied" {
+		t.Errorf("second message = %+v, want the copied text", msg)
+	}
+	msg, ok := receive().(*vnc.SetColorMapEntriesMessage)
+	if !ok || msg.FirstColor != 3 || len(msg.Colors) != 2 || msg.Colors[1] != colors[1] {
+		t.Errorf("third message = %+v, want colors %v at index 3", msg, colors)
+	}
+	if got := client.GetColorMap()[4]; got != colors[1] {
+		t.Errorf("client color map entry 4 = %v, want %v", got, colors[1])
+	}
+}
+
+func TestConn_CutTextLatin1(t *testing.T) {
+	serverConn, viewerConn := net.Pipe()
+	defer viewerConn.Close()
+	conn := &Conn{conn: serverConn}
+	go func() {
+		_ = conn.CutText("café")
+		_ = serverConn.Close()
+	}()
+
+	if msgType, err := rfb.ReadMessageType(viewerConn); err != nil || msgType != rfb.ServerCutTextMsg {
+		t.Fatalf("read message type %d, %v; want ServerCutText", msgType, err)
+	}
+	text, err := rfb.ReadCutText(viewerConn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(text, []byte("caf\xe9")) {
+		t.Errorf("cut text = %q, want Latin-1 caf\\xe9", text)
+	}
+}
+
+func TestConn_SendInvalid(t *testing.T) {
+	conn := &Conn{}
+	if err := conn.CutText("世界"); err == nil {
+		t.Error("CutText accepted text that is not Latin-1")
+	}
+	if err := conn.SetColorMapEntries(255, make([]vnc.Color, 2)); !vnc.IsVNCError(err, vnc.ErrValidation) {
+		t.Errorf("SetColorMapEntries past the end of the color map: error = %v, want a validation error", err)
+	}
+}
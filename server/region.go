@@ -0,0 +1,90 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package server
+
+import (
+	"image"
+	"slices"
+)
+
+// maxRegionRects bounds the number of rectangles in a region. Beyond it the
+// region collapses to its bounding box, which keeps updates to a size every
+// viewer handles.
+const maxRegionRects = 64
+
+// region is a set of damaged rectangles. Rectangles may overlap; adding a
+// rectangle merges it with those it can be combined with at no extra cost.
+type region []image.Rectangle
+
+// add adds r to the region.
+func (rg *region) add(r image.Rectangle) {
+	if r.Empty() {
+		return
+	}
+	for i := 0; i < len(*rg); {
+		if o := (*rg)[i]; mergeable(o, r) {
+			r = r.Union(o)
+			*rg = slices.Delete(*rg, i, i+1)
+			i = 0
+			continue
+		}
+		i++
+	}
+	*rg = append(*rg, r)
+
+	if len(*rg) > maxRegionRects {
+		*rg = region{rg.bounds()}
+	}
+}
+
+// mergeable reports whether the bounding box of a and b covers no more pixels
+// than a and b do separately, which holds when one contains the other or
+// when they overlap or touch along a full edge.
+func mergeable(a, b image.Rectangle) bool {
+	return area(a.Union(b)) <= area(a)+area(b)
+}
+
+// area returns the number of pixels of r.
+func area(r image.Rectangle) int {
+	return r.Dx() * r.Dy()
+}
+
+// bounds returns the bounding box of the region.
+func (rg region) bounds() image.Rectangle {
+	var b image.Rectangle
+	for _, r := range rg {
+		b = b.Union(r)
+	}
+	return b
+}
+
+// take removes the parts of the region within clip and returns them.
+func (rg *region) take(clip image.Rectangle) []image.Rectangle {
+	var taken []image.Rectangle
+	var rest region
+	for _, r := range *rg {
+		if x := r.Intersect(clip); !x.Empty() {
+			taken = append(taken, x)
+		}
+		rest = append(rest, subtract(r, clip)...)
+	}
+	*rg = rest
+	return taken
+}
+
+// subtract returns the parts of r outside clip as at most four rectangles.
+func subtract(r, clip image.Rectangle) []image.Rectangle {
+	x := r.Intersect(clip)
+	if x.Empty() {
+		return []image.Rectangle{r}
+	}
+
+	pieces := []image.Rectangle{
+		image.Rect(r.Min.X, r.Min.Y, r.Max.X, x.Min.Y), // above
+		image.Rect(r.Min.X, x.Max.Y, r.Max.X, r.Max.Y), // below
+		image.Rect(r.Min.X, x.Min.Y, x.Min.X, x.Max.Y), // left
+		image.Rect(x.Max.X, x.Min.Y, r.Max.X, x.Max.Y), // right
+	}
+	return slices.DeleteFunc(pieces, image.Rectangle.Empty)
+}
@@ -0,0 +1,82 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package server
+
+import (
+	"image"
+	"slices"
+	"testing"
+)
+
+func TestRegion_Add(t *testing.T) {
+	tests := []struct {
+		name string
+		add  []image.Rectangle
+		want region
+	}{
+		{
+			name: "Contained",
+			add:  []image.Rectangle{image.Rect(0, 0, 10, 10), image.Rect(2, 2, 4, 4)},
+			want: region{image.Rect(0, 0, 10, 10)},
+		},
+		{
+			name: "AdjacentRows",
+			add:  []image.Rectangle{image.Rect(0, 0, 10, 1), image.Rect(0, 1, 10, 2), image.Rect(0, 2, 10, 3)},
+			want: region{image.Rect(0, 0, 10, 3)},
+		},
+		{
+			name: "Disjoint",
+			add:  []image.Rectangle{image.Rect(0, 0, 2, 2), image.Rect(8, 8, 10, 10)},
+			want: region{image.Rect(0, 0, 2, 2), image.Rect(8, 8, 10, 10)},
+		},
+		{
+			name: "Empty",
+			add:  []image.Rectangle{{}},
+			want: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var rg region
+			for _, r := range tt.add {
+				rg.add(r)
+			}
+			if !slices.Equal(rg, tt.want) {
+				t.Errorf("region = %v, want %v", rg, tt.want)
+			}
+		})
+	}
+}
+
+func TestRegion_Overflow(t *testing.T) {
+	var rg region
+	for i := range maxRegionRects + 1 {
+		rg.add(image.Rect(2*i, 2*i, 2*i+1, 2*i+1))
+	}
+	want := image.Rect(0, 0, 2*maxRegionRects+1, 2*maxRegionRects+1)
+	if len(rg) != 1 || rg[0] != want {
+		t.Errorf("region = %v, want its bounding box %v", rg, want)
+	}
+}
+
+func TestRegion_Take(t *testing.T) {
+	rg := region{image.Rect(0, 0, 10, 10)}
+
+	taken := rg.take(image.Rect(2, 2, 5, 5))
+	if want := []image.Rectangle{image.Rect(2, 2, 5, 5)}; !slices.Equal(taken, want) {
+		t.Errorf("take() = %v, want %v", taken, want)
+	}
+
+	var pixels int
+	for _, r := range rg {
+		if r.Overlaps(image.Rect(2, 2, 5, 5)) {
+			t.Errorf("remaining rectangle %v overlaps the taken area", r)
+		}
+		pixels += area(r)
+	}
+	if pixels != 100-9 {
+		t.Errorf("remaining region %v covers %d pixels, want 91", rg, pixels)
+	}
+}
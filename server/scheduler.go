@@ -0,0 +1,225 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package server
+
+import (
+	"encoding/binary"
+	"image"
+	"slices"
+	"sync"
+
+	"github.com/tenthirtyam/go-vnc/rfb"
+)
+
+// scheduler decides when a viewer receives which parts of a Display. It
+// collects the damage reported since the viewer's last update and releases
+// it only while the viewer has a FramebufferUpdateRequest outstanding, which
+// paces updates to the rate the viewer consumes them, or while the viewer has
+// enabled continuous updates, in which case writes to the viewer pace them.
+type scheduler struct {
+	mu sync.Mutex
+
+	// sending is held while an update is taken and written, so messages
+	// that must follow the update in progress wait for it.
+	sending sync.Mutex
+
+	// dirty is the damage not yet sent to the viewer.
+	dirty region
+
+	// requested is the area of the outstanding update requests, or empty
+	// if the viewer has none.
+	requested image.Rectangle
+
+	// continuous is the area of continuous updates, or empty if the viewer
+	// has not enabled them.
+	continuous image.Rectangle
+
+	// pf is the pixel format the viewer asked for.
+	pf rfb.PixelFormat
+
+	// desktopSize reports whether the viewer supports the DesktopSize
+	// pseudo-encoding.
+	desktopSize bool
+
+	// size is the new size of the display to announce in the next update,
+	// or empty if the display was not resized.
+	size image.Rectangle
+
+	// wake is signaled when an update may have become due.
+	wake chan struct{}
+}
+
+// newScheduler returns a scheduler for a viewer that receives pixels in pf.
+func newScheduler(pf rfb.PixelFormat) *scheduler {
+	return &scheduler{pf: pf, wake: make(chan struct{}, 1)}
+}
+
+// signal wakes the update loop.
+func (s *scheduler) signal() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// damage marks r as changed.
+func (s *scheduler) damage(r image.Rectangle) {
+	s.mu.Lock()
+	s.dirty.add(r)
+	s.mu.Unlock()
+	s.signal()
+}
+
+// request records a FramebufferUpdateRequest for r. A non-incremental
+// request also marks r as changed, so it is answered with its full contents
+// even if nothing changed.
+func (s *scheduler) request(r image.Rectangle, incremental bool) {
+	s.mu.Lock()
+	if !incremental {
+		s.dirty.add(r)
+	}
+	s.requested = s.requested.Union(r)
+	s.mu.Unlock()
+	s.signal()
+}
+
+// setContinuous enables continuous updates of r, or disables them if r is
+// empty.
+func (s *scheduler) setContinuous(r image.Rectangle) {
+	s.mu.Lock()
+	s.continuous = r
+	s.mu.Unlock()
+	s.signal()
+}
+
+// setPixelFormat changes the pixel format of later updates.
+func (s *scheduler) setPixelFormat(pf rfb.PixelFormat) {
+	s.mu.Lock()
+	s.pf = pf
+	s.mu.Unlock()
+}
+
+// setEncodings records the encodings the viewer supports.
+func (s *scheduler) setEncodings(encodings []int32) {
+	s.mu.Lock()
+	s.desktopSize = slices.Contains(encodings, rfb.PseudoEncodingDesktopSize)
+	s.mu.Unlock()
+}
+
+// resize records that the display was resized to bounds. Viewers that
+// support the DesktopSize pseudo-encoding are sent the new size and the whole
+// display in the update answering their next request; others keep their size and receive the parts
+// of the display within it. Continuous updates continue within the new
+// bounds.
+func (s *scheduler) resize(bounds image.Rectangle) {
+	s.mu.Lock()
+	s.continuous = s.continuous.Intersect(bounds)
+	if s.desktopSize {
+		s.size = bounds
+		s.dirty = region{bounds}
+	} else {
+		s.requested = s.requested.Intersect(bounds)
+		s.dirty.add(bounds)
+	}
+	s.mu.Unlock()
+	s.signal()
+}
+
+// update is a FramebufferUpdate due to a viewer.
+type update struct {
+	// size is the new size of the display to announce, or empty.
+	size image.Rectangle
+
+	// rects are the damaged rectangles to send.
+	rects []image.Rectangle
+
+	// pf is the pixel format to send them in.
+	pf rfb.PixelFormat
+}
+
+// next returns the next update and consumes the outstanding request. It
+// returns false if no update is due: the viewer has neither asked for one
+// nor enabled continuous updates, or nothing it asked for has changed.
+func (s *scheduler) next() (update, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	area := s.requested.Union(s.continuous)
+	if area.Empty() {
+		return update{}, false
+	}
+	if !s.size.Empty() {
+		// After a resize the viewer's requests refer to the old size, so
+		// the whole display is sent at once.
+		u := update{size: s.size, rects: s.dirty.take(s.size), pf: s.pf}
+		s.size, s.requested = image.Rectangle{}, image.Rectangle{}
+		return u, true
+	}
+	rects := s.dirty.take(area)
+	if len(rects) == 0 {
+		return update{}, false
+	}
+	s.requested = image.Rectangle{}
+	return update{rects: rects, pf: s.pf}, true
+}
+
+// appendUpdate appends the FramebufferUpdate message u, with the rectangles
+// of fb in Raw encoding, to buf.
+func appendUpdate(buf []byte, fb *image.RGBA, u update) []byte {
+	numRects := len(u.rects)
+	if !u.size.Empty() {
+		numRects++
+	}
+	buf = append(buf, rfb.FramebufferUpdateMsg, 0)
+	buf = binary.BigEndian.AppendUint16(buf, uint16(numRects)) // #nosec G115 - bounded by maxRegionRects
+
+	if !u.size.Empty() {
+		buf = appendRectangle(buf, image.Rectangle{Max: u.size.Size()}, rfb.PseudoEncodingDesktopSize)
+	}
+	for _, r := range u.rects {
+		buf = appendRectangle(buf, r, rfb.EncodingRaw)
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			row := fb.Pix[fb.PixOffset(r.Min.X, y):fb.PixOffset(r.Max.X, y)]
+			for i := 0; i < len(row); i += 4 {
+				buf = appendPixel(buf, u.pf, row[i], row[i+1], row[i+2])
+			}
+		}
+	}
+	return buf
+}
+
+// appendRectangle appends the header of rectangle r with the given encoding.
+func appendRectangle(buf []byte, r image.Rectangle, encoding int32) []byte {
+	buf = binary.BigEndian.AppendUint16(buf, uint16(r.Min.X))   // #nosec G115 - within the display
+	buf = binary.BigEndian.AppendUint16(buf, uint16(r.Min.Y))   // #nosec G115 - within the display
+	buf = binary.BigEndian.AppendUint16(buf, uint16(r.Dx()))    // #nosec G115 - within the display
+	buf = binary.BigEndian.AppendUint16(buf, uint16(r.Dy()))    // #nosec G115 - within the display
+	return binary.BigEndian.AppendUint32(buf, uint32(encoding)) // #nosec G115 - two's complement as on the wire
+}
+
+// appendPixel appends an 8-bit RGB color as a pixel in true color format pf.
+func appendPixel(buf []byte, pf rfb.PixelFormat, r, g, b uint8) []byte {
+	v := scale(r, pf.RedMax)<<pf.RedShift |
+		scale(g, pf.GreenMax)<<pf.GreenShift |
+		scale(b, pf.BlueMax)<<pf.BlueShift
+
+	var order binary.AppendByteOrder = binary.LittleEndian
+	if pf.BigEndian {
+		order = binary.BigEndian
+	}
+	switch pf.BPP {
+	case 8:
+		return append(buf, uint8(v)) // #nosec G115 - the components fit the pixel format
+	case 16:
+		return order.AppendUint16(buf, uint16(v)) // #nosec G115 - the components fit the pixel format
+	default:
+		return order.AppendUint32(buf, v)
+	}
+}
+
+// scale scales an 8-bit color component to the range 0..max, rounding to the
+// nearest value.
+func scale(v uint8, max uint16) uint32 {
+	return (uint32(v)*uint32(max) + 127) / 255
+}
@@ -0,0 +1,372 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+// Package server implements the server side of the RFB protocol for Go
+// programs that expose a desktop, or act as one toward viewers, such as the
+// proxy package.
+//
+// Accept performs the handshake with a viewer: it negotiates the protocol
+// version, authenticates the viewer with one of the configured
+// Authenticators, asks the Authorizer which access to grant, and sends the
+// ServerInit. The returned Conn records the viewer's identity and access, and
+// sends messages to the viewer with the message types the vnc package parses.
+//
+// Example usage:
+//
+//	conn, err := server.Accept(ctx, netConn, &server.Config{
+//		ServerInit: rfb.ServerInit{Width: 1024, Height: 768, PixelFormat: pf, Name: "app"},
+//		Auth:       []server.Authenticator{&server.PasswordAuth{Password: "secret"}},
+//		Authorizer: server.AuthorizerFunc(func(ctx context.Context, req server.AccessRequest) (server.Access, error) {
+//			if isOperator(req.RemoteAddr) {
+//				return server.AccessInteractive, nil
+//			}
+//			return server.AccessViewOnly, nil
+//		}),
+//	})
+//
+// A Display holds the pixels of a desktop drawn by the application and
+// serves them to viewers. It tracks the damaged regions of the desktop for
+// each viewer, coalesces them, and sends them when the viewer requests an
+// update, so applications expose their UI without writing protocol logic:
+//
+//	display := server.NewDisplay(1024, 768)
+//	go render(display) // calls display.Update as the UI changes
+//
+//	conn, err := server.Accept(ctx, netConn, &server.Config{ServerInit: display.ServerInit("app")})
+//	if err != nil {
+//		return err
+//	}
+//	return display.Serve(ctx, conn)
+//
+// Applications that render whole frames hand them to Display.Swap, which
+// damages only the tiles that differ. A Canvas is a draw.Image that tracks
+// the tiles drawn into it until Flush, and Display.Mirror polls a Capturer,
+// the interface platform screen grabbers implement, to share a real desktop.
+// Display.Resize changes the size of the desktop and announces it to viewers
+// that support the DesktopSize pseudo-encoding.
+//
+// Display answers the fence requests of viewers that announce the Fence
+// pseudo-encoding, and Conn.Fence sends fences of the server's own. Viewers
+// that announce the ContinuousUpdates pseudo-encoding may enable continuous
+// updates, which Display sends as the display changes without waiting for
+// update requests.
+//
+// Display.Input receives the key, pointer, and clipboard input of
+// interactive viewers. InputFuncs adapts callbacks that drive a synthetic
+// UI; UinputSink on Linux and SendInputSink on Windows inject the input into
+// the local desktop.
+package server
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/tenthirtyam/go-vnc"
+	"github.com/tenthirtyam/go-vnc/rfb"
+)
+
+// DefaultHandshakeTimeout bounds the handshake when Config.HandshakeTimeout
+// is zero.
+const DefaultHandshakeTimeout = 30 * time.Second
+
+// ErrAccessDenied is returned by Accept when the Authorizer denied the viewer.
+var ErrAccessDenied = errors.New("server: access denied")
+
+// Config configures the server side of the handshake.
+type Config struct {
+	// ServerInit is sent to viewers that are granted access.
+	ServerInit rfb.ServerInit
+
+	// Init, if set, is called once a viewer has been authenticated and
+	// granted access and returns the ServerInit to send in place of
+	// ServerInit, such as that of an upstream server a proxy only connects
+	// to for authenticated viewers. An error refuses the viewer with the
+	// text of the error as the reason.
+	Init func(ctx context.Context) (rfb.ServerInit, error)
+
+	// Auth lists the security types offered to viewers, in order of
+	// preference. With no Authenticators only NoneAuth is offered.
+	Auth []Authenticator
+
+	// Authorizer, if set, decides on the access of each authenticated
+	// viewer. Without it viewers get the access their credentials request.
+	Authorizer Authorizer
+
+	// HandshakeTimeout bounds the handshake. Zero uses
+	// DefaultHandshakeTimeout.
+	HandshakeTimeout time.Duration
+
+	// Logger receives diagnostics. Nil disables logging.
+	Logger vnc.Logger
+}
+
+// logger returns the configured logger or a no-op logger.
+func (cfg *Config) logger() vnc.Logger {
+	if cfg.Logger == nil {
+		return &vnc.NoOpLogger{}
+	}
+	return cfg.Logger
+}
+
+// Conn is a viewer connection that completed the handshake.
+type Conn struct {
+	conn     net.Conn
+	minor    uint
+	identity Identity
+	access   Access
+	shared   bool
+
+	// pixelFormat is the pixel format announced in the ServerInit.
+	pixelFormat rfb.PixelFormat
+
+	// writeMu keeps messages from different goroutines from interleaving.
+	writeMu sync.Mutex
+}
+
+// NetConn returns the underlying connection.
+func (c *Conn) NetConn() net.Conn {
+	return c.conn
+}
+
+// RemoteAddr returns the address of the viewer.
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// ProtocolVersion returns the negotiated protocol version, 3.3, 3.7, or 3.8.
+func (c *Conn) ProtocolVersion() (major, minor uint) {
+	return 3, c.minor
+}
+
+// Identity returns who the viewer authenticated as.
+func (c *Conn) Identity() Identity {
+	return c.identity
+}
+
+// Access returns the access granted to the viewer.
+func (c *Conn) Access() Access {
+	return c.access
+}
+
+// Shared reports whether the viewer asked to share the desktop with other
+// viewers in its ClientInit message.
+func (c *Conn) Shared() bool {
+	return c.shared
+}
+
+// Close closes the connection.
+func (c *Conn) Close() error {
+	return c.conn.Close()
+}
+
+// Accept performs the server side of the handshake on conn. On failure the
+// viewer is told the reason where the protocol version allows it, and the
+// caller remains responsible for closing conn.
+func Accept(ctx context.Context, conn net.Conn, cfg *Config) (*Conn, error) {
+	if cfg == nil {
+		cfg = &Config{}
+	}
+	timeout := cfg.HandshakeTimeout
+	if timeout <= 0 {
+		timeout = DefaultHandshakeTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	minor, err := negotiateVersion(conn)
+	if err != nil {
+		return nil, err
+	}
+	c := &Conn{conn: conn, minor: minor}
+
+	auth, err := c.negotiateSecurity(cfg.Auth)
+	if err != nil {
+		return nil, err
+	}
+
+	identity, requested, err := auth.Authenticate(ctx, conn)
+	if err != nil {
+		if errors.Is(err, ErrAuthenticationFailed) {
+			cfg.logger().Warn("Viewer failed authentication",
+				vnc.Field{Key: "remote_addr", Value: conn.RemoteAddr()},
+				vnc.Field{Key: "security_type", Value: auth.SecurityType()})
+			_ = c.writeSecurityResult(auth, errors.New("authentication failed"))
+		}
+		return nil, err
+	}
+	c.identity = identity
+
+	c.access = c.authorize(ctx, cfg, requested)
+	if c.access == AccessDenied {
+		_ = c.writeSecurityResult(auth, errors.New("access denied"))
+		return nil, ErrAccessDenied
+	}
+	init := cfg.ServerInit
+	if cfg.Init != nil {
+		if init, err = cfg.Init(ctx); err != nil {
+			_ = c.writeSecurityResult(auth, err)
+			return nil, err
+		}
+	}
+	if err := c.writeSecurityResult(auth, nil); err != nil {
+		return nil, err
+	}
+
+	if c.shared, err = rfb.ReadClientInit(conn); err != nil {
+		return nil, err
+	}
+	if err := rfb.WriteServerInit(conn, init); err != nil {
+		return nil, err
+	}
+	c.pixelFormat = init.PixelFormat
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		return nil, err
+	}
+
+	cfg.logger().Info("Viewer connected",
+		vnc.Field{Key: "remote_addr", Value: conn.RemoteAddr()},
+		vnc.Field{Key: "user", Value: identity.User},
+		vnc.Field{Key: "access", Value: c.access.String()})
+	return c, nil
+}
+
+// Refuse completes the version exchange with a viewer and refuses it with
+// reason, for example when the desktop is unavailable. The caller remains
+// responsible for closing conn.
+func Refuse(ctx context.Context, conn net.Conn, reason string) error {
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	minor, err := negotiateVersion(conn)
+	if err != nil {
+		return err
+	}
+	if minor < 7 {
+		return writeRFB33Failure(conn, reason)
+	}
+	return rfb.WriteSecurityFailure(conn, reason)
+}
+
+// writeRFB33Failure refuses an RFB 3.3 viewer with security type 0 and reason.
+func writeRFB33Failure(conn net.Conn, reason string) error {
+	buf := binary.BigEndian.AppendUint32(nil, 0)
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(reason))) // #nosec G115 - reasons are short
+	_, err := conn.Write(append(buf, reason...))
+	return err
+}
+
+// negotiateVersion offers RFB 3.8 and returns the minor version to speak.
+// Unknown minor versions below 3.7 get RFB 3.3 semantics, as RFC 6143
+// requires.
+func negotiateVersion(conn net.Conn) (uint, error) {
+	if err := rfb.WriteProtocolVersion(conn, 3, 8); err != nil {
+		return 0, err
+	}
+	major, minor, err := rfb.ReadProtocolVersion(conn)
+	if err != nil {
+		return 0, err
+	}
+	switch {
+	case major != 3 || minor < 3:
+		return 0, fmt.Errorf("server: unsupported protocol version %d.%d", major, minor)
+	case minor >= 8:
+		return 8, nil
+	case minor == 7:
+		return 7, nil
+	default:
+		return 3, nil
+	}
+}
+
+// negotiateSecurity offers the security types of methods and returns the one
+// the viewer selected. RFB 3.3 viewers get the first of None and VNC
+// authentication, the only types that version knows.
+func (c *Conn) negotiateSecurity(methods []Authenticator) (Authenticator, error) {
+	if len(methods) == 0 {
+		methods = []Authenticator{NoneAuth{}}
+	}
+
+	if c.minor < 7 {
+		i := slices.IndexFunc(methods, func(auth Authenticator) bool {
+			return auth.SecurityType() == rfb.SecurityNone || auth.SecurityType() == rfb.SecurityVNCAuth
+		})
+		if i < 0 {
+			const reason = "no security type supported by RFB 3.3"
+			_ = writeRFB33Failure(c.conn, reason)
+			return nil, errors.New("server: " + reason)
+		}
+		if err := binary.Write(c.conn, binary.BigEndian, uint32(methods[i].SecurityType())); err != nil {
+			return nil, err
+		}
+		return methods[i], nil
+	}
+
+	types := make([]uint8, len(methods))
+	for i, auth := range methods {
+		types[i] = auth.SecurityType()
+	}
+	if err := rfb.WriteSecurityTypes(c.conn, types); err != nil {
+		return nil, err
+	}
+	selected, err := rfb.ReadSecurityType(c.conn)
+	if err != nil {
+		return nil, err
+	}
+	i := slices.Index(types, selected)
+	if i < 0 {
+		return nil, fmt.Errorf("server: viewer selected unoffered security type %d", selected)
+	}
+	return methods[i], nil
+}
+
+// authorize returns the access granted to the authenticated viewer.
+func (c *Conn) authorize(ctx context.Context, cfg *Config, requested Access) Access {
+	if cfg.Authorizer == nil {
+		return requested
+	}
+
+	granted, err := cfg.Authorizer.Authorize(ctx, AccessRequest{
+		Identity:   c.identity,
+		RemoteAddr: c.conn.RemoteAddr(),
+		Requested:  requested,
+	})
+	if err != nil {
+		cfg.logger().Error("Authorizer failed",
+			vnc.Field{Key: "remote_addr", Value: c.conn.RemoteAddr()},
+			vnc.Field{Key: "error", Value: err})
+		return AccessDenied
+	}
+
+	access := min(granted, requested)
+	if access == AccessDenied {
+		cfg.logger().Warn("Viewer denied access",
+			vnc.Field{Key: "remote_addr", Value: c.conn.RemoteAddr()},
+			vnc.Field{Key: "user", Value: c.identity.User})
+	}
+	return access
+}
+
+// writeSecurityResult reports the outcome of the security handshake.
+// RFB 3.3 and 3.7 send no result after None and no reason with a failure.
+func (c *Conn) writeSecurityResult(auth Authenticator, failure error) error {
+	if c.minor >= 8 {
+		return rfb.WriteSecurityResult(c.conn, failure)
+	}
+	if auth.SecurityType() == rfb.SecurityNone {
+		return nil
+	}
+	var result uint32
+	if failure != nil {
+		result = 1
+	}
+	return binary.Write(c.conn, binary.BigEndian, result)
+}
@@ -0,0 +1,322 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package server
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/tenthirtyam/go-vnc"
+	"github.com/tenthirtyam/go-vnc/rfb"
+)
+
+// testServerInit is the ServerInit sent by the servers under test.
+var testServerInit = rfb.ServerInit{
+	Width:  64,
+	Height: 48,
+	PixelFormat: rfb.PixelFormat{
+		BPP: 32, Depth: 24, TrueColor: true,
+		RedMax: 255, GreenMax: 255, BlueMax: 255,
+		RedShift: 16, GreenShift: 8,
+	},
+	Name: "test",
+}
+
+// acceptResult is the outcome of Accept on the server side of a pipe.
+type acceptResult struct {
+	conn *Conn
+	err  error
+}
+
+// startAccept runs Accept with cfg on one end of a pipe and returns the other
+// end for the viewer.
+func startAccept(t *testing.T, cfg *Config) (net.Conn, <-chan acceptResult) {
+	t.Helper()
+
+	viewerConn, serverConn := net.Pipe()
+	t.Cleanup(func() {
+		_ = viewerConn.Close()
+		_ = serverConn.Close()
+	})
+	cfg.ServerInit = testServerInit
+	cfg.HandshakeTimeout = 5 * time.Second
+
+	results := make(chan acceptResult, 1)
+	go func() {
+		conn, err := Accept(context.Background(), serverConn, cfg)
+		results <- acceptResult{conn, err}
+	}()
+	return viewerConn, results
+}
+
+// dial connects a vnc client with password to the viewer end of a pipe.
+func dial(viewerConn net.Conn, password string) (*vnc.ClientConn, error) {
+	return vnc.ClientWithOptions(context.Background(), viewerConn,
+		vnc.WithAuth(vnc.NewPasswordAuth(password)),
+		vnc.WithConnectTimeout(5*time.Second))
+}
+
+// drain discards what the server sends so that it is never blocked on the
+// pipe.
+func drain(conn net.Conn) {
+	go func() { _, _ = io.Copy(io.Discard, conn) }()
+}
+
+func TestAccept_Password(t *testing.T) {
+	viewerConn, results := startAccept(t, &Config{
+		Auth: []Authenticator{&PasswordAuth{Password: "secret", ViewOnlyPassword: "watch"}},
+	})
+
+	client, err := dial(viewerConn, "watch")
+	if err != nil {
+		t.Fatalf("client handshake: %v", err)
+	}
+	defer client.Close()
+	if got := client.GetDesktopName(); got != testServerInit.Name {
+		t.Errorf("desktop name = %q, want %q", got, testServerInit.Name)
+	}
+
+	res := <-results
+	if res.err != nil {
+		t.Fatalf("Accept: %v", res.err)
+	}
+	drain(res.conn.NetConn())
+	if res.conn.Access() != AccessViewOnly {
+		t.Errorf("access = %s, want %s", res.conn.Access(), AccessViewOnly)
+	}
+	if res.conn.Identity().SecurityType != rfb.SecurityVNCAuth {
+		t.Errorf("security type = %d, want %d", res.conn.Identity().SecurityType, rfb.SecurityVNCAuth)
+	}
+	if _, minor := res.conn.ProtocolVersion(); minor != 8 {
+		t.Errorf("protocol version 3.%d, want 3.8", minor)
+	}
+}
+
+func TestAccept_WrongPassword(t *testing.T) {
+	viewerConn, results := startAccept(t, &Config{
+		Auth: []Authenticator{&PasswordAuth{Password: "secret"}},
+	})
+
+	if _, err := dial(viewerConn, "guess"); !vnc.IsVNCError(err, vnc.ErrAuthentication) {
+		t.Errorf("client error = %v, want an authentication error", err)
+	}
+	if res := <-results; !errors.Is(res.err, ErrAuthenticationFailed) {
+		t.Errorf("Accept error = %v, want ErrAuthenticationFailed", res.err)
+	}
+}
+
+func TestAccept_Authorizer(t *testing.T) {
+	tests := []struct {
+		name    string
+		access  Access
+		err     error
+		want    Access
+		wantErr error
+	}{
+		{name: "Interactive", access: AccessInteractive, want: AccessInteractive},
+		{name: "Downgrade", access: AccessViewOnly, want: AccessViewOnly},
+		{name: "Deny", access: AccessDenied, wantErr: ErrAccessDenied},
+		{name: "Error", access: AccessInteractive, err: errors.New("directory unavailable"), wantErr: ErrAccessDenied},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var req AccessRequest
+			viewerConn, results := startAccept(t, &Config{
+				Auth: []Authenticator{&PasswordAuth{Password: "secret"}},
+				Authorizer: AuthorizerFunc(func(_ context.Context, r AccessRequest) (Access, error) {
+					req = r
+					return tt.access, tt.err
+				}),
+			})
+
+			client, err := dial(viewerConn, "secret")
+			res := <-results
+			if tt.wantErr != nil {
+				if !vnc.IsVNCError(err, vnc.ErrAuthentication) {
+					t.Errorf("client error = %v, want an authentication error", err)
+				}
+				if !errors.Is(res.err, tt.wantErr) {
+					t.Errorf("Accept error = %v, want %v", res.err, tt.wantErr)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("client handshake: %v", err)
+			}
+			defer client.Close()
+			if res.err != nil {
+				t.Fatalf("Accept: %v", res.err)
+			}
+			drain(res.conn.NetConn())
+			if res.conn.Access() != tt.want {
+				t.Errorf("access = %s, want %s", res.conn.Access(), tt.want)
+			}
+			if req.Requested != AccessInteractive || req.RemoteAddr == nil {
+				t.Errorf("Authorizer request = %+v, want interactive access and a remote address", req)
+			}
+		})
+	}
+}
+
+func TestAccept_NoUpgrade(t *testing.T) {
+	viewerConn, results := startAccept(t, &Config{
+		Auth: []Authenticator{&PasswordAuth{Password: "secret", ViewOnlyPassword: "watch"}},
+		Authorizer: AuthorizerFunc(func(context.Context, AccessRequest) (Access, error) {
+			return AccessInteractive, nil
+		}),
+	})
+
+	client, err := dial(viewerConn, "watch")
+	if err != nil {
+		t.Fatalf("client handshake: %v", err)
+	}
+	defer client.Close()
+
+	res := <-results
+	if res.err != nil {
+		t.Fatalf("Accept: %v", res.err)
+	}
+	drain(res.conn.NetConn())
+	if res.conn.Access() != AccessViewOnly {
+		t.Errorf("access = %s, want the view-only password to stay %s", res.conn.Access(), AccessViewOnly)
+	}
+}
+
+func TestAccept_Init(t *testing.T) {
+	init := testServerInit
+	init.Name = "upstream"
+	var calls int
+	viewerConn, results := startAccept(t, &Config{
+		Auth: []Authenticator{&PasswordAuth{Password: "secret"}},
+		Init: func(context.Context) (rfb.ServerInit, error) {
+			calls++
+			return init, nil
+		},
+	})
+
+	if _, err := dial(viewerConn, "guess"); !vnc.IsVNCError(err, vnc.ErrAuthentication) {
+		t.Errorf("client error = %v, want an authentication error", err)
+	}
+	if res := <-results; !errors.Is(res.err, ErrAuthenticationFailed) {
+		t.Errorf("Accept error = %v, want ErrAuthenticationFailed", res.err)
+	}
+	if calls != 0 {
+		t.Fatalf("Init called %d times for an unauthenticated viewer, want 0", calls)
+	}
+
+	viewerConn, results = startAccept(t, &Config{
+		Auth: []Authenticator{&PasswordAuth{Password: "secret"}},
+		Init: func(context.Context) (rfb.ServerInit, error) {
+			calls++
+			return init, nil
+		},
+	})
+	client, err := dial(viewerConn, "secret")
+	if err != nil {
+		t.Fatalf("client handshake: %v", err)
+	}
+	defer client.Close()
+	res := <-results
+	if res.err != nil {
+		t.Fatalf("Accept: %v", res.err)
+	}
+	drain(res.conn.NetConn())
+	if got := client.GetDesktopName(); got != init.Name || calls != 1 {
+		t.Errorf("desktop name = %q after %d Init calls, want %q after 1", got, calls, init.Name)
+	}
+}
+
+func TestAccept_InitError(t *testing.T) {
+	viewerConn, results := startAccept(t, &Config{
+		Init: func(context.Context) (rfb.ServerInit, error) {
+			return rfb.ServerInit{}, errors.New("desktop unavailable")
+		},
+	})
+
+	if _, _, err := rfb.ReadProtocolVersion(viewerConn); err != nil {
+		t.Fatal(err)
+	}
+	if err := rfb.WriteProtocolVersion(viewerConn, 3, 8); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rfb.ReadSecurityTypes(viewerConn); err != nil {
+		t.Fatal(err)
+	}
+	if err := rfb.WriteSecurityType(viewerConn, rfb.SecurityNone); err != nil {
+		t.Fatal(err)
+	}
+	err := rfb.ReadSecurityResult(viewerConn)
+	var failure *rfb.FailureError
+	if !errors.As(err, &failure) || failure.Reason != "desktop unavailable" {
+		t.Errorf("ReadSecurityResult error = %v, want the Init failure reason", err)
+	}
+	if res := <-results; res.err == nil {
+		t.Error("Accept succeeded despite the Init failure")
+	}
+}
+
+func TestAccept_RFB33(t *testing.T) {
+	viewerConn, results := startAccept(t, &Config{})
+
+	if _, _, err := rfb.ReadProtocolVersion(viewerConn); err != nil {
+		t.Fatal(err)
+	}
+	if err := rfb.WriteProtocolVersion(viewerConn, 3, 3); err != nil {
+		t.Fatal(err)
+	}
+	var securityType uint32
+	if err := binary.Read(viewerConn, binary.BigEndian, &securityType); err != nil {
+		t.Fatal(err)
+	}
+	if securityType != uint32(rfb.SecurityNone) {
+		t.Fatalf("security type = %d, want None", securityType)
+	}
+	// RFB 3.3 sends no SecurityResult after None.
+	if err := rfb.WriteClientInit(viewerConn, false); err != nil {
+		t.Fatal(err)
+	}
+	init, err := rfb.ReadServerInit(viewerConn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if init.Name != testServerInit.Name {
+		t.Errorf("desktop name = %q, want %q", init.Name, testServerInit.Name)
+	}
+
+	res := <-results
+	if res.err != nil {
+		t.Fatalf("Accept: %v", res.err)
+	}
+	if _, minor := res.conn.ProtocolVersion(); minor != 3 || res.conn.Shared() {
+		t.Errorf("protocol version 3.%d, shared %v; want 3.3 and exclusive", minor, res.conn.Shared())
+	}
+}
+
+func TestRefuse(t *testing.T) {
+	viewerConn, serverConn := net.Pipe()
+	defer viewerConn.Close()
+	go func() {
+		_ = Refuse(context.Background(), serverConn, "maintenance")
+		_ = serverConn.Close()
+	}()
+
+	if _, _, err := rfb.ReadProtocolVersion(viewerConn); err != nil {
+		t.Fatal(err)
+	}
+	if err := rfb.WriteProtocolVersion(viewerConn, 3, 8); err != nil {
+		t.Fatal(err)
+	}
+	_, err := rfb.ReadSecurityTypes(viewerConn)
+	var failure *rfb.FailureError
+	if !errors.As(err, &failure) || failure.Reason != "maintenance" {
+		t.Errorf("ReadSecurityTypes error = %v, want the refusal reason", err)
+	}
+}
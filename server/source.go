@@ -0,0 +1,206 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package server
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/draw"
+	"time"
+)
+
+// tileSize is the size of the tiles Swap compares and Canvas tracks.
+const tileSize = 16
+
+// Swap replaces the contents of the display with img, which is aligned with
+// the display's origin, and marks only the tiles that differ as damaged. It
+// suits applications that render whole frames, such as a game loop or a
+// snapshot of a real desktop, without knowing what changed.
+func (d *Display) Swap(img image.Image) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	bounds := d.fb.Rect
+	src, _ := img.(*image.RGBA)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y += tileSize {
+		for x := bounds.Min.X; x < bounds.Max.X; x += tileSize {
+			tile := image.Rect(x, y, x+tileSize, y+tileSize).Intersect(bounds)
+			if src != nil && tileEqual(d.fb, src, tile) {
+				continue
+			}
+			if src == nil && tileEqualImage(d.fb, img, tile) {
+				continue
+			}
+			draw.Draw(d.fb, tile, img, tile.Min, draw.Src)
+			d.damageLocked(tile)
+		}
+	}
+}
+
+// tileEqual reports whether a and b have the same pixels in tile. Pixels of b
+// outside its bounds count as different.
+func tileEqual(a, b *image.RGBA, tile image.Rectangle) bool {
+	if !tile.In(b.Rect) {
+		return false
+	}
+	for y := tile.Min.Y; y < tile.Max.Y; y++ {
+		rowA := a.Pix[a.PixOffset(tile.Min.X, y):a.PixOffset(tile.Max.X, y)]
+		rowB := b.Pix[b.PixOffset(tile.Min.X, y):b.PixOffset(tile.Max.X, y)]
+		if !bytes.Equal(rowA, rowB) {
+			return false
+		}
+	}
+	return true
+}
+
+// tileEqualImage is tileEqual for images of any type.
+func tileEqualImage(a *image.RGBA, b image.Image, tile image.Rectangle) bool {
+	if !tile.In(b.Bounds()) {
+		return false
+	}
+	for y := tile.Min.Y; y < tile.Max.Y; y++ {
+		for x := tile.Min.X; x < tile.Max.X; x++ {
+			if a.RGBAAt(x, y) != color.RGBAModel.Convert(b.At(x, y)).(color.RGBA) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Canvas is a draw.Image in front of a Display. Applications draw into it
+// with the image/draw package or any code that renders to a draw.Image, and
+// call Flush to publish the changed tiles to viewers. A Canvas is not safe
+// for concurrent use.
+type Canvas struct {
+	display *Display
+	img     *image.RGBA
+	dirty   []bool
+	columns int
+}
+
+// NewCanvas returns a Canvas with the current contents of d.
+func NewCanvas(d *Display) *Canvas {
+	d.mu.RLock()
+	img := image.NewRGBA(d.fb.Rect)
+	copy(img.Pix, d.fb.Pix)
+	d.mu.RUnlock()
+
+	columns := (img.Rect.Dx() + tileSize - 1) / tileSize
+	rows := (img.Rect.Dy() + tileSize - 1) / tileSize
+	return &Canvas{display: d, img: img, dirty: make([]bool, columns*rows), columns: columns}
+}
+
+// ColorModel returns color.RGBAModel.
+func (c *Canvas) ColorModel() color.Model {
+	return color.RGBAModel
+}
+
+// Bounds returns the bounds of the display.
+func (c *Canvas) Bounds() image.Rectangle {
+	return c.img.Rect
+}
+
+// At returns the color of the pixel at (x, y).
+func (c *Canvas) At(x, y int) color.Color {
+	return c.img.At(x, y)
+}
+
+// Set sets the color of the pixel at (x, y).
+func (c *Canvas) Set(x, y int, col color.Color) {
+	if !(image.Point{x, y}).In(c.img.Rect) {
+		return
+	}
+	c.img.Set(x, y, col)
+	c.dirty[(y/tileSize)*c.columns+x/tileSize] = true
+}
+
+// Draw draws src onto r of the canvas like draw.Draw with operator op, which
+// is much faster than drawing through Set.
+func (c *Canvas) Draw(r image.Rectangle, src image.Image, sp image.Point, op draw.Op) {
+	draw.Draw(c.img, r, src, sp, op)
+	c.Damage(r)
+}
+
+// Damage marks r as changed, for pixels modified without Set or Draw.
+func (c *Canvas) Damage(r image.Rectangle) {
+	r = r.Intersect(c.img.Rect)
+	if r.Empty() {
+		return
+	}
+	for ty := r.Min.Y / tileSize; ty <= (r.Max.Y-1)/tileSize; ty++ {
+		for tx := r.Min.X / tileSize; tx <= (r.Max.X-1)/tileSize; tx++ {
+			c.dirty[ty*c.columns+tx] = true
+		}
+	}
+}
+
+// Flush publishes the tiles changed since the last Flush to the display.
+func (c *Canvas) Flush() {
+	var changed region
+	for i, dirty := range c.dirty {
+		if !dirty {
+			continue
+		}
+		c.dirty[i] = false
+		x, y := (i%c.columns)*tileSize, (i/c.columns)*tileSize
+		changed.add(image.Rect(x, y, x+tileSize, y+tileSize))
+	}
+
+	d := c.display
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, r := range changed {
+		r = r.Intersect(d.fb.Rect)
+		draw.Draw(d.fb, r, c.img, r.Min, draw.Src)
+		d.damageLocked(r)
+	}
+}
+
+// Capturer captures the screen of a real desktop. Implementations wrap the
+// platform's capture API, such as XShm and XDamage on X11 or Desktop
+// Duplication on Windows, and Display.Mirror serves the captured frames.
+type Capturer interface {
+	// Capture returns the current frame, aligned with the display's
+	// origin, and the areas changed since the previous call. A nil damage
+	// list means the changes are unknown, and the frame is compared with
+	// the previous one.
+	Capture(ctx context.Context) (frame image.Image, damage []image.Rectangle, err error)
+}
+
+// CapturerFunc adapts a function to the Capturer interface.
+type CapturerFunc func(ctx context.Context) (image.Image, []image.Rectangle, error)
+
+// Capture calls f.
+func (f CapturerFunc) Capture(ctx context.Context) (image.Image, []image.Rectangle, error) {
+	return f(ctx)
+}
+
+// Mirror captures a frame from c every interval and shows it on the display
+// until ctx ends or a capture fails.
+func (d *Display) Mirror(ctx context.Context, c Capturer, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		frame, damage, err := c.Capture(ctx)
+		if err != nil {
+			return err
+		}
+		if damage == nil {
+			d.Swap(frame)
+		}
+		for _, r := range damage {
+			d.Update(frame, r)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,132 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package server
+
+import (
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/tenthirtyam/go-vnc/rfb"
+)
+
+// watch registers a viewer with d and returns its scheduler, whose dirty
+// region records the damage d reports.
+func watch(d *Display) *scheduler {
+	s := newScheduler(rfb.PixelFormat{})
+	d.mu.Lock()
+	d.viewers[s] = struct{}{}
+	d.mu.Unlock()
+	return s
+}
+
+// damaged returns the damage reported to s.
+func damaged(s *scheduler) []image.Rectangle {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.dirty)
+}
+
+func TestDisplay_Swap(t *testing.T) {
+	tests := []struct {
+		name  string
+		frame func() image.Image
+	}{
+		{
+			name: "RGBA",
+			frame: func() image.Image {
+				img := image.NewRGBA(image.Rect(0, 0, 64, 32))
+				draw.Draw(img, img.Rect, &image.Uniform{C: color.RGBA{A: 0xff}}, image.Point{}, draw.Src)
+				img.SetRGBA(20, 5, red)
+				return img
+			},
+		},
+		{
+			name: "Paletted",
+			frame: func() image.Image {
+				img := image.NewPaletted(image.Rect(0, 0, 64, 32), color.Palette{color.Black, red})
+				img.SetColorIndex(20, 5, 1)
+				return img
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			display := NewDisplay(64, 32)
+			display.Update(&image.Uniform{C: color.RGBA{A: 0xff}}, display.Bounds())
+			s := watch(display)
+
+			display.Swap(tt.frame())
+			if got, want := damaged(s), []image.Rectangle{image.Rect(16, 0, 32, 16)}; !slices.Equal(got, want) {
+				t.Errorf("damage = %v, want the changed tile %v", got, want)
+			}
+			if got := display.fb.RGBAAt(20, 5); got != red {
+				t.Errorf("pixel (20,5) = %v, want red", got)
+			}
+		})
+	}
+}
+
+func TestCanvas_Flush(t *testing.T) {
+	display := NewDisplay(64, 32)
+	s := watch(display)
+	canvas := NewCanvas(display)
+
+	canvas.Set(1, 1, red)
+	canvas.Draw(image.Rect(40, 20, 44, 24), &image.Uniform{C: blue}, image.Point{}, draw.Src)
+	if got := damaged(s); len(got) != 0 {
+		t.Fatalf("damage before Flush = %v, want none", got)
+	}
+
+	canvas.Flush()
+	want := []image.Rectangle{image.Rect(0, 0, 16, 16), image.Rect(32, 16, 48, 32)}
+	if got := damaged(s); !slices.Equal(got, want) {
+		t.Errorf("damage = %v, want %v", got, want)
+	}
+	if got := display.fb.RGBAAt(1, 1); got != red {
+		t.Errorf("pixel (1,1) = %v, want red", got)
+	}
+	if got := display.fb.RGBAAt(42, 22); got != blue {
+		t.Errorf("pixel (42,22) = %v, want blue", got)
+	}
+}
+
+func TestDisplay_Mirror(t *testing.T) {
+	display := NewDisplay(32, 16)
+	s := watch(display)
+
+	frame := image.NewRGBA(display.Bounds())
+	frame.SetRGBA(3, 3, red)
+	errDone := errors.New("done")
+	var calls int
+	capturer := CapturerFunc(func(context.Context) (image.Image, []image.Rectangle, error) {
+		calls++
+		switch calls {
+		case 1:
+			return frame, nil, nil
+		case 2:
+			frame.SetRGBA(30, 10, blue)
+			return frame, []image.Rectangle{image.Rect(30, 10, 31, 11)}, nil
+		default:
+			return nil, nil, errDone
+		}
+	})
+
+	if err := display.Mirror(context.Background(), capturer, time.Millisecond); !errors.Is(err, errDone) {
+		t.Fatalf("Mirror() = %v, want the capture error", err)
+	}
+	want := []image.Rectangle{image.Rect(0, 0, 16, 16), image.Rect(30, 10, 31, 11)}
+	if got := damaged(s); !slices.Equal(got, want) {
+		t.Errorf("damage = %v, want the changed tile and the reported area %v", got, want)
+	}
+	if got := display.fb.RGBAAt(30, 10); got != blue {
+		t.Errorf("pixel (30,10) = %v, want blue", got)
+	}
+}
@@ -4,9 +4,11 @@
 package vnc
 
 import (
-	"encoding/binary"
 	"fmt"
 	"io"
+	"time"
+
+	"github.com/tenthirtyam/go-vnc/rfb"
 )
 
 // ServerMessage defines the interface for messages sent from a VNC server to the client.
@@ -17,8 +19,16 @@ type ServerMessage interface {
 
 // FramebufferUpdateMessage represents a framebuffer update from the server (message type 0).
 type FramebufferUpdateMessage struct {
-	// Rectangles contains the list of screen rectangles being updated.
+	// Rectangles contains the list of screen rectangles being updated, in
+	// the order the server sent them. A DesktopSize or ExtendedDesktopSize
+	// rectangle changes the framebuffer size for the rectangles after it.
 	Rectangles []Rectangle
+
+	// Width and Height are the framebuffer size once the update has been
+	// applied. Unlike GetFrameBufferSize, which may already reflect later
+	// updates, they match the rectangles of this message.
+	Width  uint16
+	Height uint16
 }
 
 // Rectangle represents a rectangular region of the framebuffer with associated pixel data.
@@ -130,6 +140,9 @@ func (*FramebufferUpdateMessage) Type() uint8 {
 //	//   [4 bytes] - Encoding type (big-endian int32)
 //	//   [variable] - Encoding-specific pixel data
 //
+// An update that announces 65535 rectangles may end early with a LastRect
+// rectangle (see LastRectPseudoEncoding), which is consumed and not returned.
+//
 // Error handling:
 // The method may return various error types:
 //   - NetworkError: I/O failures reading message data
@@ -145,23 +158,29 @@ func (*FramebufferUpdateMessage) Type() uint8 {
 func (*FramebufferUpdateMessage) Read(c *ClientConn, r io.Reader) (ServerMessage, error) {
 	validator := newInputValidator()
 
-	var padding [1]byte
-	if _, err := io.ReadFull(r, padding[:]); err != nil {
-		return nil, networkError("FramebufferUpdateMessage.Read", "failed to read padding", err)
-	}
-
-	var numRects uint16
-	if err := binary.Read(r, binary.BigEndian, &numRects); err != nil {
-		return nil, networkError("FramebufferUpdateMessage.Read", "failed to read number of rectangles", err)
+	var header [3]byte
+	fields, err := readFields(r, "update header", header[:])
+	if err != nil {
+		return nil, networkError("FramebufferUpdateMessage.Read", "failed to read update header", err)
 	}
+	fields.skip(1) // padding
+	numRects := fields.uint16()
 
-	if numRects > MaxRectanglesPerUpdate {
+	// Servers that support LastRect may announce the largest count and end
+	// the update early with a LastRect rectangle.
+	if numRects > MaxRectanglesPerUpdate && numRects != rfb.LastRectUnknownCount {
 		return nil, protocolError("FramebufferUpdateMessage.Read",
 			fmt.Sprintf("too many rectangles in update: %d (max %d)", numRects, MaxRectanglesPerUpdate), nil)
 	}
 
+	// Compressed encodings compiled into the package are always decoded, so
+	// servers that send ZRLE without being asked still work; the instances
+	// passed to SetEncodings take precedence.
 	encMap := make(map[int32]Encoding)
-	for _, enc := range c.Encs {
+	for _, enc := range compressedEncodings() {
+		encMap[enc.Type()] = enc
+	}
+	for _, enc := range c.GetEncodings() {
 		encMap[enc.Type()] = enc
 	}
 
@@ -174,23 +193,26 @@ func (*FramebufferUpdateMessage) Read(c *ClientConn, r io.Reader) (ServerMessage
 	desktopSizePseudo := new(DesktopSizePseudoEncoding)
 	encMap[desktopSizePseudo.Type()] = desktopSizePseudo
 
-	rects := make([]Rectangle, numRects)
+	extendedDesktopSizePseudo := new(ExtendedDesktopSizePseudoEncoding)
+	encMap[extendedDesktopSizePseudo.Type()] = extendedDesktopSizePseudo
+
+	rects := make([]Rectangle, 0, min(numRects, MaxRectanglesPerUpdate))
+	var rectHeader [12]byte
 	for i := uint16(0); i < numRects; i++ {
-		var encodingType int32
-
-		rect := &rects[i]
-		data := []interface{}{
-			&rect.X,
-			&rect.Y,
-			&rect.Width,
-			&rect.Height,
-			&encodingType,
+		fields, err := readFields(r, "rectangle header", rectHeader[:])
+		if err != nil {
+			return nil, networkError("FramebufferUpdateMessage.Read",
+				fmt.Sprintf("failed to read header of rectangle %d", i), err)
 		}
+		rect := &Rectangle{X: fields.uint16(), Y: fields.uint16(), Width: fields.uint16(), Height: fields.uint16()}
+		encodingType := fields.int32()
 
-		for _, val := range data {
-			if err := binary.Read(r, binary.BigEndian, val); err != nil {
-				return nil, networkError("FramebufferUpdateMessage.Read", "failed to read rectangle header", err)
-			}
+		if encodingType == rfb.PseudoEncodingLastRect {
+			break
+		}
+		if i >= MaxRectanglesPerUpdate {
+			return nil, protocolError("FramebufferUpdateMessage.Read",
+				fmt.Sprintf("too many rectangles in update without LastRect (max %d)", MaxRectanglesPerUpdate), nil)
 		}
 
 		if err := validator.ValidateEncodingType(encodingType); err != nil {
@@ -198,26 +220,38 @@ func (*FramebufferUpdateMessage) Read(c *ClientConn, r io.Reader) (ServerMessage
 				fmt.Sprintf("invalid encoding type for rectangle %d", i), err)
 		}
 
-		isPseudoEncoding := encodingType < 0
+		isPseudoEncoding := encodingType < 0 || isVMwarePseudoEncoding(encodingType)
 		if !isPseudoEncoding {
+			fbWidth, fbHeight := c.GetFrameBufferSize()
 			if err := validator.ValidateRectangle(rect.X, rect.Y, rect.Width, rect.Height,
-				c.FrameBufferWidth, c.FrameBufferHeight); err != nil {
+				fbWidth, fbHeight); err != nil {
 				return nil, protocolError("FramebufferUpdateMessage.Read",
 					fmt.Sprintf("invalid rectangle %d", i), err)
 			}
 		}
 
 		enc, ok := encMap[encodingType]
+		if !ok {
+			enc, ok = c.registeredEncoding(encodingType)
+		}
 		if !ok {
 			return nil, unsupportedError("FramebufferUpdateMessage.Read", fmt.Sprintf("unsupported encoding type: %d", encodingType), nil)
 		}
 
-		var err error
-		rect.Enc, err = enc.Read(c, rect, r)
+		counter := &countingReader{r: r}
+		start := time.Now()
+		rect.Enc, err = enc.Read(c, rect, counter)
+		decodeTime := time.Since(start)
 		if err != nil {
 			return nil, encodingError("FramebufferUpdateMessage.Read", "failed to read rectangle encoding data", err)
 		}
 
+		var decodedBytes uint64
+		if !isPseudoEncoding {
+			decodedBytes = uint64(rect.Width) * uint64(rect.Height) * uint64(c.GetPixelFormat().BPP/8)
+		}
+		c.recordRectangle(encodingType, counter.n, decodedBytes, decodeTime)
+
 		if pseudoEnc, isPseudo := rect.Enc.(PseudoEncoding); isPseudo {
 			if err := pseudoEnc.Handle(c, rect); err != nil {
 				c.logger.Error("Failed to handle pseudo-encoding",
@@ -225,9 +259,16 @@ func (*FramebufferUpdateMessage) Read(c *ClientConn, r io.Reader) (ServerMessage
 					Field{Key: "error", Value: err})
 			}
 		}
+
+		rects = append(rects, *rect)
 	}
 
-	return &FramebufferUpdateMessage{rects}, nil
+	c.reportPixelEndianness()
+	c.applyUpdate(rects)
+	c.recordFramebufferUpdate()
+
+	width, height := c.GetFrameBufferSize()
+	return &FramebufferUpdateMessage{Rectangles: rects, Width: width, Height: height}, nil
 }
 
 // SetColorMapEntriesMessage represents a color map update from the server (message type 1).
@@ -316,7 +357,7 @@ func (*SetColorMapEntriesMessage) Type() uint8 {
 //	// After this message is processed, indexed pixel values will use the new colors:
 //	// For 8-bit indexed pixel format:
 //	pixelValue := uint8(42) // Example pixel value from framebuffer update
-//	actualColor := clientConn.ColorMap[pixelValue] // Uses updated color map
+//	actualColor := clientConn.GetColorMap()[pixelValue] // Uses updated color map
 //
 // Message structure:
 //
@@ -331,10 +372,8 @@ func (*SetColorMapEntriesMessage) Type() uint8 {
 //
 // Automatic color map update:
 //
-//	// The method automatically updates the connection's color map:
-//	for i, color := range newColors {
-//		clientConn.ColorMap[firstColor + i] = color
-//	}
+//	// The method automatically updates the connection's color map, so
+//	// GetColorMap returns the new colors at firstColor onwards.
 //
 //	// This ensures that subsequent framebuffer updates with indexed pixels
 //	// will use the correct color interpretations
@@ -358,45 +397,53 @@ func (*SetColorMapEntriesMessage) Type() uint8 {
 func (*SetColorMapEntriesMessage) Read(c *ClientConn, r io.Reader) (ServerMessage, error) {
 	validator := newInputValidator()
 
-	var padding [1]byte
-	if _, err := io.ReadFull(r, padding[:]); err != nil {
-		return nil, networkError("SetColorMapEntriesMessage.Read", "failed to read padding", err)
-	}
-
-	var result SetColorMapEntriesMessage
-	if err := binary.Read(r, binary.BigEndian, &result.FirstColor); err != nil {
-		return nil, networkError("SetColorMapEntriesMessage.Read", "failed to read first color index", err)
-	}
-
-	var numColors uint16
-	if err := binary.Read(r, binary.BigEndian, &numColors); err != nil {
-		return nil, networkError("SetColorMapEntriesMessage.Read", "failed to read number of colors", err)
+	var header [5]byte
+	fields, err := readFields(r, "color map header", header[:])
+	if err != nil {
+		return nil, networkError("SetColorMapEntriesMessage.Read", "failed to read color map header", err)
 	}
+	fields.skip(1) // padding
+	result := SetColorMapEntriesMessage{FirstColor: fields.uint16()}
+	numColors := fields.uint16()
 
 	if err := validator.ValidateColorMapEntries(result.FirstColor, numColors, ColorMapSize); err != nil {
 		return nil, protocolError("SetColorMapEntriesMessage.Read", "invalid color map entries", err)
 	}
 
+	data, err := readBytes(r, "colors", 6*int(numColors))
+	if err != nil {
+		return nil, networkError("SetColorMapEntriesMessage.Read", "failed to read color data", err)
+	}
+	fields = wireFields{buf: data}
 	result.Colors = make([]Color, numColors)
-	for i := uint16(0); i < numColors; i++ {
+	for i := range result.Colors {
+		result.Colors[i] = Color{R: fields.uint16(), G: fields.uint16(), B: fields.uint16()}
+	}
 
-		color := &result.Colors[i]
-		data := []interface{}{
-			&color.R,
-			&color.G,
-			&color.B,
-		}
+	c.setColorMapEntries(result.FirstColor, result.Colors)
 
-		for _, val := range data {
-			if err := binary.Read(r, binary.BigEndian, val); err != nil {
-				return nil, networkError("SetColorMapEntriesMessage.Read", "failed to read color data", err)
-			}
-		}
+	return &result, nil
+}
 
-		c.ColorMap[result.FirstColor+i] = *color
+// Write encodes the message for a client, as servers built with the server
+// package send it. The entries must fit the color map, as Read requires.
+func (m *SetColorMapEntriesMessage) Write(w io.Writer) error {
+	if len(m.Colors) > ColorMapSize {
+		return validationError("SetColorMapEntriesMessage.Write", "too many colors", nil)
+	}
+	// #nosec G115 - len(m.Colors) is at most ColorMapSize
+	if err := newInputValidator().ValidateColorMapEntries(m.FirstColor, uint16(len(m.Colors)), ColorMapSize); err != nil {
+		return validationError("SetColorMapEntriesMessage.Write", "invalid color map entries", err)
 	}
 
-	return &result, nil
+	colors := make([]rfb.Color, len(m.Colors))
+	for i, c := range m.Colors {
+		colors[i] = rfb.Color{R: c.R, G: c.G, B: c.B}
+	}
+	if err := rfb.WriteSetColorMapEntries(w, m.FirstColor, colors); err != nil {
+		return networkError("SetColorMapEntriesMessage.Write", "failed to write color map entries", err)
+	}
+	return nil
 }
 
 // BellMessage represents an audible bell notification from the server (message type 2).
@@ -432,6 +479,15 @@ func (*BellMessage) Read(*ClientConn, io.Reader) (ServerMessage, error) {
 	return new(BellMessage), nil
 }
 
+// Write encodes the message for a client, as servers built with the server
+// package send it.
+func (*BellMessage) Write(w io.Writer) error {
+	if err := rfb.WriteBell(w); err != nil {
+		return networkError("BellMessage.Write", "failed to write bell", err)
+	}
+	return nil
+}
+
 // ServerCutTextMessage represents clipboard data from the server (message type 3).
 // This message is sent when the server's clipboard (cut buffer) contents change
 // and should be synchronized with the client's clipboard.
@@ -590,22 +646,24 @@ func (*ServerCutTextMessage) Type() uint8 {
 func (*ServerCutTextMessage) Read(c *ClientConn, r io.Reader) (ServerMessage, error) {
 	validator := newInputValidator()
 
-	var padding [1]byte
-	if _, err := io.ReadFull(r, padding[:]); err != nil {
-		return nil, networkError("ServerCutTextMessage.Read", "failed to read padding", err)
+	var header [7]byte
+	fields, err := readFields(r, "cut text header", header[:])
+	if err != nil {
+		return nil, networkError("ServerCutTextMessage.Read", "failed to read cut text header", err)
 	}
-
-	var textLength uint32
-	if err := binary.Read(r, binary.BigEndian, &textLength); err != nil {
-		return nil, networkError("ServerCutTextMessage.Read", "failed to read text length", err)
-	}
-
-	if err := validator.ValidateMessageLength(textLength, MaxServerClipboardLength); err != nil {
-		return nil, protocolError("ServerCutTextMessage.Read", "invalid clipboard text length", err)
+	fields.skip(3) // padding
+	textLength := fields.uint32()
+
+	// Empty text is valid: servers send it when their clipboard is cleared,
+	// and Vino as a keep-alive (see QuirkCutTextKeepAlive).
+	if textLength > 0 {
+		if err := validator.ValidateMessageLength(textLength, MaxServerClipboardLength); err != nil {
+			return nil, protocolError("ServerCutTextMessage.Read", "invalid clipboard text length", err)
+		}
 	}
 
-	textBytes := make([]uint8, textLength)
-	if err := binary.Read(r, binary.BigEndian, &textBytes); err != nil {
+	textBytes, err := readBytes(r, "cut text", int(textLength))
+	if err != nil {
 		return nil, networkError("ServerCutTextMessage.Read", "failed to read text data", err)
 	}
 
@@ -619,3 +677,16 @@ func (*ServerCutTextMessage) Read(c *ClientConn, r io.Reader) (ServerMessage, er
 
 	return &ServerCutTextMessage{clipboardText}, nil
 }
+
+// Write encodes the message for a client, as servers built with the server
+// package send it. Text is sent byte for byte, as Read returns it, so it must
+// already be Latin-1 encoded.
+func (m *ServerCutTextMessage) Write(w io.Writer) error {
+	if len(m.Text) > MaxServerClipboardLength {
+		return validationError("ServerCutTextMessage.Write", "clipboard text too long", nil)
+	}
+	if err := rfb.WriteServerCutText(w, []byte(m.Text)); err != nil {
+		return networkError("ServerCutTextMessage.Write", "failed to write cut text", err)
+	}
+	return nil
+}
@@ -0,0 +1,266 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package vnc
+
+import (
+	"context"
+	"errors"
+	"net"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Session connects to one server on demand and re-establishes the
+// connection when it has closed, such as after WithIdleDisconnect, so
+// applications holding many targets only keep connections to those in use:
+//
+//	session := vnc.NewSession(func(ctx context.Context) (net.Conn, error) {
+//		var d net.Dialer
+//		return d.DialContext(ctx, "tcp", "desktop:5900")
+//	}, vnc.WithAuth(auth), vnc.WithIdleDisconnect(5*time.Minute))
+//	defer session.Close()
+//
+//	client, err := session.Client(ctx)
+//
+// Each connection is created with the options of the session, keeping the
+// credentials of the Auth methods as with WithKeepCredentials(true). A
+// reconnection also restores the pixel format and encodings the previous
+// connection last used, so settings changed during the session survive it;
+// the client framebuffer, frame history, and statistics start over. A
+// Session is safe for concurrent use.
+type Session struct {
+	dial    func(ctx context.Context) (net.Conn, error)
+	options []ClientOption
+
+	// ctx bounds the lifetime of the connections, and ends with Close.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu         sync.Mutex
+	conn       *ClientConn
+	connects   int
+	closed     bool
+	connecting chan struct{}
+}
+
+// NewSession returns a Session that opens connections with dial and
+// configures them with options. It does not connect until Client is called.
+func NewSession(dial func(ctx context.Context) (net.Conn, error), options ...ClientOption) *Session {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Session{
+		dial:    dial,
+		options: slices.Clone(options),
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+}
+
+// Client returns the connection of the session, first connecting if there is
+// none or it has closed. ctx bounds the dial and handshake, not the
+// connection, which lasts until it closes or the session is closed. Callers
+// that arrive while another is connecting wait for its connection.
+func (s *Session) Client(ctx context.Context) (*ClientConn, error) {
+	for {
+		s.mu.Lock()
+		switch {
+		case s.closed:
+			s.mu.Unlock()
+			return nil, validationError("Session.Client", "session is closed", nil)
+		case s.conn != nil && s.conn.ctx.Err() == nil:
+			conn := s.conn
+			s.mu.Unlock()
+			return conn, nil
+		case s.connecting != nil:
+			connecting := s.connecting
+			s.mu.Unlock()
+			select {
+			case <-connecting:
+				continue
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+		connecting := make(chan struct{})
+		s.connecting = connecting
+		prev := s.conn
+		s.mu.Unlock()
+
+		conn, err := s.connect(ctx, prev)
+
+		s.mu.Lock()
+		s.connecting = nil
+		close(connecting)
+		if err == nil {
+			if s.closed {
+				s.mu.Unlock()
+				_ = conn.Close()
+				return nil, validationError("Session.Client", "session is closed", nil)
+			}
+			s.conn = conn
+			s.connects++
+		}
+		s.mu.Unlock()
+		return conn, err
+	}
+}
+
+// Connects returns the number of connections the session has established.
+func (s *Session) Connects() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.connects
+}
+
+// Close closes the connection of the session, if any, and makes later calls
+// to Client fail.
+func (s *Session) Close() error {
+	s.mu.Lock()
+	conn := s.conn
+	s.closed = true
+	s.mu.Unlock()
+
+	s.cancel()
+	if conn != nil {
+		return conn.Close()
+	}
+	return nil
+}
+
+// connect dials and establishes a connection configured like prev, if set.
+func (s *Session) connect(ctx context.Context, prev *ClientConn) (*ClientConn, error) {
+	// The server closes a connection whose credentials it rejects, so other
+	// credentials are tried on a new one.
+	attempt := &connectAttempt{}
+	for {
+		conn, err := s.connectOnce(ctx, prev, attempt.option())
+		var failure *AuthFailureError
+		if err == nil || !errors.As(err, &failure) {
+			return conn, err
+		}
+
+		switch {
+		case attempt.prompted && attempt.prompts < maxAuthPromptAttempts:
+			// Ask the prompt of WithAuthPrompt again.
+		case attempt.retry && attempt.hasAlternative(failure.auth):
+			delay := attempt.backoff << len(attempt.rejected)
+			attempt.rejected = append(attempt.rejected, failure.auth)
+			if delay > 0 {
+				timer := time.NewTimer(delay)
+				select {
+				case <-timer.C:
+				case <-ctx.Done():
+					timer.Stop()
+					return nil, ctx.Err()
+				}
+			}
+		default:
+			return nil, err
+		}
+		attempt.reason = failure.Reason
+	}
+}
+
+// connectAttempt carries the outcome of the rejected connections of
+// Session.connect into the configuration of the next one.
+type connectAttempt struct {
+	// reason is the failure reason of the last rejected connection.
+	reason string
+
+	// rejected lists the Auth methods whose credentials were rejected.
+	rejected []ClientAuth
+
+	// prompts counts the calls to the prompt of WithAuthPrompt, and prompted
+	// reports whether the last connection called it.
+	prompts  int
+	prompted bool
+
+	// retry, backoff, and auth are the AuthRetry settings and the Auth
+	// methods of the last connection.
+	retry   bool
+	backoff time.Duration
+	auth    []ClientAuth
+}
+
+// option returns an option that removes the rejected Auth methods and
+// numbers the prompts, passing them the reason of the last failure.
+func (a *connectAttempt) option() ClientOption {
+	a.prompted = false
+	return func(cfg *ClientConfig) {
+		a.retry, a.backoff = cfg.AuthRetry, cfg.AuthRetryBackoff
+		if len(a.rejected) > 0 {
+			cfg.Auth = slices.DeleteFunc(slices.Clone(cfg.Auth), func(auth ClientAuth) bool {
+				return slices.Contains(a.rejected, auth)
+			})
+		}
+		a.auth = cfg.Auth
+
+		if fn := cfg.AuthPrompt; fn != nil {
+			reason := a.reason
+			cfg.AuthPrompt = func(ctx context.Context, prompt AuthPrompt) (string, []byte, error) {
+				a.prompted = true
+				a.prompts++
+				prompt.Attempt = a.prompts
+				prompt.FailureReason = reason
+				return fn(ctx, prompt)
+			}
+		}
+	}
+}
+
+// hasAlternative reports whether the last connection had an Auth method
+// other than rejected of the same security type.
+func (a *connectAttempt) hasAlternative(rejected ClientAuth) bool {
+	if rejected == nil {
+		return false
+	}
+	return slices.ContainsFunc(a.auth, func(auth ClientAuth) bool {
+		return auth != rejected && auth.SecurityType() == rejected.SecurityType()
+	})
+}
+
+// connectOnce dials and performs the handshake of a connection, applying
+// option after those of the session.
+func (s *Session) connectOnce(ctx context.Context, prev *ClientConn, option ClientOption) (*ClientConn, error) {
+	netConn, err := s.dial(ctx)
+	if err != nil {
+		return nil, networkError("Session.Client", "failed to connect", err)
+	}
+
+	options := slices.Clone(s.options)
+	if prev != nil {
+		options = append(options, restoreSettings(prev))
+	}
+	// Reconnecting needs the credentials again.
+	options = append(options, WithKeepCredentials(true), option)
+
+	// The connection must outlive ctx, so ctx only interrupts the handshake.
+	stop := context.AfterFunc(ctx, func() { _ = netConn.Close() })
+	conn, err := ClientWithOptions(s.ctx, netConn, options...)
+	if !stop() && err == nil {
+		_ = conn.Close()
+		err = ctx.Err()
+	}
+	if err != nil {
+		_ = netConn.Close()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+	return conn, nil
+}
+
+// restoreSettings returns an option that requests the pixel format and
+// encodings prev last used.
+func restoreSettings(prev *ClientConn) ClientOption {
+	pixelFormat := prev.GetPixelFormat()
+	encodings := prev.GetEncodings()
+	return func(cfg *ClientConfig) {
+		cfg.PixelFormat = &pixelFormat
+		if len(encodings) > 0 {
+			cfg.InitialEncodings = encodings
+		}
+	}
+}
@@ -0,0 +1,124 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package vnc
+
+import (
+	"time"
+)
+
+// SharingEventKind identifies what a SharingEvent reports.
+type SharingEventKind int
+
+// Kinds of SharingEvent.
+const (
+	// SharingGranted reports that the server accepted the ClientInit and
+	// started the session. RFB has no reply that confirms the mode: most
+	// servers honor an exclusive request by disconnecting the other clients,
+	// but some are configured to treat every client as shared, and servers
+	// that refuse a client close the connection instead, failing the
+	// handshake.
+	SharingGranted SharingEventKind = iota + 1
+
+	// SharingOtherClient reports that the server applied a change made by
+	// another client, such as a desktop resize, so the session is shared.
+	// After an exclusive request it means the server did not honor it. It is
+	// sent once per connection.
+	SharingOtherClient
+
+	// SharingDisconnected reports that the server closed the connection
+	// between messages without an error. This is how servers disconnect
+	// shared clients when another client connects exclusively; a server
+	// shutting down or timing out idle clients looks the same.
+	SharingDisconnected
+)
+
+// String returns the name of the kind.
+func (k SharingEventKind) String() string {
+	switch k {
+	case SharingGranted:
+		return "granted"
+	case SharingOtherClient:
+		return "other client"
+	case SharingDisconnected:
+		return "disconnected"
+	default:
+		return "unknown"
+	}
+}
+
+// SharingEvent reports an observable outcome of the shared or exclusive
+// access requested in the ClientInit. Events are sent to the channel
+// configured with WithSharingEvents.
+type SharingEvent struct {
+	// Kind tells what happened.
+	Kind SharingEventKind
+
+	// Shared reports whether the client requested shared access, that is,
+	// whether Exclusive was false.
+	Shared bool
+
+	// Time is when the client observed the event.
+	Time time.Time
+}
+
+// WithSharingEvents sets a channel that receives a SharingEvent when the
+// session starts, when another client turns out to share it, and when the
+// server closes the connection, as it does to clients displaced by an
+// exclusive one:
+//
+//	events := make(chan vnc.SharingEvent, 4)
+//	client, err := vnc.ClientWithOptions(ctx, conn,
+//		vnc.WithExclusive(true), vnc.WithSharingEvents(events))
+//	...
+//	for ev := range events {
+//		if ev.Kind == vnc.SharingOtherClient && !ev.Shared {
+//			log.Print("server did not grant exclusive access")
+//		}
+//	}
+//
+// Events are dropped when the channel is full, so the connection never waits
+// for the application. The channel is not closed.
+func WithSharingEvents(ch chan<- SharingEvent) ClientOption {
+	return func(cfg *ClientConfig) {
+		cfg.SharingEvents = ch
+	}
+}
+
+// Shared reports whether the client requested shared access to the desktop.
+// The server may not have honored an exclusive request; see SharingEvent.
+func (c *ClientConn) Shared() bool {
+	return c.config == nil || !c.config.Exclusive
+}
+
+// sendSharingEvent logs a sharing outcome and sends it to the configured
+// channel without blocking.
+func (c *ClientConn) sendSharingEvent(kind SharingEventKind) {
+	shared := c.Shared()
+	switch {
+	case kind == SharingOtherClient && !shared:
+		c.logger.Warn("Another client is using the desktop despite the exclusive access request")
+	default:
+		c.logger.Debug("Sharing event",
+			Field{Key: "kind", Value: kind.String()},
+			Field{Key: "shared", Value: shared})
+	}
+
+	if c.config == nil || c.config.SharingEvents == nil {
+		return
+	}
+	select {
+	case c.config.SharingEvents <- SharingEvent{Kind: kind, Shared: shared, Time: time.Now()}:
+	default:
+		c.logger.Debug("Sharing event channel full, dropping event",
+			Field{Key: "kind", Value: kind.String()})
+	}
+}
+
+// observeOtherClient records evidence that another client shares the desktop,
+// sending SharingOtherClient the first time.
+func (c *ClientConn) observeOtherClient() {
+	if !c.otherClient.Swap(true) {
+		c.sendSharingEvent(SharingOtherClient)
+	}
+}
@@ -0,0 +1,77 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package vnc
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/tenthirtyam/go-vnc/rfb"
+)
+
+func TestSharing_Events(t *testing.T) {
+	s := &replayStream{}
+	s.write(replayHandshake(4, 4, "shared"))
+	s.write(uint8(0), uint8(0), uint16(1))
+	s.rect(uint16(DesktopSizeReasonOtherClient), 0, 8, 4, rfb.PseudoEncodingExtendedDesktopSize)
+	s.write(uint8(1), [3]byte{})
+	s.write(uint32(1), uint16(0), uint16(0), uint16(8), uint16(4), uint32(0))
+
+	serverConn, clientConn := net.Pipe()
+	t.Cleanup(func() {
+		serverConn.Close()
+	})
+	go func() {
+		_, _ = io.Copy(io.Discard, serverConn)
+	}()
+	go func() {
+		_, _ = serverConn.Write(s.bytes())
+		serverConn.Close()
+	}()
+
+	events := make(chan SharingEvent, 4)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := ClientWithOptions(ctx, clientConn,
+		WithAuth(&ClientAuthNone{}),
+		WithExclusive(true),
+		WithSharingEvents(events))
+	if err != nil {
+		t.Fatalf("handshake failed: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = conn.CloseAndWait()
+	})
+
+	if conn.Shared() {
+		t.Error("Shared() = true for an exclusive client")
+	}
+	for _, want := range []SharingEventKind{SharingGranted, SharingOtherClient, SharingDisconnected} {
+		select {
+		case ev := <-events:
+			if ev.Kind != want || ev.Shared || ev.Time.IsZero() {
+				t.Errorf("event = %+v, want %v for an exclusive client", ev, want)
+			}
+		case <-ctx.Done():
+			t.Fatalf("timed out waiting for %v", want)
+		}
+	}
+}
+
+func TestSharing_FullChannel(t *testing.T) {
+	events := make(chan SharingEvent)
+	c := &ClientConn{logger: &NoOpLogger{}, config: &ClientConfig{SharingEvents: events}}
+
+	c.observeOtherClient()
+	c.observeOtherClient()
+	if !c.otherClient.Load() {
+		t.Error("observeOtherClient() did not record the other client")
+	}
+	if !c.Shared() {
+		t.Error("Shared() = false without Exclusive")
+	}
+}
@@ -0,0 +1,211 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package vnc
+
+import "slices"
+
+// connState is an immutable snapshot of the connection state negotiated with
+// the server. Readers load the current snapshot without locking, which keeps
+// per-rectangle decoding free of lock traffic; writers copy it under
+// ClientConn.stateMu and publish the copy. The snapshot is the only copy the
+// library reads; the deprecated exported fields of ClientConn are written
+// alongside it for compatibility, and changes made to them are logged.
+type connState struct {
+	width       uint16
+	height      uint16
+	desktopName string
+	pixelFormat PixelFormat
+	// colorMap is shared between snapshots and replaced, never modified.
+	colorMap  *[ColorMapSize]Color
+	encodings []Encoding
+	// screens is shared between snapshots and replaced, never modified.
+	screens []Screen
+	// cursor is shared between snapshots and replaced, never modified.
+	cursor *CursorImage
+	// leds is the LED state, valid once ledsKnown is set.
+	leds      LEDState
+	ledsKnown bool
+}
+
+// emptyState is returned by loadState before any state has been recorded.
+var emptyState = &connState{colorMap: new([ColorMapSize]Color)}
+
+// loadState returns the current state snapshot. It must not be modified.
+func (c *ClientConn) loadState() *connState {
+	if s := c.state.Load(); s != nil {
+		return s
+	}
+	return emptyState
+}
+
+// updateState applies update to a copy of the current state and publishes it.
+// Writers are serialized so that concurrent updates are not lost.
+func (c *ClientConn) updateState(update func(s *connState)) {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+
+	next := *c.loadState()
+	if !c.deprecatedWarned && c.deprecatedFieldsChanged(&next) {
+		c.deprecatedWarned = true
+		c.logger.Warn("Deprecated ClientConn fields were modified; the changes are ignored, use the setter methods instead")
+	}
+	update(&next)
+	c.state.Store(&next)
+}
+
+// deprecatedFieldsChanged reports whether the deprecated exported fields no
+// longer match s, the state last written to them. Encodings are compared by
+// type. It is called with stateMu held.
+func (c *ClientConn) deprecatedFieldsChanged(s *connState) bool {
+	if c.FrameBufferWidth != s.width || c.FrameBufferHeight != s.height ||
+		c.DesktopName != s.desktopName || c.PixelFormat != s.pixelFormat ||
+		c.ColorMap != *s.colorMap || len(c.Encs) != len(s.encodings) {
+		return true
+	}
+	for i, enc := range c.Encs {
+		if enc == nil || enc.Type() != s.encodings[i].Type() {
+			return true
+		}
+	}
+	return false
+}
+
+// pixelReader returns a PixelReader for the current pixel format and color map.
+// Unlike NewPixelReader it shares the color map of the snapshot rather than
+// copying it, applies WithForcePixelEndianness, and feeds the endianness
+// probe.
+func (c *ClientConn) pixelReader() *PixelReader {
+	s := c.loadState()
+	pr := newPixelReader(s.pixelFormat, s.colorMap)
+	pr.byteOrder = c.pixelByteOrder(s.pixelFormat)
+	pr.probe = c.pixelEndiannessProbe(s.pixelFormat)
+	return pr
+}
+
+// GetFrameBufferSize returns the current framebuffer dimensions in a thread-safe manner.
+func (c *ClientConn) GetFrameBufferSize() (width, height uint16) {
+	s := c.loadState()
+	return s.width, s.height
+}
+
+// GetDesktopName returns the desktop name in a thread-safe manner.
+func (c *ClientConn) GetDesktopName() string {
+	return c.loadState().desktopName
+}
+
+// GetPixelFormat returns a copy of the current pixel format in a thread-safe manner.
+func (c *ClientConn) GetPixelFormat() PixelFormat {
+	return c.loadState().pixelFormat
+}
+
+// GetColorMap returns a copy of the color map used for indexed color modes in
+// a thread-safe manner.
+func (c *ClientConn) GetColorMap() [ColorMapSize]Color {
+	return *c.loadState().colorMap
+}
+
+// GetEncodings returns a copy of the encodings last sent with SetEncodings in
+// a thread-safe manner.
+func (c *ClientConn) GetEncodings() []Encoding {
+	return append([]Encoding(nil), c.loadState().encodings...)
+}
+
+// Screens returns the screen layout of a multi-monitor desktop as last
+// reported by the ExtendedDesktopSize pseudo-encoding, or nil if the server
+// has not reported one. A plain DesktopSize resize discards the layout.
+func (c *ClientConn) Screens() []Screen {
+	return slices.Clone(c.loadState().screens)
+}
+
+// Cursor returns a copy of the cursor shape last sent by the server with the
+// Cursor, X Cursor, or Cursor With Alpha pseudo-encoding, or nil if the
+// server has not sent one, in which case it draws the cursor into the
+// framebuffer itself.
+func (c *ClientConn) Cursor() *CursorImage {
+	if cursor := c.loadState().cursor; cursor != nil {
+		return cursor.clone()
+	}
+	return nil
+}
+
+// LEDState returns the lock key LEDs last reported by the server with the LED
+// State pseudo-encoding, and false if the server has not reported them.
+func (c *ClientConn) LEDState() (LEDState, bool) {
+	s := c.loadState()
+	return s.leds, s.ledsKnown
+}
+
+// setFrameBufferSize records new framebuffer dimensions.
+func (c *ClientConn) setFrameBufferSize(width, height uint16) {
+	c.updateState(func(s *connState) {
+		s.width, s.height = width, height
+		c.FrameBufferWidth, c.FrameBufferHeight = width, height
+	})
+}
+
+// setScreens records the screen layout.
+func (c *ClientConn) setScreens(screens []Screen) {
+	c.updateState(func(s *connState) {
+		s.screens = screens
+	})
+}
+
+// setCursor records the cursor shape.
+func (c *ClientConn) setCursor(cursor *CursorImage) {
+	c.updateState(func(s *connState) {
+		s.cursor = cursor
+	})
+}
+
+// setLEDState records the LED state.
+func (c *ClientConn) setLEDState(leds LEDState) {
+	c.updateState(func(s *connState) {
+		s.leds, s.ledsKnown = leds, true
+	})
+}
+
+// setDesktopName records the desktop name.
+func (c *ClientConn) setDesktopName(name string) {
+	c.updateState(func(s *connState) {
+		s.desktopName = name
+		c.DesktopName = name
+	})
+}
+
+// setPixelFormat records the pixel format of framebuffer updates.
+func (c *ClientConn) setPixelFormat(pf PixelFormat) {
+	c.updateState(func(s *connState) {
+		s.pixelFormat = pf
+		c.PixelFormat = pf
+	})
+}
+
+// setColorMapEntries replaces color map entries starting at first. The caller
+// validates that the entries fit in the color map.
+func (c *ClientConn) setColorMapEntries(first uint16, colors []Color) {
+	c.updateState(func(s *connState) {
+		colorMap := *s.colorMap
+		copy(colorMap[first:], colors)
+		s.colorMap = &colorMap
+		c.ColorMap = colorMap
+	})
+}
+
+// resetColorMap clears the color map.
+func (c *ClientConn) resetColorMap() {
+	c.updateState(func(s *connState) {
+		s.colorMap = new([ColorMapSize]Color)
+		c.ColorMap = *s.colorMap
+	})
+}
+
+// setEncodings records the encodings the client accepts.
+func (c *ClientConn) setEncodings(encs []Encoding) {
+	encs = append([]Encoding(nil), encs...)
+
+	c.updateState(func(s *connState) {
+		s.encodings = encs
+		c.Encs = append([]Encoding(nil), encs...)
+	})
+}
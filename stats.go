@@ -0,0 +1,214 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package vnc
+
+import (
+	"io"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/tenthirtyam/go-vnc/rfb"
+)
+
+// EncodingStats accumulates decoding statistics for a single encoding type.
+type EncodingStats struct {
+	// Rectangles is the number of rectangles decoded with the encoding.
+	Rectangles uint64
+
+	// WireBytes is the number of rectangle payload bytes read from the network,
+	// excluding the 12-byte rectangle header.
+	WireBytes uint64
+
+	// DecodedBytes is the size the rectangles would have had as Raw pixel data
+	// in the session pixel format. It is zero for pseudo-encodings.
+	DecodedBytes uint64
+
+	// DecodeTime is the total time spent decoding the rectangles, and
+	// MaxDecodeTime the longest time spent on one. Decoding reads the
+	// rectangle from the connection, so the times include waiting for data
+	// the server has not sent yet.
+	DecodeTime    time.Duration
+	MaxDecodeTime time.Duration
+}
+
+// CompressionRatio returns DecodedBytes divided by WireBytes, or 0 when either
+// is zero. A ratio of 10 means the encoding transferred a tenth of the Raw size.
+func (s EncodingStats) CompressionRatio() float64 {
+	if s.WireBytes == 0 || s.DecodedBytes == 0 {
+		return 0
+	}
+	return float64(s.DecodedBytes) / float64(s.WireBytes)
+}
+
+// AverageDecodeTime returns the mean time spent decoding a rectangle, or 0
+// if none has been decoded.
+func (s EncodingStats) AverageDecodeTime() time.Duration {
+	if s.Rectangles == 0 {
+		return 0
+	}
+	return s.DecodeTime / time.Duration(s.Rectangles) // #nosec G115 - Rectangle counts stay far below 2^63
+}
+
+// Stats is a point-in-time snapshot of connection statistics returned by
+// ClientConn.Stats.
+type Stats struct {
+	// FramebufferUpdates is the number of FramebufferUpdate messages processed.
+	FramebufferUpdates uint64
+
+	// Encodings holds per-encoding statistics keyed by encoding type.
+	Encodings map[int32]EncodingStats
+
+	// RoundTripTime and RoundTripVariation are the smoothed round-trip time
+	// and its mean deviation, as returned by ClientConn.RoundTripTime.
+	RoundTripTime      time.Duration
+	RoundTripVariation time.Duration
+
+	// SuppressedBells is the number of BellMessages dropped by BellInterval.
+	SuppressedBells uint64
+
+	// PacedRequests is the number of incremental FramebufferUpdateRequests
+	// held by MaxFrameRate, and CoalescedUpdates the number of
+	// FramebufferUpdateMessages it merged into a later delivery.
+	PacedRequests    uint64
+	CoalescedUpdates uint64
+
+	// Keepalives is the number of keepalives sent by WithKeepalive.
+	Keepalives uint64
+
+	// CopyRectMismatches is the number of CopyRect rectangles that failed
+	// the checks enabled by VerifyCopyRect.
+	CopyRectMismatches uint64
+
+	// Annotations holds the most recent annotations added with
+	// ClientConn.Annotate, oldest first.
+	Annotations []Annotation
+
+	// Delivery reports the delivery of server messages to the channel
+	// configured with WithServerMessageChannel.
+	Delivery DeliveryStats
+
+	// StateLock, PumpLock, and StatsLock report contention on the locks that
+	// serialize state updates, server message processing, and statistics.
+	// Reading connection state never takes a lock.
+	StateLock LockStats
+	PumpLock  LockStats
+	StatsLock LockStats
+}
+
+// CompressionRatio returns the combined compression ratio of all pixel
+// encodings, or 0 if no pixel data has been received.
+func (s Stats) CompressionRatio() float64 {
+	var total EncodingStats
+	for encType, enc := range s.Encodings {
+		// TightPNG is the one pixel encoding with a negative number.
+		if (encType < 0 && encType != rfb.EncodingTightPNG) || isVMwarePseudoEncoding(encType) {
+			continue
+		}
+		total.WireBytes += enc.WireBytes
+		total.DecodedBytes += enc.DecodedBytes
+	}
+	return total.CompressionRatio()
+}
+
+// connStats holds the live statistics of a connection.
+type connStats struct {
+	mu                 contentionMutex
+	framebufferUpdates uint64
+	encodings          map[int32]EncodingStats
+	latency            latencyEstimator
+	suppressedBells    uint64
+	pacedRequests      uint64
+	coalescedUpdates   uint64
+	keepalives         uint64
+	copyRectMismatches uint64
+	annotations        []Annotation
+	delivery           DeliveryStats
+}
+
+// Stats returns a snapshot of the connection statistics. Accounting is
+// transparent to encodings: wire bytes are measured while each rectangle is
+// decoded, so compressed encodings report their efficiency without any
+// encoding-specific support.
+func (c *ClientConn) Stats() Stats {
+	c.stats.mu.Lock()
+	defer c.stats.mu.Unlock()
+
+	return Stats{
+		FramebufferUpdates: c.stats.framebufferUpdates,
+		Encodings:          c.encodingStats(),
+		RoundTripTime:      c.stats.latency.srtt,
+		RoundTripVariation: c.stats.latency.rttvar,
+		SuppressedBells:    c.stats.suppressedBells,
+		PacedRequests:      c.stats.pacedRequests,
+		CoalescedUpdates:   c.stats.coalescedUpdates,
+		Keepalives:         c.stats.keepalives,
+		CopyRectMismatches: c.stats.copyRectMismatches,
+		Annotations:        slices.Clone(c.stats.annotations),
+		Delivery:           c.deliveryStats(),
+		StateLock:          c.stateMu.stats(),
+		PumpLock:           c.pumpMu.stats(),
+		StatsLock:          c.stats.mu.stats(),
+	}
+}
+
+// EncodingStats returns a snapshot of the statistics of each encoding the
+// server has sent rectangles in, keyed by encoding type, showing which
+// encodings the server actually uses and what they cost to decode:
+//
+//	for encType, s := range client.EncodingStats() {
+//		log.Printf("encoding %d: %d rectangles, %d bytes, %v per rectangle",
+//			encType, s.Rectangles, s.WireBytes, s.AverageDecodeTime())
+//	}
+func (c *ClientConn) EncodingStats() map[int32]EncodingStats {
+	c.stats.mu.Lock()
+	defer c.stats.mu.Unlock()
+	return c.encodingStats()
+}
+
+// encodingStats returns a copy of the per-encoding statistics. The caller
+// holds c.stats.mu.
+func (c *ClientConn) encodingStats() map[int32]EncodingStats {
+	encodings := make(map[int32]EncodingStats, len(c.stats.encodings))
+	maps.Copy(encodings, c.stats.encodings)
+	return encodings
+}
+
+// recordFramebufferUpdate counts a processed FramebufferUpdate message.
+func (c *ClientConn) recordFramebufferUpdate() {
+	c.stats.mu.Lock()
+	c.stats.framebufferUpdates++
+	c.stats.mu.Unlock()
+}
+
+// recordRectangle adds a decoded rectangle to the statistics of its encoding.
+func (c *ClientConn) recordRectangle(encodingType int32, wireBytes, decodedBytes uint64, decodeTime time.Duration) {
+	c.stats.mu.Lock()
+	defer c.stats.mu.Unlock()
+
+	if c.stats.encodings == nil {
+		c.stats.encodings = make(map[int32]EncodingStats)
+	}
+
+	enc := c.stats.encodings[encodingType]
+	enc.Rectangles++
+	enc.WireBytes += wireBytes
+	enc.DecodedBytes += decodedBytes
+	enc.DecodeTime += decodeTime
+	enc.MaxDecodeTime = max(enc.MaxDecodeTime, decodeTime)
+	c.stats.encodings[encodingType] = enc
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n uint64
+}
+
+// Read reads from the underlying reader and counts the bytes returned.
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += uint64(n) // #nosec G115 - n is never negative
+	return n, err
+}
@@ -0,0 +1,177 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package vnc
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+)
+
+func TestStats_CompressionRatio(t *testing.T) {
+	tests := []struct {
+		name  string
+		stats EncodingStats
+		want  float64
+	}{
+		{name: "Empty", stats: EncodingStats{}, want: 0},
+		{name: "Raw", stats: EncodingStats{WireBytes: 100, DecodedBytes: 100}, want: 1},
+		{name: "Compressed", stats: EncodingStats{WireBytes: 25, DecodedBytes: 100}, want: 4},
+		{name: "Pseudo-encoding", stats: EncodingStats{WireBytes: 64}, want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.stats.CompressionRatio(); got != tt.want {
+				t.Errorf("CompressionRatio() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStats_AggregateExcludesPseudoEncodings(t *testing.T) {
+	stats := Stats{Encodings: map[int32]EncodingStats{
+		0:    {WireBytes: 100, DecodedBytes: 100},
+		5:    {WireBytes: 50, DecodedBytes: 500},
+		-239: {WireBytes: 1000},
+	}}
+
+	if got := stats.CompressionRatio(); got != 4 {
+		t.Errorf("CompressionRatio() = %v, want 4", got)
+	}
+}
+
+func TestStats_SnapshotIsolation(t *testing.T) {
+	c := &ClientConn{logger: &NoOpLogger{}}
+	c.recordRectangle(0, 10, 10, time.Millisecond)
+
+	snapshot := c.Stats()
+	c.recordRectangle(0, 10, 10, time.Millisecond)
+
+	if got := snapshot.Encodings[0].Rectangles; got != 1 {
+		t.Errorf("snapshot changed after recording: Rectangles = %d", got)
+	}
+	if got := c.Stats().Encodings[0].Rectangles; got != 2 {
+		t.Errorf("Rectangles = %d, want 2", got)
+	}
+}
+
+func TestStats_EncodingStats(t *testing.T) {
+	c := &ClientConn{logger: &NoOpLogger{}}
+	if got := c.EncodingStats(); got == nil || len(got) != 0 {
+		t.Errorf("EncodingStats() = %v, want an empty map", got)
+	}
+
+	c.recordRectangle(16, 100, 400, time.Millisecond)
+	c.recordRectangle(16, 50, 400, 3*time.Millisecond)
+	c.recordRectangle(0, 400, 400, 0)
+
+	stats := c.EncodingStats()
+	stats[0] = EncodingStats{}
+	zrle := stats[16]
+	if zrle.Rectangles != 2 || zrle.WireBytes != 150 || zrle.DecodeTime != 4*time.Millisecond {
+		t.Errorf("EncodingStats()[16] = %+v", zrle)
+	}
+	if zrle.MaxDecodeTime != 3*time.Millisecond || zrle.AverageDecodeTime() != 2*time.Millisecond {
+		t.Errorf("MaxDecodeTime = %v and AverageDecodeTime() = %v, want 3ms and 2ms",
+			zrle.MaxDecodeTime, zrle.AverageDecodeTime())
+	}
+	if got := c.EncodingStats()[0].Rectangles; got != 1 {
+		t.Errorf("Rectangles = %d after modifying a snapshot, want 1", got)
+	}
+	if got := (EncodingStats{}).AverageDecodeTime(); got != 0 {
+		t.Errorf("AverageDecodeTime() without rectangles = %v, want 0", got)
+	}
+}
+
+func TestStats_LockContention(t *testing.T) {
+	c := &ClientConn{logger: &NoOpLogger{}}
+	c.setDesktopName("desk")
+
+	c.stateMu.Lock()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.setDesktopName("other")
+	}()
+	for c.stateMu.acquisitions.Load() < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+	c.stateMu.Unlock()
+	<-done
+
+	got := c.Stats().StateLock
+	if got.Acquisitions != 3 || got.Contended != 1 || got.WaitTime <= 0 {
+		t.Errorf("StateLock = %+v, want 3 acquisitions with 1 contended", got)
+	}
+	if rate := got.ContentionRate(); rate < 0.3 || rate > 0.4 {
+		t.Errorf("ContentionRate() = %v, want 1/3", rate)
+	}
+	if c.GetDesktopName() != "other" {
+		t.Errorf("GetDesktopName() = %q, want other", c.GetDesktopName())
+	}
+}
+
+func TestStats_CountingReader(t *testing.T) {
+	cr := &countingReader{r: bytes.NewReader(make([]byte, 10))}
+	buf := make([]byte, 4)
+	for {
+		if _, err := cr.Read(buf); err != nil {
+			break
+		}
+	}
+	if cr.n != 10 {
+		t.Errorf("counted %d bytes, want 10", cr.n)
+	}
+}
+
+func TestStats_Delivery(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	msgCh := make(chan ServerMessage, 1)
+	c := &ClientConn{
+		logger: &NoOpLogger{},
+		config: &ClientConfig{ServerMessageCh: msgCh},
+		ctx:    ctx,
+	}
+
+	if !c.deliverMessage(new(BellMessage)) {
+		t.Fatal("deliverMessage() = false with space in the channel")
+	}
+
+	done := make(chan bool)
+	go func() {
+		done <- c.deliverMessage(new(BellMessage))
+	}()
+	time.Sleep(20 * time.Millisecond)
+	<-msgCh
+	if !<-done {
+		t.Fatal("deliverMessage() = false after the channel was drained")
+	}
+
+	go func() {
+		done <- c.deliverMessage(new(BellMessage))
+	}()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	if <-done {
+		t.Fatal("deliverMessage() = true after the connection closed")
+	}
+
+	got := c.Stats().Delivery
+	if got.Delivered != 2 || got.Dropped != 1 || got.Blocked != 1 {
+		t.Errorf("Delivery = %+v, want 2 delivered, 1 dropped, 1 blocked", got)
+	}
+	if got.QueueDepth != 1 || got.QueueCapacity != 1 || got.MaxQueueDepth != 1 {
+		t.Errorf("Delivery queue = %d/%d (max %d), want 1/1 (max 1)",
+			got.QueueDepth, got.QueueCapacity, got.MaxQueueDepth)
+	}
+	if got.BlockedTime <= 0 || got.MaxLatency != got.BlockedTime {
+		t.Errorf("Delivery latency = %v (max %v), want the one blocked delivery", got.BlockedTime, got.MaxLatency)
+	}
+	if rate := got.BlockRate(); rate != 0.5 {
+		t.Errorf("BlockRate() = %v, want 0.5", rate)
+	}
+}
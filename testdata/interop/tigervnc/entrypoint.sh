@@ -0,0 +1,17 @@
+#!/bin/sh
+# SPDX-License-Identifier: MIT
+# SPDX-FileCopyrightText: Ryan Johnson
+
+set -e
+
+mkdir -p /root/.vnc
+echo "${VNC_PASSWORD:-secret}" | vncpasswd -f > /root/.vnc/passwd
+chmod 600 /root/.vnc/passwd
+
+Xtigervnc :1 -rfbport 5900 -geometry 640x480 -depth 24 \
+    -SecurityTypes VncAuth -PasswordFile /root/.vnc/passwd -AlwaysShared &
+
+until xsetroot -solid '#336699' 2>/dev/null; do sleep 0.1; done
+vncconfig -nowin &
+
+wait
@@ -0,0 +1,12 @@
+#!/bin/sh
+# SPDX-License-Identifier: MIT
+# SPDX-FileCopyrightText: Ryan Johnson
+
+set -e
+
+Xvfb :1 -screen 0 640x480x24 &
+
+until xsetroot -solid '#336699' 2>/dev/null; do sleep 0.1; done
+
+exec x11vnc -display :1 -rfbport 5900 -passwd "${VNC_PASSWORD:-secret}" \
+    -forever -shared -noxdamage -quiet
@@ -0,0 +1,122 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package vnc
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"time"
+)
+
+// withReadDeadline runs fn, which reads from the connection, bounded by ctx
+// and the configured ReadTimeout.
+func (c *ClientConn) withReadDeadline(ctx context.Context, fn func() error) error {
+	var timeout time.Duration
+	if c.config != nil {
+		timeout = c.config.ReadTimeout
+	}
+	return c.withDeadline(ctx, timeout, c.c.SetReadDeadline, fn)
+}
+
+// withWriteDeadline runs fn, which writes to the connection, bounded by ctx
+// and the configured WriteTimeout. Writes are serialized, as they share the
+// write deadline, and a write cut short by ctx closes the connection because
+// it may leave a partial message on the wire.
+func (c *ClientConn) withWriteDeadline(ctx context.Context, fn func() error) error {
+	var timeout time.Duration
+	if c.config != nil {
+		timeout = c.config.WriteTimeout
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if ctx.Value(keepaliveKey{}) == nil {
+		c.lastActivity.Store(time.Now().UnixNano())
+	}
+	err := c.withDeadline(ctx, timeout, c.c.SetWriteDeadline, fn)
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		_ = c.Close()
+	}
+	return err
+}
+
+// withDeadline runs fn on the calling goroutine with the connection deadline
+// set by setDeadline bounded by ctx's deadline and timeout, if positive.
+// Cancelling ctx moves the deadline into the past, which interrupts fn, so
+// I/O is cancellable without a goroutine per call. Expired deadlines are
+// reported as ctx.Err() or context.DeadlineExceeded.
+func (c *ClientConn) withDeadline(ctx context.Context, timeout time.Duration, setDeadline func(time.Time) error, fn func() error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	c.closeMu.Lock()
+	closing := c.closing
+	c.closeMu.Unlock()
+	if closing {
+		return net.ErrClosed
+	}
+
+	deadline, bounded := ctx.Deadline()
+	if timeout > 0 {
+		if d := time.Now().Add(timeout); !bounded || d.Before(deadline) {
+			deadline, bounded = d, true
+		}
+	}
+	if err := setDeadline(deadline); err != nil {
+		return err
+	}
+
+	interrupted := make(chan struct{})
+	stop := context.AfterFunc(ctx, func() {
+		_ = setDeadline(time.Unix(1, 0))
+		close(interrupted)
+	})
+	err := fn()
+	if !stop() {
+		// Wait for the interruption so it cannot outlive the reset below.
+		<-interrupted
+	}
+	_ = setDeadline(time.Time{})
+
+	if err == nil {
+		return nil
+	}
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	if bounded && errors.Is(err, os.ErrDeadlineExceeded) {
+		return context.DeadlineExceeded
+	}
+	return err
+}
+
+// messageReader returns the reader for the body of a server message whose
+// type byte has already arrived. With a ReadTimeout configured each read gets
+// its own deadline; the returned function clears it again so the idle wait for
+// the next message is never timed out.
+func (c *ClientConn) messageReader() (io.Reader, func()) {
+	if c.config == nil || c.config.ReadTimeout <= 0 {
+		return c.c, func() {}
+	}
+	r := &timeoutReader{conn: c.c, timeout: c.config.ReadTimeout}
+	return r, func() { _ = c.c.SetReadDeadline(time.Time{}) }
+}
+
+// timeoutReader reads from a connection, extending the read deadline before
+// every read so that slow but steady transfers are not cut off.
+type timeoutReader struct {
+	conn    net.Conn
+	timeout time.Duration
+}
+
+// Read implements io.Reader.
+func (r *timeoutReader) Read(p []byte) (int, error) {
+	if err := r.conn.SetReadDeadline(time.Now().Add(r.timeout)); err != nil {
+		return 0, err
+	}
+	return r.conn.Read(p)
+}
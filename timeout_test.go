@@ -0,0 +1,304 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package vnc
+
+// The benchmarks in this file compare the deadline-based I/O used by the
+// client's context-aware helpers with the goroutine-per-call design they
+// replaced, in which every read or write ran on a new goroutine while the
+// caller waited for it or for ctx. Run them with:
+//
+//	go test -run '^$' -bench BenchmarkConnIO -benchmem -count 10 . | tee io.txt
+//	benchstat io.txt
+//
+// Each benchmark reports ops/s, allocations, and, on Go releases providing
+// the /sched/goroutines-created metric, goroutines created per operation.
+// Over net.Pipe both designs reach similar throughput, and the deadline design
+// costs one more allocation for registering ctx's cancellation. In exchange it
+// creates no goroutine per operation, where the goroutine design creates one
+// and leaves a blocked read behind when ctx is cancelled.
+// TestConnIO_NoGoroutinePerCall guards against a return to per-call
+// goroutines.
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"runtime/metrics"
+	"testing"
+	"time"
+
+	"github.com/tenthirtyam/go-vnc/rfb"
+)
+
+// goroutinesCreated returns the number of goroutines created by the process,
+// or false if the runtime does not provide the metric.
+func goroutinesCreated() (uint64, bool) {
+	sample := []metrics.Sample{{Name: "/sched/goroutines-created:goroutines"}}
+	metrics.Read(sample)
+	if sample[0].Value.Kind() != metrics.KindUint64 {
+		return 0, false
+	}
+	return sample[0].Value.Uint64(), true
+}
+
+// newIOConn returns a client connection over net.Pipe for exercising the I/O
+// helpers directly, with the server side of the pipe.
+func newIOConn(tb testing.TB) (*ClientConn, net.Conn) {
+	tb.Helper()
+	serverConn, clientConn := net.Pipe()
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &ClientConn{c: clientConn, ctx: ctx, cancel: cancel, logger: &NoOpLogger{}}
+	tb.Cleanup(func() {
+		_ = c.CloseAndWait()
+		_ = serverConn.Close()
+	})
+	return c, serverConn
+}
+
+// goroutineWrite is the goroutine-per-call write the deadline-based
+// writeWithContext replaced, kept as the baseline of the benchmarks.
+func goroutineWrite(c *ClientConn, ctx context.Context, data []byte) error {
+	done := make(chan error, 1)
+	if !c.goTracked(func() {
+		_, err := c.c.Write(data)
+		done <- err
+	}) {
+		return net.ErrClosed
+	}
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		_ = c.Close()
+		return ctx.Err()
+	}
+}
+
+// goroutineRead is the goroutine-per-call read the deadline-based
+// readWithContext replaced, kept as the baseline of the benchmarks.
+func goroutineRead(c *ClientConn, ctx context.Context, buf []byte) error {
+	done := make(chan error, 1)
+	if !c.goTracked(func() {
+		_, err := io.ReadFull(c.c, buf)
+		done <- err
+	}) {
+		return net.ErrClosed
+	}
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// benchmarkIO runs op b.N times and reports its throughput and goroutines.
+func benchmarkIO(b *testing.B, op func() error) {
+	b.ReportAllocs()
+	created, ok := goroutinesCreated()
+	b.ResetTimer()
+	for range b.N {
+		if err := op(); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.StopTimer()
+
+	b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "ops/s")
+	if after, ok2 := goroutinesCreated(); ok && ok2 {
+		b.ReportMetric(float64(after-created)/float64(b.N), "goroutines/op")
+	}
+}
+
+func BenchmarkConnIO_Write(b *testing.B) {
+	// The size of a KeyEvent message.
+	msg := make([]byte, 8)
+	for _, bb := range []struct {
+		name  string
+		write func(*ClientConn, []byte) error
+	}{
+		{"Goroutine", func(c *ClientConn, data []byte) error { return goroutineWrite(c, c.ctx, data) }},
+		{"Deadline", func(c *ClientConn, data []byte) error { return c.writeWithContext(c.ctx, data) }},
+	} {
+		b.Run(bb.name, func(b *testing.B) {
+			c, serverConn := newIOConn(b)
+			go func() { _, _ = io.Copy(io.Discard, serverConn) }()
+			benchmarkIO(b, func() error { return bb.write(c, msg) })
+		})
+	}
+}
+
+func BenchmarkConnIO_Read(b *testing.B) {
+	for _, bb := range []struct {
+		name string
+		read func(*ClientConn, []byte) error
+	}{
+		{"Goroutine", func(c *ClientConn, buf []byte) error { return goroutineRead(c, c.ctx, buf) }},
+		{"Deadline", func(c *ClientConn, buf []byte) error { return c.readWithContext(c.ctx, buf) }},
+	} {
+		b.Run(bb.name, func(b *testing.B) {
+			c, serverConn := newIOConn(b)
+			go func() {
+				chunk := make([]byte, 4096)
+				for {
+					if _, err := serverConn.Write(chunk); err != nil {
+						return
+					}
+				}
+			}()
+			// The size of a FramebufferUpdate rectangle header.
+			buf := make([]byte, 12)
+			benchmarkIO(b, func() error { return bb.read(c, buf) })
+		})
+	}
+}
+
+func TestConnIO_NoGoroutinePerCall(t *testing.T) {
+	if _, ok := goroutinesCreated(); !ok {
+		t.Skip("runtime does not report goroutines created")
+	}
+
+	c, serverConn := newIOConn(t)
+	go func() { _, _ = io.Copy(io.Discard, serverConn) }()
+	// Let the copy goroutine start before counting.
+	if err := c.writeWithContext(c.ctx, []byte{0}); err != nil {
+		t.Fatal(err)
+	}
+	before, _ := goroutinesCreated()
+
+	const calls = 100
+	for range calls {
+		if err := c.writeWithContext(c.ctx, make([]byte, 8)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	after, _ := goroutinesCreated()
+	// Allow for unrelated runtime goroutines, but not one per call.
+	if created := after - before; created >= calls/2 {
+		t.Errorf("%d writes created %d goroutines, want none per call", calls, created)
+	}
+}
+
+func TestConnIO_Cancellation(t *testing.T) {
+	t.Run("Read", func(t *testing.T) {
+		c, _ := newIOConn(t)
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(20*time.Millisecond, cancel)
+
+		if err := c.readWithContext(ctx, make([]byte, 1)); !errors.Is(err, context.Canceled) {
+			t.Fatalf("readWithContext = %v, want context.Canceled", err)
+		}
+		// The deadline is cleared again, so the next read is not cut short.
+		ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		if err := c.readWithContext(ctx, make([]byte, 1)); !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("second readWithContext = %v, want context.DeadlineExceeded", err)
+		}
+	})
+
+	t.Run("ReadTimeout", func(t *testing.T) {
+		c, serverConn := newIOConn(t)
+		c.config = &ClientConfig{ReadTimeout: 20 * time.Millisecond}
+		if err := c.readWithContext(context.Background(), make([]byte, 1)); !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("readWithContext = %v, want context.DeadlineExceeded", err)
+		}
+
+		go func() { _, _ = serverConn.Write([]byte{7}) }()
+		buf := make([]byte, 1)
+		if err := c.readWithContext(context.Background(), buf); err != nil || buf[0] != 7 {
+			t.Fatalf("readWithContext after a timeout = %v, %v", buf, err)
+		}
+	})
+
+	t.Run("Write", func(t *testing.T) {
+		c, _ := newIOConn(t)
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(20*time.Millisecond, cancel)
+
+		if err := c.writeWithContext(ctx, []byte{1}); !errors.Is(err, context.Canceled) {
+			t.Fatalf("writeWithContext = %v, want context.Canceled", err)
+		}
+		// A cancelled write may leave a partial message, so it closes the
+		// connection.
+		if err := c.writeWithContext(context.Background(), []byte{1}); err == nil {
+			t.Fatal("writeWithContext succeeded after a cancelled write")
+		}
+	})
+}
+
+func TestAuthTimeout(t *testing.T) {
+	// serve runs the handshake of a server offering securityType, calling
+	// stall after the client selects it and before the ServerInit.
+	serve := func(conn net.Conn, securityType uint8, stall func(phase string)) {
+		defer func() { _ = conn.Close() }()
+		if rfb.WriteProtocolVersion(conn, 3, 8) != nil {
+			return
+		}
+		if _, _, err := rfb.ReadProtocolVersion(conn); err != nil {
+			return
+		}
+		if rfb.WriteSecurityTypes(conn, []uint8{securityType}) != nil {
+			return
+		}
+		if _, err := rfb.ReadSecurityType(conn); err != nil {
+			return
+		}
+		stall("auth")
+		if rfb.WriteSecurityResult(conn, nil) != nil {
+			return
+		}
+		if _, err := rfb.ReadClientInit(conn); err != nil {
+			return
+		}
+		stall("init")
+		_ = rfb.WriteServerInit(conn, rfb.ServerInit{
+			Width:       4,
+			Height:      4,
+			PixelFormat: rfb.PixelFormat{BPP: 8, Depth: 8, TrueColor: true, RedMax: 7, GreenMax: 7, BlueMax: 3},
+			Name:        "auth-timeout",
+		})
+		_, _ = io.Copy(io.Discard, conn)
+	}
+
+	t.Run("stalled authentication", func(t *testing.T) {
+		serverConn, clientConn := net.Pipe()
+		go serve(serverConn, rfb.SecurityVNCAuth, func(phase string) {
+			if phase == "auth" {
+				// Never send the challenge.
+				_, _ = io.Copy(io.Discard, serverConn)
+			}
+		})
+
+		start := time.Now()
+		_, err := ClientWithOptions(context.Background(), clientConn,
+			WithAuth(NewPasswordAuth("secret")),
+			WithConnectTimeout(5*time.Second),
+			WithAuthTimeout(50*time.Millisecond))
+		if !IsVNCError(err, ErrTimeout) {
+			t.Fatalf("ClientWithOptions() error = %v, want timeout error", err)
+		}
+		if elapsed := time.Since(start); elapsed > 2*time.Second {
+			t.Errorf("handshake took %v, want the auth timeout", elapsed)
+		}
+	})
+
+	t.Run("slow initialization", func(t *testing.T) {
+		serverConn, clientConn := net.Pipe()
+		go serve(serverConn, rfb.SecurityNone, func(phase string) {
+			if phase == "init" {
+				time.Sleep(100 * time.Millisecond)
+			}
+		})
+
+		conn, err := ClientWithOptions(context.Background(), clientConn,
+			WithAuth(&ClientAuthNone{}),
+			WithConnectTimeout(5*time.Second),
+			WithAuthTimeout(20*time.Millisecond))
+		if err != nil {
+			t.Fatalf("ClientWithOptions() error = %v, want the auth timeout not to cover initialization", err)
+		}
+		_ = conn.Close()
+	})
+}
@@ -0,0 +1,134 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package vnc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/tenthirtyam/go-vnc/rfb"
+)
+
+// TLSAuth implements the legacy TLS security type (18) of older vino and QEMU
+// servers. The client wraps the connection in anonymous TLS, after which the
+// server offers its security types again and the client authenticates with
+// one of Auth over the encrypted connection. The rest of the session runs
+// over the encrypted connection.
+//
+// Anonymous TLS uses anonymous Diffie-Hellman cipher suites, which crypto/tls
+// does not implement, so the handshake is performed by AnonymousTLS.
+type TLSAuth struct {
+	// Auth lists the authentication methods used inside TLS, in order of
+	// preference. Empty uses ClientAuthNone.
+	Auth []ClientAuth
+
+	// AnonymousTLS wraps conn in anonymous TLS, such as with a binding to a
+	// TLS library supporting anonymous Diffie-Hellman cipher suites.
+	AnonymousTLS func(ctx context.Context, conn net.Conn) (net.Conn, error)
+
+	logger Logger
+}
+
+// SecurityType returns the security type identifier for TLS.
+func (t *TLSAuth) SecurityType() uint8 {
+	return rfb.SecurityTLS
+}
+
+// String returns a human-readable description of the authentication method.
+func (t *TLSAuth) String() string {
+	return "TLS"
+}
+
+// SetLogger sets the logger for the authentication method.
+func (t *TLSAuth) SetLogger(logger Logger) {
+	t.logger = logger
+}
+
+// ClearPassword securely clears the passwords of the inner authentication
+// methods from memory.
+func (t *TLSAuth) ClearPassword() {
+	for _, auth := range t.Auth {
+		if clearer, ok := auth.(interface{ ClearPassword() }); ok {
+			clearer.ClearPassword()
+		}
+	}
+}
+
+// Handshake establishes anonymous TLS, negotiates the inner security type,
+// and authenticates over the encrypted connection.
+func (t *TLSAuth) Handshake(ctx context.Context, conn net.Conn) error {
+	select {
+	case <-ctx.Done():
+		return timeoutError("TLSAuth.Handshake", "authentication cancelled", ctx.Err())
+	default:
+	}
+
+	logger := t.logger
+	if logger == nil {
+		logger = &NoOpLogger{}
+	}
+
+	if t.AnonymousTLS == nil {
+		return unsupportedError("TLSAuth.Handshake", "anonymous TLS is not configured", nil)
+	}
+	secured, err := t.AnonymousTLS(ctx, conn)
+	if err != nil {
+		return authenticationError("TLSAuth.Handshake", "anonymous TLS handshake failed", err)
+	}
+	setSecuredConn(ctx, secured)
+	logger.Debug("TLS established")
+
+	securityTypes, err := rfb.ReadSecurityTypes(secured)
+	if err != nil {
+		var failure *rfb.FailureError
+		if errors.As(err, &failure) {
+			return authenticationError("TLSAuth.Handshake",
+				fmt.Sprintf("no security types available: %s", failure.Reason), nil)
+		}
+		return networkError("TLSAuth.Handshake", "failed to read security types", err)
+	}
+
+	inner, ok := t.chooseAuth(securityTypes, minSecurity(ctx))
+	if !ok {
+		return authenticationError("TLSAuth.Handshake",
+			fmt.Sprintf("no suitable auth schemes found. server supported: %v", securityTypes), nil)
+	}
+	logger.Debug("Selected authentication method inside TLS",
+		Field{Key: "types", Value: securityTypes},
+		Field{Key: "method", Value: inner.String()})
+
+	if err := rfb.WriteSecurityType(secured, inner.SecurityType()); err != nil {
+		return networkError("TLSAuth.Handshake", "failed to send selected security type", err)
+	}
+
+	if authWithLogger, ok := inner.(interface{ SetLogger(Logger) }); ok {
+		authWithLogger.SetLogger(logger)
+	}
+	return inner.Handshake(ctx, secured)
+}
+
+// chooseAuth returns the first inner authentication method whose security
+// type the server offers. Anonymous TLS does not authenticate the server, so
+// it meets RequireAuthenticated at most, with an inner method that
+// authenticates the client.
+func (t *TLSAuth) chooseAuth(offered []uint8, level SecurityLevel) (ClientAuth, bool) {
+	if level > RequireAuthenticated {
+		return nil, false
+	}
+	auths := t.Auth
+	if len(auths) == 0 {
+		auths = []ClientAuth{new(ClientAuthNone)}
+	}
+	for _, auth := range auths {
+		for _, securityType := range offered {
+			if auth.SecurityType() == securityType &&
+				(level == AllowNone || authSecurityLevel(auth, securityType) >= RequireAuthenticated) {
+				return auth, true
+			}
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,91 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package vnc
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/tenthirtyam/go-vnc/rfb"
+)
+
+// wrappedConn stands in for a connection wrapped in anonymous TLS.
+type wrappedConn struct {
+	net.Conn
+}
+
+func TestTLSAuth_InnerNegotiation(t *testing.T) {
+	server, client := net.Pipe()
+	defer func() { _ = client.Close() }()
+
+	done := make(chan uint8, 1)
+	go func() {
+		defer func() { _ = server.Close() }()
+		defer close(done)
+		if err := rfb.WriteSecurityTypes(server, []uint8{rfb.SecurityVNCAuth, rfb.SecurityNone}); err != nil {
+			return
+		}
+		selected, err := rfb.ReadSecurityType(server)
+		if err != nil {
+			return
+		}
+		done <- selected
+	}()
+
+	auth := &TLSAuth{
+		Auth: []ClientAuth{&ClientAuthNone{}},
+		AnonymousTLS: func(ctx context.Context, conn net.Conn) (net.Conn, error) {
+			return &wrappedConn{conn}, nil
+		},
+	}
+	var secured net.Conn
+	ctx := context.WithValue(context.Background(), securedConnKey{}, &secured)
+	if err := auth.Handshake(ctx, client); err != nil {
+		t.Fatalf("Handshake() error = %v", err)
+	}
+	if _, ok := secured.(*wrappedConn); !ok {
+		t.Errorf("secured connection = %T, want *wrappedConn", secured)
+	}
+	if selected := <-done; selected != rfb.SecurityNone {
+		t.Errorf("selected inner security type = %d, want %d", selected, rfb.SecurityNone)
+	}
+}
+
+func TestTLSAuth_Failures(t *testing.T) {
+	identity := func(ctx context.Context, conn net.Conn) (net.Conn, error) { return conn, nil }
+
+	t.Run("without anonymous TLS", func(t *testing.T) {
+		err := (&TLSAuth{}).Handshake(context.Background(), nil)
+		if !IsVNCError(err, ErrUnsupported) {
+			t.Errorf("Handshake() error = %v, want unsupported error", err)
+		}
+	})
+
+	t.Run("server failure", func(t *testing.T) {
+		server, client := net.Pipe()
+		defer func() { _ = client.Close() }()
+		go func() {
+			defer func() { _ = server.Close() }()
+			_ = rfb.WriteSecurityFailure(server, "TLS required")
+		}()
+		err := (&TLSAuth{AnonymousTLS: identity}).Handshake(context.Background(), client)
+		if !IsVNCError(err, ErrAuthentication) {
+			t.Errorf("Handshake() error = %v, want authentication error", err)
+		}
+	})
+
+	t.Run("no mutual method", func(t *testing.T) {
+		server, client := net.Pipe()
+		defer func() { _ = client.Close() }()
+		go func() {
+			defer func() { _ = server.Close() }()
+			_ = rfb.WriteSecurityTypes(server, []uint8{rfb.SecurityVNCAuth})
+		}()
+		err := (&TLSAuth{AnonymousTLS: identity}).Handshake(context.Background(), client)
+		if !IsVNCError(err, ErrAuthentication) {
+			t.Errorf("Handshake() error = %v, want authentication error", err)
+		}
+	})
+}
@@ -196,6 +196,9 @@ func (iv *InputValidator) ValidateEncodingType(encodingType int32) error {
 		case 0, 1, 2, 4, 5, 15, 16:
 			return nil
 		default:
+			if isVMwarePseudoEncoding(encodingType) {
+				return nil
+			}
 			if encodingType > 1000000 {
 				return validationError("InputValidator.ValidateEncodingType",
 					fmt.Sprintf("encoding type too large: %d", encodingType), nil)
@@ -205,7 +208,7 @@ func (iv *InputValidator) ValidateEncodingType(encodingType int32) error {
 	}
 
 	switch encodingType {
-	case -1, -2, -223, -224, -232, -239, -240, -247, -314:
+	case -1, -2, -223, -224, -232, -239, -240, -247, -258, -261, -307, -308, -312, -313, -314, -316:
 		return nil
 	default:
 		if encodingType < -1000000 {
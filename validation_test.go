@@ -443,6 +443,11 @@ func TestValidation_EncodingType(t *testing.T) {
 			encodingType: 1000001,
 			wantErr:      true,
 		},
+		{
+			name:         "VMware pseudo-encoding",
+			encodingType: 0x574d5664,
+			wantErr:      false,
+		},
 		{
 			name:         "pseudo-encoding too negative",
 			encodingType: -1000001,
@@ -0,0 +1,312 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package vnc
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"slices"
+
+	"github.com/tenthirtyam/go-vnc/rfb"
+)
+
+// defaultVeNCryptSubTypes is the order in which VeNCryptAuth prefers the
+// sub-types when SubTypes is empty: verified TLS before anonymous TLS, and
+// never the unencrypted Plain sub-type.
+var defaultVeNCryptSubTypes = []uint32{
+	rfb.VeNCryptX509Plain,
+	rfb.VeNCryptX509Vnc,
+	rfb.VeNCryptX509None,
+	rfb.VeNCryptTLSPlain,
+	rfb.VeNCryptTLSVnc,
+	rfb.VeNCryptTLSNone,
+}
+
+// securedConnKey marks the context of a security handshake with the
+// *net.Conn in which an authentication method that wraps the connection,
+// such as in TLS, stores the connection the session continues on. See
+// AuthHandshake.
+type securedConnKey struct{}
+
+// setSecuredConn makes the handshake of the connection continue on conn.
+func setSecuredConn(ctx context.Context, conn net.Conn) {
+	if slot, ok := ctx.Value(securedConnKey{}).(*net.Conn); ok {
+		*slot = conn
+	}
+}
+
+// VeNCryptAuth implements the VeNCrypt security type (19), which wraps the
+// connection in TLS and authenticates inside it. QEMU and libvirt consoles
+// configured with TLS require it, and TigerVNC offers it by default.
+//
+// The server offers sub-types combining a transport with an authentication
+// scheme, and the client picks the first of SubTypes the server offers and
+// the configuration supports. The X509 sub-types verify the server
+// certificate with TLSConfig, CACertificates, ServerName, and PinnedSHA256,
+// and ConnectionState reports it after the handshake. The TLS sub-types use
+// anonymous TLS, which crypto/tls does not implement, so they are only used
+// with AnonymousTLS. The rest of the session runs over the encrypted connection.
+type VeNCryptAuth struct {
+	// Username and Password are the credentials of the Plain sub-types.
+	// Password is also the password of the Vnc sub-types.
+	Username string
+	Password string
+
+	// Plain, if set, authenticates the Plain sub-types in place of Username
+	// and Password.
+	Plain *PlainAuth
+
+	// Credentials, if set, supplies the username and password at handshake
+	// time in place of Username and Password.
+	Credentials CredentialProvider
+
+	// SubTypes lists the accepted sub-types, such as rfb.VeNCryptX509Vnc, in
+	// order of preference. Empty accepts every sub-type but the unencrypted
+	// rfb.VeNCryptPlain, preferring X509 to anonymous TLS and Plain to VNC
+	// authentication to none.
+	SubTypes []uint32
+
+	// TLSConfig configures TLS for the X509 sub-types. Without a ServerName
+	// the certificate is verified for the host of the server address.
+	TLSConfig *tls.Config
+
+	// CACertificates holds PEM-encoded CA certificates that verify the
+	// server certificate in place of the roots of TLSConfig, such as the CA
+	// of a libvirt deployment.
+	CACertificates []byte
+
+	// ServerName overrides the host name the server certificate is verified
+	// for, such as when connecting through a tunnel or by IP address.
+	ServerName string
+
+	// PinnedSHA256 lists the SHA-256 fingerprints of the accepted server
+	// certificates, in hex with or without colons. A server certificate
+	// matching a pin is accepted without chain verification, which suits
+	// self-signed certificates; any other certificate is rejected.
+	PinnedSHA256 []string
+
+	// ClientCertificate and ClientKey hold the PEM-encoded certificate chain
+	// and private key the client presents to servers requiring mutual TLS in
+	// the X509 sub-types, such as libvirt consoles with vnc_tls_x509_verify
+	// enabled. GetClientCertificate, if set, selects the certificate when the
+	// server requests one instead, such as from a hardware token. Either
+	// replaces the client certificates of TLSConfig.
+	ClientCertificate    []byte
+	ClientKey            []byte
+	GetClientCertificate func(*tls.CertificateRequestInfo) (*tls.Certificate, error)
+
+	// AnonymousTLS wraps conn in anonymous TLS for the TLS sub-types, such as
+	// with a binding to a TLS library supporting anonymous Diffie-Hellman
+	// cipher suites. Without it the TLS sub-types are not used.
+	AnonymousTLS func(ctx context.Context, conn net.Conn) (net.Conn, error)
+
+	logger Logger
+	state  *tls.ConnectionState
+}
+
+// SecurityType returns the security type identifier for VeNCrypt.
+func (v *VeNCryptAuth) SecurityType() uint8 {
+	return rfb.SecurityVeNCrypt
+}
+
+// String returns a human-readable description of the authentication method.
+func (v *VeNCryptAuth) String() string {
+	return "VeNCrypt"
+}
+
+// SetLogger sets the logger for the authentication method.
+func (v *VeNCryptAuth) SetLogger(logger Logger) {
+	v.logger = logger
+}
+
+// ClearPassword securely clears the passwords from memory.
+func (v *VeNCryptAuth) ClearPassword() {
+	if v.Password != "" {
+		v.Password = (&SecureMemory{}).ClearString(v.Password)
+	}
+	if v.Plain != nil {
+		v.Plain.ClearPassword()
+	}
+}
+
+// ConnectionState returns the state of the TLS connection established by the
+// last handshake, including the server certificates, and whether one was
+// established.
+func (v *VeNCryptAuth) ConnectionState() (tls.ConnectionState, bool) {
+	if v.state == nil {
+		return tls.ConnectionState{}, false
+	}
+	return *v.state, true
+}
+
+// Handshake negotiates the VeNCrypt version and sub-type, establishes TLS,
+// and authenticates over it.
+func (v *VeNCryptAuth) Handshake(ctx context.Context, conn net.Conn) error {
+	select {
+	case <-ctx.Done():
+		return timeoutError("VeNCryptAuth.Handshake", "authentication cancelled", ctx.Err())
+	default:
+	}
+
+	logger := v.logger
+	if logger == nil {
+		logger = &NoOpLogger{}
+	}
+	v.state = nil
+
+	fields, err := readFields(conn, "VeNCrypt version", make([]byte, 2))
+	if err != nil {
+		return networkError("VeNCryptAuth.Handshake", "failed to read VeNCrypt version", err)
+	}
+	major, minor := fields.uint8(), fields.uint8()
+	if major != 0 || minor < 2 {
+		_, _ = conn.Write([]byte{0, 0})
+		return unsupportedError("VeNCryptAuth.Handshake",
+			fmt.Sprintf("unsupported VeNCrypt version %d.%d", major, minor), nil)
+	}
+	if _, err := conn.Write([]byte{0, 2}); err != nil {
+		return networkError("VeNCryptAuth.Handshake", "failed to send VeNCrypt version", err)
+	}
+
+	fields, err = readFields(conn, "VeNCrypt version status", make([]byte, 2))
+	if err != nil {
+		return networkError("VeNCryptAuth.Handshake", "failed to read VeNCrypt sub-types", err)
+	}
+	if fields.uint8() != 0 {
+		return authenticationError("VeNCryptAuth.Handshake", "server rejected VeNCrypt version 0.2", nil)
+	}
+	count := int(fields.uint8())
+	if count == 0 {
+		return authenticationError("VeNCryptAuth.Handshake", "server offered no VeNCrypt sub-types", nil)
+	}
+	fields, err = readFields(conn, "VeNCrypt sub-types", make([]byte, 4*count))
+	if err != nil {
+		return networkError("VeNCryptAuth.Handshake", "failed to read VeNCrypt sub-types", err)
+	}
+	offered := make([]uint32, count)
+	for i := range offered {
+		offered[i] = fields.uint32()
+	}
+
+	level := minSecurity(ctx)
+	subType, ok := v.chooseSubType(offered, level)
+	if !ok {
+		if level > AllowNone {
+			return authenticationError("VeNCryptAuth.Handshake",
+				fmt.Sprintf("no VeNCrypt sub-type meeting the minimum security level %s found. server supported: %v", level, offered), nil)
+		}
+		return unsupportedError("VeNCryptAuth.Handshake",
+			fmt.Sprintf("no suitable VeNCrypt sub-type found. server supported: %v", offered), nil)
+	}
+	logger.Debug("Selected VeNCrypt sub-type",
+		Field{Key: "offered", Value: offered},
+		Field{Key: "sub_type", Value: subType})
+
+	if err := binary.Write(conn, binary.BigEndian, subType); err != nil {
+		return networkError("VeNCryptAuth.Handshake", "failed to send VeNCrypt sub-type", err)
+	}
+
+	if subType != rfb.VeNCryptPlain {
+		ack, err := readFields(conn, "VeNCrypt sub-type status", make([]byte, 1))
+		if err != nil {
+			return networkError("VeNCryptAuth.Handshake", "failed to read VeNCrypt sub-type status", err)
+		}
+		if ack.uint8() != 1 {
+			return authenticationError("VeNCryptAuth.Handshake", "server failed to initialize TLS", nil)
+		}
+
+		conn, err = v.secure(ctx, conn, subType)
+		if err != nil {
+			return err
+		}
+		setSecuredConn(ctx, conn)
+		logger.Debug("TLS established", Field{Key: "sub_type", Value: subType})
+	}
+
+	switch subType {
+	case rfb.VeNCryptTLSVnc, rfb.VeNCryptX509Vnc:
+		inner := &PasswordAuth{Password: v.Password, Credentials: v.Credentials, secureMemory: &SecureMemory{}}
+		inner.SetLogger(logger)
+		return inner.Handshake(ctx, conn)
+	case rfb.VeNCryptPlain, rfb.VeNCryptTLSPlain, rfb.VeNCryptX509Plain:
+		plain := v.Plain
+		if plain == nil {
+			plain = &PlainAuth{Username: v.Username, Password: v.Password, Credentials: v.Credentials}
+		}
+		plain.SetLogger(logger)
+		return plain.Handshake(ctx, conn)
+	}
+	return nil
+}
+
+// chooseSubType returns the first accepted sub-type that the server offers,
+// the configuration supports, and meets level.
+func (v *VeNCryptAuth) chooseSubType(offered []uint32, level SecurityLevel) (uint32, bool) {
+	accepted := v.SubTypes
+	if len(accepted) == 0 {
+		accepted = defaultVeNCryptSubTypes
+	}
+	for _, subType := range accepted {
+		if !slices.Contains(offered, subType) || veNCryptSubTypeLevel(subType, v.hasClientCertificate()) < level {
+			continue
+		}
+		switch subType {
+		case rfb.VeNCryptTLSNone, rfb.VeNCryptTLSVnc, rfb.VeNCryptTLSPlain:
+			if v.AnonymousTLS == nil {
+				continue
+			}
+		case rfb.VeNCryptPlain, rfb.VeNCryptX509None, rfb.VeNCryptX509Vnc, rfb.VeNCryptX509Plain:
+		default:
+			continue
+		}
+		return subType, true
+	}
+	return 0, false
+}
+
+// hasClientCertificate reports whether the client presents a certificate in
+// the X509 sub-types.
+func (v *VeNCryptAuth) hasClientCertificate() bool {
+	if len(v.ClientCertificate) > 0 || v.GetClientCertificate != nil {
+		return true
+	}
+	return v.TLSConfig != nil && (len(v.TLSConfig.Certificates) > 0 || v.TLSConfig.GetClientCertificate != nil)
+}
+
+// secure performs the TLS handshake of subType on conn and returns the
+// encrypted connection.
+func (v *VeNCryptAuth) secure(ctx context.Context, conn net.Conn, subType uint32) (net.Conn, error) {
+	switch subType {
+	case rfb.VeNCryptTLSNone, rfb.VeNCryptTLSVnc, rfb.VeNCryptTLSPlain:
+		secured, err := v.AnonymousTLS(ctx, conn)
+		if err != nil {
+			return nil, authenticationError("VeNCryptAuth.Handshake", "anonymous TLS handshake failed", err)
+		}
+		return secured, nil
+	}
+
+	cfg, err := tlsClientConfig(v.TLSConfig, tlsVerification{
+		caCertificates: v.CACertificates,
+		serverName:     v.ServerName,
+		pinnedSHA256:   v.PinnedSHA256,
+
+		clientCertificate:    v.ClientCertificate,
+		clientKey:            v.ClientKey,
+		getClientCertificate: v.GetClientCertificate,
+	}, conn)
+	if err != nil {
+		return nil, err
+	}
+
+	secured := tls.Client(conn, cfg)
+	if err := secured.HandshakeContext(ctx); err != nil {
+		return nil, authenticationError("VeNCryptAuth.Handshake", "TLS handshake failed", err)
+	}
+	state := secured.ConnectionState()
+	v.state = &state
+	return secured, nil
+}
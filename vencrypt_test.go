@@ -0,0 +1,347 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package vnc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tenthirtyam/go-vnc/rfb"
+)
+
+// serveVeNCryptNegotiation plays the server side of the VeNCrypt version and
+// sub-type negotiation and returns the sub-type the client chose.
+func serveVeNCryptNegotiation(conn net.Conn, offered ...uint32) (uint32, error) {
+	if _, err := conn.Write([]byte{0, 2}); err != nil {
+		return 0, err
+	}
+	version := make([]byte, 2)
+	if _, err := io.ReadFull(conn, version); err != nil {
+		return 0, err
+	}
+	msg := []byte{0, byte(len(offered))}
+	for _, subType := range offered {
+		msg = binary.BigEndian.AppendUint32(msg, subType)
+	}
+	if _, err := conn.Write(msg); err != nil {
+		return 0, err
+	}
+	var chosen uint32
+	err := binary.Read(conn, binary.BigEndian, &chosen)
+	return chosen, err
+}
+
+// readPlainCredentials reads the username and password of a Plain sub-type.
+func readPlainCredentials(conn net.Conn) (string, string, error) {
+	var lengths [2]uint32
+	if err := binary.Read(conn, binary.BigEndian, &lengths); err != nil {
+		return "", "", err
+	}
+	buf := make([]byte, lengths[0]+lengths[1])
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return "", "", err
+	}
+	return string(buf[:lengths[0]]), string(buf[lengths[0]:]), nil
+}
+
+// selfSignedCertificate returns a certificate for localhost and a pool
+// trusting it.
+func selfSignedCertificate(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(leaf)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, pool
+}
+
+func TestVeNCryptAuth_X509Plain(t *testing.T) {
+	cert, pool := selfSignedCertificate(t)
+	server, client := net.Pipe()
+	defer func() { _ = client.Close() }()
+
+	type result struct {
+		chosen             uint32
+		username, password string
+		err                error
+	}
+	done := make(chan result, 1)
+	go func() {
+		defer func() { _ = server.Close() }()
+		var r result
+		defer func() { done <- r }()
+		if r.chosen, r.err = serveVeNCryptNegotiation(server, rfb.VeNCryptPlain, rfb.VeNCryptX509Plain); r.err != nil {
+			return
+		}
+		if _, r.err = server.Write([]byte{1}); r.err != nil {
+			return
+		}
+		secured := tls.Server(server, &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12})
+		if r.err = secured.Handshake(); r.err != nil {
+			return
+		}
+		r.username, r.password, r.err = readPlainCredentials(secured)
+	}()
+
+	auth := &VeNCryptAuth{
+		Username:  "admin",
+		Password:  "secret",
+		TLSConfig: &tls.Config{RootCAs: pool, ServerName: "localhost", MinVersion: tls.VersionTLS12},
+	}
+	var secured net.Conn
+	ctx := context.WithValue(context.Background(), securedConnKey{}, &secured)
+	if err := auth.Handshake(ctx, client); err != nil {
+		t.Fatalf("Handshake() error = %v", err)
+	}
+	if _, ok := secured.(*tls.Conn); !ok {
+		t.Errorf("secured connection = %T, want *tls.Conn", secured)
+	}
+
+	r := <-done
+	if r.err != nil {
+		t.Fatalf("server error = %v", r.err)
+	}
+	if r.chosen != rfb.VeNCryptX509Plain {
+		t.Errorf("chosen sub-type = %d, want %d", r.chosen, rfb.VeNCryptX509Plain)
+	}
+	if r.username != "admin" || r.password != "secret" {
+		t.Errorf("credentials = %q/%q, want admin/secret", r.username, r.password)
+	}
+}
+
+func TestVeNCryptAuth_UntrustedCertificate(t *testing.T) {
+	cert, _ := selfSignedCertificate(t)
+	server, client := net.Pipe()
+	defer func() { _ = client.Close() }()
+
+	go serveX509None(server, cert)
+
+	auth := &VeNCryptAuth{TLSConfig: &tls.Config{ServerName: "localhost", MinVersion: tls.VersionTLS12}}
+	err := auth.Handshake(context.Background(), client)
+	if !IsVNCError(err, ErrAuthentication) {
+		t.Fatalf("Handshake() error = %v, want authentication error", err)
+	}
+}
+
+func TestVeNCryptAuth_Plain(t *testing.T) {
+	server, client := net.Pipe()
+	defer func() { _ = client.Close() }()
+
+	done := make(chan error, 1)
+	go func() {
+		defer func() { _ = server.Close() }()
+		if _, err := serveVeNCryptNegotiation(server, rfb.VeNCryptPlain); err != nil {
+			done <- err
+			return
+		}
+		username, password, err := readPlainCredentials(server)
+		if err == nil && (username != "user" || password != "pass") {
+			err = errors.New("unexpected credentials " + username + "/" + password)
+		}
+		done <- err
+	}()
+
+	auth := &VeNCryptAuth{Username: "user", Password: "pass", SubTypes: []uint32{rfb.VeNCryptPlain}}
+	if err := auth.Handshake(context.Background(), client); err != nil {
+		t.Fatalf("Handshake() error = %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("server error = %v", err)
+	}
+}
+
+func TestVeNCryptAuth_ChooseSubType(t *testing.T) {
+	anonymous := func(ctx context.Context, conn net.Conn) (net.Conn, error) { return conn, nil }
+	tests := []struct {
+		name    string
+		auth    *VeNCryptAuth
+		offered []uint32
+		want    uint32
+		wantOK  bool
+	}{
+		{"prefers X509", &VeNCryptAuth{AnonymousTLS: anonymous},
+			[]uint32{rfb.VeNCryptTLSVnc, rfb.VeNCryptX509None}, rfb.VeNCryptX509None, true},
+		{"skips anonymous TLS without support", &VeNCryptAuth{},
+			[]uint32{rfb.VeNCryptTLSVnc}, 0, false},
+		{"uses anonymous TLS with support", &VeNCryptAuth{AnonymousTLS: anonymous},
+			[]uint32{rfb.VeNCryptTLSVnc}, rfb.VeNCryptTLSVnc, true},
+		{"never defaults to Plain", &VeNCryptAuth{},
+			[]uint32{rfb.VeNCryptPlain}, 0, false},
+		{"follows SubTypes", &VeNCryptAuth{SubTypes: []uint32{rfb.VeNCryptX509Vnc, rfb.VeNCryptX509Plain}},
+			[]uint32{rfb.VeNCryptX509Plain, rfb.VeNCryptX509Vnc}, rfb.VeNCryptX509Vnc, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := tt.auth.chooseSubType(tt.offered, AllowNone)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("chooseSubType() = %d, %v, want %d, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+// serveX509None plays a server offering only the X509None sub-type with cert.
+func serveX509None(server net.Conn, cert tls.Certificate) {
+	defer func() { _ = server.Close() }()
+	if _, err := serveVeNCryptNegotiation(server, rfb.VeNCryptX509None); err != nil {
+		return
+	}
+	if _, err := server.Write([]byte{1}); err != nil {
+		return
+	}
+	_ = tls.Server(server, &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}).Handshake()
+}
+
+func TestVeNCryptAuth_Verification(t *testing.T) {
+	cert, _ := selfSignedCertificate(t)
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Leaf.Raw})
+	sum := sha256.Sum256(cert.Leaf.Raw)
+
+	tests := []struct {
+		name    string
+		auth    *VeNCryptAuth
+		wantErr bool
+	}{
+		{"CA bundle", &VeNCryptAuth{CACertificates: caPEM, ServerName: "localhost"}, false},
+		{"CA bundle with wrong host name", &VeNCryptAuth{CACertificates: caPEM, ServerName: "example.com"}, true},
+		{"pin", &VeNCryptAuth{PinnedSHA256: []string{formatFingerprint(sum[:])}}, false},
+		{"lowercase pin", &VeNCryptAuth{PinnedSHA256: []string{hex.EncodeToString(sum[:])}}, false},
+		{"mismatched pin", &VeNCryptAuth{PinnedSHA256: []string{strings.Repeat("00", sha256.Size)}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, client := net.Pipe()
+			defer func() { _ = client.Close() }()
+			go serveX509None(server, cert)
+
+			err := tt.auth.Handshake(context.Background(), client)
+			state, ok := tt.auth.ConnectionState()
+			if tt.wantErr {
+				if !IsVNCError(err, ErrAuthentication) {
+					t.Errorf("Handshake() error = %v, want authentication error", err)
+				}
+				if ok {
+					t.Error("ConnectionState() reported a failed handshake")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Handshake() error = %v", err)
+			}
+			if !ok || len(state.PeerCertificates) == 0 || !state.PeerCertificates[0].Equal(cert.Leaf) {
+				t.Error("ConnectionState() does not report the server certificate")
+			}
+		})
+	}
+}
+
+func TestVeNCryptAuth_ClientCertificate(t *testing.T) {
+	serverCert, _ := selfSignedCertificate(t)
+	clientCert, _ := selfSignedCertificate(t)
+	key, err := x509.MarshalPKCS8PrivateKey(clientCert.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: clientCert.Leaf.Raw})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: key})
+	pin := sha256.Sum256(serverCert.Leaf.Raw)
+
+	tests := []struct {
+		name string
+		auth *VeNCryptAuth
+	}{
+		{"PEM certificate and key", &VeNCryptAuth{ClientCertificate: certPEM, ClientKey: keyPEM}},
+		{"callback", &VeNCryptAuth{GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return &clientCert, nil
+		}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, client := net.Pipe()
+			defer func() { _ = client.Close() }()
+
+			done := make(chan *x509.Certificate, 1)
+			go func() {
+				defer func() { _ = server.Close() }()
+				defer close(done)
+				if _, err := serveVeNCryptNegotiation(server, rfb.VeNCryptX509None); err != nil {
+					return
+				}
+				if _, err := server.Write([]byte{1}); err != nil {
+					return
+				}
+				conn := tls.Server(server, &tls.Config{
+					Certificates: []tls.Certificate{serverCert},
+					ClientAuth:   tls.RequireAnyClientCert,
+					MinVersion:   tls.VersionTLS12,
+				})
+				if conn.Handshake() == nil {
+					done <- conn.ConnectionState().PeerCertificates[0]
+				}
+			}()
+
+			tt.auth.PinnedSHA256 = []string{hex.EncodeToString(pin[:])}
+			if err := tt.auth.Handshake(context.Background(), client); err != nil {
+				t.Fatalf("Handshake() error = %v", err)
+			}
+			if got := <-done; got == nil || !got.Equal(clientCert.Leaf) {
+				t.Error("server did not receive the client certificate")
+			}
+		})
+	}
+}
+
+func TestVeNCryptAuth_InvalidConfiguration(t *testing.T) {
+	cert, _ := selfSignedCertificate(t)
+	for name, auth := range map[string]*VeNCryptAuth{
+		"CA bundle":          {CACertificates: []byte("not PEM")},
+		"pin":                {PinnedSHA256: []string{"AB:CD"}},
+		"client certificate": {ClientCertificate: []byte("not PEM")},
+	} {
+		t.Run(name, func(t *testing.T) {
+			server, client := net.Pipe()
+			defer func() { _ = client.Close() }()
+			go serveX509None(server, cert)
+
+			if err := auth.Handshake(context.Background(), client); !IsVNCError(err, ErrConfiguration) {
+				t.Errorf("Handshake() error = %v, want configuration error", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,172 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package vnc
+
+import (
+	"image"
+	"math"
+)
+
+// Rotation is a clockwise rotation applied by a viewer when drawing the framebuffer.
+type Rotation int
+
+// Supported viewer rotations.
+const (
+	Rotate0 Rotation = iota
+	Rotate90
+	Rotate180
+	Rotate270
+)
+
+// Viewport maps between viewer coordinates and framebuffer coordinates for a
+// framebuffer that is rotated and then scaled into a rectangle of a widget.
+//
+// All conversions treat pixels as unit squares and map through their
+// continuous coordinates, so a framebuffer pixel converted to viewer space and
+// back always yields the same pixel regardless of the scale factor.
+//
+// Example usage:
+//
+//	width, height := client.GetFrameBufferSize()
+//	view := vnc.FitViewport(int(width), int(height), widget.Bounds(), vnc.Rotate0)
+//
+//	// On mouse input from the widget
+//	if x, y, ok := view.ToFramebuffer(ev.X, ev.Y); ok {
+//		client.PointerEvent(mask, x, y)
+//	}
+type Viewport struct {
+	// FramebufferWidth and FramebufferHeight are the framebuffer dimensions in pixels.
+	FramebufferWidth  int
+	FramebufferHeight int
+
+	// Bounds is the rectangle, in viewer coordinates, that the rotated
+	// framebuffer is drawn into. It may have a different aspect ratio than
+	// the framebuffer, in which case the image is stretched.
+	Bounds image.Rectangle
+
+	// Rotation is the clockwise rotation applied before scaling.
+	Rotation Rotation
+}
+
+// FitViewport returns a Viewport that scales the rotated framebuffer to the
+// largest size fitting within bounds while preserving its aspect ratio,
+// centered with letterbox or pillarbox margins as needed.
+func FitViewport(fbWidth, fbHeight int, bounds image.Rectangle, rotation Rotation) Viewport {
+	v := Viewport{FramebufferWidth: fbWidth, FramebufferHeight: fbHeight, Bounds: bounds, Rotation: rotation}
+
+	rw, rh := v.rotatedSize()
+	if rw <= 0 || rh <= 0 || bounds.Empty() {
+		return v
+	}
+
+	scale := math.Min(float64(bounds.Dx())/float64(rw), float64(bounds.Dy())/float64(rh))
+	w := int(math.Round(float64(rw) * scale))
+	h := int(math.Round(float64(rh) * scale))
+	x := bounds.Min.X + (bounds.Dx()-w)/2
+	y := bounds.Min.Y + (bounds.Dy()-h)/2
+	v.Bounds = image.Rect(x, y, x+w, y+h)
+
+	return v
+}
+
+// Scale returns the horizontal and vertical scale factors from rotated
+// framebuffer pixels to viewer pixels, or zero for an empty viewport.
+func (v Viewport) Scale() (sx, sy float64) {
+	rw, rh := v.rotatedSize()
+	if rw <= 0 || rh <= 0 {
+		return 0, 0
+	}
+	return float64(v.Bounds.Dx()) / float64(rw), float64(v.Bounds.Dy()) / float64(rh)
+}
+
+// ToFramebuffer converts a viewer position to the framebuffer pixel beneath it.
+// The result is clamped to the framebuffer, and ok reports whether the position
+// lies within Bounds. Positions are continuous, so (10.0, 10.0) and
+// (10.9, 10.9) fall in the same viewer pixel.
+func (v Viewport) ToFramebuffer(vx, vy float64) (x, y uint16, ok bool) {
+	rw, rh := v.rotatedSize()
+	if rw <= 0 || rh <= 0 || v.Bounds.Empty() {
+		return 0, 0, false
+	}
+
+	ok = vx >= float64(v.Bounds.Min.X) && vx < float64(v.Bounds.Max.X) &&
+		vy >= float64(v.Bounds.Min.Y) && vy < float64(v.Bounds.Max.Y)
+
+	// Viewer space to rotated framebuffer space.
+	u := (vx - float64(v.Bounds.Min.X)) * float64(rw) / float64(v.Bounds.Dx())
+	w := (vy - float64(v.Bounds.Min.Y)) * float64(rh) / float64(v.Bounds.Dy())
+
+	// Undo the clockwise rotation.
+	fw, fh := float64(v.FramebufferWidth), float64(v.FramebufferHeight)
+	var fx, fy float64
+	switch v.normalizedRotation() {
+	case Rotate90:
+		fx, fy = w, fh-u
+	case Rotate180:
+		fx, fy = fw-u, fh-w
+	case Rotate270:
+		fx, fy = fw-w, u
+	default:
+		fx, fy = u, w
+	}
+
+	return clampPixel(fx, v.FramebufferWidth), clampPixel(fy, v.FramebufferHeight), ok
+}
+
+// ToViewer converts a framebuffer pixel to the viewer position of its center.
+func (v Viewport) ToViewer(x, y int) (vx, vy float64) {
+	rw, rh := v.rotatedSize()
+	if rw <= 0 || rh <= 0 {
+		return float64(v.Bounds.Min.X), float64(v.Bounds.Min.Y)
+	}
+
+	fx, fy := float64(x)+0.5, float64(y)+0.5
+	fw, fh := float64(v.FramebufferWidth), float64(v.FramebufferHeight)
+
+	var u, w float64
+	switch v.normalizedRotation() {
+	case Rotate90:
+		u, w = fh-fy, fx
+	case Rotate180:
+		u, w = fw-fx, fh-fy
+	case Rotate270:
+		u, w = fy, fw-fx
+	default:
+		u, w = fx, fy
+	}
+
+	vx = float64(v.Bounds.Min.X) + u*float64(v.Bounds.Dx())/float64(rw)
+	vy = float64(v.Bounds.Min.Y) + w*float64(v.Bounds.Dy())/float64(rh)
+	return vx, vy
+}
+
+// normalizedRotation maps the rotation into the range Rotate0 to Rotate270.
+func (v Viewport) normalizedRotation() Rotation {
+	return ((v.Rotation % 4) + 4) % 4
+}
+
+// rotatedSize returns the framebuffer dimensions after rotation.
+func (v Viewport) rotatedSize() (width, height int) {
+	switch v.normalizedRotation() {
+	case Rotate90, Rotate270:
+		return v.FramebufferHeight, v.FramebufferWidth
+	default:
+		return v.FramebufferWidth, v.FramebufferHeight
+	}
+}
+
+// clampPixel converts a continuous coordinate to a pixel index in [0, size-1].
+func clampPixel(c float64, size int) uint16 {
+	p := int(math.Floor(c))
+	if p < 0 {
+		p = 0
+	}
+	if p > size-1 {
+		p = size - 1
+	}
+	if p > math.MaxUint16 {
+		p = math.MaxUint16
+	}
+	return uint16(p) // #nosec G115 - clamped to the uint16 range above
+}
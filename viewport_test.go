@@ -0,0 +1,98 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+package vnc
+
+import (
+	"image"
+	"testing"
+)
+
+func TestViewport_RoundTrip(t *testing.T) {
+	bounds := []image.Rectangle{
+		image.Rect(0, 0, 64, 48),
+		image.Rect(10, 20, 10+97, 20+131),
+		image.Rect(0, 0, 1000, 700),
+	}
+
+	for _, rotation := range []Rotation{Rotate0, Rotate90, Rotate180, Rotate270} {
+		for _, b := range bounds {
+			v := Viewport{FramebufferWidth: 64, FramebufferHeight: 48, Bounds: b, Rotation: rotation}
+			for y := 0; y < 48; y++ {
+				for x := 0; x < 64; x++ {
+					vx, vy := v.ToViewer(x, y)
+					gx, gy, ok := v.ToFramebuffer(vx, vy)
+					if !ok || int(gx) != x || int(gy) != y {
+						t.Fatalf("rotation %d, bounds %v: (%d,%d) -> (%.2f,%.2f) -> (%d,%d, %v)",
+							rotation, b, x, y, vx, vy, gx, gy, ok)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestViewport_ToFramebuffer(t *testing.T) {
+	tests := []struct {
+		name     string
+		view     Viewport
+		vx, vy   float64
+		x, y     uint16
+		inBounds bool
+	}{
+		{
+			name: "Half scale last pixel",
+			view: Viewport{FramebufferWidth: 100, FramebufferHeight: 100, Bounds: image.Rect(0, 0, 50, 50)},
+			vx:   49.9, vy: 49.9, x: 99, y: 99, inBounds: true,
+		},
+		{
+			name: "Double scale pixel edge",
+			view: Viewport{FramebufferWidth: 10, FramebufferHeight: 10, Bounds: image.Rect(0, 0, 20, 20)},
+			vx:   3.99, vy: 4, x: 1, y: 2, inBounds: true,
+		},
+		{
+			name: "Rotate 90 top left",
+			view: Viewport{FramebufferWidth: 4, FramebufferHeight: 2, Bounds: image.Rect(0, 0, 2, 4), Rotation: Rotate90},
+			vx:   0.5, vy: 0.5, x: 0, y: 1, inBounds: true,
+		},
+		{
+			name: "Rotate 270 top left",
+			view: Viewport{FramebufferWidth: 4, FramebufferHeight: 2, Bounds: image.Rect(0, 0, 2, 4), Rotation: Rotate270},
+			vx:   0.5, vy: 0.5, x: 3, y: 0, inBounds: true,
+		},
+		{
+			name: "Outside is clamped",
+			view: Viewport{FramebufferWidth: 10, FramebufferHeight: 10, Bounds: image.Rect(5, 5, 15, 15)},
+			vx:   -3, vy: 20, x: 0, y: 9, inBounds: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			x, y, ok := tt.view.ToFramebuffer(tt.vx, tt.vy)
+			if x != tt.x || y != tt.y || ok != tt.inBounds {
+				t.Errorf("ToFramebuffer(%v, %v) = (%d, %d, %v), want (%d, %d, %v)",
+					tt.vx, tt.vy, x, y, ok, tt.x, tt.y, tt.inBounds)
+			}
+		})
+	}
+}
+
+func TestViewport_Fit(t *testing.T) {
+	v := FitViewport(1920, 1080, image.Rect(0, 0, 800, 800), Rotate0)
+	if v.Bounds != image.Rect(0, 175, 800, 625) {
+		t.Errorf("letterboxed bounds = %v", v.Bounds)
+	}
+
+	v = FitViewport(1920, 1080, image.Rect(0, 0, 800, 800), Rotate90)
+	if v.Bounds != image.Rect(175, 0, 625, 800) {
+		t.Errorf("rotated bounds = %v", v.Bounds)
+	}
+	if sx, sy := v.Scale(); sx != sy {
+		t.Errorf("Scale() = %v, %v; aspect ratio not preserved", sx, sy)
+	}
+
+	if _, _, ok := FitViewport(0, 0, image.Rect(0, 0, 10, 10), Rotate0).ToFramebuffer(1, 1); ok {
+		t.Error("empty framebuffer should not map")
+	}
+}
@@ -0,0 +1,58 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: Ryan Johnson
+
+//go:build !vnc_minimal
+
+package vnc
+
+import (
+	"bytes"
+	"compress/zlib"
+	"io"
+)
+
+// zlibStream is a zlib stream that spans the rectangles of a session, as used
+// by the Tight, ZRLE, and Zlib encodings. The server flushes the stream at the end of
+// every rectangle, so decoding a rectangle never needs data from the next one.
+type zlibStream struct {
+	input  bytes.Buffer
+	reader io.ReadCloser
+}
+
+// reset discards the stream state.
+func (s *zlibStream) reset() {
+	s.input.Reset()
+	s.reader = nil
+}
+
+// feed appends the compressed data of a rectangle to the stream and returns a
+// reader of the uncompressed data.
+func (s *zlibStream) feed(compressed []byte) (io.Reader, error) {
+	s.input.Write(compressed)
+	if s.reader == nil {
+		reader, err := zlib.NewReader(&s.input)
+		if err != nil {
+			s.reset()
+			return nil, err
+		}
+		s.reader = reader
+	}
+	return s.reader, nil
+}
+
+// inflate appends compressed to the stream and returns the next size bytes of
+// uncompressed data.
+func (s *zlibStream) inflate(compressed []byte, size int) ([]byte, error) {
+	r, err := s.feed(compressed)
+	if err != nil {
+		return nil, err
+	}
+
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		// The stream cannot recover from a decoding error.
+		s.reset()
+		return nil, err
+	}
+	return data, nil
+}